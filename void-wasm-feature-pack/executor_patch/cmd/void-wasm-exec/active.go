@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// --- Active runs registry (cancel/inspect/drain) ---
+type activeRun struct {
+	rec    *RunRecord
+	cancel context.CancelFunc
+}
+
+type ActiveRunInfo struct {
+	ID        string    `json:"id"`
+	Module    string    `json:"module"`
+	Started   time.Time `json:"started"`
+	ElapsedMs int64     `json:"elapsed_ms"`
+}
+
+var (
+	activeMu   sync.Mutex
+	activeRuns = map[string]*activeRun{}
+)
+
+func trackRun(rec *RunRecord, cancel context.CancelFunc) func() {
+	activeMu.Lock(); activeRuns[rec.ID] = &activeRun{rec: rec, cancel: cancel}; activeMu.Unlock()
+	return func() { activeMu.Lock(); delete(activeRuns, rec.ID); activeMu.Unlock() }
+}
+
+func cancelRun(id string) bool {
+	activeMu.Lock(); defer activeMu.Unlock()
+	ar, ok := activeRuns[id]
+	if ok { ar.cancel() }
+	return ok
+}
+
+func listActive() []ActiveRunInfo {
+	activeMu.Lock(); defer activeMu.Unlock()
+	out := []ActiveRunInfo{}
+	for _, ar := range activeRuns {
+		out = append(out, ActiveRunInfo{ID: ar.rec.ID, Module: ar.rec.Module, Started: ar.rec.Started, ElapsedMs: time.Since(ar.rec.Started).Milliseconds()})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Started.Before(out[j].Started) })
+	return out
+}
+
+func activeCount() int { activeMu.Lock(); defer activeMu.Unlock(); return len(activeRuns) }
+
+// waitIdle polls until no runs are active or the timeout passes; returns what's left.
+func waitIdle(timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+	for {
+		n := activeCount()
+		if n == 0 || time.Now().After(deadline) { return n }
+		time.Sleep(100 * time.Millisecond)
+	}
+}
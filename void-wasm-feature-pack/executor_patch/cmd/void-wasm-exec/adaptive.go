@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Adaptive concurrency (AIMD) ---
+// With adaptive_concurrency the exec pool still has `concurrency` workers but
+// only `limit` of them may run wasm at once. Every adaptive_every the limit is
+// cut by 30% when p95 run time (execution only, not queueing) exceeds slo_p95
+// or the relay error rate exceeds adaptive_relay_err, and raised by one
+// when p95 is comfortably under target and runs are waiting.
+
+var (
+	concLimitGauge = prometheus.NewGauge(prometheus.GaugeOpts{Name: "void_wasm_concurrency_limit", Help: "Effective exec concurrency"})
+	concAdjust     = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_concurrency_adjustments_total", Help: "Adaptive concurrency changes by direction"}, []string{"direction"})
+)
+
+var (
+	gateMu    sync.Mutex
+	gateCond  = sync.NewCond(&gateMu)
+	gateLimit = 1 << 30 // no gating until startAdaptive
+	gateBusy  int
+
+	runLatMu sync.Mutex
+	runLat   []float64 // run ms since the last tick
+
+	relayOK, relayFail atomic.Int64 // POST attempts, see postWithRetry
+)
+
+// execGate blocks until a run may start and returns the release func.
+func execGate() func() {
+	gateMu.Lock()
+	for gateBusy >= gateLimit { gateCond.Wait() }
+	gateBusy++
+	gateMu.Unlock()
+	return func() { gateMu.Lock(); gateBusy--; gateMu.Unlock(); gateCond.Signal() }
+}
+
+func setGateLimit(n int) {
+	gateMu.Lock(); gateLimit = n; gateMu.Unlock()
+	gateCond.Broadcast()
+	concLimitGauge.Set(float64(n))
+}
+
+func recordRunLatency(ms int64) {
+	runLatMu.Lock(); runLat = append(runLat, float64(ms)); runLatMu.Unlock()
+}
+
+// aimdNext is one controller step.
+func aimdNext(cfg Config, limit int, p95, relayErr float64, waiting bool) int {
+	target := float64(cfg.SLOP95.Milliseconds())
+	switch {
+	case p95 > target || relayErr > cfg.AdaptiveRelayErr:
+		limit = max(cfg.AdaptiveMin, limit*7/10)
+	case p95 < 0.8*target && waiting:
+		limit = min(cfg.Concurrency, limit+1)
+	}
+	return limit
+}
+
+func startAdaptive(cfg Config) {
+	setGateLimit(cfg.Concurrency)
+	if !cfg.AdaptiveConcurrency { return }
+	go func() {
+		limit := cfg.Concurrency
+		for range time.Tick(cfg.AdaptiveEvery) {
+			runLatMu.Lock(); lat := runLat; runLat = nil; runLatMu.Unlock()
+			ok, fail := relayOK.Swap(0), relayFail.Swap(0)
+			if len(lat) < cfg.AdaptiveMinRuns && fail == 0 { continue }
+			var p95, relayErr float64
+			if len(lat) > 0 { sort.Float64s(lat); p95 = lat[(len(lat)*95+99)/100-1] }
+			if ok+fail > 0 { relayErr = float64(fail) / float64(ok+fail) }
+			next := aimdNext(cfg, limit, p95, relayErr, runsQueued.Load() > 0)
+			if next == limit { continue }
+			dir := "up"
+			if next < limit { dir = "down" }
+			concAdjust.WithLabelValues(dir).Inc()
+			fmt.Printf("[adaptive] concurrency %d -> %d (p95=%.0fms relay_err=%.2f runs=%d)\n", limit, next, p95, relayErr, len(lat))
+			limit = next
+			setGateLimit(limit)
+		}
+	}()
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/subtle"
+	"expvar"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/pprof"
+	"net/url"
+	"runtime"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// intakePaused makes sseLoop skip envelopes instead of dispatching them.
+var intakePaused atomic.Bool
+
+var (
+	intakeSkipped = prometheus.NewCounter(prometheus.CounterOpts{Name: "void_wasm_intake_skipped_total", Help: "Envelopes skipped while intake paused"})
+	pausedGauge   = prometheus.NewGauge(prometheus.GaugeOpts{Name: "void_wasm_intake_paused", Help: "1 while intake is paused"})
+)
+
+func setPaused(p bool) {
+	intakePaused.Store(p)
+	if p { pausedGauge.Set(1) } else { pausedGauge.Set(0) }
+}
+
+// --- Admin server (separate port, bearer token) ---
+func adminAuth(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := strings.TrimPrefix(r.Header.Get("authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeJSON(w, 401, map[string]any{"error": "unauthorized"}); return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func startAdmin(cfg Config) {
+	if cfg.AdminAddr == "" { return }
+	if cfg.AdminToken == "" { fmt.Println("[admin] ADMIN_TOKEN not set, admin API disabled"); return }
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /runs", handleRunsList)
+	mux.HandleFunc("GET /runs/{id}", handleRunGet)
+	mux.HandleFunc("GET /runs/{id}/wait", handleRunWait)
+	mux.HandleFunc("GET /stream", handleStream)
+	mux.HandleFunc("GET /admin/active", func(w http.ResponseWriter, r *http.Request) { writeJSON(w, 200, listActive()) })
+	mux.HandleFunc("POST /admin/pause", func(w http.ResponseWriter, r *http.Request) {
+		setPaused(true); fmt.Println("[admin] intake paused")
+		audit(cfg, "admin.pause", nil)
+		writeJSON(w, 200, map[string]any{"paused": true})
+	})
+	mux.HandleFunc("POST /admin/resume", func(w http.ResponseWriter, r *http.Request) {
+		setPaused(false); fmt.Println("[admin] intake resumed")
+		audit(cfg, "admin.resume", nil)
+		writeJSON(w, 200, map[string]any{"paused": false})
+	})
+	mux.HandleFunc("POST /admin/drain", func(w http.ResponseWriter, r *http.Request) {
+		timeout, err := time.ParseDuration(r.URL.Query().Get("timeout"))
+		if err != nil || timeout <= 0 { timeout = 30 * time.Second }
+		setPaused(true); fmt.Println("[admin] draining, timeout", timeout)
+		audit(cfg, "admin.drain", map[string]any{"timeout": timeout.String()})
+		left := waitIdle(timeout)
+		writeJSON(w, 200, map[string]any{"paused": true, "drained": left == 0, "active": left})
+	})
+	mux.HandleFunc("POST /admin/envelopes", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil { writeJSON(w, 400, map[string]any{"error": err.Error()}); return }
+		env, err := decodeEnvelope(r.Header.Get("content-type"), body, currentConfig().StrictEnvelopes)
+		if err != nil {
+			envelopesInvalid.WithLabelValues(reasonOf(err)).Inc()
+			writeJSON(w, 400, map[string]any{"error": err.Error()}); return
+		}
+		if !isLeader.Load() { writeJSON(w, 503, map[string]any{"status": "standby"}); return }
+		setCorrelationHeader(&env, r.Header.Get("x-correlation-id"))
+		status := admitEnvelope(&env)
+		code := 202
+		if status != "queued" { code = 503 }
+		writeJSON(w, code, map[string]any{"status": status})
+	})
+	mux.HandleFunc("POST /admin/runs/{id}/cancel", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if !cancelRun(id) { writeJSON(w, 404, map[string]any{"error": "run not active"}); return }
+		fmt.Println("[admin] cancel run", id)
+		audit(cfg, "admin.cancel", map[string]any{"run": id})
+		writeJSON(w, 200, map[string]any{"canceled": id})
+	})
+	mux.HandleFunc("GET /admin/config", func(w http.ResponseWriter, r *http.Request) { writeJSON(w, 200, redactConfig(currentConfig())) })
+	mux.HandleFunc("POST /admin/reload", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := reloadConfig(); err != nil { writeJSON(w, 400, map[string]any{"error": err.Error()}); return }
+		audit(cfg, "admin.reload", nil)
+		writeJSON(w, 200, map[string]any{"reloaded": true})
+	})
+	mux.HandleFunc("POST /admin/policy/simulate", handleSimulate)
+	mux.HandleFunc("GET /admin/allowlist", handleAllowGet)
+	mux.HandleFunc("PUT /admin/allowlist", handleAllowPut)
+	mux.HandleFunc("DELETE /admin/allowlist", handleAllowDelete)
+	mux.HandleFunc("GET /admin/kv/export", handleKVExport)
+	mux.HandleFunc("POST /admin/kv/import", handleKVImport)
+	handleScratch(mux, cfg)
+	handleHTTPCache(mux, cfg)
+	handleLedger(mux, cfg)
+	if cfg.AdminPprof { mountDebug(mux) }
+	go func() {
+		if err := http.ListenAndServe(cfg.AdminAddr, adminAuth(cfg.AdminToken, mux)); err != nil { fmt.Println("[admin] server error:", err) }
+	}()
+}
+
+// redactConfig is c as GET /admin/config shows it: every secret, and the
+// userinfo of every url, replaced. A new secret field belongs here.
+func redactConfig(c Config) Config {
+	c.AdminToken = "<redacted>"
+	if c.S3SecretKey != "" { c.S3SecretKey = "<redacted>" }
+	if c.S3SessionToken != "" { c.S3SessionToken = "<redacted>" }
+	if c.AttestOCIPassword != "" { c.AttestOCIPassword = "<redacted>" }
+	if c.KVBackupKey != "" { c.KVBackupKey = "<redacted>" }
+	if c.IntentToken != "" { c.IntentToken = "<redacted>" }
+	if c.GitHubSecret != "" { c.GitHubSecret = "<redacted>" }
+	if c.GitHubToken != "" { c.GitHubToken = "<redacted>" }
+	if c.GrafanaToken != "" { c.GrafanaToken = "<redacted>" }
+	if c.CorpusSalt != "" { c.CorpusSalt = "<redacted>" } // with it the pseudonyms are reversible
+	for _, u := range []*string{&c.KVRedisURL, &c.KVNATSURL, &c.NATSURL, &c.LogURL} { *u = redactURL(*u) }
+	c.Webhooks = slices.Clone(c.Webhooks)
+	for i := range c.Webhooks { // chat webhook urls are credentials themselves
+		c.Webhooks[i].URL = "<redacted>"
+		if c.Webhooks[i].Auth != "" { c.Webhooks[i].Auth = "<redacted>" }
+	}
+	c.GuestEnv = maps.Clone(c.GuestEnv)
+	for k, e := range c.GuestEnv { // literal values may be tokens
+		if e.Value != "" { e.Value = "<redacted>"; c.GuestEnv[k] = e }
+	}
+	c.GraphQL = maps.Clone(c.GraphQL)
+	for k, ep := range c.GraphQL {
+		if ep.Auth != "" { ep.Auth = "<redacted>"; c.GraphQL[k] = ep }
+	}
+	c.WS = maps.Clone(c.WS)
+	for k, ep := range c.WS {
+		ep.Headers = maps.Clone(ep.Headers)
+		for h := range ep.Headers { ep.Headers[h] = "<redacted>" }
+		c.WS[k] = ep
+	}
+	c.HostVars = maps.Clone(c.HostVars)
+	for k, v := range c.HostVars {
+		if v.Value != "" { v.Value = "<redacted>"; c.HostVars[k] = v }
+	}
+	return c
+}
+
+// redactURL hides the userinfo (user:password@, nats token@) of a url or a
+// comma-separated server list.
+func redactURL(s string) string {
+	parts := strings.Split(s, ",")
+	for i, p := range parts {
+		if u, err := url.Parse(strings.TrimSpace(p)); err == nil && u.User != nil { u.User = url.User("<redacted>"); parts[i] = u.String() }
+	}
+	return strings.Join(parts, ",")
+}
+
+// mountDebug exposes pprof profiles and expvar runtime stats (behind admin auth).
+func mountDebug(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	expvar.Publish("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+	expvar.Publish("active_runs", expvar.Func(func() any { return activeCount() }))
+	expvar.Publish("intake_paused", expvar.Func(func() any { return intakePaused.Load() }))
+	fmt.Println("[admin] pprof + expvar enabled on /debug/")
+}
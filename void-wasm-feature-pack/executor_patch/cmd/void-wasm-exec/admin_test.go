@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Every string field whose yaml name says it is a secret must come back
+// redacted, so a new one cannot be forgotten.
+func TestRedactConfigSecrets(t *testing.T) {
+	c := defaultConfig()
+	v := reflect.ValueOf(&c).Elem()
+	var secrets []string
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if f.Type.Kind() != reflect.String || !strings.Contains(name, "token") && !strings.Contains(name, "secret") && !strings.Contains(name, "password") && !strings.Contains(name, "salt") && !strings.HasSuffix(name, "backup_key") {
+			continue
+		}
+		if strings.HasSuffix(name, "_header") { continue } // header names, not values
+		v.Field(i).SetString("s3cr3t-" + name)
+		secrets = append(secrets, name)
+	}
+	if len(secrets) < 9 { t.Fatalf("found only %v", secrets) }
+	c.S3SessionToken = "s3cr3t-session" // env only, no yaml name
+	c.NATSURL, c.KVNATSURL = "nats://tok3n@a:4222, nats://u:pw@b:4222", "nats://u:pw@c:4222"
+	c.KVRedisURL, c.LogURL = "redis://:pw@r:6379/0", "https://u:pw@loki:3100/loki/api/v1/push"
+	b, _ := json.Marshal(redactConfig(c))
+	for _, leak := range []string{"s3cr3t-", "tok3n", ":pw@"} {
+		if i := strings.Index(string(b), leak); i >= 0 { t.Errorf("redacted config leaks %q: ...%s...", leak, b[max(0, i-40):min(len(b), i+40)]) }
+	}
+	r := redactConfig(c)
+	if r.NATSURL != "nats://%3Credacted%3E@a:4222,nats://%3Credacted%3E@b:4222" { t.Errorf("nats_url = %s", r.NATSURL) }
+	if c.GitHubToken != "s3cr3t-github_token" { t.Error("redactConfig changed its argument") }
+}
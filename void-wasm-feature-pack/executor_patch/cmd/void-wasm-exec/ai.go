@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Inference (syscall.ai.infer, cap ai) ---
+// Modules that reason over their inputs call a model through the host
+// instead of holding an API key and http access to the provider. Endpoints
+// are declared under ai_endpoints with the wire api (openai chat completions,
+// the default, or anthropic messages), the models a module may ask for (the
+// first is the default) and a token spec (file:, env: or keyring:, as
+// at_rest_key) read at call time, so rotating the secret needs no reload:
+//   syscall.ai.infer {"id","endpoint","model"?,"system"?,"prompt"|"messages",
+//                     "max_tokens"?,"temperature"?}
+//   -> sysret.ai.infer {"id","endpoint","model","ok","status","text","finish",
+//                       "usage":{"in","out"},"cost"?}
+// A prompt (system plus messages, as JSON) over max_prompt_kb
+// (ai_max_prompt_kb) answers ok:false, "error":"prompt_too_large", a
+// response over max_response_kb (ai_max_response_kb) "response_too_large";
+// max_tokens is capped at the endpoint's (ai_max_tokens) and a provider
+// error answers "upstream_error" with its "message". The tokens the
+// provider reports are priced with price_in/price_out (per 1k tokens, any
+// unit) into the run record's ai_tokens and ai_cost, usage.report's
+// ai_tokens, void_wasm_ai_tokens_total{endpoint,kind} and
+// void_wasm_ai_cost_total{tenant,endpoint}; once a run has used
+// ai_max_run_tokens (0 = no cap) further calls answer "quota". Calls share
+// http_rps with http.fetch and the response bytes count as net_kb.
+
+type AIEndpoint struct {
+	URL           string   `yaml:"url"`
+	API           string   `yaml:"api"`    // openai (default) | anthropic
+	Token         string   `yaml:"token"`  // file:<path> | env:<VAR> | keyring:<name>
+	Models        []string `yaml:"models"` // allowed; the first is the default
+	Modules       []string `yaml:"modules"` // empty = any allowlisted module
+	Tenants       []string `yaml:"tenants"` // empty = any tenant
+	MaxPromptKB   int      `yaml:"max_prompt_kb"`   // 0 = ai_max_prompt_kb
+	MaxResponseKB int      `yaml:"max_response_kb"` // 0 = ai_max_response_kb
+	MaxTokens     int      `yaml:"max_tokens"`      // 0 = ai_max_tokens
+	PriceIn       float64  `yaml:"price_in"`  // per 1k prompt tokens
+	PriceOut      float64  `yaml:"price_out"` // per 1k completion tokens
+}
+
+var (
+	aiTotal  = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_ai_total", Help: "syscall.ai.infer requests by endpoint and result"}, []string{"endpoint", "result"})
+	aiTokens = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_ai_tokens_total", Help: "Tokens used through syscall.ai.infer by endpoint and kind (in, out)"}, []string{"endpoint", "kind"})
+	aiCost   = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_ai_cost_total", Help: "Priced syscall.ai.infer usage by tenant and endpoint, in the endpoint's price unit"}, []string{"tenant", "endpoint"})
+)
+
+// aiSyscall handles syscall.ai.infer.
+func aiSyscall(cfg Config, rec *RunRecord, payload map[string]any) (result string) {
+	name, _ := payload["endpoint"].(string)
+	ep, declared := cfg.AI[name]
+	defer func() {
+		if !declared { name = "" }
+		aiTotal.WithLabelValues(name, result).Inc()
+	}()
+	switch {
+	case !allowed("ai", cfg.AllowCaps): return "denied"
+	case !declared: return "bad_endpoint"
+	case len(ep.Modules) > 0 && !allowed(rec.Module, ep.Modules), len(ep.Tenants) > 0 && !allowed(rec.Tenant, ep.Tenants): return "endpoint_denied"
+	}
+	id, _ := payload["id"].(string)
+	model, _ := payload["model"].(string)
+	if model == "" { model = ep.Models[0] }
+	reply := map[string]any{"type": "sysret.ai.infer", "id": id, "endpoint": name, "model": model, "ok": false}
+	fail := func(result string) string { reply["error"] = result; sysret(cfg, rec, reply); return result }
+	if !allowed(model, ep.Models) { return fail("model_denied") }
+	system, _ := payload["system"].(string)
+	msgs, _ := payload["messages"].([]any)
+	if p, _ := payload["prompt"].(string); p != "" { msgs = append(msgs, map[string]any{"role": "user", "content": p}) }
+	if len(msgs) == 0 { return "bad_prompt" }
+	maxP, maxR, maxT := ep.MaxPromptKB, ep.MaxResponseKB, ep.MaxTokens
+	if maxP == 0 { maxP = cfg.AIMaxPromptKB }
+	if maxR == 0 { maxR = cfg.AIMaxResponseKB }
+	if maxT == 0 { maxT = cfg.AIMaxTokens }
+	if p, _ := json.Marshal([]any{system, msgs}); len(p) > maxP<<10 { return fail("prompt_too_large") }
+	if cfg.AIMaxRunTokens > 0 && rec.AITokens >= int64(cfg.AIMaxRunTokens) { return fail("quota") }
+	tokens := maxT
+	if n, ok := payload["max_tokens"].(float64); ok && n >= 1 && int(n) < tokens { tokens = int(n) }
+	body := map[string]any{"model": model, "max_tokens": tokens}
+	if t, ok := payload["temperature"].(float64); ok { body["temperature"] = t }
+	if ep.API == "anthropic" {
+		body["messages"] = msgs
+		if system != "" { body["system"] = system }
+	} else {
+		if system != "" { msgs = append([]any{map[string]any{"role": "system", "content": system}}, msgs...) }
+		body["messages"] = msgs
+	}
+	token, err := keySource(ep.Token)
+	if err != nil { fmt.Println("[ai]", name+": token:", err, rec.corr()); return fail("no_token") }
+	if !httpAllow(cfg) { return "rate_limited" }
+	b, _ := json.Marshal(body)
+	req, _ := http.NewRequest("POST", ep.URL, bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	if tok := strings.TrimSpace(string(token)); ep.API == "anthropic" {
+		req.Header.Set("x-api-key", tok)
+		req.Header.Set("anthropic-version", "2023-06-01")
+	} else {
+		req.Header.Set("Authorization", "Bearer "+tok)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil { fmt.Println("[ai]", name+":", err, rec.corr()); return "io_err" }
+	defer resp.Body.Close()
+	limit := int64(maxR) << 10
+	if left := rec.budget.netLeft(); left >= 0 && left < limit { limit = left + 1 } // one byte over ends the run
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	rec.NetBytes += int64(len(raw))
+	rec.budget.spend(budgetNet, int64(len(raw)))
+	reply["status"] = resp.StatusCode
+	if int64(len(raw)) > limit { return fail("response_too_large") }
+	out, err := aiParse(ep.API, raw)
+	if err != nil { return fail("bad_response") }
+	in, gen := out.in, out.out
+	aiTokens.WithLabelValues(name, "in").Add(float64(in))
+	aiTokens.WithLabelValues(name, "out").Add(float64(gen))
+	rec.AITokens += in + gen
+	reply["usage"] = map[string]any{"in": in, "out": gen}
+	if cost := float64(in)*ep.PriceIn/1000 + float64(gen)*ep.PriceOut/1000; cost > 0 {
+		rec.AICost += cost
+		aiCost.WithLabelValues(rec.Tenant, name).Add(cost)
+		reply["cost"] = cost
+	}
+	if resp.StatusCode >= 300 {
+		if out.err == "" { out.err = http.StatusText(resp.StatusCode) }
+		reply["message"] = out.err
+		return fail("upstream_error")
+	}
+	reply["text"], reply["finish"], reply["ok"] = out.text, out.finish, true
+	sysret(cfg, rec, reply)
+	return "ok"
+}
+
+type aiResult struct {
+	text, finish, err string
+	in, out           int64
+}
+
+// aiParse reads an openai chat completion or an anthropic message.
+func aiParse(api string, raw []byte) (aiResult, error) {
+	var r aiResult
+	var e struct {
+		Error struct{ Message string `json:"message"` } `json:"error"`
+	}
+	json.Unmarshal(raw, &e)
+	r.err = e.Error.Message
+	if api == "anthropic" {
+		var m struct {
+			Content []struct{ Type, Text string } `json:"content"`
+			Stop    string                        `json:"stop_reason"`
+			Usage   struct{ In int64 `json:"input_tokens"`; Out int64 `json:"output_tokens"` } `json:"usage"`
+		}
+		if err := json.Unmarshal(raw, &m); err != nil { return r, err }
+		for _, c := range m.Content {
+			if c.Type == "text" { r.text += c.Text }
+		}
+		r.finish, r.in, r.out = m.Stop, m.Usage.In, m.Usage.Out
+		return r, nil
+	}
+	var m struct {
+		Choices []struct {
+			Message struct{ Content string `json:"content"` } `json:"message"`
+			Finish  string                                    `json:"finish_reason"`
+		} `json:"choices"`
+		Usage struct{ In int64 `json:"prompt_tokens"`; Out int64 `json:"completion_tokens"` } `json:"usage"`
+	}
+	if err := json.Unmarshal(raw, &m); err != nil { return r, err }
+	if len(m.Choices) > 0 { r.text, r.finish = m.Choices[0].Message.Content, m.Choices[0].Finish }
+	r.in, r.out = m.Usage.In, m.Usage.Out
+	return r, nil
+}
+
+func validAI(c Config) error {
+	if c.AIMaxPromptKB < 1 || c.AIMaxResponseKB < 1 || c.AIMaxTokens < 1 || c.AIMaxRunTokens < 0 { return fmt.Errorf("ai_max_prompt_kb, ai_max_response_kb, ai_max_tokens: must be >= 1, ai_max_run_tokens >= 0") }
+	for name, ep := range c.AI {
+		u, err := url.Parse(ep.URL)
+		if !tenantName.MatchString(name) || err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" { return fmt.Errorf("ai_endpoints[%s]: name [a-z0-9_-] and an http(s) url required", name) }
+		if ep.API != "" && ep.API != "openai" && ep.API != "anthropic" { return fmt.Errorf("ai_endpoints[%s]: api must be openai or anthropic, got %q", name, ep.API) }
+		if len(ep.Models) == 0 { return fmt.Errorf("ai_endpoints[%s]: models: at least one required", name) }
+		if kind, _, _ := strings.Cut(ep.Token, ":"); kind != "file" && kind != "env" && kind != "keyring" { return fmt.Errorf("ai_endpoints[%s]: token must be file:, env: or keyring:", name) }
+		if ep.MaxPromptKB < 0 || ep.MaxResponseKB < 0 || ep.MaxTokens < 0 || ep.PriceIn < 0 || ep.PriceOut < 0 { return fmt.Errorf("ai_endpoints[%s]: limits and prices must be >= 0", name) }
+	}
+	return nil
+}
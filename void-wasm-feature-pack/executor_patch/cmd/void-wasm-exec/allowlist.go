@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Runtime allowlist updates ---
+// allow_modules, allow_caps and allow_http_hosts can be replaced while the
+// executor runs, so a rollout does not restart every edge node:
+//   relay control event on the SSE stream, DSSE-signed (like attestations)
+//   by one of control_keys (ed25519 public key PEM files):
+//     {"type":"control.allowlist","dsse":{"payloadType":
+//      "application/vnd.void.allowlist+json","payload":"<base64>","signatures":[...]}}
+//   with the payload
+//     {"version":7,"expires":"<RFC3339, optional>","nodes":["edge-*"],
+//      "allow_modules":[...],"allow_caps":[...],"allow_http_hosts":[...]}
+//   PUT /admin/allowlist with the same lists (no signature, admin token)
+//   DELETE /admin/allowlist drops the override, back to the config
+//   GET /admin/allowlist shows the effective lists and where they came from
+// A list left out stays as it is; one given (even empty) replaces it. Relay
+// updates must carry a version above the last one applied and, with "nodes",
+// name this node (trailing-* prefixes); stale, expired, unsigned or
+// mis-signed events are dropped. Without control_keys control events are
+// ignored. Every change is audited (control.allowlist / admin.allowlist).
+// The override is kept in <cache_dir>/allowlist.json, so it survives restarts
+// and SIGHUP reloads; tenant and policies.d overlays still apply on top.
+
+const allowPayloadType = "application/vnd.void.allowlist+json"
+
+var allowUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_allowlist_updates_total", Help: "Runtime allowlist updates by source and result"}, []string{"source", "result"})
+
+// allowLists is an override; nil fields are not overridden.
+type allowLists struct {
+	Modules   *[]string `json:"allow_modules,omitempty"`
+	Caps      *[]string `json:"allow_caps,omitempty"`
+	HTTPHosts *[]string `json:"allow_http_hosts,omitempty"`
+}
+
+// allowState is what allowlist.json holds.
+type allowState struct {
+	allowLists
+	Source  string    `json:"source"`  // relay | admin
+	Version int64     `json:"version"` // last relay version applied
+	Updated time.Time `json:"updated"`
+}
+
+var (
+	allowMu       sync.Mutex
+	allowOverride *allowState
+)
+
+func allowFile(cfg Config) string { return filepath.Join(cfg.CacheDir, "allowlist.json") }
+
+// apply puts the overridden lists into c.
+func (a *allowLists) apply(c *Config) {
+	if a.Modules != nil { c.AllowModules = *a.Modules }
+	if a.Caps != nil { c.AllowCaps = *a.Caps }
+	if a.HTTPHosts != nil { c.AllowHTTPHosts = *a.HTTPHosts }
+}
+
+// merge overlays the lists next sets.
+func (a *allowLists) merge(next allowLists) {
+	if next.Modules != nil { a.Modules = next.Modules }
+	if next.Caps != nil { a.Caps = next.Caps }
+	if next.HTTPHosts != nil { a.HTTPHosts = next.HTTPHosts }
+}
+
+// applyAllowOverride puts the runtime override into c (startup, reloadConfig).
+func applyAllowOverride(c *Config) {
+	allowMu.Lock(); defer allowMu.Unlock()
+	if allowOverride != nil { allowOverride.apply(c) }
+}
+
+// loadAllowOverride restores the override kept from before a restart.
+func loadAllowOverride(cfg Config) {
+	b, err := os.ReadFile(allowFile(cfg))
+	if errors.Is(err, os.ErrNotExist) { return }
+	var st allowState
+	if err == nil { err = json.Unmarshal(b, &st) }
+	if err != nil { fmt.Println("[allowlist] ignoring", allowFile(cfg)+":", err); return }
+	allowMu.Lock()
+	allowOverride = &st
+	allowMu.Unlock()
+	fmt.Println("[allowlist] runtime override from", st.Source, "version", st.Version, "updated", st.Updated.Format(time.RFC3339))
+}
+
+// setAllowlist applies lists (nil = drop the override) and returns the new
+// effective config.
+func setAllowlist(source string, version int64, lists *allowLists) Config {
+	reloadMu.Lock(); defer reloadMu.Unlock()
+	c := currentConfig()
+	allowMu.Lock()
+	if lists == nil {
+		// keep the relay version so older control events stay stale
+		if allowOverride != nil && allowOverride.Version > 0 { allowOverride = &allowState{Source: source, Version: allowOverride.Version, Updated: time.Now().UTC()} } else { allowOverride = nil }
+	} else {
+		var st allowState
+		if allowOverride != nil { st = *allowOverride }
+		st.merge(*lists)
+		st.Source, st.Updated = source, time.Now().UTC()
+		if source == "relay" { st.Version = version }
+		allowOverride = &st
+	}
+	var raw []byte
+	if allowOverride != nil { raw, _ = json.MarshalIndent(allowOverride, "", "  ") }
+	allowMu.Unlock()
+
+	// the config file's lists are the base the override sits on
+	if base, err := loadConfig(configPath); err == nil { c.AllowModules, c.AllowCaps, c.AllowHTTPHosts = base.AllowModules, base.AllowCaps, base.AllowHTTPHosts }
+	applyAllowOverride(&c)
+	var err error
+	if raw == nil { err = os.Remove(allowFile(c)); if errors.Is(err, os.ErrNotExist) { err = nil } } else { os.MkdirAll(c.CacheDir, 0o755); err = writeFileAtomic(allowFile(c), raw, 0o600) }
+	if err != nil { fmt.Println("[allowlist] not persisted:", err) }
+	liveCfg.Store(&c)
+	return c
+}
+
+func allowFields(c Config) map[string]any {
+	return map[string]any{"allow_modules": c.AllowModules, "allow_caps": c.AllowCaps, "allow_http_hosts": c.AllowHTTPHosts}
+}
+
+// controlEvent handles an SSE payload if it is a control event; false means
+// it is something else.
+func controlEvent(payload string) bool {
+	if !strings.Contains(payload, `"control.`) { return false }
+	var ev struct {
+		Type string       `json:"type"`
+		DSSE dsseEnvelope `json:"dsse"`
+	}
+	if json.Unmarshal([]byte(payload), &ev) != nil || !strings.HasPrefix(ev.Type, "control.") { return false }
+	if ev.Type != "control.allowlist" { return true }
+	cfg := currentConfig()
+	reject := func(result string, msg ...any) bool {
+		allowUpdates.WithLabelValues("relay", result).Inc()
+		fmt.Println(append([]any{"[allowlist] control event rejected:"}, msg...)...)
+		return true
+	}
+	if len(cfg.ControlKeys) == 0 { allowUpdates.WithLabelValues("relay", "no_key").Inc(); return true }
+	if ev.DSSE.PayloadType != allowPayloadType { return reject("invalid", "payloadType", ev.DSSE.PayloadType) }
+	raw, err := base64.StdEncoding.DecodeString(ev.DSSE.Payload)
+	if err != nil { return reject("invalid", "payload:", err) }
+	if !controlSigned(cfg, ev.DSSE, raw) { return reject("bad_sig", "no valid signature from control_keys") }
+	var upd struct {
+		allowLists
+		Version int64     `json:"version"`
+		Expires time.Time `json:"expires"`
+		Nodes   []string  `json:"nodes"`
+	}
+	if err := json.Unmarshal(raw, &upd); err != nil { return reject("invalid", err) }
+	if len(upd.Nodes) > 0 && !allowed(nodeID(cfg), upd.Nodes) { allowUpdates.WithLabelValues("relay", "not_for_node").Inc(); return true }
+	if !upd.Expires.IsZero() && time.Now().After(upd.Expires) { return reject("expired", "version", upd.Version, "expired", upd.Expires.Format(time.RFC3339)) }
+	allowMu.Lock()
+	var last int64
+	if allowOverride != nil { last = allowOverride.Version }
+	allowMu.Unlock()
+	if upd.Version <= last { return reject("stale", "version", upd.Version, "<= applied", last) }
+	c := setAllowlist("relay", upd.Version, &upd.allowLists)
+	allowUpdates.WithLabelValues("relay", "applied").Inc()
+	fmt.Println("[allowlist] applied relay version", upd.Version)
+	audit(c, "control.allowlist", map[string]any{"version": upd.Version, "allow": allowFields(c)})
+	return true
+}
+
+// controlSigned reports whether any signature verifies under control_keys.
+func controlSigned(cfg Config, env dsseEnvelope, payload []byte) bool {
+	return ed25519Signed(cfg.ControlKeys, env, payload)
+}
+
+// ed25519Signed reports whether any signature verifies under one of the
+// ed25519 public key PEMs at keys.
+func ed25519Signed(keys []string, env dsseEnvelope, payload []byte) bool {
+	msg := dssePAE(env.PayloadType, payload)
+	for _, path := range keys {
+		kb, err := os.ReadFile(path)
+		if err != nil { fmt.Println("[dsse] key:", err); continue }
+		blk, _ := pem.Decode(kb)
+		if blk == nil { continue }
+		k, err := x509.ParsePKIXPublicKey(blk.Bytes)
+		pub, ok := k.(ed25519.PublicKey)
+		if err != nil || !ok { continue }
+		for _, s := range env.Signatures {
+			sig, err := base64.StdEncoding.DecodeString(s.Sig)
+			if err == nil && ed25519.Verify(pub, msg, sig) { return true }
+		}
+	}
+	return false
+}
+
+func handleAllowGet(w http.ResponseWriter, r *http.Request) {
+	out := allowFields(currentConfig())
+	allowMu.Lock()
+	if allowOverride != nil { out["override"] = allowOverride }
+	allowMu.Unlock()
+	writeJSON(w, 200, out)
+}
+
+func handleAllowPut(w http.ResponseWriter, r *http.Request) {
+	var lists allowLists
+	dec := json.NewDecoder(io.LimitReader(r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&lists); err != nil { allowUpdates.WithLabelValues("admin", "invalid").Inc(); writeJSON(w, 400, map[string]any{"error": err.Error()}); return }
+	if lists == (allowLists{}) { writeJSON(w, 400, map[string]any{"error": "set allow_modules, allow_caps and/or allow_http_hosts"}); return }
+	c := setAllowlist("admin", 0, &lists)
+	allowUpdates.WithLabelValues("admin", "applied").Inc()
+	fmt.Println("[allowlist] updated via admin API")
+	audit(c, "admin.allowlist", map[string]any{"allow": allowFields(c)})
+	writeJSON(w, 200, allowFields(c))
+}
+
+func handleAllowDelete(w http.ResponseWriter, r *http.Request) {
+	c := setAllowlist("admin", 0, nil)
+	allowUpdates.WithLabelValues("admin", "cleared").Inc()
+	fmt.Println("[allowlist] override cleared via admin API")
+	audit(c, "admin.allowlist.clear", map[string]any{"allow": allowFields(c)})
+	writeJSON(w, 200, allowFields(c))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Run artifacts (/out) ---
+// Every run gets an empty /out; files the module leaves there (and, for older
+// modules, in /tmp/out) are collected after it exits, up to artifactMaxFiles
+// and artifact_max_mb per run, hashed and handed to the sinks:
+// artifacts_url relay POSTs each file to <relay_base><artifact_post>,
+// s3://bucket/prefix stores it content-addressed (s3.go), and ipfs_publish
+// adds it to IPFS (ipfs.go). Whatever was stored is listed in the run record
+// and the receipt; names are paths relative to the directory.
+
+var (
+	artifactsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_artifacts_total", Help: "Artifact uploads by result"}, []string{"result"})
+	artifactBytes  = prometheus.NewCounter(prometheus.CounterOpts{Name: "void_wasm_artifact_bytes_total", Help: "Artifact bytes uploaded"})
+)
+
+type Artifact struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	URL    string `json:"url,omitempty"`
+	CID    string `json:"cid,omitempty"`
+}
+
+const artifactMaxFiles = 32
+
+func artifactsOn(cfg Config) bool { return cfg.ArtifactsURL != "" || cfg.IPFSPublish != "" }
+
+// publishArtifacts stores every regular file under dirs with the configured
+// sinks.
+func publishArtifacts(cfg Config, rec *RunRecord, dirs ...string) {
+	bucket, prefix, _ := parseS3(cfg.ArtifactsURL)
+	budget := int64(cfg.ArtifactMaxMB) << 20
+	for _, dir := range dirs {
+		filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() { return nil } // the module wrote nothing, a dir, or a link
+			if len(rec.Artifacts) >= artifactMaxFiles { artifactsTotal.WithLabelValues("skipped").Inc(); return nil }
+			data, err := os.ReadFile(p)
+			if err != nil || int64(len(data)) > budget { artifactsTotal.WithLabelValues("skipped").Inc(); return nil }
+			budget -= int64(len(data))
+			name, _ := filepath.Rel(dir, p)
+			sum := sha256.Sum256(data)
+			a := Artifact{Name: filepath.ToSlash(name), SHA256: hex.EncodeToString(sum[:]), Size: int64(len(data))}
+			switch {
+			case cfg.ArtifactsURL == "relay":
+				u, err := postArtifact(cfg, rec, a, data)
+				if err != nil { artifactsTotal.WithLabelValues("error").Inc(); fmt.Println("[artifacts] post", a.Name, "failed:", err, rec.corr()); rec.decide("artifact=" + a.Name + ":error") }
+				a.URL = u
+			case bucket != "":
+				key := strings.TrimPrefix(strings.TrimSuffix(prefix, "/")+"/"+a.SHA256, "/")
+				if err := uploadArtifact(cfg, rec, bucket, key, a.Name, data); err != nil {
+					artifactsTotal.WithLabelValues("error").Inc()
+					fmt.Println("[artifacts] upload", a.Name, "failed:", err, rec.corr())
+					rec.decide("artifact=" + a.Name + ":error")
+				} else {
+					a.URL = "s3://" + bucket + "/" + key
+				}
+			}
+			if cfg.IPFSPublish != "" {
+				cid, err := ipfsAdd(cfg, "artifact", a.Name, data)
+				if err != nil { fmt.Println("[ipfs] add", a.Name, "failed:", err, rec.corr()); rec.decide("artifact=" + a.Name + ":ipfs_error") }
+				a.CID = cid
+			}
+			if a.URL == "" && a.CID == "" { return nil }
+			artifactsTotal.WithLabelValues("ok").Inc()
+			artifactBytes.Add(float64(len(data)))
+			rec.Artifacts = append(rec.Artifacts, a)
+			return nil
+		})
+	}
+}
+
+// postArtifact sends one file to the relay; the relay may answer
+// {"url": ...}, otherwise the artifact is addressed as <post url>/<sha256>.
+func postArtifact(cfg Config, rec *RunRecord, a Artifact, data []byte) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.FetchTimeout)
+	defer cancel()
+	u := cfg.RelayBase + cfg.ArtifactPost
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(data))
+	if err != nil { return "", err }
+	req.Header.Set("content-type", "application/octet-stream")
+	req.Header.Set("x-void-run", rec.ID)
+	req.Header.Set("x-void-module", rec.Module)
+	req.Header.Set("x-void-name", a.Name)
+	req.Header.Set("x-void-sha256", a.SHA256)
+	resp, err := relayClient.Do(req)
+	if err != nil { return "", err }
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode/100 != 2 { return "", fmt.Errorf("status %d: %s", resp.StatusCode, bytes.TrimSpace(body)) }
+	var out struct{ URL string `json:"url"` }
+	if json.Unmarshal(body, &out) == nil && out.URL != "" { return out.URL, nil }
+	return u + "/" + a.SHA256, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// --- Encryption at rest ---
+// at_rest_key names an AES-256 key that seals KV contents (every backend:
+// the whole file, or each bbolt/redis value) and cached modules, manifest
+// and all, with AES-256-GCM before they touch disk or Redis. The key is
+// never in the config itself:
+//   file:/etc/void/at-rest.key    32 raw bytes, or hex / base64 text
+//   env:VOID_AT_REST_KEY          hex / base64
+//   keyring:void-at-rest          Linux user/session keyring ("user" key):
+//                                 keyctl padd user void-at-rest @u < key
+// Sealed data starts with "VOIDENC1" and is bound (GCM additional data) to
+// what it is: module:<cache file>, input:<sha256>, kv:<tenant> for a whole
+// file KV and kv:<tenant>/<key> for each bbolt, redis or NATS value, so a
+// blob cannot be swapped for another module, input, tenant or key. Blobs
+// sealed before that binding (module, input, kv:<tenant>) still open and are
+// bound on their next write. Plain data written before the key was set still
+// reads and is sealed on its next write; a
+// `kv export | kv import -` round trip re-seals the whole KV, clearing
+// cache_dir re-seals modules. Sealed data without the key is an error,
+// never read as empty. The key is loaded at startup only.
+
+const atRestMagic = "VOIDENC1"
+
+var atRest cipher.AEAD // nil = off
+
+// keySource reads the key material a file:, env: or keyring: spec names.
+func keySource(spec string) (raw []byte, err error) {
+	kind, name, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "file":
+		raw, err = os.ReadFile(name)
+	case "env":
+		raw = []byte(os.Getenv(name))
+		if len(raw) == 0 { err = fmt.Errorf("$%s is empty", name) }
+	case "keyring":
+		raw, err = keyringKey(name)
+	default:
+		return nil, fmt.Errorf("want file:, env: or keyring:, got %q", spec)
+	}
+	return raw, err
+}
+
+func loadAtRestKey(spec string) (cipher.AEAD, error) {
+	raw, err := keySource(spec)
+	if err != nil { return nil, err }
+	k, err := parseAtRestKey(raw)
+	if err != nil { return nil, fmt.Errorf("%s: %w", spec, err) }
+	blk, _ := aes.NewCipher(k)
+	return cipher.NewGCM(blk)
+}
+
+func parseAtRestKey(raw []byte) ([]byte, error) {
+	if len(raw) == 32 { return raw, nil }
+	s := strings.TrimSpace(string(raw))
+	if k, err := hex.DecodeString(s); err == nil && len(k) == 32 { return k, nil }
+	if k, err := base64.StdEncoding.DecodeString(s); err == nil && len(k) == 32 { return k, nil }
+	return nil, errors.New("want 32 bytes, raw, hex or base64")
+}
+
+// atRestLegacy maps what to the additional data blobs of that kind were
+// sealed under before they were bound to their name or key.
+func atRestLegacy(what string) string {
+	kind, name, _ := strings.Cut(what, ":")
+	switch kind {
+	case "kv":
+		tenant, _, _ := strings.Cut(name, "/")
+		return "kv:" + tenant
+	case "module", "input":
+		return kind
+	}
+	return what
+}
+
+// moduleAAD binds a cached module to its cache file name (sha256 or module).
+func moduleAAD(path string) string { return "module:" + filepath.Base(path) }
+
+// sealAtRest encrypts b for what (aad); a no-op without at_rest_key.
+func sealAtRest(b []byte, what string) []byte {
+	if atRest == nil { return b }
+	nonce := make([]byte, atRest.NonceSize())
+	rand.Read(nonce)
+	out := append([]byte(atRestMagic), nonce...)
+	return atRest.Seal(out, nonce, b, []byte(what))
+}
+
+// openAtRest decrypts sealed b and passes plain b through.
+func openAtRest(b []byte, what string) ([]byte, error) {
+	rest, sealed := bytes.CutPrefix(b, []byte(atRestMagic))
+	if !sealed { return b, nil }
+	if atRest == nil { return nil, fmt.Errorf("%s: sealed at rest and at_rest_key not set", what) }
+	if len(rest) < atRest.NonceSize() { return nil, fmt.Errorf("%s: sealed data truncated", what) }
+	nonce, ct := rest[:atRest.NonceSize()], rest[atRest.NonceSize():]
+	out, err := atRest.Open(nil, nonce, ct, []byte(what))
+	if legacy := atRestLegacy(what); err != nil && legacy != what { out, err = atRest.Open(nil, nonce, ct, []byte(legacy)) }
+	if err != nil { return nil, fmt.Errorf("%s: wrong at_rest_key, corrupted or sealed for something else", what) }
+	return out, nil
+}
+
+// readModule reads a module file, cached (maybe sealed) or local, and
+// decrypts an encrypted module blob (modenc.go) in memory.
+func readModule(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil { return nil, err }
+	if b, err = openAtRest(b, moduleAAD(path)); err != nil { return nil, err }
+	return openModule(b)
+}
@@ -0,0 +1,24 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// keyringKey reads a "user" key from the session, then the user keyring.
+func keyringKey(name string) ([]byte, error) {
+	var id int
+	var err error
+	for _, ring := range []int{unix.KEY_SPEC_SESSION_KEYRING, unix.KEY_SPEC_USER_KEYRING} {
+		if id, err = unix.KeyctlSearch(ring, "user", name, 0); err == nil { break }
+	}
+	if err != nil { return nil, fmt.Errorf("keyring %q: %w", name, err) }
+	buf := make([]byte, 512)
+	n, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, buf, 0)
+	if err != nil { return nil, fmt.Errorf("keyring %q: %w", name, err) }
+	if n > len(buf) { return nil, fmt.Errorf("keyring %q: key too large", name) }
+	return buf[:n], nil
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func keyringKey(string) ([]byte, error) { return nil, errors.New("keyring: Linux only") }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func sha256Of(s string) string { sum := sha256.Sum256([]byte(s)); return hex.EncodeToString(sum[:]) }
+
+func TestAtRestBinding(t *testing.T) {
+	blk, _ := aes.NewCipher(bytes.Repeat([]byte{7}, 32))
+	gcm, _ := cipher.NewGCM(blk)
+	atRest = gcm
+	defer func() { atRest = nil }()
+
+	open := func(b []byte, what string) bool { _, err := openAtRest(b, what); return err == nil }
+	a, b := "/var/cache/void/"+sha256Of("a")+".wasm", "/var/cache/void/"+sha256Of("b")+".wasm"
+	mod := sealAtRest([]byte("\x00asm"), moduleAAD(a))
+	if !bytes.HasPrefix(mod, []byte(atRestMagic)) { t.Fatal("not sealed") }
+	if !open(mod, moduleAAD(a)) { t.Error("module does not open under its own name") }
+	if open(mod, moduleAAD(b)) { t.Error("module opens as another module") }
+	if open(mod, "input:"+sha256Of("a")) { t.Error("module opens as an input") }
+
+	kv := sealAtRest([]byte(`"v"`), "kv:acme/token")
+	if !open(kv, "kv:acme/token") { t.Error("kv value does not open under its key") }
+	for _, what := range []string{"kv:acme/other", "kv:evil/token", "kv:acme"} {
+		if open(kv, what) { t.Errorf("kv value opens as %s", what) }
+	}
+
+	// sealed before the per-name binding: still opens, within the same kind and tenant only
+	old := sealAtRest([]byte(`"v"`), "kv:acme")
+	if !open(old, "kv:acme/token") { t.Error("legacy kv value does not open") }
+	if open(old, "kv:evil/token") { t.Error("legacy kv value opens for another tenant") }
+	if old := sealAtRest([]byte("\x00asm"), "module"); !open(old, moduleAAD(a)) || open(old, "input:"+sha256Of("a")) { t.Error("legacy module blob") }
+	if q := sealAtRest([]byte("{}"), "queue"); open(q, "queue:acme") { t.Error("queue has no legacy fallback") }
+
+	if plain := []byte("plain"); !open(plain, "kv:acme/token") { t.Error("plain data does not pass through") }
+	if open(mod[:len(atRestMagic)+4], moduleAAD(a)) { t.Error("truncated blob opens") }
+}
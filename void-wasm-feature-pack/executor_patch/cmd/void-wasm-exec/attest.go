@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- in-toto run attestations ---
+// With attest set every finished run gets an in-toto v1 Statement: subjects
+// are the module (sha256 of the wasm) and its inputs (sha256 of the
+// envelope's inputs as JSON); the predicate records result, durations,
+// executor identity and policy decisions. It is signed as a DSSE envelope
+// with the node's ed25519 key (attest_key, generated on first start; public
+// half at /attest/pubkey) and its digest lands in the run record.
+// attest: relay posts it as a wasm.attestation event; oci://registry/repo
+// pushes it as an OCI artifact tagged with the run ID.
+
+const (
+	attestPayloadType   = "application/vnd.in-toto+json"
+	attestPredicateType = "https://github.com/s0fractal/void/attestations/run/v1"
+	dsseMediaType       = "application/vnd.dsse.envelope.v1+json"
+)
+
+var attestationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_attestations_total", Help: "Run attestations by sink and result"}, []string{"sink", "result"})
+
+var (
+	attestKey   ed25519.PrivateKey
+	attestKeyID string
+)
+
+func attestKeyPath(cfg Config) string {
+	if cfg.AttestKey != "" { return cfg.AttestKey }
+	return filepath.Join(cfg.CacheDir, "attest.key")
+}
+
+// loadAttestKey reads the PKCS#8 PEM signing key, generating it if missing.
+func loadAttestKey(cfg Config) error {
+	priv, err := loadEd25519Key(attestKeyPath(cfg))
+	if err != nil { return err }
+	pub, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	sum := sha256.Sum256(pub)
+	attestKey, attestKeyID = priv, hex.EncodeToString(sum[:])
+	fmt.Println("[attest] signing key", attestKeyID)
+	return nil
+}
+
+func handleAttestKey(w http.ResponseWriter, r *http.Request) {
+	if attestKey == nil { http.NotFound(w, r); return }
+	pub, _ := x509.MarshalPKIXPublicKey(attestKey.Public())
+	w.Header().Set("content-type", "application/x-pem-file")
+	w.Write(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}))
+}
+
+type intotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type intotoStatement struct {
+	Type          string          `json:"_type"`
+	Subject       []intotoSubject `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     any             `json:"predicate"`
+}
+
+type dsseSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+type dsseEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []dsseSignature `json:"signatures"`
+}
+
+// dssePAE is the DSSE v1 pre-authentication encoding that gets signed.
+func dssePAE(payloadType string, payload []byte) []byte {
+	return fmt.Appendf(nil, "DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload)
+}
+
+// moduleDigest is the declared sha256, else the hash of the cached module.
+func moduleDigest(rec *RunRecord, modPath string) string {
+	if rec.SHA256 != "" { return strings.ToLower(rec.SHA256) }
+	if modPath == "" { return "" }
+	data, err := readModule(modPath)
+	if err != nil { return "" }
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func runStatement(cfg Config, rec *RunRecord, modPath string) intotoStatement {
+	var subjects []intotoSubject
+	if d := moduleDigest(rec, modPath); d != "" { subjects = append(subjects, intotoSubject{rec.Module, map[string]string{"sha256": d}}) }
+	inputs := map[string]any{}
+	if rec.Envelope != nil && rec.Envelope.Inputs != nil { inputs = rec.Envelope.Inputs }
+	b, _ := json.Marshal(inputs) // map keys are sorted, so the hash is stable
+	sum := sha256.Sum256(b)
+	subjects = append(subjects, intotoSubject{"inputs", map[string]string{"sha256": hex.EncodeToString(sum[:])}})
+	pred := map[string]any{
+		"run": rec.ID, "module": rec.Module, "tenant": rec.Tenant, "path": rec.Path,
+		"result": rec.Result, "error": rec.Error, "started": rec.Started.UTC().Format(time.RFC3339Nano),
+		"duration_ms": map[string]int64{"fetch": rec.FetchMs, "run": rec.RunMs, "total": rec.TotalMs},
+		"executor":    map[string]string{"id": nodeID(cfg), "version": version, "commit": commit, "engine": engineVersion()},
+		"decisions":   rec.Decisions,
+	}
+	if len(rec.Artifacts) > 0 { pred["byproducts"] = rec.Artifacts }
+	return intotoStatement{Type: "https://in-toto.io/Statement/v1", Subject: subjects, PredicateType: attestPredicateType, Predicate: pred}
+}
+
+// attestRun signs rec's statement, stores the envelope digest in rec and
+// publishes in the background.
+func attestRun(cfg Config, rec *RunRecord, modPath string) {
+	sink := "relay"
+	if strings.HasPrefix(cfg.Attest, "oci://") { sink = "oci" }
+	if attestKey == nil { attestationsTotal.WithLabelValues(sink, "error").Inc(); return }
+	payload, err := json.Marshal(runStatement(cfg, rec, modPath))
+	if err != nil { attestationsTotal.WithLabelValues(sink, "error").Inc(); return }
+	sig := ed25519.Sign(attestKey, dssePAE(attestPayloadType, payload))
+	env, _ := json.Marshal(dsseEnvelope{attestPayloadType, base64.StdEncoding.EncodeToString(payload), []dsseSignature{{attestKeyID, base64.StdEncoding.EncodeToString(sig)}}})
+	sum := sha256.Sum256(env)
+	rec.Attestation = "sha256:" + hex.EncodeToString(sum[:])
+	if sink == "relay" {
+		postRunEvent(cfg, rec, map[string]any{"type": "wasm.attestation", "meta": map[string]any{"run": rec.ID, "module": rec.Module, "digest": rec.Attestation, "dsse": json.RawMessage(env)}})
+		attestationsTotal.WithLabelValues(sink, "ok").Inc()
+		return
+	}
+	go func() {
+		if err := ociPushAttestation(cfg, rec, env); err != nil {
+			attestationsTotal.WithLabelValues(sink, "error").Inc()
+			fmt.Println("[attest] push", rec.ID, "failed:", err, rec.corr())
+			return
+		}
+		attestationsTotal.WithLabelValues(sink, "ok").Inc()
+	}()
+}
+
+// attestVerifyLocal: void-wasm-exec attest-verify --key pub.pem [--module m.wasm] file
+// checks the signature of a DSSE envelope (bare or inside a wasm.attestation
+// event) and, with --module, that the module is one of its subjects.
+func attestVerifyLocal(args []string) int {
+	fs := flag.NewFlagSet("attest-verify", flag.ExitOnError)
+	keyPath := fs.String("key", "", "PEM public key (GET /attest/pubkey)")
+	module := fs.String("module", "", "wasm file that must match the module subject")
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, "usage: void-wasm-exec attest-verify --key pub.pem [--module m.wasm] attestation.json"); fs.PrintDefaults() }
+	fs.Parse(args)
+	if fs.NArg() != 1 || *keyPath == "" { fs.Usage(); return 2 }
+	fail := func(msg ...any) int { fmt.Fprintln(os.Stderr, append([]any{"attest-verify:"}, msg...)...); return 1 }
+	raw, err := os.ReadFile(fs.Arg(0))
+	if err != nil { return fail(err) }
+	var ev struct{ Meta struct{ DSSE json.RawMessage `json:"dsse"` } `json:"meta"` }
+	if json.Unmarshal(raw, &ev) == nil && ev.Meta.DSSE != nil { raw = ev.Meta.DSSE }
+	var env dsseEnvelope
+	if err := json.Unmarshal(raw, &env); err != nil { return fail(err) }
+	kb, err := os.ReadFile(*keyPath)
+	if err != nil { return fail(err) }
+	blk, _ := pem.Decode(kb)
+	if blk == nil { return fail("no PEM block in", *keyPath) }
+	k, err := x509.ParsePKIXPublicKey(blk.Bytes)
+	pub, ok := k.(ed25519.PublicKey)
+	if err != nil || !ok { return fail("not an ed25519 public key") }
+	payload, err := base64.StdEncoding.DecodeString(env.Payload)
+	if err != nil { return fail("payload:", err) }
+	verified := false
+	for _, s := range env.Signatures {
+		sig, err := base64.StdEncoding.DecodeString(s.Sig)
+		if err == nil && ed25519.Verify(pub, dssePAE(env.PayloadType, payload), sig) { verified = true; break }
+	}
+	if !verified { return fail("signature does not verify") }
+	var st intotoStatement
+	if err := json.Unmarshal(payload, &st); err != nil { return fail("statement:", err) }
+	for _, sub := range st.Subject { fmt.Printf("subject  %s sha256:%s\n", sub.Name, sub.Digest["sha256"]) }
+	if pred, ok := st.Predicate.(map[string]any); ok { fmt.Printf("run      %v module=%v result=%v\n", pred["run"], pred["module"], pred["result"]) }
+	if *module != "" {
+		data, err := os.ReadFile(*module)
+		if err != nil { return fail(err) }
+		sum := sha256.Sum256(data)
+		if !slices.ContainsFunc(st.Subject, func(s intotoSubject) bool { return s.Digest["sha256"] == hex.EncodeToString(sum[:]) }) { return fail("module", *module, "is not a subject") }
+	}
+	fmt.Println("OK")
+	return 0
+}
+
+// --- OCI distribution push ---
+// Blobs go through POST+PUT uploads, then a manifest with artifactType
+// application/vnd.dsse.envelope.v1+json is PUT under the run ID. Registries
+// answering 401 with a Bearer challenge get a token from their realm
+// (attest_oci_user/password as basic auth); localhost is plain HTTP.
+
+const ociEmptyJSON = "{}"
+
+var (
+	ociTokenMu sync.Mutex
+	ociToken   string
+)
+
+var authParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+type ociRef struct{ base, repo string }
+
+func parseOCI(raw string) (ociRef, error) {
+	host, repo, ok := strings.Cut(strings.TrimPrefix(raw, "oci://"), "/")
+	if !ok || host == "" || repo == "" { return ociRef{}, fmt.Errorf("invalid oci reference %q", raw) }
+	scheme := "https://"
+	if h := strings.Split(host, ":")[0]; h == "localhost" || h == "127.0.0.1" { scheme = "http://" }
+	return ociRef{scheme + host, strings.TrimSuffix(repo, "/")}, nil
+}
+
+// ociDo sends a request built by mk, answering one Bearer challenge.
+func ociDo(ctx context.Context, cfg Config, mk func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := mk()
+		if err != nil { return nil, err }
+		req = req.WithContext(ctx)
+		ociTokenMu.Lock()
+		tok := ociToken
+		ociTokenMu.Unlock()
+		if tok != "" {
+			req.Header.Set("authorization", "Bearer "+tok)
+		} else if cfg.AttestOCIUser != "" {
+			req.SetBasicAuth(cfg.AttestOCIUser, cfg.AttestOCIPassword)
+		}
+		resp, err := fetchClient.Do(req)
+		if err != nil || resp.StatusCode != 401 || attempt > 0 { return resp, err }
+		challenge := resp.Header.Get("www-authenticate")
+		resp.Body.Close()
+		if err := ociLogin(ctx, cfg, challenge); err != nil { return nil, err }
+	}
+}
+
+func ociLogin(ctx context.Context, cfg Config, challenge string) error {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "bearer") { return fmt.Errorf("registry: unsupported auth %q", scheme) }
+	p := map[string]string{}
+	for _, m := range authParam.FindAllStringSubmatch(params, -1) { p[m[1]] = m[2] }
+	req, err := http.NewRequestWithContext(ctx, "GET", p["realm"], nil)
+	if err != nil { return err }
+	q := req.URL.Query()
+	if p["service"] != "" { q.Set("service", p["service"]) }
+	if p["scope"] != "" { q.Set("scope", p["scope"]) }
+	req.URL.RawQuery = q.Encode()
+	if cfg.AttestOCIUser != "" { req.SetBasicAuth(cfg.AttestOCIUser, cfg.AttestOCIPassword) }
+	resp, err := fetchClient.Do(req)
+	if err != nil { return err }
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 { return fmt.Errorf("registry token: status %d", resp.StatusCode) }
+	var out struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil { return err }
+	tok := out.Token
+	if tok == "" { tok = out.AccessToken }
+	ociTokenMu.Lock()
+	ociToken = tok
+	ociTokenMu.Unlock()
+	return nil
+}
+
+func ociBlob(ctx context.Context, cfg Config, ref ociRef, data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	resp, err := ociDo(ctx, cfg, func() (*http.Request, error) { return http.NewRequest("HEAD", ref.base+"/v2/"+ref.repo+"/blobs/"+digest, nil) })
+	if err != nil { return "", err }
+	resp.Body.Close()
+	if resp.StatusCode == 200 { return digest, nil }
+	resp, err = ociDo(ctx, cfg, func() (*http.Request, error) { return http.NewRequest("POST", ref.base+"/v2/"+ref.repo+"/blobs/uploads/", nil) })
+	if err != nil { return "", err }
+	resp.Body.Close()
+	if resp.StatusCode != 202 { return "", fmt.Errorf("blob upload: status %d", resp.StatusCode) }
+	loc, err := resp.Request.URL.Parse(resp.Header.Get("location"))
+	if err != nil { return "", err }
+	q := loc.Query()
+	q.Set("digest", digest)
+	loc.RawQuery = q.Encode()
+	resp, err = ociDo(ctx, cfg, func() (*http.Request, error) {
+		req, err := http.NewRequest("PUT", loc.String(), bytes.NewReader(data))
+		if req != nil { req.Header.Set("content-type", "application/octet-stream") }
+		return req, err
+	})
+	if err != nil { return "", err }
+	resp.Body.Close()
+	if resp.StatusCode != 201 { return "", fmt.Errorf("blob put: status %d", resp.StatusCode) }
+	return digest, nil
+}
+
+func ociPushAttestation(cfg Config, rec *RunRecord, env []byte) error {
+	ref, err := parseOCI(cfg.Attest)
+	if err != nil { return err }
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.FetchTimeout)
+	defer cancel()
+	emptyDigest, err := ociBlob(ctx, cfg, ref, []byte(ociEmptyJSON))
+	if err != nil { return err }
+	layerDigest, err := ociBlob(ctx, cfg, ref, env)
+	if err != nil { return err }
+	manifest, _ := json.Marshal(map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+		"artifactType":  dsseMediaType,
+		"config":        map[string]any{"mediaType": "application/vnd.oci.empty.v1+json", "digest": emptyDigest, "size": len(ociEmptyJSON)},
+		"layers":        []any{map[string]any{"mediaType": dsseMediaType, "digest": layerDigest, "size": len(env), "annotations": map[string]string{"org.opencontainers.image.title": rec.ID + ".intoto.json"}}},
+		"annotations":   map[string]string{"dev.void.run": rec.ID, "dev.void.module": rec.Module, "dev.void.result": rec.Result},
+	})
+	resp, err := ociDo(ctx, cfg, func() (*http.Request, error) {
+		req, err := http.NewRequest("PUT", ref.base+"/v2/"+ref.repo+"/manifests/"+rec.ID, bytes.NewReader(manifest))
+		if req != nil { req.Header.Set("content-type", "application/vnd.oci.image.manifest.v1+json") }
+		return req, err
+	})
+	if err != nil { return err }
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode != 201 { return fmt.Errorf("manifest put: status %d: %s", resp.StatusCode, bytes.TrimSpace(msg)) }
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testAttestKey installs a fresh attestation key and returns a PEM file
+// holding its public half.
+func testAttestKey(t *testing.T) string {
+	t.Helper()
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(pub)
+	path := filepath.Join(t.TempDir(), "pub.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil { t.Fatal(err) }
+	saved, savedID := attestKey, attestKeyID
+	t.Cleanup(func() { attestKey, attestKeyID = saved, savedID })
+	sum := sha256.Sum256(der)
+	attestKey, attestKeyID = priv, hex.EncodeToString(sum[:])
+	return path
+}
+
+func TestDSSEPAE(t *testing.T) {
+	// the example from the DSSE v1 protocol spec
+	if got := string(dssePAE("http://example.com/HelloWorld", []byte("hello world"))); got != "DSSEv1 29 http://example.com/HelloWorld 11 hello world" { t.Errorf("PAE = %q", got) }
+	if got := string(dssePAE("", nil)); got != "DSSEv1 0  0 " { t.Errorf("empty PAE = %q", got) }
+}
+
+func TestAttestRun(t *testing.T) {
+	otherKey := testAttestKey(t)
+	key := testAttestKey(t) // the one that signs
+	dir := t.TempDir()
+	mod := filepath.Join(dir, "m.wasm")
+	other := filepath.Join(dir, "other.wasm")
+	os.WriteFile(mod, []byte("\x00asm\x01\x00\x00\x00"), 0o600)
+	os.WriteFile(other, []byte("\x00asm\x01\x00\x00\x00\x00"), 0o600)
+	var events []map[string]any
+	saved := localSink
+	defer func() { localSink = saved }()
+	localSink = func(ev map[string]any) { events = append(events, ev) }
+
+	rec := &RunRecord{ID: "r1", Module: "demo/hello", Tenant: "acme", Result: "ok", Started: time.Unix(1700000000, 0), Envelope: &Envelope{Inputs: map[string]any{"n": 1}}, Decisions: []string{"allow"}}
+	attestRun(Config{Attest: "relay", NodeID: "node-a"}, rec, mod)
+	if len(events) != 1 || events[0]["type"] != "wasm.attestation" { t.Fatalf("events = %v", events) }
+	meta := events[0]["meta"].(map[string]any)
+	raw := meta["dsse"].(json.RawMessage)
+	sum := sha256.Sum256(raw)
+	if want := "sha256:" + hex.EncodeToString(sum[:]); rec.Attestation != want || meta["digest"] != want { t.Errorf("digest %q, event %v, want %s", rec.Attestation, meta["digest"], want) }
+
+	var env dsseEnvelope
+	if err := json.Unmarshal(raw, &env); err != nil { t.Fatal(err) }
+	if env.PayloadType != attestPayloadType || len(env.Signatures) != 1 || env.Signatures[0].KeyID != attestKeyID { t.Fatalf("envelope %+v", env) }
+	payload, _ := base64.StdEncoding.DecodeString(env.Payload)
+	sig, _ := base64.StdEncoding.DecodeString(env.Signatures[0].Sig)
+	if !ed25519.Verify(attestKey.Public().(ed25519.PublicKey), dssePAE(attestPayloadType, payload), sig) { t.Error("signature is not over the PAE") }
+	var st struct {
+		intotoStatement
+		Predicate map[string]any `json:"predicate"`
+	}
+	if err := json.Unmarshal(payload, &st); err != nil { t.Fatal(err) }
+	modSum, inSum := sha256.Sum256([]byte("\x00asm\x01\x00\x00\x00")), sha256.Sum256([]byte(`{"n":1}`))
+	if st.Type != "https://in-toto.io/Statement/v1" || st.PredicateType != attestPredicateType || len(st.Subject) != 2 ||
+		st.Subject[0].Name != "demo/hello" || st.Subject[0].Digest["sha256"] != hex.EncodeToString(modSum[:]) ||
+		st.Subject[1].Name != "inputs" || st.Subject[1].Digest["sha256"] != hex.EncodeToString(inSum[:]) { t.Errorf("statement %+v", st.intotoStatement) }
+	if st.Predicate["run"] != "r1" || st.Predicate["result"] != "ok" || st.Predicate["tenant"] != "acme" { t.Errorf("predicate %v", st.Predicate) }
+
+	writeRaw := func(name string, b []byte) string {
+		p := filepath.Join(dir, name)
+		os.WriteFile(p, b, 0o600)
+		return p
+	}
+	event, _ := json.Marshal(events[0])
+	write := func(name string, edit func(e *dsseEnvelope)) string {
+		e := env
+		e.Signatures = append([]dsseSignature{}, env.Signatures...)
+		if edit != nil { edit(&e) }
+		b, _ := json.Marshal(e)
+		return writeRaw(name, b)
+	}
+	bare := write("bare.json", nil)
+	for _, tc := range []struct {
+		name, key, module, file string
+		want                    int
+	}{
+		{"relay event", key, "", writeRaw("event.json", event), 0},
+		{"bare envelope", key, "", bare, 0},
+		{"module is a subject", key, mod, bare, 0},
+		{"later signature verifies", key, "", write("second.json", func(e *dsseEnvelope) { e.Signatures = append([]dsseSignature{{"x", base64.StdEncoding.EncodeToString(make([]byte, 64))}}, e.Signatures...) }), 0},
+		{"other module", key, other, bare, 1},
+		{"missing module", key, filepath.Join(dir, "missing.wasm"), bare, 1},
+		{"other key", otherKey, "", bare, 1},
+		{"payload edited", key, "", write("edited.json", func(e *dsseEnvelope) {
+			e.Payload = base64.StdEncoding.EncodeToString(bytes.Replace(payload, []byte(`"result":"ok"`), []byte(`"result":"error"`), 1))
+		}), 1},
+		{"payload type changed", key, "", write("type.json", func(e *dsseEnvelope) { e.PayloadType = "application/json" }), 1},
+		{"signature swapped", key, "", write("zero.json", func(e *dsseEnvelope) { e.Signatures[0].Sig = base64.StdEncoding.EncodeToString(make([]byte, 64)) }), 1},
+		{"signature not base64", key, "", write("sig64.json", func(e *dsseEnvelope) { e.Signatures[0].Sig = "!!" }), 1},
+		{"payload not base64", key, "", write("payload64.json", func(e *dsseEnvelope) { e.Payload = "!!" }), 1},
+		{"unsigned", key, "", write("unsigned.json", func(e *dsseEnvelope) { e.Signatures = nil }), 1},
+		{"not json", key, "", writeRaw("junk.json", []byte("nope")), 1},
+		{"key not PEM", mod, "", bare, 1},
+		{"no key", "", "", bare, 2},
+	} {
+		args := []string{"--key", tc.key}
+		if tc.module != "" { args = append(args, "--module", tc.module) }
+		if got := attestVerifyLocal(append(args, tc.file)); got != tc.want { t.Errorf("%s: attest-verify = %d, want %d", tc.name, got, tc.want) }
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- void-wasm-exec bench: replay an envelope corpus at a target RPS ---
+// Runs the local execution path (fetch/cache + wazero) without the relay.
+// The first run of each module is reported as cold (download + compile),
+// the rest as warm.
+
+type benchStats struct {
+	mu         sync.Mutex
+	cold, warm []float64
+	errors     int
+	firstErr   error
+	seen       map[string]bool
+}
+
+func (s *benchStats) add(key string, ms float64, err error) {
+	s.mu.Lock(); defer s.mu.Unlock()
+	if err != nil { s.errors++; if s.firstErr == nil { s.firstErr = err }; return }
+	if s.seen[key] { s.warm = append(s.warm, ms); return }
+	s.seen[key] = true
+	s.cold = append(s.cold, ms)
+}
+
+func pct(sorted []float64, p int) float64 {
+	if len(sorted) == 0 { return 0 }
+	return sorted[(len(sorted)*p+99)/100-1]
+}
+
+func loadCorpus(path string) ([]Envelope, error) {
+	f, err := os.Open(path)
+	if err != nil { return nil, err }
+	defer f.Close()
+	var out []Envelope
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 4<<20)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") { continue }
+		env, err := parseEnvelope([]byte(line), false)
+		if err != nil { return nil, fmt.Errorf("%s:%d: %w", path, n, err) }
+		out = append(out, env)
+	}
+	return out, sc.Err()
+}
+
+func benchLocal(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	rps := fs.Float64("rps", 10, "target envelopes per second")
+	duration := fs.Duration("duration", 10*time.Second, "how long to send")
+	conc := fs.Int("concurrency", 4, "max runs in flight (excess ticks are counted as missed)")
+	caps := fs.String("caps", "emit,kv", "comma-separated caps")
+	timeout := fs.Duration("timeout", 10*time.Second, "per-run timeout")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: void-wasm-exec bench [flags] corpus.ndjson   (one signal.wasm envelope per line; url may be file://)")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 || *rps <= 0 || *conc < 1 { fs.Usage(); return 2 }
+	corpus, err := loadCorpus(fs.Arg(0))
+	if err != nil || len(corpus) == 0 { fmt.Fprintln(os.Stderr, "corpus:", err); return 2 }
+
+	cfg := defaultConfig()
+	cfg.Tenant = defaultTenant
+	cfg.AllowCaps = parseList(*caps)
+	cfg.DefaultTO = *timeout
+	os.MkdirAll(cfg.CacheDir, 0o755)
+	localSink = func(map[string]any) {}
+
+	stats := &benchStats{seen: map[string]bool{}}
+	slots := make(chan struct{}, *conc)
+	var wg sync.WaitGroup
+	sent, missed := 0, 0
+	tick := time.NewTicker(time.Duration(float64(time.Second) / *rps))
+	defer tick.Stop()
+	start := time.Now()
+	for i := 0; time.Since(start) < *duration; i++ {
+		<-tick.C
+		select {
+		case slots <- struct{}{}:
+		default:
+			missed++
+			continue
+		}
+		sent++
+		env := corpus[i%len(corpus)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			t0 := time.Now()
+			err := benchRun(cfg, &env)
+			stats.add(env.Module+"@"+env.SHA256+env.URL, float64(time.Since(t0).Microseconds())/1000, err)
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	done := len(stats.cold) + len(stats.warm)
+	fmt.Printf("sent %d, missed %d (concurrency %d), ok %d, errors %d in %s -> %.1f runs/s (target %.1f)\n",
+		sent, missed, *conc, done, stats.errors, elapsed.Round(time.Millisecond), float64(done)/elapsed.Seconds(), *rps)
+	for _, row := range []struct {
+		name string
+		ms   []float64
+	}{{"cold", stats.cold}, {"warm", stats.warm}} {
+		sort.Float64s(row.ms)
+		if len(row.ms) == 0 { fmt.Printf("%-5s n=0\n", row.name); continue }
+		fmt.Printf("%-5s n=%-6d p50=%.1fms p90=%.1fms p99=%.1fms max=%.1fms\n",
+			row.name, len(row.ms), pct(row.ms, 50), pct(row.ms, 90), pct(row.ms, 99), row.ms[len(row.ms)-1])
+	}
+	if stats.errors > 0 { fmt.Println("first error:", stats.firstErr); return 1 }
+	return 0
+}
+
+// benchRun is handleEnvelope minus relay, policy and bookkeeping.
+func benchRun(cfg Config, env *Envelope) error {
+	v, err := selectVariant(cfg, env.Variants)
+	if err != nil { return err }
+	src := variantSource(env, v)
+	path := strings.TrimPrefix(src.URL, "file://")
+	if path == src.URL {
+		if path, _, err = fetchModule(cfg, src, nil); err != nil { return err }
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DefaultTO)
+	defer cancel()
+	rec := &RunRecord{ID: newRunID(time.Now()), Module: env.Module, Tenant: defaultTenant, Path: pathStable}
+	return runWasm(ctx, cfg, path, env, rec)
+}
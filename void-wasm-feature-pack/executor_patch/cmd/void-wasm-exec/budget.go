@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
+)
+
+// --- Per-run resource budget ---
+// An envelope may declare "budget": {"wall_ms","fuel","net_kb","syscalls"}
+// (any subset, 0 = no limit) that the run draws down across everything it
+// does: wall_ms from admission, so queueing and the module download count;
+// fuel as guest function calls (wazero has no instruction metering, so
+// budgeted runs compile with a call listener, cached separately from
+// unmetered ones); net_kb as http.fetch body bytes, reads capped at what is
+// left; syscalls as stdout syscalls plus void.kv_watch. The first dimension
+// to run out ends the run with result budget_exhausted, and the run record
+// (history, receipts) carries the budget, what was used and which dimension
+// ran out. It only tightens: timeout and the other limits still apply.
+
+var budgetExhausted = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_budget_exhausted_total", Help: "Runs ended by their envelope budget, by dimension"}, []string{"dimension"})
+
+var errBudget = errors.New("budget exhausted")
+
+// RunBudget is the envelope's budget, and the usage reported against it.
+type RunBudget struct {
+	WallMs   int64 `json:"wall_ms,omitempty"`
+	Fuel     int64 `json:"fuel,omitempty"`
+	NetKB    int64 `json:"net_kb,omitempty"`
+	Syscalls int64 `json:"syscalls,omitempty"`
+}
+
+// BudgetReport goes into the run record.
+type BudgetReport struct {
+	Limit     RunBudget `json:"limit"`
+	Used      RunBudget `json:"used"`
+	Exhausted string    `json:"exhausted,omitempty"` // wall_ms | fuel | net_kb | syscalls
+}
+
+const (
+	budgetWall     = "wall_ms"
+	budgetFuel     = "fuel"
+	budgetNet      = "net_kb"
+	budgetSyscalls = "syscalls"
+)
+
+// runBudget is the live state; a nil *runBudget is an unbudgeted run.
+type runBudget struct {
+	limit          RunBudget
+	start          time.Time
+	fuel, syscalls atomic.Int64
+	net            atomic.Int64 // bytes
+
+	mu        sync.Mutex
+	exhausted string
+	cancel    context.CancelFunc // ends the guest; set by runWasm
+}
+
+func newRunBudget(b *RunBudget, start time.Time) *runBudget {
+	if b == nil || *b == (RunBudget{}) { return nil }
+	return &runBudget{limit: *b, start: start}
+}
+
+func validBudget(b *RunBudget) error {
+	if b == nil { return nil }
+	if b.WallMs < 0 || b.Fuel < 0 || b.NetKB < 0 || b.Syscalls < 0 { return errors.New("dimensions must be >= 0") }
+	return nil
+}
+
+// exhaust records the first dimension to run out and stops the guest.
+func (b *runBudget) exhaust(dim string) {
+	b.mu.Lock()
+	first := b.exhausted == ""
+	if first { b.exhausted = dim }
+	cancel := b.cancel
+	b.mu.Unlock()
+	if !first { return }
+	budgetExhausted.WithLabelValues(dim).Inc()
+	if cancel != nil { cancel() }
+}
+
+// spend books n units of a dimension; false once that dimension is over.
+func (b *runBudget) spend(dim string, n int64) bool {
+	if b == nil { return true }
+	var used, limit int64
+	switch dim {
+	case budgetFuel: used, limit = b.fuel.Add(n), b.limit.Fuel
+	case budgetSyscalls: used, limit = b.syscalls.Add(n), b.limit.Syscalls
+	case budgetNet: used, limit = b.net.Add(n), b.limit.NetKB<<10
+	}
+	if limit > 0 && used > limit { b.exhaust(dim); return false }
+	return true
+}
+
+// netLeft is how many more body bytes the run may read; -1 = unlimited.
+func (b *runBudget) netLeft() int64 {
+	if b == nil || b.limit.NetKB <= 0 { return -1 }
+	return max(b.limit.NetKB<<10-b.net.Load(), 0)
+}
+
+// wallLeft is the time left from admission; ok is false without wall_ms.
+func (b *runBudget) wallLeft() (time.Duration, bool) {
+	if b == nil || b.limit.WallMs <= 0 { return 0, false }
+	return time.Duration(b.limit.WallMs)*time.Millisecond - time.Since(b.start), true
+}
+
+func (b *runBudget) err() error {
+	if b == nil { return nil }
+	b.mu.Lock(); defer b.mu.Unlock()
+	if b.exhausted == "" { return nil }
+	return fmt.Errorf("%w: %s", errBudget, b.exhausted)
+}
+
+func (b *runBudget) report() *BudgetReport {
+	if b == nil { return nil }
+	b.mu.Lock(); defer b.mu.Unlock()
+	return &BudgetReport{Limit: b.limit, Exhausted: b.exhausted, Used: RunBudget{
+		WallMs: time.Since(b.start).Milliseconds(), Fuel: b.fuel.Load(), NetKB: (b.net.Load() + 1023) >> 10, Syscalls: b.syscalls.Load(),
+	}}
+}
+
+// arm hooks the budget to a starting guest: cancel ends it, and the wall
+// clock fires exhaust when it runs out. The returned func disarms.
+func (b *runBudget) arm(cancel context.CancelFunc) func() {
+	if b == nil { return func() {} }
+	b.mu.Lock()
+	b.cancel = cancel
+	b.mu.Unlock()
+	left, ok := b.wallLeft()
+	if !ok { return func() {} }
+	t := time.AfterFunc(max(left, 0), func() { b.exhaust(budgetWall) })
+	return func() { t.Stop() }
+}
+
+// --- fuel metering ---
+
+type budgetKey struct{}
+
+func withBudget(ctx context.Context, b *runBudget) context.Context {
+	if b == nil { return ctx }
+	return context.WithValue(ctx, budgetKey{}, b)
+}
+
+// metered reports whether runs under ctx need the fuel listener.
+func metered(ctx context.Context) bool {
+	b, _ := ctx.Value(budgetKey{}).(*runBudget)
+	return b != nil && b.limit.Fuel > 0
+}
+
+// fuelListener charges one unit of fuel per guest function call.
+type fuelListener struct{}
+
+func (fuelListener) NewFunctionListener(api.FunctionDefinition) experimental.FunctionListener { return fuelListener{} }
+
+func (fuelListener) Before(ctx context.Context, _ api.Module, _ api.FunctionDefinition, _ []uint64, _ experimental.StackIterator) {
+	if b, _ := ctx.Value(budgetKey{}).(*runBudget); b != nil { b.spend(budgetFuel, 1) }
+}
+
+func (fuelListener) After(context.Context, api.Module, api.FunctionDefinition, []uint64) {}
+
+func (fuelListener) Abort(context.Context, api.Module, api.FunctionDefinition, error) {}
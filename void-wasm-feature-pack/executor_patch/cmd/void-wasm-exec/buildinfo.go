@@ -0,0 +1,108 @@
+package main
+
+import (
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// commit is stamped at build time next to version: -ldflags "-X main.commit=abc123"
+var commit = "unknown"
+
+func engineVersion() string {
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, d := range bi.Deps {
+			if d.Path == "github.com/tetratelabs/wazero" { return "wazero " + d.Version }
+		}
+	}
+	return "wazero"
+}
+
+// registerBuildInfo exposes void_wasm_build_info plus one
+// void_wasm_feature_enabled{feature} gauge per flag, read live from config.
+func registerBuildInfo() {
+	info := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "void_wasm_build_info", Help: "Build info (always 1)"}, []string{"version", "commit", "engine"})
+	info.WithLabelValues(version, commit, engineVersion()).Set(1)
+	reg.MustRegister(info)
+
+	b := func(v bool) float64 { if v { return 1 }; return 0 }
+	for name, fn := range featureFlags() {
+		fn := fn
+		reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "void_wasm_feature_enabled", Help: "1 if the feature flag is enabled", ConstLabels: prometheus.Labels{"feature": name},
+		}, func() float64 { return b(fn()) }))
+	}
+}
+
+// featureFlags are the void_wasm_feature_enabled flags, read live from
+// config; node registration advertises the enabled ones (register.go).
+func featureFlags() map[string]func() bool {
+	return map[string]func() bool{
+		"cosign":            func() bool { return currentConfig().CosignVerify },
+		"dry_run":           func() bool { return currentConfig().DryRun },
+		"frozen":            func() bool { return intakePaused.Load() },
+		"native_histograms": func() bool { return currentConfig().NativeHistograms },
+		"history":           func() bool { return history != nil },
+		"admin":             func() bool { c := currentConfig(); return c.AdminAddr != "" && c.AdminToken != "" },
+		"pprof":             func() bool { return currentConfig().AdminPprof },
+		"event_spill":       func() bool { return currentConfig().EventSpillDir != "" },
+		"event_batch_post":  func() bool { return currentConfig().EventBatchPost != "" },
+		"event_cbor":        func() bool { return currentConfig().EventEncoding == "cbor" },
+		"cloudevents":       func() bool { return currentConfig().CloudEvents },
+		"grpc_sink":         func() bool { return currentConfig().EventSink == "grpc" },
+		"nats_sink":         func() bool { return currentConfig().NATSURL != "" },
+		"artifacts":         func() bool { return currentConfig().ArtifactsURL != "" },
+		"ipfs_publish":      func() bool { return currentConfig().IPFSPublish != "" },
+		"webhooks":          func() bool { return len(currentConfig().Webhooks) > 0 },
+		"attest":            func() bool { return currentConfig().Attest != "" },
+		"identity":          func() bool { return identity != nil },
+		"sign_events":       func() bool { return identity != nil && currentConfig().SignEvents },
+		"log_shipping":      func() bool { return currentConfig().LogSink != "" },
+		"heartbeat":         func() bool { return currentConfig().HeartbeatEvery > 0 },
+		"register":          func() bool { c := currentConfig(); return c.RegisterPath != "" && c.RegisterLease > 0 },
+		"canary":            func() bool { return currentConfig().CanaryPercent > 0 },
+		"leader_election":   func() bool { return currentConfig().LeaderLock != "" },
+		"chaos":             func() bool { return currentConfig().Chaos },
+		"record":            func() bool { return currentConfig().RecordDir != "" },
+		"ledger":            func() bool { return ledger != nil },
+		"strict_envelopes":  func() bool { return currentConfig().StrictEnvelopes },
+		"shared_runtime":    func() bool { return !currentConfig().RuntimePerRun },
+		"adaptive":          func() bool { return currentConfig().AdaptiveConcurrency },
+		"fair_queue":        func() bool { return currentConfig().FairQueue != "off" },
+		"module_limits":     func() bool { return len(currentConfig().ModuleLimits) > 0 },
+		"policies_d":        func() bool { return currentConfig().PoliciesDir != "" },
+		"kv_bbolt":          func() bool { return currentConfig().KVBackend == "bbolt" },
+		"kv_redis":          func() bool { return currentConfig().KVBackend == "redis" },
+		"kv_nats":           func() bool { return currentConfig().KVBackend == "nats" },
+		"queue":             func() bool { return currentConfig().QueueBackend != "off" },
+		"shared_cache":      func() bool { return currentConfig().SharedCache != "" },
+		"intent":            func() bool { return currentConfig().IntentAddr != "" },
+		"github":            func() bool { return currentConfig().GitHubAddr != "" },
+		"github_provenance": func() bool { c := currentConfig(); return c.SigstoreRoots != "" && c.SigstoreRekorKey != "" },
+		"module_registry":   func() bool { return currentConfig().RegistryURL != "" },
+		"grafana":           func() bool { return currentConfig().GrafanaURL != "" },
+		"intake_limits":     func() bool { return currentConfig().IntakeRPS > 0 },
+		"corpus_export":     func() bool { return currentConfig().CorpusFile != "" },
+		"sandbox":           func() bool { c := currentConfig(); return c.Landlock != "off" || c.Seccomp },
+		"control_allowlist": func() bool { return len(currentConfig().ControlKeys) > 0 },
+		"dns_cache":         func() bool { return currentConfig().DNSCache },
+		"http_cache":        func() bool { return currentConfig().HTTPCache },
+		"at_rest":           func() bool { return atRest != nil },
+		"module_keys":       func() bool { return len(moduleAEAD) > 0 || len(moduleAge) > 0 },
+		"mounts":            func() bool { return len(currentConfig().Mounts) > 0 },
+		"guest_env":         func() bool { return len(currentConfig().GuestEnv) > 0 },
+		"host_vars":         func() bool { return len(currentConfig().HostVars) > 0 },
+		"scratch":           func() bool { return len(currentConfig().ScratchModules) > 0 },
+		"event_schemas":     func() bool { return currentConfig().EventSchemaAction != "off" },
+		"soft_timeout":      func() bool { return currentConfig().SoftTimeoutPct > 0 },
+		"real_clock":        func() bool { return currentConfig().Clock == "real" },
+		"random":            func() bool { return currentConfig().RandomMaxKB > 0 },
+		"graphql":           func() bool { return len(currentConfig().GraphQL) > 0 },
+		"ai":                func() bool { return len(currentConfig().AI) > 0 },
+		"ws":                func() bool { return len(currentConfig().WS) > 0 },
+		"run_tokens":        func() bool { return identity != nil && len(currentConfig().RunTokenHosts) > 0 },
+		"output_limits":     func() bool { c := currentConfig(); return c.MaxStdoutKB > 0 || c.MaxEvents > 0 || c.MaxEventKB > 0 },
+		"module_lru":        func() bool { c := currentConfig(); return !c.RuntimePerRun && c.ModuleLRU > 0 },
+		"transport_sse":     func() bool { return true },
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Fault injection (test only, CHAOS=1) ---
+// Each fault fires with its own probability so rollout automation, alerts and
+// dashboards can be exercised before a real incident. Never enable in prod.
+
+var errChaos = errors.New("chaos: injected fault")
+
+var chaosInjected = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_chaos_injected_total", Help: "Faults injected by chaos mode"}, []string{"fault"})
+
+func chaosHit(cfg Config, fault string, p float64) bool {
+	if !cfg.Chaos || p <= 0 || rand.Float64() >= p { return false }
+	chaosInjected.WithLabelValues(fault).Inc()
+	fmt.Println("[chaos] inject", fault)
+	return true
+}
+
+func chaosErr(fault string) error { return fmt.Errorf("%w: %s", errChaos, fault) }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// --- Guest clocks (syscall.time: void.time_now, void.time_mono) ---
+// clock (also per module in policy files) decides what time a run sees.
+// deterministic, the default, leaves the WASI clocks on wazero's fake ones
+// and answers void.time_now (unix ns) and void.time_mono (ns since the run
+// started) from a fake clock of its own: the fixed epoch 2022-01-01 and 1ms
+// more on every read. The same inputs then see the same times on any node,
+// which golden files and replay depend on. real opts the WASI clocks into
+// the host's and answers with the wall clock and the monotonic time since
+// the run started, so modules can measure durations the same way under any
+// toolchain. With RECORD_DIR the readings of a real run go into the
+// recording and replay hands them back in order (the WASI clocks stay fake
+// there). Reads are counted in void_wasm_syscall_requests_total with the
+// clock as result but not traced: a stopwatch in a loop would fill the
+// timeline.
+
+const fakeEpoch = 1640995200_000_000_000 // 2022-01-01T00:00:00Z, as wazero's fake walltime
+
+type runClock struct {
+	real  bool
+	start time.Time
+	reads atomic.Int64
+	tape  *runTape
+}
+
+type clockKey struct{}
+
+func withClock(ctx context.Context, cfg Config, rec *RunRecord, start time.Time) context.Context {
+	return context.WithValue(ctx, clockKey{}, &runClock{real: cfg.Clock == "real", start: start, tape: rec.tape})
+}
+
+// clockModule opts a clock real run's WASI clocks and random_get into the
+// host's.
+func clockModule(cfg Config, mc wazero.ModuleConfig) wazero.ModuleConfig {
+	if cfg.Clock != "real" { return mc }
+	return mc.WithSysWalltime().WithSysNanotime().WithSysNanosleep().WithRandSource(rand.Reader)
+}
+
+// read answers kind: from the replay tape, the real clocks or the fake one.
+func (c *runClock) read(kind string, now func() int64) int64 {
+	n := c.reads.Add(1)
+	if !c.real {
+		sysReqTotal.WithLabelValues(kind, "deterministic").Inc()
+		if kind == "syscall.time.now" { return fakeEpoch + n*int64(time.Millisecond) }
+		return n * int64(time.Millisecond)
+	}
+	sysReqTotal.WithLabelValues(kind, "real").Inc()
+	if c.tape != nil && c.tape.replay != nil { return c.replayed(kind) }
+	v := now()
+	if c.tape != nil { c.tape.exchanges = append(c.tape.exchanges, Exchange{Kind: kind, Response: map[string]any{"ns": strconv.FormatInt(v, 10)}, Result: "ok"}) }
+	return v
+}
+
+// replayed takes the next recorded reading of kind; a miss reads as 0.
+func (c *runClock) replayed(kind string) int64 {
+	x, ok := c.tape.next(kind)
+	if !ok { return 0 }
+	s, _ := x.Response["ns"].(string)
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil { fmt.Println("[replay] bad", kind, "reading:", s) }
+	return v
+}
+
+// exportClock adds time_now and time_mono to the void host module.
+func exportClock(b wazero.HostModuleBuilder) {
+	i64 := api.ValueTypeI64
+	fn := func(kind string, now func(c *runClock) int64) api.GoFunc {
+		return api.GoFunc(func(ctx context.Context, stack []uint64) {
+			c, _ := ctx.Value(clockKey{}).(*runClock)
+			if c == nil { c = &runClock{} } // outside a run: the fake clock
+			stack[0] = api.EncodeI64(c.read(kind, func() int64 { return now(c) }))
+		})
+	}
+	b.NewFunctionBuilder().
+		WithGoFunction(fn("syscall.time.now", func(*runClock) int64 { return time.Now().UnixNano() }), nil, []api.ValueType{i64}).
+		Export("time_now")
+	b.NewFunctionBuilder().
+		WithGoFunction(fn("syscall.time.mono", func(c *runClock) int64 { return int64(time.Since(c.start)) }), nil, []api.ValueType{i64}).
+		Export("time_mono")
+}
+
+func validClock(clock string) error {
+	if clock != "deterministic" && clock != "real" { return fmt.Errorf("clock: must be deterministic or real, got %q", clock) }
+	return nil
+}
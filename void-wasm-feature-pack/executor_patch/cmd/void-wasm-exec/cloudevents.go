@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"time"
+)
+
+// --- CloudEvents 1.0 (structured mode) ---
+// With cloudevents every outgoing event is wrapped before it is queued, so
+// spilled and retried events keep the same id. The original event is `data`
+// and its "type" the CloudEvents type; run events carry the module as
+// subject plus the runid, correlationid and (from envelope meta) traceparent
+// extensions.
+// Posts go out as application/cloudevents+json, batches as
+// application/cloudevents-batch+json; with event_encoding cbor the body is
+// CBOR as before.
+
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Time            string          `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	Subject         string          `json:"subject,omitempty"`
+	TraceParent     string          `json:"traceparent,omitempty"`
+	RunID           string          `json:"runid,omitempty"`
+	CorrelationID   string          `json:"correlationid,omitempty"`
+	Data            json.RawMessage `json:"data"`
+}
+
+var traceparentRe = regexp.MustCompile(`^[0-9a-f]{2}-[0-9a-f]{32}-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+func ceSource(cfg Config) string {
+	if cfg.CloudEventsSource != "" { return cfg.CloudEventsSource }
+	return "/void-wasm-exec/" + nodeID(cfg)
+}
+
+// wrapCloudEvent returns data inside a CloudEvents envelope; rec may be nil
+// for node events (heartbeat, slo, usage...).
+func wrapCloudEvent(cfg Config, rec *RunRecord, data []byte) []byte {
+	var head struct{ Type string `json:"type"` }
+	json.Unmarshal(data, &head)
+	if head.Type == "" { head.Type = "void.event" }
+	now := time.Now()
+	ce := cloudEvent{SpecVersion: "1.0", ID: newRunID(now), Source: ceSource(cfg), Type: head.Type, Time: now.UTC().Format(time.RFC3339Nano), DataContentType: "application/json", Data: data}
+	if rec != nil {
+		ce.Subject, ce.RunID, ce.CorrelationID = rec.Module, rec.ID, rec.Correlation
+		if rec.Envelope != nil {
+			if tp, _ := rec.Envelope.Meta["traceparent"].(string); traceparentRe.MatchString(tp) { ce.TraceParent = tp }
+		}
+	}
+	b, _ := json.Marshal(ce)
+	return b
+}
+
+func ceContentType(payload any) string {
+	if _, batch := payload.([]any); batch { return "application/cloudevents-batch+json" }
+	return "application/cloudevents+json"
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"reflect"
+	"sync/atomic"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- CBOR encoding (envelopes in, events out) ---
+// event_encoding: cbor posts events and batches as application/cbor; a relay
+// that answers 415 is remembered and gets JSON from then on. POST
+// /admin/envelopes takes one envelope as application/json or
+// application/cbor, for bridges from binary transports (NATS, MQTT). CBOR
+// envelopes are converted to JSON and validated by parseEnvelope like SSE ones.
+
+var eventBytes = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_event_bytes_total", Help: "Event POST body bytes by encoding"}, []string{"encoding"})
+
+var cborRefused atomic.Bool // relay answered 415 to CBOR
+
+var (
+	cborDec, _ = cbor.DecOptions{DefaultMapType: reflect.TypeOf(map[string]any(nil)), MaxNestedLevels: 32}.DecMode()
+	cborEnc, _ = cbor.EncOptions{ShortestFloat: cbor.ShortestFloat16}.EncMode()
+)
+
+func isCBOR(ctype string) bool { mt, _, _ := mime.ParseMediaType(ctype); return mt == "application/cbor" }
+
+func encodingOf(ctype string) string { if isCBOR(ctype) { return "cbor" }; return "json" }
+
+// encodeBody marshals an event or batch for the relay; returns the content type.
+func encodeBody(cfg Config, payload any) ([]byte, string, error) {
+	b, err := json.Marshal(payload)
+	if err != nil || cfg.EventEncoding != "cbor" || cborRefused.Load() {
+		if cfg.CloudEvents { return b, ceContentType(payload), err }
+		return b, "application/json", err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b)) // raw module events and maps alike
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil { return nil, "", err }
+	c, err := cborEnc.Marshal(cborValue(v))
+	return c, "application/cbor", err
+}
+
+// cborValue keeps integral JSON numbers as CBOR ints (1-9 bytes, not float64).
+func cborValue(v any) any {
+	switch t := v.(type) {
+	case json.Number:
+		if n, err := t.Int64(); err == nil { return n }
+		f, _ := t.Float64()
+		return f
+	case map[string]any:
+		for k, x := range t { t[k] = cborValue(x) }
+	case []any:
+		for i, x := range t { t[i] = cborValue(x) }
+	}
+	return v
+}
+
+// decodeEnvelope parses an envelope body according to its content type.
+func decodeEnvelope(ctype string, body []byte, strict bool) (Envelope, error) {
+	if !isCBOR(ctype) { return parseEnvelope(body, strict) }
+	if err := checkEnvelopeSize(len(body)); err != nil { return Envelope{}, err }
+	var v any
+	if err := cborDec.Unmarshal(body, &v); err != nil { return Envelope{}, envelopeError{"decode", err.Error()} }
+	b, err := json.Marshal(v)
+	if err != nil { return Envelope{}, envelopeError{"decode", err.Error()} }
+	return parseEnvelope(b, strict)
+}
@@ -0,0 +1,946 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config via file (CONFIG_FILE / -config), then env overrides, then flags
+type Config struct {
+	RelayBase   string        `yaml:"relay_base"`
+	SSEPath     string        `yaml:"sse_path"`
+	EventPost   string        `yaml:"event_post"`
+	IPFSGateway string        `yaml:"ipfs_gateway"`
+	CacheDir    string        `yaml:"cache_dir"`
+	PromAddr    string        `yaml:"prom_addr"`
+	Concurrency int           `yaml:"concurrency"` // exec workers
+	DefaultTO   time.Duration `yaml:"timeout"`
+	MaxMemMB    uint32        `yaml:"mem_mb"`
+	CacheMaxMB  int           `yaml:"cache_max_mb"` // 0 = unbounded
+
+	ReadyMinFreeMB int `yaml:"ready_min_free_mb"` // /readyz fails below this much free in cache_dir (ready.go)
+
+	SoftTimeoutPct int `yaml:"soft_timeout_pct"` // warn the module at this % of timeout (deadline.go); 0 = off
+	Clock          string `yaml:"clock"`          // deterministic | real: what WASI and void.time_* report (clock.go)
+	RandomMaxKB    int    `yaml:"random_max_kb"`  // void.random bytes per run (random.go); 0 = off
+
+	Tenants map[string]TenantPolicy `yaml:"tenants"`
+	Tenant  string                  `yaml:"-"` // resolved per run by forTenant
+
+	QuotaWindow      time.Duration `yaml:"quota_window"` // 0 disables accounting
+	QuotaRuns        int64         `yaml:"quota_runs"`   // per module (within a tenant); 0 = no limit
+	QuotaRunTime     time.Duration `yaml:"quota_run_time"`
+	QuotaNetMB       int64         `yaml:"quota_net_mb"`
+	QuotaAction      string        `yaml:"quota_action"` // deny | defer
+	QuotaDefer       time.Duration `yaml:"quota_defer"`
+	UsageReportEvery time.Duration `yaml:"usage_report"`
+
+	IntakeRPS      int    `yaml:"intake_rps"`   // envelopes/s per module at intake (intake.go); 0 = unlimited
+	IntakeBurst    int    `yaml:"intake_burst"`
+	IntakeAction   string `yaml:"intake_action"` // shed | defer
+	IntakeDeferMax int    `yaml:"intake_defer_max"`
+
+	NativeHistograms bool `yaml:"native_histograms"`
+	StrictEnvelopes  bool `yaml:"strict_envelopes"` // reject fields outside schema/envelope.v1.json
+	EnvelopeMaxKB    int  `yaml:"envelope_max_kb"`  // raw envelope (schema.go); 1..1024
+	InputsMaxKB      int  `yaml:"inputs_max_kb"`    // inputs as JSON; 0 = no limit
+	CapsMax          int  `yaml:"caps_max"`
+	MetaMaxDepth     int  `yaml:"meta_max_depth"`
+
+	CanaryPercent int    `yaml:"canary_percent"` // 0..100 of envelopes on the canary path
+	CanaryEngine  string `yaml:"canary_engine"`  // "", interpreter, compiler
+	CanaryMemMB   uint32 `yaml:"canary_mem_mb"`  // 0 = no extra limit
+
+	AllowModules []string `yaml:"allow_modules"`
+	AllowCaps    []string `yaml:"allow_caps"`
+
+	AllowHTTPHosts []string `yaml:"allow_http_hosts"`
+	ControlKeys    []string `yaml:"control_keys"` // ed25519 public key PEMs that may sign relay allowlist updates (allowlist.go)
+	HTTPBurst      int      `yaml:"http_burst"`
+	HTTPRPS        int      `yaml:"http_rps"`
+	MaxHTTPKB      int      `yaml:"http_max_kb"`
+
+	MaxStdoutKB  int    `yaml:"max_stdout_kb"` // per run; 0 = unlimited
+	MaxEvents    int    `yaml:"max_events"`
+	MaxEventKB   int    `yaml:"max_event_kb"`
+	OutputAction string `yaml:"output_action"` // kill | throttle
+
+	EventSchemaAction string `yaml:"event_schema_action"` // flag | reject | off, for events failing the module's void.manifest (evschema.go)
+
+	EventQueue     int           `yaml:"event_queue"`
+	EventBatch     int           `yaml:"event_batch"`
+	EventFlush     time.Duration `yaml:"event_flush"`
+	EventRetries   int           `yaml:"event_retries"`
+	EventBatchPost string        `yaml:"event_batch_post"`
+	EventSpillDir  string        `yaml:"event_spill_dir"`
+	EventEncoding  string        `yaml:"event_encoding"` // json | cbor
+
+	CloudEvents       bool   `yaml:"cloudevents"`        // wrap events in a CloudEvents 1.0 envelope
+	CloudEventsSource string `yaml:"cloudevents_source"` // "" = /void-wasm-exec/<node_id>
+
+	EventSink    string `yaml:"event_sink"` // relay | grpc
+	EventGRPC    string `yaml:"event_grpc"` // host:port serving void.events.v1.EventSink
+	EventGRPCTLS bool   `yaml:"event_grpc_tls"`
+
+	NATSURL       string `yaml:"nats_url"`     // "" = off; run events also published here
+	NATSSubject   string `yaml:"nats_subject"` // prefix; subject = prefix.<event type>
+	NATSCreds     string `yaml:"nats_creds"`   // .creds file
+	NATSJetStream bool   `yaml:"nats_jetstream"`
+	NATSStream    string `yaml:"nats_stream"` // created over prefix.> if missing; "" = must exist
+
+	S3Endpoint     string `yaml:"s3_endpoint"` // "" = AWS (virtual-hosted); MinIO e.g. http://minio:9000 (path-style)
+	S3Region       string `yaml:"s3_region"`
+	S3AccessKey    string `yaml:"s3_access_key"` // "" = anonymous
+	S3SecretKey    string `yaml:"s3_secret_key"`
+	S3SessionToken string `yaml:"-"`             // AWS_SESSION_TOKEN only
+	S3KMSKeyID     string `yaml:"s3_kms_key_id"` // SSE-KMS for artifact uploads
+
+	SharedCache string `yaml:"shared_cache"` // s3://bucket/prefix second cache tier keyed by sha256 (sharedcache.go); "" = off
+	ArtifactsURL   string `yaml:"artifacts_url"` // relay | s3://bucket/prefix; "" = no uploads
+	ArtifactMaxMB  int    `yaml:"artifact_max_mb"` // per run
+	InputRefMaxMB  int    `yaml:"input_ref_max_mb"` // per input reference (inputs.go)
+	ArtifactPost   string `yaml:"artifact_post"` // artifacts_url relay
+
+	IPFSPublish string `yaml:"ipfs_publish"` // "" | kubo | gateway: receipts + artifacts, CIDs in wasm.result
+	IPFSAPI     string `yaml:"ipfs_api"`     // Kubo RPC
+	IPFSPin     bool   `yaml:"ipfs_pin"`
+
+	Webhooks []Webhook `yaml:"webhooks"` // result notifications (webhook.go)
+
+	Attest            string `yaml:"attest"`     // "" | relay | oci://registry/repo
+	AttestKey         string `yaml:"attest_key"` // ed25519 PKCS#8 PEM; "" = <cache_dir>/attest.key
+	AttestOCIUser     string `yaml:"attest_oci_user"`
+	AttestOCIPassword string `yaml:"attest_oci_password"`
+
+	IdentityKey string `yaml:"identity_key"` // file:/path | kms:<key>; signs receipts (identity.go); restart to change
+	KMSRegion   string `yaml:"kms_region"`   // "" = s3_region
+	SignEvents  bool   `yaml:"sign_events"`  // sign event deliveries as well
+	RunTokenHosts  []string      `yaml:"run_token_hosts"`  // hosts module requests to get a signed run token (runtoken.go)
+	RunTokenTTL    time.Duration `yaml:"run_token_ttl"`
+	RunTokenHeader string        `yaml:"run_token_header"`
+
+	RelayTimeout    time.Duration `yaml:"relay_timeout"` // event POSTs; logs get +2s
+	FetchTimeout    time.Duration `yaml:"fetch_timeout"` // module downloads
+	HTTPTimeout     time.Duration `yaml:"http_timeout"`  // http.fetch syscalls
+	HTTPIdlePerHost int           `yaml:"http_idle_per_host"`
+	HTTPIdleTimeout time.Duration `yaml:"http_idle_timeout"`
+
+	DNSCache       bool          `yaml:"dns_cache"`    // caching resolver for fetch and http.fetch (dnscache.go)
+	DNSUpstream    []string      `yaml:"dns_upstream"` // host[:port]; empty = system resolver
+	DNSMinTTL      time.Duration `yaml:"dns_min_ttl"`
+	DNSMaxTTL      time.Duration `yaml:"dns_max_ttl"`
+	DNSNegativeTTL time.Duration `yaml:"dns_negative_ttl"`
+
+	HTTPCache        bool          `yaml:"http_cache"`         // http.fetch GET response cache, per module (httpcache.go)
+	HTTPCacheEntries int           `yaml:"http_cache_entries"` // per tenant+module partition
+	HTTPCacheMaxTTL  time.Duration `yaml:"http_cache_max_ttl"`
+
+	GraphQL              map[string]GraphQLEndpoint `yaml:"graphql_endpoints"` // name -> endpoint for syscall.graphql (graphql.go)
+	GraphQLMaxQueryKB    int                        `yaml:"graphql_max_query_kb"`
+	GraphQLMaxResponseKB int                        `yaml:"graphql_max_response_kb"`
+	AI                   map[string]AIEndpoint      `yaml:"ai_endpoints"` // name -> endpoint for syscall.ai.infer (ai.go)
+	AIMaxPromptKB        int                        `yaml:"ai_max_prompt_kb"`
+	AIMaxResponseKB      int                        `yaml:"ai_max_response_kb"`
+	AIMaxTokens          int                        `yaml:"ai_max_tokens"`
+	AIMaxRunTokens       int                        `yaml:"ai_max_run_tokens"` // 0 = no cap
+	WS                   map[string]WSEndpoint      `yaml:"ws_endpoints"` // name -> endpoint for reactor sessions (ws.go)
+	WSMaxFrameKB         int                        `yaml:"ws_max_frame_kb"`
+	WSMaxRate            int                        `yaml:"ws_max_rate"` // messages/s each way
+
+	Targets    []string `yaml:"targets"`     // envelope variants this node runs, preferred first (targets.go)
+	TargetOpts []string `yaml:"target_opts"` // opt levels, preferred first
+
+	CosignVerify bool   `yaml:"cosign_verify"`
+	OPABase      string `yaml:"opa_base"`     // OPA the security executor asks; simulate queries it too; "" = off
+	OPADecision  string `yaml:"opa_decision"` // decision path, as OPA_DECISION there
+	DryRun       bool   `yaml:"dry_run"`
+
+	Chaos            bool          `yaml:"chaos"` // test only: fault injection
+	ChaosDownload    float64       `yaml:"chaos_download"`
+	ChaosTrap        float64       `yaml:"chaos_trap"`
+	ChaosSlowSyscall float64       `yaml:"chaos_slow_syscall"`
+	ChaosSlowDelay   time.Duration `yaml:"chaos_slow_delay"`
+
+	LogSink  string        `yaml:"log_sink"` // "", loki, otlp
+	LogURL   string        `yaml:"log_url"`
+	LogBatch int           `yaml:"log_batch"`
+	LogFlush time.Duration `yaml:"log_flush"`
+
+	SLOErrorTarget float64       `yaml:"slo_error_target"` // 0 disables
+	SLOP95         time.Duration `yaml:"slo_p95"`
+	SLOBurnRate    float64       `yaml:"slo_burn_rate"`
+	SLOShortWindow time.Duration `yaml:"slo_short_window"`
+	SLOLongWindow  time.Duration `yaml:"slo_long_window"`
+	SLOMinRuns     int           `yaml:"slo_min_runs"`
+	SLOEvalEvery   time.Duration `yaml:"slo_eval_every"`
+	SLORenotify    time.Duration `yaml:"slo_renotify"`
+
+	GrafanaURL       string   `yaml:"grafana_url"`       // annotations (grafana.go); "" = off
+	GrafanaToken     string   `yaml:"grafana_token"`     // service account token
+	GrafanaDashboard string   `yaml:"grafana_dashboard"` // dashboard UID; "" = org-wide
+	GrafanaTags      []string `yaml:"grafana_tags"`
+
+	Maintenance []MaintWindow `yaml:"maintenance"`
+	MaintTZ     string        `yaml:"maintenance_tz"`
+	AuditLog    string        `yaml:"audit_log"` // "" = stdout only
+	RecordDir   string        `yaml:"record_dir"` // "" = recording off
+	RecordMaxMB int           `yaml:"record_max_mb"` // 0 = unbounded
+	RecordTTL   time.Duration `yaml:"record_ttl"`    // 0 = kept until record_max_mb evicts them
+
+	CorpusFile   string   `yaml:"corpus_file"`   // anonymized envelope corpus, NDJSON (corpus.go); "" = off
+	CorpusSample float64  `yaml:"corpus_sample"` // fraction of received envelopes exported, 0..1
+	CorpusMaxMB  int      `yaml:"corpus_max_mb"` // export stops past this size
+	CorpusKeep   []string `yaml:"corpus_keep"`   // inputs/meta keys exported verbatim
+	CorpusSalt   string   `yaml:"corpus_salt"`   // pseudonym key; "" = random per start
+
+	FetchWorkers  int `yaml:"fetch_workers"`
+	PolicyWorkers int `yaml:"policy_workers"`
+	StageQueue    int `yaml:"stage_queue"` // bound of each stage channel
+
+	AdaptiveConcurrency bool          `yaml:"adaptive_concurrency"` // AIMD between adaptive_min and concurrency
+	AdaptiveMin         int           `yaml:"adaptive_min"`
+	AdaptiveMinRuns     int           `yaml:"adaptive_min_runs"`
+	AdaptiveEvery       time.Duration `yaml:"adaptive_every"`
+	AdaptiveRelayErr    float64       `yaml:"adaptive_relay_err"`
+
+	ModuleLimits map[string]ModuleLimit `yaml:"module_limits"` // module or prefix* -> max_concurrent / mutex_group
+
+	FairQueue       string             `yaml:"fair_queue"`        // off | module | tenant: weighted fair exec queue (fair.go); restart to change
+	FairWeights     map[string]float64 `yaml:"fair_weights"`      // module/tenant or prefix* -> weight, default 1
+	FairStarveAfter time.Duration      `yaml:"fair_starve_after"` // queued longer than this counts as starved
+
+	PoliciesDir string         `yaml:"policies_dir"` // per-module overrides, one *.yaml each (policies.go); "" = off
+	Policies    []ModulePolicy `yaml:"-"`            // loaded from policies_dir
+
+	Schedules       []ModuleSchedule `yaml:"schedules"`         // execution windows by module (schedule.go); policies.d schedules win
+	ScheduleHoldMax int              `yaml:"schedule_hold_max"` // envelopes held for their window at once; more are refused (scheduled_full); 0 = unlimited
+
+	Mounts map[string]MountSpec `yaml:"mounts"` // name -> host dir envelopes may mount (mounts.go)
+
+	GuestEnv map[string]GuestEnv `yaml:"guest_env"` // name -> variable envelopes may pass to the module (guestenv.go)
+	HostVars map[string]HostVar  `yaml:"host_vars"` // name -> value modules with cap env may read via void.env_get (hostvars.go)
+
+	ScratchModules []string `yaml:"scratch_modules"` // modules granted a persistent /scratch (scratch.go)
+	ScratchDir     string   `yaml:"scratch_dir"`
+	ScratchQuotaMB int      `yaml:"scratch_quota_mb"` // per tenant and module hash; 0 = unlimited
+
+	RuntimePerRun bool `yaml:"runtime_per_run"` // debug: fresh wazero runtime per envelope
+	ModuleLRU     int  `yaml:"module_lru"`      // compiled modules kept in memory; 0 = off
+	ModuleLRUMB   int  `yaml:"module_lru_mb"`
+
+	NodeID         string        `yaml:"node_id"`
+	RegisterPath   string        `yaml:"register_path"`  // POST relay_base+this to register (register.go); "" = off
+	RegisterLease  time.Duration `yaml:"register_lease"` // asked for, renewed every half; 0 = off
+	AdvertiseURL   string        `yaml:"advertise_url"`  // where the relay reaches this node's admin server
+	HeartbeatEvery time.Duration `yaml:"heartbeat"`
+	LeaderLock     string        `yaml:"leader_lock"` // shared lock file; "" = always leader
+	LeaderRetry    time.Duration `yaml:"leader_retry"`
+	ShardNodes     []string      `yaml:"shard_nodes"` // ring members (node IDs); empty = no sharding
+	ShardKey       string        `yaml:"shard_key"`   // module | envelope
+
+	NodeLabels map[string]string `yaml:"node_labels"` // matched by envelope placement tags (placement.go)
+
+	KVBackend     string `yaml:"kv_backend"`    // file | bbolt | redis | nats (kvstore.go); restart to change
+	KVBolt        string `yaml:"kv_bolt"`
+	KVRedisURL    string `yaml:"kv_redis_url"`  // redis://[:password@]host:6379/0
+	KVRedisPrefix string `yaml:"kv_redis_prefix"`
+	KVBackupKey   string `yaml:"kv_backup_key"` // AES-256 key (hex or base64) for encrypted KV snapshots
+
+	KVNATSURL      string `yaml:"kv_nats_url"`      // kv_backend nats (natskv.go); "" = nats_url
+	KVNATSBucket   string `yaml:"kv_nats_bucket"`
+	KVNATSReplicas int    `yaml:"kv_nats_replicas"` // when creating the bucket
+
+	QueueBackend      string                `yaml:"queue_backend"` // off | bbolt | nats: durable queues for syscall.queue (queue.go); restart to change
+	QueueBolt         string                `yaml:"queue_bolt"`
+	QueueNATSStream   string                `yaml:"queue_nats_stream"`   // queue_backend nats, on nats_url (queuenats.go)
+	QueueNATSReplicas int                   `yaml:"queue_nats_replicas"` // when creating the stream
+	QueueMaxDepth     int                   `yaml:"queue_max_depth"`     // messages per queue
+	QueueMaxKB        int                   `yaml:"queue_max_kb"`        // per message body
+	QueueVisibility   time.Duration         `yaml:"queue_visibility"`    // popped and not acked: delivered again after this
+	QueueLimits       map[string]QueueLimit `yaml:"queue_limits"`        // queue or prefix* -> overrides of the three above
+
+	AtRestKey string `yaml:"at_rest_key"` // file:/path | env:NAME | keyring:name; seals KV and cached modules (atrest.go)
+	ModuleKeys map[string]string `yaml:"module_keys"` // name -> key spec for encrypted module blobs (modenc.go); restart to change
+
+	IntentAddr    string        `yaml:"intent_addr"`  // built-in POST /intent/execute-wasm (intent.go); "" = off
+	IntentToken   string        `yaml:"intent_token"` // bearer for it; "" = open, loopback intent_addr only
+	IntentIdemTTL time.Duration `yaml:"intent_idempotency_ttl"`
+
+	GitHubAddr   string       `yaml:"github_addr"`   // POST /github webhook source (github.go); "" = off
+	GitHubSecret string       `yaml:"github_secret"` // webhook secret, HMAC-SHA256
+	GitHubHooks  []GitHubHook `yaml:"github_hooks"`
+
+	GitHubAPI              string   `yaml:"github_api"`               // github:// module sources (ghrelease.go)
+	GitHubToken            string   `yaml:"github_token"`             // private repos, rate limits; "" = anonymous
+	GitHubProvenance       string   `yaml:"github_provenance"`        // attestation | digest
+	GitHubTrustedWorkflows []string `yaml:"github_trusted_workflows"` // signer SAN globs; empty = the asset's own repo
+	SigstoreRoots          string   `yaml:"sigstore_roots"`           // PEM Fulcio root + intermediates
+	SigstoreRekorKey       string   `yaml:"sigstore_rekor_key"`       // PEM Rekor public key(s), for signed entry timestamps
+
+	RegistryURL  string        `yaml:"registry_url"`  // signed module index for module@version (registry.go); "" = off
+	RegistryKeys []string      `yaml:"registry_keys"` // ed25519 public key PEMs that may sign it
+	RegistryTTL  time.Duration `yaml:"registry_ttl"`  // how long an index is used before it is re-read
+
+	AdminAddr        string        `yaml:"admin_addr"`
+	AdminToken       string        `yaml:"admin_token"`
+	AdminPprof       bool          `yaml:"admin_pprof"`
+	HistoryDB        string        `yaml:"history_db"`
+	HistoryRetention time.Duration `yaml:"history_retention"`
+	LedgerDir        string        `yaml:"ledger_dir"`        // hash-chained receipts ledger (ledger.go); "" = off
+	LedgerRootEvery  time.Duration `yaml:"ledger_root_every"` // seal and publish a Merkle root
+	LedgerSegmentMB  int           `yaml:"ledger_segment_mb"` // rotate ledger.ndjson past this; 0 = never
+	TimelineMax      int           `yaml:"timeline_max"`
+	ShutdownTimeout  time.Duration `yaml:"shutdown_timeout"`
+
+	RunAs        string   `yaml:"run_as"`        // user[:group] to switch to when started as root (harden.go); restart to change
+	AllowRoot    bool     `yaml:"allow_root"`    // keep running as root; also --allow-root
+	Landlock     string   `yaml:"landlock"`      // off | on | required (Linux)
+	Seccomp      bool     `yaml:"seccomp"`       // deny exec, ptrace, mount, module loading, bpf (Linux)
+	SandboxPaths []string `yaml:"sandbox_paths"` // extra landlock paths: /path (rw) or /path:ro
+	SandboxPorts []int    `yaml:"sandbox_ports"` // extra TCP ports landlock lets the executor connect to
+}
+
+func defaultConfig() Config {
+	return Config{
+		RelayBase:        "http://localhost:8787",
+		SSEPath:          "/sse",
+		EventPost:        "/event",
+		IPFSGateway:      "https://ipfs.io",
+		CacheDir:         voidPath("wasm-cache"),
+		PromAddr:         ":9490",
+		ReadyMinFreeMB:   100,
+		Concurrency:      1,
+		FetchWorkers:     4,
+		PolicyWorkers:    2,
+		StageQueue:       100,
+		FairQueue:        "off",
+		FairStarveAfter:  30 * time.Second,
+		AdaptiveMin:      1,
+		AdaptiveMinRuns:  5,
+		AdaptiveEvery:    5 * time.Second,
+		AdaptiveRelayErr: 0.1,
+		DefaultTO:        2000 * time.Millisecond,
+		Clock:            "deterministic",
+		RandomMaxKB:      64,
+		MaxMemMB:         128,
+		AllowModules:     []string{"wasm/ci/*", "wasm/pulse/*"},
+		AllowCaps:        []string{"emit"},
+		AllowHTTPHosts:   []string{"relay", "localhost"},
+		HTTPBurst:        5,
+		HTTPRPS:          5,
+		MaxHTTPKB:        64,
+		GraphQLMaxQueryKB: 16,
+		GraphQLMaxResponseKB: 64,
+		AIMaxPromptKB: 32,
+		AIMaxResponseKB: 128,
+		AIMaxTokens: 1024,
+		WSMaxFrameKB: 64,
+		WSMaxRate: 50,
+		RunTokenTTL: 5 * time.Minute,
+		RunTokenHeader: "Void-Run-Token",
+		OPADecision: "/v1/data/void/policy/allow",
+		RegisterPath: "/nodes/register",
+		RegisterLease: time.Minute,
+		MaxStdoutKB:      1024,
+		MaxEvents:        1000,
+		MaxEventKB:       64,
+		OutputAction:     "kill",
+		EventSchemaAction: "flag",
+		EventQueue:       1000,
+		EventBatch:       50,
+		EventFlush:       200 * time.Millisecond,
+		EventRetries:     3,
+		EventEncoding:    "json",
+		EventSink:        "relay",
+		NATSSubject:      "void.events",
+		S3Region:         "us-east-1",
+		ArtifactMaxMB:    64,
+		InputRefMaxMB:    256,
+		ArtifactPost:     "/artifact",
+		ScratchDir:       voidPath("scratch"),
+		ScratchQuotaMB:   256,
+		IPFSAPI:          "http://localhost:5001",
+		IPFSPin:          true,
+		RelayTimeout:     3 * time.Second,
+		FetchTimeout:     30 * time.Second,
+		HTTPTimeout:      2 * time.Second,
+		HTTPIdlePerHost:  16,
+		HTTPIdleTimeout:  90 * time.Second,
+		DNSMinTTL:        5 * time.Second,
+		DNSMaxTTL:        5 * time.Minute,
+		DNSNegativeTTL:   30 * time.Second,
+		HTTPCacheEntries: 256,
+		HTTPCacheMaxTTL:  10 * time.Minute,
+		Targets:          []string{"wasm32-wasip1", "wasm32-wasi", "wasm32-unknown-unknown"},
+		TargetOpts:       []string{"O3", "O2", "Os", "Oz", "O1", "O0"},
+		LogBatch:         500,
+		LogFlush:         time.Second,
+		ChaosSlowDelay:   500 * time.Millisecond,
+		QuotaWindow:      time.Hour,
+		QuotaAction:      "deny",
+		QuotaDefer:       2 * time.Second,
+		EnvelopeMaxKB:    256,
+		InputsMaxKB:      128,
+		CapsMax:          32,
+		MetaMaxDepth:     8,
+		IntakeBurst:      20,
+		IntakeAction:     "shed",
+		Landlock:         "off",
+		IntakeDeferMax:   100,
+		UsageReportEvery: time.Minute,
+		SLOErrorTarget:   0.05,
+		SLOP95:           300 * time.Millisecond,
+		SLOBurnRate:      6,
+		SLOShortWindow:   5 * time.Minute,
+		SLOLongWindow:    30 * time.Minute,
+		SLOMinRuns:       20,
+		SLOEvalEvery:     30 * time.Second,
+		SLORenotify:      5 * time.Minute,
+		MaintTZ:          "UTC",
+		ScheduleHoldMax:  1000,
+		AuditLog:         voidPath("audit.ndjson"),
+		CorpusSample:     1,
+		CorpusMaxMB:      100,
+		HeartbeatEvery:   15 * time.Second,
+		LeaderRetry:      time.Second,
+		ShardKey:         "module",
+		KVBackend:        "file",
+		KVBolt:           voidPath("kv.db"),
+		KVRedisPrefix:    "void:kv:",
+		KVNATSBucket:     "void_kv",
+		KVNATSReplicas:   1,
+		QueueBackend:     "off",
+		QueueBolt:        voidPath("queue.db"),
+		QueueNATSStream:  "VOID_QUEUE",
+		QueueNATSReplicas: 1,
+		QueueMaxDepth:    10000,
+		QueueMaxKB:       64,
+		QueueVisibility:  30 * time.Second,
+		IntentIdemTTL:    10 * time.Minute,
+		GitHubAPI:        "https://api.github.com",
+		GitHubProvenance: "attestation",
+		RegistryTTL:      5 * time.Minute,
+		AdminAddr:        ":9491",
+		HistoryDB:        voidPath("history.db"),
+		HistoryRetention: 72 * time.Hour,
+		RecordMaxMB:      1024,
+		RecordTTL:        72 * time.Hour,
+		LedgerRootEvery:  10 * time.Minute,
+		LedgerSegmentMB:  64,
+		TimelineMax:      200,
+		ModuleLRU:        32,
+		ModuleLRUMB:      256,
+		ShutdownTimeout:  20 * time.Second,
+	}
+}
+
+func getenv(key, def string) string { v := os.Getenv(key); if v == "" { return def }; return v }
+
+func parseList(s string) []string {
+	out := []string{}
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p != "" { out = append(out, p) }
+	}
+	return out
+}
+
+func atoi(s string, d int) int { var n int; if _,err:=fmt.Sscanf(s,"%d",&n); err!=nil { return d }; return n }
+
+// applyEnv overrides cfg with every env var that is actually set.
+func applyEnv(cfg *Config) {
+	str := func(k string, dst *string) { if v := os.Getenv(k); v != "" { *dst = v } }
+	num := func(k string, dst *int) { if v := os.Getenv(k); v != "" { *dst = atoi(v, *dst) } }
+	list := func(k string, dst *[]string) { if v := os.Getenv(k); v != "" { *dst = parseList(v) } }
+	boolean := func(k string, dst *bool) { if v := os.Getenv(k); v != "" { *dst = v == "1" } }
+	float := func(k string, dst *float64) { if v := os.Getenv(k); v != "" { fmt.Sscanf(v, "%g", dst) } }
+	dur := func(k string, unit time.Duration, dst *time.Duration) {
+		if v := os.Getenv(k); v != "" { *dst = time.Duration(atoi(v, int(*dst/unit))) * unit }
+	}
+	str("RELAY_BASE", &cfg.RelayBase)
+	str("SSE_PATH", &cfg.SSEPath)
+	str("EVENT_POST", &cfg.EventPost)
+	str("IPFS_GATEWAY", &cfg.IPFSGateway)
+	str("CACHE_DIR", &cfg.CacheDir)
+	str("PROM_ADDR", &cfg.PromAddr)
+	num("READY_MIN_FREE_MB", &cfg.ReadyMinFreeMB)
+	boolean("NATIVE_HISTOGRAMS", &cfg.NativeHistograms)
+	boolean("STRICT_ENVELOPES", &cfg.StrictEnvelopes)
+	num("ENVELOPE_MAX_KB", &cfg.EnvelopeMaxKB)
+	num("INPUTS_MAX_KB", &cfg.InputsMaxKB)
+	num("CAPS_MAX", &cfg.CapsMax)
+	num("META_MAX_DEPTH", &cfg.MetaMaxDepth)
+	num("CONCURRENCY", &cfg.Concurrency)
+	num("FETCH_WORKERS", &cfg.FetchWorkers)
+	num("POLICY_WORKERS", &cfg.PolicyWorkers)
+	num("STAGE_QUEUE", &cfg.StageQueue)
+	str("FAIR_QUEUE", &cfg.FairQueue)
+	dur("FAIR_STARVE_AFTER_S", time.Second, &cfg.FairStarveAfter)
+	boolean("ADAPTIVE_CONCURRENCY", &cfg.AdaptiveConcurrency)
+	num("ADAPTIVE_MIN", &cfg.AdaptiveMin)
+	num("ADAPTIVE_MIN_RUNS", &cfg.AdaptiveMinRuns)
+	dur("ADAPTIVE_EVERY_MS", time.Millisecond, &cfg.AdaptiveEvery)
+	float("ADAPTIVE_RELAY_ERR", &cfg.AdaptiveRelayErr)
+	num("CANARY_PERCENT", &cfg.CanaryPercent)
+	str("CANARY_ENGINE", &cfg.CanaryEngine)
+	if v := os.Getenv("CANARY_MEM_MB"); v != "" { cfg.CanaryMemMB = uint32(atoi(v, int(cfg.CanaryMemMB))) }
+	dur("TIMEOUT_MS", time.Millisecond, &cfg.DefaultTO)
+	num("SOFT_TIMEOUT_PCT", &cfg.SoftTimeoutPct)
+	str("CLOCK", &cfg.Clock)
+	num("RANDOM_MAX_KB", &cfg.RandomMaxKB)
+	if v := os.Getenv("MEM_MB"); v != "" { cfg.MaxMemMB = uint32(atoi(v, int(cfg.MaxMemMB))) }
+	num("CACHE_MAX_MB", &cfg.CacheMaxMB)
+	dur("QUOTA_WINDOW_S", time.Second, &cfg.QuotaWindow)
+	if v := os.Getenv("QUOTA_RUNS"); v != "" { cfg.QuotaRuns = int64(atoi(v, int(cfg.QuotaRuns))) }
+	dur("QUOTA_RUN_TIME_MS", time.Millisecond, &cfg.QuotaRunTime)
+	if v := os.Getenv("QUOTA_NET_MB"); v != "" { cfg.QuotaNetMB = int64(atoi(v, int(cfg.QuotaNetMB))) }
+	str("QUOTA_ACTION", &cfg.QuotaAction)
+	dur("QUOTA_DEFER_MS", time.Millisecond, &cfg.QuotaDefer)
+	num("INTAKE_RPS", &cfg.IntakeRPS)
+	num("INTAKE_BURST", &cfg.IntakeBurst)
+	str("INTAKE_ACTION", &cfg.IntakeAction)
+	str("RUN_AS", &cfg.RunAs)
+	boolean("ALLOW_ROOT", &cfg.AllowRoot)
+	str("LANDLOCK", &cfg.Landlock)
+	boolean("SECCOMP", &cfg.Seccomp)
+	list("SANDBOX_PATHS", &cfg.SandboxPaths)
+	if v := os.Getenv("SANDBOX_PORTS"); v != "" {
+		cfg.SandboxPorts = nil
+		for _, p := range parseList(v) { cfg.SandboxPorts = append(cfg.SandboxPorts, atoi(p, 0)) }
+	}
+	num("INTAKE_DEFER_MAX", &cfg.IntakeDeferMax)
+	dur("USAGE_REPORT_S", time.Second, &cfg.UsageReportEvery)
+	list("ALLOW_MODULES", &cfg.AllowModules)
+	list("ALLOW_CAPS", &cfg.AllowCaps)
+	list("ALLOW_HTTP_HOSTS", &cfg.AllowHTTPHosts)
+	list("CONTROL_KEYS", &cfg.ControlKeys)
+	list("SCRATCH_MODULES", &cfg.ScratchModules)
+	str("SCRATCH_DIR", &cfg.ScratchDir)
+	str("POLICIES_DIR", &cfg.PoliciesDir)
+	str("KV_BACKEND", &cfg.KVBackend)
+	str("KV_BOLT", &cfg.KVBolt)
+	str("KV_REDIS_URL", &cfg.KVRedisURL)
+	str("KV_REDIS_PREFIX", &cfg.KVRedisPrefix)
+	str("KV_BACKUP_KEY", &cfg.KVBackupKey)
+	str("KV_NATS_URL", &cfg.KVNATSURL)
+	str("KV_NATS_BUCKET", &cfg.KVNATSBucket)
+	num("KV_NATS_REPLICAS", &cfg.KVNATSReplicas)
+	str("QUEUE_BACKEND", &cfg.QueueBackend)
+	str("QUEUE_BOLT", &cfg.QueueBolt)
+	str("QUEUE_NATS_STREAM", &cfg.QueueNATSStream)
+	num("QUEUE_NATS_REPLICAS", &cfg.QueueNATSReplicas)
+	num("QUEUE_MAX_DEPTH", &cfg.QueueMaxDepth)
+	num("QUEUE_MAX_KB", &cfg.QueueMaxKB)
+	dur("QUEUE_VISIBILITY_S", time.Second, &cfg.QueueVisibility)
+	str("AT_REST_KEY", &cfg.AtRestKey)
+	if v := os.Getenv("MODULE_KEYS"); v != "" { cfg.ModuleKeys = parseLabels(v) }
+	num("SCRATCH_QUOTA_MB", &cfg.ScratchQuotaMB)
+	num("HTTP_BURST", &cfg.HTTPBurst)
+	num("HTTP_RPS", &cfg.HTTPRPS)
+	num("HTTP_MAX_KB", &cfg.MaxHTTPKB)
+	num("GRAPHQL_MAX_QUERY_KB", &cfg.GraphQLMaxQueryKB)
+	num("GRAPHQL_MAX_RESPONSE_KB", &cfg.GraphQLMaxResponseKB)
+	num("AI_MAX_PROMPT_KB", &cfg.AIMaxPromptKB)
+	num("AI_MAX_RESPONSE_KB", &cfg.AIMaxResponseKB)
+	num("AI_MAX_TOKENS", &cfg.AIMaxTokens)
+	num("AI_MAX_RUN_TOKENS", &cfg.AIMaxRunTokens)
+	num("WS_MAX_FRAME_KB", &cfg.WSMaxFrameKB)
+	num("WS_MAX_RATE", &cfg.WSMaxRate)
+	num("MAX_STDOUT_KB", &cfg.MaxStdoutKB)
+	num("MAX_EVENTS", &cfg.MaxEvents)
+	num("MAX_EVENT_KB", &cfg.MaxEventKB)
+	str("OUTPUT_ACTION", &cfg.OutputAction)
+	str("EVENT_SCHEMA_ACTION", &cfg.EventSchemaAction)
+	num("EVENT_QUEUE", &cfg.EventQueue)
+	num("EVENT_BATCH", &cfg.EventBatch)
+	dur("EVENT_FLUSH_MS", time.Millisecond, &cfg.EventFlush)
+	num("EVENT_RETRIES", &cfg.EventRetries)
+	str("EVENT_BATCH_POST", &cfg.EventBatchPost)
+	str("EVENT_SPILL_DIR", &cfg.EventSpillDir)
+	str("EVENT_ENCODING", &cfg.EventEncoding)
+	boolean("CLOUDEVENTS", &cfg.CloudEvents)
+	str("CLOUDEVENTS_SOURCE", &cfg.CloudEventsSource)
+	str("EVENT_SINK", &cfg.EventSink)
+	str("EVENT_GRPC", &cfg.EventGRPC)
+	boolean("EVENT_GRPC_TLS", &cfg.EventGRPCTLS)
+	str("NATS_URL", &cfg.NATSURL)
+	str("NATS_SUBJECT", &cfg.NATSSubject)
+	str("NATS_CREDS", &cfg.NATSCreds)
+	boolean("NATS_JETSTREAM", &cfg.NATSJetStream)
+	str("NATS_STREAM", &cfg.NATSStream)
+	str("S3_ENDPOINT", &cfg.S3Endpoint)
+	str("AWS_REGION", &cfg.S3Region)
+	str("S3_REGION", &cfg.S3Region)
+	str("AWS_ACCESS_KEY_ID", &cfg.S3AccessKey)
+	str("AWS_SECRET_ACCESS_KEY", &cfg.S3SecretKey)
+	str("AWS_SESSION_TOKEN", &cfg.S3SessionToken)
+	str("S3_KMS_KEY_ID", &cfg.S3KMSKeyID)
+	str("SHARED_CACHE", &cfg.SharedCache)
+	str("ARTIFACTS_URL", &cfg.ArtifactsURL)
+	num("ARTIFACT_MAX_MB", &cfg.ArtifactMaxMB)
+	num("INPUT_REF_MAX_MB", &cfg.InputRefMaxMB)
+	str("ARTIFACT_POST", &cfg.ArtifactPost)
+	str("IPFS_PUBLISH", &cfg.IPFSPublish)
+	str("IPFS_API", &cfg.IPFSAPI)
+	boolean("IPFS_PIN", &cfg.IPFSPin)
+	str("ATTEST", &cfg.Attest)
+	str("ATTEST_KEY", &cfg.AttestKey)
+	str("ATTEST_OCI_USER", &cfg.AttestOCIUser)
+	str("ATTEST_OCI_PASSWORD", &cfg.AttestOCIPassword)
+	str("IDENTITY_KEY", &cfg.IdentityKey)
+	str("KMS_REGION", &cfg.KMSRegion)
+	boolean("SIGN_EVENTS", &cfg.SignEvents)
+	list("RUN_TOKEN_HOSTS", &cfg.RunTokenHosts)
+	dur("RUN_TOKEN_TTL", time.Second, &cfg.RunTokenTTL)
+	str("RUN_TOKEN_HEADER", &cfg.RunTokenHeader)
+	dur("RELAY_TIMEOUT_MS", time.Millisecond, &cfg.RelayTimeout)
+	dur("FETCH_TIMEOUT_MS", time.Millisecond, &cfg.FetchTimeout)
+	dur("HTTP_TIMEOUT_MS", time.Millisecond, &cfg.HTTPTimeout)
+	num("HTTP_IDLE_PER_HOST", &cfg.HTTPIdlePerHost)
+	dur("HTTP_IDLE_TIMEOUT_S", time.Second, &cfg.HTTPIdleTimeout)
+	boolean("DNS_CACHE", &cfg.DNSCache)
+	list("DNS_UPSTREAM", &cfg.DNSUpstream)
+	dur("DNS_MIN_TTL_S", time.Second, &cfg.DNSMinTTL)
+	dur("DNS_MAX_TTL_S", time.Second, &cfg.DNSMaxTTL)
+	dur("DNS_NEGATIVE_TTL_S", time.Second, &cfg.DNSNegativeTTL)
+	boolean("HTTP_CACHE", &cfg.HTTPCache)
+	num("HTTP_CACHE_ENTRIES", &cfg.HTTPCacheEntries)
+	dur("HTTP_CACHE_MAX_TTL_S", time.Second, &cfg.HTTPCacheMaxTTL)
+	list("TARGETS", &cfg.Targets)
+	list("TARGET_OPTS", &cfg.TargetOpts)
+	boolean("COSIGN_VERIFY", &cfg.CosignVerify)
+	str("OPA_BASE", &cfg.OPABase)
+	str("OPA_DECISION", &cfg.OPADecision)
+	boolean("WASM_DRYRUN", &cfg.DryRun)
+	boolean("CHAOS", &cfg.Chaos)
+	float("CHAOS_DOWNLOAD", &cfg.ChaosDownload)
+	float("CHAOS_TRAP", &cfg.ChaosTrap)
+	float("CHAOS_SLOW_SYSCALL", &cfg.ChaosSlowSyscall)
+	dur("CHAOS_SLOW_DELAY_MS", time.Millisecond, &cfg.ChaosSlowDelay)
+	str("LOG_SINK", &cfg.LogSink)
+	str("LOG_URL", &cfg.LogURL)
+	num("LOG_BATCH", &cfg.LogBatch)
+	dur("LOG_FLUSH_MS", time.Millisecond, &cfg.LogFlush)
+	if v := os.Getenv("SLO_ERROR_TARGET"); v != "" { fmt.Sscanf(v, "%g", &cfg.SLOErrorTarget) }
+	dur("SLO_P95_MS", time.Millisecond, &cfg.SLOP95)
+	if v := os.Getenv("SLO_BURN_RATE"); v != "" { fmt.Sscanf(v, "%g", &cfg.SLOBurnRate) }
+	num("SLO_MIN_RUNS", &cfg.SLOMinRuns)
+	str("GRAFANA_URL", &cfg.GrafanaURL)
+	str("GRAFANA_TOKEN", &cfg.GrafanaToken)
+	str("GRAFANA_DASHBOARD", &cfg.GrafanaDashboard)
+	list("GRAFANA_TAGS", &cfg.GrafanaTags)
+	str("MAINTENANCE_TZ", &cfg.MaintTZ)
+	num("SCHEDULE_HOLD_MAX", &cfg.ScheduleHoldMax)
+	str("AUDIT_LOG", &cfg.AuditLog)
+	str("RECORD_DIR", &cfg.RecordDir)
+	num("RECORD_MAX_MB", &cfg.RecordMaxMB)
+	dur("RECORD_TTL_H", time.Hour, &cfg.RecordTTL)
+	str("CORPUS_FILE", &cfg.CorpusFile)
+	float("CORPUS_SAMPLE", &cfg.CorpusSample)
+	num("CORPUS_MAX_MB", &cfg.CorpusMaxMB)
+	list("CORPUS_KEEP", &cfg.CorpusKeep)
+	str("CORPUS_SALT", &cfg.CorpusSalt)
+	boolean("RUNTIME_PER_RUN", &cfg.RuntimePerRun)
+	num("MODULE_LRU", &cfg.ModuleLRU)
+	num("MODULE_LRU_MB", &cfg.ModuleLRUMB)
+	str("NODE_ID", &cfg.NodeID)
+	str("REGISTER_PATH", &cfg.RegisterPath)
+	dur("REGISTER_LEASE", time.Second, &cfg.RegisterLease)
+	str("ADVERTISE_URL", &cfg.AdvertiseURL)
+	if v := os.Getenv("NODE_LABELS"); v != "" { cfg.NodeLabels = parseLabels(v) }
+	dur("HEARTBEAT_S", time.Second, &cfg.HeartbeatEvery)
+	str("LEADER_LOCK", &cfg.LeaderLock)
+	dur("LEADER_RETRY_MS", time.Millisecond, &cfg.LeaderRetry)
+	list("SHARD_NODES", &cfg.ShardNodes)
+	str("SHARD_KEY", &cfg.ShardKey)
+	str("INTENT_ADDR", &cfg.IntentAddr)
+	str("INTENT_TOKEN", &cfg.IntentToken)
+	dur("INTENT_IDEMPOTENCY_TTL_S", time.Second, &cfg.IntentIdemTTL)
+	str("GITHUB_ADDR", &cfg.GitHubAddr)
+	str("GITHUB_SECRET", &cfg.GitHubSecret)
+	str("GITHUB_API", &cfg.GitHubAPI)
+	str("GITHUB_TOKEN", &cfg.GitHubToken)
+	str("GITHUB_PROVENANCE", &cfg.GitHubProvenance)
+	list("GITHUB_TRUSTED_WORKFLOWS", &cfg.GitHubTrustedWorkflows)
+	str("SIGSTORE_ROOTS", &cfg.SigstoreRoots)
+	str("SIGSTORE_REKOR_KEY", &cfg.SigstoreRekorKey)
+	str("REGISTRY_URL", &cfg.RegistryURL)
+	list("REGISTRY_KEYS", &cfg.RegistryKeys)
+	dur("REGISTRY_TTL_S", time.Second, &cfg.RegistryTTL)
+	str("ADMIN_ADDR", &cfg.AdminAddr)
+	str("ADMIN_TOKEN", &cfg.AdminToken)
+	boolean("ADMIN_PPROF", &cfg.AdminPprof)
+	str("HISTORY_DB", &cfg.HistoryDB)
+	dur("HISTORY_RETENTION_H", time.Hour, &cfg.HistoryRetention)
+	str("LEDGER_DIR", &cfg.LedgerDir)
+	dur("LEDGER_ROOT_EVERY_S", time.Second, &cfg.LedgerRootEvery)
+	num("LEDGER_SEGMENT_MB", &cfg.LedgerSegmentMB)
+	num("TIMELINE_MAX", &cfg.TimelineMax)
+	dur("SHUTDOWN_TIMEOUT_MS", time.Millisecond, &cfg.ShutdownTimeout)
+}
+
+func (c Config) validate() error {
+	var errs []error
+	if u, err := url.Parse(c.RelayBase); err != nil || u.Scheme == "" || u.Host == "" {
+		errs = append(errs, fmt.Errorf("relay_base: invalid url %q", c.RelayBase))
+	}
+	if c.Concurrency < 1 { errs = append(errs, errors.New("concurrency: must be >= 1")) }
+	if c.AdaptiveConcurrency && (c.AdaptiveMin < 1 || c.AdaptiveMin > c.Concurrency || c.AdaptiveEvery <= 0 || c.SLOP95 <= 0) { errs = append(errs, errors.New("adaptive: 1 <= adaptive_min <= concurrency, adaptive_every > 0, slo_p95 > 0")) }
+	if c.FetchWorkers < 1 || c.PolicyWorkers < 1 || c.StageQueue < 1 { errs = append(errs, errors.New("pipeline: fetch_workers, policy_workers and stage_queue must be >= 1")) }
+	if c.DefaultTO <= 0 { errs = append(errs, errors.New("timeout: must be > 0")) }
+	if c.SoftTimeoutPct < 0 || c.SoftTimeoutPct > 99 { errs = append(errs, errors.New("soft_timeout_pct: must be 0..99")) }
+	if err := validClock(c.Clock); err != nil { errs = append(errs, err) }
+	if c.RandomMaxKB < 0 { errs = append(errs, errors.New("random_max_kb: must be >= 0")) }
+	if c.MaxMemMB == 0 { errs = append(errs, errors.New("mem_mb: must be > 0")) }
+	if c.CanaryPercent < 0 || c.CanaryPercent > 100 { errs = append(errs, errors.New("canary_percent: must be 0..100")) }
+	if c.CanaryEngine != "" && c.CanaryEngine != "interpreter" && c.CanaryEngine != "compiler" { errs = append(errs, fmt.Errorf("canary_engine: unknown %q", c.CanaryEngine)) }
+	if c.HTTPRPS < 0 || c.HTTPBurst < 0 || c.MaxHTTPKB < 0 { errs = append(errs, errors.New("http limits: must be >= 0")) }
+	if c.MaxStdoutKB < 0 || c.MaxEvents < 0 || c.MaxEventKB < 0 { errs = append(errs, errors.New("output limits: must be >= 0")) }
+	if c.OutputAction != "kill" && c.OutputAction != "throttle" { errs = append(errs, fmt.Errorf("output_action: must be kill or throttle, got %q", c.OutputAction)) }
+	if c.EventSchemaAction != "flag" && c.EventSchemaAction != "reject" && c.EventSchemaAction != "off" { errs = append(errs, fmt.Errorf("event_schema_action: must be flag, reject or off, got %q", c.EventSchemaAction)) }
+	if c.EventQueue < 1 || c.EventBatch < 1 || c.EventFlush <= 0 || c.EventRetries < 0 { errs = append(errs, errors.New("event pipeline: queue/batch >= 1, flush > 0, retries >= 0")) }
+	if c.EventEncoding != "json" && c.EventEncoding != "cbor" { errs = append(errs, fmt.Errorf("event_encoding: must be json or cbor, got %q", c.EventEncoding)) }
+	if c.EventSink != "relay" && c.EventSink != "grpc" { errs = append(errs, fmt.Errorf("event_sink: must be relay or grpc, got %q", c.EventSink)) }
+	if c.EventSink == "grpc" && c.EventGRPC == "" { errs = append(errs, errors.New("event_grpc: required with event_sink grpc")) }
+	if c.NATSURL != "" && (c.NATSSubject == "" || strings.ContainsAny(c.NATSSubject, " *>") || strings.HasPrefix(c.NATSSubject, ".") || strings.HasSuffix(c.NATSSubject, ".")) { errs = append(errs, fmt.Errorf("nats_subject: invalid subject prefix %q", c.NATSSubject)) }
+	if c.NATSStream != "" && !c.NATSJetStream { errs = append(errs, errors.New("nats_stream: needs nats_jetstream")) }
+	if (c.S3AccessKey == "") != (c.S3SecretKey == "") { errs = append(errs, errors.New("s3: s3_access_key and s3_secret_key go together")) }
+	if c.S3Endpoint != "" {
+		if u, err := url.Parse(c.S3Endpoint); err != nil || u.Scheme == "" || u.Host == "" { errs = append(errs, fmt.Errorf("s3_endpoint: invalid url %q", c.S3Endpoint)) }
+	}
+	if c.SharedCache != "" {
+		if _, _, err := parseS3(c.SharedCache); err != nil { errs = append(errs, fmt.Errorf("shared_cache: %w", err)) }
+	}
+	if c.ArtifactsURL != "" && c.ArtifactsURL != "relay" {
+		if _, _, err := parseS3(c.ArtifactsURL); err != nil { errs = append(errs, fmt.Errorf("artifacts_url: %w", err)) }
+	}
+	if c.IPFSPublish != "" && c.IPFSPublish != "kubo" && c.IPFSPublish != "gateway" { errs = append(errs, fmt.Errorf("ipfs_publish: must be kubo or gateway, got %q", c.IPFSPublish)) }
+	if c.IPFSPublish == "kubo" {
+		if u, err := url.Parse(c.IPFSAPI); err != nil || u.Scheme == "" || u.Host == "" { errs = append(errs, fmt.Errorf("ipfs_api: invalid url %q", c.IPFSAPI)) }
+	}
+	for i, h := range c.Webhooks {
+		if u, err := url.Parse(h.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" { errs = append(errs, fmt.Errorf("webhooks[%d]: invalid url", i)) }
+		if h.Template != "" {
+			if _, err := webhookTemplate(h.Template); err != nil { errs = append(errs, fmt.Errorf("webhooks[%d]: template: %w", i, err)) }
+		}
+	}
+	if c.Attest != "" && c.Attest != "relay" {
+		if _, err := parseOCI(c.Attest); err != nil || !strings.HasPrefix(c.Attest, "oci://") { errs = append(errs, fmt.Errorf("attest: must be relay or oci://registry/repo, got %q", c.Attest)) }
+	}
+	if c.ArtifactMaxMB < 0 { errs = append(errs, errors.New("artifact_max_mb: must be >= 0")) }
+	if c.InputRefMaxMB < 1 { errs = append(errs, errors.New("input_ref_max_mb: must be >= 1")) }
+	if c.CloudEventsSource != "" {
+		if _, err := url.Parse(c.CloudEventsSource); err != nil { errs = append(errs, fmt.Errorf("cloudevents_source: %w", err)) }
+	}
+	if c.DNSMinTTL < 0 || c.DNSMaxTTL < c.DNSMinTTL || c.DNSNegativeTTL < 0 { errs = append(errs, errors.New("dns: 0 <= dns_min_ttl <= dns_max_ttl, dns_negative_ttl >= 0")) }
+	if c.HTTPCache && (c.HTTPCacheEntries < 1 || c.HTTPCacheMaxTTL <= 0) { errs = append(errs, errors.New("http_cache: http_cache_entries >= 1, http_cache_max_ttl > 0")) }
+	if c.RelayTimeout <= 0 || c.FetchTimeout <= 0 || c.HTTPTimeout <= 0 || c.HTTPIdlePerHost < 1 || c.HTTPIdleTimeout <= 0 { errs = append(errs, errors.New("http clients: timeouts > 0, http_idle_per_host >= 1")) }
+	if c.LogSink != "" && (c.LogURL == "" || c.LogBatch < 1 || c.LogFlush <= 0) { errs = append(errs, errors.New("log shipping: log_url, log_batch >= 1 and log_flush > 0 required")) }
+	if c.GrafanaURL != "" {
+		if u, err := url.Parse(c.GrafanaURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") { errs = append(errs, fmt.Errorf("grafana_url: invalid url %q", c.GrafanaURL)) }
+		if c.GrafanaToken == "" { errs = append(errs, errors.New("grafana_token: required with grafana_url")) }
+	}
+	if c.SLOErrorTarget > 0 && (c.SLOShortWindow <= 0 || c.SLOLongWindow < c.SLOShortWindow || c.SLOEvalEvery <= 0) { errs = append(errs, errors.New("slo: windows must be > 0 with long >= short, eval_every > 0")) }
+	if err := validGitHubHooks(c); err != nil { errs = append(errs, err) }
+	if err := validGitHubSources(c); err != nil { errs = append(errs, err) }
+	if err := validRegistry(c); err != nil { errs = append(errs, err) }
+	if err := validIdentityKey(c.IdentityKey); err != nil { errs = append(errs, fmt.Errorf("identity_key: %w", err)) }
+	if err := validRunTokens(c); err != nil { errs = append(errs, err) }
+	if err := validRegister(c); err != nil { errs = append(errs, err) }
+	if err := validLedger(c); err != nil { errs = append(errs, err) }
+	if strings.HasPrefix(c.IdentityKey, "kms:") && c.S3AccessKey == "" { errs = append(errs, errors.New("identity_key: kms: needs s3_access_key / AWS_ACCESS_KEY_ID")) }
+	if err := validModuleKeys(c.ModuleKeys); err != nil { errs = append(errs, fmt.Errorf("module_keys: %w", err)) }
+	if err := validTargets(c.Targets); err != nil { errs = append(errs, fmt.Errorf("targets: %w", err)) }
+	if err := validLabels(c.NodeLabels); err != nil { errs = append(errs, fmt.Errorf("node_labels: %w", err)) }
+	switch c.KVBackend {
+	case "file":
+	case "bbolt":
+		if c.KVBolt == "" { errs = append(errs, errors.New("kv_bolt: required for kv_backend bbolt")) }
+	case "redis":
+		if c.KVRedisURL == "" { errs = append(errs, errors.New("kv_redis_url: required for kv_backend redis")) }
+	case "nats":
+		if c.KVNATSURL == "" && c.NATSURL == "" { errs = append(errs, errors.New("kv_nats_url: required for kv_backend nats (or nats_url)")) }
+		if !natsBucket.MatchString(c.KVNATSBucket) || c.KVNATSReplicas < 1 || c.KVNATSReplicas > 5 { errs = append(errs, errors.New("kv_nats_bucket: [A-Za-z0-9_-]+, kv_nats_replicas 1..5")) }
+	default:
+		errs = append(errs, fmt.Errorf("kv_backend: must be file, bbolt, redis or nats, got %q", c.KVBackend))
+	}
+	if c.IntentIdemTTL < time.Second { errs = append(errs, errors.New("intent_idempotency_ttl: at least 1s")) }
+	if c.IntentAddr != "" && c.IntentToken == "" && !loopbackAddr(c.IntentAddr) { errs = append(errs, fmt.Errorf("intent_token: required when intent_addr %q is not a loopback address", c.IntentAddr)) }
+	if k, _, _ := strings.Cut(c.AtRestKey, ":"); c.AtRestKey != "" && k != "file" && k != "env" && k != "keyring" { errs = append(errs, fmt.Errorf("at_rest_key: want file:, env: or keyring:, got %q", c.AtRestKey)) }
+	if c.KVBackupKey != "" {
+		if _, err := backupKey(c.KVBackupKey); err != nil { errs = append(errs, fmt.Errorf("kv_backup_key: %w", err)) }
+	}
+	if c.LeaderLock != "" && c.LeaderRetry <= 0 { errs = append(errs, errors.New("leader_retry: must be > 0")) }
+	if c.ShardKey != "module" && c.ShardKey != "envelope" { errs = append(errs, fmt.Errorf("shard_key: must be module or envelope, got %q", c.ShardKey)) }
+	if len(c.ShardNodes) > 0 && !slices.Contains(c.ShardNodes, nodeID(c)) { errs = append(errs, fmt.Errorf("shard_nodes: this node %q is not a member", nodeID(c))) }
+	for _, p := range []float64{c.ChaosDownload, c.ChaosTrap, c.ChaosSlowSyscall} {
+		if p < 0 || p > 1 { errs = append(errs, errors.New("chaos: probabilities must be 0..1")); break }
+	}
+	if _, err := time.LoadLocation(c.MaintTZ); err != nil { errs = append(errs, fmt.Errorf("maintenance_tz: %w", err)) }
+	for i, w := range c.Maintenance {
+		_, e1 := time.Parse("15:04", w.Start)
+		_, e2 := time.Parse("15:04", w.End)
+		if e1 != nil || e2 != nil || (w.Mode != "pause" && w.Mode != "safe") { errs = append(errs, fmt.Errorf("maintenance[%d]: start/end HH:MM and mode pause|safe required", i)) }
+	}
+	if c.ScheduleHoldMax < 0 { errs = append(errs, errors.New("schedule_hold_max: must be >= 0")) }
+	for i, sc := range c.Schedules {
+		if len(sc.Modules) == 0 || len(sc.Schedule) == 0 { errs = append(errs, fmt.Errorf("schedules[%d]: modules and schedule required", i)) }
+		if sc.OutsideSchedule != "" && sc.OutsideSchedule != "defer" && sc.OutsideSchedule != "deny" { errs = append(errs, fmt.Errorf("schedules[%d]: outside_schedule must be defer or deny", i)) }
+		for j, w := range sc.Schedule {
+			if err := w.check(); err != nil { errs = append(errs, fmt.Errorf("schedules[%d].schedule[%d]: %w", i, j, err)) }
+		}
+	}
+	if c.EnvelopeMaxKB < 1 || c.EnvelopeMaxKB > 1024 { errs = append(errs, errors.New("envelope_max_kb: must be 1..1024 (POST bodies are read up to 1 MiB)")) }
+	if c.InputsMaxKB < 0 || c.CapsMax < 0 || c.MetaMaxDepth < 0 { errs = append(errs, errors.New("envelope limits: must be >= 0")) }
+	if c.IntakeAction != "shed" && c.IntakeAction != "defer" { errs = append(errs, fmt.Errorf("intake_action: must be shed or defer, got %q", c.IntakeAction)) }
+	if c.IntakeRPS < 0 || c.IntakeBurst < 0 || c.IntakeDeferMax < 0 { errs = append(errs, errors.New("intake limits: must be >= 0")) }
+	if c.CorpusSample < 0 || c.CorpusSample > 1 { errs = append(errs, fmt.Errorf("corpus_sample: must be 0..1, got %g", c.CorpusSample)) }
+	if c.CorpusMaxMB < 0 { errs = append(errs, errors.New("corpus_max_mb: must be >= 0")) }
+	if c.Landlock != "off" && c.Landlock != "on" && c.Landlock != "required" { errs = append(errs, fmt.Errorf("landlock: must be off, on or required, got %q", c.Landlock)) }
+	for _, p := range c.SandboxPaths {
+		if !filepath.IsAbs(strings.TrimSuffix(p, ":ro")) { errs = append(errs, fmt.Errorf("sandbox_paths: absolute path required, got %q", p)) }
+	}
+	for _, p := range c.SandboxPorts {
+		if p < 1 || p > 65535 { errs = append(errs, fmt.Errorf("sandbox_ports: %d out of range", p)) }
+	}
+	if c.QuotaAction != "deny" && c.QuotaAction != "defer" { errs = append(errs, fmt.Errorf("quota_action: must be deny or defer, got %q", c.QuotaAction)) }
+	for name := range c.Tenants {
+		if !tenantName.MatchString(name) { errs = append(errs, fmt.Errorf("tenants: invalid name %q", name)) }
+	}
+	for name, m := range c.Mounts {
+		if !tenantName.MatchString(name) || !filepath.IsAbs(m.Host) || (m.Mode != "ro" && m.Mode != "rw") { errs = append(errs, fmt.Errorf("mounts[%s]: valid name, absolute host and mode ro|rw required", name)); continue }
+		if m.Cleanup != "" && m.Cleanup != "keep" && m.Cleanup != "run" && m.Cleanup != "ttl" { errs = append(errs, fmt.Errorf("mounts[%s]: cleanup must be keep, run or ttl", name)) }
+		if m.Cleanup == "ttl" && m.TTL <= 0 { errs = append(errs, fmt.Errorf("mounts[%s]: ttl must be > 0", name)) }
+		if m.QuotaMB < 0 { errs = append(errs, fmt.Errorf("mounts[%s]: quota_mb must be >= 0", name)) }
+	}
+	for name, e := range c.GuestEnv {
+		if !guestEnvName.MatchString(name) { errs = append(errs, fmt.Errorf("guest_env: invalid name %q", name)) }
+		if len(e.Value) > maxGuestEnvValue { errs = append(errs, fmt.Errorf("guest_env[%s]: value longer than %d", name, maxGuestEnvValue)) }
+	}
+	if len(c.ScratchModules) > 0 && !filepath.IsAbs(c.ScratchDir) { errs = append(errs, errors.New("scratch_dir: absolute path required with scratch_modules")) }
+	if c.ScratchQuotaMB < 0 { errs = append(errs, errors.New("scratch_quota_mb: must be >= 0")) }
+	for pat, l := range c.ModuleLimits {
+		if l.MaxConcurrent < 0 { errs = append(errs, fmt.Errorf("module_limits[%s]: max_concurrent must be >= 0", pat)) }
+	}
+	if err := validFair(c); err != nil { errs = append(errs, err) }
+	if err := validQueue(c); err != nil { errs = append(errs, err) }
+	if err := validHostVars(c); err != nil { errs = append(errs, err) }
+	if err := validGraphQL(c); err != nil { errs = append(errs, err) }
+	if err := validAI(c); err != nil { errs = append(errs, err) }
+	if err := validWS(c); err != nil { errs = append(errs, err) }
+	if c.ModuleLRU < 0 || c.ModuleLRUMB < 0 { errs = append(errs, errors.New("module_lru: must be >= 0")) }
+	if c.CacheDir == "" { errs = append(errs, errors.New("cache_dir: required")) }
+	if c.ReadyMinFreeMB < 0 { errs = append(errs, errors.New("ready_min_free_mb: must be >= 0")) }
+	if c.RecordMaxMB < 0 || c.RecordTTL < 0 { errs = append(errs, errors.New("record_max_mb, record_ttl: must be >= 0")) }
+	if c.OPABase != "" && !strings.HasPrefix(c.OPADecision, "/") { errs = append(errs, errors.New("opa_decision: must start with /")) }
+	return errors.Join(errs...)
+}
+
+// loadConfig: defaults <- YAML file (strict keys) <- env vars, then validate.
+func loadConfig(path string) (Config, error) {
+	cfg := defaultConfig()
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil { return cfg, err }
+		dec := yaml.NewDecoder(f)
+		dec.KnownFields(true)
+		err = dec.Decode(&cfg)
+		f.Close()
+		if err != nil && !errors.Is(err, io.EOF) { return cfg, fmt.Errorf("%s: %w", path, err) }
+	}
+	applyEnv(&cfg)
+	if cfg.PoliciesDir != "" {
+		var err error
+		if cfg.Policies, err = loadPolicies(cfg.PoliciesDir); err != nil { return cfg, fmt.Errorf("policies_dir: %w", err) }
+	}
+	cfg.RelayBase = strings.TrimRight(cfg.RelayBase, "/")
+	cfg.IPFSGateway = strings.TrimRight(cfg.IPFSGateway, "/")
+	cfg.IPFSAPI = strings.TrimRight(cfg.IPFSAPI, "/")
+	cfg.GrafanaURL = strings.TrimRight(cfg.GrafanaURL, "/")
+	return cfg, cfg.validate()
+}
+
+// --- Live config + hot reload ---
+var (
+	liveCfg    atomic.Pointer[Config]
+	configPath string
+	reloadMu   sync.Mutex
+)
+
+func currentConfig() Config { return *liveCfg.Load() }
+
+// reloadConfig re-reads file+env and swaps in the fields that are safe to
+// change at runtime (allowlists, under any runtime override, limits, dry-run, canary split, shard ring,
+// node labels, tenants, quotas, maintenance windows, schedules,
+// runtime_per_run, module_lru, module_limits, policies.d files, mounts,
+// guest_env, scratch grants, output limits, event schema action, webhooks,
+// http.fetch cache, targets, github hooks, intake rate limits, envelope
+// limits).
+// Listener addresses, paths and transports keep their startup values until
+// restart.
+func reloadConfig() (Config, error) {
+	reloadMu.Lock(); defer reloadMu.Unlock()
+	next, err := loadConfig(configPath)
+	if err != nil { return currentConfig(), err }
+	c := currentConfig()
+	if !reflect.DeepEqual(next.Policies, c.Policies) || !reflect.DeepEqual(next.ModuleLimits, c.ModuleLimits) { annotate(next, "policy", "module policies changed on reload") }
+	c.AllowModules, c.AllowCaps, c.AllowHTTPHosts, c.ControlKeys = next.AllowModules, next.AllowCaps, next.AllowHTTPHosts, next.ControlKeys
+	applyAllowOverride(&c)
+	c.DefaultTO, c.MaxMemMB, c.SoftTimeoutPct, c.Clock, c.RandomMaxKB = next.DefaultTO, next.MaxMemMB, next.SoftTimeoutPct, next.Clock, next.RandomMaxKB
+	c.HTTPBurst, c.HTTPRPS, c.MaxHTTPKB = next.HTTPBurst, next.HTTPRPS, next.MaxHTTPKB
+	c.HTTPCache, c.HTTPCacheEntries, c.HTTPCacheMaxTTL = next.HTTPCache, next.HTTPCacheEntries, next.HTTPCacheMaxTTL
+	c.GraphQL, c.GraphQLMaxQueryKB, c.GraphQLMaxResponseKB = next.GraphQL, next.GraphQLMaxQueryKB, next.GraphQLMaxResponseKB
+	c.AI, c.AIMaxPromptKB, c.AIMaxResponseKB, c.AIMaxTokens, c.AIMaxRunTokens = next.AI, next.AIMaxPromptKB, next.AIMaxResponseKB, next.AIMaxTokens, next.AIMaxRunTokens
+	c.WS, c.WSMaxFrameKB, c.WSMaxRate = next.WS, next.WSMaxFrameKB, next.WSMaxRate
+	c.RunTokenHosts, c.RunTokenTTL, c.RunTokenHeader = next.RunTokenHosts, next.RunTokenTTL, next.RunTokenHeader
+	c.MaxStdoutKB, c.MaxEvents, c.MaxEventKB, c.OutputAction = next.MaxStdoutKB, next.MaxEvents, next.MaxEventKB, next.OutputAction
+	c.EventSchemaAction = next.EventSchemaAction
+	c.DryRun, c.StrictEnvelopes, c.RuntimePerRun = next.DryRun, next.StrictEnvelopes, next.RuntimePerRun
+	c.EnvelopeMaxKB, c.InputsMaxKB, c.CapsMax, c.MetaMaxDepth = next.EnvelopeMaxKB, next.InputsMaxKB, next.CapsMax, next.MetaMaxDepth
+	c.ModuleLRU, c.ModuleLRUMB, c.ModuleLimits, c.Policies = next.ModuleLRU, next.ModuleLRUMB, next.ModuleLimits, next.Policies
+	c.FairWeights, c.FairStarveAfter = next.FairWeights, next.FairStarveAfter
+	c.Mounts, c.GuestEnv, c.HostVars = next.Mounts, next.GuestEnv, next.HostVars
+	c.ScratchModules, c.ScratchQuotaMB = next.ScratchModules, next.ScratchQuotaMB
+	c.CanaryPercent, c.CanaryEngine, c.CanaryMemMB = next.CanaryPercent, next.CanaryEngine, next.CanaryMemMB
+	c.ShardNodes, c.ShardKey, c.NodeLabels = next.ShardNodes, next.ShardKey, next.NodeLabels
+	c.Targets, c.TargetOpts = next.Targets, next.TargetOpts
+	c.Tenants, c.CacheMaxMB, c.ReadyMinFreeMB = next.Tenants, next.CacheMaxMB, next.ReadyMinFreeMB
+	c.RecordMaxMB, c.RecordTTL = next.RecordMaxMB, next.RecordTTL
+	c.Maintenance, c.Schedules, c.ScheduleHoldMax, c.Webhooks = next.Maintenance, next.Schedules, next.ScheduleHoldMax, next.Webhooks
+	c.IntentIdemTTL = next.IntentIdemTTL
+	c.GitHubSecret, c.GitHubHooks = next.GitHubSecret, next.GitHubHooks
+	c.GitHubToken, c.GitHubProvenance, c.GitHubTrustedWorkflows = next.GitHubToken, next.GitHubProvenance, next.GitHubTrustedWorkflows
+	c.RegistryURL, c.RegistryKeys, c.RegistryTTL = next.RegistryURL, next.RegistryKeys, next.RegistryTTL
+	c.QueueMaxDepth, c.QueueMaxKB, c.QueueVisibility, c.QueueLimits = next.QueueMaxDepth, next.QueueMaxKB, next.QueueVisibility, next.QueueLimits
+	c.IntakeRPS, c.IntakeBurst, c.IntakeAction, c.IntakeDeferMax = next.IntakeRPS, next.IntakeBurst, next.IntakeAction, next.IntakeDeferMax
+	c.CorpusFile, c.CorpusSample, c.CorpusMaxMB, c.CorpusKeep = next.CorpusFile, next.CorpusSample, next.CorpusMaxMB, next.CorpusKeep
+	c.QuotaRuns, c.QuotaRunTime, c.QuotaNetMB, c.QuotaAction, c.QuotaDefer = next.QuotaRuns, next.QuotaRunTime, next.QuotaNetMB, next.QuotaAction, next.QuotaDefer
+	if next.RelayBase != c.RelayBase || next.CacheDir != c.CacheDir || next.LedgerDir != c.LedgerDir || next.AdminAddr != c.AdminAddr || next.IntentAddr != c.IntentAddr || next.Concurrency != c.Concurrency || next.FetchWorkers != c.FetchWorkers || next.PolicyWorkers != c.PolicyWorkers || next.FairQueue != c.FairQueue {
+		fmt.Println("[config] some changed fields need a restart (addresses, paths, worker pools)")
+	}
+	liveCfg.Store(&c)
+	fmt.Println("[config] reloaded")
+	return c, nil
+}
+
+func watchSIGHUP() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if _, err := reloadConfig(); err != nil { fmt.Println("[config] reload rejected:", err) }
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// --- void-wasm-exec conform: standard battery a module should pass ---
+// Policy can require a passing report before a module is allowlisted.
+
+type conformCheck struct {
+	Name    string `json:"name"`
+	Pass    bool   `json:"pass"`
+	Skipped bool   `json:"skipped,omitempty"` // nothing in the module to exercise; counts as a pass
+	Detail  string `json:"detail,omitempty"`
+}
+
+type conformRun struct {
+	stdout   []string
+	traces   []SyscallTrace
+	ms       int64
+	err      error
+	timedOut bool // killed at cfg.DefaultTO
+}
+
+func conformExec(cfg Config, path string, inputs map[string]any) conformRun {
+	env := &Envelope{Type: "signal.wasm", Module: path, Inputs: inputs}
+	rec := &RunRecord{ID: newRunID(time.Now()), Module: path, Tenant: defaultTenant, Path: pathStable, tape: &runTape{}}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DefaultTO)
+	defer cancel()
+	t0 := time.Now()
+	err := runWasm(ctx, cfg, path, env, rec)
+	return conformRun{stdout: rec.tape.stdout, traces: rec.Syscalls, ms: time.Since(t0).Milliseconds(), err: err, timedOut: errors.Is(ctx.Err(), context.DeadlineExceeded)}
+}
+
+// capSyscalls are the kinds a cap gates; emit, time and random never are.
+var capSyscalls = []string{"syscall.kv.", "syscall.http.", "syscall.graphql", "syscall.ai.", "syscall.queue.", "syscall.env."}
+
+// deniable lists the syscalls in traces that withdrawing caps would deny.
+func deniable(traces []SyscallTrace) []string {
+	var kinds []string
+	for _, t := range traces {
+		for _, p := range capSyscalls {
+			if strings.HasPrefix(t.Kind, p) { kinds = append(kinds, t.Kind); break }
+		}
+	}
+	return kinds
+}
+
+// badFrames lists stdout lines that are not JSON objects with a string type.
+func badFrames(lines []string) []string {
+	var bad []string
+	for _, l := range lines {
+		var ev map[string]any
+		if json.Unmarshal([]byte(l), &ev) != nil { bad = append(bad, l); continue }
+		if t, ok := ev["type"].(string); !ok || t == "" { bad = append(bad, l) }
+	}
+	return bad
+}
+
+func conformLocal(args []string) int {
+	fs := flag.NewFlagSet("conform", flag.ExitOnError)
+	inputs := fs.String("inputs", `{"conform":true,"n":1,"s":"x"}`, "sample inputs JSON or @file")
+	caps := fs.String("caps", "emit,kv,http", "caps the module is expected to use")
+	timeout := fs.Duration("timeout", 2*time.Second, "time budget per run")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, "usage: void-wasm-exec conform [flags] module.wasm"); fs.PrintDefaults() }
+	fs.Parse(args)
+	if fs.NArg() != 1 { fs.Usage(); return 2 }
+	path := fs.Arg(0)
+	var sample map[string]any
+	if err := jsonArg(*inputs, &sample); err != nil { fmt.Fprintln(os.Stderr, "--inputs:", err); return 2 }
+
+	cfg := defaultConfig()
+	cfg.Tenant = defaultTenant
+	cfg.AllowCaps = parseList(*caps)
+	cfg.AllowHTTPHosts = nil // http.fetch is answered host_denied; no network during conformance
+	cfg.DefaultTO = *timeout
+	localSink = func(map[string]any) {}
+
+	var report []conformCheck
+	check := func(name string, pass bool, detail string) { report = append(report, conformCheck{Name: name, Pass: pass, Detail: detail}) }
+	skip := func(name, why string) { report = append(report, conformCheck{Name: name, Pass: true, Skipped: true, Detail: why}) }
+	errText := func(r conformRun) string { if r.err != nil { return r.err.Error() }; return fmt.Sprintf("%dms", r.ms) }
+
+	base := conformExec(cfg, path, sample)
+	check("runs_with_inputs", base.err == nil, errText(base))
+	empty := conformExec(cfg, path, map[string]any{})
+	check("runs_with_empty_inputs", empty.err == nil, errText(empty))
+	odd := conformExec(cfg, path, map[string]any{"__unknown": []any{1, "two", nil}, "n": "not-a-number"})
+	check("tolerates_unexpected_inputs", odd.err == nil, errText(odd))
+
+	// the deadline is twice the budget here, so a slow module is measured and
+	// one that overruns both is reported as killed rather than as a plain error
+	slow := cfg
+	slow.DefaultTO = 2 * *timeout
+	tr := conformExec(slow, path, sample)
+	switch {
+	case tr.timedOut: check("within_timeout", false, fmt.Sprintf("killed at the %s deadline", slow.DefaultTO))
+	case tr.err != nil: check("within_timeout", false, errText(tr))
+	default: check("within_timeout", tr.ms <= timeout.Milliseconds(), fmt.Sprintf("%dms of %s", tr.ms, *timeout))
+	}
+
+	bad := badFrames(base.stdout)
+	detail := fmt.Sprintf("%d lines", len(base.stdout))
+	if len(bad) > 0 { detail = fmt.Sprintf("%d of %d lines are not JSON objects with a type, first: %.80q", len(bad), len(base.stdout), bad[0]) }
+	check("event_framing", len(bad) == 0, detail)
+
+	var malformed []string
+	for _, t := range base.traces {
+		switch t.Result {
+		case "bad_event", "bad_key", "bad_url", "unknown": malformed = append(malformed, t.Kind+"="+t.Result)
+		}
+	}
+	check("syscall_shape", len(malformed) == 0, strings.Join(malformed, " "))
+
+	// caps withdrawn: every gated syscall the module issues is answered denied,
+	// and it must still exit cleanly
+	denied := cfg
+	denied.AllowCaps = []string{"emit"}
+	d := conformExec(denied, path, sample)
+	var refused []string
+	for _, t := range d.traces {
+		if t.Result == "denied" { refused = append(refused, t.Kind) }
+	}
+	switch {
+	case d.err != nil: check("survives_denied_syscalls", false, errText(d))
+	case len(refused) > 0: check("survives_denied_syscalls", true, fmt.Sprintf("%d denied (%s), %s", len(refused), strings.Join(refused, " "), errText(d)))
+	case len(deniable(base.traces)) > 0: check("survives_denied_syscalls", false, "issued "+strings.Join(deniable(base.traces), " ")+" with --caps, none denied without them")
+	default: skip("survives_denied_syscalls", "no syscalls a cap gates")
+	}
+
+	failed, skipped := 0, 0
+	for _, c := range report {
+		if !c.Pass { failed++ }
+		if c.Skipped { skipped++ }
+	}
+	if *asJSON {
+		b, _ := json.MarshalIndent(map[string]any{"module": path, "pass": failed == 0, "checks": report}, "", "  ")
+		fmt.Println(string(b))
+	} else {
+		for _, c := range report {
+			mark := "PASS"
+			if !c.Pass { mark = "FAIL" } else if c.Skipped { mark = "SKIP" }
+			fmt.Printf("%s  %-28s %s\n", mark, c.Name, c.Detail)
+		}
+		fmt.Printf("%d/%d checks passed (%d skipped)\n", len(report)-failed, len(report), skipped)
+	}
+	if failed > 0 { return 1 }
+	return 0
+}
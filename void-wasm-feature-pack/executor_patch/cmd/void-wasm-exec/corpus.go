@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	mrand "math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Envelope corpus ---
+// corpus_file exports received envelopes (corpus_sample of them, after
+// decoding, before the intake gates) as NDJSON {"at": RFC3339, "envelope":
+// {...}} for load tests shaped like production traffic. Envelopes are
+// anonymized on the way out: every string in inputs and meta becomes a keyed
+// pseudonym of the same length (corpus_salt; the same value maps to the same
+// pseudonym within a corpus, so canary splits and correlation stay
+// consistent), numbers, booleans and keys are kept, as are keys listed in
+// corpus_keep and input references ($ref without its query, sha256). Env
+// overrides are dropped to the configured value, traceparent is removed, the
+// module URL loses userinfo and query. Tenant, module, hashes, caps, limits
+// and policy stay so the same work lands on the same quotas when replayed.
+// Export stops at corpus_max_mb.
+//
+// `void-wasm-exec replay -corpus corpus.ndjson` posts the envelopes back to
+// -target (the relay's or the executor's POST /intent/execute-wasm, or
+// /admin/envelopes) with their recorded spacing divided by -speed (0 = as
+// fast as the workers go), -loop times.
+
+var corpusTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_corpus_envelopes_total", Help: "Envelopes offered to the corpus export, by result (written, sampled_out, full, error)"}, []string{"result"})
+
+type corpusLine struct {
+	At       time.Time `json:"at"`
+	Envelope *Envelope `json:"envelope"`
+}
+
+var corpus struct {
+	sync.Mutex
+	path string
+	f    *os.File
+	size int64
+	salt []byte
+}
+
+// corpusExport appends an anonymized copy of env when corpus_file is set.
+func corpusExport(cfg Config, env *Envelope) {
+	if cfg.CorpusFile == "" { return }
+	if cfg.CorpusSample < 1 && mrand.Float64() >= cfg.CorpusSample { corpusTotal.WithLabelValues("sampled_out").Inc(); return }
+	corpus.Lock()
+	defer corpus.Unlock()
+	if corpus.salt == nil {
+		corpus.salt = []byte(cfg.CorpusSalt)
+		if len(corpus.salt) == 0 { corpus.salt = make([]byte, 32); rand.Read(corpus.salt) }
+	}
+	b, err := json.Marshal(corpusLine{At: time.Now().UTC(), Envelope: anonymizeEnvelope(env, corpus.salt, cfg.CorpusKeep)})
+	if err != nil { corpusTotal.WithLabelValues("error").Inc(); return }
+	if corpus.path != cfg.CorpusFile {
+		if corpus.f != nil { corpus.f.Close(); corpus.f = nil }
+		os.MkdirAll(filepath.Dir(cfg.CorpusFile), 0o755)
+		f, err := os.OpenFile(cfg.CorpusFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil { fmt.Println("[corpus] open:", err); corpusTotal.WithLabelValues("error").Inc(); return }
+		st, _ := f.Stat()
+		corpus.path, corpus.f, corpus.size = cfg.CorpusFile, f, st.Size()
+	}
+	if cfg.CorpusMaxMB > 0 && corpus.size+int64(len(b))+1 > int64(cfg.CorpusMaxMB)<<20 { corpusTotal.WithLabelValues("full").Inc(); return }
+	n, err := corpus.f.Write(append(b, '\n'))
+	corpus.size += int64(n)
+	if err != nil { fmt.Println("[corpus] write:", err); corpusTotal.WithLabelValues("error").Inc(); return }
+	corpusTotal.WithLabelValues("written").Inc()
+}
+
+// anonymizeEnvelope is the exported form of env; env itself is untouched.
+func anonymizeEnvelope(env *Envelope, salt []byte, keep []string) *Envelope {
+	var out Envelope
+	b, _ := json.Marshal(env)
+	json.Unmarshal(b, &out)
+	pseudo := func(s string) string {
+		if s == "" { return s }
+		m := hmac.New(sha256.New, salt)
+		m.Write([]byte(s))
+		h := hex.EncodeToString(m.Sum(nil))
+		for len(h) < len(s) { h += h }
+		return h[:len(s)]
+	}
+	var scrub func(v any) any
+	scrub = func(v any) any {
+		switch x := v.(type) {
+		case string:
+			return pseudo(x)
+		case []any:
+			for i := range x { x[i] = scrub(x[i]) }
+		case map[string]any:
+			if ref, ok := x["$ref"].(string); ok {
+				if u, err := url.Parse(ref); err == nil { u.RawQuery, u.User = "", nil; x["$ref"] = u.String() }
+				return x
+			}
+			for k, e := range x {
+				if !slices.Contains(keep, k) { x[k] = scrub(e) }
+			}
+		}
+		return v
+	}
+	for k, v := range out.Inputs {
+		if !slices.Contains(keep, k) { out.Inputs[k] = scrub(v) }
+	}
+	delete(out.Meta, "traceparent")
+	for k, v := range out.Meta {
+		if !slices.Contains(keep, k) { out.Meta[k] = scrub(v) }
+	}
+	for k := range out.Env { out.Env[k] = "" }
+	if u, err := url.Parse(out.URL); err == nil && out.URL != "" { u.RawQuery, u.User = "", nil; out.URL = u.String() }
+	return &out
+}
+
+// replayCorpus implements `void-wasm-exec replay -corpus file.ndjson`.
+func replayCorpus(path, target, token string, speed float64, loops, workers int) int {
+	f, err := os.Open(path)
+	if err != nil { fmt.Fprintln(os.Stderr, err); return 2 }
+	var lines []corpusLine
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64<<10), 4<<20)
+	for sc.Scan() {
+		var l corpusLine
+		if json.Unmarshal(sc.Bytes(), &l) != nil || l.Envelope == nil { continue }
+		lines = append(lines, l)
+	}
+	f.Close()
+	if err := sc.Err(); err != nil { fmt.Fprintln(os.Stderr, err); return 2 }
+	if len(lines) == 0 { fmt.Fprintln(os.Stderr, "no envelopes in", path); return 2 }
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].At.Before(lines[j].At) })
+	span := lines[len(lines)-1].At.Sub(lines[0].At)
+	fmt.Printf("replay  %d envelopes over %s, speed %g, %d loop(s) -> %s\n", len(lines), span.Round(time.Millisecond), speed, loops, target)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	var sent, failed atomic.Int64
+	var codesMu sync.Mutex
+	codes := map[string]int{}
+	start := time.Now()
+	for loop := 0; loop < loops; loop++ {
+		base, t0 := lines[0].At, time.Now()
+		for _, l := range lines {
+			if speed > 0 {
+				if d := time.Duration(float64(l.At.Sub(base))/speed) - time.Since(t0); d > 0 { time.Sleep(d) }
+			}
+			body, _ := json.Marshal(l.Envelope)
+			sem <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer func() { <-sem; wg.Done() }()
+				req, _ := http.NewRequest("POST", target, bytes.NewReader(body))
+				req.Header.Set("content-type", "application/json")
+				if token != "" { req.Header.Set("authorization", "Bearer "+token) }
+				sent.Add(1)
+				code := "error"
+				if resp, err := client.Do(req); err == nil {
+					resp.Body.Close()
+					code = fmt.Sprint(resp.StatusCode)
+					if resp.StatusCode >= 300 { failed.Add(1) }
+				} else {
+					failed.Add(1)
+				}
+				codesMu.Lock(); codes[code]++; codesMu.Unlock()
+			}()
+		}
+	}
+	wg.Wait()
+	took := time.Since(start)
+	fmt.Printf("replay  sent %d in %s (%.1f/s), failed %d, status %v\n", sent.Load(), took.Round(time.Millisecond), float64(sent.Load())/took.Seconds(), failed.Load(), codes)
+	if failed.Load() > 0 { return 1 }
+	return 0
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+)
+
+// --- Correlation IDs ---
+// Every run carries one correlation ID so an intent can be followed from the
+// relay through the executor and the module to the sinks. It is taken from
+// the envelope's meta.correlation_id, else meta.request_id (what the relay
+// sets), else the /intent/execute-wasm request_id, else it is the run ID;
+// POST /admin/envelopes and /intent/execute-wasm also accept an
+// X-Correlation-ID header, which becomes meta.correlation_id. A value that is
+// not 1-64 of [A-Za-z0-9._:-] is replaced by the run ID (decision
+// correlation=invalid). The ID then appears as
+//   corr=<id> on every log line about the run
+//   "correlation_id" on the receipt (history, /stream, webhooks, ipfs) and
+//   gRPC RunReceipt / Event correlation_id
+//   "correlation_id" at the top of every event the run posts (module events,
+//   sysrets, run.denied...) unless the module set its own, correlationid on
+//   CloudEvents, Void-Correlation on NATS messages
+//   a correlation_id exemplar label on void_wasm_duration_ms next to trace_id
+// The syscall timeline travels inside the receipt.
+
+var correlationRe = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// correlationID picks env's correlation ID; ok is false when a supplied one
+// was invalid and run is used instead.
+func correlationID(env *Envelope, run string) (id string, ok bool) {
+	v, _ := env.Meta["correlation_id"].(string)
+	if v == "" { v, _ = env.Meta["request_id"].(string) }
+	if v == "" { v = env.intent }
+	if v == "" { return run, true }
+	if !correlationRe.MatchString(v) { return run, false }
+	return v, true
+}
+
+// corr is the log token for rec's correlation ID.
+func (rec *RunRecord) corr() string { return "corr=" + rec.Correlation }
+
+// withCorrelation tags a run event with rec's correlation ID; raw module
+// events are not decoded, so the field is spliced in after the opening brace.
+func withCorrelation(rec *RunRecord, ev map[string]any, raw []byte) []byte {
+	if rec == nil || rec.Correlation == "" { return raw }
+	if ev != nil {
+		if _, set := ev["correlation_id"]; !set { ev["correlation_id"] = rec.Correlation }
+		return raw
+	}
+	trimmed := bytes.TrimLeft(raw, " \t\r\n")
+	if len(trimmed) < 2 || trimmed[0] != '{' || bytes.Contains(raw, []byte(`"correlation_id"`)) { return raw }
+	out := make([]byte, 0, len(trimmed)+len(rec.Correlation)+20)
+	out = append(out, `{"correlation_id":"`...)
+	out = append(out, rec.Correlation...)
+	out = append(out, '"')
+	if rest := bytes.TrimLeft(trimmed[1:], " \t\r\n"); len(rest) > 0 && rest[0] != '}' { out = append(out, ',') }
+	return append(out, trimmed[1:]...)
+}
+
+// setCorrelationHeader copies X-Correlation-ID into env's meta.
+func setCorrelationHeader(env *Envelope, h string) {
+	if h == "" { return }
+	if env.Meta == nil { env.Meta = map[string]any{} }
+	if _, set := env.Meta["correlation_id"]; !set { env.Meta["correlation_id"] = h }
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Cost accounting per module ---
+// Every booked run adds its resource use to the (tenant, module) usage
+// buckets behind quotas and usage.report (quota.go): runs, wall run_ms,
+// cpu_ms (CPU of the OS thread the guest ran on, Linux; guest wall time
+// elsewhere), fuel (budgeted runs only, budget.go), net_bytes (http.fetch
+// bodies in), egress_bytes (events the module sent plus /out artifacts),
+// syscalls, ai_tokens (syscall.ai.infer, ai.go), and module cache churn:
+// cache_misses and cache_bytes pulled from the shared tier or origin. The same figures are exported as
+// void_wasm_usage_total{tenant,module,resource} counters, for rate() based
+// cost attribution, and void_wasm_run_cost summaries of per-run cost over
+// the last quota_window, so the rollout can price each signal.
+
+var (
+	usageTotal     = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_usage_total", Help: "Resource use by tenant, module and resource (runs, run_ms, cpu_ms, fuel, net_bytes, egress_bytes, syscalls, ai_tokens, cache_misses, cache_bytes)"}, []string{"tenant", "module", "resource"})
+	runCost        = newRunCost(10 * time.Minute)
+	cacheEvictions = prometheus.NewCounter(prometheus.CounterOpts{Name: "void_wasm_cache_evictions_total", Help: "Modules evicted from cache_dir by cache_max_mb"})
+)
+
+var cacheEvicted atomic.Int64 // since the last usage.report
+
+func newRunCost(window time.Duration) *prometheus.SummaryVec {
+	return prometheus.NewSummaryVec(prometheus.SummaryOpts{
+		Name: "void_wasm_run_cost", Help: "Per-run cost by tenant, module and resource (cpu_ms, fuel, egress_bytes)",
+		Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001}, MaxAge: window,
+	}, []string{"tenant", "module", "resource"})
+}
+
+// guestCPU starts timing the guest on the calling goroutine, pinned to its
+// thread so the thread's CPU clock is the guest's; call the result when the
+// guest returns.
+func guestCPU(rec *RunRecord) func() {
+	runtime.LockOSThread()
+	t0 := time.Now()
+	c0, ok := threadCPU()
+	return func() {
+		if c1, ok1 := threadCPU(); ok && ok1 { rec.CPUMs = (c1 - c0).Milliseconds() } else { rec.CPUMs = time.Since(t0).Milliseconds() }
+		runtime.UnlockOSThread()
+	}
+}
+
+// runUsage is what one run adds to its module's totals.
+func runUsage(rec *RunRecord) usageTotals {
+	u := usageTotals{Runs: 1, RunMs: rec.RunMs, CPUMs: rec.CPUMs, NetBytes: rec.NetBytes, EgressBytes: rec.EventBytes, Syscalls: int64(len(rec.Syscalls) + rec.SyscallsDropped), AITokens: rec.AITokens}
+	for _, a := range rec.Artifacts { u.EgressBytes += a.Size }
+	if rec.Budget != nil { u.Fuel = rec.Budget.Used.Fuel }
+	if rec.FetchedBytes > 0 { u.CacheMisses, u.CacheBytes = 1, rec.FetchedBytes }
+	return u
+}
+
+func observeCost(tenant, module string, u usageTotals) {
+	for res, v := range u.fields() {
+		if v != 0 { usageTotal.WithLabelValues(tenant, module, res).Add(float64(v)) }
+	}
+	runCost.WithLabelValues(tenant, module, "cpu_ms").Observe(float64(u.CPUMs))
+	runCost.WithLabelValues(tenant, module, "egress_bytes").Observe(float64(u.EgressBytes))
+	if u.Fuel > 0 { runCost.WithLabelValues(tenant, module, "fuel").Observe(float64(u.Fuel)) }
+}
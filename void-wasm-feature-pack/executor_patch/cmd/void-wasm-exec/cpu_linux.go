@@ -0,0 +1,16 @@
+//go:build linux
+
+package main
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// threadCPU is the user+system CPU time of the calling OS thread.
+func threadCPU() (time.Duration, bool) {
+	var ru unix.Rusage
+	if unix.Getrusage(unix.RUSAGE_THREAD, &ru) != nil { return 0, false }
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano()), true
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// --- Soft deadline ---
+// With soft_timeout_pct set, a run gets a warning at that share of its
+// timeout before the hard kill at 100%: the executor emits run.soft_timeout
+// and the host function void.soft_timeout() starts returning 1, so a module
+// that polls it (voidsdk.SoftTimeout) can flush state and exit cleanly. The
+// run record notes soft_timeout either way. The void host module is present in
+// every runtime; modules that never import it are unaffected.
+
+var softTimeouts = prometheus.NewCounter(prometheus.CounterOpts{Name: "void_wasm_soft_timeouts_total", Help: "Runs that reached their soft deadline"})
+
+type softDeadlineKey struct{}
+
+// instantiateVoidHost adds the "void" host module to r: soft_timeout here,
+// kv_watch and kv_next (kvwatch.go), queue_pop (queue.go), env_get
+// (hostvars.go), time_now and time_mono (clock.go), random (random.go),
+// ws_send and ws_close (ws.go).
+func instantiateVoidHost(ctx context.Context, r wazero.Runtime) error {
+	b := r.NewHostModuleBuilder("void")
+	b.NewFunctionBuilder().
+		WithGoFunction(api.GoFunc(func(ctx context.Context, stack []uint64) {
+			stack[0] = 0
+			if passed, _ := ctx.Value(softDeadlineKey{}).(*atomic.Bool); passed != nil && passed.Load() { stack[0] = 1 }
+		}), nil, []api.ValueType{api.ValueTypeI32}).
+		Export("soft_timeout")
+	exportKVWatch(b)
+	exportQueue(b)
+	exportHostVars(b)
+	exportClock(b)
+	exportRandom(b)
+	exportWS(b)
+	_, err := b.Instantiate(ctx)
+	return err
+}
+
+// softDeadline arms the warning for ctx's deadline; the returned ctx carries
+// the flag to void.soft_timeout, stop disarms it and reports whether it fired.
+func softDeadline(ctx context.Context, cfg Config, rec *RunRecord) (context.Context, func() bool) {
+	passed := new(atomic.Bool)
+	ctx = context.WithValue(ctx, softDeadlineKey{}, passed)
+	hard, ok := ctx.Deadline()
+	if cfg.SoftTimeoutPct <= 0 || !ok { return ctx, func() bool { return false } }
+	start := time.Now()
+	soft := time.Duration(int64(time.Until(hard)) * int64(cfg.SoftTimeoutPct) / 100)
+	t := time.AfterFunc(soft, func() {
+		passed.Store(true)
+		softTimeouts.Inc()
+		postRunEvent(cfg, rec, map[string]any{"type": "run.soft_timeout", "meta": map[string]any{"run": rec.ID, "module": rec.Module, "tenant": rec.Tenant, "elapsed_ms": time.Since(start).Milliseconds(), "hard_in_ms": time.Until(hard).Milliseconds()}})
+	})
+	return ctx, func() bool { t.Stop(); return passed.Load() }
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// --- DNS cache (dns_cache) ---
+// Module downloads and http.fetch dial through one caching resolver instead
+// of a lookup per connection, which costs 100ms+ on edge nodes with slow DNS.
+// With dns_upstream (host[:53], tried in order) A and AAAA are asked of
+// those servers directly and cached for the answer's TTL clamped to
+// dns_min_ttl..dns_max_ttl; names are taken as fully qualified there, so
+// short names (relay, k8s services) need the system resolver. Without it the
+// system resolver answers and entries live dns_min_ttl, since it does not
+// report TTLs. NXDOMAIN and empty answers are cached for dns_negative_ttl;
+// server failures are not cached. Concurrent lookups of one name share a
+// query, which runs to its own timeout even if the caller that started it
+// gives up. Replies must echo the query's id and question; others are
+// ignored (UDP) or fail the exchange (TCP). Addresses are dialed in answer order until one connects. Set at
+// startup; relay, SSE and log clients keep the system resolver.
+
+var (
+	dnsLookups = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_dns_lookups_total", Help: "Cached resolver lookups by result"}, []string{"result"})
+	dnsMs      = prometheus.NewHistogram(prometheus.HistogramOpts{Name: "void_wasm_dns_lookup_ms", Help: "Resolver queries that missed the cache, ms", Buckets: []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500}})
+	dnsEntries = prometheus.NewGauge(prometheus.GaugeOpts{Name: "void_wasm_dns_cache_entries", Help: "Names in the DNS cache"})
+)
+
+type dnsEntry struct {
+	ips     []net.IP
+	err     error // negative entry
+	fail    error // the query failed; not cached, only for those sharing it
+	expires time.Time
+	ready   chan struct{} // closed once the query is done
+}
+
+type dnsResolver struct {
+	upstream         []string
+	min, max, negTTL time.Duration
+
+	mu sync.Mutex
+	m  map[string]*dnsEntry
+}
+
+var dnsRes *dnsResolver // nil = dns_cache off
+
+var (
+	errNoSuchHost  = errors.New("no such host")
+	errDNSMismatch = errors.New("dns: reply does not match the query")
+)
+
+const dnsQueryTimeout = 10 * time.Second
+
+func newDNSResolver(cfg Config) *dnsResolver {
+	r := &dnsResolver{min: cfg.DNSMinTTL, max: cfg.DNSMaxTTL, negTTL: cfg.DNSNegativeTTL, m: map[string]*dnsEntry{}}
+	for _, u := range cfg.DNSUpstream {
+		if _, _, err := net.SplitHostPort(u); err != nil { u = net.JoinHostPort(u, "53") }
+		r.upstream = append(r.upstream, u)
+	}
+	go r.sweep()
+	return r
+}
+
+// resolve returns host's addresses from the cache or a fresh query.
+func (r *dnsResolver) resolve(ctx context.Context, host string) ([]net.IP, error) {
+	key := strings.ToLower(strings.TrimSuffix(host, "."))
+	r.mu.Lock()
+	e := r.m[key]
+	if e != nil {
+		select {
+		case <-e.ready:
+			if time.Now().After(e.expires) { e = nil }
+		default: // a query is in flight; share it
+		}
+	}
+	shared := e != nil
+	if !shared {
+		e = &dnsEntry{ready: make(chan struct{})}
+		r.m[key] = e
+		dnsEntries.Set(float64(len(r.m)))
+		go r.fill(ctx, key, e)
+	}
+	r.mu.Unlock()
+	select {
+	case <-e.ready:
+	case <-ctx.Done(): return nil, ctx.Err()
+	}
+	if e.err != nil {
+		if shared { dnsLookups.WithLabelValues("negative_hit").Inc() }
+		return nil, &net.DNSError{Err: e.err.Error(), Name: host, IsNotFound: true}
+	}
+	if e.fail != nil { return nil, e.fail }
+	if shared { dnsLookups.WithLabelValues("hit").Inc() }
+	return e.ips, nil
+}
+
+// fill runs the query for a new entry. It is detached from ctx (values
+// kept) so that the caller who started it giving up does not fail everyone
+// sharing the entry.
+func (r *dnsResolver) fill(ctx context.Context, key string, e *dnsEntry) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), dnsQueryTimeout)
+	defer cancel()
+	t0 := time.Now()
+	ips, ttl, err := r.query(ctx, key)
+	dnsMs.Observe(float64(time.Since(t0).Microseconds()) / 1000)
+	switch {
+	case errors.Is(err, errNoSuchHost):
+		dnsLookups.WithLabelValues("negative").Inc()
+		e.err, e.expires = err, time.Now().Add(r.negTTL)
+	case err != nil:
+		dnsLookups.WithLabelValues("error").Inc()
+		e.fail = err
+		r.mu.Lock()
+		if r.m[key] == e { delete(r.m, key) }
+		r.mu.Unlock()
+	default:
+		dnsLookups.WithLabelValues("miss").Inc()
+		e.ips, e.expires = ips, time.Now().Add(min(max(ttl, r.min), r.max))
+	}
+	close(e.ready)
+}
+
+// query asks the upstreams (or the system resolver) for host; ttl is 0 when
+// unknown.
+func (r *dnsResolver) query(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	if len(r.upstream) == 0 {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		var de *net.DNSError
+		if errors.As(err, &de) && de.IsNotFound { return nil, 0, errNoSuchHost }
+		if err != nil { return nil, 0, err }
+		ips := make([]net.IP, len(addrs))
+		for i, a := range addrs { ips[i] = a.IP }
+		return ips, 0, nil
+	}
+	name, err := dnsmessage.NewName(host + ".")
+	if err != nil { return nil, 0, err }
+	var lastErr error
+	for _, up := range r.upstream {
+		type answer struct {
+			ips []net.IP
+			ttl uint32
+			err error
+		}
+		ch := make(chan answer, 2)
+		for _, qt := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+			go func() { ips, ttl, err := dnsExchange(ctx, up, name, qt); ch <- answer{ips, ttl, err} }()
+		}
+		var ips []net.IP
+		var ttl uint32
+		lastErr = nil
+		for range 2 {
+			a := <-ch
+			switch {
+			case errors.Is(a.err, errNoSuchHost):
+			case a.err != nil: lastErr = a.err
+			default:
+				ips = append(ips, a.ips...)
+				if len(a.ips) > 0 && (ttl == 0 || a.ttl < ttl) { ttl = a.ttl }
+			}
+		}
+		if len(ips) > 0 { return ips, time.Duration(ttl) * time.Second, nil }
+		if lastErr == nil { return nil, 0, errNoSuchHost } // NXDOMAIN or no A/AAAA
+		lastErr = fmt.Errorf("dns %s: %w", up, lastErr)
+	}
+	return nil, 0, lastErr
+}
+
+// dnsExchange sends one question over UDP, retrying over TCP if truncated.
+func dnsExchange(ctx context.Context, server string, name dnsmessage.Name, qt dnsmessage.Type) ([]net.IP, uint32, error) {
+	id := uint16(rand.Uint32())
+	q := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	q.EnableCompression()
+	err := q.StartQuestions()
+	if err == nil { err = q.Question(dnsmessage.Question{Name: name, Type: qt, Class: dnsmessage.ClassINET}) }
+	if err != nil { return nil, 0, err }
+	msg, err := q.Finish()
+	if err != nil { return nil, 0, err }
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	var d net.Dialer
+	match := func(b []byte) bool { _, _, _, err := dnsParse(b, id, name, qt); return !errors.Is(err, errDNSMismatch) }
+	resp, err := dnsRoundTrip(ctx, &d, "udp", server, msg, match)
+	if err != nil { return nil, 0, err }
+	ips, ttl, truncated, err := dnsParse(resp, id, name, qt)
+	if err == nil && truncated {
+		if resp, err = dnsRoundTrip(ctx, &d, "tcp", server, msg, nil); err == nil { ips, ttl, _, err = dnsParse(resp, id, name, qt) }
+	}
+	return ips, ttl, err
+}
+
+// dnsParse reads the reply to query id for name/qt: errDNSMismatch unless it
+// is a response echoing the id and the one question, truncated without
+// looking further, errNoSuchHost for NXDOMAIN or no A/AAAA records.
+func dnsParse(resp []byte, id uint16, name dnsmessage.Name, qt dnsmessage.Type) (ips []net.IP, ttl uint32, truncated bool, err error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(resp)
+	if err != nil { return nil, 0, false, err }
+	if h.ID != id || !h.Response { return nil, 0, false, errDNSMismatch }
+	q, err := p.Question()
+	if err != nil || q.Type != qt || q.Class != dnsmessage.ClassINET || !strings.EqualFold(q.Name.String(), name.String()) { return nil, 0, false, errDNSMismatch }
+	if _, err := p.Question(); !errors.Is(err, dnsmessage.ErrSectionDone) { return nil, 0, false, errDNSMismatch }
+	if h.Truncated { return nil, 0, true, nil }
+	switch h.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError: return nil, 0, false, errNoSuchHost
+	default: return nil, 0, false, fmt.Errorf("dns: rcode %v", h.RCode)
+	}
+	for {
+		rh, err := p.AnswerHeader()
+		if errors.Is(err, dnsmessage.ErrSectionDone) { break }
+		if err != nil { return nil, 0, false, err }
+		if ttl == 0 || rh.TTL < ttl { ttl = rh.TTL } // CNAMEs in the chain count too
+		switch rh.Type {
+		case dnsmessage.TypeA:
+			a, err := p.AResource()
+			if err != nil { return nil, 0, false, err }
+			ips = append(ips, net.IP(a.A[:]))
+		case dnsmessage.TypeAAAA:
+			a, err := p.AAAAResource()
+			if err != nil { return nil, 0, false, err }
+			ips = append(ips, net.IP(a.AAAA[:]))
+		default:
+			if err := p.SkipAnswer(); err != nil { return nil, 0, false, err }
+		}
+	}
+	if len(ips) == 0 { return nil, 0, false, errNoSuchHost }
+	return ips, ttl, false, nil
+}
+
+// dnsRoundTrip sends msg and returns the reply; over UDP, datagrams match
+// rejects are skipped until the deadline.
+func dnsRoundTrip(ctx context.Context, d *net.Dialer, network, server string, msg []byte, match func([]byte) bool) ([]byte, error) {
+	c, err := d.DialContext(ctx, network, server)
+	if err != nil { return nil, err }
+	defer c.Close()
+	if dl, ok := ctx.Deadline(); ok { c.SetDeadline(dl) }
+	if network == "tcp" {
+		msg = append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...)
+		if _, err := c.Write(msg); err != nil { return nil, err }
+		var n [2]byte
+		if _, err := io.ReadFull(c, n[:]); err != nil { return nil, err }
+		buf := make([]byte, binary.BigEndian.Uint16(n[:]))
+		_, err = io.ReadFull(c, buf)
+		return buf, err
+	}
+	if _, err := c.Write(msg); err != nil { return nil, err }
+	buf := make([]byte, 1232)
+	for {
+		n, err := c.Read(buf)
+		if err != nil || match == nil || match(buf[:n]) { return buf[:n], err }
+	}
+}
+
+// dial connects to addr through the cache, trying each address in turn.
+func (r *dnsResolver) dial(ctx context.Context, d *net.Dialer, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil { return d.DialContext(ctx, network, addr) }
+	ips, err := r.resolve(ctx, host)
+	if err != nil { return nil, err }
+	var lastErr error
+	for _, ip := range ips {
+		if network == "tcp4" && ip.To4() == nil || network == "tcp6" && ip.To4() != nil { continue }
+		c, err := d.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil { return c, nil }
+		lastErr = err
+	}
+	if lastErr == nil { lastErr = &net.DNSError{Err: "no suitable address", Name: host} }
+	return nil, lastErr
+}
+
+// sweep drops expired entries so the cache does not grow with every name
+// ever asked for.
+func (r *dnsResolver) sweep() {
+	for range time.Tick(time.Minute) {
+		r.mu.Lock()
+		for k, e := range r.m {
+			select {
+			case <-e.ready:
+				if time.Now().After(e.expires) { delete(r.m, k) }
+			default:
+			}
+		}
+		dnsEntries.Set(float64(len(r.m)))
+		r.mu.Unlock()
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsReply packs a response with header h, questions qs and answers.
+func dnsReply(t *testing.T, h dnsmessage.Header, qs []dnsmessage.Question, answers ...dnsmessage.Resource) []byte {
+	t.Helper()
+	h.Response = true
+	m := dnsmessage.Message{Header: h, Questions: qs, Answers: answers}
+	b, err := m.Pack()
+	if err != nil { t.Fatal(err) }
+	return b
+}
+
+func TestDNSParse(t *testing.T) {
+	name := dnsmessage.MustNewName("example.com.")
+	q := dnsmessage.Question{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}
+	rr := func(typ dnsmessage.Type, ttl uint32, body dnsmessage.ResourceBody) dnsmessage.Resource {
+		return dnsmessage.Resource{Header: dnsmessage.ResourceHeader{Name: name, Type: typ, Class: dnsmessage.ClassINET, TTL: ttl}, Body: body}
+	}
+	a1 := rr(dnsmessage.TypeA, 300, &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}})
+	a2 := rr(dnsmessage.TypeA, 60, &dnsmessage.AResource{A: [4]byte{192, 0, 2, 2}})
+	cname := rr(dnsmessage.TypeCNAME, 30, &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName("edge.example.net.")})
+	good := dnsReply(t, dnsmessage.Header{ID: 7}, []dnsmessage.Question{q}, cname, a1, a2)
+
+	ips, ttl, tc, err := dnsParse(good, 7, name, dnsmessage.TypeA)
+	if err != nil || tc || len(ips) != 2 || !ips[0].Equal(net.IPv4(192, 0, 2, 1)) || ttl != 30 { t.Fatalf("good reply: %v ttl=%d tc=%v %v", ips, ttl, tc, err) }
+	upper := q
+	upper.Name = dnsmessage.MustNewName("EXAMPLE.com.")
+	if _, _, _, err := dnsParse(dnsReply(t, dnsmessage.Header{ID: 7}, []dnsmessage.Question{upper}, a1), 7, name, dnsmessage.TypeA); err != nil { t.Errorf("0x20 case in the echoed question: %v", err) }
+
+	other := q
+	other.Name = dnsmessage.MustNewName("evil.example.")
+	aaaa := q
+	aaaa.Type = dnsmessage.TypeAAAA
+	query := dnsmessage.Message{Header: dnsmessage.Header{ID: 7}, Questions: []dnsmessage.Question{q}}
+	notResponse, _ := query.Pack()
+	for what, b := range map[string][]byte{
+		"id":            dnsReply(t, dnsmessage.Header{ID: 8}, []dnsmessage.Question{q}, a1),
+		"not response":  notResponse,
+		"name":          dnsReply(t, dnsmessage.Header{ID: 7}, []dnsmessage.Question{other}, a1),
+		"type":          dnsReply(t, dnsmessage.Header{ID: 7}, []dnsmessage.Question{aaaa}, a1),
+		"no question":   dnsReply(t, dnsmessage.Header{ID: 7}, nil, a1),
+		"two questions": dnsReply(t, dnsmessage.Header{ID: 7}, []dnsmessage.Question{q, q}, a1),
+	} {
+		if _, _, _, err := dnsParse(b, 7, name, dnsmessage.TypeA); !errors.Is(err, errDNSMismatch) { t.Errorf("%s mismatch: err = %v", what, err) }
+	}
+
+	if _, _, tc, err := dnsParse(dnsReply(t, dnsmessage.Header{ID: 7, Truncated: true}, []dnsmessage.Question{q}), 7, name, dnsmessage.TypeA); err != nil || !tc { t.Errorf("truncated: tc=%v %v", tc, err) }
+	if _, _, _, err := dnsParse(dnsReply(t, dnsmessage.Header{ID: 7, RCode: dnsmessage.RCodeNameError}, []dnsmessage.Question{q}), 7, name, dnsmessage.TypeA); !errors.Is(err, errNoSuchHost) { t.Errorf("NXDOMAIN: %v", err) }
+	if _, _, _, err := dnsParse(dnsReply(t, dnsmessage.Header{ID: 7}, []dnsmessage.Question{q}, cname), 7, name, dnsmessage.TypeA); !errors.Is(err, errNoSuchHost) { t.Errorf("no A records: %v", err) }
+	if _, _, _, err := dnsParse(dnsReply(t, dnsmessage.Header{ID: 7, RCode: dnsmessage.RCodeServerFailure}, []dnsmessage.Question{q}), 7, name, dnsmessage.TypeA); err == nil || errors.Is(err, errNoSuchHost) { t.Errorf("SERVFAIL: %v", err) }
+	for n := range len(good) {
+		if _, _, _, err := dnsParse(good[:n], 7, name, dnsmessage.TypeA); err == nil { t.Errorf("reply cut to %d bytes parsed", n) }
+	}
+}
+
+// fakeDNS answers A queries for ok.test with 192.0.2.1 after delay; other
+// names get NXDOMAIN. A spoofed reply with the wrong id goes out first.
+func fakeDNS(t *testing.T, delay time.Duration) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil { t.Fatal(err) }
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil { return }
+			var m dnsmessage.Message
+			if m.Unpack(buf[:n]) != nil || len(m.Questions) != 1 { continue }
+			q := m.Questions[0]
+			go func() {
+				time.Sleep(delay)
+				h := dnsmessage.Header{ID: m.Header.ID ^ 1}
+				spoof := dnsmessage.Message{Header: h, Questions: m.Questions, Answers: []dnsmessage.Resource{{Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60}, Body: &dnsmessage.AResource{A: [4]byte{6, 6, 6, 6}}}}}
+				spoof.Header.Response = true
+				if b, err := spoof.Pack(); err == nil { pc.WriteTo(b, from) }
+				r := dnsmessage.Message{Header: dnsmessage.Header{ID: m.Header.ID, Response: true}, Questions: m.Questions}
+				switch {
+				case !strings.EqualFold(q.Name.String(), "ok.test."): r.Header.RCode = dnsmessage.RCodeNameError
+				case q.Type == dnsmessage.TypeA:
+					r.Answers = []dnsmessage.Resource{{Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60}, Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}}}
+				}
+				if b, err := r.Pack(); err == nil { pc.WriteTo(b, from) }
+			}()
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func TestDNSResolverShared(t *testing.T) {
+	r := newDNSResolver(Config{DNSUpstream: []string{fakeDNS(t, 200*time.Millisecond)}, DNSMinTTL: time.Second, DNSMaxTTL: time.Minute, DNSNegativeTTL: time.Second})
+	// the caller that starts the query gives up; one sharing it still gets the answer
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	first := make(chan error, 1)
+	go func() { _, err := r.resolve(ctx, "ok.test"); first <- err }()
+	time.Sleep(10 * time.Millisecond)
+	ips, err := r.resolve(context.Background(), "OK.test.")
+	if err != nil || len(ips) != 1 || !ips[0].Equal(net.IPv4(192, 0, 2, 1)) { t.Fatalf("shared lookup = %v, %v", ips, err) }
+	if err := <-first; !errors.Is(err, context.DeadlineExceeded) { t.Errorf("first caller: %v", err) }
+
+	if _, err := r.resolve(context.Background(), "missing.test"); err == nil {
+		t.Error("NXDOMAIN resolved")
+	} else if de, ok := err.(*net.DNSError); !ok || !de.IsNotFound { t.Errorf("NXDOMAIN error = %v", err) }
+}
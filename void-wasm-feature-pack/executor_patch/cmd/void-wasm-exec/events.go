@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Event posting pipeline: bounded queue -> batches -> retry -> spill/drop ---
+var (
+	eventsPosted  = prometheus.NewCounter(prometheus.CounterOpts{Name: "void_wasm_events_posted_total", Help: "Events delivered to relay"})
+	eventsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_events_dropped_total", Help: "Events dropped by reason"}, []string{"reason"})
+	eventsSpilled = prometheus.NewCounter(prometheus.CounterOpts{Name: "void_wasm_events_spilled_total", Help: "Events spilled to disk"})
+	eventRetries  = prometheus.NewCounter(prometheus.CounterOpts{Name: "void_wasm_event_retries_total", Help: "Event POST retries"})
+	eventQueueLen = prometheus.NewGauge(prometheus.GaugeOpts{Name: "void_wasm_event_queue_depth", Help: "Events waiting to be posted"})
+)
+
+type queuedEvent struct {
+	base string
+	ev   map[string]any
+	raw  json.RawMessage // module events are forwarded as emitted, never decoded
+	run  *RunRecord      // nil for node events; not spilled
+	id   string          // assigned when queued, kept across spill and retries
+	at   time.Time
+}
+
+func (qe queuedEvent) body() any { if qe.raw != nil { return qe.raw }; return qe.ev }
+
+var (
+	eventQ       chan queuedEvent
+	eventSending atomic.Int64 // dequeued but not yet delivered/spilled
+	spillMu      sync.Mutex
+)
+
+func postEvent(cfg Config, ev map[string]any) { postRunEvent(cfg, nil, ev) }
+
+// postRunEvent posts an event on behalf of a run (rec may be nil).
+func postRunEvent(cfg Config, rec *RunRecord, ev map[string]any) {
+	withCorrelation(rec, ev, nil)
+	streamRunEvent(rec, ev, nil)
+	if localSink != nil { localSink(ev); return }
+	if cfg.CloudEvents { b, _ := json.Marshal(ev); postRaw(cfg, rec, b); return }
+	queueEvent(cfg, queuedEvent{base: cfg.RelayBase, ev: ev, run: rec})
+}
+
+// postRaw queues an event the module emitted; raw must not be reused.
+func postRaw(cfg Config, rec *RunRecord, raw []byte) {
+	raw = withCorrelation(rec, nil, raw)
+	streamRunEvent(rec, nil, raw)
+	if localSink != nil { var ev map[string]any; json.Unmarshal(raw, &ev); localSink(ev); return }
+	if cfg.CloudEvents { raw = wrapCloudEvent(cfg, rec, raw) }
+	queueEvent(cfg, queuedEvent{base: cfg.RelayBase, raw: raw, run: rec})
+}
+
+func queueEvent(cfg Config, qe queuedEvent) {
+	if qe.id == "" { qe.at = time.Now(); qe.id = newRunID(qe.at) }
+	select {
+	case eventQ <- qe:
+		eventQueueLen.Inc()
+	default:
+		spillOrDrop(cfg, []queuedEvent{qe}, "queue_full")
+	}
+}
+
+func startEventPipeline(cfg Config) {
+	eventQ = make(chan queuedEvent, cfg.EventQueue)
+	go eventWorker(cfg)
+	if cfg.NATSURL != "" { startNATS(cfg) } // sinknats.go
+	if cfg.EventSpillDir != "" { go replaySpill(cfg) }
+}
+
+func eventWorker(cfg Config) {
+	batch := make([]queuedEvent, 0, cfg.EventBatch)
+	tick := time.NewTicker(cfg.EventFlush)
+	defer tick.Stop()
+	flush := func() {
+		if len(batch) == 0 { return }
+		sendBatch(cfg, batch)
+		eventSending.Add(-int64(len(batch)))
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case qe := <-eventQ:
+			eventQueueLen.Dec()
+			eventSending.Add(1)
+			batch = append(batch, qe)
+			if len(batch) >= cfg.EventBatch { flush() }
+		case <-tick.C:
+			flush()
+		}
+	}
+}
+
+// sendBatch posts the whole batch as a JSON array when EVENT_BATCH_POST is
+// set, otherwise one POST per event (the stock relay /event takes one). The
+// gRPC sink always publishes the batch as one EventBatch. Run events are
+// also handed to the NATS worker when nats_url is set.
+func sendBatch(cfg Config, batch []queuedEvent) {
+	if natsQ != nil { offerNATS(batch) }
+	if cfg.EventSink == "grpc" {
+		if err := publishBatch(cfg, batch); err != nil {
+			fmt.Println("[grpc] publish:", err)
+			postFailed(cfg, batch, err)
+			return
+		}
+		eventsPosted.Add(float64(len(batch)))
+		return
+	}
+	if cfg.EventBatchPost != "" {
+		evs := make([]any, len(batch))
+		for i, qe := range batch { evs[i] = qe.body() }
+		if err := postWithRetry(cfg, batch[0].base+cfg.EventBatchPost, evs); err != nil {
+			postFailed(cfg, batch, err)
+			return
+		}
+		eventsPosted.Add(float64(len(batch)))
+		return
+	}
+	for _, qe := range batch {
+		if err := postWithRetry(cfg, qe.base+cfg.EventPost, qe.body()); err != nil {
+			postFailed(cfg, []queuedEvent{qe}, err)
+			continue
+		}
+		eventsPosted.Inc()
+	}
+}
+
+type permanentErr struct{ status int }
+
+func (e permanentErr) Error() string { return fmt.Sprintf("relay rejected event: status %d", e.status) }
+
+// postFailed drops events the relay rejected (a retry or a replay from the
+// spill would only be rejected again) and spills the rest.
+func postFailed(cfg Config, evs []queuedEvent, err error) {
+	var pe permanentErr
+	if errors.As(err, &pe) {
+		fmt.Println("[events] dropping", len(evs), "event(s):", err)
+		eventsDropped.WithLabelValues("rejected").Add(float64(len(evs)))
+		return
+	}
+	spillOrDrop(cfg, evs, "retries_exhausted")
+}
+
+func postWithRetry(cfg Config, url string, payload any) error {
+	body, ctype, err := encodeBody(cfg, payload)
+	if err != nil { return permanentErr{status: 0} }
+	sig := eventSignature(cfg, body)
+	backoff := 200 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		req, _ := http.NewRequest("POST", url, bytes.NewReader(body))
+		req.Header.Set("content-type", ctype)
+		if sig != nil { req.Header.Set("x-void-signature", sig.header()) }
+		resp, err := relayClient.Do(req)
+		if err == nil {
+			io.Copy(io.Discard, resp.Body) // drain so the connection goes back to the pool
+			resp.Body.Close()
+			if resp.StatusCode < 300 { relayOK.Add(1); eventBytes.WithLabelValues(encodingOf(ctype)).Add(float64(len(body))); return nil }
+			if resp.StatusCode == 415 && isCBOR(ctype) {
+				if !cborRefused.Swap(true) { fmt.Println("[events] relay refused CBOR, falling back to JSON") }
+				return postWithRetry(cfg, url, payload)
+			}
+			if resp.StatusCode < 500 && resp.StatusCode != 429 { return permanentErr{status: resp.StatusCode} }
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		}
+		relayFail.Add(1)
+		if attempt >= cfg.EventRetries { return err }
+		eventRetries.Inc()
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// --- Disk spill (NDJSON) ---
+func spillPath(cfg Config) string { return filepath.Join(cfg.EventSpillDir, "events.ndjson") }
+
+func spillOrDrop(cfg Config, evs []queuedEvent, reason string) {
+	if cfg.EventSpillDir == "" { eventsDropped.WithLabelValues(reason).Add(float64(len(evs))); return }
+	n := writeSpill(cfg, evs)
+	eventsSpilled.Add(float64(n))
+	if n < len(evs) { eventsDropped.WithLabelValues("spill_error").Add(float64(len(evs) - n)) }
+}
+
+func writeSpill(cfg Config, evs []queuedEvent) int {
+	spillMu.Lock(); defer spillMu.Unlock()
+	os.MkdirAll(cfg.EventSpillDir, 0o755)
+	f, err := os.OpenFile(spillPath(cfg), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil { return 0 }
+	defer f.Close()
+	n := 0
+	for _, qe := range evs {
+		b, _ := json.Marshal(spilledEvent{ID: qe.id, At: qe.at.UnixNano(), Event: qe.body()})
+		if _, err := f.Write(append(b, '\n')); err != nil { break }
+		n++
+	}
+	return n
+}
+
+// spilledEvent is one spill line: the event as it would be posted, with the
+// id and time it was queued under so a replay publishes it unchanged.
+type spilledEvent struct {
+	ID    string `json:"void_spill_id"`
+	At    int64  `json:"at"`
+	Event any    `json:"event"`
+}
+
+// unspill reads a spill line; lines from before ids were spilled are the
+// bare event and get a fresh id.
+func unspill(cfg Config, line []byte) (queuedEvent, bool) {
+	if !json.Valid(line) { return queuedEvent{}, false }
+	var s struct {
+		ID    string          `json:"void_spill_id"`
+		At    int64           `json:"at"`
+		Event json.RawMessage `json:"event"`
+	}
+	if json.Unmarshal(line, &s) == nil && s.ID != "" && s.Event != nil {
+		return queuedEvent{base: cfg.RelayBase, raw: bytes.Clone(s.Event), id: s.ID, at: time.Unix(0, s.At)}, true
+	}
+	now := time.Now()
+	return queuedEvent{base: cfg.RelayBase, raw: bytes.Clone(line), id: newRunID(now), at: now}, true
+}
+
+// replaySpill periodically moves spilled events back into the queue.
+func replaySpill(cfg Config) {
+	for {
+		time.Sleep(5 * time.Second)
+		spillMu.Lock()
+		replay := spillPath(cfg) + ".replay"
+		err := os.Rename(spillPath(cfg), replay)
+		spillMu.Unlock()
+		if err != nil { continue }
+		f, err := os.Open(replay)
+		if err != nil { continue }
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 64*1024), 4<<20)
+		var rest []queuedEvent
+		for sc.Scan() {
+			qe, ok := unspill(cfg, sc.Bytes())
+			if !ok { continue }
+			if rest != nil { rest = append(rest, qe); continue }
+			select {
+			case eventQ <- qe:
+				eventQueueLen.Inc()
+			default:
+				rest = append(rest, qe)
+			}
+		}
+		f.Close()
+		os.Remove(replay)
+		// queue full again: the unsent tail goes back to disk, in order
+		if len(rest) == 0 { continue }
+		if n := writeSpill(cfg, rest); n < len(rest) { eventsDropped.WithLabelValues("spill_error").Add(float64(len(rest) - n)) }
+	}
+}
+
+// flushEvents waits until the queue and in-flight posts are empty; whatever
+// is left at the deadline is spilled (or counted as dropped).
+func flushEvents(cfg Config, timeout time.Duration) {
+	if eventQ == nil { return }
+	deadline := time.Now().Add(timeout)
+	for len(eventQ) > 0 || eventSending.Load() > 0 || natsPending.Load() > 0 {
+		if time.Now().After(deadline) { break }
+		time.Sleep(50 * time.Millisecond)
+	}
+	var left []queuedEvent
+	for {
+		select {
+		case qe := <-eventQ:
+			eventQueueLen.Dec()
+			left = append(left, qe)
+			continue
+		default:
+		}
+		break
+	}
+	if len(left) > 0 { spillOrDrop(cfg, left, "shutdown") }
+}
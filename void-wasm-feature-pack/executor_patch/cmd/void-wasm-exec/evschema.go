@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// --- Event schemas (void.manifest) ---
+// A module may carry a "void.manifest" custom section, a JSON object whose
+// "events" maps event types to JSON Schemas:
+//   {"events": {"ci.result": {"type":"object","required":["ok"]}}, "strict": true}
+// Every event the module forwards (plain lines and syscall.emit) with a
+// declared type is validated; with strict, undeclared types fail too.
+// event_schema_action decides what happens to a failing event: flag forwards
+// it with a "schema_error" field so relay consumers can tell, reject drops
+// it, off skips validation. A manifest that does not parse or compile fails
+// the run before it starts. Schemas cannot $ref anything outside the
+// manifest. `void-wasm-exec manifest --set m.json module.wasm` embeds one.
+// Compiled manifests are kept per module path, most recently used first,
+// for as many modules as module_lru (at least 16); a rewritten file
+// replaces its entry.
+
+const manifestSection = "void.manifest"
+
+var eventSchemaTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_event_schema_total", Help: "Validated module events by result"}, []string{"result"})
+
+type eventSchemas struct {
+	events map[string]*jsonschema.Schema
+	strict bool
+}
+
+type manifestEntry struct {
+	path  string
+	stamp string // size@mtime of the file compiled
+	s     *eventSchemas // nil = no manifest
+}
+
+var (
+	manifestMu    sync.Mutex
+	manifestOrder = list.New() // front = most recently used
+	manifestIndex = map[string]*list.Element{}
+)
+
+const manifestCacheMin = 16
+
+// eventSchemasFor loads and compiles path's manifest once per module file.
+func eventSchemasFor(cfg Config, path string) (*eventSchemas, error) {
+	st, err := os.Stat(path)
+	if err != nil { return nil, err }
+	stamp := fmt.Sprintf("%d@%d", st.Size(), st.ModTime().UnixNano())
+	manifestMu.Lock()
+	if el, ok := manifestIndex[path]; ok && el.Value.(*manifestEntry).stamp == stamp {
+		manifestOrder.MoveToFront(el)
+		s := el.Value.(*manifestEntry).s
+		manifestMu.Unlock()
+		return s, nil
+	}
+	manifestMu.Unlock()
+	data, err := readModule(path)
+	if err != nil { return nil, err }
+	var s *eventSchemas
+	if raw, ok := customSection(data, manifestSection); ok {
+		if s, err = compileManifest(raw); err != nil { return nil, fmt.Errorf("%s: %w", manifestSection, err) }
+	}
+	manifestMu.Lock(); defer manifestMu.Unlock()
+	if el, ok := manifestIndex[path]; ok { manifestOrder.Remove(el) }
+	manifestIndex[path] = manifestOrder.PushFront(&manifestEntry{path: path, stamp: stamp, s: s})
+	for manifestOrder.Len() > max(cfg.ModuleLRU, manifestCacheMin) {
+		el := manifestOrder.Back()
+		manifestOrder.Remove(el)
+		delete(manifestIndex, el.Value.(*manifestEntry).path)
+	}
+	return s, nil
+}
+
+func compileManifest(raw []byte) (*eventSchemas, error) {
+	var m struct {
+		Events map[string]json.RawMessage `json:"events"`
+		Strict bool                       `json:"strict"`
+	}
+	if err := json.Unmarshal(raw, &m); err != nil { return nil, err }
+	s := &eventSchemas{events: map[string]*jsonschema.Schema{}, strict: m.Strict}
+	c := jsonschema.NewCompiler()
+	c.UseLoader(jsonschema.SchemeURLLoader{}) // no file or network $refs
+	for typ, doc := range m.Events {
+		v, err := jsonschema.UnmarshalJSON(bytes.NewReader(doc))
+		if err != nil { return nil, fmt.Errorf("events[%s]: %w", typ, err) }
+		u := "urn:void:manifest:" + typ
+		if err := c.AddResource(u, v); err != nil { return nil, fmt.Errorf("events[%s]: %w", typ, err) }
+		if s.events[typ], err = c.Compile(u); err != nil { return nil, fmt.Errorf("events[%s]: %w", typ, err) }
+	}
+	return s, nil
+}
+
+// admit validates one forwarded event; false = drop it. Flagged events get
+// schema_error written into f.
+func (s *eventSchemas) admit(cfg Config, rec *RunRecord, f *frame) bool {
+	var ev map[string]any
+	switch {
+	case f.event != nil:
+		if json.Unmarshal(f.event, &ev) != nil { return true }
+	case f.call != nil:
+		ev, _ = f.call["event"].(map[string]any)
+	}
+	if ev == nil { return true }
+	typ, _ := ev["type"].(string)
+	sch, ok := s.events[typ]
+	if !ok && !s.strict { return true }
+	var verr error
+	if !ok {
+		verr = fmt.Errorf("type %q not declared in %s", typ, manifestSection)
+	} else if err := sch.Validate(ev); err != nil {
+		verr = err
+	}
+	if verr == nil { eventSchemaTotal.WithLabelValues("ok").Inc(); return true }
+	msg := schemaErrorText(verr)
+	if !slices.ContainsFunc(rec.Decisions, func(d string) bool { return strings.HasPrefix(d, "event_schema=") }) { rec.decide("event_schema=" + typ + ":" + cfg.EventSchemaAction) } // first failure only
+	fmt.Println("[schema]", rec.ID, rec.Module, typ+":", msg, rec.corr())
+	eventSchemaTotal.WithLabelValues(cfg.EventSchemaAction).Inc()
+	if cfg.EventSchemaAction == "reject" { return false }
+	ev["schema_error"] = msg
+	if f.event != nil { f.event, _ = json.Marshal(ev) }
+	return true
+}
+
+// schemaErrorText folds the validator's indented report into one line.
+func schemaErrorText(err error) string {
+	lines := strings.Split(err.Error(), "\n")
+	if len(lines) > 1 { lines = lines[1:] } // "jsonschema validation failed with ..."
+	for i, l := range lines { lines[i] = strings.TrimLeft(strings.TrimSpace(l), "- ") }
+	msg := strings.Join(lines, "; ")
+	if len(msg) > 512 { msg = msg[:512] }
+	return msg
+}
+
+// --- wasm custom sections ---
+
+var errNotWasm = errors.New("not a wasm binary")
+
+// customSection returns the payload of the first custom section called name.
+func customSection(wasm []byte, name string) ([]byte, bool) {
+	var found []byte
+	ok := false
+	walkSections(wasm, func(id byte, body []byte) bool {
+		if id != 0 { return true }
+		n, rest, good := sectionName(body)
+		if good && n == name { found, ok = rest, true; return false }
+		return true
+	})
+	return found, ok
+}
+
+// setCustomSection drops every custom section called name and, unless
+// payload is nil, appends a new one.
+func setCustomSection(wasm []byte, name string, payload []byte) ([]byte, error) {
+	if len(wasm) < 8 || !bytes.Equal(wasm[:4], []byte("\x00asm")) { return nil, errNotWasm }
+	out := append([]byte{}, wasm[:8]...)
+	err := walkSections(wasm, func(id byte, body []byte) bool {
+		if n, _, good := sectionName(body); id == 0 && good && n == name { return true }
+		out = append(out, id)
+		out = binary.AppendUvarint(out, uint64(len(body)))
+		out = append(out, body...)
+		return true
+	})
+	if err != nil { return nil, err }
+	if payload != nil {
+		body := binary.AppendUvarint(nil, uint64(len(name)))
+		body = append(append(body, name...), payload...)
+		out = append(out, 0)
+		out = binary.AppendUvarint(out, uint64(len(body)))
+		out = append(out, body...)
+	}
+	return out, nil
+}
+
+func walkSections(wasm []byte, fn func(id byte, body []byte) bool) error {
+	if len(wasm) < 8 || !bytes.Equal(wasm[:4], []byte("\x00asm")) { return errNotWasm }
+	for p := wasm[8:]; len(p) > 0; {
+		id := p[0]
+		size, n := binary.Uvarint(p[1:])
+		if n <= 0 || uint64(len(p)-1-n) < size { return errors.New("truncated section") }
+		body := p[1+n : 1+n+int(size)]
+		p = p[1+n+int(size):]
+		if !fn(id, body) { return nil }
+	}
+	return nil
+}
+
+func sectionName(body []byte) (string, []byte, bool) {
+	l, n := binary.Uvarint(body)
+	if n <= 0 || uint64(len(body)-n) < l { return "", nil, false }
+	return string(body[n : n+int(l)]), body[n+int(l):], true
+}
+
+// manifestLocal: void-wasm-exec manifest [--set m.json | --clear] module.wasm
+func manifestLocal(args []string) int {
+	fs := flag.NewFlagSet("manifest", flag.ExitOnError)
+	set := fs.String("set", "", "embed this manifest JSON (replacing any present)")
+	drop := fs.Bool("clear", false, "remove the manifest")
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, "usage: void-wasm-exec manifest [--set manifest.json | --clear] module.wasm"); fs.PrintDefaults() }
+	fs.Parse(args)
+	if fs.NArg() != 1 { fs.Usage(); return 2 }
+	wasm, err := os.ReadFile(fs.Arg(0))
+	if err != nil { fmt.Fprintln(os.Stderr, "manifest:", err); return 1 }
+	if *set == "" && !*drop {
+		raw, ok := customSection(wasm, manifestSection)
+		if !ok { fmt.Fprintln(os.Stderr, "manifest: none"); return 1 }
+		fmt.Println(string(raw))
+		return 0
+	}
+	var payload []byte
+	if *set != "" {
+		if payload, err = os.ReadFile(*set); err != nil { fmt.Fprintln(os.Stderr, "manifest:", err); return 1 }
+		if _, err := compileManifest(payload); err != nil { fmt.Fprintln(os.Stderr, "manifest:", err); return 1 }
+		var buf bytes.Buffer
+		if json.Compact(&buf, payload) == nil { payload = buf.Bytes() }
+	}
+	out, err := setCustomSection(wasm, manifestSection, payload)
+	if err == nil { err = writeFileAtomic(fs.Arg(0), out, 0o644) }
+	if err != nil { fmt.Fprintln(os.Stderr, "manifest:", err); return 1 }
+	return 0
+}
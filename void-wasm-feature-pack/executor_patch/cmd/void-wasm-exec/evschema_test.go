@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var wasmHeader = []byte("\x00asm\x01\x00\x00\x00")
+
+// testWasm is a header plus a type section and a custom section "other".
+func testWasm() []byte {
+	b := append([]byte{}, wasmHeader...)
+	b = append(b, 1, 4, 1, 0x60, 0, 0) // type section: one func type () -> ()
+	return append(b, 0, 7, 5, 'o', 't', 'h', 'e', 'r', 'x')
+}
+
+func TestWalkSections(t *testing.T) {
+	var ids []byte
+	if err := walkSections(testWasm(), func(id byte, body []byte) bool { ids = append(ids, id); return true }); err != nil || !bytes.Equal(ids, []byte{1, 0}) { t.Fatalf("sections %v, %v", ids, err) }
+	if p, ok := customSection(testWasm(), "other"); !ok || string(p) != "x" { t.Errorf("custom section = %q, %v", p, ok) }
+
+	for name, b := range map[string][]byte{
+		"not wasm":        []byte("\x00asn\x01\x00\x00\x00"),
+		"short header":    wasmHeader[:7],
+		"size past end":   append(append([]byte{}, wasmHeader...), 1, 5, 1, 0x60),
+		"id without size": append(append([]byte{}, wasmHeader...), 1),
+		"unterminated":    append(append([]byte{}, wasmHeader...), 1, 0x80, 0x80, 0x80),
+		"overflow":        append(append([]byte{}, wasmHeader...), 1, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01),
+		"huge size":       append(append([]byte{}, wasmHeader...), 1, 0xff, 0xff, 0xff, 0xff, 0x0f),
+	} {
+		if err := walkSections(b, func(byte, []byte) bool { return true }); err == nil { t.Errorf("%s: walked", name) }
+		if _, err := setCustomSection(b, manifestSection, []byte("{}")); err == nil { t.Errorf("%s: section set", name) }
+		if _, ok := customSection(b, "other"); ok { t.Errorf("%s: custom section found", name) }
+	}
+	full := testWasm()
+	for n := len(wasmHeader) + 1; n < len(full); n++ {
+		if n == len(wasmHeader)+6 { continue } // the type section ends here
+		if err := walkSections(full[:n], func(byte, []byte) bool { return true }); err == nil { t.Errorf("cut to %d bytes: walked", n) }
+	}
+
+	// custom sections whose name does not fit are kept as they are, never matched
+	for _, body := range [][]byte{{}, {0x80}, {9, 'a'}, {0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}} {
+		b := append(append([]byte{}, wasmHeader...), 0, byte(len(body)))
+		b = append(b, body...)
+		out, err := setCustomSection(b, manifestSection, nil)
+		if err != nil || !bytes.Equal(out, b) { t.Errorf("bad name %x: %x, %v", body, out, err) }
+		if _, ok := customSection(b, ""); ok { t.Errorf("bad name %x matched", body) }
+	}
+}
+
+func TestSetCustomSection(t *testing.T) {
+	b, err := setCustomSection(testWasm(), manifestSection, []byte(`{"strict":true}`))
+	if err != nil { t.Fatal(err) }
+	if b, err = setCustomSection(b, manifestSection, []byte(`{}`)); err != nil { t.Fatal(err) }
+	if p, ok := customSection(b, manifestSection); !ok || string(p) != "{}" { t.Errorf("replaced manifest = %q, %v", p, ok) }
+	if p, ok := customSection(b, "other"); !ok || string(p) != "x" { t.Errorf("other section lost: %q", p) }
+	if b, err = setCustomSection(b, manifestSection, nil); err != nil { t.Fatal(err) }
+	if !bytes.Equal(b, testWasm()) { t.Errorf("removing the manifest left %x", b) }
+	if _, err := setCustomSection([]byte("wasm"), manifestSection, nil); !errors.Is(err, errNotWasm) { t.Errorf("short input: %v", err) }
+}
+
+func TestManifestCache(t *testing.T) {
+	t.Cleanup(func() {
+		manifestMu.Lock(); defer manifestMu.Unlock()
+		manifestOrder.Init()
+		clear(manifestIndex)
+	})
+	dir := t.TempDir()
+	write := func(name, manifest string, mtime time.Time) string {
+		b, err := setCustomSection(testWasm(), manifestSection, []byte(manifest))
+		if err != nil { t.Fatal(err) }
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, b, 0o600); err != nil { t.Fatal(err) }
+		os.Chtimes(p, mtime, mtime)
+		return p
+	}
+	t0 := time.Unix(1700000000, 0)
+	for i := range manifestCacheMin + 4 {
+		p := write(fmt.Sprintf("m%d.wasm", i), `{"strict":false}`, t0)
+		if _, err := eventSchemasFor(Config{}, p); err != nil { t.Fatal(err) }
+	}
+	if n := manifestOrder.Len(); n != manifestCacheMin || len(manifestIndex) != n { t.Errorf("cache holds %d (%d indexed), want %d", n, len(manifestIndex), manifestCacheMin) }
+	if _, ok := manifestIndex[filepath.Join(dir, "m0.wasm")]; ok { t.Error("oldest entry kept") }
+
+	p := filepath.Join(dir, fmt.Sprintf("m%d.wasm", manifestCacheMin+3))
+	s, _ := eventSchemasFor(Config{}, p)
+	if s2, _ := eventSchemasFor(Config{}, p); s2 != s { t.Error("unchanged module recompiled") }
+	write(filepath.Base(p), `{"strict":true}`, t0.Add(time.Second))
+	if s, err := eventSchemasFor(Config{}, p); err != nil || s == nil || !s.strict { t.Errorf("rewritten module: %+v, %v", s, err) }
+	if n := manifestOrder.Len(); n != manifestCacheMin { t.Errorf("rewrite added an entry: %d", n) }
+
+	if _, err := eventSchemasFor(Config{ModuleLRU: 64}, write("big.wasm", `{}`, t0)); err != nil { t.Fatal(err) }
+	if n := manifestOrder.Len(); n != manifestCacheMin+1 { t.Errorf("module_lru 64: cache holds %d", n) }
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Weighted fair queueing for the exec stage ---
+// With fair_queue module (or tenant) runs ready to execute wait in one queue
+// per module (tenant) instead of the exec FIFO, and a free exec worker takes
+// the run with the lowest virtual finish tag (self-clocked fair queueing):
+// a run's tag is max(virtual time, its flow's last tag) + cost / weight,
+// where cost is the flow's moving average run time in ms (the average over
+// all flows until its first run finishes) and weight comes from
+// fair_weights (name or prefix*, longest match, default 1). A flow
+// therefore gets worker time in proportion to its weight while others wait,
+// and a flow that was idle starts at the current virtual time instead of
+// cashing in credit. The queues together hold stage_queue runs; fetch
+// workers block past that as they do on the FIFO. A run that waits longer
+// than fair_starve_after counts in void_wasm_fair_starved_total. A flow's
+// cost is forgotten once it has not finished a run for fairCostTTL, so
+// one-off modules do not pile up.
+
+var (
+	fairQueued  = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "void_wasm_fair_queued", Help: "Runs waiting for an exec worker by fair queueing flow"}, []string{"flow"})
+	fairWaitMs  = prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "void_wasm_fair_wait_ms", Help: "Time a run waited in its fair queueing flow", Buckets: []float64{1,5,20,50,100,250,500,1000,2500,5000,10000,30000}}, []string{"flow"})
+	fairStarved = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_fair_starved_total", Help: "Runs that waited longer than fair_starve_after for an exec worker"}, []string{"flow"})
+	fairServed  = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_fair_served_total", Help: "Runs handed to an exec worker by fair queueing flow"}, []string{"flow"})
+)
+
+type fairJob struct {
+	j   *job
+	tag float64
+	at  time.Time
+}
+
+type fairCost struct {
+	ms   float64
+	last time.Time // when a run of the flow last finished
+}
+
+const fairCostTTL = time.Hour
+
+type fairFlow struct {
+	q    []fairJob
+	last float64 // finish tag of the flow's last queued run
+}
+
+var fair struct {
+	sync.Mutex
+	full, ready *sync.Cond
+	flows       map[string]*fairFlow // flows with queued runs
+	costMs      map[string]fairCost  // moving average run time by flow
+	avgMs       float64              // the same over all flows, for new ones
+	n           int
+	vtime       float64
+}
+
+func startFair(cfg Config) {
+	fair.flows, fair.costMs, fair.avgMs = map[string]*fairFlow{}, map[string]fairCost{}, 1
+	fair.full, fair.ready = sync.NewCond(&fair.Mutex), sync.NewCond(&fair.Mutex)
+	execQ = make(chan *job) // the dispatcher hands runs to workers one by one
+	go func() {
+		for {
+			j := fairPop()
+			execQ <- j
+		}
+	}()
+	go func() {
+		for now := range time.Tick(time.Minute) { fairPrune(now) }
+	}()
+}
+
+// fairPrune drops the cost of flows idle past fairCostTTL.
+func fairPrune(now time.Time) {
+	fair.Lock(); defer fair.Unlock()
+	for flow, c := range fair.costMs {
+		if now.Sub(c.last) > fairCostTTL { delete(fair.costMs, flow) }
+	}
+}
+
+// toExec hands j to the exec stage.
+func toExec(j *job) {
+	if fair.flows == nil { j.next(execQ); return }
+	j.queuedAt = time.Now()
+	flow := fairFlowOf(j)
+	w := fairWeight(j.cfg, flow)
+	fair.Lock()
+	defer fair.Unlock()
+	for fair.n >= j.cfg.StageQueue { fair.full.Wait() }
+	f := fair.flows[flow]
+	if f == nil { f = &fairFlow{}; fair.flows[flow] = f }
+	cost := fair.avgMs
+	if c, ok := fair.costMs[flow]; ok { cost = c.ms }
+	f.last = max(fair.vtime, f.last) + cost/w
+	f.q = append(f.q, fairJob{j, f.last, j.queuedAt})
+	fair.n++
+	fairQueued.WithLabelValues(flow).Set(float64(len(f.q)))
+	fair.ready.Signal()
+}
+
+// fairPop takes the queued run with the lowest finish tag.
+func fairPop() *job {
+	fair.Lock()
+	defer fair.Unlock()
+	for fair.n == 0 { fair.ready.Wait() }
+	var best string
+	for name, f := range fair.flows {
+		if len(f.q) > 0 && (best == "" || f.q[0].tag < fair.flows[best].q[0].tag) { best = name }
+	}
+	f := fair.flows[best]
+	fj := f.q[0]
+	f.q = f.q[1:]
+	fair.n--
+	fair.vtime = fj.tag
+	if len(f.q) == 0 { // its last tag is now the virtual time: nothing to keep
+		delete(fair.flows, best)
+		fairQueued.DeleteLabelValues(best)
+	} else {
+		fairQueued.WithLabelValues(best).Set(float64(len(f.q)))
+	}
+	fairServed.WithLabelValues(best).Inc()
+	wait := time.Since(fj.at)
+	fairWaitMs.WithLabelValues(best).Observe(float64(wait.Milliseconds()))
+	if wait > fj.j.cfg.FairStarveAfter { fairStarved.WithLabelValues(best).Inc() }
+	fair.full.Signal()
+	return fj.j
+}
+
+// fairLen is how many runs wait in the fair queues.
+func fairLen() int {
+	fair.Lock(); defer fair.Unlock()
+	return fair.n
+}
+
+// fairDone feeds a finished run's time into its flow's cost.
+func fairDone(j *job) {
+	if fair.flows == nil || j.rec.RunMs <= 0 { return }
+	flow, ms := fairFlowOf(j), float64(j.rec.RunMs)
+	fair.Lock()
+	defer fair.Unlock()
+	if c, ok := fair.costMs[flow]; ok { ms = 0.8*c.ms + 0.2*ms }
+	fair.costMs[flow] = fairCost{ms, time.Now()}
+	fair.avgMs = 0.9*fair.avgMs + 0.1*float64(j.rec.RunMs)
+}
+
+func fairFlowOf(j *job) string {
+	if j.cfg.FairQueue == "tenant" { return j.rec.Tenant }
+	return j.rec.Module
+}
+
+// fairWeight is the fair_weights entry for flow: exact name, else the
+// longest matching prefix*, else 1.
+func fairWeight(cfg Config, flow string) float64 {
+	if w, ok := cfg.FairWeights[flow]; ok { return w }
+	w, best := 1.0, -1
+	for pat, pw := range cfg.FairWeights {
+		if len(pat) > best && allowed(flow, []string{pat}) { w, best = pw, len(pat) }
+	}
+	return w
+}
+
+func validFair(c Config) error {
+	if c.FairQueue != "off" && c.FairQueue != "module" && c.FairQueue != "tenant" { return fmt.Errorf("fair_queue: want off, module or tenant, got %q", c.FairQueue) }
+	for pat, w := range c.FairWeights {
+		if w <= 0 { return fmt.Errorf("fair_weights[%s]: must be > 0", pat) }
+	}
+	if c.FairStarveAfter <= 0 { return errors.New("fair_starve_after: must be > 0") }
+	return nil
+}
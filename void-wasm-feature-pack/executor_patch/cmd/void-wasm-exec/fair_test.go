@@ -0,0 +1,20 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFairPrune(t *testing.T) {
+	saved := fair.costMs
+	t.Cleanup(func() { fair.costMs = saved })
+	now := time.Now()
+	fair.costMs = map[string]fairCost{
+		"busy":    {ms: 10, last: now.Add(-time.Minute)},
+		"edge":    {ms: 20, last: now.Add(-fairCostTTL)},
+		"one-off": {ms: 30, last: now.Add(-fairCostTTL - time.Second)},
+	}
+	fairPrune(now)
+	if _, ok := fair.costMs["one-off"]; ok { t.Error("flow idle past the TTL kept") }
+	if len(fair.costMs) != 2 || fair.costMs["busy"].ms != 10 || fair.costMs["edge"].ms != 20 { t.Errorf("costs = %v", fair.costMs) }
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// --- Fuzz targets for attacker-influenced parsing ---
+// Each target takes raw bytes and panics when an invariant breaks; seeds live
+// in testdata/fuzz/<Target>/. `go test -fuzz FuzzEnvelope` (FuzzSSE,
+// FuzzStdout) explores, plain `go test` replays the seeds.
+
+func FuzzEnvelope(f *testing.F) { fuzzTarget(f, fuzzEnvelope) }
+func FuzzSSE(f *testing.F)      { fuzzTarget(f, fuzzSSE) }
+func FuzzStdout(f *testing.F)   { fuzzTarget(f, fuzzStdout) }
+
+func fuzzTarget(f *testing.F, fn func([]byte)) {
+	localSink = func(map[string]any) {}
+	f.Fuzz(func(t *testing.T, data []byte) { fn(data) })
+}
+
+func fuzzEnvelope(data []byte) {
+	lax, errLax := parseEnvelope(data, false)
+	_, errStrict := parseEnvelope(data, true)
+	if errStrict == nil && errLax != nil { panic("strict accepted what lax rejected: " + errLax.Error()) }
+	if errLax != nil { return }
+	if lax.Type != "signal.wasm" { panic("accepted envelope with type " + lax.Type) }
+	cfg := defaultConfig()
+	cfg.CanaryPercent, cfg.ShardNodes = 50, []string{"a", "b", nodeID(cfg)}
+	selectPath(cfg, &lax)
+	ownsEnvelope(cfg, &lax)
+	unplaced(cfg, &lax)
+	traceID(&lax, "")
+	tenantOf(&lax)
+}
+
+func fuzzSSE(data []byte) {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 4096), 1<<20)
+	for sc.Scan() {
+		payload, ok := sseData(sc.Text())
+		if !ok { continue }
+		if payload != strings.TrimSpace(payload) || payload == "" { panic("untrimmed or empty payload") }
+		if wasmSignal(payload) { fuzzEnvelope([]byte(payload)) }
+	}
+}
+
+func fuzzStdout(data []byte) {
+	f, ok := stdoutFrame(bytes.TrimSpace(data))
+	if !ok { return }
+	if f.event == nil && f.call == nil { panic("ok frame with neither event nor call") }
+	if f.kind != "" && !strings.HasPrefix(f.kind, "syscall.") { panic("bad kind " + f.kind) }
+	if f.event != nil && !json.Valid(f.event) { panic("invalid event bytes") }
+	if f.kind != "" { (&RunRecord{}).traceSyscall(4, time.Now(), f.kind, f.id, "ok", time.Now()) }
+}
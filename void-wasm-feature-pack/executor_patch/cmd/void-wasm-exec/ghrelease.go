@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- GitHub release sources with provenance ---
+// An envelope url may be github://owner/repo@tag#asset.wasm. The executor
+// looks the asset up in the release (github_api, github_token for private
+// repos and rate limits) and, with github_provenance attestation (the
+// default), verifies a Sigstore bundle for it before downloading: the
+// <asset>.sigstore.json or <asset>.bundle asset next to it (cosign
+// sign-blob --bundle, or attest-build-provenance output), else the repo's
+// attestations API (gh attestation). A bundle passes when its certificate
+// chains to sigstore_roots (PEM, the Fulcio root and intermediate) at the
+// log's integrated time, was issued by GitHub Actions' OIDC issuer to a
+// workflow matching github_trusted_workflows (default: any workflow of the
+// same repo), and signs either an in-toto statement with a subject for the
+// asset (its digest, or its name when the release reports none) or the
+// asset digest itself. The integrated time is only believed once the log
+// entry's signed entry timestamp verifies under sigstore_rekor_key (Rekor's
+// public keys, matched by log id) and the logged body carries this
+// certificate; the inclusion proof is not re-checked. github_provenance
+// digest trusts the release's own asset digest only. The verified digest then drives the usual download, sha256 check and
+// cache; an envelope sha256 must agree with it. Resolutions are reused for
+// 10 minutes.
+
+const githubOIDCIssuer = "https://token.actions.githubusercontent.com"
+
+var (
+	oidFulcioIssuerV1 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	oidFulcioIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+var githubProvenance = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_github_provenance_total", Help: "github:// module resolutions by result"}, []string{"result"})
+
+type githubAsset struct {
+	download string // asset API url
+	sha256   string
+	signer   string // workflow identity from the certificate, "" in digest mode
+	at       time.Time
+}
+
+var (
+	githubAssetMu    sync.Mutex
+	githubAssetCache = map[string]githubAsset{}
+)
+
+// parseGitHubSource splits github://owner/repo@tag#asset.
+func parseGitHubSource(raw string) (repo, tag, asset string, err error) {
+	rest, ok := strings.CutPrefix(raw, "github://")
+	rest, asset, _ = strings.Cut(rest, "#")
+	repo, tag, _ = strings.Cut(rest, "@")
+	if !ok || strings.Count(repo, "/") != 1 || tag == "" || asset == "" { return "", "", "", fmt.Errorf("want github://owner/repo@tag#asset, got %q", raw) }
+	return repo, tag, asset, nil
+}
+
+// githubSource rewrites a github:// envelope to the verified asset; env is
+// returned as is for other urls or when its sha256 is already cached.
+func githubSource(cfg Config, env *Envelope) (*Envelope, error) {
+	if !strings.HasPrefix(env.URL, "github://") { return env, nil }
+	if env.SHA256 != "" {
+		if st, err := os.Stat(filepath.Join(cfg.CacheDir, env.SHA256+".wasm")); err == nil && st.Size() > 0 { return env, nil }
+	}
+	a, err := resolveGitHubAsset(cfg, env.URL)
+	if err != nil { githubProvenance.WithLabelValues("error").Inc(); return nil, fmt.Errorf("%s: %w", env.URL, err) }
+	if env.SHA256 != "" && !strings.EqualFold(env.SHA256, a.sha256) { return nil, fmt.Errorf("sha256 mismatch: release asset is %s", a.sha256) }
+	src := *env
+	src.URL, src.SHA256 = a.download, a.sha256
+	return &src, nil
+}
+
+func resolveGitHubAsset(cfg Config, raw string) (githubAsset, error) {
+	githubAssetMu.Lock()
+	a, ok := githubAssetCache[raw]
+	githubAssetMu.Unlock()
+	if ok && time.Since(a.at) < 10*time.Minute { return a, nil }
+	repo, tag, name, err := parseGitHubSource(raw)
+	if err != nil { return a, err }
+	var rel struct {
+		Assets []struct {
+			Name   string `json:"name"`
+			URL    string `json:"url"`
+			Digest string `json:"digest"` // sha256:<hex>
+		} `json:"assets"`
+	}
+	if err := githubGet(cfg, cfg.GitHubAPI+"/repos/"+repo+"/releases/tags/"+tag, "application/vnd.github+json", &rel); err != nil { return a, err }
+	urls := map[string]string{}
+	for _, as := range rel.Assets {
+		urls[as.Name] = as.URL
+		if as.Name == name { a.download, a.sha256 = as.URL, strings.TrimPrefix(as.Digest, "sha256:") }
+	}
+	if a.download == "" { return a, fmt.Errorf("release %s has no asset %s", tag, name) }
+	if cfg.GitHubProvenance == "attestation" {
+		var bundles []json.RawMessage
+		for _, b := range []string{name + ".sigstore.json", name + ".bundle"} {
+			if u := urls[b]; u != "" {
+				var raw json.RawMessage
+				if err := githubGet(cfg, u, "application/octet-stream", &raw); err != nil { return a, fmt.Errorf("%s: %w", b, err) }
+				bundles = append(bundles, raw)
+			}
+		}
+		if len(bundles) == 0 {
+			if a.sha256 == "" { return a, errors.New("no provenance bundle and the release reports no asset digest to look one up") }
+			var att struct{ Attestations []struct{ Bundle json.RawMessage `json:"bundle"` } `json:"attestations"` }
+			if err := githubGet(cfg, cfg.GitHubAPI+"/repos/"+repo+"/attestations/sha256:"+a.sha256, "application/vnd.github+json", &att); err != nil { return a, fmt.Errorf("attestations: %w", err) }
+			for _, x := range att.Attestations { bundles = append(bundles, x.Bundle) }
+		}
+		var errs []error
+		for _, b := range bundles {
+			digest, signer, err := verifyProvenance(cfg, repo, name, a.sha256, b)
+			if err == nil { a.sha256, a.signer = digest, signer; break }
+			errs = append(errs, err)
+		}
+		if a.signer == "" { return a, fmt.Errorf("provenance: %w", errors.Join(append(errs, errors.New("no bundle verified"))...)) }
+	}
+	if !sha256Hex.MatchString(a.sha256) { return a, errors.New("release reports no sha256 digest for the asset") }
+	a.sha256 = strings.ToLower(a.sha256)
+	a.at = time.Now()
+	githubProvenance.WithLabelValues(cfg.GitHubProvenance).Inc()
+	fmt.Printf("[github] %s -> sha256:%s %s\n", raw, a.sha256, a.signer)
+	githubAssetMu.Lock()
+	githubAssetCache[raw] = a
+	githubAssetMu.Unlock()
+	return a, nil
+}
+
+func githubRequest(cfg Config, u, accept string) (*http.Request, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil { return nil, err }
+	req.Header.Set("accept", accept)
+	req.Header.Set("x-github-api-version", "2022-11-28")
+	if cfg.GitHubToken != "" { req.Header.Set("authorization", "Bearer "+cfg.GitHubToken) }
+	return req, nil
+}
+
+func githubGet(cfg Config, u, accept string, out any) error {
+	req, err := githubRequest(cfg, u, accept)
+	if err != nil { return err }
+	resp, err := fetchClient.Do(req)
+	if err != nil { return err }
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil { return err }
+	if resp.StatusCode != 200 { return fmt.Errorf("%s: status %d", u, resp.StatusCode) }
+	return json.Unmarshal(b, out)
+}
+
+// isGitHubAsset reports whether fetchURL should download src as a release
+// asset (API url: octet-stream, token; redirects drop the token).
+func isGitHubAsset(cfg Config, src string) bool {
+	return strings.HasPrefix(src, cfg.GitHubAPI+"/repos/") && strings.Contains(src, "/releases/assets/")
+}
+
+type rawCert struct{ RawBytes []byte `json:"rawBytes"` }
+
+// sigstoreBundle is the part of a Sigstore bundle (v0.1-v0.3) checked here.
+type sigstoreBundle struct {
+	VerificationMaterial struct {
+		Certificate *rawCert                               `json:"certificate"`
+		Chain       *struct{ Certificates []rawCert `json:"certificates"` } `json:"x509CertificateChain"`
+		TlogEntries []tlogEntry                            `json:"tlogEntries"`
+	} `json:"verificationMaterial"`
+	DSSE *struct {
+		Payload     []byte `json:"payload"`
+		PayloadType string `json:"payloadType"`
+		Signatures  []struct{ Sig []byte `json:"sig"` } `json:"signatures"`
+	} `json:"dsseEnvelope"`
+	MessageSignature *struct {
+		MessageDigest struct {
+			Algorithm string `json:"algorithm"`
+			Digest    []byte `json:"digest"`
+		} `json:"messageDigest"`
+		Signature []byte `json:"signature"`
+	} `json:"messageSignature"`
+}
+
+// tlogEntry is a bundle's Rekor entry (protobuf JSON: int64 as strings,
+// bytes as base64).
+type tlogEntry struct {
+	LogIndex         string                                             `json:"logIndex"`
+	LogID            struct{ KeyID []byte `json:"keyId"` }              `json:"logId"`
+	IntegratedTime   string                                             `json:"integratedTime"`
+	InclusionPromise *struct{ SignedEntryTimestamp []byte `json:"signedEntryTimestamp"` } `json:"inclusionPromise"`
+	CanonicalizedBody []byte                                            `json:"canonicalizedBody"`
+}
+
+// verifyProvenance checks a bundle for repo's asset name and returns the
+// sha256 it vouches for and the signing workflow; want is the release's
+// digest for the asset, "" when it reports none.
+func verifyProvenance(cfg Config, repo, name, want string, raw []byte) (digest, signer string, err error) {
+	var b sigstoreBundle
+	if err := json.Unmarshal(raw, &b); err != nil { return "", "", err }
+	vm := b.VerificationMaterial
+	var ders []rawCert
+	if vm.Certificate != nil { ders = append(ders, *vm.Certificate) }
+	if vm.Chain != nil { ders = append(ders, vm.Chain.Certificates...) }
+	if len(ders) == 0 { return "", "", errors.New("bundle has no certificate") }
+	var certs []*x509.Certificate
+	for _, c := range ders {
+		cert, err := x509.ParseCertificate(c.RawBytes)
+		if err != nil { return "", "", err }
+		certs = append(certs, cert)
+	}
+	leaf := certs[0]
+	if len(vm.TlogEntries) == 0 { return "", "", errors.New("bundle has no transparency log entry") }
+	at, err := verifyRekorEntry(cfg, vm.TlogEntries[0], leaf)
+	if err != nil { return "", "", fmt.Errorf("tlog entry: %w", err) }
+	if err := verifyFulcioChain(cfg, leaf, certs[1:], at); err != nil { return "", "", err }
+	if signer, err = githubSigner(cfg, repo, leaf); err != nil { return "", "", err }
+	pub, ok := leaf.PublicKey.(*ecdsa.PublicKey)
+	if !ok { return "", "", errors.New("certificate key is not ECDSA") }
+	switch {
+	case b.DSSE != nil:
+		if len(b.DSSE.Signatures) == 0 || !ecdsaVerify(pub, dssePAE(b.DSSE.PayloadType, b.DSSE.Payload), b.DSSE.Signatures[0].Sig) { return "", "", errors.New("dsse signature does not verify") }
+		var st intotoStatement
+		if err := json.Unmarshal(b.DSSE.Payload, &st); err != nil { return "", "", fmt.Errorf("statement: %w", err) }
+		for _, s := range st.Subject {
+			d := strings.ToLower(s.Digest["sha256"])
+			if d != "" && (strings.EqualFold(d, want) || want == "" && path.Base(s.Name) == name) { return d, signer, nil }
+		}
+		return "", "", fmt.Errorf("statement has no subject for %s (sha256:%s)", name, want)
+	case b.MessageSignature != nil:
+		ms := b.MessageSignature
+		if ms.MessageDigest.Algorithm != "SHA2_256" || len(ms.MessageDigest.Digest) != sha256.Size { return "", "", errors.New("message digest is not sha256") }
+		if !ecdsa.VerifyASN1(pub, ms.MessageDigest.Digest, ms.Signature) { return "", "", errors.New("message signature does not verify") }
+		d := hex.EncodeToString(ms.MessageDigest.Digest)
+		if want != "" && !strings.EqualFold(d, want) { return "", "", fmt.Errorf("bundle is for %s, asset is %s", d, want) }
+		return d, signer, nil
+	}
+	return "", "", errors.New("bundle has neither dsseEnvelope nor messageSignature")
+}
+
+func ecdsaVerify(pub *ecdsa.PublicKey, msg, sig []byte) bool {
+	h := crypto.SHA256
+	if pub.Curve.Params().BitSize > 256 { h = crypto.SHA384 }
+	d := h.New()
+	d.Write(msg)
+	return ecdsa.VerifyASN1(pub, d.Sum(nil), sig)
+}
+
+// verifyRekorEntry checks e's signed entry timestamp under the Rekor key
+// its log id names and that the logged body carries leaf, and returns the
+// now trustworthy integrated time.
+func verifyRekorEntry(cfg Config, e tlogEntry, leaf *x509.Certificate) (time.Time, error) {
+	if e.InclusionPromise == nil { return time.Time{}, errors.New("no signed entry timestamp") }
+	keys, err := rekorKeys(cfg)
+	if err != nil { return time.Time{}, fmt.Errorf("sigstore_rekor_key: %w", err) }
+	logID := hex.EncodeToString(e.LogID.KeyID)
+	key := keys[logID]
+	if key == nil { return time.Time{}, fmt.Errorf("log %s is not in sigstore_rekor_key", logID) }
+	secs, err := strconv.ParseInt(e.IntegratedTime, 10, 64)
+	if err != nil { return time.Time{}, fmt.Errorf("integratedTime: %w", err) }
+	idx, err := strconv.ParseInt(e.LogIndex, 10, 64)
+	if err != nil { return time.Time{}, fmt.Errorf("logIndex: %w", err) }
+	// the SET signs this canonical JSON (keys sorted, as the fields are)
+	set, _ := json.Marshal(struct {
+		Body           string `json:"body"`
+		IntegratedTime int64  `json:"integratedTime"`
+		LogID          string `json:"logID"`
+		LogIndex       int64  `json:"logIndex"`
+	}{base64.StdEncoding.EncodeToString(e.CanonicalizedBody), secs, logID, idx})
+	if !ecdsaVerify(key, set, e.InclusionPromise.SignedEntryTimestamp) { return time.Time{}, errors.New("signed entry timestamp does not verify") }
+	if !logsCert(e.CanonicalizedBody, leaf) { return time.Time{}, errors.New("logged entry is for another certificate") }
+	return time.Unix(secs, 0), nil
+}
+
+// rekorKeys reads sigstore_rekor_key: ECDSA public key PEMs by log id (the
+// sha256 of the PKIX DER).
+func rekorKeys(cfg Config) (map[string]*ecdsa.PublicKey, error) {
+	if cfg.SigstoreRekorKey == "" { return nil, errors.New("not set") }
+	raw, err := os.ReadFile(cfg.SigstoreRekorKey)
+	if err != nil { return nil, err }
+	keys := map[string]*ecdsa.PublicKey{}
+	for {
+		var blk *pem.Block
+		if blk, raw = pem.Decode(raw); blk == nil { break }
+		k, err := x509.ParsePKIXPublicKey(blk.Bytes)
+		if err != nil { return nil, err }
+		ek, ok := k.(*ecdsa.PublicKey)
+		if !ok { return nil, errors.New("not an ECDSA key") }
+		sum := sha256.Sum256(blk.Bytes)
+		keys[hex.EncodeToString(sum[:])] = ek
+	}
+	if len(keys) == 0 { return nil, errors.New("no PEM public key") }
+	return keys, nil
+}
+
+// logsCert reports whether a logged entry body (hashedrekord, dsse,
+// intoto: the verifier is a base64 PEM somewhere in it) holds leaf.
+func logsCert(body []byte, leaf *x509.Certificate) bool {
+	var v any
+	if json.Unmarshal(body, &v) != nil { return false }
+	var walk func(v any) bool
+	walk = func(v any) bool {
+		switch x := v.(type) {
+		case string:
+			b, err := base64.StdEncoding.DecodeString(x)
+			if err != nil { return false }
+			blk, _ := pem.Decode(b)
+			return blk != nil && bytes.Equal(blk.Bytes, leaf.Raw)
+		case []any:
+			for _, e := range x { if walk(e) { return true } }
+		case map[string]any:
+			for _, e := range x { if walk(e) { return true } }
+		}
+		return false
+	}
+	return walk(v)
+}
+
+var (
+	sigstoreRootsOnce sync.Once
+	sigstoreRoots     *x509.CertPool
+	sigstoreInter     *x509.CertPool
+	sigstoreRootsErr  error
+)
+
+// verifyFulcioChain checks leaf against sigstore_roots (self-signed certs
+// are roots, the rest intermediates) at the time it was logged.
+func verifyFulcioChain(cfg Config, leaf *x509.Certificate, chain []*x509.Certificate, at time.Time) error {
+	sigstoreRootsOnce.Do(func() {
+		if cfg.SigstoreRoots == "" { sigstoreRootsErr = errors.New("sigstore_roots not set"); return }
+		raw, err := os.ReadFile(cfg.SigstoreRoots)
+		if err != nil { sigstoreRootsErr = err; return }
+		sigstoreRoots, sigstoreInter = x509.NewCertPool(), x509.NewCertPool()
+		for {
+			var blk *pem.Block
+			if blk, raw = pem.Decode(raw); blk == nil { break }
+			c, err := x509.ParseCertificate(blk.Bytes)
+			if err != nil { sigstoreRootsErr = err; return }
+			if bytes.Equal(c.RawIssuer, c.RawSubject) { sigstoreRoots.AddCert(c) } else { sigstoreInter.AddCert(c) }
+		}
+	})
+	if sigstoreRootsErr != nil { return fmt.Errorf("sigstore_roots: %w", sigstoreRootsErr) }
+	inter := sigstoreInter.Clone()
+	for _, c := range chain { inter.AddCert(c) }
+	_, err := leaf.Verify(x509.VerifyOptions{Roots: sigstoreRoots, Intermediates: inter, CurrentTime: at, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}})
+	return err
+}
+
+// githubSigner checks the certificate came from a trusted GitHub Actions
+// workflow and returns its identity (the SAN URI).
+func githubSigner(cfg Config, repo string, cert *x509.Certificate) (string, error) {
+	issuer := ""
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal(oidFulcioIssuerV2):
+			asn1.Unmarshal(ext.Value, &issuer)
+		case ext.Id.Equal(oidFulcioIssuerV1) && issuer == "":
+			issuer = string(ext.Value)
+		}
+	}
+	if issuer != githubOIDCIssuer { return "", fmt.Errorf("certificate issuer %q is not GitHub Actions", issuer) }
+	if len(cert.URIs) == 0 { return "", errors.New("certificate has no workflow identity") }
+	id := cert.URIs[0].String()
+	trusted := cfg.GitHubTrustedWorkflows
+	if len(trusted) == 0 { trusted = []string{"https://github.com/" + repo + "/.github/workflows/*"} }
+	for _, t := range trusted {
+		if ok, _ := path.Match(t, strings.SplitN(id, "@", 2)[0]); ok || (strings.HasSuffix(t, "/*") && strings.HasPrefix(id, strings.TrimSuffix(t, "*"))) { return id, nil }
+	}
+	return "", fmt.Errorf("workflow %s is not in github_trusted_workflows", id)
+}
+
+func validGitHubSources(c Config) error {
+	if c.GitHubProvenance != "attestation" && c.GitHubProvenance != "digest" { return fmt.Errorf("github_provenance: want attestation or digest, got %q", c.GitHubProvenance) }
+	if !strings.HasPrefix(c.GitHubAPI, "https://") && !strings.HasPrefix(c.GitHubAPI, "http://") { return fmt.Errorf("github_api: invalid url %q", c.GitHubAPI) }
+	for _, w := range c.GitHubTrustedWorkflows {
+		if _, err := path.Match(w, ""); err != nil { return fmt.Errorf("github_trusted_workflows: %q: %w", w, err) }
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sigstoreFixture is a fake Fulcio root and Rekor key in cfg, and a leaf
+// for a workflow of owner/repo, valid ten minutes around logged.
+type sigstoreFixture struct {
+	cfg     Config
+	root    *x509.Certificate
+	rootKey *ecdsa.PrivateKey
+	rekor   *ecdsa.PrivateKey
+	logged  time.Time
+}
+
+func newSigstoreFixture(t *testing.T) *sigstoreFixture {
+	t.Helper()
+	f := &sigstoreFixture{logged: time.Now().Add(-time.Hour).Truncate(time.Second)} // the leaf has long expired
+	f.rootKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "test fulcio"}, NotBefore: f.logged.Add(-time.Hour), NotAfter: f.logged.Add(24 * time.Hour), IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &f.rootKey.PublicKey, f.rootKey)
+	if err != nil { t.Fatal(err) }
+	f.root, _ = x509.ParseCertificate(der)
+	f.rekor, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rk, _ := x509.MarshalPKIXPublicKey(&f.rekor.PublicKey)
+	dir := t.TempDir()
+	f.cfg = Config{SigstoreRoots: filepath.Join(dir, "roots.pem"), SigstoreRekorKey: filepath.Join(dir, "rekor.pem")}
+	os.WriteFile(f.cfg.SigstoreRoots, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(f.cfg.SigstoreRekorKey, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rk}), 0o600)
+	sigstoreRootsOnce = sync.Once{} // the roots are read once per process
+	t.Cleanup(func() { sigstoreRootsOnce = sync.Once{} })
+	return f
+}
+
+func (f *sigstoreFixture) leaf(t *testing.T, issuer, workflow string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	iss, _ := asn1.Marshal(issuer)
+	u, _ := url.Parse(workflow)
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(2), NotBefore: f.logged.Add(-time.Minute), NotAfter: f.logged.Add(10 * time.Minute), KeyUsage: x509.KeyUsageDigitalSignature, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}, URIs: []*url.URL{u}, ExtraExtensions: []pkix.Extension{{Id: oidFulcioIssuerV2, Value: iss}}}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, f.root, &key.PublicKey, f.rootKey)
+	if err != nil { t.Fatal(err) }
+	c, _ := x509.ParseCertificate(der)
+	return c, key
+}
+
+// bundle is a DSSE bundle over an in-toto statement with subjects
+// (name -> sha256), logged at when by rekor for cert logged.
+func (f *sigstoreFixture) bundle(t *testing.T, leaf *x509.Certificate, key *ecdsa.PrivateKey, subjects map[string]string, when time.Time, rekor *ecdsa.PrivateKey, logged *x509.Certificate) []byte {
+	t.Helper()
+	st := intotoStatement{Type: "https://in-toto.io/Statement/v1", PredicateType: "https://slsa.dev/provenance/v1"}
+	for n, d := range subjects { st.Subject = append(st.Subject, intotoSubject{n, map[string]string{"sha256": d}}) }
+	payload, _ := json.Marshal(st)
+	sum := sha256.Sum256(dssePAE("application/vnd.in-toto+json", payload))
+	sig, _ := ecdsa.SignASN1(rand.Reader, key, sum[:])
+	body, _ := json.Marshal(map[string]any{"apiVersion": "0.0.1", "kind": "dsse", "spec": map[string]any{"signatures": []any{map[string]any{
+		"signature": base64.StdEncoding.EncodeToString(sig),
+		"verifier":  base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: logged.Raw})),
+	}}}})
+	rk, _ := x509.MarshalPKIXPublicKey(&f.rekor.PublicKey) // the log id stays the trusted one
+	logID := sha256.Sum256(rk)
+	set, _ := json.Marshal(map[string]any{"body": base64.StdEncoding.EncodeToString(body), "integratedTime": when.Unix(), "logID": hex.EncodeToString(logID[:]), "logIndex": 42})
+	setSum := sha256.Sum256(set)
+	setSig, _ := ecdsa.SignASN1(rand.Reader, rekor, setSum[:])
+	b, _ := json.Marshal(map[string]any{
+		"mediaType": "application/vnd.dev.sigstore.bundle.v0.3+json",
+		"verificationMaterial": map[string]any{
+			"certificate": map[string]any{"rawBytes": leaf.Raw},
+			"tlogEntries": []any{map[string]any{"logIndex": "42", "logId": map[string]any{"keyId": logID[:]}, "integratedTime": strconv.FormatInt(when.Unix(), 10), "inclusionPromise": map[string]any{"signedEntryTimestamp": setSig}, "canonicalizedBody": body}},
+		},
+		"dsseEnvelope": map[string]any{"payload": payload, "payloadType": "application/vnd.in-toto+json", "signatures": []any{map[string]any{"sig": sig}}},
+	})
+	return b
+}
+
+func TestVerifyProvenance(t *testing.T) {
+	f := newSigstoreFixture(t)
+	const workflow = "https://github.com/acme/mod/.github/workflows/release.yml@refs/tags/v1"
+	leaf, key := f.leaf(t, githubOIDCIssuer, workflow)
+	other, _ := f.leaf(t, githubOIDCIssuer, workflow)
+	stranger, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	mod, cli := strings.Repeat("ab", 32), strings.Repeat("cd", 32)
+	subjects := map[string]string{"dist/module.wasm": mod, "dist/cli": cli}
+	good := f.bundle(t, leaf, key, subjects, f.logged, f.rekor, leaf)
+	edited := strings.Replace(string(good), strconv.FormatInt(f.logged.Unix(), 10), strconv.FormatInt(f.logged.Unix()+1, 10), 1)
+	for _, tc := range []struct {
+		name, asset, want string
+		bundle            []byte
+		digest            string // "" = must fail
+	}{
+		{"good, release digest", "module.wasm", mod, good, mod},
+		{"good, by name", "module.wasm", "", good, mod},
+		{"second subject", "cli", cli, good, cli},
+		{"foreign subject by name", "evil.wasm", "", good, ""},
+		{"foreign subject by digest", "module.wasm", strings.Repeat("ef", 32), good, ""},
+		{"edited time", "module.wasm", mod, []byte(edited), ""},
+		{"logged after expiry", "module.wasm", mod, f.bundle(t, leaf, key, subjects, f.logged.Add(time.Hour), f.rekor, leaf), ""},
+		{"set by another log key", "module.wasm", mod, f.bundle(t, leaf, key, subjects, f.logged, stranger, leaf), ""},
+		{"entry logs another cert", "module.wasm", mod, f.bundle(t, leaf, key, subjects, f.logged, f.rekor, other), ""},
+		{"no signed timestamp", "module.wasm", mod, []byte(strings.Replace(string(good), "inclusionPromise", "x", 1)), ""},
+	} {
+		d, signer, err := verifyProvenance(f.cfg, "acme/mod", tc.asset, tc.want, tc.bundle)
+		switch {
+		case tc.digest == "" && err == nil: t.Errorf("%s: verified as %s", tc.name, d)
+		case tc.digest != "" && err != nil: t.Errorf("%s: %v", tc.name, err)
+		case tc.digest != "" && (d != tc.digest || signer != workflow): t.Errorf("%s: got %s %s", tc.name, d, signer)
+		}
+	}
+}
+
+func TestGitHubSigner(t *testing.T) {
+	f := newSigstoreFixture(t)
+	for _, tc := range []struct {
+		name, issuer, workflow string
+		trusted                []string
+		ok                     bool
+	}{
+		{"own repo", githubOIDCIssuer, "https://github.com/acme/mod/.github/workflows/release.yml@refs/heads/main", nil, true},
+		{"other repo", githubOIDCIssuer, "https://github.com/evil/mod/.github/workflows/release.yml@refs/heads/main", nil, false},
+		{"other issuer", "https://accounts.google.com", "https://github.com/acme/mod/.github/workflows/release.yml@refs/heads/main", nil, false},
+		{"trusted workflow", githubOIDCIssuer, "https://github.com/acme/build/.github/workflows/wasm.yml@refs/tags/v2", []string{"https://github.com/acme/build/.github/workflows/wasm.yml"}, true},
+		{"untrusted workflow", githubOIDCIssuer, "https://github.com/acme/build/.github/workflows/other.yml@refs/tags/v2", []string{"https://github.com/acme/build/.github/workflows/wasm.yml"}, false},
+	} {
+		cert, _ := f.leaf(t, tc.issuer, tc.workflow)
+		cfg := f.cfg
+		cfg.GitHubTrustedWorkflows = tc.trusted
+		if id, err := githubSigner(cfg, "acme/mod", cert); (err == nil) != tc.ok || (tc.ok && id != tc.workflow) { t.Errorf("%s: githubSigner = %q, %v", tc.name, id, err) }
+	}
+}
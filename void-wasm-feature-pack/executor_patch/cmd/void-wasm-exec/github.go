@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- GitHub webhook source (github_addr) ---
+// The wasm/ci/* modules can react to repository activity without a bridge:
+// github_addr serves POST /github, where a repository or organization
+// webhook delivers push, release and workflow_run events. Every delivery
+// must carry X-Hub-Signature-256, the HMAC-SHA256 of the body under
+// github_secret; anything else is 401 (a missing header before the body is
+// read), counted under event "unknown" since the event header is not yet
+// trusted. Each github_hooks entry whose events, repos, branches and actions
+// match turns the delivery into a signal.wasm envelope for its module, which
+// then goes through the same schema checks, intake gates, policy and
+// allowlist as relay envelopes. The guest gets
+//   inputs.github: {event, action, delivery, repo, ref, branch, tag, sha,
+//                   sender, release{tag,name,prerelease}, workflow_run{name,
+//                   status, conclusion, head_sha, url}}
+// and the run's correlation ID is the X-GitHub-Delivery GUID; redeliveries
+// of a GUID seen in the last intent_idempotency_ttl are answered 200
+// without running again. The answer is 202 when every matched run was
+// queued, 202 "partial" when some were, 503 "rejected" (and the GUID
+// forgotten, so a redelivery can retry) when none were, 200 "unmatched"
+// when no hook matched. ping is answered pong. Hooks reload with config.
+
+type GitHubHook struct {
+	Events   []string `yaml:"events"`   // push | release | workflow_run; empty = all three
+	Repos    []string `yaml:"repos"`    // owner/name, path globs; empty = any
+	Branches []string `yaml:"branches"` // push and workflow_run; empty = any
+	Actions  []string `yaml:"actions"`  // release / workflow_run action, e.g. published, completed; empty = any
+	Module   string   `yaml:"module"`
+	URL      string   `yaml:"url"`
+	CID      string   `yaml:"cid"`
+	SHA256   string   `yaml:"sha256"`
+	Caps     []string `yaml:"caps"`
+	Tenant   string   `yaml:"tenant"`
+}
+
+var githubEvents = []string{"push", "release", "workflow_run"}
+
+var githubTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_github_deliveries_total", Help: "GitHub webhook deliveries by event and result"}, []string{"event", "result"})
+
+var (
+	githubMu   sync.Mutex
+	githubSeen = map[string]time.Time{} // delivery GUID -> first seen
+)
+
+func validGitHubHooks(c Config) error {
+	if c.GitHubAddr != "" && c.GitHubSecret == "" { return fmt.Errorf("github_secret: required with github_addr") }
+	for i, h := range c.GitHubHooks {
+		if h.Module == "" || (h.URL == "" && h.CID == "" && h.SHA256 == "") { return fmt.Errorf("github_hooks[%d]: module and one of url, cid, sha256 required", i) }
+		for _, e := range h.Events {
+			if !slices.Contains(githubEvents, e) { return fmt.Errorf("github_hooks[%d]: event %q: want push, release or workflow_run", i, e) }
+		}
+		for _, p := range append(slices.Clone(h.Repos), h.Branches...) {
+			if _, err := path.Match(p, ""); err != nil { return fmt.Errorf("github_hooks[%d]: %q: %w", i, p, err) }
+		}
+	}
+	return nil
+}
+
+func startGitHub(cfg Config) {
+	if cfg.GitHubAddr == "" { return }
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /github", handleGitHub)
+	go func() {
+		if err := http.ListenAndServe(cfg.GitHubAddr, mux); err != nil { fmt.Println("[github] server error:", err) }
+	}()
+}
+
+// githubSigned checks X-Hub-Signature-256 against body.
+func githubSigned(secret string, body []byte, header string) bool {
+	got, ok := strings.CutPrefix(header, "sha256=")
+	if !ok { return false }
+	sig, err := hex.DecodeString(got)
+	if err != nil { return false }
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
+
+// githubPayload is the part of push, release and workflow_run deliveries
+// the envelope carries.
+type githubPayload struct {
+	Action     string `json:"action"`
+	Ref        string `json:"ref"`
+	After      string `json:"after"`
+	Repository struct{ FullName string `json:"full_name"` } `json:"repository"`
+	Sender     struct{ Login string `json:"login"` } `json:"sender"`
+	Release    *struct {
+		TagName    string `json:"tag_name"`
+		Name       string `json:"name"`
+		Prerelease bool   `json:"prerelease"`
+	} `json:"release"`
+	WorkflowRun *struct {
+		Name       string `json:"name"`
+		Status     string `json:"status"`
+		Conclusion string `json:"conclusion"`
+		HeadSHA    string `json:"head_sha"`
+		HeadBranch string `json:"head_branch"`
+		HTMLURL    string `json:"html_url"`
+	} `json:"workflow_run"`
+}
+
+// inputs flattens p for the guest; branch is "" for tags and releases.
+func (p githubPayload) inputs(event, delivery string) (in map[string]any, branch string) {
+	in = map[string]any{"event": event, "action": p.Action, "delivery": delivery, "repo": p.Repository.FullName, "sender": p.Sender.Login}
+	switch {
+	case event == "push":
+		in["ref"], in["sha"] = p.Ref, p.After
+		if b, ok := strings.CutPrefix(p.Ref, "refs/heads/"); ok { branch = b; in["branch"] = b }
+		if t, ok := strings.CutPrefix(p.Ref, "refs/tags/"); ok { in["tag"] = t }
+	case event == "release" && p.Release != nil:
+		in["tag"] = p.Release.TagName
+		in["release"] = map[string]any{"tag": p.Release.TagName, "name": p.Release.Name, "prerelease": p.Release.Prerelease}
+	case event == "workflow_run" && p.WorkflowRun != nil:
+		w := p.WorkflowRun
+		branch = w.HeadBranch
+		in["branch"], in["sha"] = w.HeadBranch, w.HeadSHA
+		in["workflow_run"] = map[string]any{"name": w.Name, "status": w.Status, "conclusion": w.Conclusion, "head_sha": w.HeadSHA, "url": w.HTMLURL}
+	}
+	return in, branch
+}
+
+func globAny(patterns []string, s string) bool {
+	if len(patterns) == 0 { return true }
+	return slices.ContainsFunc(patterns, func(p string) bool { ok, _ := path.Match(p, s); return ok })
+}
+
+func (h GitHubHook) matches(event, repo, branch, action string) bool {
+	if len(h.Events) > 0 && !slices.Contains(h.Events, event) { return false }
+	if !globAny(h.Repos, repo) { return false }
+	if len(h.Branches) > 0 && (branch == "" || !globAny(h.Branches, branch)) { return false }
+	return len(h.Actions) == 0 || slices.Contains(h.Actions, action)
+}
+
+func handleGitHub(w http.ResponseWriter, r *http.Request) {
+	cfg := currentConfig()
+	event, delivery, sig := r.Header.Get("x-github-event"), r.Header.Get("x-github-delivery"), r.Header.Get("x-hub-signature-256")
+	unsigned := func() { // the event header is caller-chosen until the hmac holds
+		githubTotal.WithLabelValues("unknown", "bad_signature").Inc()
+		writeJSON(w, 401, map[string]any{"error": "bad or missing X-Hub-Signature-256"})
+	}
+	if !strings.HasPrefix(sig, "sha256=") { unsigned(); return }
+	body, err := io.ReadAll(io.LimitReader(r.Body, 25<<20)) // GitHub caps payloads at 25 MB
+	if err != nil { writeJSON(w, 400, map[string]any{"error": err.Error()}); return }
+	if !githubSigned(cfg.GitHubSecret, body, sig) { unsigned(); return }
+	if event == "ping" { githubTotal.WithLabelValues(event, "ok").Inc(); writeJSON(w, 200, map[string]any{"status": "pong"}); return }
+	if !slices.Contains(githubEvents, event) { githubTotal.WithLabelValues(event, "ignored").Inc(); writeJSON(w, 200, map[string]any{"status": "ignored"}); return }
+	var p githubPayload
+	if err := json.Unmarshal(body, &p); err != nil { githubTotal.WithLabelValues(event, "invalid").Inc(); writeJSON(w, 400, map[string]any{"error": err.Error()}); return }
+
+	githubMu.Lock()
+	for id, t := range githubSeen {
+		if time.Since(t) > cfg.IntentIdemTTL { delete(githubSeen, id) }
+	}
+	_, dup := githubSeen[delivery]
+	if !dup && delivery != "" { githubSeen[delivery] = time.Now() }
+	githubMu.Unlock()
+	if dup { githubTotal.WithLabelValues(event, "duplicate").Inc(); writeJSON(w, 200, map[string]any{"status": "duplicate"}); return }
+	if !isLeader.Load() {
+		githubMu.Lock(); delete(githubSeen, delivery); githubMu.Unlock()
+		githubTotal.WithLabelValues(event, "rejected").Inc()
+		writeJSON(w, 503, map[string]any{"status": "standby"}); return
+	}
+
+	in, branch := p.inputs(event, delivery)
+	var runs []map[string]any
+	queued := 0
+	for _, h := range cfg.GitHubHooks {
+		if !h.matches(event, p.Repository.FullName, branch, p.Action) { continue }
+		raw, _ := json.Marshal(map[string]any{
+			"type": "signal.wasm", "module": h.Module, "url": h.URL, "cid": h.CID, "sha256": h.SHA256, "caps": h.Caps, "tenant": h.Tenant,
+			"inputs": map[string]any{"github": in},
+			"meta":   map[string]any{"source": "github", "correlation_id": delivery},
+		})
+		env, err := decodeEnvelope("application/json", raw, false)
+		if err != nil { runs = append(runs, map[string]any{"module": h.Module, "status": "invalid", "error": err.Error()}); continue }
+		status := admitEnvelope(&env)
+		if status == "queued" { queued++ }
+		runs = append(runs, map[string]any{"module": h.Module, "status": status})
+	}
+	result, code := "queued", 202
+	switch {
+	case len(runs) == 0:
+		result, code = "unmatched", 200
+	case queued == 0: // nothing ran: a redelivery may still
+		result, code = "rejected", 503
+		githubMu.Lock(); delete(githubSeen, delivery); githubMu.Unlock()
+	case queued < len(runs):
+		result = "partial"
+	}
+	githubTotal.WithLabelValues(event, result).Inc()
+	fmt.Printf("[github] %s %s %s -> %d/%d run(s) queued\n", event, p.Repository.FullName, delivery, queued, len(runs))
+	writeJSON(w, code, map[string]any{"delivery": delivery, "status": result, "runs": runs})
+}
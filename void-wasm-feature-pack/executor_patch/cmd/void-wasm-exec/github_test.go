@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+// The example from GitHub's "Validating webhook deliveries" docs.
+func TestGitHubSigned(t *testing.T) {
+	const secret, body = "It's a Secret to Everybody", "Hello, World!"
+	const good = "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"
+	for _, tc := range []struct {
+		name, secret, body, header string
+		ok                         bool
+	}{
+		{"valid", secret, body, good, true},
+		{"wrong secret", "not it", body, good, false},
+		{"tampered body", secret, body + " ", good, false},
+		{"missing", secret, body, "", false},
+		{"sha1 prefix", secret, body, "sha1=" + good[7:], false},
+		{"no prefix", secret, body, good[7:], false},
+		{"not hex", secret, body, "sha256=zz" + good[9:], false},
+		{"truncated", secret, body, good[:len(good)-2], false},
+		{"upper-case hex", secret, body, "sha256=757107EA0EB2509FC211221CCE984B8A37570B6D7586C22C46F4379C8B043E17", true},
+	} {
+		if got := githubSigned(tc.secret, []byte(tc.body), tc.header); got != tc.ok { t.Errorf("%s: githubSigned = %v, want %v", tc.name, got, tc.ok) }
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// --- Golden outputs: void-wasm-exec run --golden dir/ [--update] module.wasm ---
+// Every dir/<case>.inputs.json is run and the emitted events plus the syscall
+// results are diffed against dir/<case>.golden.ndjson (rewritten by --update).
+// Runs are deterministic: clock is forced to deterministic, so the guest sees
+// the fake clocks (WASI and void.time_*) and seeded rand sources (WASI and
+// void.random); here kv starts empty per case and http.fetch is answered
+// host_denied.
+
+func goldenCase(cfg Config, path, inputsFile string) ([]string, error) {
+	var inputs map[string]any
+	if err := jsonArg("@"+inputsFile, &inputs); err != nil { return nil, err }
+	kvDir, err := os.MkdirTemp("", "void-golden-")
+	if err != nil { return nil, err }
+	defer os.RemoveAll(kvDir)
+	saved := kvPath
+	kvPath = filepath.Join(kvDir, "kv.json")
+	defer func() { kvPath = saved }()
+
+	var lines []string
+	localSink = func(ev map[string]any) { b, _ := json.Marshal(ev); lines = append(lines, string(b)) }
+	env := &Envelope{Type: "signal.wasm", Module: path, Inputs: inputs, Caps: cfg.AllowCaps}
+	rec := &RunRecord{ID: newRunID(time.Now()), Module: path, Tenant: defaultTenant, Path: pathStable}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DefaultTO)
+	defer cancel()
+	if err := runWasm(ctx, cfg, path, env, rec); err != nil { return nil, err }
+	for _, s := range rec.Syscalls {
+		b, _ := json.Marshal(map[string]any{"syscall": s.Kind, "result": s.Result})
+		lines = append(lines, string(b))
+	}
+	return lines, nil
+}
+
+func runGolden(cfg Config, path, dir string, update bool) int {
+	cases, _ := filepath.Glob(filepath.Join(dir, "*.inputs.json"))
+	if len(cases) == 0 { fmt.Fprintln(os.Stderr, "golden: no *.inputs.json in", dir); return 2 }
+	cfg.AllowHTTPHosts = nil
+	cfg.Chaos = false
+	cfg.Clock = "deterministic"
+	failed := 0
+	for _, in := range cases {
+		name := strings.TrimSuffix(filepath.Base(in), ".inputs.json")
+		goldenFile := filepath.Join(dir, name+".golden.ndjson")
+		got, err := goldenCase(cfg, path, in)
+		if err != nil { failed++; fmt.Printf("FAIL  %s: %v\n", name, err); continue }
+		if update {
+			if err := writeFileAtomic(goldenFile, []byte(strings.Join(got, "\n")+"\n"), 0o644); err != nil { failed++; fmt.Printf("FAIL  %s: %v\n", name, err); continue }
+			fmt.Printf("WROTE %s (%d lines)\n", goldenFile, len(got))
+			continue
+		}
+		b, err := os.ReadFile(goldenFile)
+		if err != nil { failed++; fmt.Printf("FAIL  %s: %v (run with --update to create)\n", name, err); continue }
+		want := strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+		if len(b) == 0 { want = nil }
+		diffs := 0
+		for i := 0; i < len(want) || i < len(got); i++ {
+			var w, g string
+			if i < len(want) { w = want[i] }
+			if i < len(got) { g = got[i] }
+			if w != g { diffs++; fmt.Printf("  %s[%d]\n  - %s\n  + %s\n", name, i, w, g) }
+		}
+		if diffs > 0 { failed++; fmt.Printf("FAIL  %s: %d lines differ\n", name, diffs); continue }
+		fmt.Printf("PASS  %s\n", name)
+	}
+	if !update { fmt.Printf("%d/%d golden cases passed\n", len(cases)-failed, len(cases)) }
+	if failed > 0 { return 1 }
+	return 0
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Grafana annotations ---
+// With grafana_url and grafana_token (a service account token with
+// annotations:write) the executor marks rollout-relevant moments on the
+// dashboards, next to the latency and error graphs they explain:
+//   rollout   first run of a module hash the node has not run before
+//   policy    policies_dir / module_limits change on reload, allowlist updates
+//   freeze    admin pause or drain, maintenance window start
+//   unfreeze  admin resume, maintenance window end
+//   slo       slo.breach and slo.recovered
+// Each is POSTed to /api/annotations with the tags void, <kind>, node:<id>
+// plus grafana_tags, on grafana_dashboard (a dashboard UID) or org-wide when
+// that is empty. Delivery is best effort: one try, off the run path.
+
+var grafanaAnnotations = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_grafana_annotations_total", Help: "Grafana annotations posted, by kind and result"}, []string{"kind", "result"})
+
+// auditAnnotations maps audit actions to annotation kinds.
+var auditAnnotations = map[string]string{
+	"admin.pause": "freeze", "admin.drain": "freeze", "maintenance.start": "freeze",
+	"admin.resume": "unfreeze", "maintenance.end": "unfreeze",
+	"control.allowlist": "policy", "admin.allowlist": "policy", "admin.allowlist.clear": "policy",
+}
+
+var (
+	rolloutMu   sync.Mutex
+	rolloutSeen = map[string]string{} // module -> last sha256 run
+)
+
+// annotate posts one annotation in the background.
+func annotate(cfg Config, kind, text string, tags ...string) {
+	if cfg.GrafanaURL == "" { return }
+	tags = append(append([]string{"void", kind, "node:" + nodeID(cfg)}, tags...), cfg.GrafanaTags...)
+	body := map[string]any{"time": time.Now().UnixMilli(), "tags": tags, "text": text}
+	if cfg.GrafanaDashboard != "" { body["dashboardUID"] = cfg.GrafanaDashboard }
+	b, _ := json.Marshal(body)
+	go func() {
+		req, err := http.NewRequest("POST", cfg.GrafanaURL+"/api/annotations", bytes.NewReader(b))
+		if err != nil { grafanaAnnotations.WithLabelValues(kind, "error").Inc(); return }
+		req.Header.Set("content-type", "application/json")
+		req.Header.Set("authorization", "Bearer "+cfg.GrafanaToken)
+		resp, err := relayClient.Do(req)
+		if err == nil {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			resp.Body.Close()
+			if resp.StatusCode != 200 { err = fmt.Errorf("status %d: %s", resp.StatusCode, bytes.TrimSpace(msg)) }
+		}
+		if err != nil {
+			grafanaAnnotations.WithLabelValues(kind, "error").Inc()
+			fmt.Println("[grafana] annotate", kind, err)
+			return
+		}
+		grafanaAnnotations.WithLabelValues(kind, "ok").Inc()
+	}()
+}
+
+// annotateRollout marks the first run of a module hash; the previous hash
+// comes from history after a restart.
+func annotateRollout(cfg Config, rec *RunRecord) {
+	if cfg.GrafanaURL == "" || rec.SHA256 == "" { return }
+	sha := strings.ToLower(rec.SHA256)
+	rolloutMu.Lock()
+	prev, ok := rolloutSeen[rec.Module]
+	rolloutSeen[rec.Module] = sha
+	rolloutMu.Unlock()
+	if !ok {
+		for _, r := range history.Query(rec.Module, "", 50) {
+			if r.Module == rec.Module && r.SHA256 != "" { prev = strings.ToLower(r.SHA256); break }
+		}
+	}
+	if prev == sha { return }
+	text := fmt.Sprintf("%s: first run of %.12s", rec.Module, sha)
+	if prev != "" { text += fmt.Sprintf(" (was %.12s)", prev) }
+	annotate(cfg, "rollout", text, "module:"+rec.Module)
+}
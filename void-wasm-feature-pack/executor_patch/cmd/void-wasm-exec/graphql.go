@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- GraphQL (syscall.graphql, cap graphql) ---
+// Several void services speak GraphQL; over http.fetch a module would spend
+// its KB budget on request boilerplate and never see the answer. Instead a
+// module names an endpoint declared under graphql_endpoints and sends only
+// the operation:
+//   syscall.graphql {"id","endpoint","query","variables"?,"operation"?}
+//   -> sysret.graphql {"id","endpoint","ok","status","data","errors"?}
+// The executor POSTs {"query","variables","operationName"} to the
+// endpoint's url with its auth as the Authorization header, so modules never
+// hold the credential or pick the host. inject sets variables the module
+// cannot override: a literal, or $tenant, $module, $run, $node, so a
+// service can scope by the caller it is told about. Queries larger than
+// max_query_kb (graphql_max_query_kb) answer ok:false, "error":
+// "query_too_large"; responses are read up to max_response_kb
+// (graphql_max_response_kb) and a larger one answers "response_too_large".
+// Unlike http.fetch the data and errors are in the reply. Requests share
+// http_rps with http.fetch and the response bytes count as net_kb; hosts in
+// run_token_hosts get the run's token (runtoken.go).
+
+type GraphQLEndpoint struct {
+	URL           string            `yaml:"url"`
+	Auth          string            `yaml:"auth"`    // Authorization header value
+	Inject        map[string]string `yaml:"inject"`  // variable -> literal | $tenant | $module | $run | $node
+	Modules       []string          `yaml:"modules"` // empty = any allowlisted module
+	Tenants       []string          `yaml:"tenants"` // empty = any tenant
+	MaxQueryKB    int               `yaml:"max_query_kb"`    // 0 = graphql_max_query_kb
+	MaxResponseKB int               `yaml:"max_response_kb"` // 0 = graphql_max_response_kb
+}
+
+var graphqlTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_graphql_total", Help: "syscall.graphql requests by endpoint and result"}, []string{"endpoint", "result"})
+
+// graphqlSyscall handles syscall.graphql.
+func graphqlSyscall(cfg Config, rec *RunRecord, payload map[string]any) (result string) {
+	name, _ := payload["endpoint"].(string)
+	ep, declared := cfg.GraphQL[name]
+	defer func() {
+		if !declared { name = "" }
+		graphqlTotal.WithLabelValues(name, result).Inc()
+	}()
+	switch {
+	case !allowed("graphql", cfg.AllowCaps): return "denied"
+	case !declared: return "bad_endpoint"
+	case len(ep.Modules) > 0 && !allowed(rec.Module, ep.Modules), len(ep.Tenants) > 0 && !allowed(rec.Tenant, ep.Tenants): return "endpoint_denied"
+	}
+	id, _ := payload["id"].(string)
+	query, _ := payload["query"].(string)
+	if query == "" { return "bad_query" }
+	reply := map[string]any{"type": "sysret.graphql", "id": id, "endpoint": name, "ok": false}
+	fail := func(result string) string { reply["error"] = result; sysret(cfg, rec, reply); return result }
+	maxQ, maxR := ep.MaxQueryKB, ep.MaxResponseKB
+	if maxQ == 0 { maxQ = cfg.GraphQLMaxQueryKB }
+	if maxR == 0 { maxR = cfg.GraphQLMaxResponseKB }
+	vars, _ := payload["variables"].(map[string]any)
+	if vars == nil { vars = map[string]any{} }
+	for k, v := range ep.Inject { vars[k] = graphqlInject(cfg, rec, v) }
+	body := map[string]any{"query": query, "variables": vars}
+	if op, _ := payload["operation"].(string); op != "" { body["operationName"] = op }
+	b, _ := json.Marshal(body)
+	if len(b) > maxQ<<10 { return fail("query_too_large") }
+	if !httpAllow(cfg) { return "rate_limited" }
+	req, _ := http.NewRequest("POST", ep.URL, bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/graphql-response+json, application/json")
+	if ep.Auth != "" { req.Header.Set("Authorization", ep.Auth) }
+	if u, err := url.Parse(ep.URL); err == nil { setRunToken(cfg, rec, u, req.Header) }
+	resp, err := httpClient.Do(req)
+	if err != nil { fmt.Println("[graphql]", name+":", err, rec.corr()); return "io_err" }
+	defer resp.Body.Close()
+	limit := int64(maxR) << 10
+	if left := rec.budget.netLeft(); left >= 0 && left < limit { limit = left + 1 } // one byte over ends the run
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	rec.NetBytes += int64(len(raw))
+	rec.budget.spend(budgetNet, int64(len(raw)))
+	reply["status"] = resp.StatusCode
+	if int64(len(raw)) > limit { return fail("response_too_large") }
+	var out struct {
+		Data   any   `json:"data"`
+		Errors []any `json:"errors"`
+	}
+	if json.Unmarshal(raw, &out) != nil { return fail("bad_response") }
+	reply["data"] = out.Data
+	if len(out.Errors) > 0 { reply["errors"] = out.Errors }
+	reply["ok"] = resp.StatusCode < 300 && len(out.Errors) == 0
+	sysret(cfg, rec, reply)
+	return "ok"
+}
+
+// graphqlInject resolves one inject value.
+func graphqlInject(cfg Config, rec *RunRecord, v string) string {
+	switch v {
+	case "$tenant": return rec.Tenant
+	case "$module": return rec.Module
+	case "$run": return rec.ID
+	case "$node": return nodeID(cfg)
+	}
+	return v
+}
+
+func validGraphQL(c Config) error {
+	if c.GraphQLMaxQueryKB < 1 || c.GraphQLMaxResponseKB < 1 { return fmt.Errorf("graphql_max_query_kb, graphql_max_response_kb: must be >= 1") }
+	for name, ep := range c.GraphQL {
+		u, err := url.Parse(ep.URL)
+		if !tenantName.MatchString(name) || err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" { return fmt.Errorf("graphql_endpoints[%s]: name [a-z0-9_-] and an http(s) url required", name) }
+		if ep.MaxQueryKB < 0 || ep.MaxResponseKB < 0 { return fmt.Errorf("graphql_endpoints[%s]: limits must be >= 0", name) }
+		for k, v := range ep.Inject {
+			if strings.HasPrefix(v, "$") && graphqlInject(c, &RunRecord{}, v) == v { return fmt.Errorf("graphql_endpoints[%s]: inject %s: unknown %s", name, k, v) }
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Per-module concurrency limits and mutex groups ---
+// module_limits (config, pattern with '*' suffix) or the envelope's policy
+// {max_concurrent, mutex_group} put a run in a group: mutex_group shares one
+// slot between all modules naming it, otherwise the module gets
+// max_concurrent slots of its own. Config wins over the envelope. A run whose
+// group is full waits in the group's FIFO without holding an exec worker and
+// is handed the slot when a run in the group finishes; canceled while it
+// waits, it leaves the FIFO and ends at once. Config patterns match exact
+// name first, then the longest '*' prefix.
+
+type ModuleLimit struct {
+	MaxConcurrent int    `yaml:"max_concurrent"`
+	MutexGroup    string `yaml:"mutex_group"`
+}
+
+var (
+	groupRunning = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "void_wasm_group_running", Help: "Runs holding a slot by concurrency group"}, []string{"group"})
+	groupWaiting = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "void_wasm_group_waiting", Help: "Runs queued for a slot by concurrency group"}, []string{"group"})
+	groupWaitMs  = prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "void_wasm_group_wait_ms", Help: "Time queued for a group slot", Buckets: []float64{1,5,20,50,100,250,500,1000,2500,5000,10000}}, []string{"group"})
+)
+
+type runGroup struct {
+	running int
+	waiting []*job
+}
+
+var (
+	groupsMu sync.Mutex
+	groups   = map[string]*runGroup{}
+)
+
+// groupFor returns the group name and slot count for a run; limit 0 = ungrouped.
+func groupFor(cfg Config, env *Envelope, module string) (string, int) {
+	lim, ok := cfg.ModuleLimits[module]
+	best := -1
+	for pat, l := range cfg.ModuleLimits { // else the longest matching pattern
+		if !ok && len(pat) > best && allowed(module, []string{pat}) { lim, best = l, len(pat) }
+	}
+	ok = ok || best >= 0
+	if !ok && env.Policy != nil {
+		lim.MutexGroup, _ = env.Policy["mutex_group"].(string)
+		if n, _ := env.Policy["max_concurrent"].(float64); n > 0 { lim.MaxConcurrent = int(n) }
+	}
+	if lim.MutexGroup != "" { return "group:" + lim.MutexGroup, 1 }
+	if lim.MaxConcurrent > 0 { return "module:" + module, lim.MaxConcurrent }
+	return "", 0
+}
+
+// acquireGroup takes a slot or parks j; false means j was parked and will be
+// put back on the exec stage holding a slot.
+func acquireGroup(j *job) bool {
+	if j.group == "" || j.hasSlot { return true }
+	groupsMu.Lock(); defer groupsMu.Unlock()
+	g := groups[j.group]
+	if g == nil { g = &runGroup{}; groups[j.group] = g }
+	if g.running < j.groupLimit {
+		g.running++
+		groupRunning.WithLabelValues(j.group).Set(float64(g.running))
+		j.hasSlot = true
+		return true
+	}
+	j.parkedAt = time.Now()
+	g.waiting = append(g.waiting, j)
+	groupWaiting.WithLabelValues(j.group).Set(float64(len(g.waiting)))
+	j.unpark = context.AfterFunc(j.runCtx, func() { dropParked(j) })
+	return false
+}
+
+// dropParked ends a run canceled while it waited for a slot; one already
+// handed a slot is left to the exec stage.
+func dropParked(j *job) {
+	groupsMu.Lock()
+	g := groups[j.group]
+	i := -1
+	if g != nil { i = slices.Index(g.waiting, j) }
+	if i < 0 { groupsMu.Unlock(); return }
+	g.waiting = slices.Delete(g.waiting, i, i+1)
+	groupWaiting.WithLabelValues(j.group).Set(float64(len(g.waiting)))
+	groupsMu.Unlock()
+	runsTotal.WithLabelValues("canceled", j.rec.Module).Inc()
+	j.rec.Result = "canceled"
+	j.finish()
+}
+
+// releaseGroup hands the slot to the next waiter, or frees it.
+func releaseGroup(j *job) {
+	if !j.hasSlot { return }
+	j.hasSlot = false
+	groupsMu.Lock(); defer groupsMu.Unlock()
+	g := groups[j.group]
+	if len(g.waiting) > 0 {
+		next := g.waiting[0]
+		g.waiting = g.waiting[1:]
+		groupWaiting.WithLabelValues(j.group).Set(float64(len(g.waiting)))
+		groupWaitMs.WithLabelValues(j.group).Observe(float64(time.Since(next.parkedAt).Milliseconds()))
+		next.unpark()
+		next.hasSlot = true
+		go toExec(next) // not from this worker: execQ may be full
+		return
+	}
+	g.running--
+	groupRunning.WithLabelValues(j.group).Set(float64(g.running))
+	if g.running == 0 { delete(groups, j.group) }
+}
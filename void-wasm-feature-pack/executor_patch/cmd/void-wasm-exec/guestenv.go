@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Guest environment ---
+// Modules start with an empty WASI environment. An envelope asks for
+// variables by name ("env":{"LOG_LEVEL":"","REGION":"eu"}); only names
+// declared under guest_env in config exist, and policy checks the
+// module/tenant patterns before the run is fetched (deny_env). An empty value
+// means "the configured one": a literal value or, with from_env, the
+// executor's own variable read at run time. A non-empty value is a
+// manifest-level setting and is accepted only where override is true. Values
+// never reach the run decisions, only names.
+
+type GuestEnv struct {
+	Value    string   `yaml:"value"`
+	FromEnv  string   `yaml:"from_env"` // host variable; wins over value when set
+	Modules  []string `yaml:"modules"`  // empty = any allowlisted module
+	Tenants  []string `yaml:"tenants"`  // empty = any tenant
+	Override bool     `yaml:"override"` // envelopes may supply their own value
+}
+
+const (
+	maxGuestEnv      = 32
+	maxGuestEnvValue = 4096
+)
+
+var guestEnvName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,127}$`)
+
+var guestEnvTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_guest_env_total", Help: "Guest environment requests by result"}, []string{"result"})
+
+// validGuestEnv is the envelope-side check (parseEnvelope).
+func validGuestEnv(env map[string]string) error {
+	if len(env) > maxGuestEnv { return fmt.Errorf("at most %d", maxGuestEnv) }
+	for k, v := range env {
+		if !guestEnvName.MatchString(k) { return fmt.Errorf("invalid name %q", k) }
+		if len(v) > maxGuestEnvValue || strings.ContainsRune(v, 0) { return fmt.Errorf("%s: value too long or contains NUL", k) }
+	}
+	return nil
+}
+
+// guestEnvFor resolves and approves env's variables in name order; denied
+// names a variable that policy refuses (err says why).
+func guestEnvFor(cfg Config, env *Envelope, tenant, module string) (vars [][2]string, denied string, err error) {
+	names := make([]string, 0, len(env.Env))
+	for k := range env.Env { names = append(names, k) }
+	sort.Strings(names)
+	for _, k := range names {
+		spec, ok := cfg.GuestEnv[k]
+		switch {
+		case !ok:
+			err = errors.New("not declared")
+		case len(spec.Modules) > 0 && !allowed(module, spec.Modules):
+			err = errors.New("module not allowed")
+		case len(spec.Tenants) > 0 && !allowed(tenant, spec.Tenants):
+			err = errors.New("tenant not allowed")
+		case env.Env[k] != "" && !spec.Override:
+			err = errors.New("value is fixed by config")
+		}
+		if err != nil { return nil, k, err }
+		v := env.Env[k]
+		switch {
+		case v != "":
+		case spec.FromEnv != "":
+			v = os.Getenv(spec.FromEnv)
+		default:
+			v = spec.Value
+		}
+		vars = append(vars, [2]string{k, v})
+	}
+	return vars, "", nil
+}
+
+// guestEnvPolicy is the policy-stage check.
+func guestEnvPolicy(cfg Config, env *Envelope, rec *RunRecord) bool {
+	vars, denied, err := guestEnvFor(cfg, env, rec.Tenant, rec.Module)
+	if err != nil {
+		guestEnvTotal.WithLabelValues("deny").Inc()
+		rec.decide("env=" + denied + ":deny"); rec.Error = "env " + denied + ": " + err.Error()
+		return false
+	}
+	names := make([]string, len(vars))
+	for i, kv := range vars { names[i] = kv[0] }
+	guestEnvTotal.WithLabelValues("ok").Inc()
+	rec.decide("env=" + strings.Join(names, ","))
+	return true
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Self-hardening ---
+// The executor refuses to start as root unless allow_root (or --allow-root)
+// says so. Started as root with run_as: user[:group], it creates its data
+// dirs, hands them to that user and switches to it before opening anything
+// else. On Linux, once startup has loaded its keys and opened its stores:
+//   landlock  on | required: the process can only read and write its own
+//             dirs (cache_dir, history_db, kv, scratch_dir, record_dir,
+//             event_spill_dir, ledger_dir, audit_log, leader_lock, corpus_file, mounts,
+//             the per-run temp dirs, sandbox_paths), read its config,
+//             policies, keys and the system TLS/DNS files, and - on kernels
+//             with Landlock ABI 4 (6.7+) - only connect to the TCP ports of
+//             its configured endpoints (every *_url / relay_base / gateway
+//             in the config, webhooks, github_hooks, graphql, ai and ws
+//             endpoints, 80 and 443 for http.fetch, 53, sandbox_ports)
+//             and bind its own *_addr listeners. "on" carries on with a
+//             warning when the kernel lacks Landlock, "required" refuses to
+//             start.
+//   seccomp   denies exec, ptrace, mount, namespaces, module loading, bpf,
+//             perf and kexec with EPERM, for every thread.
+// Both are one-way: a config reload cannot widen them, so new dirs or
+// endpoints need a restart. void_wasm_sandbox shows the layers in effect.
+
+var sandboxLayers = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "void_wasm_sandbox", Help: "Self-hardening layers in effect (non_root, landlock_fs, landlock_net, seccomp)"}, []string{"layer"})
+
+// sandboxPath is one landlock grant.
+type sandboxPath struct {
+	path string
+	rw   bool
+}
+
+// checkRoot switches to run_as when started as root and refuses to go on as
+// root without allow_root. Windows has no euid (-1) and passes.
+func checkRoot(cfg Config) error {
+	if os.Geteuid() != 0 { return nil }
+	if cfg.RunAs != "" {
+		if err := dropPrivileges(cfg.RunAs, sandboxRW(cfg)); err != nil { return fmt.Errorf("run_as %s: %w", cfg.RunAs, err) }
+		fmt.Println("[harden] running as", cfg.RunAs)
+		return nil
+	}
+	if cfg.AllowRoot { fmt.Println("[harden] running as root (allow_root)"); return nil }
+	return errors.New("refusing to run as root: set run_as, or allow_root / --allow-root")
+}
+
+// hardenSelf applies landlock and seccomp as configured.
+func hardenSelf(cfg Config) error {
+	if os.Geteuid() != 0 { sandboxLayers.WithLabelValues("non_root").Set(1) }
+	if cfg.Landlock != "off" {
+		fs, netOK, err := applyLandlock(cfg)
+		switch {
+		case err != nil && cfg.Landlock == "required":
+			return fmt.Errorf("landlock: %w", err)
+		case err != nil:
+			fmt.Println("[harden] landlock not applied:", err)
+		default:
+			sandboxLayers.WithLabelValues("landlock_fs").Set(1)
+			if netOK { sandboxLayers.WithLabelValues("landlock_net").Set(1) }
+			fmt.Println("[harden] landlock:", fs, "paths, network", map[bool]string{true: "restricted", false: "unrestricted (kernel < 6.7)"}[netOK])
+		}
+	}
+	if cfg.Seccomp {
+		if err := applySeccomp(); err != nil { return fmt.Errorf("seccomp: %w", err) }
+		sandboxLayers.WithLabelValues("seccomp").Set(1)
+		fmt.Println("[harden] seccomp filter installed")
+	}
+	return nil
+}
+
+// sandboxRW are the executor's own data dirs, handed to run_as.
+func sandboxRW(cfg Config) []string {
+	dirs := []string{voidHome(), filepath.Join(os.TempDir(), "void"), cfg.CacheDir, cfg.ScratchDir, cfg.RecordDir, cfg.EventSpillDir, cfg.LedgerDir, filepath.Dir(kvPath)}
+	for _, f := range []string{cfg.HistoryDB, cfg.KVBolt, cfg.QueueBolt, cfg.AuditLog, cfg.LeaderLock, cfg.CorpusFile} {
+		if f != "" { dirs = append(dirs, filepath.Dir(f)) }
+	}
+	out := dirs[:0]
+	for _, d := range dirs {
+		if d != "" && d != "." { out = append(out, d) }
+	}
+	return out
+}
+
+// sandboxPaths is everything landlock grants: the data dirs read-write
+// (created if missing), the rest read-only where it exists.
+func sandboxPaths(cfg Config) []sandboxPath {
+	var ps []sandboxPath
+	for _, d := range sandboxRW(cfg) {
+		os.MkdirAll(d, 0o755)
+		ps = append(ps, sandboxPath{d, true})
+	}
+	for _, m := range cfg.Mounts {
+		if m.Host != "" { os.MkdirAll(m.Host, 0o755); ps = append(ps, sandboxPath{m.Host, m.Mode == "rw"}) }
+	}
+	ro := []string{"/etc/ssl", "/etc/pki", "/etc/ca-certificates", "/usr/share/ca-certificates", "/etc/resolv.conf", "/etc/hosts", "/etc/nsswitch.conf", "/etc/services", "/etc/localtime", "/usr/share/zoneinfo", cfg.PoliciesDir, cfg.SigstoreRoots, cfg.SigstoreRekorKey, cfg.AttestKey}
+	if configPath != "" { ro = append(ro, filepath.Dir(configPath)) }
+	ro = append(ro, cfg.ControlKeys...)
+	ro = append(ro, cfg.RegistryKeys...)
+	if !strings.Contains(cfg.RegistryURL, "://") { ro = append(ro, cfg.RegistryURL) }
+	for _, spec := range append([]string{cfg.AtRestKey, cfg.IdentityKey}, mapValues(cfg.ModuleKeys)...) {
+		if p, ok := strings.CutPrefix(spec, "file:"); ok { ro = append(ro, p) }
+	}
+	for _, p := range ro {
+		if p == "" { continue }
+		if _, err := os.Stat(p); err == nil { ps = append(ps, sandboxPath{p, false}) }
+	}
+	ps = append(ps, sandboxPath{os.DevNull, true})
+	for _, p := range cfg.SandboxPaths {
+		path, ro := strings.CutSuffix(p, ":ro")
+		if !ro { os.MkdirAll(path, 0o755) }
+		ps = append(ps, sandboxPath{path, !ro})
+	}
+	return ps
+}
+
+func mapValues(m map[string]string) []string {
+	out := make([]string, 0, len(m))
+	for _, v := range m { out = append(out, v) }
+	return out
+}
+
+// schemePorts are the default ports of the URL schemes the config uses.
+var schemePorts = map[string]int{"http": 80, "https": 443, "ws": 80, "wss": 443, "s3": 443, "nats": 4222, "tls": 4222, "redis": 6379, "rediss": 6379, "grpc": 443}
+
+// sandboxPorts are the TCP ports the executor may connect to and bind: every
+// URL-valued top-level string in the config, the urls of webhooks,
+// github_hooks and the graphql/ai/ws endpoints, event_grpc and host:port
+// entries of allow_http_hosts (tenants' too) and dns_upstream, plus 53 for DNS
+// over TCP, 80/443 for http.fetch and sandbox_ports; every *_addr for
+// listeners.
+func sandboxPorts(cfg Config) (connect, bind []int) {
+	cs, bs := map[int]bool{53: true, 80: true, 443: true}, map[int]bool{}
+	for _, p := range cfg.SandboxPorts { cs[p] = true }
+	hostPorts := append(append([]string{cfg.EventGRPC}, cfg.AllowHTTPHosts...), cfg.DNSUpstream...)
+	for _, tp := range cfg.Tenants { hostPorts = append(hostPorts, tp.AllowHTTPHosts...) }
+	for _, h := range hostPorts {
+		if _, port, err := net.SplitHostPort(h); err == nil {
+			if n, err := strconv.Atoi(port); err == nil { cs[n] = true }
+		}
+	}
+	v, t := reflect.ValueOf(cfg), reflect.TypeOf(cfg)
+	for i := 0; i < t.NumField(); i++ {
+		var vals []string
+		switch f := v.Field(i); {
+		case f.Kind() == reflect.String:
+			vals = []string{f.String()}
+		case f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.String:
+			vals = f.Interface().([]string)
+		}
+		for _, s := range vals {
+			if strings.HasSuffix(t.Field(i).Name, "Addr") {
+				if _, port, err := net.SplitHostPort(s); err == nil {
+					if n, err := strconv.Atoi(port); err == nil { bs[n] = true }
+				}
+				continue
+			}
+			if n := urlPort(s); n > 0 { cs[n] = true }
+		}
+	}
+	// endpoints nested in lists and maps, which the walk above does not reach
+	var urls []string
+	for _, h := range cfg.Webhooks { urls = append(urls, h.URL) }
+	for _, h := range cfg.GitHubHooks { urls = append(urls, h.URL) }
+	for _, e := range cfg.GraphQL { urls = append(urls, e.URL) }
+	for _, e := range cfg.AI { urls = append(urls, e.URL) }
+	for _, e := range cfg.WS { urls = append(urls, e.URL) }
+	for _, s := range urls {
+		if n := urlPort(s); n > 0 { cs[n] = true }
+	}
+	return sortedPorts(cs), sortedPorts(bs)
+}
+
+// urlPort is the TCP port s points at, 0 when s is not a network URL.
+func urlPort(s string) int {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" { return 0 }
+	if p := u.Port(); p != "" {
+		n, _ := strconv.Atoi(p)
+		return n
+	}
+	return schemePorts[u.Scheme]
+}
+
+func sortedPorts(m map[int]bool) []int {
+	out := make([]int, 0, len(m))
+	for p := range m { out = append(out, p) }
+	sort.Ints(out)
+	return out
+}
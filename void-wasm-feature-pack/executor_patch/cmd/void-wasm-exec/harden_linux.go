@@ -0,0 +1,165 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Landlock uAPI (linux/landlock.h); declared here so older x/sys still builds.
+const (
+	llFSExecute = 1 << iota
+	llFSWriteFile
+	llFSReadFile
+	llFSReadDir
+	llFSRemoveDir
+	llFSRemoveFile
+	llFSMakeChar
+	llFSMakeDir
+	llFSMakeReg
+	llFSMakeSock
+	llFSMakeFifo
+	llFSMakeBlock
+	llFSMakeSym
+	llFSRefer    // ABI 2
+	llFSTruncate // ABI 3
+	llFSIoctlDev // ABI 5
+
+	llNetBindTCP    = 1 << 0 // ABI 4
+	llNetConnectTCP = 1 << 1
+
+	llRulePathBeneath = 1
+	llRuleNetPort     = 2
+	llRulesetVersion  = 1 << 0
+)
+
+type llRulesetAttr struct{ fs, net uint64 }
+
+type llPathBeneath struct {
+	access uint64
+	fd     int32
+}
+
+type llNetPort struct{ access, port uint64 }
+
+// applyLandlock restricts every thread to sandboxPaths and, on ABI 4+, to
+// sandboxPorts. It returns how many paths were granted and whether the
+// network is restricted. Needs a build without cgo (AllThreadsSyscall).
+func applyLandlock(cfg Config) (int, bool, error) {
+	abi, _, e := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, llRulesetVersion)
+	if e != 0 { return 0, false, fmt.Errorf("not supported by this kernel: %w", e) }
+	handled := uint64(llFSMakeSym<<1 - 1)
+	if abi >= 2 { handled |= llFSRefer }
+	if abi >= 3 { handled |= llFSTruncate }
+	if abi >= 5 { handled |= llFSIoctlDev }
+	attr := llRulesetAttr{fs: handled}
+	if abi >= 4 { attr.net = llNetBindTCP | llNetConnectTCP }
+	fd, _, e := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if e != 0 { return 0, false, fmt.Errorf("create ruleset: %w", e) }
+	defer unix.Close(int(fd))
+	addRule := func(typ uintptr, rule unsafe.Pointer) error {
+		_, _, e := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, fd, typ, uintptr(rule), 0, 0, 0)
+		if e != 0 { return e }
+		return nil
+	}
+
+	ro := uint64(llFSReadFile | llFSReadDir)
+	rw := handled &^ (llFSExecute | llFSMakeChar | llFSMakeBlock)
+	fileOnly := uint64(llFSExecute | llFSWriteFile | llFSReadFile | llFSTruncate | llFSIoctlDev)
+	n := 0
+	for _, p := range sandboxPaths(cfg) {
+		pfd, err := unix.Open(p.path, unix.O_PATH|unix.O_CLOEXEC, 0)
+		if err != nil { continue }
+		access := ro
+		if p.rw { access = rw }
+		var st unix.Stat_t
+		if unix.Fstat(pfd, &st) == nil && st.Mode&unix.S_IFMT != unix.S_IFDIR { access &= fileOnly }
+		err = addRule(llRulePathBeneath, unsafe.Pointer(&llPathBeneath{access: access & handled, fd: int32(pfd)}))
+		unix.Close(pfd)
+		if err != nil { return 0, false, fmt.Errorf("%s: %w", p.path, err) }
+		n++
+	}
+	if abi >= 4 {
+		connect, bind := sandboxPorts(cfg)
+		for _, port := range connect {
+			if err := addRule(llRuleNetPort, unsafe.Pointer(&llNetPort{llNetConnectTCP, uint64(port)})); err != nil { return 0, false, fmt.Errorf("port %d: %w", port, err) }
+		}
+		for _, port := range bind {
+			if err := addRule(llRuleNetPort, unsafe.Pointer(&llNetPort{llNetBindTCP, uint64(port)})); err != nil { return 0, false, fmt.Errorf("port %d: %w", port, err) }
+		}
+	}
+	if err := noNewPrivs(); err != nil { return 0, false, err }
+	if _, _, e := syscall.AllThreadsSyscall(unix.SYS_LANDLOCK_RESTRICT_SELF, fd, 0, 0); e != 0 { return 0, false, fmt.Errorf("restrict: %w", e) }
+	return n, abi >= 4, nil
+}
+
+func noNewPrivs() error {
+	if _, _, e := syscall.AllThreadsSyscall(unix.SYS_PRCTL, unix.PR_SET_NO_NEW_PRIVS, 1, 0); e != 0 {
+		if e == syscall.ENOTSUP { return errors.New("no_new_privs: needs a build with CGO_ENABLED=0") }
+		return fmt.Errorf("no_new_privs: %w", e)
+	}
+	return nil
+}
+
+// seccompDenied fail with EPERM; nothing the executor does after startup
+// needs them.
+var seccompDenied = []uintptr{
+	unix.SYS_EXECVE, unix.SYS_EXECVEAT, unix.SYS_PTRACE, unix.SYS_PROCESS_VM_READV, unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_MOUNT, unix.SYS_UMOUNT2, unix.SYS_PIVOT_ROOT, unix.SYS_CHROOT, unix.SYS_UNSHARE, unix.SYS_SETNS,
+	unix.SYS_INIT_MODULE, unix.SYS_FINIT_MODULE, unix.SYS_DELETE_MODULE, unix.SYS_KEXEC_LOAD, unix.SYS_KEXEC_FILE_LOAD,
+	unix.SYS_BPF, unix.SYS_PERF_EVENT_OPEN, unix.SYS_USERFAULTFD, unix.SYS_OPEN_BY_HANDLE_AT,
+	unix.SYS_REBOOT, unix.SYS_SWAPON, unix.SYS_SWAPOFF,
+}
+
+const (
+	seccompSetModeFilter  = 1
+	seccompFlagTsync      = 1
+	seccompRetKillProcess = 0x80000000
+	seccompRetErrno       = 0x00050000
+	seccompRetAllow       = 0x7fff0000
+
+	bpfLdAbs = unix.BPF_LD | unix.BPF_W | unix.BPF_ABS
+	bpfJeq   = unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K
+	bpfJge   = unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K
+	bpfRet   = unix.BPF_RET | unix.BPF_K
+)
+
+// applySeccomp installs a deny-list filter on every thread (TSYNC). Other
+// architectures than the one built for are killed outright.
+func applySeccomp() error {
+	var arch uint32
+	switch runtime.GOARCH {
+	case "amd64":
+		arch = unix.AUDIT_ARCH_X86_64
+	case "arm64":
+		arch = unix.AUDIT_ARCH_AARCH64
+	default:
+		return errors.New("not available on " + runtime.GOARCH)
+	}
+	// seccomp_data: nr at 0, arch at 4
+	prog := []unix.SockFilter{
+		{Code: bpfLdAbs, K: 4},
+		{Code: bpfJeq, Jt: 1, K: arch},
+		{Code: bpfRet, K: seccompRetKillProcess},
+		{Code: bpfLdAbs, K: 0},
+	}
+	if runtime.GOARCH == "amd64" { prog = append(prog, unix.SockFilter{Code: bpfJge, K: 0x40000000}) } // x32 ABI
+	for _, nr := range seccompDenied { prog = append(prog, unix.SockFilter{Code: bpfJeq, K: uint32(nr)}) }
+	prog = append(prog, unix.SockFilter{Code: bpfRet, K: seccompRetAllow}, unix.SockFilter{Code: bpfRet, K: seccompRetErrno | uint32(unix.EPERM)})
+	deny := len(prog) - 1
+	for i := 4; i < deny-1; i++ { prog[i].Jt = uint8(deny - i - 1) }
+
+	if err := noNewPrivs(); err != nil { return err }
+	fprog := unix.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]}
+	if r, _, e := unix.Syscall(unix.SYS_SECCOMP, seccompSetModeFilter, seccompFlagTsync, uintptr(unsafe.Pointer(&fprog))); e != 0 || r != 0 {
+		if e != 0 { return e }
+		return fmt.Errorf("thread %d could not be synchronized", r)
+	}
+	return nil
+}
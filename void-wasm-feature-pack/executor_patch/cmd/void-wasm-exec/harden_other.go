@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"runtime"
+)
+
+func applyLandlock(Config) (int, bool, error) { return 0, false, errors.New("not available on " + runtime.GOOS) }
+
+func applySeccomp() error { return errors.New("not available on " + runtime.GOOS) }
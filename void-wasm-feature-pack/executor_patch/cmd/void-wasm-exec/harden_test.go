@@ -0,0 +1,27 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSandboxPorts(t *testing.T) {
+	cfg := Config{
+		RelayBase:      "http://relay:8787",
+		AdminAddr:      ":9491",
+		EventGRPC:      "sink:7443",
+		AllowHTTPHosts: []string{"api.example.com", "svc:8080"},
+		DNSUpstream:    []string{"10.0.0.2:5353"},
+		Tenants:        map[string]TenantPolicy{"acme": {AllowHTTPHosts: []string{"acme.internal:9443"}}},
+		Webhooks:       []Webhook{{URL: "https://hooks.example.com:8443/void"}},
+		GitHubHooks:    []GitHubHook{{URL: "http://ci:3000/hook"}},
+		GraphQL:        map[string]GraphQLEndpoint{"catalog": {URL: "http://catalog:4000/graphql"}},
+		AI:             map[string]AIEndpoint{"assistant": {URL: "http://llm:11434/v1"}},
+		WS:             map[string]WSEndpoint{"feed": {URL: "wss://feed.example.com"}, "local": {URL: "ws://ticker:9001/ws"}},
+		SandboxPorts:   []int{6000},
+	}
+	connect, bind := sandboxPorts(cfg)
+	want := []int{53, 80, 443, 3000, 4000, 5353, 6000, 7443, 8080, 8443, 8787, 9001, 9443, 11434}
+	if !reflect.DeepEqual(connect, want) { t.Errorf("connect = %v, want %v", connect, want) }
+	if !reflect.DeepEqual(bind, []int{9491}) { t.Errorf("bind = %v, want [9491]", bind) }
+}
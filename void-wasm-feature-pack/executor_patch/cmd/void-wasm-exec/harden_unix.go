@@ -0,0 +1,40 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// dropPrivileges hands dirs to spec (user[:group], names or ids) and
+// switches every thread to it for good.
+func dropPrivileges(spec string, dirs []string) error {
+	name, group, _ := strings.Cut(spec, ":")
+	u, err := user.Lookup(name)
+	if err != nil {
+		if u, err = user.LookupId(name); err != nil { return err }
+	}
+	uid, _ := strconv.Atoi(u.Uid)
+	gid, _ := strconv.Atoi(u.Gid)
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			if g, err = user.LookupGroupId(group); err != nil { return err }
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	if uid == 0 { return fmt.Errorf("%s is root", spec) }
+	for _, d := range dirs {
+		os.MkdirAll(d, 0o755)
+		if err := os.Lchown(d, uid, gid); err != nil { return err }
+	}
+	if err := syscall.Setgroups([]int{gid}); err != nil { return fmt.Errorf("setgroups: %w", err) }
+	if err := syscall.Setgid(gid); err != nil { return fmt.Errorf("setgid: %w", err) }
+	if err := syscall.Setuid(uid); err != nil { return fmt.Errorf("setuid: %w", err) }
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build windows
+
+package main
+
+import "errors"
+
+// dropPrivileges is never reached: Windows reports no euid. Run the service
+// under a low-privilege account instead.
+func dropPrivileges(string, []string) error { return errors.New("run_as: not supported on windows") }
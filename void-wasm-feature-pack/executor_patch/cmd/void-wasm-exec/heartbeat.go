@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// version is stamped at build time: -ldflags "-X main.version=v1.2.3"
+var version = "dev"
+
+// runsQueued counts envelopes in the pipeline that have not started executing.
+var runsQueued atomic.Int64
+
+func nodeID(cfg Config) string {
+	if cfg.NodeID != "" { return cfg.NodeID }
+	h, _ := os.Hostname()
+	return h
+}
+
+func cacheStats(dir string) (files int, bytes int64) {
+	filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() { return nil }
+		if info, err := d.Info(); err == nil { files++; bytes += info.Size() }
+		return nil
+	})
+	return
+}
+
+// heartbeatLoop posts node.heartbeat so the relay can keep a live fleet view.
+func heartbeatLoop(cfg Config) {
+	if cfg.HeartbeatEvery <= 0 { return }
+	id := nodeID(cfg)
+	for {
+		files, bytes := cacheStats(cfg.CacheDir)
+		postEvent(cfg, map[string]any{
+			"type": "node.heartbeat",
+			"meta": map[string]any{
+				"node":        id,
+				"version":     version,
+				"active":      activeCount(),
+				"queued":      runsQueued.Load(),
+				"event_queue": len(eventQ),
+				"frozen":      intakePaused.Load(),
+				"leader":      isLeader.Load(),
+				"labels":      currentConfig().NodeLabels,
+				"key_id":      identityKeyID,
+				"cache":       map[string]any{"files": files, "bytes": bytes},
+				"ts":          time.Now().UTC().Format(time.RFC3339),
+			},
+		})
+		time.Sleep(cfg.HeartbeatEvery)
+	}
+}
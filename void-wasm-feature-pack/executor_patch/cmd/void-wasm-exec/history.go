@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// RunRecord is one persisted run: what came in, what was decided, how it ended.
+type RunRecord struct {
+	ID        string    `json:"id"`
+	Module    string    `json:"module"`
+	Tenant    string    `json:"tenant,omitempty"`
+	SHA256    string    `json:"sha256,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	Envelope  *Envelope `json:"envelope,omitempty"`
+	Decisions []string  `json:"decisions,omitempty"`
+	Result    string    `json:"result"`
+	Error     string    `json:"error,omitempty"`
+	Started   time.Time `json:"started"`
+	FetchMs   int64     `json:"fetch_ms"`
+	RunMs     int64     `json:"run_ms"`
+	TotalMs   int64     `json:"total_ms"`
+
+	Correlation     string         `json:"correlation_id,omitempty"` // correlation.go
+	NetBytes        int64          `json:"net_bytes,omitempty"`
+	CPUMs           int64          `json:"cpu_ms,omitempty"`        // guest CPU (cost.go)
+	EventBytes      int64          `json:"event_bytes,omitempty"`   // events the module sent
+	FetchedBytes    int64          `json:"fetched_bytes,omitempty"` // module bytes pulled past the local cache
+	Target          string         `json:"target,omitempty"` // chosen variant (targets.go)
+	Signature       *ResultSignature `json:"signature,omitempty"` // node identity (identity.go); covers everything else
+	Opt             string         `json:"opt,omitempty"`
+	Syscalls        []SyscallTrace `json:"syscalls,omitempty"`
+	SyscallsDropped int            `json:"syscalls_dropped,omitempty"`
+	Artifacts       []Artifact     `json:"artifacts,omitempty"`
+	Attestation     string         `json:"attestation,omitempty"` // sha256 of the DSSE envelope
+	Budget          *BudgetReport  `json:"budget,omitempty"`      // envelope budget and usage (budget.go)
+	Phases          map[string]int64 `json:"phases_ms,omitempty"` // latency by phase (phases.go)
+	Resolved        *ModuleRelease `json:"resolved,omitempty"`    // module@version pinned by the registry (registry.go)
+	RandomBytes     int64          `json:"random_bytes,omitempty"` // handed out by void.random (random.go)
+	AITokens        int64          `json:"ai_tokens,omitempty"`    // syscall.ai.infer usage (ai.go)
+	AICost          float64        `json:"ai_cost,omitempty"`      // priced with the endpoints' price_in/price_out
+	WS              *WSReport      `json:"ws,omitempty"`           // reactor session (ws.go)
+	RunTokens       []string       `json:"run_tokens,omitempty"`   // jti of each run token minted (runtoken.go)
+
+	tape   *runTape   // record/replay state, not persisted
+	budget *runBudget // live budget, nil = none
+	inputs *runInputs // resolved input references, nil = none
+	phases runPhases
+	runTokens map[string]mintedToken // by audience (runtoken.go)
+}
+
+func (rec *RunRecord) decide(d string) { rec.Decisions = append(rec.Decisions, d) }
+
+// newRunID is time-ordered hex so bbolt keys sort by start time.
+func newRunID(t time.Time) string {
+	var b [12]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(t.UnixNano()))
+	rand.Read(b[8:])
+	return hex.EncodeToString(b[:])
+}
+
+// --- History store (bbolt) ---
+var runsBucket = []byte("runs")
+
+type History struct {
+	db        *bolt.DB
+	retention time.Duration
+}
+
+var history *History
+
+func openHistory(path string, retention time.Duration) (*History, error) {
+	if path == "" { return nil, nil }
+	os.MkdirAll(filepath.Dir(path), 0o755)
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil { return nil, err }
+	err = db.Update(func(tx *bolt.Tx) error { _, err := tx.CreateBucketIfNotExists(runsBucket); return err })
+	if err != nil { db.Close(); return nil, err }
+	h := &History{db: db, retention: retention}
+	if retention > 0 { go h.pruneLoop() }
+	return h, nil
+}
+
+func (h *History) Put(rec *RunRecord) {
+	if h == nil { return }
+	b, err := json.Marshal(rec)
+	if err != nil { return }
+	err = h.db.Update(func(tx *bolt.Tx) error { return tx.Bucket(runsBucket).Put([]byte(rec.ID), b) })
+	if err != nil { fmt.Println("[history] put error:", err) }
+}
+
+func (h *History) Close() {
+	if h == nil { return }
+	h.db.Close()
+}
+
+func (h *History) Get(id string) (*RunRecord, bool) {
+	if h == nil { return nil, false }
+	var rec RunRecord
+	found := false
+	h.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(runsBucket).Get([]byte(id))
+		if v != nil && json.Unmarshal(v, &rec) == nil { found = true }
+		return nil
+	})
+	return &rec, found
+}
+
+// Query returns newest-first records matching module/result (empty = any).
+func (h *History) Query(module, result string, limit int) []RunRecord {
+	out := []RunRecord{}
+	if h == nil { return out }
+	h.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(runsBucket).Cursor()
+		for k, v := c.Last(); k != nil && len(out) < limit; k, v = c.Prev() {
+			var rec RunRecord
+			if json.Unmarshal(v, &rec) != nil { continue }
+			if module != "" && !allowed(rec.Module, []string{module}) { continue }
+			if result != "" && rec.Result != result { continue }
+			out = append(out, rec)
+		}
+		return nil
+	})
+	return out
+}
+
+func (h *History) prune() {
+	cutoff := newRunID(time.Now().Add(-h.retention))[:16]
+	n := 0
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(runsBucket)
+		// collect first: deleting under a live cursor skips keys; keys too
+		// short for a run id are not ours and stay
+		old := [][]byte{}
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if len(k) < 16 { continue }
+			if string(k[:16]) >= cutoff { break }
+			old = append(old, k)
+		}
+		for _, k := range old {
+			if err := b.Delete(k); err != nil { return err }
+			n++
+		}
+		return nil
+	})
+	if err != nil { fmt.Println("[history] prune error:", err) }
+	if n > 0 { fmt.Println("[history] pruned", n, "runs") }
+}
+
+func (h *History) pruneLoop() {
+	for {
+		h.prune()
+		time.Sleep(10 * time.Minute)
+	}
+}
+
+// --- Admin handlers ---
+func handleRunsList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if limit <= 0 || limit > 500 { limit = 50 }
+	writeJSON(w, 200, history.Query(q.Get("module"), q.Get("result"), limit))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// --- Host variables (syscall.env.get, cap env) ---
+// Configuration the host hands out on request, so the relay does not have to
+// bake region, node or feature flags into every envelope's inputs. Only
+// names declared under host_vars exist; each has a literal value, a from_env
+// variable of the executor read at call time, or a builtin (node, tenant,
+// module, version, label:<node_labels key>), and module/tenant patterns that
+// may read it. The read has to answer the running module, so it is a host
+// function: void.env_get(name_ptr, name_len, buf_ptr, buf_cap) writes the
+// value and returns its length, -1 without cap env, -2 for a name that is
+// not declared or not granted to this module/tenant (the same answer, so
+// modules cannot probe what exists), -3 when the value does not fit the
+// buffer. Unlike guest_env nothing is requested up front: a module reads
+// what it needs, and every read is traced as syscall.env.get with the name
+// as id (never the value).
+
+type HostVar struct {
+	Value   string   `yaml:"value"`
+	FromEnv string   `yaml:"from_env"` // executor variable; wins over value
+	Builtin string   `yaml:"builtin"`  // node | tenant | module | version | label:<key>; wins over both
+	Modules []string `yaml:"modules"`  // empty = any allowlisted module
+	Tenants []string `yaml:"tenants"`  // empty = any tenant
+}
+
+// env_get results
+const (
+	envGetDenied   = -1
+	envGetUnknown  = -2
+	envGetTooSmall = -3
+)
+
+var hostVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]{0,127}$`)
+
+var envGetTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_env_get_total", Help: "syscall.env.get reads by result"}, []string{"result"})
+
+// hostVar is name's value for rec, ok false when it is not declared or not
+// granted.
+func hostVar(cfg Config, rec *RunRecord, name string) (string, bool) {
+	v, ok := cfg.HostVars[name]
+	if !ok || (len(v.Modules) > 0 && !allowed(rec.Module, v.Modules)) || (len(v.Tenants) > 0 && !allowed(rec.Tenant, v.Tenants)) { return "", false }
+	switch b := v.Builtin; {
+	case b == "node": return nodeID(cfg), true
+	case b == "tenant": return rec.Tenant, true
+	case b == "module": return rec.Module, true
+	case b == "version": return version, true
+	case strings.HasPrefix(b, "label:"): return cfg.NodeLabels[b[len("label:"):]], true
+	case v.FromEnv != "": return os.Getenv(v.FromEnv), true
+	}
+	return v.Value, true
+}
+
+type runVars struct {
+	cfg   Config
+	rec   *RunRecord
+	start time.Time
+}
+
+type hostVarsKey struct{}
+
+func withHostVars(ctx context.Context, cfg Config, rec *RunRecord, start time.Time) context.Context {
+	return context.WithValue(ctx, hostVarsKey{}, &runVars{cfg, rec, start})
+}
+
+// exportHostVars adds env_get to the void host module.
+func exportHostVars(b wazero.HostModuleBuilder) {
+	i32 := api.ValueTypeI32
+	b.NewFunctionBuilder().
+		WithGoModuleFunction(api.GoModuleFunc(func(ctx context.Context, mod api.Module, stack []uint64) {
+			t0 := time.Now()
+			rv, _ := ctx.Value(hostVarsKey{}).(*runVars)
+			nptr, nlen := api.DecodeU32(stack[0]), api.DecodeU32(stack[1])
+			ptr, max := api.DecodeU32(stack[2]), api.DecodeU32(stack[3])
+			if rv == nil { stack[0] = api.EncodeI32(envGetDenied); return }
+			raw, ok := mod.Memory().Read(nptr, nlen)
+			name := string(raw)
+			res, result := int32(envGetUnknown), "undeclared"
+			switch {
+			case !allowed("env", rv.cfg.AllowCaps) || !rv.rec.budget.spend(budgetSyscalls, 1):
+				res, result = envGetDenied, "denied"
+			case ok && hostVarName.MatchString(name):
+				v, declared := hostVar(rv.cfg, rv.rec, name)
+				if !declared { break }
+				res, result = int32(len(v)), "ok"
+				if len(v) > int(max) || !mod.Memory().WriteString(ptr, v) { res, result = envGetTooSmall, "too_small" }
+			}
+			stack[0] = api.EncodeI32(res)
+			envGetTotal.WithLabelValues(result).Inc()
+			sysReqTotal.WithLabelValues("syscall.env.get", result).Inc()
+			rv.rec.traceSyscall(rv.cfg.TimelineMax, rv.start, "syscall.env.get", name, result, t0)
+		}), []api.ValueType{i32, i32, i32, i32}, []api.ValueType{i32}).
+		Export("env_get")
+}
+
+func validHostVars(c Config) error {
+	for name, v := range c.HostVars {
+		if !hostVarName.MatchString(name) { return fmt.Errorf("host_vars: invalid name %q", name) }
+		if len(v.Value) > maxGuestEnvValue { return fmt.Errorf("host_vars[%s]: value longer than %d", name, maxGuestEnvValue) }
+		switch b := v.Builtin; {
+		case b == "", b == "node", b == "tenant", b == "module", b == "version":
+		case strings.HasPrefix(b, "label:") && len(b) > len("label:"):
+		default:
+			return fmt.Errorf("host_vars[%s]: builtin must be node, tenant, module, version or label:<key>, got %q", name, b)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- http.fetch response cache (http_cache) ---
+// GETs made by modules through syscall.http.fetch are answered from a
+// host-side cache when the origin allows it, so pulse modules polling the
+// same endpoints stop generating egress. Every tenant+module has its own
+// partition (an LRU of http_cache_entries), so one module never sees what
+// another fetched, and the cache is private in the RFC 9111 sense: responses
+// with Authorization or Cache-Control: private are kept too. Freshness is
+// max-age (else Expires - Date) clamped to http_cache_max_ttl; no-store on
+// either side bypasses the cache, no-cache on either side and stale entries
+// with an ETag or Last-Modified are revalidated with If-None-Match /
+// If-Modified-Since, and a 304 refreshes the entry. Requests carrying their
+// own conditional headers, and responses with Vary: *, are passed through.
+// Fresh hits skip the http_rps bucket and add nothing to net_bytes; the
+// sysret says "cache":"hit" or "revalidated".
+
+var (
+	httpCacheTotal   = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_http_cache_total", Help: "http.fetch cache lookups by result"}, []string{"result"})
+	httpCacheEntries = prometheus.NewGauge(prometheus.GaugeOpts{Name: "void_wasm_http_cache_entries", Help: "Responses in the http.fetch cache"})
+)
+
+// httpCached is what a sysret.http needs; bodies are not relayed, so only
+// their size is kept.
+type httpCached struct {
+	key       string
+	status    int
+	ctype     string
+	size      int64
+	truncated bool // size hit the max_kb of the request that filled it
+	etag      string
+	lastMod   string
+	vary      []string // request header names, canonical
+	varyVals  string
+	noCache   bool
+	expires   time.Time
+}
+
+type httpPartition struct {
+	order *list.List // front = most recently used
+	index map[string]*list.Element
+}
+
+var (
+	httpCacheMu    sync.Mutex
+	httpCacheParts = map[string]*httpPartition{}
+	httpCacheLen   int
+)
+
+// httpCacheable statuses may be stored without explicit freshness.
+var httpCacheable = map[int]bool{200: true, 203: true, 204: true, 301: true, 404: true, 410: true}
+
+func cacheControl(h http.Header) map[string]string {
+	cc := map[string]string{}
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			k, val, _ := strings.Cut(strings.TrimSpace(d), "=")
+			if k != "" { cc[strings.ToLower(k)] = strings.Trim(val, `"`) }
+		}
+	}
+	return cc
+}
+
+func httpPartKey(cfg Config, rec *RunRecord) string {
+	if rec == nil { return cfg.Tenant + "|" }
+	return cfg.Tenant + "|" + rec.Module
+}
+
+// httpCacheLookup returns the entry for a module request reading up to limit
+// bytes, and whether the request may use the cache at all.
+func httpCacheLookup(cfg Config, rec *RunRecord, method, rawURL string, hdr http.Header, limit int64) (*httpCached, bool) {
+	if !cfg.HTTPCache || method != "GET" { return nil, false }
+	if hdr.Get("If-None-Match") != "" || hdr.Get("If-Modified-Since") != "" || hdr.Get("Range") != "" { return nil, false }
+	if _, ok := cacheControl(hdr)["no-store"]; ok { return nil, false }
+	httpCacheMu.Lock(); defer httpCacheMu.Unlock()
+	p := httpCacheParts[httpPartKey(cfg, rec)]
+	if p == nil { return nil, true }
+	el, ok := p.index[rawURL]
+	if !ok { return nil, true }
+	e := el.Value.(*httpCached)
+	if varyValues(e.vary, hdr) != e.varyVals || (e.truncated && limit > e.size) { return nil, true }
+	p.order.MoveToFront(el)
+	c := *e
+	return &c, true
+}
+
+// fresh reports whether e may answer a request with headers hdr without
+// asking the origin.
+func (e *httpCached) fresh(hdr http.Header) bool {
+	cc := cacheControl(hdr)
+	if _, ok := cc["no-cache"]; ok || e.noCache || cc["max-age"] == "0" { return false }
+	return time.Now().Before(e.expires)
+}
+
+func (e *httpCached) validators() bool { return e.etag != "" || e.lastMod != "" }
+
+func varyValues(names []string, hdr http.Header) string {
+	var b strings.Builder
+	for _, n := range names { b.WriteString(n); b.WriteByte('='); b.WriteString(strings.Join(hdr.Values(n), ",")); b.WriteByte('\n') }
+	return b.String()
+}
+
+// httpFreshFor is how long resp may be served without revalidation; ok is
+// false when it must not be stored.
+func httpFreshFor(cfg Config, resp *http.Response) (ttl time.Duration, noCache, ok bool) {
+	cc := cacheControl(resp.Header)
+	if _, ns := cc["no-store"]; ns || !httpCacheable[resp.StatusCode] || resp.Header.Get("Vary") == "*" { return 0, false, false }
+	_, noCache = cc["no-cache"]
+	if v, has := cc["max-age"]; has {
+		s, err := strconv.Atoi(v)
+		if err != nil || s < 0 { s = 0 }
+		ttl = time.Duration(s) * time.Second
+	} else if exp := resp.Header.Get("Expires"); exp != "" {
+		date, err := http.ParseTime(resp.Header.Get("Date"))
+		if err != nil { date = time.Now() }
+		if t, err := http.ParseTime(exp); err == nil { ttl = t.Sub(date) } // an invalid Expires means already expired
+	}
+	ttl = min(max(ttl, 0), cfg.HTTPCacheMaxTTL)
+	if ttl == 0 && !noCache && resp.Header.Get("ETag") == "" && resp.Header.Get("Last-Modified") == "" { return 0, false, false }
+	return ttl, noCache, true
+}
+
+// httpCacheStore keeps resp (whose body was read to size bytes) in the
+// module's partition, replacing any previous entry for the url.
+func httpCacheStore(cfg Config, rec *RunRecord, rawURL string, hdr http.Header, resp *http.Response, size int64, truncated bool) {
+	ttl, noCache, ok := httpFreshFor(cfg, resp)
+	if !ok { httpCacheTotal.WithLabelValues("uncacheable").Inc(); return }
+	e := &httpCached{
+		key: rawURL, status: resp.StatusCode, ctype: resp.Header.Get("Content-Type"), size: size, truncated: truncated,
+		etag: resp.Header.Get("ETag"), lastMod: resp.Header.Get("Last-Modified"), noCache: noCache, expires: time.Now().Add(ttl),
+	}
+	for _, v := range resp.Header.Values("Vary") {
+		for _, n := range strings.Split(v, ",") {
+			if n = strings.TrimSpace(n); n != "" { e.vary = append(e.vary, http.CanonicalHeaderKey(n)) }
+		}
+	}
+	e.varyVals = varyValues(e.vary, hdr)
+	httpCacheMu.Lock(); defer httpCacheMu.Unlock()
+	pk := httpPartKey(cfg, rec)
+	p := httpCacheParts[pk]
+	if p == nil { p = &httpPartition{order: list.New(), index: map[string]*list.Element{}}; httpCacheParts[pk] = p }
+	if el, ok := p.index[rawURL]; ok { p.order.Remove(el); httpCacheLen-- }
+	p.index[rawURL] = p.order.PushFront(e)
+	httpCacheLen++
+	for p.order.Len() > max(cfg.HTTPCacheEntries, 1) {
+		old := p.order.Remove(p.order.Back()).(*httpCached)
+		delete(p.index, old.key)
+		httpCacheLen--
+	}
+	httpCacheEntries.Set(float64(httpCacheLen))
+	httpCacheTotal.WithLabelValues("stored").Inc()
+}
+
+// httpCacheRefresh applies a 304 to the stored entry e was copied from.
+func httpCacheRefresh(cfg Config, rec *RunRecord, e *httpCached, resp *http.Response) {
+	hdr := resp.Header.Clone()
+	if hdr.Get("ETag") == "" && e.etag != "" { hdr.Set("ETag", e.etag) }
+	if hdr.Get("Last-Modified") == "" && e.lastMod != "" { hdr.Set("Last-Modified", e.lastMod) }
+	ttl, noCache, ok := httpFreshFor(cfg, &http.Response{StatusCode: e.status, Header: hdr})
+	if !ok { ttl, noCache = 0, true } // the 304 says no-store now: ask every time
+	httpCacheMu.Lock(); defer httpCacheMu.Unlock()
+	if p := httpCacheParts[httpPartKey(cfg, rec)]; p != nil {
+		if el, ok := p.index[e.key]; ok {
+			s := el.Value.(*httpCached)
+			s.expires, s.noCache = time.Now().Add(ttl), noCache
+			s.etag, s.lastMod = hdr.Get("ETag"), hdr.Get("Last-Modified")
+		}
+	}
+}
+
+// httpCachePurge drops the partitions matching tenant and module ("" matches
+// any), returning the number of entries removed.
+func httpCachePurge(tenant, module string) int {
+	httpCacheMu.Lock(); defer httpCacheMu.Unlock()
+	n := 0
+	for k, p := range httpCacheParts {
+		t, m, _ := strings.Cut(k, "|")
+		if (tenant != "" && t != tenant) || (module != "" && m != module) { continue }
+		n += p.order.Len()
+		delete(httpCacheParts, k)
+	}
+	httpCacheLen -= n
+	httpCacheEntries.Set(float64(httpCacheLen))
+	return n
+}
+
+func handleHTTPCache(mux *http.ServeMux, cfg Config) {
+	mux.HandleFunc("DELETE /admin/http-cache", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		n := httpCachePurge(q.Get("tenant"), q.Get("module"))
+		audit(cfg, "admin.http_cache.purge", map[string]any{"tenant": q.Get("tenant"), "module": q.Get("module"), "entries": n})
+		writeJSON(w, 200, map[string]any{"purged": n})
+	})
+}
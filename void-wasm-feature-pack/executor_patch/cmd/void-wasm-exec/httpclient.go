@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Shared HTTP clients ---
+// One pooled client per endpoint class so relay POSTs, module downloads and
+// http.fetch syscalls reuse keep-alive connections instead of dialing per
+// request. Timeouts are per class; sse and ws have none (the streams are
+// long-lived and bounded by their context). Clients are rebuilt from config once at startup;
+// fetch and syscall dial through the DNS cache when it is on (dnscache.go).
+// The syscall client only follows a redirect to another host when that host
+// is in allow_http_hosts (else the module gets the 3xx), and never carries
+// the run token or endpoint credentials there: Go forwards every header but
+// Authorization and Cookie.
+
+var (
+	httpConnsOpened = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_http_conns_opened_total", Help: "TCP connections dialed by client"}, []string{"client"})
+	httpConnsReused = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_http_conns_reused_total", Help: "Requests served on a pooled connection by client"}, []string{"client"})
+	httpConnsOpen   = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "void_wasm_http_conns_open", Help: "Open TCP connections by client"}, []string{"client"})
+)
+
+var (
+	relayClient = newPooledClient("relay", 3*time.Second, 16, 90*time.Second)
+	sseClient   = newPooledClient("sse", 0, 2, 90*time.Second)
+	fetchClient = newPooledClient("fetch", 30*time.Second, 16, 90*time.Second)
+	httpClient  = newPooledClient("syscall", 2*time.Second, 16, 90*time.Second)
+	logClient   = newPooledClient("logs", 5*time.Second, 4, 90*time.Second)
+	wsClient    = newPooledClient("ws", 0, 2, 90*time.Second)
+)
+
+func initHTTPClients(cfg Config) {
+	if cfg.DNSCache { dnsRes = newDNSResolver(cfg) }
+	relayClient = newPooledClient("relay", cfg.RelayTimeout, cfg.HTTPIdlePerHost, cfg.HTTPIdleTimeout)
+	sseClient = newPooledClient("sse", 0, 2, cfg.HTTPIdleTimeout)
+	fetchClient = newPooledClient("fetch", cfg.FetchTimeout, cfg.HTTPIdlePerHost, cfg.HTTPIdleTimeout)
+	httpClient = newPooledClient("syscall", cfg.HTTPTimeout, cfg.HTTPIdlePerHost, cfg.HTTPIdleTimeout)
+	logClient = newPooledClient("logs", cfg.RelayTimeout+2*time.Second, 4, cfg.HTTPIdleTimeout)
+	wsClient = newPooledClient("ws", 0, 2, cfg.HTTPIdleTimeout)
+}
+
+type countedConn struct {
+	net.Conn
+	once   sync.Once
+	client string
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() { httpConnsOpen.WithLabelValues(c.client).Dec() })
+	return c.Conn.Close()
+}
+
+// tracedTransport counts pooled-connection reuse per request.
+type tracedTransport struct {
+	base   *http.Transport
+	client string
+}
+
+func (t tracedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) {
+		if info.Reused { httpConnsReused.WithLabelValues(t.client).Inc() }
+	}}
+	return t.base.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+func newPooledClient(name string, timeout time.Duration, idlePerHost int, idleTimeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: 2 * time.Second, KeepAlive: 30 * time.Second}
+	tr := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var c net.Conn
+			var err error
+			if dnsRes != nil && (name == "fetch" || name == "syscall") { c, err = dnsRes.dial(ctx, dialer, network, addr) } else { c, err = dialer.DialContext(ctx, network, addr) }
+			if err != nil { return nil, err }
+			httpConnsOpened.WithLabelValues(name).Inc()
+			httpConnsOpen.WithLabelValues(name).Inc()
+			return &countedConn{Conn: c, client: name}, nil
+		},
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          4 * idlePerHost,
+		MaxIdleConnsPerHost:   idlePerHost,
+		IdleConnTimeout:       idleTimeout,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	c := &http.Client{Timeout: timeout, Transport: tracedTransport{base: tr, client: name}}
+	if name == "syscall" { c.CheckRedirect = syscallRedirect }
+	return c
+}
+
+// syscallRedirect is the syscall client's redirect policy.
+func syscallRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 { return errors.New("stopped after 10 redirects") }
+	if req.URL.Host == via[0].URL.Host { return nil }
+	cfg := currentConfig()
+	if !hostAllowed(req.URL, cfg.AllowHTTPHosts) { return http.ErrUseLastResponse }
+	for _, h := range []string{cfg.RunTokenHeader, "Authorization", "X-Api-Key", "Cookie"} { req.Header.Del(h) }
+	return nil
+}
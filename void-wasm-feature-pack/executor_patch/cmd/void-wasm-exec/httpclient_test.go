@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A token host that redirects elsewhere must not hand over the run token or
+// endpoint credentials, and must not lead off allow_http_hosts at all.
+func TestSyscallRedirect(t *testing.T) {
+	var got http.Header
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = r.Header.Clone() }))
+	defer other.Close()
+	tokenHost := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/same" { http.Redirect(w, r, "/done", http.StatusFound); return }
+		if r.URL.Path == "/done" { got = r.Header.Clone(); return }
+		http.Redirect(w, r, other.URL+"/x", http.StatusFound)
+	}))
+	defer tokenHost.Close()
+	saved := liveCfg.Load()
+	defer liveCfg.Store(saved)
+	c := newPooledClient("syscall", 2*time.Second, 1, time.Second)
+	get := func(path string, allow ...string) *http.Response {
+		liveCfg.Store(&Config{RunTokenHeader: "Void-Run-Token", AllowHTTPHosts: allow})
+		got = nil
+		req, _ := http.NewRequest("GET", tokenHost.URL+path, nil)
+		req.Header.Set("Void-Run-Token", "tok")
+		req.Header.Set("X-Api-Key", "key")
+		req.Header.Set("X-Trace", "t")
+		resp, err := c.Do(req)
+		if err != nil { t.Fatal(err) }
+		resp.Body.Close()
+		return resp
+	}
+
+	if get("/same", "127.0.0.1"); got.Get("Void-Run-Token") != "tok" || got.Get("X-Api-Key") != "key" { t.Errorf("same-host redirect dropped headers: %v", got) }
+	if resp := get("/", "127.0.0.1"); resp.StatusCode != 200 || got == nil {
+		t.Fatalf("allowed redirect not followed: %d", resp.StatusCode)
+	}
+	for _, h := range []string{"Void-Run-Token", "X-Api-Key"} {
+		if got.Get(h) != "" { t.Errorf("%s forwarded to another host", h) }
+	}
+	if got.Get("X-Trace") != "t" { t.Error("plain header dropped") }
+	if resp := get("/", "example.com"); resp.StatusCode != http.StatusFound || got != nil || !strings.HasPrefix(resp.Header.Get("Location"), other.URL) {
+		t.Errorf("redirect off allow_http_hosts followed: %d %v", resp.StatusCode, got)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Executor identity: signed receipts and events ---
+// identity_key gives the node a signing identity:
+//   file:/etc/void/identity.key  ed25519 PKCS#8 PEM, generated on first start
+//   kms:<key id or arn>          AWS KMS ECC_NIST_P256 key (ECDSA_SHA_256),
+//                                signed remotely with the s3_* credentials
+//                                in kms_region (default s3_region)
+// Every booked run's receipt (the run record as JSON) then carries
+// "signature": {node, key_id, alg, digest, sig}: digest is the sha256 of the
+// receipt JSON without the signature, sig the signature over its DSSE PAE
+// (payload type application/vnd.void.receipt+json), key_id the sha256 of the
+// public key (PKIX DER), served at /identity/pubkey and sent in
+// node.heartbeat. With sign_events, every event delivery is signed too: the
+// relay POST body (one event or a batch) in an X-Void-Signature header, the
+// NATS message data in a Void-Signature header, the gRPC Event's data_json
+// in its signature field. A relay that knows the node's key can tell which
+// node produced a result; `void-wasm-exec receipt-verify` checks a receipt.
+
+const receiptPayloadType = "application/vnd.void.receipt+json"
+
+var identitySigned = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_identity_signatures_total", Help: "Receipts and event deliveries signed with the node identity, by kind and result"}, []string{"kind", "result"})
+
+// ResultSignature is a receipt's node signature.
+type ResultSignature struct {
+	Node   string `json:"node"`
+	KeyID  string `json:"key_id"`
+	Alg    string `json:"alg"` // ed25519 | ecdsa-p256-sha256
+	Digest string `json:"digest"`
+	Sig    string `json:"sig"` // base64
+}
+
+type nodeSigner interface {
+	sign(msg []byte) ([]byte, error)
+	alg() string
+}
+
+var (
+	identity      nodeSigner // nil = off
+	identityPub   []byte     // PKIX DER
+	identityKeyID string
+)
+
+type fileSigner struct{ key ed25519.PrivateKey }
+
+func (s fileSigner) sign(msg []byte) ([]byte, error) { return ed25519.Sign(s.key, msg), nil }
+func (fileSigner) alg() string                        { return "ed25519" }
+
+// kmsSigner signs sha256 digests with an AWS KMS asymmetric key.
+type kmsSigner struct {
+	cfg    Config
+	key    string
+	region string
+}
+
+func (kmsSigner) alg() string { return "ecdsa-p256-sha256" }
+
+func (s kmsSigner) call(target string, in, out any) error {
+	body, _ := json.Marshal(in)
+	req, err := http.NewRequest("POST", "https://kms."+s.region+".amazonaws.com/", bytes.NewReader(body))
+	if err != nil { return err }
+	req.Header.Set("content-type", "application/x-amz-json-1.1")
+	req.Header.Set("x-amz-target", "TrentService."+target)
+	sum := sha256.Sum256(body)
+	awsSign(req, s.cfg, s.region, "kms", hex.EncodeToString(sum[:]), time.Now())
+	resp, err := fetchClient.Do(req)
+	if err != nil { return err }
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode != 200 { return fmt.Errorf("kms %s: status %d: %s", target, resp.StatusCode, bytes.TrimSpace(b)) }
+	return json.Unmarshal(b, out)
+}
+
+func (s kmsSigner) sign(msg []byte) ([]byte, error) {
+	sum := sha256.Sum256(msg)
+	var out struct{ Signature []byte }
+	err := s.call("Sign", map[string]any{"KeyId": s.key, "Message": sum[:], "MessageType": "DIGEST", "SigningAlgorithm": "ECDSA_SHA_256"}, &out)
+	return out.Signature, err
+}
+
+func validIdentityKey(spec string) error {
+	if spec == "" { return nil }
+	kind, rest, _ := strings.Cut(spec, ":")
+	if (kind != "file" && kind != "kms") || rest == "" { return fmt.Errorf("want file:/path or kms:<key>, got %q", spec) }
+	return nil
+}
+
+// loadIdentity sets up the node's signer from identity_key.
+func loadIdentity(cfg Config) error {
+	kind, name, _ := strings.Cut(cfg.IdentityKey, ":")
+	var signer nodeSigner
+	var pub []byte
+	switch kind {
+	case "file":
+		priv, err := loadEd25519Key(name)
+		if err != nil { return err }
+		signer = fileSigner{priv}
+		pub, _ = x509.MarshalPKIXPublicKey(priv.Public())
+	case "kms":
+		region := cfg.KMSRegion
+		if region == "" { region = cfg.S3Region }
+		s := kmsSigner{cfg: cfg, key: name, region: region}
+		var out struct{ PublicKey []byte; KeySpec string }
+		if err := s.call("GetPublicKey", map[string]any{"KeyId": name}, &out); err != nil { return err }
+		if out.KeySpec != "ECC_NIST_P256" { return fmt.Errorf("kms key %s: want ECC_NIST_P256, got %s", name, out.KeySpec) }
+		signer, pub = s, out.PublicKey
+	default:
+		return validIdentityKey(cfg.IdentityKey)
+	}
+	sum := sha256.Sum256(pub)
+	identity, identityPub, identityKeyID = signer, pub, hex.EncodeToString(sum[:])
+	fmt.Println("[identity]", signer.alg(), "key", identityKeyID)
+	return nil
+}
+
+// loadEd25519Key reads a PKCS#8 PEM ed25519 key, generating it if missing.
+func loadEd25519Key(path string) (ed25519.PrivateKey, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		_, priv, _ := ed25519.GenerateKey(rand.Reader)
+		der, _ := x509.MarshalPKCS8PrivateKey(priv)
+		os.MkdirAll(filepath.Dir(path), 0o700)
+		if err := writeFileAtomic(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil { return nil, err }
+		fmt.Println("[keys] generated ed25519 key", path)
+		raw, err = os.ReadFile(path)
+	}
+	if err != nil { return nil, err }
+	blk, _ := pem.Decode(raw)
+	if blk == nil { return nil, fmt.Errorf("%s: no PEM block", path) }
+	k, err := x509.ParsePKCS8PrivateKey(blk.Bytes)
+	if err != nil { return nil, fmt.Errorf("%s: %w", path, err) }
+	priv, ok := k.(ed25519.PrivateKey)
+	if !ok { return nil, fmt.Errorf("%s: not an ed25519 key", path) }
+	return priv, nil
+}
+
+// signReceipt signs rec as it will be published; a failed signature leaves
+// the receipt unsigned.
+func signReceipt(cfg Config, rec *RunRecord) {
+	if identity == nil { return }
+	rec.Signature = nil
+	b, err := json.Marshal(rec)
+	if err != nil { identitySigned.WithLabelValues("receipt", "error").Inc(); return }
+	sig, err := identity.sign(dssePAE(receiptPayloadType, b))
+	if err != nil {
+		identitySigned.WithLabelValues("receipt", "error").Inc()
+		fmt.Println("[identity] sign receipt", rec.ID, err, rec.corr())
+		return
+	}
+	sum := sha256.Sum256(b)
+	rec.Signature = &ResultSignature{Node: nodeID(cfg), KeyID: identityKeyID, Alg: identity.alg(), Digest: hex.EncodeToString(sum[:]), Sig: base64.StdEncoding.EncodeToString(sig)}
+	identitySigned.WithLabelValues("receipt", "ok").Inc()
+}
+
+// eventSignature signs an event delivery body; nil when sign_events is off
+// or signing failed.
+func eventSignature(cfg Config, body []byte) *ResultSignature {
+	if identity == nil || !cfg.SignEvents { return nil }
+	sig, err := identity.sign(body)
+	if err != nil { identitySigned.WithLabelValues("event", "error").Inc(); return nil }
+	identitySigned.WithLabelValues("event", "ok").Inc()
+	sum := sha256.Sum256(body)
+	return &ResultSignature{Node: nodeID(cfg), KeyID: identityKeyID, Alg: identity.alg(), Digest: hex.EncodeToString(sum[:]), Sig: base64.StdEncoding.EncodeToString(sig)}
+}
+
+// header is the X-Void-Signature / Void-Signature value.
+func (s *ResultSignature) header() string {
+	return "node=" + s.Node + ";key_id=" + s.KeyID + ";alg=" + s.Alg + ";sig=" + s.Sig
+}
+
+func handleIdentityKey(w http.ResponseWriter, r *http.Request) {
+	if identity == nil { http.NotFound(w, r); return }
+	w.Header().Set("content-type", "application/x-pem-file")
+	w.Write(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: identityPub}))
+}
+
+// verifyNodeSig checks sig over msg with a PKIX public key of either alg.
+func verifyNodeSig(pub any, msg, sig []byte) bool {
+	switch k := pub.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, msg, sig)
+	case *ecdsa.PublicKey:
+		sum := sha256.Sum256(msg)
+		return ecdsa.VerifyASN1(k, sum[:], sig)
+	}
+	return false
+}
+
+// receiptVerifyLocal: void-wasm-exec receipt-verify --key pub.pem receipt.json
+func receiptVerifyLocal(args []string) int {
+	fs := flag.NewFlagSet("receipt-verify", flag.ExitOnError)
+	keyPath := fs.String("key", "", "PEM public key (GET /identity/pubkey)")
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, "usage: void-wasm-exec receipt-verify --key pub.pem receipt.json"); fs.PrintDefaults() }
+	fs.Parse(args)
+	if fs.NArg() != 1 || *keyPath == "" { fs.Usage(); return 2 }
+	fail := func(msg ...any) int { fmt.Fprintln(os.Stderr, append([]any{"receipt-verify:"}, msg...)...); return 1 }
+	raw, err := os.ReadFile(fs.Arg(0))
+	if err != nil { return fail(err) }
+	var rec RunRecord
+	if err := json.Unmarshal(raw, &rec); err != nil { return fail(err) }
+	s := rec.Signature
+	if s == nil { return fail("receipt is not signed") }
+	kb, err := os.ReadFile(*keyPath)
+	if err != nil { return fail(err) }
+	blk, _ := pem.Decode(kb)
+	if blk == nil { return fail("no PEM block in", *keyPath) }
+	pub, err := x509.ParsePKIXPublicKey(blk.Bytes)
+	if err != nil { return fail(err) }
+	if sum := sha256.Sum256(blk.Bytes); hex.EncodeToString(sum[:]) != s.KeyID { return fail("signed by key", s.KeyID, "not this one") }
+	rec.Signature = nil
+	b, _ := json.Marshal(&rec)
+	if sum := sha256.Sum256(b); hex.EncodeToString(sum[:]) != s.Digest { return fail("receipt does not match its digest (edited, or re-encoded by a non-Go tool)") }
+	sig, err := base64.StdEncoding.DecodeString(s.Sig)
+	if err != nil || !verifyNodeSig(pub, dssePAE(receiptPayloadType, b), sig) { return fail("signature does not verify") }
+	fmt.Printf("run      %s module=%s result=%s\nnode     %s (%s)\n", rec.ID, rec.Module, rec.Result, s.Node, s.Alg)
+	fmt.Println("OK")
+	return 0
+}
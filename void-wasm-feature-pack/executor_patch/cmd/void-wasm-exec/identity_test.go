@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// ecSigner stands in for kmsSigner: ASN.1 ECDSA over sha256(msg).
+type ecSigner struct{ key *ecdsa.PrivateKey }
+
+func (s ecSigner) sign(msg []byte) ([]byte, error) {
+	sum := sha256.Sum256(msg)
+	return ecdsa.SignASN1(rand.Reader, s.key, sum[:])
+}
+func (ecSigner) alg() string { return "ecdsa-p256-sha256" }
+
+// testIdentity installs a fresh node identity of alg (ed25519 |
+// ecdsa-p256-sha256) and returns its public key and a PEM file holding it.
+func testIdentity(t *testing.T, alg string) (any, string) {
+	t.Helper()
+	var signer nodeSigner
+	var pub any
+	if alg == "ed25519" {
+		p, priv, _ := ed25519.GenerateKey(rand.Reader)
+		signer, pub = fileSigner{priv}, p
+	} else {
+		k, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		signer, pub = ecSigner{k}, &k.PublicKey
+	}
+	der, _ := x509.MarshalPKIXPublicKey(pub)
+	path := filepath.Join(t.TempDir(), "pub.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil { t.Fatal(err) }
+	saved, savedPub, savedID := identity, identityPub, identityKeyID
+	t.Cleanup(func() { identity, identityPub, identityKeyID = saved, savedPub, savedID })
+	sum := sha256.Sum256(der)
+	identity, identityPub, identityKeyID = signer, der, hex.EncodeToString(sum[:])
+	return pub, path
+}
+
+func TestReceiptVerify(t *testing.T) {
+	for _, alg := range []string{"ed25519", "ecdsa-p256-sha256"} {
+		t.Run(alg, func(t *testing.T) {
+			_, otherKey := testIdentity(t, alg)
+			_, key := testIdentity(t, alg) // the one that signs
+			rec := RunRecord{ID: "r1", Module: "demo/hello", Tenant: "acme", Result: "ok", Started: time.Unix(1700000000, 5).UTC(), RunMs: 12, Decisions: []string{"allow"}}
+			signReceipt(Config{NodeID: "node-a"}, &rec)
+			if rec.Signature == nil || rec.Signature.Alg != alg { t.Fatalf("signature = %+v", rec.Signature) }
+			dir := t.TempDir()
+			write := func(name string, edit func(m map[string]any)) string {
+				b, _ := json.Marshal(&rec)
+				if edit != nil {
+					var m map[string]any
+					json.Unmarshal(b, &m)
+					edit(m)
+					b, _ = json.Marshal(m)
+				}
+				p := filepath.Join(dir, name)
+				os.WriteFile(p, b, 0o600)
+				return p
+			}
+			sig := func(f func(s map[string]any)) func(m map[string]any) {
+				return func(m map[string]any) { f(m["signature"].(map[string]any)) }
+			}
+			for _, tc := range []struct {
+				name, key, file string
+				want             int
+			}{
+				{"valid", key, write("ok.json", nil), 0},
+				{"other key", otherKey, write("ok2.json", nil), 1},
+				{"edited result", key, write("result.json", func(m map[string]any) { m["result"] = "error" }), 1},
+				{"unsigned", key, write("unsigned.json", func(m map[string]any) { delete(m, "signature") }), 1},
+				{"digest swapped", key, write("digest.json", sig(func(s map[string]any) { s["digest"] = hex.EncodeToString(make([]byte, 32)) })), 1},
+				{"bad sig", key, write("sig.json", sig(func(s map[string]any) { s["sig"] = "AAAA" })), 1},
+				{"no key", "", write("nokey.json", nil), 2},
+			} {
+				if got := receiptVerifyLocal([]string{"--key", tc.key, tc.file}); got != tc.want { t.Errorf("%s: receipt-verify = %d, want %d", tc.name, got, tc.want) }
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Large inputs by reference ---
+// An inputs value may stand for a payload too big for the relay's events:
+//   "dataset": {"$ref": "ipfs://bafy..." | "https://..." | "s3://b/k", "sha256": "<hex>", "stdin": false}
+// The fetch stage downloads every reference (at most input_ref_max_mb each),
+// checks its sha256 and keeps it under <cache_dir>/inputs/<sha256> (sealed
+// at rest like modules, evicted oldest first past cache_max_mb), so repeated
+// runs over the same data fetch it once. The guest sees each one read-only
+// as /inputs/<key>, and the inputs JSON on stdin carries that path in place
+// of the reference. One reference may set "stdin": true to be streamed on
+// stdin instead; the inputs JSON then moves to /inputs/inputs.json. A
+// missing, oversized or mismatched payload ends the run as input_error
+// before it reaches the exec pool.
+
+const maxInputRefs = 16
+
+var (
+	inputRefsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_input_refs_total", Help: "Input references resolved, by result (hit, fetched, error)"}, []string{"result"})
+	inputRefBytes  = prometheus.NewCounter(prometheus.CounterOpts{Name: "void_wasm_input_ref_bytes_total", Help: "Input reference bytes downloaded"})
+)
+
+var inputKey = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,127}$`)
+
+type inputRef struct {
+	key, src, sha string
+	stdin         bool
+}
+
+// inputRefs returns the references in inputs, nil when there are none.
+func inputRefs(inputs map[string]any) ([]inputRef, error) {
+	var refs []inputRef
+	stdin := ""
+	for k, v := range inputs {
+		m, ok := v.(map[string]any)
+		if !ok { continue }
+		src, ok := m["$ref"].(string)
+		if !ok { continue }
+		r := inputRef{key: k, src: src}
+		r.sha, _ = m["sha256"].(string)
+		r.stdin, _ = m["stdin"].(bool)
+		if !inputKey.MatchString(k) || k == "inputs.json" { return nil, fmt.Errorf("%q: key must match %s", k, inputKey) }
+		if !sha256Hex.MatchString(r.sha) { return nil, fmt.Errorf("%s: sha256 must be 64 hex chars", k) }
+		if s, _, _ := strings.Cut(src, "://"); s != "ipfs" && s != "https" && s != "http" && s != "s3" { return nil, fmt.Errorf("%s: $ref must be ipfs://, http(s):// or s3://", k) }
+		if r.stdin {
+			if stdin != "" { return nil, fmt.Errorf("%s: only one reference may be stdin (%s is)", k, stdin) }
+			stdin = k
+		}
+		r.sha = strings.ToLower(r.sha)
+		refs = append(refs, r)
+	}
+	if len(refs) > maxInputRefs { return nil, fmt.Errorf("more than %d references", maxInputRefs) }
+	return refs, nil
+}
+
+// runInputs is a run's resolved references, laid out for the guest.
+type runInputs struct {
+	dir    string         // mounted ro at /inputs
+	inputs map[string]any // env.Inputs with guest paths for references
+	stdin  string         // host file streamed on stdin; "" = inputs JSON
+}
+
+func (in *runInputs) cleanup() {
+	if in != nil { os.RemoveAll(in.dir) }
+}
+
+// resolveInputs fetches and verifies env's references for run id; nil
+// without references. pulled is what came over the network.
+func resolveInputs(cfg Config, env *Envelope, id string) (in *runInputs, pulled int64, err error) {
+	refs, err := inputRefs(env.Inputs)
+	if err != nil || len(refs) == 0 { return nil, 0, err }
+	in = &runInputs{dir: filepath.Join(os.TempDir(), "void", "inputs", id), inputs: make(map[string]any, len(env.Inputs))}
+	for k, v := range env.Inputs { in.inputs[k] = v }
+	if err := os.MkdirAll(in.dir, 0o755); err != nil { return nil, 0, err }
+	for _, r := range refs {
+		n, err := placeInput(cfg, r, filepath.Join(in.dir, r.key))
+		pulled += n
+		if err != nil { in.cleanup(); inputRefsTotal.WithLabelValues("error").Inc(); return nil, pulled, fmt.Errorf("input %s: %w", r.key, err) }
+		if r.stdin { in.stdin = filepath.Join(in.dir, r.key); delete(in.inputs, r.key); continue }
+		in.inputs[r.key] = "/inputs/" + r.key
+	}
+	return in, pulled, nil
+}
+
+// placeInput puts the payload of r at dst, from the cache when it can.
+func placeInput(cfg Config, r inputRef, dst string) (int64, error) {
+	cached := filepath.Join(cfg.CacheDir, "inputs", r.sha)
+	var pulled int64
+	if _, err := os.Stat(cached); err != nil {
+		n, err := fetchInput(cfg, r, cached)
+		if err != nil { return n, err }
+		pulled = n
+		inputRefsTotal.WithLabelValues("fetched").Inc()
+	} else {
+		inputRefsTotal.WithLabelValues("hit").Inc()
+	}
+	if atRest == nil { // plain in the cache: a hard link costs nothing
+		if os.Link(cached, dst) == nil { return pulled, nil }
+	}
+	b, err := os.ReadFile(cached)
+	if err == nil { b, err = openAtRest(b, "input:"+r.sha) }
+	if err != nil { return pulled, err }
+	return pulled, os.WriteFile(dst, b, 0o444)
+}
+
+// fetchInput streams r into the cache, hashing on the way.
+func fetchInput(cfg Config, r inputRef, cached string) (int64, error) {
+	src := r.src
+	if cid, ok := strings.CutPrefix(src, "ipfs://"); ok { src = cfg.IPFSGateway + "/ipfs/" + cid }
+	t0 := time.Now()
+	resp, err := fetchURL(cfg, src)
+	if err != nil { return 0, err }
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 { return 0, fmt.Errorf("status %d", resp.StatusCode) }
+	if err := os.MkdirAll(filepath.Dir(cached), 0o755); err != nil { return 0, err }
+	tmp, err := os.CreateTemp(filepath.Dir(cached), r.sha+".tmp-*")
+	if err != nil { return 0, err }
+	defer os.Remove(tmp.Name())
+	limit := int64(cfg.InputRefMaxMB) << 20
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(resp.Body, limit+1))
+	inputRefBytes.Add(float64(n))
+	if err == nil && n > limit { err = fmt.Errorf("larger than input_ref_max_mb %d", cfg.InputRefMaxMB) }
+	if err == nil && hex.EncodeToString(h.Sum(nil)) != r.sha { err = errors.New("sha256 mismatch") }
+	if cerr := tmp.Close(); err == nil { err = cerr }
+	if err != nil { return n, err }
+	fmt.Printf("[inputs] fetched %s (%d bytes, %dms)\n", r.key, n, time.Since(t0).Milliseconds())
+	if atRest != nil {
+		b, err := os.ReadFile(tmp.Name())
+		if err == nil { err = writeFileAtomic(cached, sealAtRest(b, "input:"+r.sha), 0o444) }
+		if err != nil { return n, err }
+	} else {
+		if err := os.Chmod(tmp.Name(), 0o444); err != nil { return n, err }
+		if err := os.Rename(tmp.Name(), cached); err != nil { return n, err }
+	}
+	enforceCacheQuota(filepath.Dir(cached), cfg.CacheMaxMB, cached)
+	return n, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// --- void-wasm-exec inspect module.wasm: what a module needs before allowlisting ---
+
+type Inspection struct {
+	File     string            `json:"file"`
+	Size     int               `json:"size"`
+	SHA256   string            `json:"sha256"`
+	CID      string            `json:"cid"` // CIDv1 raw, sha2-256, base32
+	Imports  []string          `json:"imports"`
+	Exports  []string          `json:"exports"`
+	WASI     []string          `json:"wasi"` // wasi_snapshot_preview1 functions used
+	Memory   map[string]any    `json:"memory,omitempty"`
+	Sections map[string]string `json:"custom_sections,omitempty"` // name -> text or "<n bytes>"
+}
+
+// cidV1Raw builds the CID ipfs would give the bytes as a single raw block.
+func cidV1Raw(digest []byte) string {
+	b := append([]byte{0x01, 0x55, 0x12, 0x20}, digest...)
+	return "b" + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+}
+
+func sig(d api.FunctionDefinition) string {
+	types := func(ts []api.ValueType) string {
+		s := make([]string, len(ts))
+		for i, t := range ts { s[i] = api.ValueTypeName(t) }
+		return strings.Join(s, ",")
+	}
+	return "(" + types(d.ParamTypes()) + ") -> (" + types(d.ResultTypes()) + ")"
+}
+
+func inspectModule(path string) (*Inspection, error) {
+	b, err := readModule(path)
+	if err != nil { return nil, err }
+	ctx := context.Background()
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCustomSections(true))
+	defer r.Close(ctx)
+	m, err := r.CompileModule(ctx, b)
+	if err != nil { return nil, err }
+	sum := sha256.Sum256(b)
+	in := &Inspection{File: path, Size: len(b), SHA256: hex.EncodeToString(sum[:]), CID: cidV1Raw(sum[:]), Sections: map[string]string{}}
+	for _, f := range m.ImportedFunctions() {
+		mod, name, _ := f.Import()
+		in.Imports = append(in.Imports, mod+"."+name+sig(f))
+		if mod == "wasi_snapshot_preview1" { in.WASI = append(in.WASI, name) }
+	}
+	for name, f := range m.ExportedFunctions() { in.Exports = append(in.Exports, name+sig(f)) }
+	mems := map[string]api.MemoryDefinition{}
+	for name, md := range m.ExportedMemories() { mems[name] = md }
+	for _, md := range m.ImportedMemories() { mod, name, _ := md.Import(); mems["import:"+mod+"."+name] = md }
+	for name, md := range mems {
+		info := map[string]any{"min_pages": md.Min(), "min_kb": md.Min() * 64}
+		if mx, ok := md.Max(); ok { info["max_pages"] = mx }
+		if in.Memory == nil { in.Memory = map[string]any{} }
+		in.Memory[name] = info
+	}
+	for _, cs := range m.CustomSections() {
+		data := cs.Data()
+		if utf8.Valid(data) && len(data) <= 4096 { in.Sections[cs.Name()] = string(data) } else { in.Sections[cs.Name()] = fmt.Sprintf("<%d bytes>", len(data)) }
+	}
+	sort.Strings(in.Imports); sort.Strings(in.Exports); sort.Strings(in.WASI)
+	in.WASI = slices.Compact(in.WASI)
+	return in, nil
+}
+
+func inspectLocal(args []string) int {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print JSON")
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, "usage: void-wasm-exec inspect [--json] module.wasm"); fs.PrintDefaults() }
+	fs.Parse(args)
+	if fs.NArg() != 1 { fs.Usage(); return 2 }
+	in, err := inspectModule(fs.Arg(0))
+	if err != nil { fmt.Fprintln(os.Stderr, "inspect:", err); return 1 }
+	if *asJSON {
+		b, _ := json.MarshalIndent(in, "", "  ")
+		fmt.Println(string(b))
+		return 0
+	}
+	fmt.Printf("file     %s (%d bytes)\nsha256   %s\ncid      %s\n", in.File, in.Size, in.SHA256, in.CID)
+	fmt.Printf("wasi     %d functions: %s\n", len(in.WASI), strings.Join(in.WASI, " "))
+	for name, m := range in.Memory { fmt.Printf("memory   %s %v\n", name, m) }
+	for _, s := range in.Imports { fmt.Println("import  ", s) }
+	for _, s := range in.Exports { fmt.Println("export  ", s) }
+	for name, v := range in.Sections {
+		if len(v) > 120 { v = v[:120] + "..." }
+		fmt.Printf("section  %s: %s\n", name, strings.ReplaceAll(v, "\n", " "))
+	}
+	return 0
+}
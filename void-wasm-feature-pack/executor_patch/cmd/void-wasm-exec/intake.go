@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Per-module intake rate limits ---
+// intake_rps / intake_burst give every (tenant, module) its own token bucket
+// at intake, before anything is queued, so an upstream that floods one module
+// with envelopes cannot fill the pipeline queues ahead of everything else. A
+// policies.d file may set its own intake_rps / intake_burst. An envelope that
+// finds the bucket empty is, per intake_action:
+//   shed   dropped: SSE skips it, POST /intent and /admin/envelopes get 503
+//          rate_limited
+//   defer  queued once the bucket refills, at most intake_defer_max waiting
+//          per module; past that it is shed
+// Every shed module gets an intake.denied event (reason rate_limited, the
+// count dropped since the last one), at most one per second per module.
+
+var (
+	intakeLimited  = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_intake_limited_total", Help: "Envelopes over their module's intake rate, by action (shed, defer)"}, []string{"action"})
+	intakeDeferred = prometheus.NewGauge(prometheus.GaugeOpts{Name: "void_wasm_intake_deferred", Help: "Envelopes waiting for an intake token"})
+)
+
+type intakeBucket struct {
+	tokens    float64 // below 0: tokens already promised to deferred envelopes
+	last      time.Time
+	shed      int // since the last intake.denied
+	lastEvent time.Time
+}
+
+var (
+	intakeMu      sync.Mutex
+	intakeBuckets = map[usageKey]*intakeBucket{}
+)
+
+// intakeTokens peeks at env's bucket for simulation; cfg is resolved for
+// the module already.
+func intakeTokens(cfg Config, env *Envelope, module string) float64 {
+	intakeMu.Lock(); defer intakeMu.Unlock()
+	b := intakeBuckets[usageKey{tenantOf(env), module}]
+	if b == nil { return float64(max(cfg.IntakeBurst, 1)) }
+	return min(float64(max(cfg.IntakeBurst, 1)), b.tokens+time.Since(b.last).Seconds()*float64(cfg.IntakeRPS))
+}
+
+// intakeTake takes a token for env: ok with how long to hold it back, or
+// !ok when it is shed.
+func intakeTake(cfg Config, env *Envelope) (wait time.Duration, ok bool) {
+	module := env.Module
+	if module == "" { module = "unknown" }
+	c, _ := cfg.forTenant(tenantOf(env))
+	c, _ = c.forModule(module)
+	if c.IntakeRPS <= 0 { return 0, true }
+	rps, burst := float64(c.IntakeRPS), float64(c.IntakeBurst)
+	if burst < 1 { burst = 1 }
+	k := usageKey{tenantOf(env), module}
+	intakeMu.Lock()
+	now := time.Now()
+	b := intakeBuckets[k]
+	if b == nil {
+		if len(intakeBuckets) >= 4096 { // forget idle modules
+			for bk, ob := range intakeBuckets {
+				if now.Sub(ob.last) > time.Minute { delete(intakeBuckets, bk) }
+			}
+		}
+		b = &intakeBucket{tokens: burst, last: now}
+		intakeBuckets[k] = b
+	}
+	b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*rps)
+	b.last = now
+	if b.tokens >= 1 { b.tokens--; intakeMu.Unlock(); return 0, true }
+	if c.IntakeAction == "defer" && -b.tokens < float64(c.IntakeDeferMax) {
+		b.tokens--
+		wait = time.Duration((-b.tokens) / rps * float64(time.Second))
+		intakeMu.Unlock()
+		intakeLimited.WithLabelValues("defer").Inc()
+		intakeDeferred.Inc()
+		time.AfterFunc(wait, intakeDeferred.Dec)
+		return wait, true
+	}
+	b.shed++
+	var dropped int
+	if now.Sub(b.lastEvent) >= time.Second { dropped, b.shed, b.lastEvent = b.shed, 0, now }
+	intakeMu.Unlock()
+	intakeLimited.WithLabelValues("shed").Inc()
+	if dropped > 0 {
+		fmt.Printf("[intake] %s/%s over %d/s, shed %d\n", k.tenant, module, c.IntakeRPS, dropped)
+		go postEvent(cfg, map[string]any{"type": "intake.denied", "status": "error", "meta": map[string]any{"module": module, "tenant": k.tenant, "reason": "rate_limited", "intake_rps": c.IntakeRPS, "dropped": dropped, "node": nodeID(cfg)}})
+	}
+	return 0, false
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Embedded mini-relay (intent_addr) ---
+// Small deployments can post straight to the executor instead of running a
+// relay: intent_addr serves the relay's POST /intent/execute-wasm (bearer
+// intent_token, which only a loopback intent_addr may leave out). The body is an envelope ("type" may be left out) or
+// the relay's request, {"cid","inputs","policy","idempotency_key"}; it goes
+// through the same decoding, schema checks and intake gates as SSE
+// envelopes and is answered 202 {"request_id","status":"queued"} before it
+// runs, or 503 with the intake status (paused, not_placed, not_owner,
+// standby). GET /intent/execute-wasm/{request_id} reports queued, running or
+// the run's result with its record, without the envelope (its inputs and
+// env may hold secrets), as "receipt". An idempotency_key (or
+// Idempotency-Key header) returns the first request's answer for
+// intent_idempotency_ttl instead of queueing again, 422 if the body differs;
+// keys live in memory only. Results still go to the configured sink.
+
+var intentsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_intents_total", Help: "POST /intent/execute-wasm requests by result"}, []string{"result"})
+
+type intent struct {
+	id      string
+	sum     [32]byte // body, for idempotency conflicts
+	created time.Time
+	run     string
+	rec     *RunRecord // set when the run is booked
+}
+
+var (
+	intentMu   sync.Mutex
+	intents    = map[string]*intent{} // request id -> intent
+	intentKeys = map[string]string{}  // idempotency key -> request id
+)
+
+func startIntent(cfg Config) {
+	if cfg.IntentAddr == "" { return }
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /intent/execute-wasm", handleIntent)
+	mux.HandleFunc("GET /intent/execute-wasm/health", func(w http.ResponseWriter, r *http.Request) {
+		c := currentConfig()
+		writeJSON(w, 200, map[string]any{"status": "healthy", "node": nodeID(c), "paused": intakePaused.Load(), "leader": isLeader.Load(),
+			"features": map[string]bool{"idempotency": true, "async": true}, "limits": map[string]any{"idempotency_ttl_ms": c.IntentIdemTTL.Milliseconds()}})
+	})
+	mux.HandleFunc("GET /intent/execute-wasm/{id}", handleIntentGet)
+	mux.HandleFunc("GET /runs/{id}", handleRunGet)
+	mux.HandleFunc("GET /runs/{id}/wait", handleRunWait)
+	var h http.Handler = mux
+	if cfg.IntentToken != "" { h = adminAuth(cfg.IntentToken, mux) } else { fmt.Println("[intent] intent_token not set, /intent/execute-wasm is open on loopback", cfg.IntentAddr) }
+	go func() {
+		if err := http.ListenAndServe(cfg.IntentAddr, h); err != nil { fmt.Println("[intent] server error:", err) }
+	}()
+}
+
+// loopbackAddr reports whether a listen address only takes local
+// connections ("" and 0.0.0.0 hosts take every interface).
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil { return false }
+	if host == "localhost" { return true }
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func handleIntent(w http.ResponseWriter, r *http.Request) {
+	cfg := currentConfig()
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil { writeJSON(w, 400, map[string]any{"error": err.Error()}); return }
+	key := r.Header.Get("idempotency-key")
+	var req map[string]any
+	if json.Unmarshal(body, &req) == nil {
+		if k, ok := req["idempotency_key"].(string); ok && key == "" { key = k }
+		delete(req, "idempotency_key")
+		if _, ok := req["type"]; !ok { req["type"] = "signal.wasm" }
+		body, _ = json.Marshal(req)
+	}
+	sum := sha256.Sum256(body)
+
+	// the key is reserved under the same lock as the lookup, so a concurrent
+	// duplicate finds it; every refusal below gives it back
+	in := &intent{id: newRunID(time.Now()), sum: sum, created: time.Now()}
+	intentMu.Lock()
+	pruneIntents(cfg.IntentIdemTTL)
+	if id, ok := intentKeys[key]; ok && key != "" {
+		prev := intents[id]
+		intentMu.Unlock()
+		if prev.sum != sum { intentsTotal.WithLabelValues("conflict").Inc(); writeJSON(w, 422, map[string]any{"error": "idempotency_key reused with a different request", "request_id": id}); return }
+		intentsTotal.WithLabelValues("duplicate").Inc()
+		if st := intentStatus(id); st != nil { writeJSON(w, 200, st) } else { writeJSON(w, 503, map[string]any{"status": "rejected"}) }
+		return
+	}
+	intents[in.id] = in
+	if key != "" { intentKeys[key] = in.id }
+	intentMu.Unlock()
+	refuse := func(result string, status int, body map[string]any) {
+		intentMu.Lock()
+		delete(intents, in.id)
+		if key != "" { delete(intentKeys, key) }
+		intentMu.Unlock()
+		intentsTotal.WithLabelValues(result).Inc()
+		writeJSON(w, status, body)
+	}
+
+	env, err := decodeEnvelope("application/json", body, cfg.StrictEnvelopes)
+	if err != nil {
+		envelopesInvalid.WithLabelValues(reasonOf(err)).Inc()
+		refuse("invalid", 400, map[string]any{"error": err.Error()}); return
+	}
+	if !isLeader.Load() { refuse("rejected", 503, map[string]any{"status": "standby"}); return }
+	env.intent = in.id
+	setCorrelationHeader(&env, r.Header.Get("x-correlation-id"))
+	if status := admitEnvelope(&env); status != "queued" { refuse("rejected", 503, map[string]any{"status": status}); return }
+	intentsTotal.WithLabelValues("queued").Inc()
+	writeJSON(w, 202, map[string]any{"request_id": in.id, "status": "queued"})
+}
+
+func handleIntentGet(w http.ResponseWriter, r *http.Request) {
+	st := intentStatus(r.PathValue("id"))
+	if st == nil { writeJSON(w, 404, map[string]any{"error": "unknown or expired request_id"}); return }
+	writeJSON(w, 200, st)
+}
+
+// intentStatus is the GET answer for id, nil if unknown.
+func intentStatus(id string) map[string]any {
+	intentMu.Lock(); defer intentMu.Unlock()
+	in := intents[id]
+	if in == nil { return nil }
+	out := map[string]any{"request_id": id, "status": "queued"}
+	if in.run != "" { out["run"], out["status"] = in.run, "running" }
+	if in.rec != nil { out["status"], out["receipt"] = in.rec.Result, runView(in.rec) }
+	return out
+}
+
+// intentStarted and intentDone follow an intent's envelope through the
+// pipeline (policyStage, job.finish).
+func intentStarted(env *Envelope, run string) {
+	if env.intent == "" { return }
+	intentMu.Lock(); defer intentMu.Unlock()
+	if in := intents[env.intent]; in != nil { in.run = run }
+}
+
+func intentDone(env *Envelope, rec *RunRecord) {
+	if env.intent == "" { return }
+	intentMu.Lock(); defer intentMu.Unlock()
+	if in := intents[env.intent]; in != nil { in.rec = rec }
+}
+
+// pruneIntents forgets finished intents older than ttl; intentMu held.
+func pruneIntents(ttl time.Duration) {
+	for key, id := range intentKeys {
+		if in := intents[id]; in == nil || (in.rec != nil && time.Since(in.created) > ttl) { delete(intentKeys, key) }
+	}
+	for id, in := range intents {
+		if in.rec != nil && time.Since(in.created) > ttl { delete(intents, id) }
+	}
+}
@@ -0,0 +1,12 @@
+package main
+
+import "testing"
+
+func TestLoopbackAddr(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"127.0.0.1:9492": true, "localhost:9492": true, "[::1]:9492": true, "127.8.0.1:1": true,
+		":9492": false, "0.0.0.0:9492": false, "[::]:9492": false, "10.0.0.5:9492": false, "example.com:9492": false, "9492": false,
+	} {
+		if got := loopbackAddr(addr); got != want { t.Errorf("loopbackAddr(%q) = %v, want %v", addr, got, want) }
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Publish results to IPFS ---
+// ipfs_publish kubo adds through the Kubo RPC API at ipfs_api (pinned with
+// ipfs_pin); gateway POSTs to a writable gateway at ipfs_gateway/ipfs/. Every
+// /tmp/out artifact gets a CID in the run record, and when the run finishes
+// its receipt (the run record as JSON) is added as well. A wasm.result event
+// carrying the receipt and artifact CIDs then goes out like any other event,
+// so results are content-addressed signals themselves.
+
+var ipfsAdds = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_ipfs_adds_total", Help: "IPFS adds by kind and result"}, []string{"kind", "result"})
+
+// ipfsAdd stores data and returns its CID (v1).
+func ipfsAdd(cfg Config, kind, name string, data []byte) (cid string, err error) {
+	defer func() {
+		result := "ok"
+		if err != nil { result = "error" }
+		ipfsAdds.WithLabelValues(kind, result).Inc()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.FetchTimeout)
+	defer cancel()
+	var req *http.Request
+	if cfg.IPFSPublish == "gateway" {
+		req, err = http.NewRequestWithContext(ctx, "POST", cfg.IPFSGateway+"/ipfs/", bytes.NewReader(data))
+	} else {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile("file", name)
+		fw.Write(data)
+		mw.Close()
+		req, err = http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/api/v0/add?cid-version=1&pin=%t", cfg.IPFSAPI, cfg.IPFSPin), &body)
+		if req != nil { req.Header.Set("content-type", mw.FormDataContentType()) }
+	}
+	if err != nil { return "", err }
+	resp, err := fetchClient.Do(req)
+	if err != nil { return "", err }
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 { return "", fmt.Errorf("ipfs add: status %d", resp.StatusCode) }
+	if cfg.IPFSPublish == "gateway" {
+		io.Copy(io.Discard, resp.Body)
+		cid = resp.Header.Get("Ipfs-Hash")
+	} else {
+		var out struct{ Hash string }
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil { return "", err }
+		cid = out.Hash
+	}
+	if cid == "" { return "", errors.New("ipfs add: no CID in response") }
+	return cid, nil
+}
+
+// publishResult adds the finished run's receipt and posts wasm.result; the
+// record is encoded before returning, the add happens in the background.
+func publishResult(cfg Config, rec *RunRecord) {
+	receipt, err := json.Marshal(rec)
+	if err != nil { return }
+	arts := make([]map[string]any, 0, len(rec.Artifacts))
+	for _, a := range rec.Artifacts {
+		if a.CID != "" { arts = append(arts, map[string]any{"name": a.Name, "cid": a.CID, "sha256": a.SHA256, "size": a.Size}) }
+	}
+	meta := map[string]any{"run": rec.ID, "module": rec.Module, "tenant": rec.Tenant, "result": rec.Result, "artifacts": arts}
+	go func() {
+		if cid, err := ipfsAdd(cfg, "receipt", rec.ID+".json", receipt); err != nil {
+			fmt.Println("[ipfs] receipt", rec.ID, "failed:", err, rec.corr())
+		} else {
+			meta["receipt_cid"] = cid
+		}
+		postRunEvent(cfg, rec, map[string]any{"type": "wasm.result", "meta": meta})
+	}()
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// --- KV backup, restore and migration ---
+// A snapshot is one JSON document with every tenant's map:
+//   {"version":1,"created":"...","node":"...","backend":"bbolt","tenants":{"default":{"k":v}}}
+// With kv_backup_key (32 bytes, hex or base64) it can be sealed with
+// AES-256-GCM: "VOIDKV1\n", a 12-byte nonce, then the ciphertext; import
+// recognises sealed snapshots by that header. Restoring replaces each
+// snapshot tenant's map whole (mode=merge overlays it key by key instead);
+// tenants absent from the snapshot are left alone, and watching runs see the
+// restored keys as changes from run "kv.import".
+//   GET  /admin/kv/export[?tenant=t&encrypt=1]
+//   POST /admin/kv/import[?mode=merge]
+//   void-wasm-exec kv export|import|migrate (offline; file and bbolt are
+// locked by a running executor, so stop it first)
+
+const kvSealMagic = "VOIDKV1\n"
+
+type kvSnapshot struct {
+	Version int                       `json:"version"`
+	Created time.Time                 `json:"created"`
+	Node    string                    `json:"node,omitempty"`
+	Backend string                    `json:"backend"`
+	Tenants map[string]map[string]any `json:"tenants"`
+}
+
+func backupKey(s string) ([]byte, error) {
+	k, err := hex.DecodeString(s)
+	if err != nil { k, err = base64.StdEncoding.DecodeString(s) }
+	if err != nil || len(k) != 32 { return nil, errors.New("want 32 bytes, hex or base64") }
+	return k, nil
+}
+
+func kvGCM(key string) (cipher.AEAD, error) {
+	if key == "" { return nil, errors.New("kv_backup_key not set") }
+	k, err := backupKey(key)
+	if err != nil { return nil, err }
+	blk, _ := aes.NewCipher(k)
+	return cipher.NewGCM(blk)
+}
+
+func sealSnapshot(raw []byte, key string) ([]byte, error) {
+	gcm, err := kvGCM(key)
+	if err != nil { return nil, err }
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+	out := append([]byte(kvSealMagic), nonce...)
+	return gcm.Seal(out, nonce, raw, []byte(kvSealMagic)), nil
+}
+
+// parseSnapshot decodes plain or sealed snapshot bytes.
+func parseSnapshot(raw []byte, key string) (kvSnapshot, error) {
+	var snap kvSnapshot
+	if rest, sealed := bytes.CutPrefix(raw, []byte(kvSealMagic)); sealed {
+		gcm, err := kvGCM(key)
+		if err != nil { return snap, fmt.Errorf("sealed snapshot: %w", err) }
+		if len(rest) < gcm.NonceSize() { return snap, errors.New("sealed snapshot: truncated") }
+		if raw, err = gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], []byte(kvSealMagic)); err != nil { return snap, errors.New("sealed snapshot: wrong key or corrupted") }
+	}
+	if err := json.Unmarshal(raw, &snap); err != nil { return snap, fmt.Errorf("snapshot: %w", err) }
+	if snap.Version != 1 { return snap, fmt.Errorf("snapshot: unsupported version %d", snap.Version) }
+	return snap, nil
+}
+
+// snapshotKV reads every tenant of st (or just only).
+func snapshotKV(cfg Config, st kvStore, backend, only string) (kvSnapshot, error) {
+	snap := kvSnapshot{Version: 1, Created: time.Now().UTC(), Node: nodeID(cfg), Backend: backend, Tenants: map[string]map[string]any{}}
+	names := []string{only}
+	if only == "" {
+		var err error
+		if names, err = st.tenants(); err != nil { return snap, err }
+	}
+	for _, t := range names {
+		kvMu.Lock()
+		m, err := st.load(t)
+		kvMu.Unlock()
+		if err != nil { return snap, fmt.Errorf("tenant %s: %w", t, err) }
+		if len(m) > 0 || only != "" { snap.Tenants[t] = m }
+	}
+	return snap, nil
+}
+
+// restoreKV writes snap into st and returns how many tenants and keys it
+// touched.
+func restoreKV(st kvStore, snap kvSnapshot, merge, notify bool) (int, int, error) {
+	keys := 0
+	for t, m := range snap.Tenants {
+		if !tenantName.MatchString(t) { return 0, 0, fmt.Errorf("tenant %q: invalid name", t) }
+		kvMu.Lock()
+		next := m
+		if merge {
+			cur, err := st.load(t)
+			if err != nil { kvMu.Unlock(); return 0, keys, fmt.Errorf("tenant %s: %w", t, err) }
+			for k, v := range m { cur[k] = v }
+			next = cur
+		}
+		err := st.save(t, next)
+		kvMu.Unlock()
+		if err != nil { return 0, keys, fmt.Errorf("tenant %s: %w", t, err) }
+		keys += len(m)
+		if _, watched := st.(kvRevStore); notify && !watched { // nats: its watcher notifies
+			for k, v := range m { kvNotify(t, k, v, "kv.import", 0) }
+		}
+	}
+	return len(snap.Tenants), keys, nil
+}
+
+func handleKVExport(w http.ResponseWriter, r *http.Request) {
+	cfg := currentConfig()
+	tenant := r.URL.Query().Get("tenant")
+	if tenant != "" && !tenantName.MatchString(tenant) { writeJSON(w, 400, map[string]any{"error": "invalid tenant"}); return }
+	snap, err := snapshotKV(cfg, kvBackend, cfg.KVBackend, tenant)
+	if err != nil { writeJSON(w, 500, map[string]any{"error": err.Error()}); return }
+	raw, _ := json.Marshal(snap)
+	name := "kv-" + nodeID(cfg) + "-" + snap.Created.Format("20060102T150405Z") + ".json"
+	ctype := "application/json"
+	if r.URL.Query().Get("encrypt") == "1" {
+		if raw, err = sealSnapshot(raw, cfg.KVBackupKey); err != nil { writeJSON(w, 400, map[string]any{"error": err.Error()}); return }
+		name, ctype = name+".enc", "application/octet-stream"
+	}
+	audit(cfg, "admin.kv.export", map[string]any{"tenant": tenant, "tenants": len(snap.Tenants), "encrypted": ctype != "application/json"})
+	w.Header().Set("content-type", ctype)
+	w.Header().Set("content-disposition", `attachment; filename="`+name+`"`)
+	w.Write(raw)
+}
+
+func handleKVImport(w http.ResponseWriter, r *http.Request) {
+	cfg := currentConfig()
+	mode := r.URL.Query().Get("mode")
+	if mode != "" && mode != "replace" && mode != "merge" { writeJSON(w, 400, map[string]any{"error": "mode: replace or merge"}); return }
+	raw, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 256<<20))
+	if err != nil { writeJSON(w, 400, map[string]any{"error": err.Error()}); return }
+	snap, err := parseSnapshot(raw, cfg.KVBackupKey)
+	if err != nil { writeJSON(w, 400, map[string]any{"error": err.Error()}); return }
+	tenants, keys, err := restoreKV(kvBackend, snap, mode == "merge", true)
+	if err != nil { writeJSON(w, 500, map[string]any{"error": err.Error(), "keys": keys}); return }
+	fmt.Println("[kv] imported", keys, "keys for", tenants, "tenants from", snap.Node)
+	audit(cfg, "admin.kv.import", map[string]any{"mode": mode, "tenants": tenants, "keys": keys, "from_node": snap.Node, "created": snap.Created})
+	writeJSON(w, 200, map[string]any{"tenants": tenants, "keys": keys})
+}
+
+// kvLocal is `void-wasm-exec kv export|import|migrate`.
+func kvLocal(args []string) int {
+	usage := func() int { fmt.Fprintln(os.Stderr, "usage: void-wasm-exec kv export|import|migrate [flags] (-h for each)"); return 2 }
+	if len(args) == 0 { return usage() }
+	fs := flag.NewFlagSet("kv "+args[0], flag.ExitOnError)
+	cfgPath := fs.String("config", getenv("CONFIG_FILE", ""), "YAML config file (kv_* settings)")
+	backend := fs.String("backend", "", "backend to use (default kv_backend)")
+	fail := func(msg ...any) int { fmt.Fprintln(os.Stderr, append([]any{"kv " + args[0] + ":"}, msg...)...); return 1 }
+	switch args[0] {
+	case "export":
+		out := fs.String("o", "", "output file (default stdout)")
+		tenant := fs.String("tenant", "", "only this tenant")
+		encrypt := fs.Bool("encrypt", false, "seal with kv_backup_key")
+		fs.Parse(args[1:])
+		cfg, st, name, err := kvOpenLocal(*cfgPath, *backend)
+		if err != nil { return fail(err) }
+		defer st.close()
+		snap, err := snapshotKV(cfg, st, name, *tenant)
+		if err != nil { return fail(err) }
+		raw, _ := json.MarshalIndent(snap, "", "  ")
+		if *encrypt {
+			if raw, err = sealSnapshot(raw, cfg.KVBackupKey); err != nil { return fail(err) }
+		}
+		if *out == "" { os.Stdout.Write(raw); return 0 }
+		if err := writeFileAtomic(*out, raw, 0o600); err != nil { return fail(err) }
+		fmt.Fprintln(os.Stderr, "kv export:", len(snap.Tenants), "tenants to", *out)
+	case "import":
+		merge := fs.Bool("merge", false, "overlay keys instead of replacing each tenant")
+		fs.Usage = func() { fmt.Fprintln(os.Stderr, "usage: void-wasm-exec kv import [--backend b] [--merge] snapshot.json|-"); fs.PrintDefaults() }
+		fs.Parse(args[1:])
+		if fs.NArg() != 1 { fs.Usage(); return 2 }
+		var raw []byte
+		var err error
+		if fs.Arg(0) == "-" { raw, err = io.ReadAll(os.Stdin) } else { raw, err = os.ReadFile(fs.Arg(0)) }
+		if err != nil { return fail(err) }
+		cfg, st, _, err := kvOpenLocal(*cfgPath, *backend)
+		if err != nil { return fail(err) }
+		defer st.close()
+		snap, err := parseSnapshot(raw, cfg.KVBackupKey)
+		if err != nil { return fail(err) }
+		tenants, keys, err := restoreKV(st, snap, *merge, false)
+		if err != nil { return fail(err) }
+		fmt.Fprintln(os.Stderr, "kv import:", keys, "keys,", tenants, "tenants")
+	case "migrate":
+		to := fs.String("to", "", "destination backend: file | bbolt | redis | nats")
+		merge := fs.Bool("merge", false, "overlay keys instead of replacing each tenant")
+		fs.Usage = func() { fmt.Fprintln(os.Stderr, "usage: void-wasm-exec kv migrate [--backend from] --to bbolt|redis|nats|file"); fs.PrintDefaults() }
+		fs.Parse(args[1:])
+		cfg, src, from, err := kvOpenLocal(*cfgPath, *backend)
+		if err != nil { return fail(err) }
+		defer src.close()
+		if *to == "" || *to == from { fs.Usage(); return 2 }
+		dst, err := openKV(cfg, *to)
+		if err != nil { return fail(err) }
+		defer dst.close()
+		snap, err := snapshotKV(cfg, src, from, "")
+		if err != nil { return fail(err) }
+		tenants, keys, err := restoreKV(dst, snap, *merge, false)
+		if err != nil { return fail(err) }
+		fmt.Fprintf(os.Stderr, "kv migrate: %d keys, %d tenants, %s -> %s; set kv_backend: %s\n", keys, tenants, from, *to, *to)
+	default:
+		return usage()
+	}
+	return 0
+}
+
+func kvOpenLocal(path, backend string) (Config, kvStore, string, error) {
+	cfg, err := loadConfig(path)
+	if err != nil { return cfg, nil, "", err }
+	if cfg.AtRestKey != "" {
+		if atRest, err = loadAtRestKey(cfg.AtRestKey); err != nil { return cfg, nil, "", fmt.Errorf("at_rest_key: %w", err) }
+	}
+	if backend == "" { backend = cfg.KVBackend }
+	st, err := openKV(cfg, backend)
+	if err != nil && backend == "bbolt" { err = fmt.Errorf("%w (is an executor holding it?)", err) }
+	return cfg, st, backend, err
+}
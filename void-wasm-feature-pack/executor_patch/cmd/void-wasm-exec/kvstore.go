@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	bolt "go.etcd.io/bbolt"
+)
+
+// --- KV backends ---
+// kv_backend picks where syscall.kv.* state lives: file keeps one JSON file
+// per tenant next to kv.json (the default), bbolt one bucket per tenant in
+// kv_bolt, redis one hash per tenant (<kv_redis_prefix><tenant>, field = key,
+// value = JSON) at kv_redis_url so it survives the node and can be shared,
+// nats a JetStream KV bucket with per-key revisions (natskv.go).
+// With at_rest_key values are sealed (atrest.go).
+// A tenant's map is still read and written whole under kvMu. The backend is
+// opened at startup and is not reloadable; `void-wasm-exec kv migrate` moves
+// data between them (kvbackup.go).
+
+type kvStore interface {
+	load(tenant string) (map[string]any, error)
+	save(tenant string, m map[string]any) error
+	tenants() ([]string, error)
+	close() error
+}
+
+var kvBackend kvStore = fileKV{}
+
+func openKV(cfg Config, backend string) (kvStore, error) {
+	switch backend {
+	case "", "file":
+		return fileKV{}, nil
+	case "bbolt":
+		os.MkdirAll(filepath.Dir(cfg.KVBolt), 0o755)
+		db, err := bolt.Open(cfg.KVBolt, 0o600, &bolt.Options{Timeout: time.Second})
+		if err != nil { return nil, fmt.Errorf("kv_bolt %s: %w", cfg.KVBolt, err) }
+		return boltKV{db}, nil
+	case "redis":
+		opt, err := redis.ParseURL(cfg.KVRedisURL)
+		if err != nil { return nil, fmt.Errorf("kv_redis_url: %w", err) }
+		c := redis.NewClient(opt)
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		if err := c.Ping(ctx).Err(); err != nil { c.Close(); return nil, fmt.Errorf("redis: %w", err) }
+		return redisKV{c, cfg.KVRedisPrefix}, nil
+	case "nats":
+		return openNATSKV(cfg)
+	}
+	return nil, fmt.Errorf("unknown kv backend %q", backend)
+}
+
+// kvEncode is one bbolt/redis value: JSON, sealed with at_rest_key if set
+// and bound to its tenant and key.
+func kvEncode(tenant, key string, v any) []byte {
+	raw, _ := json.Marshal(v)
+	return sealAtRest(raw, "kv:"+tenant+"/"+key)
+}
+
+func kvDecode(tenant, key string, raw []byte) (any, error) {
+	raw, err := openAtRest(raw, "kv:"+tenant+"/"+key)
+	if err != nil { return nil, err }
+	var v any
+	err = json.Unmarshal(raw, &v)
+	return v, err
+}
+
+// --- file ---
+type fileKV struct{}
+
+func (fileKV) load(tenant string) (map[string]any, error) {
+	m := map[string]any{}
+	b, err := os.ReadFile(kvFile(tenant))
+	if errors.Is(err, os.ErrNotExist) { return m, nil }
+	if err == nil { b, err = openAtRest(b, "kv:"+tenant) }
+	if err != nil { return nil, err }
+	_ = json.Unmarshal(b, &m)
+	return m, nil
+}
+
+func (fileKV) save(tenant string, m map[string]any) error {
+	b, _ := json.Marshal(m)
+	p := kvFile(tenant)
+	os.MkdirAll(filepath.Dir(p), 0o755)
+	return writeFileAtomic(p, sealAtRest(b, "kv:"+tenant), 0o600)
+}
+
+func (fileKV) tenants() ([]string, error) {
+	var out []string
+	if _, err := os.Stat(kvPath); err == nil { out = append(out, defaultTenant) }
+	files, _ := filepath.Glob(filepath.Join(filepath.Dir(kvPath), "kv", "*.json"))
+	for _, f := range files { out = append(out, strings.TrimSuffix(filepath.Base(f), ".json")) }
+	return out, nil
+}
+
+func (fileKV) close() error { return nil }
+
+// --- bbolt ---
+type boltKV struct{ db *bolt.DB }
+
+func (s boltKV) load(tenant string) (map[string]any, error) {
+	m := map[string]any{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(tenant))
+		if b == nil { return nil }
+		return b.ForEach(func(k, v []byte) error {
+			val, err := kvDecode(tenant, string(k), v)
+			if err != nil { return fmt.Errorf("key %s: %w", k, err) }
+			m[string(k)] = val
+			return nil
+		})
+	})
+	return m, err
+}
+
+func (s boltKV) save(tenant string, m map[string]any) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket([]byte(tenant)); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) { return err }
+		b, err := tx.CreateBucket([]byte(tenant))
+		if err != nil { return err }
+		for k, v := range m {
+			if err := b.Put([]byte(k), kvEncode(tenant, k, v)); err != nil { return err }
+		}
+		return nil
+	})
+}
+
+func (s boltKV) tenants() ([]string, error) {
+	var out []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error { out = append(out, string(name)); return nil })
+	})
+	return out, err
+}
+
+func (s boltKV) close() error { return s.db.Close() }
+
+// --- redis ---
+type redisKV struct {
+	c      *redis.Client
+	prefix string
+}
+
+func (s redisKV) load(tenant string) (map[string]any, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	h, err := s.c.HGetAll(ctx, s.prefix+tenant).Result()
+	if err != nil { return nil, err }
+	m := make(map[string]any, len(h))
+	for k, raw := range h {
+		val, err := kvDecode(tenant, k, []byte(raw))
+		if err != nil { return nil, fmt.Errorf("key %s: %w", k, err) }
+		m[k] = val
+	}
+	return m, nil
+}
+
+func (s redisKV) save(tenant string, m map[string]any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	fields := make([]any, 0, 2*len(m))
+	for k, v := range m { fields = append(fields, k, kvEncode(tenant, k, v)) }
+	_, err := s.c.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Del(ctx, s.prefix+tenant)
+		if len(fields) > 0 { p.HSet(ctx, s.prefix+tenant, fields...) }
+		return nil
+	})
+	return err
+}
+
+func (s redisKV) tenants() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var out []string
+	it := s.c.Scan(ctx, 0, s.prefix+"*", 100).Iterator()
+	for it.Next(ctx) { out = append(out, strings.TrimPrefix(it.Val(), s.prefix)) }
+	sort.Strings(out)
+	return out, it.Err()
+}
+
+func (s redisKV) close() error { return s.c.Close() }
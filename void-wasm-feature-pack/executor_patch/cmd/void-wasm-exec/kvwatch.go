@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// --- KV watch (void.kv_watch / void.kv_next, cap kv) ---
+// stdout syscalls are handled after the module exits, so a watch is two host
+// functions instead: kv_watch(prefix) subscribes the running module to
+// changes of keys under prefix in its tenant's KV, kv_next(buf, cap,
+// timeout_ms) blocks until the next change (or the timeout, or the end of
+// the run) and writes it as JSON:
+//   {"watch":1,"key":"cfg/flags","value":{...},"run":"<writer run id>"}
+// Changes come from other runs' syscall.kv.set as the executor applies them
+// (with kv_backend nats from every node, plus "rev"); a run never sees its
+// own writes. Each run queues at most kvWatchQueue
+// changes; past that they are dropped and the next delivery carries
+// "dropped":n, a value that does not fit the buffer arrives without it and
+// with "truncated":true. Watches end with the run. In the timeline a watch is
+// syscall.kv.watch with the prefix as id.
+
+const (
+	kvWatchMax    = 8   // watches per run
+	kvWatchQueue  = 256 // pending changes per run
+	kvWatchPrefix = 256 // bytes
+)
+
+// kv_watch results; kv_next returns the length written, 0 on timeout or -1
+// without a watch.
+const (
+	kvWatchDenied    = -1
+	kvWatchLimit     = -2
+	kvWatchBadPrefix = -3
+)
+
+var kvWatchTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_kv_watch_total", Help: "KV change notifications to watching runs by result"}, []string{"result"})
+
+type kvChange struct {
+	Watch     int32  `json:"watch"`
+	Key       string `json:"key"`
+	Value     any    `json:"value,omitempty"`
+	Run       string `json:"run,omitempty"`
+	Rev       uint64 `json:"rev,omitempty"` // kv_backend nats
+	Dropped   int    `json:"dropped,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// runWatches holds one run's watches and its queue of changes.
+type runWatches struct {
+	cfg   Config
+	rec   *RunRecord
+	start time.Time
+	ch    chan kvChange
+
+	mu       sync.Mutex
+	prefixes []string // watch id = index+1
+	dropped  int
+}
+
+type kvWatchKey struct{}
+
+var (
+	kvWatchMu  sync.Mutex
+	kvWatchers = map[string]map[*runWatches]bool{} // tenant -> watching runs
+)
+
+// withKVWatch gives the run's host calls somewhere to keep watches; stop
+// unsubscribes them.
+func withKVWatch(ctx context.Context, cfg Config, rec *RunRecord, start time.Time) (context.Context, func()) {
+	w := &runWatches{cfg: cfg, rec: rec, start: start, ch: make(chan kvChange, kvWatchQueue)}
+	return context.WithValue(ctx, kvWatchKey{}, w), func() {
+		kvWatchMu.Lock(); defer kvWatchMu.Unlock()
+		delete(kvWatchers[rec.Tenant], w)
+		if len(kvWatchers[rec.Tenant]) == 0 { delete(kvWatchers, rec.Tenant) }
+	}
+}
+
+// kvNotify fans a committed kv.set out to the tenant's watching runs; rev is
+// 0 unless the backend versions keys.
+func kvNotify(tenant, key string, value any, run string, rev uint64) {
+	kvWatchMu.Lock(); defer kvWatchMu.Unlock()
+	for w := range kvWatchers[tenant] { w.offer(key, value, run, rev) }
+}
+
+func (w *runWatches) offer(key string, value any, run string, rev uint64) {
+	w.mu.Lock(); defer w.mu.Unlock()
+	for i, p := range w.prefixes {
+		if !strings.HasPrefix(key, p) { continue }
+		select {
+		case w.ch <- kvChange{Watch: int32(i + 1), Key: key, Value: value, Run: run, Rev: rev}:
+		default:
+			w.dropped++
+			kvWatchTotal.WithLabelValues("dropped").Inc()
+		}
+		return // one notification per change, for the first matching watch
+	}
+}
+
+func (w *runWatches) watch(prefix string) int32 {
+	if !allowed("kv", w.cfg.AllowCaps) { return kvWatchDenied }
+	w.mu.Lock()
+	if len(w.prefixes) >= kvWatchMax { w.mu.Unlock(); return kvWatchLimit }
+	w.prefixes = append(w.prefixes, prefix)
+	id := int32(len(w.prefixes))
+	first := id == 1
+	w.mu.Unlock()
+	if first {
+		kvWatchMu.Lock()
+		if kvWatchers[w.rec.Tenant] == nil { kvWatchers[w.rec.Tenant] = map[*runWatches]bool{} }
+		kvWatchers[w.rec.Tenant][w] = true
+		kvWatchMu.Unlock()
+	}
+	return id
+}
+
+// next waits up to timeout (and no longer than the run) for a change and
+// encodes it to fit max bytes; nil on timeout.
+func (w *runWatches) next(ctx context.Context, timeout time.Duration, max int) []byte {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	var c kvChange
+	select {
+	case c = <-w.ch:
+	case <-t.C: return nil
+	case <-ctx.Done(): return nil
+	}
+	w.mu.Lock()
+	c.Dropped, w.dropped = w.dropped, 0
+	w.mu.Unlock()
+	b, _ := json.Marshal(c)
+	if len(b) > max {
+		c.Value, c.Truncated = nil, true
+		b, _ = json.Marshal(c)
+		if len(b) > max { kvWatchTotal.WithLabelValues("dropped").Inc(); return nil }
+	}
+	kvWatchTotal.WithLabelValues("delivered").Inc()
+	return b
+}
+
+// exportKVWatch adds kv_watch and kv_next to the void host module.
+func exportKVWatch(b wazero.HostModuleBuilder) {
+	i32 := api.ValueTypeI32
+	b.NewFunctionBuilder().
+		WithGoModuleFunction(api.GoModuleFunc(func(ctx context.Context, mod api.Module, stack []uint64) {
+			t0 := time.Now()
+			w, _ := ctx.Value(kvWatchKey{}).(*runWatches)
+			ptr, n := api.DecodeU32(stack[0]), api.DecodeU32(stack[1])
+			raw, ok := mod.Memory().Read(ptr, n)
+			res := int32(kvWatchBadPrefix)
+			switch {
+			case w == nil: res = kvWatchDenied
+			case ok && n <= kvWatchPrefix: res = w.watch(string(raw))
+			}
+			stack[0] = api.EncodeI32(res)
+			if w == nil { return }
+			if !w.rec.budget.spend(budgetSyscalls, 1) { return }
+			result := map[int32]string{kvWatchDenied: "denied", kvWatchLimit: "limit", kvWatchBadPrefix: "bad_prefix"}[res]
+			if result == "" { result = "ok" }
+			sysReqTotal.WithLabelValues("syscall.kv.watch", result).Inc()
+			w.rec.traceSyscall(w.cfg.TimelineMax, w.start, "syscall.kv.watch", string(raw), result, t0)
+		}), []api.ValueType{i32, i32}, []api.ValueType{i32}).
+		Export("kv_watch")
+	b.NewFunctionBuilder().
+		WithGoModuleFunction(api.GoModuleFunc(func(ctx context.Context, mod api.Module, stack []uint64) {
+			w, _ := ctx.Value(kvWatchKey{}).(*runWatches)
+			ptr, max, ms := api.DecodeU32(stack[0]), api.DecodeU32(stack[1]), api.DecodeI32(stack[2])
+			if w == nil { stack[0] = api.EncodeI32(-1); return }
+			w.mu.Lock()
+			watching := len(w.prefixes) > 0
+			w.mu.Unlock()
+			if !watching { stack[0] = api.EncodeI32(-1); return }
+			if ms < 0 { ms = 0 }
+			b := w.next(ctx, time.Duration(ms)*time.Millisecond, int(max))
+			if b == nil || !mod.Memory().Write(ptr, b) { stack[0] = 0; return }
+			stack[0] = api.EncodeI32(int32(len(b)))
+		}), []api.ValueType{i32, i32, i32}, []api.ValueType{i32}).
+		Export("kv_next")
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var runBuckets = []float64{50,100,200,400,800,1500,3000,6000,12000}
+
+// newRunDuration builds void_wasm_duration_ms; with native=true it also emits
+// a Prometheus native (sparse) histogram next to the classic buckets.
+func newRunDuration(native bool) *prometheus.HistogramVec {
+	opts := prometheus.HistogramOpts{Name: "void_wasm_duration_ms", Help: "Run duration ms", Buckets: runBuckets}
+	if native {
+		opts.NativeHistogramBucketFactor = 1.1
+		opts.NativeHistogramMaxBucketNumber = 160
+	}
+	return prometheus.NewHistogramVec(opts, []string{"module"})
+}
+
+// traceID takes the trace from meta.trace_id or a W3C meta.traceparent,
+// falling back to the run ID so every observation still links somewhere.
+func traceID(env *Envelope, fallback string) string {
+	if id, _ := env.Meta["trace_id"].(string); id != "" { return id }
+	if tp, _ := env.Meta["traceparent"].(string); tp != "" {
+		if parts := strings.Split(tp, "-"); len(parts) == 4 && len(parts[1]) == 32 { return parts[1] }
+	}
+	return fallback
+}
+
+// observeRun records run latency with trace_id and correlation_id exemplar
+// labels (OpenMetrics). Exemplar labels are capped at 128 runes in total, so
+// whichever does not fit is left out.
+func observeRun(module string, ms float64, trace, corr string) {
+	obs := runDuration.WithLabelValues(module)
+	lbl := prometheus.Labels{}
+	n := 0
+	for _, kv := range [][2]string{{"trace_id", trace}, {"correlation_id", corr}} {
+		if l := utf8.RuneCountInString(kv[0] + kv[1]); kv[1] != "" && n+l <= 128 { lbl[kv[0]] = kv[1]; n += l }
+	}
+	if eo, ok := obs.(prometheus.ExemplarObserver); ok && len(lbl) > 0 {
+		eo.ObserveWithExemplar(ms, lbl)
+		return
+	}
+	obs.Observe(ms)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Leader election for hot-standby pairs (flock on a shared volume) ---
+// Only the lock holder consumes the relay; the standby polls the lock every
+// LeaderRetry. The kernel drops the lock the moment the leader process dies,
+// so failover takes at most one retry interval.
+
+var isLeader atomic.Bool
+
+var leaderGauge = prometheus.NewGauge(prometheus.GaugeOpts{Name: "void_wasm_leader", Help: "1 while this executor holds leadership and consumes the relay"})
+
+func setLeader(v bool) {
+	isLeader.Store(v)
+	if v { leaderGauge.Set(1) } else { leaderGauge.Set(0) }
+}
+
+// startLeaderElection returns immediately; without a lock path every node is leader.
+func startLeaderElection(cfg Config) {
+	if cfg.LeaderLock == "" { setLeader(true); return }
+	setLeader(false)
+	go func() {
+		os.MkdirAll(filepath.Dir(cfg.LeaderLock), 0o755)
+		for {
+			if f, err := tryLock(cfg.LeaderLock); err == nil {
+				f.Truncate(0)
+				f.WriteString(nodeID(cfg) + "\n")
+				setLeader(true)
+				fmt.Println("[leader] acquired", cfg.LeaderLock)
+				postEvent(cfg, map[string]any{"type": "node.leader", "meta": map[string]any{"node": nodeID(cfg), "lock": cfg.LeaderLock}})
+				return // held until the process exits; f is intentionally never closed
+			}
+			time.Sleep(cfg.LeaderRetry)
+		}
+	}()
+}
+
+// waitLeader blocks intake until this node is leader or ctx is done.
+func waitLeader(ctx context.Context) bool {
+	for !isLeader.Load() {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+	return true
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Receipts ledger (ledger_dir) ---
+// history keeps run records for a while and an admin can delete them; the
+// ledger is the tamper-evident account of what the node ran. Every booked
+// run appends one line to <ledger_dir>/ledger.ndjson:
+//   {seq, kind:"run", ts, run, module, tenant, result, receipt, module_prev,
+//    prev, hash}
+// receipt is the sha256 of the receipt (signature.digest when identity_key
+// signs it), prev the hash of the line before, module_prev the hash of the
+// module's previous run line and hash the sha256 of the line without hash
+// and sig: the node's history is one chain, each module's a chain threaded
+// through it. Every ledger_root_every the runs since the last root are
+// sealed by a root line {kind:"root", from, to, count, root, heads}: root is
+// the RFC 6962 Merkle root of their hashes, heads each module's latest hash.
+// With identity_key the root line is signed (sig: DSSE over its hash,
+// payload type application/vnd.void.ledger-root) and published as a
+// ledger.root event (node, key_id, seq, hash, prev, from, to, count, root,
+// sig); with ipfs_publish the sealed lines are added to IPFS first and the
+// event carries their cid. Once the file passes ledger_segment_mb it is
+// renamed ledger-<first seq>.ndjson when the next root is sealed, and the
+// new file starts with that root line, which carries all the node needs to
+// continue the chains. A torn last line (crash mid-write) is dropped at
+// startup; any other broken line stops the executor. GET /admin/ledger
+// shows the head, GET /admin/ledger/proof?run=<id> the run's line, its root
+// line and Merkle audit path. `void-wasm-exec ledger-verify [--key pub.pem]
+// <ledger_dir>` rechecks every chain, root and signature; `ledger-verify
+// --key pub.pem --proof proof.json` checks one proof.
+
+const ledgerRootType = "application/vnd.void.ledger-root"
+
+type LedgerEntry struct {
+	Seq     int64             `json:"seq"`
+	Kind    string            `json:"kind"` // run | root
+	TS      string            `json:"ts"`
+	Run     string            `json:"run,omitempty"`
+	Module  string            `json:"module,omitempty"`
+	Tenant  string            `json:"tenant,omitempty"`
+	Result  string            `json:"result,omitempty"`
+	Receipt string            `json:"receipt,omitempty"`
+	ModPrev string            `json:"module_prev,omitempty"`
+	From    int64             `json:"from,omitempty"`
+	To      int64             `json:"to,omitempty"`
+	Count   int               `json:"count,omitempty"`
+	Root    string            `json:"root,omitempty"`
+	Heads   map[string]string `json:"heads,omitempty"`
+	Prev    string            `json:"prev"`
+	Hash    string            `json:"hash"`
+	Sig     *ResultSignature  `json:"sig,omitempty"`
+}
+
+// LedgerProof shows a run is under a sealed root.
+type LedgerProof struct {
+	Entry *LedgerEntry `json:"entry"`
+	Index int          `json:"index"` // leaf index in the root's batch
+	Path  []string     `json:"path"`  // RFC 6962 audit path, hex
+	Root  *LedgerEntry `json:"root"`
+}
+
+type receiptLedger struct {
+	mu       sync.Mutex
+	dir      string
+	f        *os.File
+	size     int64
+	first    int64             // seq of ledger.ndjson's first line
+	seq      int64
+	head     string
+	heads    map[string]string // module -> hash of its last run line
+	batch    []string          // hashes of the runs since the last root
+	lines    []byte            // and their lines, for ipfs
+	lastRoot *LedgerEntry
+}
+
+var (
+	ledger      *receiptLedger
+	ledgerTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_ledger_entries_total", Help: "Receipts ledger lines by kind (run, root) and result"}, []string{"kind", "result"})
+	ledgerSeq   = prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: "void_wasm_ledger_seq", Help: "Sequence number of the receipts ledger head"}, func() float64 {
+		if ledger == nil { return 0 }
+		ledger.mu.Lock()
+		defer ledger.mu.Unlock()
+		return float64(ledger.seq)
+	})
+)
+
+// digest is the entry's hash: sha256 of its JSON without hash and sig.
+func (e LedgerEntry) digest() string {
+	e.Hash, e.Sig = "", nil
+	b, _ := json.Marshal(e)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// openLedger resumes the chain in dir; nil when the ledger is off.
+func openLedger(dir string) (*receiptLedger, error) {
+	if dir == "" { return nil, nil }
+	if err := os.MkdirAll(dir, 0o700); err != nil { return nil, err }
+	path := filepath.Join(dir, "ledger.ndjson")
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil { return nil, err }
+	l := &receiptLedger{dir: dir, f: f, heads: map[string]string{}}
+	r := bufio.NewReader(f)
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			if len(line) > 0 {
+				fmt.Println("[ledger] dropping torn line", n, "of", path)
+				if err := f.Truncate(l.size); err != nil { f.Close(); return nil, err }
+			}
+			break
+		}
+		var e LedgerEntry
+		if err := json.Unmarshal(line, &e); err != nil { f.Close(); return nil, fmt.Errorf("%s:%d: %w", path, n, err) }
+		if e.Hash != e.digest() || (l.seq > 0 && (e.Seq != l.seq+1 || e.Prev != l.head)) { f.Close(); return nil, fmt.Errorf("%s:%d: chain broken at seq %d", path, n, e.Seq) }
+		if l.size == 0 { l.first = e.Seq }
+		l.size += int64(len(line))
+		l.apply(&e, line)
+	}
+	if segs := ledgerFiles(dir); l.seq == 0 && len(segs) > 1 {
+		// crashed between rotating and sealing: the chain goes on from the last segment
+		if err := scanLedger(segs[len(segs)-2:len(segs)-1], func(e *LedgerEntry) error {
+			line, _ := json.Marshal(e)
+			l.apply(e, append(line, '\n'))
+			return nil
+		}); err != nil { f.Close(); return nil, err }
+	}
+	if l.seq > 0 { fmt.Println("[ledger]", path, "seq", l.seq, "pending", len(l.batch)) }
+	return l, nil
+}
+
+func (l *receiptLedger) apply(e *LedgerEntry, line []byte) {
+	l.seq, l.head = e.Seq, e.Hash
+	switch e.Kind {
+	case "run":
+		l.heads[e.Module] = e.Hash
+		l.batch = append(l.batch, e.Hash)
+		l.lines = append(l.lines, line...)
+	case "root":
+		l.heads = maps.Clone(e.Heads)
+		if l.heads == nil { l.heads = map[string]string{} }
+		l.batch, l.lines, l.lastRoot = nil, nil, e
+	}
+}
+
+// write chains e onto the head and appends it; a failed write is cut off
+// again so the file never holds a torn line mid-chain.
+func (l *receiptLedger) write(cfg Config, e *LedgerEntry) ([]byte, error) {
+	e.Seq, e.Prev, e.TS = l.seq+1, l.head, time.Now().UTC().Format(time.RFC3339Nano)
+	e.Hash = e.digest()
+	if e.Kind == "root" && identity != nil {
+		sig, err := identity.sign(dssePAE(ledgerRootType, []byte(e.Hash)))
+		if err != nil {
+			identitySigned.WithLabelValues("ledger_root", "error").Inc()
+			fmt.Println("[identity] sign ledger root", e.Seq, err)
+		} else {
+			identitySigned.WithLabelValues("ledger_root", "ok").Inc()
+			e.Sig = &ResultSignature{Node: nodeID(cfg), KeyID: identityKeyID, Alg: identity.alg(), Digest: e.Hash, Sig: base64.StdEncoding.EncodeToString(sig)}
+		}
+	}
+	line, _ := json.Marshal(e)
+	line = append(line, '\n')
+	if _, err := l.f.Write(line); err != nil { l.f.Truncate(l.size); return nil, err }
+	if l.size == 0 { l.first = e.Seq }
+	l.size += int64(len(line))
+	l.apply(e, line)
+	return line, nil
+}
+
+// add books a finished run; rec is signed already when identity_key is set.
+func (l *receiptLedger) add(cfg Config, rec *RunRecord) {
+	receipt := ""
+	if rec.Signature != nil {
+		receipt = rec.Signature.Digest
+	} else if b, err := json.Marshal(rec); err == nil {
+		sum := sha256.Sum256(b)
+		receipt = hex.EncodeToString(sum[:])
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e := &LedgerEntry{Kind: "run", Run: rec.ID, Module: rec.Module, Tenant: rec.Tenant, Result: rec.Result, Receipt: receipt, ModPrev: l.heads[rec.Module]}
+	if _, err := l.write(cfg, e); err != nil {
+		ledgerTotal.WithLabelValues("run", "error").Inc()
+		fmt.Println("[ledger]", rec.ID, err, rec.corr())
+		return
+	}
+	ledgerTotal.WithLabelValues("run", "ok").Inc()
+}
+
+// seal roots the runs since the last root and publishes the root.
+func (l *receiptLedger) seal(cfg Config) {
+	l.mu.Lock()
+	if len(l.batch) == 0 { l.mu.Unlock(); return }
+	leaves := make([][]byte, len(l.batch))
+	for i, h := range l.batch { leaves[i], _ = hex.DecodeString(h) }
+	batch := l.lines
+	if cfg.LedgerSegmentMB > 0 && l.size >= int64(cfg.LedgerSegmentMB)<<20 {
+		if err := l.rotate(); err != nil { fmt.Println("[ledger] rotate:", err) }
+	}
+	e := &LedgerEntry{Kind: "root", From: l.seq - int64(len(leaves)) + 1, To: l.seq, Count: len(leaves), Root: hex.EncodeToString(merkleHash(leaves)), Heads: maps.Clone(l.heads)}
+	line, err := l.write(cfg, e)
+	if err == nil { err = l.f.Sync() }
+	l.mu.Unlock()
+	if err != nil {
+		ledgerTotal.WithLabelValues("root", "error").Inc()
+		fmt.Printf("[ledger] seal %d-%d: %v\n", e.From, e.To, err)
+		return
+	}
+	ledgerTotal.WithLabelValues("root", "ok").Inc()
+	meta := map[string]any{"node": nodeID(cfg), "seq": e.Seq, "hash": e.Hash, "prev": e.Prev, "from": e.From, "to": e.To, "count": e.Count, "root": e.Root, "ts": e.TS}
+	if e.Sig != nil { meta["key_id"], meta["alg"], meta["sig"] = e.Sig.KeyID, e.Sig.Alg, e.Sig.Sig }
+	if cfg.IPFSPublish != "" {
+		if cid, err := ipfsAdd(cfg, "ledger", fmt.Sprintf("ledger-%d-%d.ndjson", e.From, e.Seq), append(batch, line...)); err != nil {
+			fmt.Println("[ipfs] ledger", e.From, "-", e.Seq, "failed:", err)
+		} else {
+			meta["cid"] = cid
+		}
+	}
+	postEvent(cfg, map[string]any{"type": "ledger.root", "meta": meta})
+}
+
+// rotate moves ledger.ndjson aside; the caller writes the new file's first
+// line.
+func (l *receiptLedger) rotate() error {
+	cur := filepath.Join(l.dir, "ledger.ndjson")
+	l.f.Close()
+	err := os.Rename(cur, filepath.Join(l.dir, fmt.Sprintf("ledger-%012d.ndjson", l.first)))
+	f, ferr := os.OpenFile(cur, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if ferr != nil { return ferr }
+	l.f = f
+	if err == nil { l.size = 0 }
+	return err
+}
+
+func (l *receiptLedger) close(cfg Config) {
+	if l == nil { return }
+	l.seal(cfg)
+	l.mu.Lock()
+	l.f.Close()
+	l.mu.Unlock()
+}
+
+func ledgerLoop(cfg Config) {
+	if ledger == nil { return }
+	for !shuttingDown.Load() {
+		time.Sleep(cfg.LedgerRootEvery)
+		if !shuttingDown.Load() { ledger.seal(currentConfig()) }
+	}
+}
+
+// --- Merkle tree (RFC 6962: leaf 0x00||d, node 0x01||l||r) ---
+
+func merkleLeaf(d []byte) []byte { s := sha256.Sum256(append([]byte{0}, d...)); return s[:] }
+
+func merkleNode(l, r []byte) []byte {
+	s := sha256.Sum256(append(append([]byte{1}, l...), r...))
+	return s[:]
+}
+
+// merkleSplit is the largest power of two below n.
+func merkleSplit(n int) int {
+	k := 1
+	for k<<1 < n { k <<= 1 }
+	return k
+}
+
+func merkleHash(leaves [][]byte) []byte {
+	if len(leaves) == 1 { return merkleLeaf(leaves[0]) }
+	k := merkleSplit(len(leaves))
+	return merkleNode(merkleHash(leaves[:k]), merkleHash(leaves[k:]))
+}
+
+func merklePath(m int, leaves [][]byte) [][]byte {
+	if len(leaves) <= 1 { return nil }
+	k := merkleSplit(len(leaves))
+	if m < k { return append(merklePath(m, leaves[:k]), merkleHash(leaves[k:])) }
+	return append(merklePath(m-k, leaves[k:]), merkleHash(leaves[:k]))
+}
+
+// merkleVerify checks an inclusion proof (RFC 9162 2.1.3.2).
+func merkleVerify(m, n int, leaf []byte, path [][]byte, root []byte) bool {
+	if m < 0 || m >= n { return false }
+	fn, sn, r := m, n-1, merkleLeaf(leaf)
+	for _, p := range path {
+		if sn == 0 { return false }
+		if fn&1 == 1 || fn == sn {
+			r = merkleNode(p, r)
+			for fn&1 == 0 && fn != 0 { fn >>= 1; sn >>= 1 }
+		} else {
+			r = merkleNode(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(r, root)
+}
+
+// --- Reading the ledger back ---
+
+// ledgerFiles lists dir's segments oldest first, ledger.ndjson last.
+func ledgerFiles(dir string) []string {
+	files, _ := filepath.Glob(filepath.Join(dir, "ledger-*.ndjson"))
+	sort.Strings(files)
+	if _, err := os.Stat(filepath.Join(dir, "ledger.ndjson")); err == nil { files = append(files, filepath.Join(dir, "ledger.ndjson")) }
+	return files
+}
+
+var errLedgerStop = errors.New("stop")
+
+// scanLedger calls fn for every complete line of files in order; a torn
+// last line is skipped.
+func scanLedger(files []string, fn func(e *LedgerEntry) error) error {
+	for _, path := range files {
+		f, err := os.Open(path)
+		if err != nil { return err }
+		r := bufio.NewReader(f)
+		for n := 1; ; n++ {
+			line, err := r.ReadBytes('\n')
+			if err != nil { break }
+			e := &LedgerEntry{}
+			if err := json.Unmarshal(line, e); err != nil { f.Close(); return fmt.Errorf("%s:%d: %w", path, n, err) }
+			if err := fn(e); err != nil {
+				f.Close()
+				if err == errLedgerStop { return nil }
+				return fmt.Errorf("%s:%d: %w", path, n, err)
+			}
+		}
+		f.Close()
+	}
+	return nil
+}
+
+// ledgerProof finds run's line and the root that seals it.
+func ledgerProof(dir, run string) (*LedgerProof, error) {
+	var p *LedgerProof
+	var batch [][]byte
+	err := scanLedger(ledgerFiles(dir), func(e *LedgerEntry) error {
+		switch {
+		case e.Kind == "run":
+			h, _ := hex.DecodeString(e.Hash)
+			batch = append(batch, h)
+			if e.Run == run && p == nil { p = &LedgerProof{Entry: e, Index: len(batch) - 1} }
+		case p != nil:
+			p.Root = e
+			for _, h := range merklePath(p.Index, batch) { p.Path = append(p.Path, hex.EncodeToString(h)) }
+			return errLedgerStop
+		default:
+			batch = batch[:0]
+		}
+		return nil
+	})
+	return p, err
+}
+
+func handleLedger(mux *http.ServeMux, cfg Config) {
+	if ledger == nil { return }
+	mux.HandleFunc("GET /admin/ledger", func(w http.ResponseWriter, r *http.Request) {
+		ledger.mu.Lock()
+		defer ledger.mu.Unlock()
+		writeJSON(w, 200, map[string]any{"node": nodeID(cfg), "dir": ledger.dir, "seq": ledger.seq, "head": ledger.head, "pending": len(ledger.batch), "last_root": ledger.lastRoot, "modules": ledger.heads})
+	})
+	mux.HandleFunc("GET /admin/ledger/proof", func(w http.ResponseWriter, r *http.Request) {
+		run := r.URL.Query().Get("run")
+		if run == "" { writeJSON(w, 400, map[string]any{"error": "run required"}); return }
+		p, err := ledgerProof(ledger.dir, run)
+		switch {
+		case err != nil: writeJSON(w, 500, map[string]any{"error": err.Error()})
+		case p == nil: writeJSON(w, 404, map[string]any{"error": "run not in ledger"})
+		case p.Root == nil: writeJSON(w, 409, map[string]any{"error": "not sealed yet", "entry": p.Entry})
+		default: writeJSON(w, 200, p)
+		}
+	})
+}
+
+// ledgerRootOK checks a root line's signature.
+func ledgerRootOK(pub any, e *LedgerEntry) bool {
+	if e.Sig == nil { return false }
+	sig, err := base64.StdEncoding.DecodeString(e.Sig.Sig)
+	return err == nil && verifyNodeSig(pub, dssePAE(ledgerRootType, []byte(e.Hash)), sig)
+}
+
+// ledgerVerifyLocal: void-wasm-exec ledger-verify [--key pub.pem] <ledger_dir>
+// or ledger-verify --key pub.pem --proof proof.json
+func ledgerVerifyLocal(args []string) int {
+	fs := flag.NewFlagSet("ledger-verify", flag.ExitOnError)
+	keyPath := fs.String("key", "", "PEM public key (GET /identity/pubkey); roots must be signed by it")
+	proofPath := fs.String("proof", "", "check one proof (GET /admin/ledger/proof) instead of a ledger_dir")
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, "usage: void-wasm-exec ledger-verify [--key pub.pem] <ledger_dir>\n       void-wasm-exec ledger-verify --key pub.pem --proof proof.json"); fs.PrintDefaults() }
+	fs.Parse(args)
+	if (*proofPath == "") == (fs.NArg() != 1) || (*proofPath != "" && *keyPath == "") { fs.Usage(); return 2 }
+	fail := func(msg ...any) int { fmt.Fprintln(os.Stderr, append([]any{"ledger-verify:"}, msg...)...); return 1 }
+	var pub any
+	if *keyPath != "" {
+		kb, err := os.ReadFile(*keyPath)
+		if err != nil { return fail(err) }
+		blk, _ := pem.Decode(kb)
+		if blk == nil { return fail("no PEM block in", *keyPath) }
+		if pub, err = x509.ParsePKIXPublicKey(blk.Bytes); err != nil { return fail(err) }
+	}
+	if *proofPath != "" {
+		raw, err := os.ReadFile(*proofPath)
+		if err != nil { return fail(err) }
+		var p LedgerProof
+		if err := json.Unmarshal(raw, &p); err != nil || p.Entry == nil || p.Root == nil { return fail("not a ledger proof") }
+		e, root := p.Entry, p.Root
+		leaf, _ := hex.DecodeString(e.Hash)
+		want, _ := hex.DecodeString(root.Root)
+		var path [][]byte
+		for _, h := range p.Path {
+			b, err := hex.DecodeString(h)
+			if err != nil { return fail("bad path:", err) }
+			path = append(path, b)
+		}
+		switch {
+		case e.Hash != e.digest(): return fail("entry hash does not match its line")
+		case root.Hash != root.digest(): return fail("root hash does not match its line")
+		case e.Seq < root.From || e.Seq > root.To || int64(p.Index) != e.Seq-root.From: return fail("seq", e.Seq, "is not leaf", p.Index, "of", root.From, "-", root.To)
+		case !merkleVerify(p.Index, root.Count, leaf, path, want): return fail("audit path does not lead to root", root.Root)
+		case !ledgerRootOK(pub, root): return fail("root", root.Seq, "is not signed by", *keyPath)
+		}
+		fmt.Printf("run      %s module=%s tenant=%s result=%s\nreceipt  %s\nroot     %s seq=%d node=%s\nOK\n", e.Run, e.Module, e.Tenant, e.Result, e.Receipt, root.Root, root.Seq, root.Sig.Node)
+		return 0
+	}
+	files := ledgerFiles(fs.Arg(0))
+	if len(files) == 0 { return fail("no ledger files in", fs.Arg(0)) }
+	var seq, start int64
+	var head string
+	var batch [][]byte
+	var runs, roots int
+	heads, known := map[string]string{}, false
+	err := scanLedger(files, func(e *LedgerEntry) error {
+		if e.Hash != e.digest() { return fmt.Errorf("seq %d: hash does not match the line", e.Seq) }
+		if seq == 0 {
+			start, known = e.Seq, e.Seq == 1
+			if known && e.Prev != "" { return errors.New("seq 1: prev must be empty") }
+		} else if e.Seq != seq+1 || e.Prev != head {
+			return fmt.Errorf("seq %d: does not follow seq %d", e.Seq, seq)
+		}
+		seq, head = e.Seq, e.Hash
+		switch e.Kind {
+		case "run":
+			if known && e.ModPrev != heads[e.Module] { return fmt.Errorf("seq %d: module_prev breaks the %s chain", e.Seq, e.Module) }
+			heads[e.Module] = e.Hash
+			h, _ := hex.DecodeString(e.Hash)
+			batch = append(batch, h)
+			runs++
+		case "root":
+			if known {
+				if e.Count != len(batch) || e.To != e.Seq-1 || e.From != e.Seq-int64(len(batch)) { return fmt.Errorf("seq %d: root covers %d-%d, ledger has %d runs", e.Seq, e.From, e.To, len(batch)) }
+				if len(batch) > 0 && hex.EncodeToString(merkleHash(batch)) != e.Root { return fmt.Errorf("seq %d: merkle root does not match", e.Seq) }
+				if !maps.Equal(heads, e.Heads) { return fmt.Errorf("seq %d: heads do not match the module chains", e.Seq) }
+			}
+			if pub != nil && !ledgerRootOK(pub, e) { return fmt.Errorf("seq %d: root not signed by %s", e.Seq, *keyPath) }
+			heads, known, batch = maps.Clone(e.Heads), true, batch[:0]
+			if heads == nil { heads = map[string]string{} }
+			roots++
+		default:
+			return fmt.Errorf("seq %d: unknown kind %q", e.Seq, e.Kind)
+		}
+		return nil
+	})
+	if err != nil { return fail(err) }
+	if start > 1 { fmt.Println("note     starts at seq", start, "(older segments removed); checked from the first root on") }
+	fmt.Printf("entries  %d-%d runs=%d roots=%d modules=%d unsealed=%d\nhead     %s\nOK\n", start, seq, runs, roots, len(heads), len(batch), head)
+	return 0
+}
+
+func validLedger(c Config) error {
+	if c.LedgerDir == "" { return nil }
+	if c.LedgerRootEvery < 10*time.Second { return fmt.Errorf("ledger_root_every: must be >= 10s, got %s", c.LedgerRootEvery) }
+	if c.LedgerSegmentMB < 0 { return errors.New("ledger_segment_mb: must be >= 0") }
+	return nil
+}
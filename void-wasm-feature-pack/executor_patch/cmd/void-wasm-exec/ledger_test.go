@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The leaves and roots of the certificate-transparency reference tests
+// (RFC 6962 2.1, RFC 9162 2.1.1).
+var rfc6962Leaves = []string{"", "00", "10", "2021", "3031", "40414243", "5051525354555657", "606162636465666768696a6b6c6d6e6f"}
+
+var rfc6962Roots = []string{
+	"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+	"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+	"aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77",
+	"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+	"4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4",
+	"76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef",
+	"ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c",
+	"5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+}
+
+func TestMerkleRFC6962(t *testing.T) {
+	var leaves [][]byte
+	for _, h := range rfc6962Leaves {
+		b, _ := hex.DecodeString(h)
+		leaves = append(leaves, b)
+	}
+	for n := 1; n <= len(leaves); n++ {
+		root := merkleHash(leaves[:n])
+		if got := hex.EncodeToString(root); got != rfc6962Roots[n-1] { t.Errorf("n=%d: root %s, want %s", n, got, rfc6962Roots[n-1]) }
+		for m := 0; m < n; m++ {
+			path := merklePath(m, leaves[:n])
+			if !merkleVerify(m, n, leaves[m], path, root) { t.Errorf("n=%d m=%d: proof does not verify", n, m); continue }
+			if merkleVerify(m, n, []byte("x"), path, root) { t.Errorf("n=%d m=%d: wrong leaf verifies", n, m) }
+			if n > 1 && merkleVerify((m+1)%n, n, leaves[m], path, root) { t.Errorf("n=%d m=%d: wrong index verifies", n, m) }
+			if merkleVerify(n, n, leaves[m], path, root) || merkleVerify(-1, n, leaves[m], path, root) { t.Errorf("n=%d m=%d: index out of the tree verifies", n, m) }
+			if len(path) > 0 {
+				bad := append([][]byte{}, path...)
+				bad[0] = merkleLeaf([]byte("x"))
+				if merkleVerify(m, n, leaves[m], bad, root) { t.Errorf("n=%d m=%d: tampered path verifies", n, m) }
+				if merkleVerify(m, n, leaves[m], path[:len(path)-1], root) { t.Errorf("n=%d m=%d: short path verifies", n, m) }
+			}
+			if merkleVerify(m, n, leaves[m], append(path, root), root) { t.Errorf("n=%d m=%d: long path verifies", n, m) }
+		}
+	}
+}
+
+// testLedger books runs into a fresh ledger_dir, sealing after 3 and after
+// the rest, and returns the dir and its lines.
+func testLedger(t *testing.T, runs int) (string, []LedgerEntry) {
+	t.Helper()
+	saved := localSink
+	localSink = func(map[string]any) {}
+	t.Cleanup(func() { localSink = saved })
+	dir := t.TempDir()
+	l, err := openLedger(dir)
+	if err != nil { t.Fatal(err) }
+	cfg := Config{NodeID: "node-a"}
+	for i := 0; i < runs; i++ {
+		l.add(cfg, &RunRecord{ID: fmt.Sprintf("r%d", i), Module: []string{"demo/a", "demo/b"}[i%2], Result: "ok"})
+		if i == 2 { l.seal(cfg) }
+	}
+	l.close(cfg)
+	var lines []LedgerEntry
+	if err := scanLedger(ledgerFiles(dir), func(e *LedgerEntry) error { lines = append(lines, *e); return nil }); err != nil { t.Fatal(err) }
+	return dir, lines
+}
+
+func TestLedgerProofs(t *testing.T) {
+	dir, _ := testLedger(t, 8)
+	for i := 0; i < 8; i++ {
+		p, err := ledgerProof(dir, fmt.Sprintf("r%d", i))
+		if err != nil || p == nil || p.Root == nil { t.Fatalf("r%d: proof %+v, %v", i, p, err) }
+		leaf, _ := hex.DecodeString(p.Entry.Hash)
+		root, _ := hex.DecodeString(p.Root.Root)
+		var path [][]byte
+		for _, h := range p.Path {
+			b, _ := hex.DecodeString(h)
+			path = append(path, b)
+		}
+		if !merkleVerify(p.Index, p.Root.Count, leaf, path, root) { t.Errorf("r%d: proof does not verify against root %d", i, p.Root.Seq) }
+	}
+}
+
+func TestLedgerTampered(t *testing.T) {
+	dir, lines := testLedger(t, 8)
+	if got := ledgerVerifyLocal([]string{dir}); got != 0 { t.Fatalf("ledger-verify on the untouched ledger = %d", got) }
+	// rechain re-hashes lines from i on, as a forger who can rewrite the file would.
+	rechain := func(ls []LedgerEntry, i int) {
+		for ; i < len(ls); i++ {
+			if i > 0 { ls[i].Prev = ls[i-1].Hash }
+			ls[i].Hash = ls[i].digest()
+		}
+	}
+	for _, tc := range []struct {
+		name    string
+		edit    func(ls []LedgerEntry) []LedgerEntry
+		opens   bool // openLedger only follows the chain, not the roots
+	}{
+		{"edited result", func(ls []LedgerEntry) []LedgerEntry { ls[1].Result = "error"; return ls }, false},
+		{"edited and rehashed", func(ls []LedgerEntry) []LedgerEntry { ls[1].Result = "error"; ls[1].Hash = ls[1].digest(); return ls }, false},
+		{"dropped line", func(ls []LedgerEntry) []LedgerEntry { return append(ls[:2], ls[3:]...) }, false},
+		{"swapped lines", func(ls []LedgerEntry) []LedgerEntry { ls[0], ls[1] = ls[1], ls[0]; return ls }, false},
+		{"rechained under a root", func(ls []LedgerEntry) []LedgerEntry { ls[1].Result = "error"; rechain(ls, 1); return ls }, true},
+		{"module chain cut", func(ls []LedgerEntry) []LedgerEntry { ls[2].ModPrev = ""; rechain(ls, 2); return ls }, true},
+		{"root over fewer runs", func(ls []LedgerEntry) []LedgerEntry { ls[3].Count, ls[3].From = 2, 2; rechain(ls, 3); return ls }, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ls := tc.edit(append([]LedgerEntry{}, lines...))
+			var buf bytes.Buffer
+			for _, e := range ls {
+				b, _ := json.Marshal(e)
+				buf.Write(append(b, '\n'))
+			}
+			d := t.TempDir()
+			if err := os.WriteFile(filepath.Join(d, "ledger.ndjson"), buf.Bytes(), 0o600); err != nil { t.Fatal(err) }
+			if got := ledgerVerifyLocal([]string{d}); got != 1 { t.Errorf("ledger-verify = %d, want 1", got) }
+			l, err := openLedger(d)
+			if (err == nil) != tc.opens { t.Errorf("openLedger: %v, want opens=%v", err, tc.opens) }
+			if l != nil { l.f.Close() }
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Direct log shipping (Loki push API or OTLP/HTTP JSON logs) ---
+// stdout is teed: every line still goes to the console, and a copy is batched
+// to the sink with labels node, component ("[wasm]" -> wasm) and, when the line
+// carries module=/result= tokens, module and result.
+
+var (
+	logsShipped = prometheus.NewCounter(prometheus.CounterOpts{Name: "void_wasm_logs_shipped_total", Help: "Log lines shipped to the log sink"})
+	logsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_logs_dropped_total", Help: "Log lines dropped by reason"}, []string{"reason"})
+)
+
+type logLine struct {
+	ts     time.Time
+	labels map[string]string
+	line   string
+}
+
+func startLogShipping(cfg Config) {
+	if cfg.LogSink == "" { return }
+	if cfg.LogSink != "loki" && cfg.LogSink != "otlp" { fmt.Println("[logs] unknown LOG_SINK", cfg.LogSink); return }
+	r, w, err := os.Pipe()
+	if err != nil { fmt.Println("[logs] pipe:", err); return }
+	console := os.Stdout
+	os.Stdout = w
+	q := make(chan logLine, 5000)
+	node := nodeID(cfg)
+	go func() {
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 64*1024), 1<<20)
+		for sc.Scan() {
+			line := sc.Text()
+			fmt.Fprintln(console, line)
+			select {
+			case q <- logLine{ts: time.Now(), labels: logLabels(node, line), line: line}:
+			default:
+				logsDropped.WithLabelValues("queue_full").Inc()
+			}
+		}
+	}()
+	go shipLogs(cfg, q)
+	fmt.Println("[logs] shipping to", cfg.LogSink, cfg.LogURL)
+}
+
+func logLabels(node, line string) map[string]string {
+	l := map[string]string{"node": node, "app": "void-wasm-exec"}
+	if strings.HasPrefix(line, "[") {
+		if i := strings.Index(line, "]"); i > 1 { l["component"] = line[1:i] }
+	}
+	for _, tok := range strings.Fields(line) {
+		if k, v, ok := strings.Cut(tok, "="); ok && (k == "module" || k == "result") { l[k] = v }
+	}
+	return l
+}
+
+func shipLogs(cfg Config, q chan logLine) {
+	batch := []logLine{}
+	tick := time.NewTicker(cfg.LogFlush)
+	defer tick.Stop()
+	flush := func() {
+		if len(batch) == 0 { return }
+		var body []byte
+		if cfg.LogSink == "loki" { body = lokiPayload(batch) } else { body = otlpPayload(batch) }
+		req, _ := http.NewRequest("POST", cfg.LogURL, bytes.NewReader(body))
+		req.Header.Set("content-type", "application/json")
+		resp, err := logClient.Do(req)
+		if err == nil { resp.Body.Close() }
+		if err != nil || resp.StatusCode >= 300 {
+			logsDropped.WithLabelValues("push_failed").Add(float64(len(batch)))
+		} else {
+			logsShipped.Add(float64(len(batch)))
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case ll := <-q:
+			batch = append(batch, ll)
+			if len(batch) >= cfg.LogBatch { flush() }
+		case <-tick.C:
+			flush()
+		}
+	}
+}
+
+func labelKey(l map[string]string) string {
+	keys := make([]string, 0, len(l))
+	for k := range l { keys = append(keys, k) }
+	sort.Strings(keys)
+	var sb strings.Builder
+	for _, k := range keys { sb.WriteString(k + "=" + l[k] + ",") }
+	return sb.String()
+}
+
+// lokiPayload groups lines into one stream per label set (POST /loki/api/v1/push).
+func lokiPayload(batch []logLine) []byte {
+	type stream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	idx := map[string]*stream{}
+	order := []string{}
+	for _, ll := range batch {
+		k := labelKey(ll.labels)
+		s, ok := idx[k]
+		if !ok { s = &stream{Stream: ll.labels}; idx[k] = s; order = append(order, k) }
+		s.Values = append(s.Values, [2]string{strconv.FormatInt(ll.ts.UnixNano(), 10), ll.line})
+	}
+	streams := make([]*stream, 0, len(order))
+	for _, k := range order { streams = append(streams, idx[k]) }
+	b, _ := json.Marshal(map[string]any{"streams": streams})
+	return b
+}
+
+// otlpPayload encodes an OTLP/HTTP JSON ExportLogsServiceRequest (POST /v1/logs).
+func otlpPayload(batch []logLine) []byte {
+	attr := func(k, v string) map[string]any { return map[string]any{"key": k, "value": map[string]any{"stringValue": v}} }
+	records := make([]map[string]any, 0, len(batch))
+	for _, ll := range batch {
+		attrs := []map[string]any{}
+		for k, v := range ll.labels {
+			if k == "node" || k == "app" { continue }
+			attrs = append(attrs, attr(k, v))
+		}
+		records = append(records, map[string]any{
+			"timeUnixNano": strconv.FormatInt(ll.ts.UnixNano(), 10),
+			"body":         map[string]any{"stringValue": ll.line},
+			"attributes":   attrs,
+		})
+	}
+	node := ""
+	if len(batch) > 0 { node = batch[0].labels["node"] }
+	b, _ := json.Marshal(map[string]any{"resourceLogs": []any{map[string]any{
+		"resource":  map[string]any{"attributes": []any{attr("service.name", "void-wasm-exec"), attr("host.name", node)}},
+		"scopeLogs": []any{map[string]any{"scope": map[string]any{"name": "void-wasm-exec"}, "logRecords": records}},
+	}}})
+	return b
+}
@@ -11,7 +11,6 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -23,8 +22,6 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/tetratelabs/wazero"
-	"github.com/tetratelabs/wazero/api"
-	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
 )
 
 // Envelope received from relay
@@ -34,42 +31,29 @@ type Envelope struct {
 	CID    string                 `json:"cid,omitempty"`
 	URL    string                 `json:"url,omitempty"`
 	Module string                 `json:"module,omitempty"`
+	Version string                `json:"version,omitempty"` // semver constraint, resolved by the registry (registry.go)
 	Entry  string                 `json:"entry,omitempty"`
 	Inputs map[string]any         `json:"inputs,omitempty"`
 	Caps   []string               `json:"caps,omitempty"`
 	Limits map[string]any         `json:"limits,omitempty"`
 	Policy map[string]any         `json:"policy,omitempty"`
 	Meta   map[string]any         `json:"meta,omitempty"`
-}
+	Tenant string                 `json:"tenant,omitempty"`
+	Mounts []MountReq             `json:"mounts,omitempty"`
+	Env    map[string]string      `json:"env,omitempty"` // name -> "" (configured value) or an override
+
+	Placement []string `json:"placement,omitempty"` // key=value tags matched against node_labels (placement.go)
+	Budget    *RunBudget `json:"budget,omitempty"`  // per-run resource budget (budget.go)
+	Variants  []ModuleVariant `json:"variants,omitempty"` // per-target builds (targets.go)
+	WS        string `json:"ws,omitempty"` // ws_endpoints name for a reactor module (ws.go)
 
-// Config via env/flags
-type Config struct {
-	RelayBase    string
-	SSEPath      string
-	EventPost    string
-	IPFSGateway  string
-	CacheDir     string
-	PromAddr     string
-	Concurrency  int
-	DefaultTO    time.Duration
-	MaxMemMB     uint32
-
-	AllowModules []string
-	AllowCaps    []string
-
-	AllowHTTPHosts []string
-	HTTPBurst      int
-	HTTPRPS        int
-	MaxHTTPKB      int
-
-	CosignVerify bool
-	DryRun       bool
+	intent string // request id when posted to /intent/execute-wasm (intent.go)
 }
 
 var (
 	reg            = prometheus.NewRegistry()
 	runsTotal      = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_runs_total", Help: "WASM runs by result"}, []string{"result", "module"})
-	runDuration    = prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "void_wasm_duration_ms", Help: "Run duration ms", Buckets: []float64{50,100,200,400,800,1500,3000,6000,12000}}, []string{"module"})
+	runDuration    = newRunDuration(false)
 	cacheHitTotal  = prometheus.NewCounter(prometheus.CounterOpts{Name: "void_wasm_cache_hit_total", Help: "Cache hits"})
 	downloadMs     = prometheus.NewHistogram(prometheus.HistogramOpts{Name: "void_wasm_download_ms", Help: "Download ms", Buckets: []float64{5,10,20,50,100,200,400,800,1500}})
 	policyDenied   = prometheus.NewCounter(prometheus.CounterOpts{Name: "void_wasm_policy_denied_total", Help: "Policy denies"})
@@ -83,6 +67,20 @@ var (
 
 func mustRegister() {
 	reg.MustRegister(runsTotal, runDuration, cacheHitTotal, downloadMs, policyDenied, stdoutEvents, activeGauge, sseReconnects, downloadsTotal, sysReqTotal, sysDur)
+	reg.MustRegister(intakeSkipped, pausedGauge, drainRemaining)
+	reg.MustRegister(eventsPosted, eventsDropped, eventsSpilled, eventRetries, eventQueueLen)
+	reg.MustRegister(logsShipped, logsDropped)
+	reg.MustRegister(sloBurn, sloP95, sloBreach)
+	reg.MustRegister(pathRuns, pathDuration)
+	reg.MustRegister(leaderGauge, shardSkipped)
+	reg.MustRegister(tenantRuns, quotaExceeded, chaosInjected)
+	reg.MustRegister(maintGauge, envelopesInvalid)
+	reg.MustRegister(httpConnsOpened, httpConnsReused, httpConnsOpen)
+	reg.MustRegister(runtimesCreated, modLRUTotal, modLRUBytes, modLRUEntries)
+	reg.MustRegister(stageWaitMs, stageMs, groupRunning, groupWaiting, groupWaitMs)
+	reg.MustRegister(concLimitGauge, concAdjust)
+	reg.MustRegister(stageQueues()...)
+	reg.MustRegister(outputLimited, eventBytes, artifactsTotal, artifactBytes, ipfsAdds, webhooksTotal, natsPublished, attestationsTotal, mountsTotal, guestEnvTotal, eventSchemaTotal, softTimeouts, modulePolicies, scheduleTotal, placementSkipped, kvWatchTotal, sharedCacheTotal, intentsTotal, streamEvents, streamSubs, allowUpdates, dnsLookups, dnsMs, dnsEntries, httpCacheTotal, httpCacheEntries, budgetExhausted, usageTotal, runCost, cacheEvictions, variantSelected, moduleDecrypts, inputRefsTotal, inputRefBytes, identitySigned, githubTotal, githubProvenance, grafanaAnnotations, intakeLimited, intakeDeferred, sandboxLayers, corpusTotal, recordPruned, phaseMs, registryResolves, registryIndexVer, moduleUpdates, fairQueued, fairWaitMs, fairStarved, fairServed, queueOps, queueDepth, envGetTotal, randomBytes, graphqlTotal, aiTotal, aiTokens, aiCost, wsFrames, wsSessions, registerTotal, registered, ledgerTotal, ledgerSeq)
 }
 
 // naive allow matcher with '*' suffix support
@@ -99,76 +97,124 @@ func allowed(needle string, allow []string) bool {
 	return false
 }
 
-func getenv(key, def string) string { v := os.Getenv(key); if v == "" { return def }; return v }
-
-func loadConfig() Config {
-	parseList := func(s string) []string {
-		out := []string{}
-		for _, p := range strings.Split(s, ",") {
-			p = strings.TrimSpace(p)
-			if p != "" { out = append(out, p) }
-		}
-		return out
-	}
-	atoi := func(s string, d int) int { var n int; if _,err:=fmt.Sscanf(s,"%d",&n); err!=nil { return d }; return n }
-
-	cfg := Config{
-		RelayBase:     strings.TrimRight(getenv("RELAY_BASE", "http://localhost:8787"), "/"),
-		SSEPath:       getenv("SSE_PATH", "/sse"),
-		EventPost:     getenv("EVENT_POST", "/event"),
-		IPFSGateway:   strings.TrimRight(getenv("IPFS_GATEWAY", "https://ipfs.io"), "/"),
-		CacheDir:      getenv("CACHE_DIR", "/tmp/void/wasm-cache"),
-		PromAddr:      getenv("PROM_ADDR", ":9490"),
-		Concurrency:   atoi(getenv("CONCURRENCY", "1"), 1),
-		DefaultTO:     time.Duration(atoi(getenv("TIMEOUT_MS", "2000"), 2000)) * time.Millisecond,
-		MaxMemMB:      uint32(atoi(getenv("MEM_MB", "128"), 128)),
-		AllowModules:  parseList(getenv("ALLOW_MODULES", "wasm/ci/*,wasm/pulse/*")),
-		AllowCaps:     parseList(getenv("ALLOW_CAPS", "emit")),
-		AllowHTTPHosts: parseList(getenv("ALLOW_HTTP_HOSTS", "relay,localhost")),
-		HTTPBurst:     atoi(getenv("HTTP_BURST", "5"), 5),
-		HTTPRPS:       atoi(getenv("HTTP_RPS", "5"), 5),
-		MaxHTTPKB:     atoi(getenv("HTTP_MAX_KB", "64"), 64),
-		CosignVerify:  getenv("COSIGN_VERIFY", "0") == "1",
-		DryRun:        getenv("WASM_DRYRUN", "0") == "1",
-	}
-	return cfg
-}
-
 func main() {
-	mustRegister()
-	cfg := loadConfig()
+	if len(os.Args) > 1 && os.Args[1] == "run" { os.Exit(runLocal(os.Args[2:])) }
+	if len(os.Args) > 1 && os.Args[1] == "replay" { os.Exit(replayLocal(os.Args[2:])) }
+	if len(os.Args) > 1 && os.Args[1] == "inspect" { os.Exit(inspectLocal(os.Args[2:])) }
+	if len(os.Args) > 1 && os.Args[1] == "bench" { os.Exit(benchLocal(os.Args[2:])) }
+	if len(os.Args) > 1 && os.Args[1] == "conform" { os.Exit(conformLocal(os.Args[2:])) }
+	if len(os.Args) > 1 && os.Args[1] == "attest-verify" { os.Exit(attestVerifyLocal(os.Args[2:])) }
+	if len(os.Args) > 1 && os.Args[1] == "receipt-verify" { os.Exit(receiptVerifyLocal(os.Args[2:])) }
+	if len(os.Args) > 1 && os.Args[1] == "run-token-verify" { os.Exit(runTokenVerifyLocal(os.Args[2:])) }
+	if len(os.Args) > 1 && os.Args[1] == "ledger-verify" { os.Exit(ledgerVerifyLocal(os.Args[2:])) }
+	if len(os.Args) > 1 && os.Args[1] == "manifest" { os.Exit(manifestLocal(os.Args[2:])) }
+	if len(os.Args) > 1 && os.Args[1] == "module-seal" { os.Exit(moduleSealLocal(os.Args[2:])) }
+	if len(os.Args) > 1 && os.Args[1] == "kv" { os.Exit(kvLocal(os.Args[2:])) }
+	if len(os.Args) > 1 && os.Args[1] == "service" { os.Exit(serviceLocal(os.Args[2:])) }
+	serve(os.Args[1:])
+}
 
+// serve runs the executor until shutdown; args are its flags.
+func serve(args []string) {
 	// Flags still allowed for local runs
-	flag.StringVar(&cfg.PromAddr, "prom", cfg.PromAddr, "metrics addr")
-	flag.Parse()
+	flag.StringVar(&configPath, "config", getenv("CONFIG_FILE", ""), "YAML config file")
+	promAddr := flag.String("prom", "", "metrics addr (overrides config)")
+	allowRoot := flag.Bool("allow-root", false, "run as root (overrides config)")
+	flag.CommandLine.Parse(args)
+	cfg, err := loadConfig(configPath)
+	if err != nil { fmt.Println("[config] invalid:", err); os.Exit(1) }
+	if *promAddr != "" { cfg.PromAddr = *promAddr }
+	if *allowRoot { cfg.AllowRoot = true }
+	if err := checkRoot(cfg); err != nil { fmt.Println("[harden]", err); os.Exit(1) }
+	loadAllowOverride(cfg)
+	applyAllowOverride(&cfg)
+	liveCfg.Store(&cfg)
+	if cfg.Chaos { fmt.Println("[chaos] fault injection ENABLED - test only") }
+	if cfg.NativeHistograms { runDuration, phaseMs = newRunDuration(true), newPhaseMs(true) }
+	if cfg.QuotaWindow > 0 { runCost = newRunCost(cfg.QuotaWindow) }
+	initHTTPClients(cfg)
+	mustRegister()
+	registerBuildInfo()
+	startLogShipping(cfg)
+	go watchSIGHUP()
+	if cfg.PoliciesDir != "" { go watchPolicies(cfg.PoliciesDir) }
 
 	// /metrics server
 	go func() {
 		mux := http.NewServeMux()
-		mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+		mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+		mux.HandleFunc("GET /schema/{name}", handleSchema)
+		mux.HandleFunc("GET /attest/pubkey", handleAttestKey)
+		mux.HandleFunc("GET /identity/pubkey", handleIdentityKey)
 		mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(200); w.Write([]byte("{\"ok\":true}")) })
+		mux.HandleFunc("/readyz", handleReady)
 		http.ListenAndServe(cfg.PromAddr, mux)
 	}()
 
 	// ensure cache dir
 	os.MkdirAll(cfg.CacheDir, 0o755)
-
-	// SSE loop
+	if cfg.Attest != "" {
+		if err := loadAttestKey(cfg); err != nil { fmt.Println("[attest] key:", err); os.Exit(1) }
+	}
+	if cfg.IdentityKey != "" {
+		if err := loadIdentity(cfg); err != nil { fmt.Println("[identity] key:", err); os.Exit(1) }
+	}
+	if cfg.AtRestKey != "" {
+		if atRest, err = loadAtRestKey(cfg.AtRestKey); err != nil { fmt.Println("[atrest] key:", err); os.Exit(1) }
+		fmt.Println("[atrest] sealing KV and module cache")
+	}
+	if len(cfg.ModuleKeys) > 0 {
+		if err := loadModuleKeys(cfg.ModuleKeys); err != nil { fmt.Println("[modenc] module_keys:", err); os.Exit(1) }
+		fmt.Println("[modenc] module keys:", len(cfg.ModuleKeys))
+	}
+	if kvBackend, err = openKV(cfg, cfg.KVBackend); err != nil { fmt.Println("[kv] backend:", err); os.Exit(1) }
+	if cfg.KVBackend != "file" { fmt.Println("[kv] backend", cfg.KVBackend) }
+	if n, ok := kvBackend.(*natsKV); ok { go n.watch() }
+	if queues, err = openQueue(cfg); err != nil { fmt.Println("[queue] backend:", err); os.Exit(1) }
+	if queues != nil { fmt.Println("[queue] backend", cfg.QueueBackend) }
+	startEventPipeline(cfg)
+	startPipeline(cfg)
+	startAdaptive(cfg)
+	startLeaderElection(cfg)
+
+	// run history + admin server
+	h, err := openHistory(cfg.HistoryDB, cfg.HistoryRetention)
+	if err != nil { fmt.Println("[history] disabled:", err) }
+	history = h
+	if ledger, err = openLedger(cfg.LedgerDir); err != nil { fmt.Println("[ledger]", err); os.Exit(1) }
+	startAdmin(cfg)
+	startIntent(cfg)
+	startGitHub(cfg)
+	if err := hardenSelf(cfg); err != nil { fmt.Println("[harden]", err); os.Exit(1) }
+	go heartbeatLoop(cfg)
+	go registerLoop(cfg)
+	go ledgerLoop(cfg)
+	go sloLoop(cfg)
+	go usageReportLoop(cfg)
+	go maintenanceLoop(cfg)
+
+	// SSE loop until shutdown
+	intakeCtx, stopIntake := context.WithCancel(context.Background())
 	sseURL := cfg.RelayBase + cfg.SSEPath
-	fmt.Println("[wasm] SSE connect", sseURL)
-	for {
-		if err := sseLoop(cfg, sseURL); err != nil {
-			fmt.Println("[wasm] SSE error:", err)
-			sseReconnects.Inc()
-			time.Sleep(2 * time.Second)
-			continue
+	go func() {
+		if !isLeader.Load() { fmt.Println("[leader] standby, waiting for", cfg.LeaderLock) }
+		if !waitLeader(intakeCtx) { return }
+		fmt.Println("[wasm] SSE connect", sseURL)
+		for intakeCtx.Err() == nil {
+			if err := sseLoop(intakeCtx, cfg, sseURL); err != nil && intakeCtx.Err() == nil {
+				fmt.Println("[wasm] SSE error:", err)
+				sseReconnects.Inc()
+				time.Sleep(2 * time.Second)
+				continue
+			}
 		}
-	}
+	}()
+	waitForShutdown(cfg, stopIntake)
 }
 
-func sseLoop(cfg Config, sseURL string) error {
-	req, _ := http.NewRequest("GET", sseURL, nil)
-	resp, err := http.DefaultClient.Do(req)
+func sseLoop(ctx context.Context, cfg Config, sseURL string) error {
+	req, _ := http.NewRequestWithContext(ctx, "GET", sseURL, nil)
+	resp, err := sseClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -182,62 +228,53 @@ func sseLoop(cfg Config, sseURL string) error {
 		if err != nil {
 			return err
 		}
-		if !strings.HasPrefix(line, "data:") { continue }
-		payload := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
-		if payload == "" || payload == ":" { continue }
-		var env Envelope
-		if err := json.Unmarshal([]byte(payload), &env); err != nil { continue }
-		if env.Type != "signal.wasm" { continue }
-		go handleEnvelope(cfg, &env)
+		payload, ok := sseData(line)
+		if !ok || controlEvent(payload) || !wasmSignal(payload) { continue }
+		env, err := parseEnvelope([]byte(payload), currentConfig().StrictEnvelopes)
+		if err != nil {
+			envelopesInvalid.WithLabelValues(reasonOf(err)).Inc()
+			fmt.Println("[wasm] invalid envelope:", err)
+			continue
+		}
+		admitEnvelope(&env)
 	}
 }
 
-var sem = make(chan struct{}, 1) // concurrency limit
-
-func handleEnvelope(cfg Config, env *Envelope) {
-	sem <- struct{}{}; defer func(){ <-sem }()
-
-	moduleName := env.Module
-	if moduleName == "" { moduleName = "unknown" }
-	if !allowed(moduleName, cfg.AllowModules) {
-		fmt.Println("[policy] deny module", moduleName)
-		policyDenied.Inc()
-		return
-	}
-	path, err := fetchModule(cfg, env)
-	if err != nil {
-		fmt.Println("[wasm] fetch error:", err)
-		runsTotal.WithLabelValues("download_error", moduleName).Inc()
-		return
-	}
-	if cfg.DryRun {
-		fmt.Println("[wasm] DRYRUN would run", moduleName, "from", path)
-		runsTotal.WithLabelValues("dryrun", moduleName).Inc()
-		return
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), cfg.DefaultTO)
-	defer cancel()
-	activeGauge.Inc()
-	defer activeGauge.Dec()
-
-	start := time.Now()
-	err = runWasm(ctx, cfg, path, env)
-	runDuration.WithLabelValues(moduleName).Observe(float64(time.Since(start).Milliseconds()))
-	if err != nil {
-		fmt.Println("[wasm] run error:", err)
-		runsTotal.WithLabelValues("error", moduleName).Inc()
-		return
-	}
-	runsTotal.WithLabelValues("ok", moduleName).Inc()
+// admitEnvelope applies the intake gates shared by SSE and POST /admin/envelopes.
+func admitEnvelope(env *Envelope) string {
+	corpusExport(currentConfig(), env)
+	if intakePaused.Load() || maintBlocks(env) { intakeSkipped.Inc(); return "paused" }
+	cfg := currentConfig()
+	if len(unplaced(cfg, env)) > 0 { placementSkipped.Inc(); return "not_placed" }
+	if !ownsEnvelope(cfg, env) { shardSkipped.Inc(); return "not_owner" }
+	wait, ok := intakeTake(cfg, env)
+	if !ok { return "rate_limited" }
+	if !enqueue(cfg, env, wait) { return "scheduled_full" }
+	return "queued"
 }
 
-func fetchModule(cfg Config, env *Envelope) (string, error) {
+// fetchModule returns the module's cache path and how many bytes it pulled
+// from outside cache_dir (0 on a local hit).
+func fetchModule(cfg Config, env *Envelope, ph *runPhases) (string, int64, error) {
+	if chaosHit(cfg, "download", cfg.ChaosDownload) { return "", 0, chaosErr("download error") }
+	tv := time.Now()
+	env, err := githubSource(cfg, env)
+	ph.since("verify", tv)
+	if err != nil { return "", 0, err }
 	filename := env.SHA256
 	if filename == "" { filename = strings.ReplaceAll(env.Module, "/", "_") }
 	cached := filepath.Join(cfg.CacheDir, filename + ".wasm")
 	if st, err := os.Stat(cached); err == nil && st.Size() > 0 {
-		cacheHitTotal.Inc(); return cached, nil
+		cacheHitTotal.Inc(); return cached, 0, nil
+	}
+	shared := cfg.SharedCache != "" && env.SHA256 != ""
+	if shared {
+		if data := sharedCacheGet(cfg, env.SHA256); data != nil {
+			os.MkdirAll(cfg.CacheDir, 0o755)
+			if err := writeFileAtomic(cached, sealAtRest(data, moduleAAD(cached)), 0o644); err != nil { return "", 0, err }
+			enforceCacheQuota(cfg.CacheDir, cfg.CacheMaxMB, cached)
+			return cached, int64(len(data)), nil
+		}
 	}
 	var src string
 	if env.URL != "" {
@@ -246,38 +283,39 @@ func fetchModule(cfg Config, env *Envelope) (string, error) {
 		cid := strings.TrimPrefix(env.CID, "ipfs://")
 		src = cfg.IPFSGateway + "/ipfs/" + cid
 	} else {
-		return "", errors.New("no url/cid provided")
+		return "", 0, errors.New("no url/cid provided")
 	}
 	downloadsTotal.Inc()
 	t0 := time.Now()
-	resp, err := http.Get(src)
-	if err != nil { return "", err }
+	resp, err := fetchURL(cfg, src)
+	if err != nil { return "", 0, err }
 	defer resp.Body.Close()
-	if resp.StatusCode != 200 { return "", fmt.Errorf("download status %d", resp.StatusCode) }
-	data, err := io.ReadAll(resp.Body); if err != nil { return "", err }
+	if resp.StatusCode != 200 { return "", 0, fmt.Errorf("download status %d", resp.StatusCode) }
+	data, err := io.ReadAll(resp.Body); if err != nil { return "", 0, err }
 	downloadMs.Observe(float64(time.Since(t0).Milliseconds()))
 	if env.SHA256 != "" {
+		tv := time.Now()
 		sum := sha256.Sum256(data)
-		if strings.ToLower(env.SHA256) != hex.EncodeToString(sum[:]) { return "", errors.New("sha256 mismatch") }
+		ph.since("verify", tv)
+		if strings.ToLower(env.SHA256) != hex.EncodeToString(sum[:]) { return "", 0, errors.New("sha256 mismatch") }
 	}
-	if err := os.WriteFile(cached, data, 0o644); err != nil { return "", err }
-	return cached, nil
+	os.MkdirAll(cfg.CacheDir, 0o755)
+	if err := writeFileAtomic(cached, sealAtRest(data, moduleAAD(cached)), 0o644); err != nil { return "", 0, err }
+	enforceCacheQuota(cfg.CacheDir, cfg.CacheMaxMB, cached)
+	if shared { go sharedCachePut(cfg, env.SHA256, data) }
+	return cached, int64(len(data)), nil
 }
 
-// --- KV simple file store ---
+// --- KV store (backend in kvstore.go) ---
 var kvMu sync.Mutex
-var kvPath = "/tmp/void/kv.json"
-func kvLoad() map[string]any {
+var kvPath = voidPath("kv.json")
+func kvLoad(tenant string) (map[string]any, error) {
 	kvMu.Lock(); defer kvMu.Unlock()
-	m := map[string]any{}
-	b, err := os.ReadFile(kvPath)
-	if err == nil { _ = json.Unmarshal(b, &m) }
-	return m
+	return kvBackend.load(tenant)
 }
-func kvSave(m map[string]any) error {
+func kvSave(tenant string, m map[string]any) error {
 	kvMu.Lock(); defer kvMu.Unlock()
-	b, _ := json.Marshal(m)
-	return os.WriteFile(kvPath, b, 0o600)
+	return kvBackend.save(tenant, m)
 }
 
 // --- HTTP allowlist ---
@@ -293,89 +331,260 @@ func hostAllowed(u *url.URL, hosts []string) bool {
 	return false
 }
 
-// --- Run WASM and handle syscalls ---
-func runWasm(ctx context.Context, cfg Config, path string, env *Envelope) error {
-	r := wazero.NewRuntime(ctx)
-	defer r.Close(ctx)
+// sseData returns the data payload of one SSE line; ok is false for
+// comments, other fields and empty data.
+func sseData(line string) (string, bool) {
+	if !strings.HasPrefix(line, "data:") { return "", false }
+	payload := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+	if payload == "" || payload == ":" { return "", false }
+	return payload, true
+}
+
+// wasmSignal peeks at the type so other relay traffic skips full validation.
+func wasmSignal(payload string) bool {
+	var head struct{ Type string `json:"type"` }
+	return json.Unmarshal([]byte(payload), &head) == nil && head.Type == "signal.wasm"
+}
+
+// frame is one classified stdout line. event is an owned copy of what goes
+// to the relay verbatim (a plain event, or the event of a fast-path
+// syscall.emit); call is the map decode the other syscalls get.
+type frame struct {
+	kind  string // syscall.* or ""
+	id    string
+	event []byte
+	call  map[string]any
+}
+
+// frameHead is the typed fast path: type/id/event only, no map.
+type frameHead struct {
+	Type  json.RawMessage `json:"type"`
+	ID    string          `json:"id"`
+	Event json.RawMessage `json:"event"`
+}
+
+var headPool = sync.Pool{New: func() any { return new(frameHead) }}
+
+var foldKeys = [][]byte{[]byte("type"), []byte("id"), []byte("event")}
+
+// plainKeys reports whether a typed decode sees what a map decode would:
+// struct fields match keys case-insensitively ("TYPE") and \u escapes can
+// spell anything, so either sends the line down the map path.
+func plainKeys(line []byte) bool {
+	if bytes.Contains(line, []byte(`\u`)) { return false }
+	for i := 0; i < len(line); i++ {
+		if line[i] != '"' { continue }
+		for _, k := range foldKeys {
+			end := i + 1 + len(k)
+			if end < len(line) && line[end] == '"' && bytes.EqualFold(line[i+1:end], k) && !bytes.Equal(line[i+1:end], k) { return false }
+		}
+	}
+	return true
+}
+
+// stdoutFrame classifies one module stdout line (a JSON object).
+func stdoutFrame(line []byte) (f frame, ok bool) {
+	if len(line) == 0 || line[0] != '{' { return f, false }
+	if plainKeys(line) {
+		h := headPool.Get().(*frameHead)
+		defer func() { *h = frameHead{Type: h.Type[:0], Event: h.Event[:0]}; headPool.Put(h) }()
+		err := json.Unmarshal(line, h) // on any error (e.g. a numeric id) the map path decides
+		switch {
+		case err != nil:
+		case !bytes.HasPrefix(h.Type, []byte(`"syscall.`)):
+			return frame{event: bytes.Clone(line)}, true
+		case bytes.Equal(h.Type, []byte(`"syscall.emit"`)) && len(h.Event) > 0 && h.Event[0] == '{':
+			return frame{kind: "syscall.emit", id: h.ID, event: bytes.Clone(h.Event)}, true
+		}
+	}
+	var call map[string]any
+	if json.Unmarshal(line, &call) != nil || call == nil { return f, false }
+	t, _ := call["type"].(string)
+	if !strings.HasPrefix(t, "syscall.") { return frame{event: bytes.Clone(line)}, true }
+	id, _ := call["id"].(string)
+	return frame{kind: t, id: id, call: call}, true
+}
 
-	// WASI
-	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil { return err }
+// --- Run WASM and handle syscalls ---
+func runWasm(ctx context.Context, cfg Config, path string, env *Envelope, rec *RunRecord) error {
+	start := time.Now()
+	r, release, err := acquireRuntime(cfg, rec.Path)
+	if err != nil { return err }
+	defer release()
 
 	// FS: ephemeral temp dir
-	tmpDir := filepath.Join(os.TempDir(), "void", "exec", fmt.Sprintf("%d", time.Now().UnixNano()))
-	if err := os.MkdirAll(tmpDir, 0o755); err != nil { return err }
+	tmpDir, err := runTempDir("exec")
+	if err != nil { return err }
 	defer os.RemoveAll(tmpDir)
+	outDir := tmpDir + ".out"
+	if err := os.MkdirAll(outDir, 0o755); err != nil { return err }
+	defer os.RemoveAll(outDir)
+
+	mounts, _, err := mountsFor(cfg, env, rec.Tenant, rec.Module)
+	if err != nil { return err }
+	if m, done, ok := scratchMount(cfg, rec, path); ok { mounts = append(mounts, m); defer done() }
+	pruneMounts(mounts)
+	fsConf := wazero.NewFSConfig().WithDirMount(tmpDir, "/tmp").WithDirMount(outDir, "/out")
+	if rec.inputs != nil { fsConf = fsConf.WithReadOnlyDirMount(rec.inputs.dir, "/inputs") }
+	fsc, cleanupMounts, err := prepareMounts(mounts, rec.ID, fsConf)
+	if err != nil { return err }
+	defer cleanupMounts()
+	vars, _, err := guestEnvFor(cfg, env, rec.Tenant, rec.Module)
+	if err != nil { return err }
 
-	// Inputs on stdin
+	// Inputs on stdin (or in /inputs/inputs.json when a reference streams there, inputs.go)
 	inputs := env.Inputs; if inputs == nil { inputs = map[string]any{} }
+	if rec.inputs != nil { inputs = rec.inputs.inputs }
 	inBytes, _ := json.Marshal(inputs)
-	stdin := bytes.NewReader(inBytes)
+	var stdin io.Reader = bytes.NewReader(inBytes)
+	if rec.inputs != nil && rec.inputs.stdin != "" {
+		if err := os.WriteFile(filepath.Join(rec.inputs.dir, "inputs.json"), inBytes, 0o444); err != nil { return err }
+		f, err := os.Open(rec.inputs.stdin)
+		if err != nil { return err }
+		defer f.Close()
+		stdin = f
+	}
+
+	var schemas *eventSchemas
+	if cfg.EventSchemaAction != "off" {
+		if schemas, err = eventSchemasFor(cfg, path); err != nil { return err }
+	}
 
-	var stdoutBuf bytes.Buffer
+	lim := outputLimitsFor(cfg, env)
+	ctx, cancel := context.WithCancel(withBudget(ctx, rec.budget))
+	defer cancel()
+	defer rec.budget.arm(cancel)()
+	ctx, stopSoft := softDeadline(ctx, cfg, rec)
+	ctx, stopKVWatch := withKVWatch(ctx, cfg, rec, start)
+	defer stopKVWatch()
+	ctx = withQueue(ctx, cfg, rec, start)
+	ctx = withHostVars(ctx, cfg, rec, start)
+	ctx = withClock(ctx, cfg, rec, start)
+	ctx = withRandom(ctx, cfg, rec, start)
+	stdout := &stdoutCap{max: lim.stdout}
+	if lim.kill { stdout.kill = cancel }
+	stopWatch := watchMounts(ctx, mounts, cancel)
 	var stderrBuf bytes.Buffer
 
 	cfgMod := wazero.NewModuleConfig().
-		WithStdout(&stdoutBuf).
+		WithStdout(stdout).
 		WithStderr(&stderrBuf).
 		WithStdin(stdin).
-		WithFSConfig(wazero.NewFSConfig().WithDir("/tmp", tmpDir))
-
-	compiled, err := r.CompileModule(ctx, mustRead(path))
+		WithFSConfig(fsc).
+		WithName("") // anonymous: the same module can run concurrently in a shared runtime
+	for _, kv := range vars { cfgMod = cfgMod.WithEnv(kv[0], kv[1]) }
+	cfgMod = clockModule(cfg, cfgMod)
+
+	t := time.Now()
+	compiled, done, err := compiledModule(ctx, cfg, r, rec.Path, path, &rec.phases)
+	rec.phases.since("compile", t)
 	if err != nil { return err }
-	_, err = r.InstantiateModule(ctx, compiled, cfgMod)
+	defer done()
+	stopCPU := guestCPU(rec)
+	t = time.Now()
+	mod, err := r.InstantiateModule(ctx, compiled, cfgMod.WithStartFunctions()) // _start below, timed apart
+	rec.phases.since("instantiate", t)
+	if err == nil {
+		t = time.Now()
+		defer rec.phases.since("execute", t) // syscalls included
+		if env.WS != "" { err = wsReact(ctx, cfg, rec, env.WS, mod, start) } else { err = runStart(ctx, mod) }
+	}
+	stopCPU()
+	if mod != nil { defer mod.Close(context.Background()) }
+	if stopSoft() { rec.decide("soft_timeout") }
+	if err := rec.budget.err(); err != nil { return err }
+	if stdout.over && lim.kill { return fmt.Errorf("%w: stdout > max_stdout_kb %d", errOutputLimit, lim.stdout>>10) }
+	if err := stopWatch(); err != nil { return err }
 	if err != nil { return err }
+	if artifactsOn(cfg) { publishArtifacts(cfg, rec, outDir, filepath.Join(tmpDir, "out")) } // guest /out, /tmp/out
+	if chaosHit(cfg, "trap", cfg.ChaosTrap) { return chaosErr("wasm trap (unreachable)") }
 
 	// Process stdout lines
-	sc := bufio.NewScanner(&stdoutBuf)
+	sc := bufio.NewScanner(&stdout.buf)
+	if lim.stdout > 0 { sc.Buffer(make([]byte, 0, 64*1024), int(lim.stdout)+1) } // any line that fit the cap
 	for sc.Scan() {
-		line := strings.TrimSpace(sc.Text())
-		if line == "" { continue }
-		var ev map[string]any
-		if err := json.Unmarshal([]byte(line), &ev); err != nil {
-			continue
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 { continue }
+		f, ok := stdoutFrame(line)
+		if rec.tape != nil {
+			rec.tape.stdout = append(rec.tape.stdout, string(line))
+			if ok && f.kind != "" && f.call == nil { json.Unmarshal(line, &f.call) } // recordings keep the request
 		}
+		if !ok { continue }
 		stdoutEvents.Inc()
-		if t, _ := ev["type"].(string); strings.HasPrefix(t, "syscall.") {
-			handleSyscall(cfg, t, ev)
+		if f.kind == "" || f.kind == "syscall.emit" {
+			pass, err := lim.admit(f, line)
+			if err != nil { return err }
+			if !pass {
+				if f.kind != "" { rec.traceSyscall(cfg.TimelineMax, start, f.kind, f.id, "limited", time.Now()) }
+				continue
+			}
+			if schemas != nil && !schemas.admit(cfg, rec, &f) {
+				if f.kind != "" { rec.traceSyscall(cfg.TimelineMax, start, f.kind, f.id, "schema_reject", time.Now()) }
+				continue
+			}
+			if f.event != nil { rec.EventBytes += int64(len(f.event)) } else { rec.EventBytes += int64(len(line)) }
+		}
+		if f.kind != "" {
+			if !rec.budget.spend(budgetSyscalls, 1) {
+				rec.traceSyscall(cfg.TimelineMax, start, f.kind, f.id, "budget", time.Now())
+				return rec.budget.err()
+			}
+			t0 := time.Now()
+			result := handleSyscall(cfg, rec, f)
+			if rec.tape != nil { rec.tape.capture(f.kind, f.call, result) }
+			rec.traceSyscall(cfg.TimelineMax, start, f.kind, f.id, result, t0)
+			if err := rec.budget.err(); err != nil { return err }
 		} else {
-			postEvent(cfg, ev)
+			postRaw(cfg, rec, f.event)
 		}
 	}
 	return sc.Err()
 }
 
-var httpClient = &http.Client{ Timeout: 2 * time.Second, Transport: &http.Transport{
-	DialContext: (&net.Dialer{ Timeout: 1 * time.Second }).DialContext,
-	DisableKeepAlives: true,
-}}
-
-func handleSyscall(cfg Config, kind string, payload map[string]any) {
+func handleSyscall(cfg Config, rec *RunRecord, f frame) (result string) {
+	kind, payload := f.kind, f.call
 	t0 := time.Now()
-	result := "ok"
+	result = "ok"
+	if chaosHit(cfg, "slow_syscall", cfg.ChaosSlowSyscall) { time.Sleep(cfg.ChaosSlowDelay) }
 	defer func(){ sysReqTotal.WithLabelValues(kind, result).Inc(); sysDur.WithLabelValues(kind).Observe(float64(time.Since(t0).Milliseconds())) }()
+	if r, ok := replayed(cfg, rec, kind); ok { result = r; return }
 
 	switch kind {
 	case "syscall.emit":
 		// forward event
+		if f.event != nil { postRaw(cfg, rec, f.event); return }
 		if ev, ok := payload["event"].(map[string]any); ok {
-			postEvent(cfg, ev); return
+			postRunEvent(cfg, rec, ev); return
 		}
 		result = "bad_event"
 	case "syscall.kv.set":
 		if !allowed("kv", cfg.AllowCaps) { result = "denied"; return }
-		m := kvLoad()
+		if rs, ok := kvBackend.(kvRevStore); ok { result = kvRevSet(cfg, rec, rs, payload); return }
+		if _, cas := payload["rev"]; cas { result = "cas_unsupported"; sysret(cfg, rec, map[string]any{"type":"sysret.kv.set","ok":false,"key":payload["key"],"error":"cas_unsupported"}); return }
+		m, err := kvLoad(cfg.Tenant)
+		if err != nil { result = "io_err"; return }
 		key, _ := payload["key"].(string)
 		val := payload["value"]
 		if key == "" { result = "bad_key"; return }
 		m[key] = val
-		if err := kvSave(m); err != nil { result = "io_err"; return }
-		postEvent(cfg, map[string]any{"type":"sysret.kv.set","ok":true,"key":key})
+		if err := kvSave(cfg.Tenant, m); err != nil { result = "io_err"; return }
+		kvNotify(cfg.Tenant, key, val, rec.ID, 0)
+		sysret(cfg, rec, map[string]any{"type":"sysret.kv.set","ok":true,"key":key})
 	case "syscall.kv.get":
 		if !allowed("kv", cfg.AllowCaps) { result = "denied"; return }
-		m := kvLoad()
+		if rs, ok := kvBackend.(kvRevStore); ok { result = kvRevGet(cfg, rec, rs, payload); return }
+		m, err := kvLoad(cfg.Tenant)
+		if err != nil { result = "io_err"; return }
 		key, _ := payload["key"].(string)
 		val := m[key]
-		postEvent(cfg, map[string]any{"type":"sysret.kv.get","ok": val != nil, "key": key, "value": val})
+		sysret(cfg, rec, map[string]any{"type":"sysret.kv.get","ok": val != nil, "key": key, "value": val})
+	case "syscall.graphql":
+		result = graphqlSyscall(cfg, rec, payload)
+	case "syscall.ai.infer":
+		result = aiSyscall(cfg, rec, payload)
+	case "syscall.queue.push", "syscall.queue.ack":
+		result = queueSyscall(cfg, rec, kind, payload)
 	case "syscall.http.fetch":
 		if !allowed("http", cfg.AllowCaps) { result = "denied"; return }
 		reqMap, _ := payload["req"].(map[string]any)
@@ -392,33 +601,58 @@ func handleSyscall(cfg Config, kind string, payload map[string]any) {
 				if vs,ok := v.(string); ok { hm.Set(k, vs) }
 			}
 		}
-		req, _ := http.NewRequest(method, rawURL, strings.NewReader(bodyStr))
-		req.Header = hm
-		resp, err := httpClient.Do(req)
-		if err != nil { result = "io_err"; return }
-		defer resp.Body.Close()
 		// limited body read
 		limKB := cfg.MaxHTTPKB
 		if lims, ok := payload["limits"].(map[string]any); ok {
 			if v, ok := lims["max_kb"].(float64); ok && int(v)>0 { limKB = int(v) }
 		}
-		limited := io.LimitedReader{ R: resp.Body, N: int64(limKB)*1024 }
+		limit := int64(limKB)*1024
+		if left := rec.budget.netLeft(); left >= 0 && left < limit { limit = left + 1 } // one byte over ends the run
+		cached, cacheable := httpCacheLookup(cfg, rec, method, rawURL, hm, limit)
+		if cached != nil && cached.fresh(hm) {
+			httpCacheTotal.WithLabelValues("hit").Inc()
+			sysret(cfg, rec, httpSysret(id, cached.status, min(cached.size, limit), cached.ctype, "hit"))
+			return
+		}
+		if !httpAllow(cfg) { result = "rate_limited"; return }
+		req, _ := http.NewRequest(method, rawURL, strings.NewReader(bodyStr))
+		req.Header = hm.Clone()
+		if cached != nil && cached.validators() {
+			if cached.etag != "" { req.Header.Set("If-None-Match", cached.etag) }
+			if cached.lastMod != "" { req.Header.Set("If-Modified-Since", cached.lastMod) }
+		}
+		setRunToken(cfg, rec, u, req.Header)
+		resp, err := httpClient.Do(req)
+		if err != nil { result = "io_err"; return }
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotModified && cached != nil && cached.validators() {
+			httpCacheTotal.WithLabelValues("revalidated").Inc()
+			httpCacheRefresh(cfg, rec, cached, resp)
+			sysret(cfg, rec, httpSysret(id, cached.status, min(cached.size, limit), cached.ctype, "revalidated"))
+			return
+		}
+		limited := io.LimitedReader{ R: resp.Body, N: limit }
 		n, _ := io.Copy(io.Discard, &limited)
-		postEvent(cfg, map[string]any{
-			"type":"sysret.http","id":id,"status":resp.StatusCode,
-			"kb": n/1024, "headers": map[string]any{"content-type": resp.Header.Get("content-type")},
-		})
+		if rec != nil { rec.NetBytes += n; rec.budget.spend(budgetNet, n) }
+		if cacheable {
+			httpCacheTotal.WithLabelValues("miss").Inc()
+			truncated := limited.N == 0 && resp.ContentLength != n
+			httpCacheStore(cfg, rec, rawURL, hm, resp, n, truncated)
+		}
+		sysret(cfg, rec, httpSysret(id, resp.StatusCode, n, resp.Header.Get("content-type"), ""))
 	default:
 		result = "unknown"
 	}
+	return
 }
 
-func mustRead(path string) []byte { b, err := os.ReadFile(path); if err != nil { panic(err) }; return b }
-
-func postEvent(cfg Config, ev map[string]any) {
-	url := cfg.RelayBase + cfg.EventPost
-	body, _ := json.Marshal(ev)
-	req, _ := http.NewRequest("POST", url, bytes.NewReader(body))
-	req.Header.Set("content-type", "application/json")
-	http.DefaultClient.Do(req)
+// httpSysret is the reply to syscall.http.fetch; cache is "" when the
+// response came from the origin (httpcache.go).
+func httpSysret(id string, status int, n int64, ctype, cache string) map[string]any {
+	ev := map[string]any{
+		"type":"sysret.http","id":id,"status":status,
+		"kb": n/1024, "headers": map[string]any{"content-type": ctype},
+	}
+	if cache != "" { ev["cache"] = cache }
+	return ev
 }
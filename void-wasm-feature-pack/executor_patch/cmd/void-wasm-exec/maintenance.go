@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Maintenance windows ---
+// While a window is active intake is paused (mode pause) or limited to
+// safe_modules (mode safe); it resumes on its own when the window ends.
+// This is separate from the admin pause so neither overrides the other.
+
+type MaintWindow struct {
+	Days        []string `yaml:"days"`  // mon..sun; empty = every day
+	Start       string   `yaml:"start"` // HH:MM in maintenance_tz
+	End         string   `yaml:"end"`   // HH:MM; End < Start wraps past midnight
+	Mode        string   `yaml:"mode"`  // pause | safe
+	SafeModules []string `yaml:"safe_modules"`
+}
+
+var maintGauge = prometheus.NewGauge(prometheus.GaugeOpts{Name: "void_wasm_maintenance", Help: "Maintenance state (0 none, 1 safe subset, 2 paused)"})
+
+// maintActive is the window in force, nil outside maintenance.
+var maintActive atomic.Pointer[MaintWindow]
+
+func hhmm(s string) int { t, _ := time.Parse("15:04", s); return t.Hour()*60 + t.Minute() }
+
+func (w MaintWindow) onDay(d time.Weekday) bool {
+	if len(w.Days) == 0 { return true }
+	return slices.Contains(w.Days, strings.ToLower(d.String()[:3]))
+}
+
+func (w MaintWindow) active(now time.Time) bool {
+	s, e, m := hhmm(w.Start), hhmm(w.End), now.Hour()*60+now.Minute()
+	if s <= e { return m >= s && m < e && w.onDay(now.Weekday()) }
+	if m >= s { return w.onDay(now.Weekday()) }
+	return m < e && w.onDay((now.Weekday()+6)%7) // tail of yesterday's window
+}
+
+// maintBlocks reports whether intake should skip env right now.
+func maintBlocks(env *Envelope) bool {
+	w := maintActive.Load()
+	if w == nil { return false }
+	return w.Mode == "pause" || !allowed(env.Module, w.SafeModules)
+}
+
+func maintenanceLoop(cfg Config) {
+	loc, _ := time.LoadLocation(cfg.MaintTZ)
+	if loc == nil { loc = time.UTC }
+	for {
+		var cur *MaintWindow
+		now := time.Now().In(loc)
+		for _, w := range currentConfig().Maintenance {
+			if w.active(now) { cur = &w; break }
+		}
+		prev := maintActive.Swap(cur)
+		switch {
+		case cur != nil && (prev == nil || prev.Start != cur.Start || prev.Mode != cur.Mode):
+			if cur.Mode == "pause" { maintGauge.Set(2) } else { maintGauge.Set(1) }
+			fmt.Println("[maint] window start", cur.Start+"-"+cur.End, "mode", cur.Mode)
+			audit(cfg, "maintenance.start", map[string]any{"start": cur.Start, "end": cur.End, "mode": cur.Mode, "safe_modules": cur.SafeModules})
+			postEvent(cfg, map[string]any{"type": "maintenance.start", "meta": map[string]any{"node": nodeID(cfg), "mode": cur.Mode, "until": cur.End}})
+		case cur == nil && prev != nil:
+			maintGauge.Set(0)
+			fmt.Println("[maint] window end, intake resumed")
+			audit(cfg, "maintenance.end", map[string]any{"start": prev.Start, "end": prev.End, "mode": prev.Mode})
+			postEvent(cfg, map[string]any{"type": "maintenance.end", "meta": map[string]any{"node": nodeID(cfg)}})
+		}
+		time.Sleep(30 * time.Second)
+	}
+}
+
+// --- Audit trail (append-only NDJSON) ---
+var auditMu sync.Mutex
+
+func audit(cfg Config, action string, fields map[string]any) {
+	entry := map[string]any{"ts": time.Now().UTC().Format(time.RFC3339), "node": nodeID(cfg), "action": action}
+	for k, v := range fields { entry[k] = v }
+	b, _ := json.Marshal(entry)
+	fmt.Println("[audit]", string(b))
+	if kind, ok := auditAnnotations[action]; ok { annotate(cfg, kind, action, "action:"+action) }
+	if cfg.AuditLog == "" { return }
+	auditMu.Lock(); defer auditMu.Unlock()
+	os.MkdirAll(filepath.Dir(cfg.AuditLog), 0o755)
+	f, err := os.OpenFile(cfg.AuditLog, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil { fmt.Println("[audit] write:", err); return }
+	defer f.Close()
+	f.Write(append(b, '\n'))
+}
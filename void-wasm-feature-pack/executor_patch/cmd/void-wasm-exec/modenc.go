@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Encrypted module blobs ---
+// Proprietary modules can be published encrypted, so a public IPFS gateway
+// only ever serves ciphertext. Two formats are recognised by their header:
+//   age       an age file (age-encryption.org/v1) to an X25519 recipient
+//   VOIDMOD1  "VOIDMOD1" | name length (1 byte) | key name | nonce | AES-256-GCM,
+//             the header bound as additional data;
+//             `void-wasm-exec module-seal` writes one
+// module_keys maps names to host-held keys (file:, env: or keyring:, as for
+// at_rest_key): 32 bytes raw, hex or base64 for VOIDMOD1, or an age identity
+// file (AGE-SECRET-KEY-1...). The envelope's sha256 (and cosign) cover the
+// blob as published; it is cached and shared as-is and decrypted only in
+// memory, when the module is loaded for compiling, inspection or attestation.
+// A blob whose key this node lacks fails the run with an error. Keys
+// load at startup.
+
+const modEncMagic = "VOIDMOD1"
+
+var ageMagic = []byte("age-encryption.org/v1\n")
+
+var moduleDecrypts = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_module_decrypt_total", Help: "Encrypted module blobs opened, by format and result"}, []string{"format", "result"})
+
+var (
+	moduleAEAD = map[string]cipher.AEAD{} // VOIDMOD1 key name -> key
+	moduleAge  []age.Identity
+)
+
+// loadModuleKeys reads every module_keys entry; nothing is kept on error.
+func loadModuleKeys(keys map[string]string) error {
+	aeads := map[string]cipher.AEAD{}
+	var ids []age.Identity
+	names := make([]string, 0, len(keys))
+	for name := range keys { names = append(names, name) }
+	sort.Strings(names)
+	for _, name := range names {
+		raw, err := keySource(keys[name])
+		if err != nil { return fmt.Errorf("%s: %w", name, err) }
+		if bytes.Contains(raw, []byte("AGE-SECRET-KEY-")) {
+			id, err := age.ParseIdentities(bytes.NewReader(raw))
+			if err != nil { return fmt.Errorf("%s: %w", name, err) }
+			ids = append(ids, id...)
+			continue
+		}
+		k, err := parseAtRestKey(raw)
+		if err != nil { return fmt.Errorf("%s: %w", name, err) }
+		blk, _ := aes.NewCipher(k)
+		aeads[name], _ = cipher.NewGCM(blk)
+	}
+	moduleAEAD, moduleAge = aeads, ids
+	return nil
+}
+
+func validModuleKeys(keys map[string]string) error {
+	for name, spec := range keys {
+		if name == "" || len(name) > 255 { return fmt.Errorf("%q: name must be 1-255 bytes", name) }
+		if k, _, _ := strings.Cut(spec, ":"); k != "file" && k != "env" && k != "keyring" { return fmt.Errorf("%s: want file:, env: or keyring:, got %q", name, spec) }
+	}
+	return nil
+}
+
+// openModule decrypts an encrypted module blob and passes plain wasm through.
+func openModule(b []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(b, ageMagic):
+		if len(moduleAge) == 0 { moduleDecrypts.WithLabelValues("age", "no_key").Inc(); return nil, errors.New("module: age-encrypted and no age identity in module_keys") }
+		r, err := age.Decrypt(bytes.NewReader(b), moduleAge...)
+		if err == nil { b, err = io.ReadAll(r) }
+		if err != nil { moduleDecrypts.WithLabelValues("age", "error").Inc(); return nil, fmt.Errorf("module: age: %w", err) }
+		moduleDecrypts.WithLabelValues("age", "ok").Inc()
+		return b, nil
+	case bytes.HasPrefix(b, []byte(modEncMagic)):
+		hdr, nonce, ct, name, err := splitModEnc(b)
+		if err != nil { moduleDecrypts.WithLabelValues("voidmod", "error").Inc(); return nil, err }
+		aead := moduleAEAD[name]
+		if aead == nil { moduleDecrypts.WithLabelValues("voidmod", "no_key").Inc(); return nil, fmt.Errorf("module: encrypted with key %q, not in module_keys", name) }
+		out, err := aead.Open(nil, nonce, ct, hdr)
+		if err != nil { moduleDecrypts.WithLabelValues("voidmod", "error").Inc(); return nil, fmt.Errorf("module: key %q: wrong key or corrupted", name) }
+		moduleDecrypts.WithLabelValues("voidmod", "ok").Inc()
+		return out, nil
+	}
+	return b, nil
+}
+
+func splitModEnc(b []byte) (hdr, nonce, ct []byte, name string, err error) {
+	rest := b[len(modEncMagic):]
+	if len(rest) < 1 || len(rest) < 1+int(rest[0])+12 { return nil, nil, nil, "", errors.New("module: encrypted blob truncated") }
+	n := int(rest[0])
+	hdr = b[:len(modEncMagic)+1+n]
+	name = string(rest[1 : 1+n])
+	rest = rest[1+n:]
+	return hdr, rest[:12], rest[12:], name, nil
+}
+
+// sealModule encrypts wasm under key name as a VOIDMOD1 blob.
+func sealModule(wasm []byte, name string, aead cipher.AEAD) []byte {
+	hdr := append([]byte(modEncMagic), byte(len(name)))
+	hdr = append(hdr, name...)
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+	out := append(append([]byte{}, hdr...), nonce...)
+	return aead.Seal(out, nonce, wasm, hdr)
+}
+
+// moduleSealLocal: void-wasm-exec module-seal --key file:/k --name prod in.wasm out.blob
+func moduleSealLocal(args []string) int {
+	fs := flag.NewFlagSet("module-seal", flag.ExitOnError)
+	keySpec := fs.String("key", "", "AES-256 key: file:/path | env:NAME | keyring:name")
+	name := fs.String("name", "", "key name the executor knows it by (module_keys)")
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, "usage: void-wasm-exec module-seal --key SPEC --name NAME module.wasm out.blob"); fs.PrintDefaults() }
+	fs.Parse(args)
+	if fs.NArg() != 2 || *keySpec == "" || *name == "" || len(*name) > 255 { fs.Usage(); return 2 }
+	raw, err := keySource(*keySpec)
+	var k []byte
+	if err == nil { k, err = parseAtRestKey(raw) }
+	if err != nil { fmt.Fprintln(os.Stderr, "module-seal:", err); return 1 }
+	wasm, err := os.ReadFile(fs.Arg(0))
+	if err != nil { fmt.Fprintln(os.Stderr, "module-seal:", err); return 1 }
+	if !bytes.HasPrefix(wasm, []byte("\x00asm")) { fmt.Fprintln(os.Stderr, "module-seal: not a wasm module"); return 1 }
+	blk, _ := aes.NewCipher(k)
+	aead, _ := cipher.NewGCM(blk)
+	if err := writeFileAtomic(fs.Arg(1), sealModule(wasm, *name, aead), 0o644); err != nil { fmt.Fprintln(os.Stderr, "module-seal:", err); return 1 }
+	return 0
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/experimental"
+)
+
+// --- In-memory LRU of compiled modules ---
+// Keyed by runtime + sha256 of the module bytes (a CompiledModule belongs to
+// the runtime that compiled it), bounded by module_lru entries and
+// module_lru_mb of wasm; fuel-metered compiles (budget.go) are separate
+// entries. Entries are refcounted: an evicted module is closed
+// once the last run using it finishes. Off with runtime_per_run.
+
+var (
+	modLRUTotal   = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_module_lru_total", Help: "Compiled module lookups by result"}, []string{"result"})
+	modLRUBytes   = prometheus.NewGauge(prometheus.GaugeOpts{Name: "void_wasm_module_lru_bytes", Help: "Wasm bytes behind cached compiled modules"})
+	modLRUEntries = prometheus.NewGauge(prometheus.GaugeOpts{Name: "void_wasm_module_lru_entries", Help: "Cached compiled modules"})
+)
+
+type modEntry struct {
+	key      string
+	compiled wazero.CompiledModule
+	size     int
+	refs     int
+	evicted  bool
+}
+
+var (
+	modMu    sync.Mutex
+	modOrder = list.New() // front = most recently used
+	modIndex = map[string]*list.Element{}
+	modBytes int
+)
+
+func (e *modEntry) release() {
+	modMu.Lock(); defer modMu.Unlock()
+	e.refs--
+	if e.evicted && e.refs == 0 { e.compiled.Close(context.Background()) }
+}
+
+// evictLocked drops least recently used entries until the bounds hold.
+func evictLocked(maxEntries, maxBytes int) {
+	for modOrder.Len() > 0 && (modOrder.Len() > maxEntries || modBytes > maxBytes) {
+		el := modOrder.Back()
+		e := el.Value.(*modEntry)
+		modOrder.Remove(el)
+		delete(modIndex, e.key)
+		modBytes -= e.size
+		e.evicted = true
+		if e.refs == 0 { e.compiled.Close(context.Background()) }
+	}
+	modLRUBytes.Set(float64(modBytes))
+	modLRUEntries.Set(float64(modOrder.Len()))
+}
+
+// compiledModule returns path compiled in r plus a func to call when the run
+// is done with it.
+func compiledModule(ctx context.Context, cfg Config, r wazero.Runtime, runtimePath, path string, ph *runPhases) (wazero.CompiledModule, func(), error) {
+	b, err := readModule(path)
+	if err != nil { return nil, nil, err }
+	fuel := metered(ctx)
+	if fuel { ctx = experimental.WithFunctionListenerFactory(ctx, fuelListener{}) } // budget.go
+	if cfg.RuntimePerRun || cfg.ModuleLRU <= 0 {
+		c, err := r.CompileModule(ctx, b)
+		if err != nil { return nil, nil, err }
+		ph.coldStart()
+		return c, func() { c.Close(context.Background()) }, nil
+	}
+	sum := sha256.Sum256(b)
+	key := runtimeKey(cfg, runtimePath) + "@" + hex.EncodeToString(sum[:])
+	if fuel { key += "+fuel" }
+
+	modMu.Lock()
+	if el, ok := modIndex[key]; ok {
+		modOrder.MoveToFront(el)
+		e := el.Value.(*modEntry)
+		e.refs++
+		modMu.Unlock()
+		modLRUTotal.WithLabelValues("hit").Inc()
+		return e.compiled, e.release, nil
+	}
+	modMu.Unlock()
+	modLRUTotal.WithLabelValues("miss").Inc()
+
+	c, err := r.CompileModule(ctx, b)
+	if err != nil { return nil, nil, err }
+	ph.coldStart()
+	modMu.Lock(); defer modMu.Unlock()
+	if el, ok := modIndex[key]; ok { // compiled concurrently; keep the first
+		c.Close(context.Background())
+		e := el.Value.(*modEntry)
+		e.refs++
+		return e.compiled, e.release, nil
+	}
+	e := &modEntry{key: key, compiled: c, size: len(b), refs: 1}
+	modIndex[key] = modOrder.PushFront(e)
+	modBytes += e.size
+	evictLocked(cfg.ModuleLRU, cfg.ModuleLRUMB<<20)
+	return c, e.release, nil
+}
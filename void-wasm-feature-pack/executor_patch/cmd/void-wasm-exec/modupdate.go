@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Stale-while-revalidate module updates ---
+// A module@constraint keeps running the release it first resolved to. When
+// the registry index later resolves it to a newer version, runs go on with
+// the pinned release while the new one is downloaded, sha256-checked and
+// compiled into the module LRU in the background; only then does the pin
+// switch (one swap under a lock, so a run sees either release, never a half
+// fetched one) and a module.updated event {module, constraint, from, to,
+// sha256, index} goes to the relay. A failed update is retried after
+// registry_ttl. A pinned release that is yanked or leaves the index is
+// dropped at once. The index itself is revalidated the same way: past
+// registry_ttl the current one keeps serving while a new one is read, and a
+// new index version re-checks every pin without waiting for a run.
+
+var moduleUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_module_updates_total", Help: "Background module@version updates by result (switched, error)"}, []string{"result"})
+
+type modulePin struct {
+	rel      ModuleRelease
+	updating string // version being fetched, "" = none
+	failed   string // version whose update failed
+	failedAt time.Time
+}
+
+var pins struct {
+	sync.Mutex
+	m map[string]*modulePin // module@constraint
+}
+
+// servedRelease is the release a run of module@constraint uses: the pinned
+// one while it is still in the index, with an update to latest started in
+// the background when latest is newer.
+func servedRelease(cfg Config, doc *registryDoc, latest ModuleRelease) ModuleRelease {
+	key := latest.Module + "@" + latest.Constraint
+	pins.Lock()
+	defer pins.Unlock()
+	if pins.m == nil { pins.m = map[string]*modulePin{} }
+	p := pins.m[key]
+	if p == nil || !doc.listed(p.rel) {
+		pins.m[key] = &modulePin{rel: latest}
+		return latest
+	}
+	if p.rel.Version != latest.Version && p.updating == "" && newerVersion(latest.Version, p.rel.Version) && (p.failed != latest.Version || time.Since(p.failedAt) >= cfg.RegistryTTL) {
+		p.updating = latest.Version
+		go updateModule(cfg, key, latest)
+	}
+	rel := p.rel
+	rel.Index = doc.Version
+	return rel
+}
+
+// listed reports whether rel is still an unyanked release of the index.
+func (d *registryDoc) listed(rel ModuleRelease) bool {
+	r, ok := d.Modules[rel.Module][rel.Version]
+	return ok && !r.Yanked && strings.EqualFold(r.SHA256, rel.SHA256)
+}
+
+func newerVersion(a, b string) bool {
+	va, err1 := parseSemver(a)
+	vb, err2 := parseSemver(b)
+	return err1 == nil && err2 == nil && vb.less(va)
+}
+
+// updateModule fetches and compiles rel, then moves the pin for key to it.
+func updateModule(cfg Config, key string, rel ModuleRelease) {
+	err := prepareModule(cfg, rel)
+	pins.Lock()
+	p := pins.m[key]
+	if p == nil || p.updating != rel.Version { pins.Unlock(); return } // dropped meanwhile
+	p.updating = ""
+	if err != nil {
+		p.failed, p.failedAt = rel.Version, time.Now()
+		pins.Unlock()
+		moduleUpdates.WithLabelValues("error").Inc()
+		fmt.Println("[registry] update", key, "to", rel.Version+":", err)
+		return
+	}
+	from := p.rel.Version
+	p.rel, p.failed = rel, ""
+	pins.Unlock()
+	moduleUpdates.WithLabelValues("switched").Inc()
+	fmt.Println("[registry]", key, from, "->", rel.Version, "sha256:"+rel.SHA256)
+	postEvent(cfg, map[string]any{"type": "module.updated", "meta": map[string]any{"module": rel.Module, "constraint": rel.Constraint, "from": from, "to": rel.Version, "sha256": rel.SHA256, "index": rel.Index, "node": nodeID(cfg)}})
+}
+
+// prepareModule puts rel in cache_dir (sha256 checked) and, with a shared
+// runtime, its compiled form in the module LRU.
+func prepareModule(cfg Config, rel ModuleRelease) error {
+	path, _, err := fetchModule(cfg, &Envelope{Module: rel.Module, SHA256: rel.SHA256, CID: rel.CID, URL: rel.URL}, nil)
+	if err != nil { return err }
+	if cfg.RuntimePerRun || cfg.ModuleLRU <= 0 { return nil }
+	r, release, err := acquireRuntime(cfg, pathStable)
+	if err != nil { return err }
+	defer release()
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DefaultTO)
+	defer cancel()
+	_, done, err := compiledModule(ctx, cfg, r, pathStable, path, nil)
+	if err != nil { return err }
+	done()
+	return nil
+}
+
+// revalidatePins re-resolves every pin against a new index version.
+func revalidatePins(cfg Config, doc *registryDoc) {
+	pins.Lock()
+	var latest []ModuleRelease
+	for _, p := range pins.m {
+		ver, rel, err := doc.resolve(p.rel.Module, p.rel.Constraint)
+		if err != nil { continue }
+		latest = append(latest, ModuleRelease{Module: p.rel.Module, Constraint: p.rel.Constraint, Version: ver, SHA256: strings.ToLower(rel.SHA256), CID: rel.CID, URL: rel.URL, Index: doc.Version})
+	}
+	pins.Unlock()
+	for _, l := range latest { servedRelease(cfg, doc, l) }
+}
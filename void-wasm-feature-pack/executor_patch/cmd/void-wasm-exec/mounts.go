@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tetratelabs/wazero"
+)
+
+// --- Named WASI mounts ---
+// Besides the ephemeral /tmp and /out (and /scratch, scratch.go), an envelope may ask for mounts by name
+// ({"name":"datasets","path":"/data"}); only names defined under mounts in
+// config exist, and policy checks the module/tenant patterns and the mode (an
+// envelope may ask ro of an rw mount, never the reverse) before the run is
+// fetched. ro mounts are the host directory as is. rw mounts are scratch
+// space under <host>/<tenant>/<module> (or <host> itself when shared), with:
+//   quota_mb  checked before the run (deny_mount) and polled during it; a
+//             run that grows past it is canceled with result mount_quota
+//   cleanup   keep (persistent), run (a fresh <dir>/.runs/<run id> per run,
+//             removed after it, so concurrent runs never see each other's
+//             files; quota_mb is then per run) or ttl (files in the run's own
+//             dir older than ttl removed before each run)
+
+type MountSpec struct {
+	Host    string        `yaml:"host"`
+	Mode    string        `yaml:"mode"`    // ro | rw
+	Modules []string      `yaml:"modules"` // empty = any allowlisted module
+	Tenants []string      `yaml:"tenants"` // empty = any tenant
+	QuotaMB int           `yaml:"quota_mb"` // rw; 0 = unlimited
+	Cleanup string        `yaml:"cleanup"`  // rw: keep | run | ttl
+	TTL     time.Duration `yaml:"ttl"`
+	Shared  bool          `yaml:"shared"` // rw: one dir for every tenant and module
+}
+
+// MountReq is one envelope mounts entry.
+type MountReq struct {
+	Name string `json:"name"`
+	Path string `json:"path"`           // guest path
+	Mode string `json:"mode,omitempty"` // ro | rw; default the mount's mode
+}
+
+const maxMounts = 8
+
+var mountsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_mounts_total", Help: "Mount requests by mount and result"}, []string{"mount", "result"})
+
+var errMountQuota = errors.New("mount quota exceeded")
+
+type runMount struct {
+	name, host, guest string
+	rw                bool
+	quota             int64
+	cleanup           string // rw mounts only
+	ttl               time.Duration
+}
+
+// validMountReqs is the envelope-side check (parseEnvelope).
+func validMountReqs(reqs []MountReq) error {
+	if len(reqs) > maxMounts { return fmt.Errorf("at most %d", maxMounts) }
+	seen := map[string]bool{}
+	for _, m := range reqs {
+		if !tenantName.MatchString(m.Name) { return fmt.Errorf("invalid name %q", m.Name) }
+		if !path.IsAbs(m.Path) || path.Clean(m.Path) != m.Path || m.Path == "/" || reservedGuestPath(m.Path) { return fmt.Errorf("%s: path must be a clean absolute path outside /tmp, /out and /scratch", m.Name) }
+		if m.Mode != "" && m.Mode != "ro" && m.Mode != "rw" { return fmt.Errorf("%s: mode must be ro or rw", m.Name) }
+		if seen[m.Path] { return fmt.Errorf("%s: duplicate path %s", m.Name, m.Path) }
+		seen[m.Path] = true
+	}
+	return nil
+}
+
+func reservedGuestPath(p string) bool {
+	for _, r := range []string{"/tmp", "/out", scratchGuest} {
+		if p == r || strings.HasPrefix(p, r+"/") { return true }
+	}
+	return false
+}
+
+// mountsFor resolves and approves env's mounts; denied names a mount that
+// policy refuses (err says why).
+func mountsFor(cfg Config, env *Envelope, tenant, module string) (mounts []runMount, denied string, err error) {
+	for _, req := range env.Mounts {
+		spec, ok := cfg.Mounts[req.Name]
+		switch {
+		case !ok:
+			err = errors.New("not defined")
+		case len(spec.Modules) > 0 && !allowed(module, spec.Modules):
+			err = errors.New("module not allowed")
+		case len(spec.Tenants) > 0 && !allowed(tenant, spec.Tenants):
+			err = errors.New("tenant not allowed")
+		case req.Mode == "rw" && spec.Mode != "rw":
+			err = errors.New("mount is read-only")
+		}
+		if err != nil { return nil, req.Name, err }
+		m := runMount{name: req.Name, host: spec.Host, guest: req.Path, rw: spec.Mode == "rw" && req.Mode != "ro"}
+		if spec.Mode == "rw" { m.cleanup, m.ttl = spec.Cleanup, spec.TTL }
+		if spec.Mode == "rw" && !spec.Shared { m.host = filepath.Join(spec.Host, tenant, strings.ReplaceAll(module, "/", "_")) }
+		if m.rw { m.quota = int64(spec.QuotaMB) << 20 }
+		mounts = append(mounts, m)
+	}
+	return mounts, "", nil
+}
+
+// mountPolicy is the policy-stage check, including quotas already used up.
+func mountPolicy(cfg Config, env *Envelope, rec *RunRecord) bool {
+	mounts, denied, err := mountsFor(cfg, env, rec.Tenant, rec.Module)
+	if err != nil {
+		mountsTotal.WithLabelValues(denied, "deny").Inc()
+		rec.decide("mount=" + denied + ":deny"); rec.Error = "mount " + denied + ": " + err.Error()
+		return false
+	}
+	for _, m := range mounts {
+		if m.quota > 0 && m.cleanup != "run" && dirSize(m.host) >= m.quota {
+			mountsTotal.WithLabelValues(m.name, "quota").Inc()
+			rec.decide("mount=" + m.name + ":quota"); rec.Error = "mount " + m.name + ": quota exhausted"
+			return false
+		}
+		mode := "ro"
+		if m.rw { mode = "rw" }
+		mountsTotal.WithLabelValues(m.name, "ok").Inc()
+		rec.decide("mount=" + m.name + ":" + mode)
+	}
+	return true
+}
+
+// prepareMounts adds the mounts to fsc and returns a cleanup func for after
+// the run; cleanup: run mounts are pointed at their per-run dir in place.
+func prepareMounts(mounts []runMount, runID string, fsc wazero.FSConfig) (wazero.FSConfig, func(), error) {
+	var after []string
+	for i := range mounts {
+		m := &mounts[i]
+		if !m.rw {
+			if st, err := os.Stat(m.host); err != nil || !st.IsDir() { return fsc, nil, fmt.Errorf("mount %s: %s is not a directory", m.name, m.host) }
+			fsc = fsc.WithReadOnlyDirMount(m.host, m.guest)
+			continue
+		}
+		if m.cleanup == "run" {
+			m.host = filepath.Join(m.host, ".runs", runID)
+			after = append(after, m.host)
+		}
+		if err := os.MkdirAll(m.host, 0o755); err != nil { return fsc, nil, fmt.Errorf("mount %s: %w", m.name, err) }
+		fsc = fsc.WithDirMount(m.host, m.guest)
+	}
+	return fsc, func() {
+		for _, dir := range after { os.RemoveAll(dir) }
+	}, nil
+}
+
+// pruneMounts applies cleanup: ttl before a run, within the run's own dir
+// (<host>/<tenant>/<module>, or <host> when shared).
+func pruneMounts(mounts []runMount) {
+	for _, m := range mounts {
+		if m.cleanup != "ttl" { continue }
+		cutoff := time.Now().Add(-m.ttl)
+		filepath.WalkDir(m.host, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() { return nil }
+			if info, err := d.Info(); err == nil && info.ModTime().Before(cutoff) { os.Remove(p) }
+			return nil
+		})
+	}
+}
+
+// watchMounts polls rw mounts with a quota and cancels the run on a breach;
+// the returned func stops it and reports whether it fired.
+func watchMounts(ctx context.Context, mounts []runMount, cancel context.CancelFunc) func() error {
+	var quota []runMount
+	for _, m := range mounts {
+		if m.quota > 0 { quota = append(quota, m) }
+	}
+	if len(quota) == 0 { return func() error { return nil } }
+	done := make(chan struct{})
+	breach := make(chan error, 1)
+	go func() {
+		t := time.NewTicker(200 * time.Millisecond)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			for _, m := range quota {
+				if dirSize(m.host) > m.quota {
+					mountsTotal.WithLabelValues(m.name, "quota").Inc()
+					breach <- fmt.Errorf("%w: %s > %d MB", errMountQuota, m.name, m.quota>>20)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return func() error {
+		close(done)
+		select {
+		case err := <-breach:
+			return err
+		default:
+		}
+		for _, m := range quota { // writes faster than the poll
+			if dirSize(m.host) > m.quota { mountsTotal.WithLabelValues(m.name, "quota").Inc(); return fmt.Errorf("%w: %s > %d MB", errMountQuota, m.name, m.quota>>20) }
+		}
+		return nil
+	}
+}
+
+func dirSize(dir string) int64 {
+	var n int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() { return nil }
+		if info, err := d.Info(); err == nil { n += info.Size() }
+		return nil
+	})
+	return n
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// --- NATS KV backend (kv_backend: nats) ---
+// A JetStream key-value bucket (kv_nats_bucket, created with
+// kv_nats_replicas if missing) on kv_nats_url, default nats_url, shared by
+// every executor connected to it. Unlike the other backends each key is its
+// own entry, <tenant>.<base64url key>, with a revision NATS assigns on every
+// write, so the kv syscalls work per key without kvMu:
+//   syscall.kv.set with "rev": n writes only if the key is still at
+//   revision n (0 = only if absent); a lost race answers ok:false,
+//   "error":"conflict" with the current "rev" (result conflict)
+//   sysret.kv.set / sysret.kv.get carry the key's "rev"
+// A bucket watcher turns every write, from any node, into KV watch
+// notifications (with "rev"), so watching runs see the whole cluster.
+// Other backends have no revisions and answer "rev" with cas_unsupported.
+
+var natsBucket = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// kvRevStore is a backend that versions each key.
+type kvRevStore interface {
+	kvStore
+	get(tenant, key string) (any, uint64, error)
+	set(tenant, key string, val any, run string, expect *uint64) (uint64, error)
+}
+
+// kvConflict is a compare-and-set that lost; rev is the key's current one.
+type kvConflict struct{ rev uint64 }
+
+func (c kvConflict) Error() string { return fmt.Sprintf("conflict (rev %d)", c.rev) }
+
+// natsEntry is the stored value: the run is kept for watchers on other nodes.
+type natsEntry struct {
+	V   any    `json:"v"`
+	Run string `json:"run,omitempty"`
+}
+
+type natsKV struct {
+	nc *nats.Conn
+	kv nats.KeyValue
+}
+
+func openNATSKV(cfg Config) (*natsKV, error) {
+	url := cfg.KVNATSURL
+	if url == "" { url = cfg.NATSURL }
+	opts := []nats.Option{nats.Name("void-wasm-exec kv " + nodeID(cfg)), nats.MaxReconnects(-1)}
+	if cfg.NATSCreds != "" { opts = append(opts, nats.UserCredentials(cfg.NATSCreds)) }
+	nc, err := nats.Connect(url, opts...)
+	if err != nil { return nil, fmt.Errorf("nats %s: %w", url, err) }
+	js, err := nc.JetStream(nats.MaxWait(3 * time.Second))
+	if err != nil { nc.Close(); return nil, err }
+	kv, err := js.KeyValue(cfg.KVNATSBucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{Bucket: cfg.KVNATSBucket, Replicas: cfg.KVNATSReplicas, Description: "void-wasm-exec syscall.kv"})
+		if err == nil { fmt.Println("[kv] created nats bucket", cfg.KVNATSBucket) }
+	}
+	if err != nil { nc.Close(); return nil, fmt.Errorf("kv_nats_bucket %s: %w", cfg.KVNATSBucket, err) }
+	return &natsKV{nc, kv}, nil
+}
+
+func natsKey(tenant, key string) string { return tenant + "." + base64.RawURLEncoding.EncodeToString([]byte(key)) }
+
+func natsSplit(k string) (tenant, key string, ok bool) {
+	tenant, enc, ok := strings.Cut(k, ".")
+	b, err := base64.RawURLEncoding.DecodeString(enc)
+	return tenant, string(b), ok && err == nil
+}
+
+func natsDecode(tenant, key string, raw []byte) (natsEntry, error) {
+	var e natsEntry
+	raw, err := openAtRest(raw, "kv:"+tenant+"/"+key)
+	if err == nil { err = json.Unmarshal(raw, &e) }
+	return e, err
+}
+
+func (s *natsKV) get(tenant, key string) (any, uint64, error) {
+	ent, err := s.kv.Get(natsKey(tenant, key))
+	if errors.Is(err, nats.ErrKeyNotFound) { return nil, 0, nil }
+	if err != nil { return nil, 0, err }
+	e, err := natsDecode(tenant, key, ent.Value())
+	return e.V, ent.Revision(), err
+}
+
+func (s *natsKV) set(tenant, key string, val any, run string, expect *uint64) (uint64, error) {
+	raw, _ := json.Marshal(natsEntry{val, run})
+	raw = sealAtRest(raw, "kv:"+tenant+"/"+key)
+	k := natsKey(tenant, key)
+	if expect == nil { return s.kv.Put(k, raw) }
+	var rev uint64
+	var err error
+	if *expect == 0 { rev, err = s.kv.Create(k, raw) } else { rev, err = s.kv.Update(k, raw, *expect) }
+	if err == nil { return rev, nil }
+	if _, cur, gerr := s.get(tenant, key); gerr == nil && cur != *expect { return 0, kvConflict{cur} }
+	return 0, err
+}
+
+func (s *natsKV) keys(tenant string) ([]string, error) {
+	all, err := s.kv.Keys()
+	if errors.Is(err, nats.ErrNoKeysFound) { return nil, nil }
+	var out []string
+	for _, k := range all {
+		if t, _, ok := natsSplit(k); ok && (tenant == "" || t == tenant) { out = append(out, k) }
+	}
+	return out, err
+}
+
+func (s *natsKV) load(tenant string) (map[string]any, error) {
+	m := map[string]any{}
+	ks, err := s.keys(tenant)
+	if err != nil { return nil, err }
+	for _, k := range ks {
+		_, key, _ := natsSplit(k)
+		v, _, err := s.get(tenant, key)
+		if err != nil { return nil, fmt.Errorf("key %s: %w", key, err) }
+		m[key] = v
+	}
+	return m, nil
+}
+
+// save makes tenant's keys exactly m (restore and migrate).
+func (s *natsKV) save(tenant string, m map[string]any) error {
+	ks, err := s.keys(tenant)
+	if err != nil { return err }
+	for _, k := range ks {
+		_, key, _ := natsSplit(k)
+		if _, keep := m[key]; keep { continue }
+		if err := s.kv.Delete(k); err != nil { return err }
+	}
+	for key, v := range m {
+		if _, err := s.set(tenant, key, v, "", nil); err != nil { return err }
+	}
+	return nil
+}
+
+func (s *natsKV) tenants() ([]string, error) {
+	ks, err := s.keys("")
+	seen := map[string]bool{}
+	var out []string
+	for _, k := range ks {
+		if t, _, _ := natsSplit(k); !seen[t] { seen[t] = true; out = append(out, t) }
+	}
+	return out, err
+}
+
+// kvRevSet is syscall.kv.set on a versioned backend.
+func kvRevSet(cfg Config, rec *RunRecord, rs kvRevStore, payload map[string]any) string {
+	key, _ := payload["key"].(string)
+	if key == "" { return "bad_key" }
+	var expect *uint64
+	if r, ok := payload["rev"].(float64); ok {
+		if r < 0 { return "bad_rev" }
+		n := uint64(r)
+		expect = &n
+	}
+	rev, err := rs.set(cfg.Tenant, key, payload["value"], rec.ID, expect)
+	var c kvConflict
+	if errors.As(err, &c) {
+		sysret(cfg, rec, map[string]any{"type": "sysret.kv.set", "ok": false, "key": key, "error": "conflict", "rev": c.rev})
+		return "conflict"
+	}
+	if err != nil { return "io_err" }
+	sysret(cfg, rec, map[string]any{"type": "sysret.kv.set", "ok": true, "key": key, "rev": rev})
+	return "ok"
+}
+
+func kvRevGet(cfg Config, rec *RunRecord, rs kvRevStore, payload map[string]any) string {
+	key, _ := payload["key"].(string)
+	val, rev, err := rs.get(cfg.Tenant, key)
+	if err != nil { return "io_err" }
+	sysret(cfg, rec, map[string]any{"type": "sysret.kv.get", "ok": val != nil, "key": key, "value": val, "rev": rev})
+	return "ok"
+}
+
+func (s *natsKV) close() error { s.nc.Close(); return nil }
+
+// watch feeds every bucket update, local or not, to KV watch.
+func (s *natsKV) watch() {
+	for {
+		w, err := s.kv.WatchAll(nats.UpdatesOnly())
+		if err != nil { fmt.Println("[kv] nats watch:", err); time.Sleep(5 * time.Second); continue }
+		for ent := range w.Updates() {
+			if ent == nil { continue }
+			tenant, key, ok := natsSplit(ent.Key())
+			if !ok { continue }
+			var e natsEntry
+			if ent.Operation() == nats.KeyValuePut {
+				if e, err = natsDecode(tenant, key, ent.Value()); err != nil { fmt.Println("[kv] nats watch:", key, err); continue }
+			}
+			kvNotify(tenant, key, e.V, e.Run, ent.Revision())
+		}
+		fmt.Println("[kv] nats watch ended, restarting")
+		time.Sleep(time.Second)
+	}
+}
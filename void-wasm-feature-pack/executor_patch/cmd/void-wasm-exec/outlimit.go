@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Per-run output limits ---
+// max_stdout_kb caps what a module may write to stdout, max_events the events
+// it may forward (plain lines and syscall.emit) and max_event_kb the size of
+// one event. output_action kill ends the run at the first breach with result
+// output_limit (the module is closed mid-run for stdout); throttle lets it
+// finish and drops the excess: stdout past the cap is discarded, oversized
+// and surplus events are never forwarded. Envelope limits of the same names
+// can only tighten the configured values.
+
+var outputLimited = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_output_limited_total", Help: "Output limit breaches (runs for stdout, events otherwise)"}, []string{"limit"})
+
+var errOutputLimit = errors.New("output limit exceeded")
+
+type outputLimits struct {
+	stdout, events, eventBytes int64 // 0 = unlimited
+	kill                       bool
+	sent                       int64
+}
+
+func outputLimitsFor(cfg Config, env *Envelope) *outputLimits {
+	l := &outputLimits{stdout: int64(cfg.MaxStdoutKB) << 10, events: int64(cfg.MaxEvents), eventBytes: int64(cfg.MaxEventKB) << 10, kill: cfg.OutputAction == "kill"}
+	tighten := func(dst *int64, key string, unit int64) {
+		v, _ := env.Limits[key].(float64)
+		if n := int64(v) * unit; n > 0 && (*dst == 0 || n < *dst) { *dst = n }
+	}
+	tighten(&l.stdout, "max_stdout_kb", 1<<10)
+	tighten(&l.events, "max_events", 1)
+	tighten(&l.eventBytes, "max_event_kb", 1<<10)
+	return l
+}
+
+// admit books one event; false = drop it, err = end the run (kill).
+func (l *outputLimits) admit(f frame, line []byte) (bool, error) {
+	size := len(line)
+	if f.event != nil { size = len(f.event) }
+	if l.eventBytes > 0 && int64(size) > l.eventBytes {
+		outputLimited.WithLabelValues("event_size").Inc()
+		if l.kill { return false, fmt.Errorf("%w: event of %d bytes > max_event_kb %d", errOutputLimit, size, l.eventBytes>>10) }
+		return false, nil
+	}
+	if l.sent++; l.events > 0 && l.sent > l.events {
+		outputLimited.WithLabelValues("events").Inc()
+		if l.kill { return false, fmt.Errorf("%w: more than max_events %d", errOutputLimit, l.events) }
+		return false, nil
+	}
+	return true, nil
+}
+
+// stdoutCap buffers module stdout up to max bytes. Past it, kill cancels
+// the run; either way further writes are swallowed (reported as written so
+// guests don't spin on short writes).
+type stdoutCap struct {
+	buf  bytes.Buffer
+	max  int64
+	kill context.CancelFunc // nil = throttle
+	over bool
+}
+
+func (w *stdoutCap) Write(p []byte) (int, error) {
+	if w.max <= 0 || int64(w.buf.Len()+len(p)) <= w.max { return w.buf.Write(p) }
+	if room := w.max - int64(w.buf.Len()); room > 0 { w.buf.Write(p[:room]) }
+	if !w.over {
+		w.over = true
+		outputLimited.WithLabelValues("stdout").Inc()
+		if w.kill != nil { w.kill() }
+	}
+	return len(p), nil
+}
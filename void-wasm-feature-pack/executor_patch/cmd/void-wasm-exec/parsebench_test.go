@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// --- Allocation benchmarks for the stdout hot path ---
+// The old per-line map decode against stdoutFrame on the shapes modules
+// print most: go test -bench StdoutFrame -run '^$'
+
+var parseSamples = []struct{ name, line string }{
+	{"event", `{"type":"wasm.result","module":"hp","data":{"ok":true,"n":42,"tags":["a","b"]}}`},
+	{"emit", `{"type":"syscall.emit","id":"e1","event":{"type":"wasm.progress","pct":50,"msg":"half way"}}`},
+	{"kv", `{"type":"syscall.kv.get","id":"k1","key":"counter"}`},
+}
+
+func BenchmarkStdoutFrameMap(b *testing.B) {
+	benchParse(b, func(line []byte) { var m map[string]any; json.Unmarshal(line, &m) })
+}
+
+func BenchmarkStdoutFrame(b *testing.B) {
+	benchParse(b, func(line []byte) { stdoutFrame(line) })
+}
+
+func benchParse(b *testing.B, fn func([]byte)) {
+	for _, s := range parseSamples {
+		line := []byte(s.line)
+		if _, ok := stdoutFrame(line); !ok { b.Fatalf("sample %s does not parse", s.name) }
+		b.Run(s.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ { fn(line) }
+		})
+	}
+}
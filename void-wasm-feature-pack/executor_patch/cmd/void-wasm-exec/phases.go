@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// --- Run phases ---
+// void_wasm_phase_ms{phase,module,start} splits a run's latency into
+//   policy       allowlist, schedule, quota, variant, mount and env checks
+//   fetch        module download (or cache lookup) and input references
+//   verify       sha256 and GitHub release provenance
+//   compile      wazero compile, or the module LRU lookup
+//   instantiate  imports, memory and WASI set up, no guest code yet
+//   execute      _start plus the syscalls it asked for
+// start is cold when the run downloaded or compiled its module, warm when
+// both came from cache. Each run's phases are also in its record as
+// phases_ms. Phases a run never reached are not observed.
+
+var phaseBuckets = []float64{1, 2, 5, 10, 20, 50, 100, 200, 300, 500, 1000, 2000, 5000}
+
+var phaseMs = newPhaseMs(false)
+
+// newPhaseMs builds void_wasm_phase_ms, with native buckets like
+// void_wasm_duration_ms when native is set.
+func newPhaseMs(native bool) *prometheus.HistogramVec {
+	opts := prometheus.HistogramOpts{Name: "void_wasm_phase_ms", Help: "Run latency ms by phase (policy, fetch, verify, compile, instantiate, execute), module and start (cold, warm)", Buckets: phaseBuckets}
+	if native {
+		opts.NativeHistogramBucketFactor = 1.1
+		opts.NativeHistogramMaxBucketNumber = 160
+	}
+	return prometheus.NewHistogramVec(opts, []string{"phase", "module", "start"})
+}
+
+var phaseNames = []string{"policy", "fetch", "verify", "compile", "instantiate", "execute"}
+
+// runPhases is the time a run spent in each phase; a nil *runPhases drops
+// everything (local tools).
+type runPhases struct {
+	d    map[string]time.Duration
+	cold bool
+}
+
+func (p *runPhases) add(phase string, d time.Duration) {
+	if p == nil { return }
+	if p.d == nil { p.d = map[string]time.Duration{} }
+	p.d[phase] += d
+}
+
+// coldStart marks the run as having downloaded or compiled its module.
+func (p *runPhases) coldStart() { if p != nil { p.cold = true } }
+
+// since adds the time since t0 to phase.
+func (p *runPhases) since(phase string, t0 time.Time) { p.add(phase, time.Since(t0)) }
+
+// observePhases puts rec's phases in phases_ms and void_wasm_phase_ms.
+func observePhases(rec *RunRecord) {
+	if len(rec.phases.d) == 0 { return }
+	start := "warm"
+	if rec.phases.cold { start = "cold" }
+	rec.Phases = make(map[string]int64, len(rec.phases.d))
+	for _, name := range phaseNames {
+		d, ok := rec.phases.d[name]
+		if !ok { continue }
+		rec.Phases[name] = d.Milliseconds()
+		phaseMs.WithLabelValues(name, rec.Module, start).Observe(float64(d.Microseconds()) / 1000)
+	}
+}
+
+// runStart calls the module's _start; a module without one (a reactor) has
+// nothing to run. proc_exit(0) is success, as it is for InstantiateModule.
+func runStart(ctx context.Context, mod api.Module) error {
+	start := mod.ExportedFunction("_start")
+	if start == nil { return nil }
+	_, err := start.Call(ctx)
+	var exit *sys.ExitError
+	if errors.As(err, &exit) && exit.ExitCode() == 0 { return nil }
+	return err
+}
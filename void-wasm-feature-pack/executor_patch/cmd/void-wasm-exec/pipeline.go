@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Staged envelope pipeline ---
+// policy pool -> fetch+verify pool -> exec pool, joined by bounded channels.
+// Policy runs first so denied modules are never downloaded; slow downloads
+// occupy fetch workers only, so modules already on disk go straight on to
+// the exec pool (sized by concurrency). A full policy queue blocks SSE
+// intake, which is the backpressure.
+
+var (
+	stageWaitMs = prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "void_wasm_stage_wait_ms", Help: "Time queued before a stage", Buckets: []float64{1,5,20,50,100,250,500,1000,2500,5000,10000}}, []string{"stage"})
+	stageMs     = prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "void_wasm_stage_ms", Help: "Time spent in a stage", Buckets: []float64{1,5,20,50,100,250,500,1000,2500,5000,10000}}, []string{"stage"})
+)
+
+var policyQ, fetchQ, execQ chan *job
+
+// job is one envelope moving through the stages; one goroutine owns it at a time.
+type job struct {
+	cfg        Config
+	env        *Envelope
+	rec        *RunRecord
+	modPath    string
+	variant    *ModuleVariant // nil = the envelope's own url/cid/sha256
+	t0         time.Time
+	queuedAt   time.Time
+	queued     bool
+	group      string // concurrency group, "" = none (groups.go)
+	groupLimit int
+	hasSlot    bool
+	parkedAt   time.Time
+	unpark     func() bool // stops the cancel watch of a parked run (groups.go)
+	runCtx     context.Context
+	stop       context.CancelFunc
+	untrack    func()
+}
+
+func stageQueues() []prometheus.Collector {
+	depth := func(stage string, q *chan *job) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: "void_wasm_stage_queue", Help: "Jobs waiting for a stage", ConstLabels: prometheus.Labels{"stage": stage}}, func() float64 { n := len(*q); if q == &execQ { n += fairLen() }; return float64(n) })
+	}
+	return []prometheus.Collector{depth("policy", &policyQ), depth("fetch", &fetchQ), depth("exec", &execQ)}
+}
+
+func startPipeline(cfg Config) {
+	policyQ = make(chan *job, cfg.StageQueue)
+	fetchQ = make(chan *job, cfg.StageQueue)
+	execQ = make(chan *job, cfg.StageQueue)
+	if cfg.FairQueue != "off" { startFair(cfg) } // fair.go
+	worker := func(stage string, q chan *job, fn func(*job)) {
+		for j := range q {
+			stageWaitMs.WithLabelValues(stage).Observe(float64(time.Since(j.queuedAt).Milliseconds()))
+			t := time.Now()
+			fn(j)
+			stageMs.WithLabelValues(stage).Observe(float64(time.Since(t).Milliseconds()))
+		}
+	}
+	for i := 0; i < cfg.PolicyWorkers; i++ { go worker("policy", policyQ, policyStage) }
+	for i := 0; i < cfg.FetchWorkers; i++ { go worker("fetch", fetchQ, fetchStage) }
+	for i := 0; i < cfg.Concurrency; i++ { go worker("exec", execQ, execStage) }
+}
+
+// enqueue admits an envelope into the pipeline; quota defer and envelopes
+// outside their module's schedule wait off-pipeline, the latter at most
+// schedule_hold_max at a time (false: refused, scheduled_full).
+func enqueue(cfg Config, env *Envelope, wait time.Duration) bool {
+	d, hold := quotaDelay(cfg, env)+wait, scheduleWait(cfg, env) > 0
+	if hold && !holdSchedule(cfg, env) { return false }
+	runsQueued.Add(1)
+	j := &job{cfg: cfg, env: env, queued: true}
+	if hold { scheduleTotal.WithLabelValues("defer").Inc() }
+	if d > 0 || hold {
+		go func() {
+			if hold { defer scheduleHeld.Add(-1) }
+			time.Sleep(d)
+			for w := scheduleWait(currentConfig(), env); w > 0; w = scheduleWait(currentConfig(), env) { time.Sleep(w) }
+			j.queuedAt = time.Now(); policyQ <- j
+		}()
+		return true
+	}
+	j.queuedAt = time.Now()
+	policyQ <- j
+	return true
+}
+
+func (j *job) next(q chan *job) { j.queuedAt = time.Now(); q <- j }
+
+// finish books the run once, whichever stage ends it.
+func (j *job) finish() {
+	if j.queued { runsQueued.Add(-1); j.queued = false }
+	rec := j.rec
+	rec.TotalMs = time.Since(j.t0).Milliseconds()
+	rec.Budget = rec.budget.report()
+	observePhases(rec)
+	fairDone(j)
+	rec.inputs.cleanup()
+	fmt.Printf("[run] %s module=%s tenant=%s path=%s result=%s ms=%d %s\n", rec.ID, rec.Module, rec.Tenant, rec.Path, rec.Result, rec.TotalMs, rec.corr())
+	recordSLO(rec.Result, rec.TotalMs)
+	observePath(rec.Path, rec.Result, rec.RunMs)
+	tenantRuns.WithLabelValues(rec.Tenant, rec.Result).Inc()
+	recordUsage(rec)
+	writeRecording(j.cfg, rec)
+	if j.cfg.Attest != "" { attestRun(j.cfg, rec, j.modPath) }
+	signReceipt(j.cfg, rec)
+	if ledger != nil { ledger.add(j.cfg, rec) }
+	if j.cfg.EventSink == "grpc" { publishReceipt(j.cfg, rec) }
+	if j.cfg.IPFSPublish != "" { publishResult(j.cfg, rec) }
+	if len(j.cfg.Webhooks) > 0 { fireWebhooks(j.cfg, rec) }
+	annotateRollout(j.cfg, rec)
+	history.Put(rec)
+	intentDone(j.env, rec)
+	streamRun(rec)
+	runBooked(rec)
+	j.untrack()
+	j.stop()
+}
+
+func policyStage(j *job) {
+	env := j.env
+	moduleName := env.Module
+	if moduleName == "" { moduleName = "unknown" }
+	j.t0 = time.Now()
+	cfg, tenantOK := j.cfg.forTenant(tenantOf(env))
+	cfg, mp := cfg.forModule(moduleName)
+	j.cfg = cfg
+	j.rec = &RunRecord{ID: newRunID(j.t0), Module: moduleName, Tenant: cfg.Tenant, SHA256: env.SHA256, Envelope: env, Started: j.t0, Path: selectPath(cfg, env)}
+	rec := j.rec
+	var corrOK bool
+	if rec.Correlation, corrOK = correlationID(env, rec.ID); !corrOK { rec.decide("correlation=invalid") }
+	intentStarted(env, rec.ID)
+	runStarted(env, rec.ID)
+	if cfg.RecordDir != "" { rec.tape = &runTape{} }
+	rec.budget = newRunBudget(env.Budget, j.t0)
+	j.runCtx, j.stop = context.WithCancel(context.Background())
+	j.untrack = trackRun(rec, j.stop)
+
+	if !tenantOK {
+		fmt.Println("[policy] deny tenant", cfg.Tenant, rec.corr())
+		policyDenied.Inc()
+		rec.decide("tenant=deny"); rec.Result = "deny_tenant"
+		j.finish(); return
+	}
+	if !allowed(moduleName, cfg.AllowModules) {
+		fmt.Println("[policy] deny module", moduleName, rec.corr())
+		policyDenied.Inc()
+		rec.decide("allowlist=deny"); rec.Result = "deny_allowlist"
+		j.finish(); return
+	}
+	rec.decide("allowlist=allow")
+	if mp != nil { rec.decide("policy=" + mp.File) }
+	if s := cfg.scheduleFor(moduleName); s.deny() && !s.open(cfg, time.Now()) {
+		fmt.Println("[policy] outside schedule", moduleName, s.source, rec.corr())
+		scheduleTotal.WithLabelValues("deny").Inc()
+		denySchedule(cfg, rec, s)
+		j.finish(); return
+	}
+	if over := quotaCheck(cfg, moduleName); over != "" {
+		quotaExceeded.WithLabelValues(cfg.Tenant, over).Inc()
+		rec.decide("quota=" + over)
+		if cfg.QuotaAction == "deny" {
+			fmt.Println("[policy] quota exhausted", moduleName, over, rec.corr())
+			rec.Result = "deny_quota"
+			j.finish(); return
+		}
+	}
+	if v, err := selectVariant(cfg, env.Variants); err != nil {
+		fmt.Println("[policy] no target", moduleName, err, rec.corr())
+		runsTotal.WithLabelValues("no_target", moduleName).Inc()
+		rec.decide("target=none"); rec.Result = "no_target"; rec.Error = err.Error()
+		j.finish(); return
+	} else if v != nil {
+		j.variant, rec.SHA256, rec.Target, rec.Opt = v, v.SHA256, v.Target, v.Opt
+		rec.decide("target=" + v.Target + "/" + v.Opt)
+	}
+	if len(env.Mounts) > 0 && !mountPolicy(cfg, env, rec) {
+		fmt.Println("[policy] deny", moduleName, rec.Error, rec.corr())
+		policyDenied.Inc()
+		rec.Result = "deny_mount"
+		j.finish(); return
+	}
+	if len(env.Env) > 0 && !guestEnvPolicy(cfg, env, rec) {
+		fmt.Println("[policy] deny", moduleName, rec.Error, rec.corr())
+		policyDenied.Inc()
+		rec.Result = "deny_env"
+		j.finish(); return
+	}
+	if j.group, j.groupLimit = groupFor(cfg, env, moduleName); j.group != "" { rec.decide("group=" + j.group) }
+	rec.phases.since("policy", j.t0)
+	j.next(fetchQ)
+}
+
+func fetchStage(j *job) {
+	rec := j.rec
+	t := time.Now()
+	src, err := registrySource(j.cfg, variantSource(j.env, j.variant), rec)
+	if err != nil {
+		fmt.Println("[wasm] resolve error:", err, rec.corr())
+		runsTotal.WithLabelValues("resolve_error", rec.Module).Inc()
+		rec.decide("registry=error"); rec.Result = "resolve_error"; rec.Error = err.Error()
+		j.finish(); return
+	}
+	path, pulled, err := fetchModule(j.cfg, src, &rec.phases)
+	rec.phases.add("fetch", time.Since(t)-rec.phases.d["verify"])
+	if pulled > 0 { rec.phases.coldStart() }
+	rec.FetchedBytes = pulled
+	rec.FetchMs = time.Since(j.t0).Milliseconds()
+	if err != nil {
+		fmt.Println("[wasm] fetch error:", err, rec.corr())
+		runsTotal.WithLabelValues("download_error", rec.Module).Inc()
+		rec.decide("fetch=error"); rec.Result = "download_error"; rec.Error = err.Error()
+		j.finish(); return
+	}
+	t = time.Now()
+	in, n, err := resolveInputs(j.cfg, j.env, rec.ID)
+	rec.phases.since("fetch", t)
+	rec.FetchedBytes += n
+	if err != nil {
+		fmt.Println("[wasm] inputs:", err, rec.corr())
+		runsTotal.WithLabelValues("input_error", rec.Module).Inc()
+		rec.decide("inputs=error"); rec.Result = "input_error"; rec.Error = err.Error()
+		j.finish(); return
+	}
+	rec.inputs = in
+	rec.decide("fetch=ok")
+	j.modPath = path
+	toExec(j)
+}
+
+func execStage(j *job) {
+	if !acquireGroup(j) { return } // parked until a run in its group finishes
+	cfg, rec, env := j.cfg, j.rec, j.env
+	if j.queued { runsQueued.Add(-1); j.queued = false }
+	defer j.finish()
+	defer releaseGroup(j)
+	if shuttingDown.Load() { runsTotal.WithLabelValues("shutdown", rec.Module).Inc(); rec.Result = "shutdown"; return }
+	if j.runCtx.Err() != nil { runsTotal.WithLabelValues("canceled", rec.Module).Inc(); rec.Result = "canceled"; return }
+	rec.decide("path=" + rec.Path)
+	if cfg.DryRun {
+		fmt.Println("[wasm] DRYRUN would run", rec.Module, "from", j.modPath, rec.corr())
+		runsTotal.WithLabelValues("dryrun", rec.Module).Inc()
+		rec.Result = "dryrun"
+		return
+	}
+
+	defer execGate()()
+	if left, ok := rec.budget.wallLeft(); ok && left <= 0 { // spent queued or downloading
+		rec.budget.exhaust(budgetWall)
+		runsTotal.WithLabelValues("budget_exhausted", rec.Module).Inc()
+		rec.Result, rec.Error = "budget_exhausted", rec.budget.err().Error()
+		return
+	}
+	ctx, cancel := context.WithTimeout(j.runCtx, cfg.DefaultTO)
+	defer cancel()
+	activeGauge.Inc()
+	defer activeGauge.Dec()
+
+	start := time.Now()
+	err := runWasm(ctx, cfg, j.modPath, env, rec)
+	rec.RunMs = time.Since(start).Milliseconds()
+	recordRunLatency(rec.RunMs)
+	observeRun(rec.Module, float64(rec.RunMs), traceID(env, rec.ID), rec.Correlation)
+	if err != nil {
+		fmt.Println("[wasm] run error:", err, rec.corr())
+		result := "error"
+		if errors.Is(j.runCtx.Err(), context.Canceled) { result = "canceled" }
+		if errors.Is(err, errOutputLimit) { result = "output_limit" }
+		if errors.Is(err, errMountQuota) { result = "mount_quota" }
+		if err := rec.budget.err(); err != nil { result = "budget_exhausted"; rec.decide("budget=" + rec.budget.report().Exhausted) }
+		runsTotal.WithLabelValues(result, rec.Module).Inc()
+		rec.Result = result; rec.Error = err.Error()
+		return
+	}
+	runsTotal.WithLabelValues("ok", rec.Module).Inc()
+	rec.Result = "ok"
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Placement constraints ---
+// An executor advertises node_labels (region=eu, tier=edge) in its
+// heartbeat; an envelope's placement lists key=value tags that must all
+// match this node's labels, else intake skips it like a shard it does not
+// own: SSE leaves it to the other nodes, POST /admin/envelopes answers 503
+// not_placed so the sender retries elsewhere. An envelope without placement
+// runs anywhere; node=<id> matches the node id unless node_labels sets node.
+// Placement is checked before sharding, so shard_nodes should
+// only list nodes that share the labels envelopes ask for.
+
+const maxPlacement = 16
+
+var (
+	placementSkipped = prometheus.NewCounter(prometheus.CounterOpts{Name: "void_wasm_placement_skipped_total", Help: "Envelopes whose placement tags this node's labels do not match"})
+	placementKey     = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,62}$`)
+	placementValue   = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,62}$`)
+)
+
+// parseLabels reads "region=eu,tier=edge" (NODE_LABELS).
+func parseLabels(s string) map[string]string {
+	out := map[string]string{}
+	for _, kv := range parseList(s) {
+		k, v, _ := strings.Cut(kv, "=")
+		out[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return out
+}
+
+func validLabels(labels map[string]string) error {
+	var errs []error
+	for k, v := range labels {
+		if !placementKey.MatchString(k) || !placementValue.MatchString(v) { errs = append(errs, fmt.Errorf("invalid label %q=%q", k, v)) }
+	}
+	return errors.Join(errs...)
+}
+
+func validPlacement(tags []string) error {
+	if len(tags) > maxPlacement { return fmt.Errorf("more than %d tags", maxPlacement) }
+	for _, t := range tags {
+		k, v, ok := strings.Cut(t, "=")
+		if !ok || !placementKey.MatchString(k) || !placementValue.MatchString(v) { return fmt.Errorf("tag %q: want key=value", t) }
+	}
+	return nil
+}
+
+// unplaced returns the envelope's tags this node does not satisfy.
+func unplaced(cfg Config, env *Envelope) []string {
+	var miss []string
+	for _, t := range env.Placement {
+		k, v, _ := strings.Cut(t, "=")
+		got, ok := cfg.NodeLabels[k]
+		if k == "node" && !ok { got, ok = nodeID(cfg), true } // node=<id> targets one node (register.go)
+		if !ok || got != v { miss = append(miss, t) }
+	}
+	return miss
+}
+
+// labelList renders node_labels sorted, for logs and simulate.
+func labelList(labels map[string]string) string {
+	l := make([]string, 0, len(labels))
+	for k, v := range labels { l = append(l, k+"="+v) }
+	sort.Strings(l)
+	return strings.Join(l, ",")
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// --- Platform paths ---
+// Defaults for cache_dir, history_db, kv_bolt, the file KV, scratch_dir and
+// audit_log live under one home: /tmp/void on Linux (what the compose files
+// and container volumes expect), the user cache dir elsewhere
+// (~/Library/Caches/void on macOS, %LocalAppData%\void on Windows). VOID_HOME
+// moves it on any platform; explicit paths in the config still win. Per-run
+// scratch (the guest's /tmp and /out, resolved input references) goes under
+// os.TempDir().
+
+func voidHome() string {
+	if h := os.Getenv("VOID_HOME"); h != "" { return h }
+	if runtime.GOOS != "linux" {
+		if d, err := os.UserCacheDir(); err == nil { return filepath.Join(d, "void") }
+	}
+	return filepath.Join(os.TempDir(), "void")
+}
+
+// voidPath joins elem under voidHome.
+func voidPath(elem ...string) string { return filepath.Join(append([]string{voidHome()}, elem...)...) }
+
+// runTempDir creates a fresh per-run directory under os.TempDir()/void/kind.
+func runTempDir(kind string) (string, error) {
+	base := filepath.Join(os.TempDir(), "void", kind)
+	if err := os.MkdirAll(base, 0o755); err != nil { return "", err }
+	return os.MkdirTemp(base, "run-")
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v3"
+)
+
+// --- Per-module policy files (policies.d) ---
+// Every *.yaml in policies_dir holds one ModulePolicy: a module name or
+// prefix*, optionally scoped to some tenants, and the settings that differ
+// for it. Precedence, lowest first: global config, tenants.<name>, the one
+// policy file matching the run, then envelope limits (which can only
+// tighten). Only the most specific file applies, they do not stack: an
+// exact name beats a pattern, a longer pattern a shorter one, and a
+// tenant-scoped file one for every tenant. A file's max_concurrent /
+// mutex_group replace module_limits for that module.
+// schedule restricts when the module runs (schedule.go) and takes
+// precedence over the schedules list in the config. The directory is polled
+// and any change reloads the config like SIGHUP; a bad file rejects the
+// whole reload.
+
+type ModulePolicy struct {
+	Module  string   `yaml:"module"`  // name or prefix*
+	Tenants []string `yaml:"tenants"` // empty = every tenant
+
+	AllowCaps      []string      `yaml:"allow_caps"`
+	AllowHTTPHosts []string      `yaml:"allow_http_hosts"`
+	Timeout        time.Duration `yaml:"timeout"`
+	SoftTimeoutPct int           `yaml:"soft_timeout_pct"`
+	Clock          string        `yaml:"clock"`
+	HTTPRPS        int           `yaml:"http_rps"`
+	HTTPBurst      int           `yaml:"http_burst"`
+	MaxHTTPKB      int           `yaml:"http_max_kb"`
+	MaxStdoutKB    int           `yaml:"max_stdout_kb"`
+	MaxEvents      int           `yaml:"max_events"`
+	MaxEventKB     int           `yaml:"max_event_kb"`
+	OutputAction   string        `yaml:"output_action"`
+	IntakeRPS      int           `yaml:"intake_rps"`
+	IntakeBurst    int           `yaml:"intake_burst"`
+
+	MaxConcurrent int    `yaml:"max_concurrent"`
+	MutexGroup    string `yaml:"mutex_group"`
+
+	Schedule        []RunWindow `yaml:"schedule"`         // empty = any time
+	OutsideSchedule string      `yaml:"outside_schedule"` // defer (default) | deny
+
+	File string `yaml:"-"`
+}
+
+var (
+	modulePolicies = prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: "void_wasm_module_policies", Help: "Policy files loaded from policies_dir"}, func() float64 { return float64(len(currentConfig().Policies)) })
+	scheduleTotal  = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_schedule_total", Help: "Envelopes arriving outside their module's schedule by action"}, []string{"action"})
+)
+
+func policyFiles(dir string) []string {
+	var files []string
+	for _, pat := range []string{"*.yaml", "*.yml"} {
+		m, _ := filepath.Glob(filepath.Join(dir, pat))
+		files = append(files, m...)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// loadPolicies reads and checks every file in dir; a missing dir is empty.
+func loadPolicies(dir string) ([]ModulePolicy, error) {
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) { return nil, nil }
+	var out []ModulePolicy
+	var errs []error
+	seen := map[string]string{}
+	for _, path := range policyFiles(dir) {
+		f, err := os.Open(path)
+		if err != nil { errs = append(errs, err); continue }
+		var p ModulePolicy
+		dec := yaml.NewDecoder(f)
+		dec.KnownFields(true)
+		err = dec.Decode(&p)
+		f.Close()
+		if err != nil && !errors.Is(err, io.EOF) { errs = append(errs, fmt.Errorf("%s: %w", path, err)); continue }
+		p.File = filepath.Base(path)
+		if err := p.check(); err != nil { errs = append(errs, fmt.Errorf("%s: %w", path, err)); continue }
+		key := p.Module + "@" + strings.Join(p.Tenants, ",")
+		if prev, dup := seen[key]; dup { errs = append(errs, fmt.Errorf("%s: same module and tenants as %s", path, prev)); continue }
+		seen[key] = path
+		out = append(out, p)
+	}
+	return out, errors.Join(errs...)
+}
+
+func (p ModulePolicy) check() error {
+	var errs []error
+	if p.Module == "" || p.Module == "*" { errs = append(errs, errors.New("module: name or prefix* required")) }
+	for _, t := range p.Tenants {
+		if !tenantName.MatchString(t) { errs = append(errs, fmt.Errorf("tenants: invalid name %q", t)) }
+	}
+	if p.Timeout < 0 || p.MaxConcurrent < 0 || p.HTTPRPS < 0 || p.HTTPBurst < 0 || p.MaxHTTPKB < 0 || p.MaxStdoutKB < 0 || p.MaxEvents < 0 || p.MaxEventKB < 0 || p.IntakeRPS < 0 || p.IntakeBurst < 0 { errs = append(errs, errors.New("limits: must be >= 0")) }
+	if p.SoftTimeoutPct < 0 || p.SoftTimeoutPct > 99 { errs = append(errs, errors.New("soft_timeout_pct: must be 0..99")) }
+	if p.Clock != "" {
+		if err := validClock(p.Clock); err != nil { errs = append(errs, err) }
+	}
+	if p.OutputAction != "" && p.OutputAction != "kill" && p.OutputAction != "throttle" { errs = append(errs, fmt.Errorf("output_action: must be kill or throttle, got %q", p.OutputAction)) }
+	if p.OutsideSchedule != "" && p.OutsideSchedule != "defer" && p.OutsideSchedule != "deny" { errs = append(errs, fmt.Errorf("outside_schedule: must be defer or deny, got %q", p.OutsideSchedule)) }
+	for i, w := range p.Schedule {
+		if err := w.check(); err != nil { errs = append(errs, fmt.Errorf("schedule[%d]: %w", i, err)) }
+	}
+	return errors.Join(errs...)
+}
+
+// modulePolicy picks the most specific file for module in c's tenant.
+func (c Config) modulePolicy(module string) *ModulePolicy {
+	var best *ModulePolicy
+	bestScore := -1
+	for i, p := range c.Policies {
+		if !allowed(module, []string{p.Module}) { continue }
+		if len(p.Tenants) > 0 && !allowed(c.Tenant, p.Tenants) { continue }
+		score := len(p.Module) * 2
+		if p.Module == module { score = 1 << 20 }
+		if len(p.Tenants) > 0 { score++ }
+		if score > bestScore { best, bestScore = &c.Policies[i], score }
+	}
+	return best
+}
+
+// forModule applies module's policy file on top of c (already resolved by
+// forTenant).
+func (c Config) forModule(module string) (Config, *ModulePolicy) {
+	p := c.modulePolicy(module)
+	if p == nil { return c, nil }
+	if p.AllowCaps != nil { c.AllowCaps = p.AllowCaps }
+	if p.AllowHTTPHosts != nil { c.AllowHTTPHosts = p.AllowHTTPHosts }
+	if p.Timeout > 0 { c.DefaultTO = p.Timeout }
+	if p.SoftTimeoutPct > 0 { c.SoftTimeoutPct = p.SoftTimeoutPct }
+	if p.Clock != "" { c.Clock = p.Clock }
+	if p.HTTPRPS > 0 { c.HTTPRPS, c.HTTPBurst = p.HTTPRPS, p.HTTPBurst }
+	if p.MaxHTTPKB > 0 { c.MaxHTTPKB = p.MaxHTTPKB }
+	if p.MaxStdoutKB > 0 { c.MaxStdoutKB = p.MaxStdoutKB }
+	if p.MaxEvents > 0 { c.MaxEvents = p.MaxEvents }
+	if p.MaxEventKB > 0 { c.MaxEventKB = p.MaxEventKB }
+	if p.OutputAction != "" { c.OutputAction = p.OutputAction }
+	if p.IntakeRPS > 0 { c.IntakeRPS, c.IntakeBurst = p.IntakeRPS, p.IntakeBurst }
+	if p.MaxConcurrent > 0 || p.MutexGroup != "" {
+		c.ModuleLimits = maps.Clone(c.ModuleLimits)
+		if c.ModuleLimits == nil { c.ModuleLimits = map[string]ModuleLimit{} }
+		c.ModuleLimits[module] = ModuleLimit{MaxConcurrent: p.MaxConcurrent, MutexGroup: p.MutexGroup}
+	}
+	return c, p
+}
+
+// watchPolicies reloads the config when a file in policies_dir is added,
+// removed or rewritten.
+func watchPolicies(dir string) {
+	sig := func() string {
+		var b strings.Builder
+		for _, f := range policyFiles(dir) {
+			if st, err := os.Stat(f); err == nil { fmt.Fprintf(&b, "%s@%d@%d;", f, st.Size(), st.ModTime().UnixNano()) }
+		}
+		return b.String()
+	}
+	last := sig()
+	for range time.Tick(5 * time.Second) {
+		cur := sig()
+		if cur == last { continue }
+		last = cur
+		fmt.Println("[policy] policies_dir changed, reloading")
+		if _, err := reloadConfig(); err != nil { fmt.Println("[config] reload rejected:", err) }
+	}
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	bolt "go.etcd.io/bbolt"
+)
+
+// --- Durable work queues (syscall.queue.*, void.queue_pop, cap queue) ---
+// queue_backend bbolt (queue_bolt) or nats (a JetStream work-queue stream,
+// queue_nats_stream, on nats_url) keeps named queues per tenant that outlive
+// runs, so a module can leave follow-up work for a later run of itself or of
+// another module:
+//   syscall.queue.push {"queue","body","id"?}     -> sysret.queue.push {"id","queue","ok","msg_id"|"error"}
+//   syscall.queue.ack  {"queue","msg_id","receipt"} -> sysret.queue.ack {"queue","msg_id","ok","error"?}
+// Like the other stdout syscalls they apply after the module exits, so a
+// run's pushes land only once it has finished. Taking work has to answer the
+// running module, so it is a host function: void.queue_pop(name_ptr,
+// name_len, buf_ptr, buf_cap, wait_ms) waits up to wait_ms for a message
+// and writes {"queue","msg_id","receipt","body","run","module","pushed",
+// "receives"}; it returns the length, 0 when the queue stayed empty, -1
+// without cap queue or a backend, -2 for a bad queue name, -3 when the
+// message does not fit the buffer (it goes back to the queue), -4 on a store
+// error. A popped message is hidden for the queue's visibility timeout; ack
+// it with the receipt before that or it is delivered again (receives
+// counts deliveries), and an ack after someone else received it fails with
+// stale. queue_limits (name or prefix*) set max_depth (push answers full),
+// max_kb per body (too_large) and visibility per queue. With nats the
+// visibility is the consumer's ack wait, fixed when the queue is first
+// popped.
+
+var queueName = tenantName
+
+type QueueLimit struct {
+	MaxDepth   int           `yaml:"max_depth"`
+	MaxKB      int           `yaml:"max_kb"`
+	Visibility time.Duration `yaml:"visibility"`
+}
+
+// queue_pop results
+const (
+	queueDenied   = -1
+	queueBadName  = -2
+	queueTooSmall = -3
+	queueIOErr    = -4
+)
+
+var (
+	errQueueFull  = errors.New("full")
+	errQueueStale = errors.New("stale")
+)
+
+var (
+	queueOps   = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_queue_ops_total", Help: "Queue syscalls by op (push, pop, ack) and result"}, []string{"op", "result"})
+	queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "void_wasm_queue_depth", Help: "Messages in a queue, in flight included, as of its last push or ack"}, []string{"tenant", "queue"})
+)
+
+// queueMsg is one message; Receipt is set while it is in flight.
+type queueMsg struct {
+	Queue    string    `json:"queue"`
+	ID       string    `json:"msg_id"`
+	Receipt  string    `json:"receipt,omitempty"`
+	Body     any       `json:"body"`
+	Run      string    `json:"run,omitempty"`
+	Module   string    `json:"module,omitempty"`
+	Pushed   time.Time `json:"pushed"`
+	Receives int       `json:"receives"`
+}
+
+type queueStore interface {
+	push(tenant string, m queueMsg, maxDepth int) (string, error)
+	pop(ctx context.Context, tenant, queue string, vis, wait time.Duration) (*queueMsg, error) // nil when empty
+	ack(tenant, queue, id, receipt string) error
+	nack(tenant, queue, id, receipt string) error
+	depth(tenant, queue string) (int, error)
+	close() error
+}
+
+var queues queueStore // nil = queue_backend off
+
+func openQueue(cfg Config) (queueStore, error) {
+	switch cfg.QueueBackend {
+	case "off":
+		return nil, nil
+	case "bbolt":
+		os.MkdirAll(filepath.Dir(cfg.QueueBolt), 0o755)
+		db, err := bolt.Open(cfg.QueueBolt, 0o600, &bolt.Options{Timeout: time.Second})
+		if err != nil { return nil, fmt.Errorf("queue_bolt %s: %w", cfg.QueueBolt, err) }
+		return boltQueue{db: db, sig: &queueSignal{}}, nil
+	case "nats":
+		q, err := openNATSQueue(cfg)
+		if err != nil { return nil, err }
+		return q, nil
+	}
+	return nil, fmt.Errorf("unknown queue backend %q", cfg.QueueBackend)
+}
+
+// queueLimit is queue's entry in queue_limits (exact name, else the longest
+// prefix*) over the queue_* defaults.
+func queueLimit(cfg Config, queue string) QueueLimit {
+	l, ok := cfg.QueueLimits[queue]
+	best := -1
+	for pat, pl := range cfg.QueueLimits {
+		if !ok && len(pat) > best && allowed(queue, []string{pat}) { l, best = pl, len(pat) }
+	}
+	if l.MaxDepth == 0 { l.MaxDepth = cfg.QueueMaxDepth }
+	if l.MaxKB == 0 { l.MaxKB = cfg.QueueMaxKB }
+	if l.Visibility == 0 { l.Visibility = cfg.QueueVisibility }
+	return l
+}
+
+func receipt() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func observeQueueDepth(tenant, queue string) {
+	if n, err := queues.depth(tenant, queue); err == nil { queueDepth.WithLabelValues(tenant, queue).Set(float64(n)) }
+}
+
+// queueSyscall handles syscall.queue.push and syscall.queue.ack.
+func queueSyscall(cfg Config, rec *RunRecord, kind string, payload map[string]any) string {
+	op := kind[len("syscall.queue."):]
+	result := queueOp(cfg, rec, op, payload)
+	queueOps.WithLabelValues(op, result).Inc()
+	return result
+}
+
+func queueOp(cfg Config, rec *RunRecord, op string, payload map[string]any) string {
+	if !allowed("queue", cfg.AllowCaps) || queues == nil { return "denied" }
+	q, _ := payload["queue"].(string)
+	if !queueName.MatchString(q) { return "bad_queue" }
+	lim := queueLimit(cfg, q)
+	reply := map[string]any{"type": "sysret.queue." + op, "queue": q}
+	fail := func(result string) string { reply["ok"], reply["error"] = false, result; sysret(cfg, rec, reply); return result }
+	switch op {
+	case "push":
+		if id, ok := payload["id"].(string); ok { reply["id"] = id }
+		b, _ := json.Marshal(payload["body"])
+		if len(b) > lim.MaxKB<<10 { return fail("too_large") }
+		id, err := queues.push(cfg.Tenant, queueMsg{Queue: q, Body: payload["body"], Run: rec.ID, Module: rec.Module, Pushed: time.Now().UTC()}, lim.MaxDepth)
+		if errors.Is(err, errQueueFull) { return fail("full") }
+		if err != nil { fmt.Println("[queue] push:", err, rec.corr()); return fail("io_err") }
+		reply["ok"], reply["msg_id"] = true, id
+	case "ack":
+		id, _ := payload["msg_id"].(string)
+		r, _ := payload["receipt"].(string)
+		reply["msg_id"] = id
+		err := queues.ack(cfg.Tenant, q, id, r)
+		if errors.Is(err, errQueueStale) { return fail("stale") }
+		if err != nil { fmt.Println("[queue] ack:", err, rec.corr()); return fail("io_err") }
+		reply["ok"] = true
+	default:
+		return "unknown"
+	}
+	sysret(cfg, rec, reply)
+	observeQueueDepth(cfg.Tenant, q)
+	return "ok"
+}
+
+// runQueue is what void.queue_pop needs to know about the calling run.
+type runQueue struct {
+	cfg   Config
+	rec   *RunRecord
+	start time.Time
+}
+
+type queueRunKey struct{}
+
+func withQueue(ctx context.Context, cfg Config, rec *RunRecord, start time.Time) context.Context {
+	return context.WithValue(ctx, queueRunKey{}, &runQueue{cfg, rec, start})
+}
+
+func (rq *runQueue) pop(ctx context.Context, queue string, wait time.Duration, max int) ([]byte, int32) {
+	if !allowed("queue", rq.cfg.AllowCaps) || queues == nil { return nil, queueDenied }
+	if !queueName.MatchString(queue) { return nil, queueBadName }
+	m, err := queues.pop(ctx, rq.cfg.Tenant, queue, queueLimit(rq.cfg, queue).Visibility, wait)
+	if err != nil { fmt.Println("[queue] pop:", err, rq.rec.corr()); return nil, queueIOErr }
+	if m == nil { return nil, 0 }
+	b, _ := json.Marshal(m)
+	if len(b) > max {
+		if err := queues.nack(rq.cfg.Tenant, queue, m.ID, m.Receipt); err != nil { fmt.Println("[queue] nack:", err, rq.rec.corr()) }
+		return nil, queueTooSmall
+	}
+	return b, int32(len(b))
+}
+
+// exportQueue adds queue_pop to the void host module.
+func exportQueue(b wazero.HostModuleBuilder) {
+	i32 := api.ValueTypeI32
+	b.NewFunctionBuilder().
+		WithGoModuleFunction(api.GoModuleFunc(func(ctx context.Context, mod api.Module, stack []uint64) {
+			t0 := time.Now()
+			rq, _ := ctx.Value(queueRunKey{}).(*runQueue)
+			nptr, nlen := api.DecodeU32(stack[0]), api.DecodeU32(stack[1])
+			ptr, max, ms := api.DecodeU32(stack[2]), api.DecodeU32(stack[3]), api.DecodeI32(stack[4])
+			if rq == nil { stack[0] = api.EncodeI32(queueDenied); return }
+			if !rq.rec.budget.spend(budgetSyscalls, 1) { stack[0] = api.EncodeI32(queueDenied); return }
+			raw, ok := mod.Memory().Read(nptr, nlen)
+			name := string(raw)
+			res := int32(queueBadName)
+			var b []byte
+			if ok {
+				if ms < 0 { ms = 0 }
+				b, res = rq.pop(ctx, name, time.Duration(ms)*time.Millisecond, int(max))
+			}
+			if b != nil && !mod.Memory().Write(ptr, b) { res = queueTooSmall }
+			stack[0] = api.EncodeI32(res)
+			result := map[int32]string{0: "empty", queueDenied: "denied", queueBadName: "bad_queue", queueTooSmall: "too_small", queueIOErr: "io_err"}[res]
+			if result == "" { result = "ok" }
+			queueOps.WithLabelValues("pop", result).Inc()
+			sysReqTotal.WithLabelValues("syscall.queue.pop", result).Inc()
+			rq.rec.traceSyscall(rq.cfg.TimelineMax, rq.start, "syscall.queue.pop", name, result, t0)
+		}), []api.ValueType{i32, i32, i32, i32, i32}, []api.ValueType{i32}).
+		Export("queue_pop")
+}
+
+func validQueue(c Config) error {
+	var errs []error
+	switch c.QueueBackend {
+	case "off", "nats":
+	case "bbolt":
+		if c.QueueBolt == "" || c.QueueBolt == c.KVBolt || c.QueueBolt == c.HistoryDB { errs = append(errs, errors.New("queue_bolt: required for queue_backend bbolt, a file of its own")) }
+	default:
+		errs = append(errs, fmt.Errorf("queue_backend: must be off, bbolt or nats, got %q", c.QueueBackend))
+	}
+	if c.QueueBackend == "nats" && (c.NATSURL == "" || !natsBucket.MatchString(c.QueueNATSStream) || c.QueueNATSReplicas < 1 || c.QueueNATSReplicas > 5) { errs = append(errs, errors.New("queue_backend nats: needs nats_url, queue_nats_stream [A-Za-z0-9_-]+, queue_nats_replicas 1..5")) }
+	if c.QueueMaxDepth < 1 || c.QueueMaxKB < 1 || c.QueueVisibility < time.Second { errs = append(errs, errors.New("queue: queue_max_depth and queue_max_kb >= 1, queue_visibility >= 1s")) }
+	for pat, l := range c.QueueLimits {
+		if l.MaxDepth < 0 || l.MaxKB < 0 || (l.Visibility != 0 && l.Visibility < time.Second) { errs = append(errs, fmt.Errorf("queue_limits[%s]: max_depth, max_kb >= 0, visibility >= 1s", pat)) }
+	}
+	return errors.Join(errs...)
+}
+
+// --- bbolt ---
+// One bucket per tenant/queue, keyed by the bucket sequence, so messages
+// come out in push order; the stored value carries when it is visible again.
+// pop looks for a visible message in a read transaction and takes the write
+// lock only to claim it; an empty queue waits for a push or nack on that
+// bucket (queueSignal) or for the first in-flight message to time out.
+type boltQueue struct {
+	db  *bolt.DB
+	sig *queueSignal
+}
+
+// queueSignal wakes the pops waiting on a bucket: each bucket has a channel
+// that is closed and replaced when a message becomes visible there.
+type queueSignal struct {
+	mu sync.Mutex
+	ch map[string]chan struct{}
+}
+
+func (s *queueSignal) wait(bucket string) <-chan struct{} {
+	s.mu.Lock(); defer s.mu.Unlock()
+	if s.ch == nil { s.ch = map[string]chan struct{}{} }
+	c, ok := s.ch[bucket]
+	if !ok { c = make(chan struct{}); s.ch[bucket] = c }
+	return c
+}
+
+func (s *queueSignal) notify(bucket string) {
+	s.mu.Lock(); defer s.mu.Unlock()
+	if c, ok := s.ch[bucket]; ok { close(c); delete(s.ch, bucket) }
+}
+
+type boltQueueMsg struct {
+	queueMsg
+	Visible time.Time `json:"visible"`
+}
+
+func boltQueueBucket(tenant, queue string) []byte { return []byte(tenant + "/" + queue) }
+
+func boltQueueKey(id string) ([]byte, bool) {
+	n, err := strconv.ParseUint(id, 10, 64)
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, n)
+	return k, err == nil
+}
+
+func boltQueueGet(b *bolt.Bucket, tenant string, k []byte) (*boltQueueMsg, error) {
+	raw := b.Get(k)
+	if raw == nil { return nil, nil }
+	raw, err := openAtRest(raw, "queue:"+tenant)
+	if err != nil { return nil, err }
+	var m boltQueueMsg
+	return &m, json.Unmarshal(raw, &m)
+}
+
+func boltQueuePut(b *bolt.Bucket, tenant string, k []byte, m *boltQueueMsg) error {
+	raw, _ := json.Marshal(m)
+	return b.Put(k, sealAtRest(raw, "queue:"+tenant))
+}
+
+func (s boltQueue) push(tenant string, m queueMsg, maxDepth int) (string, error) {
+	var id string
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(boltQueueBucket(tenant, m.Queue))
+		if err != nil { return err }
+		if b.Stats().KeyN >= maxDepth { return errQueueFull }
+		seq, _ := b.NextSequence()
+		id = strconv.FormatUint(seq, 10)
+		m.ID = id
+		k, _ := boltQueueKey(id)
+		return boltQueuePut(b, tenant, k, &boltQueueMsg{queueMsg: m})
+	})
+	if err == nil { s.sig.notify(string(boltQueueBucket(tenant, m.Queue))) }
+	return id, err
+}
+
+func (s boltQueue) pop(ctx context.Context, tenant, queue string, vis, wait time.Duration) (*queueMsg, error) {
+	bucket := boltQueueBucket(tenant, queue)
+	deadline := time.Now().Add(wait)
+	for {
+		woken := s.sig.wait(string(bucket)) // before looking, so a push in between is not missed
+		k, next, err := s.visible(tenant, bucket)
+		if err != nil { return nil, err }
+		if k != nil {
+			m, err := s.claim(tenant, bucket, k, vis)
+			if err != nil || m != nil { return m, err }
+			continue // another pop took it first
+		}
+		left := time.Until(deadline)
+		if left <= 0 { return nil, nil }
+		if !next.IsZero() { left = min(left, max(time.Until(next), time.Millisecond)) }
+		t := time.NewTimer(left)
+		select {
+		case <-ctx.Done(): t.Stop(); return nil, nil
+		case <-woken: t.Stop()
+		case <-t.C:
+		}
+	}
+}
+
+// visible finds the first visible message without the write lock; with none,
+// next is when the first in-flight message times out (zero: none in flight).
+func (s boltQueue) visible(tenant string, bucket []byte) (k []byte, next time.Time, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		if b == nil { return nil }
+		now := time.Now()
+		c := b.Cursor()
+		for ck, _ := c.First(); ck != nil; ck, _ = c.Next() {
+			m, err := boltQueueGet(b, tenant, ck)
+			if err != nil { return err }
+			if !m.Visible.After(now) { k = bytes.Clone(ck); return nil }
+			if next.IsZero() || m.Visible.Before(next) { next = m.Visible }
+		}
+		return nil
+	})
+	return k, next, err
+}
+
+// claim takes message k if it is still visible; nil when another pop won.
+func (s boltQueue) claim(tenant string, bucket, k []byte, vis time.Duration) (*queueMsg, error) {
+	var out *queueMsg
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		if b == nil { return nil }
+		m, err := boltQueueGet(b, tenant, k)
+		now := time.Now()
+		if err != nil || m == nil || m.Visible.After(now) { return err }
+		m.Receipt, m.Visible = receipt(), now.Add(vis)
+		m.Receives++
+		if err := boltQueuePut(b, tenant, k, m); err != nil { return err }
+		out = &m.queueMsg
+		return nil
+	})
+	return out, err
+}
+
+// settle applies fn to an in-flight message whose receipt still matches.
+func (s boltQueue) settle(tenant, queue, id, rcpt string, fn func(b *bolt.Bucket, k []byte, m *boltQueueMsg) error) error {
+	k, ok := boltQueueKey(id)
+	if !ok || rcpt == "" { return errQueueStale }
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltQueueBucket(tenant, queue))
+		if b == nil { return errQueueStale }
+		m, err := boltQueueGet(b, tenant, k)
+		if err != nil { return err }
+		if m == nil || m.Receipt != rcpt { return errQueueStale }
+		return fn(b, k, m)
+	})
+}
+
+func (s boltQueue) ack(tenant, queue, id, rcpt string) error {
+	return s.settle(tenant, queue, id, rcpt, func(b *bolt.Bucket, k []byte, _ *boltQueueMsg) error { return b.Delete(k) })
+}
+
+func (s boltQueue) nack(tenant, queue, id, rcpt string) error {
+	err := s.settle(tenant, queue, id, rcpt, func(b *bolt.Bucket, k []byte, m *boltQueueMsg) error {
+		m.Receipt, m.Visible = "", time.Time{}
+		return boltQueuePut(b, tenant, k, m)
+	})
+	if err == nil { s.sig.notify(string(boltQueueBucket(tenant, queue))) }
+	return err
+}
+
+func (s boltQueue) depth(tenant, queue string) (int, error) {
+	n := 0
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(boltQueueBucket(tenant, queue)); b != nil { n = b.Stats().KeyN }
+		return nil
+	})
+	return n, err
+}
+
+func (s boltQueue) close() error { return s.db.Close() }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func testBoltQueue(t *testing.T) boltQueue {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "queue.db"), 0o600, nil)
+	if err != nil { t.Fatal(err) }
+	t.Cleanup(func() { db.Close() })
+	return boltQueue{db: db, sig: &queueSignal{}}
+}
+
+func TestBoltQueue(t *testing.T) {
+	q, ctx := testBoltQueue(t), context.Background()
+	for _, body := range []string{"a", "b"} {
+		if _, err := q.push("acme", queueMsg{Queue: "jobs", Body: body}, 10); err != nil { t.Fatal(err) }
+	}
+	m1, err := q.pop(ctx, "acme", "jobs", time.Minute, 0)
+	if err != nil || m1 == nil || m1.Body != "a" || m1.Receives != 1 || m1.Receipt == "" { t.Fatalf("pop = %+v, %v", m1, err) }
+	m2, _ := q.pop(ctx, "acme", "jobs", time.Minute, 0)
+	if m2 == nil || m2.Body != "b" { t.Fatalf("second pop = %+v", m2) }
+	if m, _ := q.pop(ctx, "acme", "jobs", time.Minute, 0); m != nil { t.Fatalf("in-flight message delivered again: %+v", m) }
+	if m, _ := q.pop(ctx, "other", "jobs", time.Minute, 0); m != nil { t.Fatal("another tenant sees the queue") }
+
+	if err := q.ack("acme", "jobs", m1.ID, "wrong"); !errors.Is(err, errQueueStale) { t.Errorf("ack with a wrong receipt: %v", err) }
+	if err := q.ack("acme", "jobs", m1.ID, m1.Receipt); err != nil { t.Fatal(err) }
+	if err := q.nack("acme", "jobs", m2.ID, m2.Receipt); err != nil { t.Fatal(err) }
+	m, _ := q.pop(ctx, "acme", "jobs", time.Minute, 0)
+	if m == nil || m.ID != m2.ID || m.Receives != 2 { t.Fatalf("after nack: %+v", m) }
+	if err := q.ack("acme", "jobs", m2.ID, m2.Receipt); !errors.Is(err, errQueueStale) { t.Errorf("ack with a superseded receipt: %v", err) }
+	if n, _ := q.depth("acme", "jobs"); n != 1 { t.Errorf("depth = %d, want 1", n) }
+
+	if _, err := q.push("acme", queueMsg{Queue: "small"}, 1); err != nil { t.Fatal(err) }
+	if _, err := q.push("acme", queueMsg{Queue: "small"}, 1); !errors.Is(err, errQueueFull) { t.Errorf("push past max_depth: %v", err) }
+}
+
+func TestBoltQueueWait(t *testing.T) {
+	q, ctx := testBoltQueue(t), context.Background()
+
+	// a waiting pop is woken by the push, not by the end of its wait
+	go func() { time.Sleep(50 * time.Millisecond); q.push("acme", queueMsg{Queue: "jobs", Body: "x"}, 10) }()
+	t0 := time.Now()
+	m, err := q.pop(ctx, "acme", "jobs", 100*time.Millisecond, 10*time.Second)
+	if err != nil || m == nil { t.Fatalf("pop = %v, %v", m, err) }
+	if d := time.Since(t0); d > 2*time.Second { t.Errorf("woken after %v", d) }
+
+	// not acked: delivered again once the visibility timeout passes
+	t0 = time.Now()
+	again, _ := q.pop(ctx, "acme", "jobs", time.Minute, 10*time.Second)
+	if again == nil || again.ID != m.ID || again.Receives != 2 { t.Fatalf("redelivery = %+v", again) }
+	if d := time.Since(t0); d > 2*time.Second { t.Errorf("redelivered after %v", d) }
+
+	// an empty wait ends at its deadline or with the context
+	if m, _ := q.pop(ctx, "acme", "none", time.Minute, 50*time.Millisecond); m != nil { t.Fatal("pop from an empty queue") }
+	cctx, cancel := context.WithCancel(ctx)
+	time.AfterFunc(50*time.Millisecond, cancel)
+	t0 = time.Now()
+	if m, _ := q.pop(cctx, "acme", "none", time.Minute, 10*time.Second); m != nil || time.Since(t0) > 2*time.Second { t.Fatal("canceled pop did not return") }
+}
+
+func TestBoltQueueConcurrentPop(t *testing.T) {
+	q, ctx := testBoltQueue(t), context.Background()
+	const n = 40
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	seen := map[string]int{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				m, err := q.pop(ctx, "acme", "jobs", time.Minute, 300*time.Millisecond)
+				if err != nil { t.Error(err); return }
+				if m == nil { return }
+				mu.Lock(); seen[m.ID]++; mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		if _, err := q.push("acme", queueMsg{Queue: "jobs", Body: i}, n); err != nil { t.Fatal(err) }
+	}
+	wg.Wait()
+	if len(seen) != n { t.Errorf("delivered %d distinct messages, want %d", len(seen), n) }
+	for id, c := range seen {
+		if c != 1 { t.Errorf("message %s delivered %d times", id, c) }
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// --- NATS queue backend (queue_backend: nats) ---
+// One work-queue stream (queue_nats_stream, created with
+// queue_nats_replicas if missing) holds every queue as the subject
+// <stream>.<tenant>.<queue>, and each queue is drained by a durable pull
+// consumer q_<tenant>_<queue> whose ack wait is the queue's visibility, so
+// every executor on the stream pops from the same queues. The sequence is
+// the msg_id; in-flight messages are held by receipt on the node that
+// popped them, so the ack has to come from a run on that node (else stale
+// and the message is redelivered after the visibility).
+
+type natsQueue struct {
+	nc     *nats.Conn
+	js     nats.JetStreamContext
+	stream string
+	mu     sync.Mutex
+	subs   map[string]*nats.Subscription // by subject
+	flight map[string]natsFlight         // by receipt
+}
+
+type natsFlight struct {
+	msg *nats.Msg
+	id  string
+	exp time.Time
+}
+
+func openNATSQueue(cfg Config) (*natsQueue, error) {
+	opts := []nats.Option{nats.Name("void-wasm-exec queue " + nodeID(cfg)), nats.MaxReconnects(-1)}
+	if cfg.NATSCreds != "" { opts = append(opts, nats.UserCredentials(cfg.NATSCreds)) }
+	nc, err := nats.Connect(cfg.NATSURL, opts...)
+	if err != nil { return nil, fmt.Errorf("nats %s: %w", cfg.NATSURL, err) }
+	js, err := nc.JetStream(nats.MaxWait(3 * time.Second))
+	if err != nil { nc.Close(); return nil, err }
+	_, err = js.StreamInfo(cfg.QueueNATSStream)
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = js.AddStream(&nats.StreamConfig{Name: cfg.QueueNATSStream, Subjects: []string{cfg.QueueNATSStream + ".>"}, Retention: nats.WorkQueuePolicy, Storage: nats.FileStorage, Replicas: cfg.QueueNATSReplicas, Description: "void-wasm-exec syscall.queue"})
+		if err == nil { fmt.Println("[queue] created nats stream", cfg.QueueNATSStream) }
+	}
+	if err != nil { nc.Close(); return nil, fmt.Errorf("queue_nats_stream %s: %w", cfg.QueueNATSStream, err) }
+	return &natsQueue{nc: nc, js: js, stream: cfg.QueueNATSStream, subs: map[string]*nats.Subscription{}, flight: map[string]natsFlight{}}, nil
+}
+
+func (s *natsQueue) subject(tenant, queue string) string { return s.stream + "." + tenant + "." + queue }
+
+func (s *natsQueue) push(tenant string, m queueMsg, maxDepth int) (string, error) {
+	n, err := s.depth(tenant, m.Queue)
+	if err != nil { return "", err }
+	if n >= maxDepth { return "", errQueueFull }
+	raw, _ := json.Marshal(m)
+	ack, err := s.js.Publish(s.subject(tenant, m.Queue), sealAtRest(raw, "queue:"+tenant))
+	if err != nil { return "", err }
+	return fmt.Sprint(ack.Sequence), nil
+}
+
+// sub is the pull subscription on queue's durable consumer.
+func (s *natsQueue) sub(tenant, queue string, vis time.Duration) (*nats.Subscription, error) {
+	subj := s.subject(tenant, queue)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sub := s.subs[subj]; sub != nil { return sub, nil }
+	durable := "q_" + strings.ReplaceAll(tenant+"_"+queue, "-", "_")
+	sub, err := s.js.PullSubscribe(subj, durable, nats.BindStream(s.stream), nats.AckExplicit(), nats.AckWait(vis))
+	if err == nil { s.subs[subj] = sub }
+	return sub, err
+}
+
+func (s *natsQueue) pop(ctx context.Context, tenant, queue string, vis, wait time.Duration) (*queueMsg, error) {
+	sub, err := s.sub(tenant, queue, vis)
+	if err != nil { return nil, err }
+	ctx, cancel := context.WithTimeout(ctx, max(wait, 10*time.Millisecond))
+	defer cancel()
+	msgs, err := sub.Fetch(1, nats.Context(ctx))
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) || errors.Is(err, nats.ErrTimeout) || len(msgs) == 0 { return nil, nil }
+	if err != nil { return nil, err }
+	msg := msgs[0]
+	meta, err := msg.Metadata()
+	if err != nil { msg.Nak(); return nil, err }
+	raw, err := openAtRest(msg.Data, "queue:"+tenant)
+	var m queueMsg
+	if err == nil { err = json.Unmarshal(raw, &m) }
+	if err != nil { msg.Term(); return nil, fmt.Errorf("msg %d: %w", meta.Sequence.Stream, err) }
+	m.ID, m.Receipt, m.Receives = fmt.Sprint(meta.Sequence.Stream), receipt(), int(meta.NumDelivered)
+	s.mu.Lock()
+	now := time.Now()
+	for r, f := range s.flight {
+		if now.After(f.exp) { delete(s.flight, r) } // redelivered by now
+	}
+	s.flight[m.Receipt] = natsFlight{msg, m.ID, now.Add(vis)}
+	s.mu.Unlock()
+	return &m, nil
+}
+
+func (s *natsQueue) take(id, rcpt string) (*nats.Msg, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.flight[rcpt]
+	if !ok || f.id != id || time.Now().After(f.exp) { return nil, errQueueStale }
+	delete(s.flight, rcpt)
+	return f.msg, nil
+}
+
+func (s *natsQueue) ack(tenant, queue, id, rcpt string) error {
+	msg, err := s.take(id, rcpt)
+	if err != nil { return err }
+	return msg.AckSync()
+}
+
+func (s *natsQueue) nack(tenant, queue, id, rcpt string) error {
+	msg, err := s.take(id, rcpt)
+	if err != nil { return err }
+	return msg.Nak()
+}
+
+func (s *natsQueue) depth(tenant, queue string) (int, error) {
+	info, err := s.js.StreamInfo(s.stream, &nats.StreamInfoRequest{SubjectsFilter: s.subject(tenant, queue)})
+	if err != nil { return 0, err }
+	return int(info.State.Subjects[s.subject(tenant, queue)]), nil
+}
+
+func (s *natsQueue) close() error { s.nc.Drain(); return nil }
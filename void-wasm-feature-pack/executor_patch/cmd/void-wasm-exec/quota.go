@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Resource quota accounting per module and tenant ---
+// Usage is kept in one-minute buckets per (tenant, module) and summed over
+// QuotaWindow. Run time is wall-clock ms inside the guest; wazero has no fuel
+// metering, so syscall count stands in as the work unit.
+
+var quotaExceeded = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_quota_exceeded_total", Help: "Runs over budget by tenant and budget"}, []string{"tenant", "budget"})
+
+type usageKey struct{ tenant, module string }
+
+type usageTotals struct {
+	Runs, RunMs, CPUMs, Fuel, NetBytes, EgressBytes, Syscalls int64
+	CacheMisses, CacheBytes                              int64 // module cache churn (cost.go)
+	AITokens                                             int64 // syscall.ai.infer (ai.go)
+}
+
+func (u *usageTotals) add(o usageTotals) {
+	u.Runs += o.Runs; u.RunMs += o.RunMs; u.CPUMs += o.CPUMs; u.Fuel += o.Fuel; u.NetBytes += o.NetBytes; u.EgressBytes += o.EgressBytes; u.Syscalls += o.Syscalls
+	u.CacheMisses += o.CacheMisses; u.CacheBytes += o.CacheBytes; u.AITokens += o.AITokens
+}
+
+// fields names the totals as they appear in usage.report and metrics.
+func (u usageTotals) fields() map[string]int64 {
+	return map[string]int64{"runs": u.Runs, "run_ms": u.RunMs, "cpu_ms": u.CPUMs, "fuel": u.Fuel, "net_bytes": u.NetBytes, "egress_bytes": u.EgressBytes, "syscalls": u.Syscalls, "cache_misses": u.CacheMisses, "cache_bytes": u.CacheBytes, "ai_tokens": u.AITokens}
+}
+
+var (
+	usageMu sync.Mutex
+	usage   = map[usageKey]map[int64]*usageTotals{} // key -> unix minute -> totals
+)
+
+func recordUsage(rec *RunRecord) {
+	switch rec.Result {
+	case "ok", "error", "canceled", "budget_exhausted":
+	default:
+		return
+	}
+	k := usageKey{rec.Tenant, rec.Module}
+	u := runUsage(rec)
+	observeCost(rec.Tenant, rec.Module, u)
+	minute := time.Now().Unix() / 60
+	usageMu.Lock(); defer usageMu.Unlock()
+	if usage[k] == nil { usage[k] = map[int64]*usageTotals{} }
+	b := usage[k][minute]
+	if b == nil { b = &usageTotals{}; usage[k][minute] = b }
+	b.add(u)
+}
+
+// usageSince sums buckets inside window, dropping older ones as it goes.
+func usageSince(window time.Duration, match func(usageKey) bool) usageTotals {
+	from := time.Now().Add(-window).Unix() / 60
+	var t usageTotals
+	usageMu.Lock(); defer usageMu.Unlock()
+	for k, mins := range usage {
+		for m, b := range mins {
+			if m < from { delete(mins, m); continue }
+			if match(k) { t.add(*b) }
+		}
+		if len(mins) == 0 { delete(usage, k) }
+	}
+	return t
+}
+
+func overBudget(u usageTotals, runs int64, runTime time.Duration, netMB int64) string {
+	switch {
+	case runs > 0 && u.Runs >= runs: return "runs"
+	case runTime > 0 && u.RunMs >= runTime.Milliseconds(): return "run_time"
+	case netMB > 0 && u.NetBytes >= netMB<<20: return "net"
+	}
+	return ""
+}
+
+// quotaCheck returns the exhausted budget ("" when within budget): module
+// budgets first, then the tenant's totals.
+func quotaCheck(cfg Config, module string) string {
+	if cfg.QuotaWindow <= 0 { return "" }
+	mod := usageSince(cfg.QuotaWindow, func(k usageKey) bool { return k.tenant == cfg.Tenant && k.module == module })
+	if b := overBudget(mod, cfg.QuotaRuns, cfg.QuotaRunTime, cfg.QuotaNetMB); b != "" { return "module_" + b }
+	p, ok := cfg.Tenants[cfg.Tenant]
+	if !ok { return "" }
+	ten := usageSince(cfg.QuotaWindow, func(k usageKey) bool { return k.tenant == cfg.Tenant })
+	if b := overBudget(ten, p.QuotaRuns, p.QuotaRunTime, p.QuotaNetMB); b != "" { return "tenant_" + b }
+	return ""
+}
+
+// quotaDelay deprioritizes over-budget envelopes (quota_action: defer) by
+// holding them back before they compete for a run slot.
+func quotaDelay(cfg Config, env *Envelope) time.Duration {
+	if cfg.QuotaAction != "defer" { return 0 }
+	c, ok := cfg.forTenant(tenantOf(env))
+	module := env.Module
+	if module == "" { module = "unknown" }
+	if !ok || quotaCheck(c, module) == "" { return 0 }
+	return cfg.QuotaDefer
+}
+
+// usageReportLoop posts usage.report with per tenant/module totals for cost
+// attribution (cost.go).
+func usageReportLoop(cfg Config) {
+	if cfg.UsageReportEvery <= 0 || cfg.QuotaWindow <= 0 { return }
+	for {
+		time.Sleep(cfg.UsageReportEvery)
+		usageSince(cfg.QuotaWindow, func(usageKey) bool { return false }) // prune
+		usageMu.Lock()
+		rows := []map[string]any{}
+		for k, mins := range usage {
+			var t usageTotals
+			for _, b := range mins { t.add(*b) }
+			row := map[string]any{"tenant": k.tenant, "module": k.module}
+			for f, v := range t.fields() { row[f] = v }
+			rows = append(rows, row)
+		}
+		usageMu.Unlock()
+		if len(rows) == 0 { continue }
+		postEvent(cfg, map[string]any{"type": "usage.report", "meta": map[string]any{
+			"node": nodeID(cfg), "window": cfg.QuotaWindow.String(), "usage": rows, "ts": time.Now().UTC().Format(time.RFC3339),
+			"cache_evictions": cacheEvicted.Swap(0), // node-wide since the last report; cached files are not per module
+		}})
+		fmt.Printf("[quota] usage.report rows=%d\n", len(rows))
+	}
+}
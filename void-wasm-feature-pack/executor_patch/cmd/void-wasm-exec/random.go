@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	mrand "math/rand/v2"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// --- Guest randomness (syscall.random: void.random) ---
+// void.random(buf_ptr, len) fills len bytes (at most 64 KiB a call) and
+// returns len, -1 once the run would draw more than random_max_kb (0 turns
+// void.random off), -2 for a bad length or buffer. The clock policy is the
+// run's determinism policy and decides the source too: with clock real the
+// bytes come from the host's crypto/rand (as does WASI random_get), with
+// clock deterministic from a ChaCha8 stream seeded by the module's sha256,
+// entry and canonical inputs (randomSeed), so the same request draws the same
+// bytes on every node and in golden runs and different inputs draw different
+// ones. Seeded bytes are not secret: anyone who knows the module and the
+// envelope can compute them; keys and nonces need clock real. Real
+// draws go into the recording with RECORD_DIR and replay hands them back.
+// Every call is traced as syscall.random with the length as id; the run
+// record keeps random_bytes and void_wasm_random_bytes_total{source} counts
+// what was handed out, for audit.
+
+const (
+	randomQuota = -1
+	randomBad   = -2
+	randomMax   = 64 << 10
+)
+
+var randomBytes = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_random_bytes_total", Help: "Bytes handed out by void.random by source (host, seeded)"}, []string{"source"})
+
+type runRandom struct {
+	cfg   Config
+	rec   *RunRecord
+	start time.Time
+	prng  *mrand.ChaCha8 // clock deterministic, made on the first draw
+}
+
+type randomKey struct{}
+
+func withRandom(ctx context.Context, cfg Config, rec *RunRecord, start time.Time) context.Context {
+	return context.WithValue(ctx, randomKey{}, &runRandom{cfg: cfg, rec: rec, start: start})
+}
+
+// fill draws len(b) bytes; result is the trace/metric label.
+func (r *runRandom) fill(b []byte) (result string) {
+	if r.cfg.Clock != "real" {
+		if r.prng == nil { r.prng = mrand.NewChaCha8(randomSeed(r.rec)) }
+		r.prng.Read(b)
+		randomBytes.WithLabelValues("seeded").Add(float64(len(b)))
+		return "seeded"
+	}
+	t := r.rec.tape
+	if t != nil && t.replay != nil {
+		x, ok := t.next("syscall.random")
+		if !ok { return "replay_miss" }
+		s, _ := x.Response["b64"].(string)
+		raw, _ := base64.StdEncoding.DecodeString(s)
+		copy(b, raw)
+		return "host"
+	}
+	rand.Read(b)
+	randomBytes.WithLabelValues("host").Add(float64(len(b)))
+	if t != nil { t.exchanges = append(t.exchanges, Exchange{Kind: "syscall.random", Response: map[string]any{"b64": base64.StdEncoding.EncodeToString(b)}, Result: "ok"}) }
+	return "host"
+}
+
+// randomSeed is the deterministic stream's seed: the module's sha256, the
+// entry and the inputs as JSON (map keys sorted, so canonical).
+func randomSeed(rec *RunRecord) (seed [32]byte) {
+	h := sha256.New()
+	h.Write([]byte("void.random\x00" + rec.SHA256 + "\x00"))
+	if env := rec.Envelope; env != nil {
+		in, _ := json.Marshal(env.Inputs)
+		h.Write([]byte(env.Entry + "\x00"))
+		h.Write(in)
+	}
+	h.Sum(seed[:0])
+	return seed
+}
+
+// exportRandom adds random to the void host module.
+func exportRandom(b wazero.HostModuleBuilder) {
+	i32 := api.ValueTypeI32
+	b.NewFunctionBuilder().
+		WithGoModuleFunction(api.GoModuleFunc(func(ctx context.Context, mod api.Module, stack []uint64) {
+			t0 := time.Now()
+			r, _ := ctx.Value(randomKey{}).(*runRandom)
+			ptr, n := api.DecodeU32(stack[0]), api.DecodeU32(stack[1])
+			if r == nil { stack[0] = api.EncodeI32(randomQuota); return }
+			res, result := int32(randomBad), "bad_len"
+			switch {
+			case n == 0 || n > randomMax:
+			case !r.rec.budget.spend(budgetSyscalls, 1) || r.rec.RandomBytes+int64(n) > int64(r.cfg.RandomMaxKB)<<10:
+				res, result = randomQuota, "quota"
+			default:
+				buf, ok := mod.Memory().Read(ptr, n) // a view: filled in place
+				if !ok { break }
+				result = r.fill(buf)
+				r.rec.RandomBytes += int64(n)
+				res = int32(n)
+			}
+			stack[0] = api.EncodeI32(res)
+			sysReqTotal.WithLabelValues("syscall.random", result).Inc()
+			r.rec.traceSyscall(r.cfg.TimelineMax, r.start, "syscall.random", strconv.Itoa(int(n)), result, t0)
+		}), []api.ValueType{i32, i32}, []api.ValueType{i32}).
+		Export("random")
+}
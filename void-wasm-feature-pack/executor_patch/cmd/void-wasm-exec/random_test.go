@@ -0,0 +1,15 @@
+package main
+
+import "testing"
+
+func TestRandomSeed(t *testing.T) {
+	sha := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	seed := func(entry string, in map[string]any) [32]byte {
+		return randomSeed(&RunRecord{SHA256: sha, Envelope: &Envelope{SHA256: sha, Entry: entry, Inputs: in}})
+	}
+	a := seed("", map[string]any{"a": 1, "b": map[string]any{"x": "y", "z": []any{1, 2}}})
+	if b := seed("", map[string]any{"b": map[string]any{"z": []any{1, 2}, "x": "y"}, "a": 1}); a != b { t.Error("key order changed the seed") }
+	if b := seed("", map[string]any{"a": 2, "b": map[string]any{"x": "y", "z": []any{1, 2}}}); a == b { t.Error("inputs do not change the seed") }
+	if b := seed("main", map[string]any{"a": 1, "b": map[string]any{"x": "y", "z": []any{1, 2}}}); a == b { t.Error("entry does not change the seed") }
+	if b := randomSeed(&RunRecord{SHA256: "0" + sha[1:], Envelope: &Envelope{Inputs: map[string]any{"a": 1, "b": map[string]any{"x": "y", "z": []any{1, 2}}}}}); a == b { t.Error("module does not change the seed") }
+}
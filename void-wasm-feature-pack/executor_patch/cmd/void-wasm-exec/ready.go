@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- /readyz: real dependency checks ---
+// relay, OPA (with opa_base), free space in cache_dir and cosign (with
+// cosign_verify), probed in parallel; any failure answers 503 with the
+// per-check detail. /healthz stays pure liveness.
+
+var readyClient = &http.Client{Timeout: 1500 * time.Millisecond}
+
+type checkResult struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+func probe(u string) error {
+	resp, err := readyClient.Get(u)
+	if err != nil { return err }
+	resp.Body.Close()
+	if resp.StatusCode >= 500 { return fmt.Errorf("status %d", resp.StatusCode) }
+	return nil
+}
+
+func readiness(cfg Config) (bool, map[string]checkResult) {
+	checks := map[string]func() error{
+		"relay": func() error { return probe(cfg.RelayBase + "/healthz") },
+		"disk": func() error {
+			free, err := diskFreeMB(cfg.CacheDir)
+			if err != nil { return err }
+			if free < uint64(cfg.ReadyMinFreeMB) { return fmt.Errorf("%dMB free < %dMB", free, cfg.ReadyMinFreeMB) }
+			return nil
+		},
+	}
+	if cfg.OPABase != "" { checks["opa"] = func() error { return probe(strings.TrimRight(cfg.OPABase, "/") + "/health") } }
+	if cfg.CosignVerify { checks["cosign"] = func() error { _, err := exec.LookPath("cosign"); return err } }
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	out := map[string]checkResult{}
+	ok := true
+	for name, fn := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := checkResult{OK: true}
+			if err := fn(); err != nil { res = checkResult{OK: false, Error: err.Error()} }
+			mu.Lock(); out[name] = res; if !res.OK { ok = false }; mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return ok, out
+}
+
+func handleReady(w http.ResponseWriter, r *http.Request) {
+	ok, checks := readiness(currentConfig())
+	status := 200
+	if !ok { status = 503 }
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"ok": ok, "checks": checks})
+}
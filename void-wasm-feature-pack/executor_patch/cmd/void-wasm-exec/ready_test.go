@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadiness(t *testing.T) {
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" { w.WriteHeader(404) }
+	}))
+	defer relay.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(503) }))
+	defer down.Close()
+	dir := t.TempDir()
+
+	for name, tc := range map[string]struct {
+		cfg    Config
+		ok     bool
+		failed string
+	}{
+		"ready":        {Config{RelayBase: relay.URL, CacheDir: dir}, true, ""},
+		"relay down":   {Config{RelayBase: down.URL, CacheDir: dir}, false, "relay"},
+		"relay gone":   {Config{RelayBase: "http://127.0.0.1:1", CacheDir: dir}, false, "relay"},
+		"opa down":     {Config{RelayBase: relay.URL, CacheDir: dir, OPABase: down.URL + "/"}, false, "opa"},
+		"disk full":    {Config{RelayBase: relay.URL, CacheDir: dir, ReadyMinFreeMB: 1 << 40}, false, "disk"},
+		"no cache dir": {Config{RelayBase: relay.URL, CacheDir: dir + "/missing"}, false, "disk"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ok, checks := readiness(tc.cfg)
+			if ok != tc.ok { t.Fatalf("ok = %v, checks %+v", ok, checks) }
+			for c, res := range checks {
+				if res.OK == (c == tc.failed) { t.Errorf("check %s = %+v", c, res) }
+			}
+			if _, ran := checks["opa"]; ran != (tc.cfg.OPABase != "") { t.Errorf("opa checked: %v", ran) }
+		})
+	}
+
+	saved := liveCfg.Load()
+	defer liveCfg.Store(saved)
+	liveCfg.Store(&Config{RelayBase: down.URL, CacheDir: dir})
+	w := httptest.NewRecorder()
+	handleReady(w, httptest.NewRequest("GET", "/readyz", nil))
+	var body struct {
+		OK     bool                   `json:"ok"`
+		Checks map[string]checkResult `json:"checks"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != 503 || body.OK || body.Checks["relay"].OK || !body.Checks["disk"].OK { t.Errorf("/readyz = %d %s", w.Code, w.Body) }
+}
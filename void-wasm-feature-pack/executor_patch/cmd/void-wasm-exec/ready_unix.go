@@ -0,0 +1,12 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+// diskFreeMB is the space left in dir for an unprivileged writer.
+func diskFreeMB(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil { return 0, err }
+	return st.Bavail * uint64(st.Bsize) / (1 << 20), nil
+}
@@ -0,0 +1,14 @@
+//go:build windows
+
+package main
+
+import "golang.org/x/sys/windows"
+
+// diskFreeMB is the space left in dir for the calling user (quotas included).
+func diskFreeMB(dir string) (uint64, error) {
+	p, err := windows.UTF16PtrFromString(dir)
+	if err != nil { return 0, err }
+	var avail, total, free uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &avail, &total, &free); err != nil { return 0, err }
+	return avail / (1 << 20), nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var recordPruned = prometheus.NewCounter(prometheus.CounterOpts{Name: "void_wasm_recordings_pruned_total", Help: "Recordings removed from record_dir by record_ttl or record_max_mb"})
+
+// --- Record and replay ---
+// With RECORD_DIR set every executed run writes <run id>.json holding the
+// envelope, the module's stdout and each syscall request with the sysret the
+// executor produced. `void-wasm-exec replay rec.json` re-runs the module with
+// kv/http syscalls answered from the recording and diffs the stdout.
+// `replay -corpus` replays an envelope corpus instead (corpus.go).
+// Recordings older than record_ttl go, then the oldest ones until the
+// directory fits record_max_mb, each time one is written.
+
+type Exchange struct {
+	Kind     string         `json:"kind"`
+	Request  map[string]any `json:"request"`
+	Response map[string]any `json:"response,omitempty"`
+	Result   string         `json:"result"`
+}
+
+type Recording struct {
+	Version   int        `json:"version"`
+	RunID     string     `json:"run_id"`
+	Module    string     `json:"module"`
+	SHA256    string     `json:"sha256,omitempty"`
+	Envelope  *Envelope  `json:"envelope"`
+	Stdout    []string   `json:"stdout"`
+	Exchanges []Exchange `json:"exchanges"`
+	Result    string     `json:"result"`
+	Error     string     `json:"error,omitempty"`
+	Clock     string     `json:"clock,omitempty"` // real: void.time_* readings are among the exchanges (clock.go)
+}
+
+// runTape is the per-run record/replay state hung off RunRecord.
+type runTape struct {
+	replay    []Exchange // replay only: remaining recorded exchanges, in order
+	stdout    []string
+	exchanges []Exchange
+	lastReply map[string]any
+}
+
+// sysret posts a syscall reply and remembers it for the recording.
+func sysret(cfg Config, rec *RunRecord, ev map[string]any) {
+	if rec != nil && rec.tape != nil { rec.tape.lastReply = ev }
+	postRunEvent(cfg, rec, ev)
+}
+
+// replayed answers kinds with side effects from the tape; ok is false when
+// the run is not replaying or the kind is handled live (emit).
+func replayed(cfg Config, rec *RunRecord, kind string) (result string, ok bool) {
+	if rec == nil || rec.tape == nil || rec.tape.replay == nil || kind == "syscall.emit" { return "", false }
+	for i, x := range rec.tape.replay {
+		if x.Kind != kind { continue }
+		rec.tape.replay = append(rec.tape.replay[:i:i], rec.tape.replay[i+1:]...)
+		if x.Response != nil { sysret(cfg, rec, x.Response) }
+		return x.Result, true
+	}
+	return "replay_miss", true
+}
+
+// next takes the next recorded exchange of kind off the replay tape; a miss
+// is noted for the replay diff.
+func (t *runTape) next(kind string) (Exchange, bool) {
+	for i, x := range t.replay {
+		if x.Kind != kind { continue }
+		t.replay = append(t.replay[:i:i], t.replay[i+1:]...)
+		return x, true
+	}
+	t.exchanges = append(t.exchanges, Exchange{Kind: kind, Result: "replay_miss"})
+	return Exchange{}, false
+}
+
+func (t *runTape) capture(kind string, req map[string]any, result string) {
+	t.exchanges = append(t.exchanges, Exchange{Kind: kind, Request: req, Response: t.lastReply, Result: result})
+	t.lastReply = nil
+}
+
+func writeRecording(cfg Config, rec *RunRecord) {
+	clock := ""
+	if cfg.Clock == "real" { clock = "real" }
+	if rec.tape == nil { return }
+	switch rec.Result {
+	case "ok", "error", "canceled":
+	default:
+		return
+	}
+	r := Recording{Version: 1, RunID: rec.ID, Module: rec.Module, SHA256: rec.SHA256, Envelope: rec.Envelope,
+		Stdout: rec.tape.stdout, Exchanges: rec.tape.exchanges, Result: rec.Result, Error: rec.Error, Clock: clock}
+	b, _ := json.MarshalIndent(r, "", "  ")
+	os.MkdirAll(cfg.RecordDir, 0o755)
+	path := filepath.Join(cfg.RecordDir, rec.ID+".json")
+	if err := writeFileAtomic(path, b, 0o600); err != nil { fmt.Println("[record] write:", err); return }
+	recordPruned.Add(float64(pruneDir(cfg.RecordDir, cfg.RecordMaxMB, cfg.RecordTTL, path)))
+}
+
+// replayLocal implements `void-wasm-exec replay [--module file.wasm] rec.json`.
+func replayLocal(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	module := fs.String("module", "", "module file (default: <cache_dir>/<sha256>.wasm)")
+	caps := fs.String("caps", "emit,kv,http", "comma-separated caps")
+	corpusFile := fs.String("corpus", "", "replay an envelope corpus (corpus.go) against -target instead of a recording")
+	target := fs.String("target", "http://localhost:8787/intent/execute-wasm", "corpus: where envelopes are posted")
+	token := fs.String("token", getenv("REPLAY_TOKEN", ""), "corpus: bearer token for -target")
+	speed := fs.Float64("speed", 1, "corpus: time scale, 2 = twice as fast, 0 = no pacing")
+	loops := fs.Int("loop", 1, "corpus: times through the corpus")
+	workers := fs.Int("workers", 32, "corpus: requests in flight")
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, "usage: void-wasm-exec replay [flags] recording.json | replay -corpus corpus.ndjson [flags]"); fs.PrintDefaults() }
+	fs.Parse(args)
+	if *corpusFile != "" {
+		if *speed < 0 || *loops < 1 || *workers < 1 { fs.Usage(); return 2 }
+		return replayCorpus(*corpusFile, *target, *token, *speed, *loops, *workers)
+	}
+	if fs.NArg() != 1 { fs.Usage(); return 2 }
+	b, err := os.ReadFile(fs.Arg(0))
+	if err != nil { fmt.Fprintln(os.Stderr, err); return 2 }
+	var r Recording
+	if err := json.Unmarshal(b, &r); err != nil || r.Envelope == nil { fmt.Fprintln(os.Stderr, "bad recording:", err); return 2 }
+
+	cfg := defaultConfig()
+	cfg.Tenant = defaultTenant
+	cfg.AllowCaps = parseList(*caps)
+	cfg.AllowHTTPHosts = nil // nothing goes to the network during replay
+	if r.Clock == "real" { cfg.Clock = "real" } // void.time_* answered from the recording
+	path := *module
+	if path == "" { path = filepath.Join(cfg.CacheDir, r.SHA256+".wasm") }
+
+	localSink = func(map[string]any) {}
+	tape := &runTape{replay: append([]Exchange{}, r.Exchanges...)}
+	rec := &RunRecord{ID: newRunID(time.Now()), Module: r.Module, Tenant: defaultTenant, Path: pathStable, tape: tape}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DefaultTO)
+	defer cancel()
+	if err := runWasm(ctx, cfg, path, r.Envelope, rec); err != nil { fmt.Println("replay  run error:", err); return 1 }
+
+	diffs := 0
+	for i := 0; i < len(r.Stdout) || i < len(tape.stdout); i++ {
+		var want, got string
+		if i < len(r.Stdout) { want = r.Stdout[i] }
+		if i < len(tape.stdout) { got = tape.stdout[i] }
+		if want != got { diffs++; fmt.Printf("stdout[%d]\n  - %s\n  + %s\n", i, want, got) }
+	}
+	for _, x := range tape.exchanges {
+		if x.Result == "replay_miss" { diffs++; fmt.Println("syscall not in recording:", x.Kind) }
+	}
+	if len(tape.replay) > 0 { diffs++; fmt.Println("recorded syscalls not replayed:", len(tape.replay)) }
+	fmt.Printf("replay  %d stdout lines, %d syscalls, %d diffs\n", len(tape.stdout), len(tape.exchanges), diffs)
+	if diffs > 0 { return 1 }
+	return 0
+}
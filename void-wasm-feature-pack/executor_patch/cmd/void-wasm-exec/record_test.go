@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPruneDir(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	write := func(name string, kb int, age time.Duration) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, make([]byte, kb<<10), 0o600); err != nil { t.Fatal(err) }
+		os.Chtimes(p, now.Add(-age), now.Add(-age))
+		return p
+	}
+	left := func() []string {
+		ents, _ := os.ReadDir(dir)
+		var names []string
+		for _, e := range ents { names = append(names, e.Name()) }
+		return names
+	}
+	write("old.json", 1, 5*time.Hour)
+	write("a.json", 400, 3*time.Hour)
+	write("b.json", 400, 2*time.Hour)
+	write("c.json", 400, time.Hour)
+	keep := write("new.json", 400, 10*time.Hour) // older than the ttl, but it is the one just written
+
+	if n := pruneDir(dir, 0, 0, keep); n != 0 || len(left()) != 5 { t.Fatalf("no limits removed %d, left %v", n, left()) }
+	if n := pruneDir(dir, 0, 4*time.Hour, keep); n != 1 || !slices.Equal(left(), []string{"a.json", "b.json", "c.json", "new.json"}) { t.Fatalf("ttl removed %d, left %v", n, left()) }
+	if n := pruneDir(dir, 1, 4*time.Hour, keep); n != 2 || !slices.Equal(left(), []string{"c.json", "new.json"}) { t.Fatalf("1 MB removed %d, left %v", n, left()) }
+	if n := pruneDir(dir, 1, 0, keep); n != 0 { t.Errorf("a dir within its quota lost %d files", n) }
+	if n := pruneDir(filepath.Join(dir, "missing"), 1, time.Hour, ""); n != 0 { t.Errorf("missing dir: %d", n) }
+}
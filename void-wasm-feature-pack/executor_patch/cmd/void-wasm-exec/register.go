@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Node registration (POST <relay>/nodes/register) ---
+// Heartbeats tell the relay a node is alive; registration tells it what the
+// node is, so it can target envelopes instead of broadcasting them. At
+// startup and then every half lease the executor POSTs
+//   {node, version, commit, engine, key_id, labels, caps, modules, features,
+//    transports: {intake, events}, limits: {concurrency, timeout_ms, mem_mb,
+//    http_max_kb, targets}, advertise_url, lease_s, ts}
+// to relay_base + register_path. features are the enabled
+// void_wasm_feature_enabled flags; intake lists sse plus admin (POST
+// /admin/envelopes at advertise_url) and intent when they are on. The relay
+// may answer {"lease_s": n} to set the lease; a node it has not heard from
+// for a lease is gone. With identity_key the body is signed like an event
+// delivery (X-Void-Signature), so the relay can pin a node id to its key.
+// On shutdown the node registers once more with lease_s 0 and draining, and
+// the relay stops sending it work. To aim an envelope at one node the relay
+// sets placement node=<id>: every node matches node against its own id
+// unless node_labels sets it (placement.go). Labels and caps are re-read at
+// every renewal, so a config reload reaches the relay within half a lease.
+
+var (
+	registerTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_register_total", Help: "Node registrations with the relay by result (ok, rejected, error)"}, []string{"result"})
+	leaseExpires  atomic.Int64 // unix ns of the current lease's end
+	registered    = prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: "void_wasm_registered", Help: "1 while the node holds a relay lease"}, func() float64 {
+		if time.Now().UnixNano() < leaseExpires.Load() { return 1 }
+		return 0
+	})
+)
+
+// nodeAdvert is the registration body.
+func nodeAdvert(cfg Config, lease time.Duration, draining bool) map[string]any {
+	var features []string
+	for name, on := range featureFlags() {
+		if on() { features = append(features, name) }
+	}
+	sort.Strings(features)
+	intake := []string{"sse"}
+	if cfg.AdminAddr != "" && cfg.AdminToken != "" { intake = append(intake, "admin") }
+	if cfg.IntentAddr != "" { intake = append(intake, "intent") }
+	events := []string{cfg.EventSink}
+	if cfg.NATSURL != "" { events = append(events, "nats") }
+	ad := map[string]any{
+		"node": nodeID(cfg), "version": version, "commit": commit, "engine": engineVersion(),
+		"labels": cfg.NodeLabels, "caps": cfg.AllowCaps, "modules": cfg.AllowModules, "features": features,
+		"transports": map[string]any{"intake": intake, "events": events},
+		"limits": map[string]any{"concurrency": cfg.Concurrency, "timeout_ms": cfg.DefaultTO.Milliseconds(), "mem_mb": cfg.MaxMemMB, "http_max_kb": cfg.MaxHTTPKB, "targets": cfg.Targets},
+		"lease_s": int(lease / time.Second),
+		"ts":      time.Now().UTC().Format(time.RFC3339),
+	}
+	if identityKeyID != "" { ad["key_id"] = identityKeyID }
+	if cfg.AdvertiseURL != "" { ad["advertise_url"] = cfg.AdvertiseURL }
+	if draining { ad["draining"] = true }
+	return ad
+}
+
+// registerNode posts one registration and returns the lease the relay granted.
+func registerNode(cfg Config, lease time.Duration, draining bool) (time.Duration, error) {
+	body, _ := json.Marshal(nodeAdvert(cfg, lease, draining))
+	req, _ := http.NewRequest("POST", cfg.RelayBase+cfg.RegisterPath, bytes.NewReader(body))
+	req.Header.Set("content-type", "application/json")
+	if identity != nil {
+		if sig, err := identity.sign(body); err == nil {
+			req.Header.Set("x-void-signature", (&ResultSignature{Node: nodeID(cfg), KeyID: identityKeyID, Alg: identity.alg(), Sig: base64.StdEncoding.EncodeToString(sig)}).header())
+		}
+	}
+	resp, err := relayClient.Do(req)
+	if err != nil { registerTotal.WithLabelValues("error").Inc(); return 0, err }
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode >= 300 {
+		registerTotal.WithLabelValues("rejected").Inc()
+		return 0, fmt.Errorf("status %d: %s", resp.StatusCode, bytes.TrimSpace(raw))
+	}
+	var out struct{ LeaseS int `json:"lease_s"` }
+	if json.Unmarshal(raw, &out) == nil && out.LeaseS > 0 { lease = time.Duration(out.LeaseS) * time.Second }
+	registerTotal.WithLabelValues("ok").Inc()
+	return lease, nil
+}
+
+// registerLoop holds the node's lease until shutdown.
+func registerLoop(cfg Config) {
+	if cfg.RegisterPath == "" || cfg.RegisterLease <= 0 { return }
+	failing := false
+	for !shuttingDown.Load() {
+		c := currentConfig()
+		lease, err := registerNode(c, c.RegisterLease, false)
+		wait := max(lease/2, time.Second)
+		switch {
+		case err != nil:
+			if !failing { fmt.Println("[register]", c.RelayBase+c.RegisterPath+":", err) }
+			failing, wait = true, min(c.RegisterLease/4, 15*time.Second)
+		default:
+			if failing || leaseExpires.Load() == 0 { fmt.Println("[register] node", nodeID(c), "lease", lease) }
+			failing = false
+			leaseExpires.Store(time.Now().Add(lease).UnixNano())
+		}
+		time.Sleep(wait)
+	}
+}
+
+// leaveRelay gives the lease back on shutdown.
+func leaveRelay(cfg Config) {
+	if cfg.RegisterPath == "" || cfg.RegisterLease <= 0 || leaseExpires.Load() == 0 { return }
+	if _, err := registerNode(cfg, 0, true); err != nil { fmt.Println("[register] leave:", err) }
+	leaseExpires.Store(0)
+}
+
+func validRegister(c Config) error {
+	if c.RegisterPath != "" && (c.RegisterPath[0] != '/' || c.RegisterLease < 0 || (c.RegisterLease > 0 && c.RegisterLease < 10*time.Second)) { return fmt.Errorf("register_path: must start with /, register_lease 0 (off) or >= 10s") }
+	return nil
+}
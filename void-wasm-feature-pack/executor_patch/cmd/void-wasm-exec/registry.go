@@ -0,0 +1,356 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Module registry and version constraints ---
+// An envelope may name a module by version instead of by hash:
+// module: wasm/ci/lint@^1.2 (or module wasm/ci/lint plus version ^1.2). The
+// executor resolves it against the registry index at registry_url (http(s),
+// s3:// or a local path), a DSSE envelope (payloadType
+// application/vnd.void.registry+json) signed by one of registry_keys
+// (ed25519 PEMs) over
+//   {"version": 42, "expires": "...", "modules": {"wasm/ci/lint":
+//     {"1.2.3": {"sha256": "...", "cid": "...", "url": "...", "yanked": false}}}}
+// The highest non-yanked release matching the constraint wins; prereleases
+// only match a constraint that names one. Constraints: 1.2.3 or =1.2.3,
+// ^1.2, ~1.2, 1.2 / 1.2.x / 1.x, * and comparisons (>=1.2 <2), all parts
+// ANDed; as in npm, a partial version compares as its range (>1.2 is
+// >=1.3.0, <=1.2 is <1.3.0) and prerelease identifiers compare as semver 11
+// says (rc.9 < rc.10). The release's sha256 then drives the usual download and check, and
+// the run record (so the signed receipt) pins it under resolved: module,
+// constraint, version, sha256, cid/url and the index version. Allowlists,
+// policies and quotas see the bare module name. The index is re-read every
+// registry_ttl; one that is unsigned, expired or older than the last one
+// accepted is refused and the previous one stays in use until it expires
+// itself, after which resolution fails rather than serve a frozen index.
+// The highest version accepted is kept in <cache_dir>/registry-*.version,
+// so a restart does not accept a rolled-back index either. Newer releases
+// replace a pinned one in the background (modupdate.go).
+
+const registryPayloadType = "application/vnd.void.registry+json"
+
+var (
+	registryResolves = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_registry_resolutions_total", Help: "module@version resolutions by result (resolved, no_match, error)"}, []string{"result"})
+	registryIndexVer = prometheus.NewGauge(prometheus.GaugeOpts{Name: "void_wasm_registry_index_version", Help: "Version of the registry index in use"})
+)
+
+// ModuleRelease is a resolved module@version, pinned in the run record.
+type ModuleRelease struct {
+	Module     string `json:"module"`
+	Constraint string `json:"constraint"`
+	Version    string `json:"version"`
+	SHA256     string `json:"sha256"`
+	CID        string `json:"cid,omitempty"`
+	URL        string `json:"url,omitempty"`
+	Index      int64  `json:"index"` // registry index version
+}
+
+type registryRelease struct {
+	SHA256 string `json:"sha256"`
+	CID    string `json:"cid,omitempty"`
+	URL    string `json:"url,omitempty"`
+	Yanked bool   `json:"yanked,omitempty"`
+}
+
+type registryDoc struct {
+	Version int64                                 `json:"version"`
+	Expires time.Time                             `json:"expires"`
+	Modules map[string]map[string]registryRelease `json:"modules"`
+}
+
+func (d *registryDoc) expired() bool { return !d.Expires.IsZero() && time.Now().After(d.Expires) }
+
+var registry struct {
+	sync.Mutex
+	doc     *registryDoc
+	src     string
+	at      time.Time
+	loading bool
+}
+
+// registrySource rewrites env to the release its version constraint resolves
+// to and pins it in rec; env is returned as is without a constraint.
+func registrySource(cfg Config, env *Envelope, rec *RunRecord) (*Envelope, error) {
+	if env.Version == "" { return env, nil }
+	if cfg.RegistryURL == "" { registryResolves.WithLabelValues("error").Inc(); return nil, errors.New("module version constraint but no registry_url") }
+	doc, err := registryIndex(cfg)
+	if err != nil { registryResolves.WithLabelValues("error").Inc(); return nil, fmt.Errorf("registry: %w", err) }
+	ver, rel, err := doc.resolve(env.Module, env.Version)
+	if err != nil { registryResolves.WithLabelValues("no_match").Inc(); return nil, err }
+	served := servedRelease(cfg, doc, ModuleRelease{Module: env.Module, Constraint: env.Version, Version: ver, SHA256: strings.ToLower(rel.SHA256), CID: rel.CID, URL: rel.URL, Index: doc.Version}) // modupdate.go
+	if env.SHA256 != "" && !strings.EqualFold(env.SHA256, served.SHA256) { registryResolves.WithLabelValues("error").Inc(); return nil, fmt.Errorf("sha256 mismatch: %s@%s is %s", env.Module, served.Version, served.SHA256) }
+	registryResolves.WithLabelValues("resolved").Inc()
+	rec.Resolved = &served
+	rec.SHA256 = served.SHA256
+	rec.decide("registry=" + env.Module + "@" + served.Version)
+	src := *env
+	src.SHA256, src.CID, src.URL = served.SHA256, served.CID, served.URL
+	return &src, nil
+}
+
+// registryIndex returns the verified index. Past registry_ttl the current
+// one is returned while a new one is read in the background; past its
+// expires it is only replaced, never returned.
+func registryIndex(cfg Config) (*registryDoc, error) {
+	registry.Lock()
+	if registry.doc != nil && registry.src == cfg.RegistryURL && !registry.doc.expired() {
+		doc := registry.doc
+		if time.Since(registry.at) >= cfg.RegistryTTL && !registry.loading { registry.loading = true; go reloadRegistry(cfg) }
+		registry.Unlock()
+		return doc, nil
+	}
+	registry.Unlock()
+	return reloadRegistry(cfg)
+}
+
+// reloadRegistry reads the index and swaps it in; on failure, or when it is
+// older than the index in use or the highest one ever accepted, the current
+// one stays while it has not expired.
+func reloadRegistry(cfg Config) (*registryDoc, error) {
+	doc, err := loadRegistry(cfg)
+	registry.Lock()
+	defer registry.Unlock()
+	registry.loading = false
+	cur := registry.doc
+	if registry.src != cfg.RegistryURL { cur = nil }
+	if err == nil && cur != nil && doc.Version < cur.Version { err = fmt.Errorf("index version %d older than %d", doc.Version, cur.Version) }
+	if err == nil {
+		if floor := registryFloor(cfg); doc.Version < floor { err = fmt.Errorf("index version %d older than %d, the highest accepted", doc.Version, floor) }
+	}
+	if err != nil {
+		if cur == nil || cur.expired() { return nil, err }
+		fmt.Printf("[registry] keeping index version %d: %v\n", cur.Version, err)
+		registry.at = time.Now()
+		return cur, nil
+	}
+	if cur == nil || doc.Version != cur.Version {
+		fmt.Println("[registry] index version", doc.Version)
+		if cur != nil { go revalidatePins(cfg, doc) }
+	}
+	registry.doc, registry.src, registry.at = doc, cfg.RegistryURL, time.Now()
+	registryIndexVer.Set(float64(doc.Version))
+	if doc.Version > registryFloor(cfg) {
+		os.MkdirAll(cfg.CacheDir, 0o755)
+		if err := writeFileAtomic(registryFloorFile(cfg), []byte(strconv.FormatInt(doc.Version, 10)), 0o644); err != nil { fmt.Println("[registry] version floor:", err) }
+	}
+	return doc, nil
+}
+
+// registryFloorFile keeps the highest index version accepted from
+// registry_url, across restarts.
+func registryFloorFile(cfg Config) string {
+	sum := sha256.Sum256([]byte(cfg.RegistryURL))
+	return filepath.Join(cfg.CacheDir, "registry-"+hex.EncodeToString(sum[:8])+".version")
+}
+
+func registryFloor(cfg Config) int64 {
+	b, err := os.ReadFile(registryFloorFile(cfg))
+	if err != nil { return 0 }
+	n, _ := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	return n
+}
+
+func loadRegistry(cfg Config) (*registryDoc, error) {
+	var raw []byte
+	if strings.Contains(cfg.RegistryURL, "://") {
+		resp, err := fetchURL(cfg, cfg.RegistryURL)
+		if err != nil { return nil, err }
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 { return nil, fmt.Errorf("index status %d", resp.StatusCode) }
+		if raw, err = io.ReadAll(io.LimitReader(resp.Body, 32<<20)); err != nil { return nil, err }
+	} else {
+		var err error
+		if raw, err = os.ReadFile(cfg.RegistryURL); err != nil { return nil, err }
+	}
+	var env dsseEnvelope
+	if err := json.Unmarshal(raw, &env); err != nil { return nil, fmt.Errorf("index: %w", err) }
+	if env.PayloadType != registryPayloadType { return nil, fmt.Errorf("index payloadType %q", env.PayloadType) }
+	payload, err := base64.StdEncoding.DecodeString(env.Payload)
+	if err != nil { return nil, fmt.Errorf("index payload: %w", err) }
+	if !ed25519Signed(cfg.RegistryKeys, env, payload) { return nil, errors.New("index: no valid signature from registry_keys") }
+	var doc registryDoc
+	if err := json.Unmarshal(payload, &doc); err != nil { return nil, fmt.Errorf("index: %w", err) }
+	if doc.expired() { return nil, fmt.Errorf("index version %d expired %s", doc.Version, doc.Expires.Format(time.RFC3339)) }
+	return &doc, nil
+}
+
+// resolve picks the highest non-yanked release of module within constraint.
+func (d *registryDoc) resolve(module, constraint string) (string, registryRelease, error) {
+	cs, err := parseConstraint(constraint)
+	if err != nil { return "", registryRelease{}, err }
+	rels, ok := d.Modules[module]
+	if !ok { return "", registryRelease{}, fmt.Errorf("registry has no module %s", module) }
+	type cand struct {
+		v   semver
+		raw string
+	}
+	var cands []cand
+	for raw, rel := range rels {
+		v, err := parseSemver(raw)
+		if err != nil || rel.Yanked || !sha256Hex.MatchString(rel.SHA256) || (rel.URL == "" && rel.CID == "") || !cs.match(v) { continue }
+		cands = append(cands, cand{v, raw})
+	}
+	if len(cands) == 0 { return "", registryRelease{}, fmt.Errorf("no release of %s matches %s", module, constraint) }
+	sort.Slice(cands, func(i, j int) bool { return cands[i].v.less(cands[j].v) })
+	best := cands[len(cands)-1]
+	return best.raw, rels[best.raw], nil
+}
+
+// --- Semver ---
+type semver struct {
+	major, minor, patch int
+	pre                 string
+}
+
+func (a semver) less(b semver) bool {
+	if a.major != b.major { return a.major < b.major }
+	if a.minor != b.minor { return a.minor < b.minor }
+	if a.patch != b.patch { return a.patch < b.patch }
+	if a.pre == "" || b.pre == "" { return a.pre != "" && b.pre == "" }
+	return preLess(a.pre, b.pre)
+}
+
+// preLess orders prerelease identifiers (semver 11.4): numeric ones by
+// value and below alphanumeric ones, a shorter list first when it is a
+// prefix of the other.
+func preLess(a, b string) bool {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] { continue }
+		x, xerr := strconv.ParseUint(as[i], 10, 64)
+		y, yerr := strconv.ParseUint(bs[i], 10, 64)
+		switch {
+		case xerr == nil && yerr == nil: return x < y
+		case xerr == nil || yerr == nil: return xerr == nil
+		}
+		return as[i] < bs[i]
+	}
+	return len(as) < len(bs)
+}
+
+func parseSemver(s string) (semver, error) {
+	v, n, err := parsePartial(s)
+	if err == nil && n < 3 { err = fmt.Errorf("version %q: want major.minor.patch", s) }
+	return v, err
+}
+
+// parsePartial parses 1, 1.2, 1.2.3 (x or * for a missing part) and returns
+// how many parts were given.
+func parsePartial(s string) (semver, int, error) {
+	s = strings.TrimPrefix(s, "v")
+	s, _, _ = strings.Cut(s, "+")
+	var v semver
+	s, v.pre, _ = strings.Cut(s, "-")
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 { return v, 0, fmt.Errorf("version %q", s) }
+	n := 0
+	for i, p := range parts {
+		if p == "x" || p == "X" || p == "*" { break }
+		x, err := strconv.Atoi(p)
+		if err != nil || x < 0 { return v, 0, fmt.Errorf("version %q", s) }
+		switch i {
+		case 0: v.major = x
+		case 1: v.minor = x
+		case 2: v.patch = x
+		}
+		n++
+	}
+	if v.pre != "" && n < 3 { return v, 0, fmt.Errorf("version %q: prerelease needs major.minor.patch", s) }
+	return v, n, nil
+}
+
+type comparator struct {
+	op string // = > >= < <=
+	v  semver
+}
+
+type constraint []comparator
+
+// parseConstraint parses a version constraint; see the block comment above.
+func parseConstraint(s string) (constraint, error) {
+	var cs constraint
+	for _, f := range strings.Fields(s) {
+		op := ""
+		for _, o := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+			if rest, ok := strings.CutPrefix(f, o); ok { op, f = o, rest; break }
+		}
+		if f == "*" || f == "x" || f == "latest" {
+			if op != "" { return nil, fmt.Errorf("constraint %q", s) }
+			continue
+		}
+		v, n, err := parsePartial(f)
+		if err != nil { return nil, fmt.Errorf("constraint %q: %w", s, err) }
+		if n == 0 { continue }
+		upper := func(major, minor, patch int) { cs = append(cs, comparator{">=", v}, comparator{"<", semver{major: major, minor: minor, patch: patch}}) }
+		switch {
+		case op == "^" && (v.major > 0 || n == 1):
+			upper(v.major+1, 0, 0)
+		case op == "^" && (v.minor > 0 || n == 2):
+			upper(0, v.minor+1, 0)
+		case op == "^":
+			upper(0, 0, v.patch+1)
+		case op == "~" && n == 1, (op == "" || op == "=") && n == 1:
+			upper(v.major+1, 0, 0)
+		case op == "~", (op == "" || op == "=") && n == 2:
+			upper(v.major, v.minor+1, 0)
+		case op == "":
+			cs = append(cs, comparator{"=", v})
+		case n < 3 && (op == ">" || op == "<="): // past the whole partial range
+			next := semver{major: v.major + 1}
+			if n == 2 { next = semver{major: v.major, minor: v.minor + 1} }
+			cs = append(cs, comparator{map[string]string{">": ">=", "<=": "<"}[op], next})
+		default:
+			cs = append(cs, comparator{op, v})
+		}
+	}
+	return cs, nil
+}
+
+// match reports whether v satisfies every comparator. A prerelease only
+// matches when some comparator names the same major.minor.patch with one.
+func (cs constraint) match(v semver) bool {
+	if v.pre != "" {
+		named := false
+		for _, c := range cs {
+			if c.v.pre != "" && c.v.major == v.major && c.v.minor == v.minor && c.v.patch == v.patch { named = true }
+		}
+		if !named { return false }
+	}
+	for _, c := range cs {
+		lt, gt := v.less(c.v), c.v.less(v)
+		ok := false
+		switch c.op {
+		case "=": ok = !lt && !gt
+		case ">": ok = gt
+		case ">=": ok = !lt
+		case "<": ok = lt
+		case "<=": ok = !gt
+		}
+		if !ok { return false }
+	}
+	return true
+}
+
+func validRegistry(c Config) error {
+	if c.RegistryURL == "" { return nil }
+	if len(c.RegistryKeys) == 0 { return errors.New("registry_keys: required with registry_url") }
+	if c.RegistryTTL <= 0 { return errors.New("registry_ttl: must be > 0") }
+	return nil
+}
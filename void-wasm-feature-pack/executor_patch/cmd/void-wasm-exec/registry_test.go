@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConstraintMatch(t *testing.T) {
+	for _, tc := range []struct {
+		constraint string
+		yes, no    []string
+	}{
+		{"1.2.3", []string{"1.2.3"}, []string{"1.2.4", "1.2.3-rc.1"}},
+		{"=1.2", []string{"1.2.0", "1.2.9"}, []string{"1.3.0", "1.1.9"}},
+		{"^1.2", []string{"1.2.0", "1.9.9"}, []string{"2.0.0", "1.1.0", "2.0.0-rc.1"}},
+		{"^0.2.3", []string{"0.2.3", "0.2.9"}, []string{"0.3.0", "0.2.2"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4"}},
+		{"~1.2", []string{"1.2.0", "1.2.7"}, []string{"1.3.0"}},
+		{"~1", []string{"1.0.0", "1.9.0"}, []string{"2.0.0"}},
+		{"1.x", []string{"1.0.0", "1.99.0"}, []string{"2.0.0", "0.9.0"}},
+		{"1.2.x", []string{"1.2.0", "1.2.5"}, []string{"1.3.0"}},
+		{"*", []string{"0.0.1", "9.9.9"}, []string{"1.0.0-rc.1"}},
+		{">=1.2 <2", []string{"1.2.0", "1.9.9"}, []string{"1.1.9", "2.0.0"}},
+		{">1.2", []string{"1.3.0", "2.0.0"}, []string{"1.2.0", "1.2.9"}},
+		{"<=1.2", []string{"1.2.9", "1.0.0"}, []string{"1.3.0"}},
+		{">1", []string{"2.0.0"}, []string{"1.9.9"}},
+		{"<=1", []string{"1.9.9"}, []string{"2.0.0"}},
+		{"<1.2", []string{"1.1.9"}, []string{"1.2.0"}},
+		{">1.2.3", []string{"1.2.4"}, []string{"1.2.3"}},
+		{">=1.2.3-rc.2", []string{"1.2.3-rc.2", "1.2.3-rc.10", "1.2.3", "1.5.0"}, []string{"1.2.3-rc.1", "1.2.4-rc.3"}},
+	} {
+		cs, err := parseConstraint(tc.constraint)
+		if err != nil { t.Errorf("%s: %v", tc.constraint, err); continue }
+		for _, v := range tc.yes {
+			if sv, _ := parseSemver(v); !cs.match(sv) { t.Errorf("%s should match %s", tc.constraint, v) }
+		}
+		for _, v := range tc.no {
+			if sv, _ := parseSemver(v); cs.match(sv) { t.Errorf("%s should not match %s", tc.constraint, v) }
+		}
+	}
+	for _, bad := range []string{">=*", "1.2.3.4", "^a", "1.2-rc.1"} {
+		if _, err := parseConstraint(bad); err == nil { t.Errorf("%q parsed", bad) }
+	}
+}
+
+// semver 11.4's own example order.
+func TestSemverOrder(t *testing.T) {
+	order := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0-rc.9", "1.0.0-rc.10", "1.0.0", "1.0.1", "1.1.0", "2.0.0"}
+	for i := 0; i+1 < len(order); i++ {
+		a, _ := parseSemver(order[i])
+		b, _ := parseSemver(order[i+1])
+		if !a.less(b) || b.less(a) { t.Errorf("want %s < %s", order[i], order[i+1]) }
+	}
+}
+
+// An expired index stops resolution even when its replacement is refused,
+// and a rolled-back index is refused after a restart.
+func TestRegistryIndexFreshness(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	dir := t.TempDir()
+	der, _ := x509.MarshalPKIXPublicKey(pub)
+	key := filepath.Join(dir, "key.pem")
+	os.WriteFile(key, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600)
+	cfg := Config{RegistryURL: filepath.Join(dir, "index.json"), RegistryKeys: []string{key}, RegistryTTL: time.Hour, CacheDir: filepath.Join(dir, "cache")}
+	publish := func(version int64, expires time.Time) {
+		payload, _ := json.Marshal(registryDoc{Version: version, Expires: expires, Modules: map[string]map[string]registryRelease{"m": {"1.0.0": {SHA256: strings.Repeat("a", 64), URL: "https://example.org/m.wasm"}}}})
+		sig := ed25519.Sign(priv, dssePAE(registryPayloadType, payload))
+		b, _ := json.Marshal(dsseEnvelope{PayloadType: registryPayloadType, Payload: base64.StdEncoding.EncodeToString(payload), Signatures: []dsseSignature{{Sig: base64.StdEncoding.EncodeToString(sig)}}})
+		os.WriteFile(cfg.RegistryURL, b, 0o600)
+	}
+	reset := func() { registry.Lock(); registry.doc, registry.src, registry.at = nil, "", time.Time{}; registry.Unlock() }
+	reset()
+	t.Cleanup(reset)
+
+	publish(5, time.Now().Add(time.Hour))
+	if doc, err := registryIndex(cfg); err != nil || doc.Version != 5 { t.Fatalf("index: %v %v", doc, err) }
+	publish(4, time.Now().Add(time.Hour))
+	if doc, err := reloadRegistry(cfg); err != nil || doc.Version != 5 { t.Errorf("rollback replaced the index: %v %v", doc, err) }
+	reset() // a restart
+	if _, err := registryIndex(cfg); err == nil { t.Error("rolled-back index accepted after a restart") }
+
+	publish(6, time.Now().Add(time.Hour))
+	if doc, err := registryIndex(cfg); err != nil || doc.Version != 6 { t.Fatalf("index: %v %v", doc, err) }
+	registry.Lock(); registry.doc.Expires = time.Now().Add(-time.Second); registry.Unlock()
+	os.Remove(cfg.RegistryURL) // the registry is blocked
+	if _, err := registryIndex(cfg); err == nil { t.Error("expired index still served") }
+}
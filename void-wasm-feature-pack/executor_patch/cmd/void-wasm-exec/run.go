@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// --- One-shot local run: void-wasm-exec run [flags] module.wasm ---
+// Runs a local module without relay/SSE: emitted events and sysret replies are
+// printed instead of posted, followed by the syscall timeline.
+
+// localSink, when set, receives events instead of the relay pipeline.
+var localSink func(ev map[string]any)
+
+// jsonArg accepts inline JSON or @file.
+func jsonArg(s string, dst any) error {
+	if s == "" { return nil }
+	b := []byte(s)
+	if strings.HasPrefix(s, "@") {
+		var err error
+		if b, err = os.ReadFile(s[1:]); err != nil { return err }
+	}
+	return json.Unmarshal(b, dst)
+}
+
+func runLocal(args []string) int {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	inputs := fs.String("inputs", "", "inputs JSON or @file")
+	caps := fs.String("caps", "emit", "comma-separated caps (emit,kv,http)")
+	hosts := fs.String("hosts", "localhost", "comma-separated hosts allowed for http.fetch")
+	limits := fs.String("limits", "", `limits JSON or @file, e.g. {"timeout_ms":2000,"max_kb":64}`)
+	golden := fs.String("golden", "", "dir of <case>.inputs.json to diff against <case>.golden.ndjson")
+	update := fs.Bool("update", false, "with --golden: rewrite the golden files")
+	schemaAction := fs.String("schema-action", "flag", "events failing the module's void.manifest: flag | reject | off")
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, "usage: void-wasm-exec run [flags] module.wasm"); fs.PrintDefaults() }
+	fs.Parse(args)
+	if fs.NArg() != 1 { fs.Usage(); return 2 }
+	path := fs.Arg(0)
+
+	cfg := defaultConfig()
+	cfg.Tenant = defaultTenant
+	cfg.AllowCaps, cfg.AllowHTTPHosts = parseList(*caps), parseList(*hosts)
+	cfg.EventSchemaAction = *schemaAction
+	if !slices.Contains([]string{"flag", "reject", "off"}, *schemaAction) { fmt.Fprintln(os.Stderr, "--schema-action: flag, reject or off"); return 2 }
+	env := &Envelope{Type: "signal.wasm", Module: path, Caps: cfg.AllowCaps}
+	if err := jsonArg(*inputs, &env.Inputs); err != nil { fmt.Fprintln(os.Stderr, "--inputs:", err); return 2 }
+	if err := jsonArg(*limits, &env.Limits); err != nil { fmt.Fprintln(os.Stderr, "--limits:", err); return 2 }
+	if v, ok := env.Limits["timeout_ms"].(float64); ok && v > 0 { cfg.DefaultTO = time.Duration(v) * time.Millisecond }
+	if v, ok := env.Limits["max_kb"].(float64); ok && v > 0 { cfg.MaxHTTPKB = int(v) }
+	if *golden != "" { return runGolden(cfg, path, *golden, *update) }
+
+	localSink = func(ev map[string]any) { b, _ := json.Marshal(ev); fmt.Println("event  ", string(b)) }
+	t0 := time.Now()
+	rec := &RunRecord{ID: newRunID(t0), Module: path, Tenant: defaultTenant, Path: pathStable, Started: t0}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DefaultTO)
+	defer cancel()
+	err := runWasm(ctx, cfg, path, env, rec)
+	for _, s := range rec.Syscalls {
+		fmt.Printf("syscall %-20s result=%s at=%dms took=%.2fms\n", s.Kind, s.Result, s.AtMs, s.Ms)
+	}
+	if rec.SyscallsDropped > 0 { fmt.Printf("syscall (%d more not shown)\n", rec.SyscallsDropped) }
+	if err != nil { fmt.Printf("result  error: %v (%dms)\n", err, time.Since(t0).Milliseconds()); return 1 }
+	fmt.Printf("result  ok (%dms)\n", time.Since(t0).Milliseconds())
+	return 0
+}
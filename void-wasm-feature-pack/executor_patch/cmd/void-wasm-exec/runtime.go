@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// --- Shared wazero runtimes ---
+// One runtime (with WASI and the void host module, deadline.go, already
+// instantiated) per distinct runtime config, i.e. one for stable and one per
+// canary engine/memory setting. Each run gets
+// its own anonymous module instance and module config, so runs stay isolated.
+// runtime_per_run=true restores a fresh runtime per envelope for debugging.
+
+var runtimesCreated = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_runtimes_created_total", Help: "wazero runtimes created by mode"}, []string{"mode"})
+
+var (
+	runtimesMu sync.Mutex
+	runtimes   = map[string]wazero.Runtime{}
+)
+
+func runtimeKey(cfg Config, path string) string {
+	if path != pathCanary { return pathStable }
+	return fmt.Sprintf("%s/%s/%d", pathCanary, cfg.CanaryEngine, cfg.CanaryMemMB)
+}
+
+func newRuntime(ctx context.Context, cfg Config, path, mode string) (wazero.Runtime, error) {
+	r := wazero.NewRuntimeWithConfig(ctx, runtimeConfig(cfg, path))
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil { r.Close(ctx); return nil, err }
+	if err := instantiateVoidHost(ctx, r); err != nil { r.Close(ctx); return nil, err }
+	runtimesCreated.WithLabelValues(mode).Inc()
+	return r, nil
+}
+
+// acquireRuntime returns the runtime for this run and a release func.
+func acquireRuntime(cfg Config, path string) (wazero.Runtime, func(), error) {
+	if cfg.RuntimePerRun {
+		r, err := newRuntime(context.Background(), cfg, path, "per_run")
+		if err != nil { return nil, nil, err }
+		return r, func() { r.Close(context.Background()) }, nil
+	}
+	key := runtimeKey(cfg, path)
+	runtimesMu.Lock(); defer runtimesMu.Unlock()
+	if r, ok := runtimes[key]; ok { return r, func() {}, nil }
+	r, err := newRuntime(context.Background(), cfg, path, "shared")
+	if err != nil { return nil, nil, err }
+	runtimes[key] = r
+	return r, func() {}, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// --- Run tokens: signed identity on module traffic ---
+// A service receiving an http.fetch or syscall.graphql request cannot tell
+// which module (or whether any module) sent it. With identity_key set and
+// the service's host in run_token_hosts (matched as allow_http_hosts), the
+// executor attaches a short-lived token in run_token_header
+// (Void-Run-Token), overwriting any the module set: a compact JWS (typ
+// void-run+jwt, EdDSA for a file: key, ES256 for kms:, kid the key_id of
+// /identity/pubkey) with the claims
+//   iss node, sub module, tenant, run, sha256 (module), aud host,
+//   iat, exp (run_token_ttl later), jti
+// so the service can authorize by module and tenant and quote the run in its
+// audit log. A token is minted once per run and host and reused while more
+// than half its ttl is left; the run record lists the jti of each under
+// run_tokens. A redirect to another host never carries it (httpclient.go). Signatures count in void_wasm_identity_signatures_total
+// {kind="run_token"}. `void-wasm-exec run-token-verify --key pub.pem
+// [--aud host] <token>` checks one.
+
+const runTokenType = "void-run+jwt"
+
+type RunTokenClaims struct {
+	Iss    string `json:"iss"`
+	Sub    string `json:"sub"`
+	Tenant string `json:"tenant"`
+	Run    string `json:"run"`
+	SHA256 string `json:"sha256"`
+	Aud    string `json:"aud"`
+	Iat    int64  `json:"iat"`
+	Exp    int64  `json:"exp"`
+	Jti    string `json:"jti"`
+}
+
+type mintedToken struct {
+	token string
+	exp   time.Time
+}
+
+var b64url = base64.RawURLEncoding
+
+// setRunToken adds rec's token for u to h when u's host takes one.
+func setRunToken(cfg Config, rec *RunRecord, u *url.URL, h http.Header) {
+	if identity == nil || rec == nil || !hostAllowed(u, cfg.RunTokenHosts) { return }
+	aud := u.Hostname()
+	if t, ok := rec.runTokens[aud]; ok && time.Until(t.exp) > cfg.RunTokenTTL/2 { h.Set(cfg.RunTokenHeader, t.token); return }
+	t, jti, err := mintRunToken(cfg, rec, aud)
+	if err != nil {
+		identitySigned.WithLabelValues("run_token", "error").Inc()
+		fmt.Println("[identity] run token", aud+":", err, rec.corr())
+		return
+	}
+	identitySigned.WithLabelValues("run_token", "ok").Inc()
+	if rec.runTokens == nil { rec.runTokens = map[string]mintedToken{} }
+	rec.runTokens[aud] = t
+	rec.RunTokens = append(rec.RunTokens, jti)
+	h.Set(cfg.RunTokenHeader, t.token)
+}
+
+func mintRunToken(cfg Config, rec *RunRecord, aud string) (mintedToken, string, error) {
+	now := time.Now()
+	nonce := make([]byte, 12)
+	rand.Read(nonce)
+	c := RunTokenClaims{Iss: nodeID(cfg), Sub: rec.Module, Tenant: rec.Tenant, Run: rec.ID, SHA256: rec.SHA256, Aud: aud, Iat: now.Unix(), Exp: now.Add(cfg.RunTokenTTL).Unix(), Jti: hex.EncodeToString(nonce)}
+	alg := "EdDSA"
+	if identity.alg() != "ed25519" { alg = "ES256" }
+	hdr, _ := json.Marshal(map[string]string{"alg": alg, "typ": runTokenType, "kid": identityKeyID})
+	body, _ := json.Marshal(c)
+	input := b64url.EncodeToString(hdr) + "." + b64url.EncodeToString(body)
+	sig, err := identity.sign([]byte(input))
+	if err == nil && alg == "ES256" { sig, err = jwsES256(sig) }
+	if err != nil { return mintedToken{}, "", err }
+	return mintedToken{token: input + "." + b64url.EncodeToString(sig), exp: time.Unix(c.Exp, 0)}, c.Jti, nil
+}
+
+// jwsES256 turns an ASN.1 ECDSA signature into JWS's fixed r||s.
+func jwsES256(der []byte) ([]byte, error) {
+	var rs struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(der, &rs); err != nil { return nil, err }
+	out := make([]byte, 64)
+	rs.R.FillBytes(out[:32])
+	rs.S.FillBytes(out[32:])
+	return out, nil
+}
+
+// verifyRunToken checks tok's signature with pub and returns its claims;
+// expiry and audience are left to the caller.
+func verifyRunToken(pub any, tok string) (RunTokenClaims, error) {
+	var c RunTokenClaims
+	parts := strings.Split(tok, ".")
+	if len(parts) != 3 { return c, errors.New("not a compact JWS") }
+	var hdr struct{ Alg, Typ, Kid string }
+	raw, err := b64url.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(raw, &hdr) != nil || hdr.Typ != runTokenType { return c, errors.New("not a void run token") }
+	sig, err := b64url.DecodeString(parts[2])
+	if err != nil { return c, err }
+	input := []byte(parts[0] + "." + parts[1])
+	ok := false
+	switch k := pub.(type) {
+	case ed25519.PublicKey:
+		ok = hdr.Alg == "EdDSA" && ed25519.Verify(k, input, sig)
+	case *ecdsa.PublicKey:
+		sum := sha256.Sum256(input)
+		ok = hdr.Alg == "ES256" && len(sig) == 64 && ecdsa.Verify(k, sum[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]))
+	}
+	if !ok { return c, errors.New("signature does not verify") }
+	raw, err = b64url.DecodeString(parts[1])
+	if err == nil { err = json.Unmarshal(raw, &c) }
+	return c, err
+}
+
+// runTokenVerifyLocal: void-wasm-exec run-token-verify --key pub.pem [--aud host] <token|->
+func runTokenVerifyLocal(args []string) int {
+	fs := flag.NewFlagSet("run-token-verify", flag.ExitOnError)
+	keyPath := fs.String("key", "", "PEM public key (GET /identity/pubkey)")
+	aud := fs.String("aud", "", "expected audience (the receiving host)")
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, "usage: void-wasm-exec run-token-verify --key pub.pem [--aud host] <token|->"); fs.PrintDefaults() }
+	fs.Parse(args)
+	if fs.NArg() != 1 || *keyPath == "" { fs.Usage(); return 2 }
+	fail := func(msg ...any) int { fmt.Fprintln(os.Stderr, append([]any{"run-token-verify:"}, msg...)...); return 1 }
+	tok := fs.Arg(0)
+	if tok == "-" {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil { return fail(err) }
+		tok = strings.TrimSpace(string(b))
+	}
+	kb, err := os.ReadFile(*keyPath)
+	if err != nil { return fail(err) }
+	blk, _ := pem.Decode(kb)
+	if blk == nil { return fail("no PEM block in", *keyPath) }
+	pub, err := x509.ParsePKIXPublicKey(blk.Bytes)
+	if err != nil { return fail(err) }
+	c, err := verifyRunToken(pub, tok)
+	if err != nil { return fail(err) }
+	fmt.Printf("run      %s module=%s tenant=%s\nnode     %s aud=%s jti=%s\n", c.Run, c.Sub, c.Tenant, c.Iss, c.Aud, c.Jti)
+	if *aud != "" && c.Aud != *aud { return fail("audience", c.Aud, "is not", *aud) }
+	if time.Now().Unix() >= c.Exp { return fail("expired", time.Unix(c.Exp, 0).UTC().Format(time.RFC3339)) }
+	fmt.Println("OK")
+	return 0
+}
+
+func validRunTokens(c Config) error {
+	if len(c.RunTokenHosts) == 0 { return nil }
+	if c.IdentityKey == "" { return errors.New("run_token_hosts: needs identity_key") }
+	if c.RunTokenTTL < 10*time.Second || c.RunTokenTTL > time.Hour { return fmt.Errorf("run_token_ttl: must be 10s..1h, got %s", c.RunTokenTTL) }
+	if c.RunTokenHeader == "" || strings.ContainsAny(c.RunTokenHeader, " :\t\r\n") { return fmt.Errorf("run_token_header: invalid %q", c.RunTokenHeader) }
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyRunToken(t *testing.T) {
+	otherPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	for _, alg := range []string{"ed25519", "ecdsa-p256-sha256"} {
+		t.Run(alg, func(t *testing.T) {
+			pub, key := testIdentity(t, alg)
+			cfg := Config{NodeID: "node-a", RunTokenTTL: time.Minute}
+			rec := &RunRecord{ID: "r1", Module: "demo/hello", Tenant: "acme", SHA256: strings.Repeat("ab", 32)}
+			tok, jti, err := mintRunToken(cfg, rec, "api.example.com")
+			if err != nil { t.Fatal(err) }
+			c, err := verifyRunToken(pub, tok.token)
+			if err != nil { t.Fatal(err) }
+			want := RunTokenClaims{Iss: "node-a", Sub: "demo/hello", Tenant: "acme", Run: "r1", SHA256: rec.SHA256, Aud: "api.example.com", Iat: c.Iat, Exp: c.Iat + 60, Jti: jti}
+			if c != want { t.Errorf("claims = %+v, want %+v", c, want) }
+
+			parts := strings.Split(tok.token, ".")
+			header := func(alg string) string {
+				b, _ := json.Marshal(map[string]string{"alg": alg, "typ": runTokenType, "kid": identityKeyID})
+				return b64url.EncodeToString(b)
+			}
+			forged := c
+			forged.Tenant = "other"
+			body, _ := json.Marshal(forged)
+			swapped := map[string]string{"ed25519": "ES256", "ecdsa-p256-sha256": "EdDSA"}[alg]
+			for name, bad := range map[string]string{
+				"wrong alg":     header(swapped) + "." + parts[1] + "." + parts[2],
+				"alg none":      header("none") + "." + parts[1] + ".",
+				"edited claims": parts[0] + "." + b64url.EncodeToString(body) + "." + parts[2],
+				"not jws":       parts[0] + "." + parts[1],
+			} {
+				if _, err := verifyRunToken(pub, bad); err == nil { t.Errorf("%s: verified", name) }
+			}
+			if _, err := verifyRunToken(otherPub, tok.token); err == nil { t.Error("verified with another key") }
+
+			expired, _, _ := mintRunToken(Config{NodeID: "node-a", RunTokenTTL: -time.Second}, rec, "api.example.com")
+			for _, tc := range []struct {
+				name string
+				args []string
+				want int
+			}{
+				{"valid", []string{"--key", key, "--aud", "api.example.com", tok.token}, 0},
+				{"no audience check", []string{"--key", key, tok.token}, 0},
+				{"wrong audience", []string{"--key", key, "--aud", "evil.example.com", tok.token}, 1},
+				{"expired", []string{"--key", key, expired.token}, 1},
+			} {
+				if got := runTokenVerifyLocal(tc.args); got != tc.want { t.Errorf("%s: run-token-verify = %d, want %d", tc.name, got, tc.want) }
+			}
+		})
+	}
+}
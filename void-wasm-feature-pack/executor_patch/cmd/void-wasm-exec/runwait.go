@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// --- Run lookup and long-poll (GET /runs/{id}, GET /runs/{id}/wait) ---
+// A caller that submitted through the relay (or POST /admin/envelopes,
+// /intent/execute-wasm) does not know the run id the executor assigns, so
+// {id} may also be the envelope's meta.request_id (what the relay sets),
+// meta.correlation_id (correlation.go) or an /intent/execute-wasm
+// request_id; the executor remembers the last runAliasMax of those.
+// GET /runs/{id}/wait?timeout=30s (default 30s, at most 5m) answers 200 with
+// the run record as soon as the run is booked (at once if it already is) or
+// 202 {"status":"pending"} when the timeout runs out first, so synchronous
+// callers can loop on it instead of following GET /stream. Both routes are
+// served on the admin server and, when enabled, on intent_addr; the record
+// comes without its envelope, whose inputs and env may hold secrets.
+
+const (
+	runAliasMax = 10000
+	runWaitMax  = 5 * time.Minute
+)
+
+var (
+	runWaitMu  sync.Mutex
+	runWaiters = map[string][]chan *RunRecord{} // run id or alias -> waiting requests
+	runAliases = map[string]string{}            // alias -> run id
+	aliasOrder []string                         // oldest first, for eviction
+)
+
+// runAliasesOf is what a run can be looked up by besides its id.
+func runAliasesOf(env *Envelope) []string {
+	var out []string
+	if id, _ := env.Meta["correlation_id"].(string); id != "" { out = append(out, id) }
+	if id, _ := env.Meta["request_id"].(string); id != "" { out = append(out, id) }
+	if env.intent != "" { out = append(out, env.intent) }
+	return out
+}
+
+// runStarted records env's aliases for run (policyStage).
+func runStarted(env *Envelope, run string) {
+	runWaitMu.Lock(); defer runWaitMu.Unlock()
+	for _, a := range runAliasesOf(env) {
+		if _, seen := runAliases[a]; !seen { aliasOrder = append(aliasOrder, a) }
+		runAliases[a] = run
+	}
+	for len(aliasOrder) > runAliasMax {
+		delete(runAliases, aliasOrder[0])
+		aliasOrder = aliasOrder[1:]
+	}
+}
+
+// runBooked wakes everyone waiting on rec (job.finish, after history.Put).
+func runBooked(rec *RunRecord) {
+	keys := []string{rec.ID}
+	if rec.Envelope != nil { keys = append(keys, runAliasesOf(rec.Envelope)...) }
+	runWaitMu.Lock(); defer runWaitMu.Unlock()
+	for _, k := range keys {
+		for _, ch := range runWaiters[k] { ch <- rec }
+		delete(runWaiters, k)
+	}
+}
+
+func resolveRun(id string) string {
+	runWaitMu.Lock(); defer runWaitMu.Unlock()
+	if run, ok := runAliases[id]; ok { return run }
+	return id
+}
+
+func handleRunGet(w http.ResponseWriter, r *http.Request) {
+	rec, ok := history.Get(resolveRun(r.PathValue("id")))
+	if !ok { writeJSON(w, 404, map[string]any{"error": "run not found"}); return }
+	writeJSON(w, 200, runView(rec))
+}
+
+// runView is rec as the run routes answer it.
+func runView(rec *RunRecord) *RunRecord {
+	if rec.Envelope == nil { return rec }
+	v := *rec
+	v.Envelope = nil
+	return &v
+}
+
+func handleRunWait(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	timeout := 30 * time.Second
+	if t := r.URL.Query().Get("timeout"); t != "" {
+		d, err := time.ParseDuration(t)
+		if ms, nerr := strconv.Atoi(t); nerr == nil { d, err = time.Duration(ms)*time.Millisecond, nil }
+		if err != nil || d < 0 { writeJSON(w, 400, map[string]any{"error": "timeout: a duration (30s) or milliseconds"}); return }
+		timeout = min(d, runWaitMax)
+	}
+	// subscribe before looking so a run booked in between is not missed
+	ch := make(chan *RunRecord, 1)
+	runWaitMu.Lock()
+	runWaiters[id] = append(runWaiters[id], ch)
+	runWaitMu.Unlock()
+	defer func() {
+		runWaitMu.Lock(); defer runWaitMu.Unlock()
+		ws := runWaiters[id]
+		for i, c := range ws {
+			if c == ch { ws = append(ws[:i], ws[i+1:]...); break }
+		}
+		if len(ws) == 0 { delete(runWaiters, id) } else { runWaiters[id] = ws }
+	}()
+	if rec, ok := history.Get(resolveRun(id)); ok { writeJSON(w, 200, runView(rec)); return }
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case rec := <-ch:
+		writeJSON(w, 200, runView(rec))
+	case <-t.C:
+		writeJSON(w, 202, map[string]any{"id": id, "status": "pending"})
+	case <-r.Context().Done():
+	}
+}
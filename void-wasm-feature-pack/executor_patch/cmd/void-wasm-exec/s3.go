@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// --- S3 / MinIO: module source and artifact sink ---
+// Envelope urls may be s3://bucket/key; downloads go through fetchModule as
+// usual, so sha256 verification and the cache apply. Requests are signed
+// with SigV4 when s3_access_key is set (anonymous otherwise), path-style
+// against s3_endpoint (MinIO) or virtual-hosted on AWS. Objects encrypted
+// with SSE-KMS need nothing extra to read.
+//
+// With artifacts_url s3://bucket/prefix, run artifacts (artifacts.go) are
+// stored content-addressed as <prefix>/<sha256>, SSE-KMS encrypted when
+// s3_kms_key_id is set.
+
+func parseS3(raw string) (bucket, key string, err error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "s3" || u.Host == "" { return "", "", fmt.Errorf("invalid s3 url %q", raw) }
+	return u.Host, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+// s3Escape is the SigV4 canonical URI encoding ('/' kept).
+func s3Escape(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 { b.WriteByte(c); continue }
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// awsQuery is the SigV4 canonical query string: pairs sorted by name then
+// value, both encoded like the path but with '/' escaped too.
+func awsQuery(raw string) string {
+	if raw == "" { return "" }
+	enc := func(s string) string {
+		if u, err := url.QueryUnescape(s); err == nil { s = u }
+		return strings.ReplaceAll(s3Escape(s), "/", "%2F")
+	}
+	var pairs [][2]string
+	for _, p := range strings.Split(raw, "&") {
+		k, v, _ := strings.Cut(p, "=")
+		pairs = append(pairs, [2]string{enc(k), enc(v)})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] { return pairs[i][0] < pairs[j][0] }
+		return pairs[i][1] < pairs[j][1]
+	})
+	out := make([]string, len(pairs))
+	for i, p := range pairs { out[i] = p[0] + "=" + p[1] }
+	return strings.Join(out, "&")
+}
+
+// s3Request builds a signed request for bucket/key.
+func s3Request(ctx context.Context, cfg Config, method, bucket, key string, body []byte, hdr http.Header) (*http.Request, error) {
+	var u *url.URL
+	var err error
+	if cfg.S3Endpoint != "" {
+		u, err = url.Parse(strings.TrimRight(cfg.S3Endpoint, "/") + "/" + bucket)
+	} else {
+		u, err = url.Parse("https://" + bucket + ".s3." + cfg.S3Region + ".amazonaws.com")
+	}
+	if err != nil { return nil, err }
+	u.Path += "/" + key
+	u.RawPath = s3Escape(u.Path)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil { return nil, err }
+	for k, v := range hdr { req.Header[k] = v }
+	if cfg.S3AccessKey != "" {
+		sum := sha256.Sum256(body)
+		awsSign(req, cfg, cfg.S3Region, "s3", hex.EncodeToString(sum[:]), time.Now())
+	}
+	return req, nil
+}
+
+func hmacSHA256(key []byte, s string) []byte { h := hmac.New(sha256.New, key); h.Write([]byte(s)); return h.Sum(nil) }
+
+// awsSign adds SigV4 headers for service in region (s3 here, kms for
+// identity keys); every header already set on req is signed.
+func awsSign(req *http.Request, cfg Config, region, service, payloadHash string, now time.Time) {
+	req.Header.Set("x-amz-date", now.UTC().Format("20060102T150405Z"))
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if cfg.S3SessionToken != "" { req.Header.Set("x-amz-security-token", cfg.S3SessionToken) }
+	req.Header.Set("authorization", awsAuthorization(req, cfg.S3AccessKey, cfg.S3SecretKey, region, service, payloadHash))
+}
+
+// awsAuthorization is the SigV4 Authorization value for req as it stands;
+// the request time is its x-amz-date header.
+func awsAuthorization(req *http.Request, accessKey, secretKey, region, service, payloadHash string) string {
+	amzDate := req.Header.Get("x-amz-date")
+	day := amzDate[:min(8, len(amzDate))]
+	names := []string{"host"}
+	for k := range req.Header { names = append(names, strings.ToLower(k)) }
+	sort.Strings(names)
+	var canon strings.Builder
+	for _, n := range names {
+		vs := []string{req.Host}
+		if n != "host" { vs = req.Header.Values(n) }
+		for i, v := range vs { vs[i] = strings.Join(strings.Fields(v), " ") }
+		canon.WriteString(n + ":" + strings.Join(vs, ",") + "\n")
+	}
+	signed := strings.Join(names, ";")
+	creq := strings.Join([]string{req.Method, req.URL.EscapedPath(), awsQuery(req.URL.RawQuery), canon.String(), signed, payloadHash}, "\n")
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	sum := sha256.Sum256([]byte(creq))
+	sts := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	k := hmacSHA256([]byte("AWS4"+secretKey), day)
+	k = hmacSHA256(k, region)
+	k = hmacSHA256(k, service)
+	k = hmacSHA256(k, "aws4_request")
+	return fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signed, hex.EncodeToString(hmacSHA256(k, sts)))
+}
+
+// fetchURL starts a module download (fetchModule reads the body).
+func fetchURL(cfg Config, src string) (*http.Response, error) {
+	if isGitHubAsset(cfg, src) {
+		req, err := githubRequest(cfg, src, "application/octet-stream")
+		if err != nil { return nil, err }
+		return fetchClient.Do(req)
+	}
+	if !strings.HasPrefix(src, "s3://") { return fetchClient.Get(src) }
+	bucket, key, err := parseS3(src)
+	if err != nil { return nil, err }
+	req, err := s3Request(context.Background(), cfg, "GET", bucket, key, nil, nil)
+	if err != nil { return nil, err }
+	return fetchClient.Do(req)
+}
+
+func uploadArtifact(cfg Config, rec *RunRecord, bucket, key, name string, data []byte) error {
+	// metadata must be ASCII: S3 hands back non-ASCII values RFC 2047 encoded, so send them that way
+	hdr := http.Header{"Content-Type": {"application/octet-stream"}, "X-Amz-Meta-Name": {mime.QEncoding.Encode("utf-8", name)}, "X-Amz-Meta-Run": {rec.ID}}
+	if cfg.S3KMSKeyID != "" { hdr.Set("x-amz-server-side-encryption", "aws:kms"); hdr.Set("x-amz-server-side-encryption-aws-kms-key-id", cfg.S3KMSKeyID) }
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.FetchTimeout)
+	defer cancel()
+	return s3Put(ctx, cfg, bucket, key, data, hdr)
+}
+
+func s3Put(ctx context.Context, cfg Config, bucket, key string, data []byte, hdr http.Header) error {
+	req, err := s3Request(ctx, cfg, "PUT", bucket, key, data, hdr)
+	if err != nil { return err }
+	resp, err := fetchClient.Do(req)
+	if err != nil { return err }
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode != 200 { return fmt.Errorf("status %d: %s", resp.StatusCode, bytes.TrimSpace(msg)) }
+	return nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// --- Execution windows ---
+// A schedule limits when a module may run: a list of windows in
+// maintenance_tz, each either days/start/end or a 5-field cron expression
+// (minute hour day-of-month month day-of-week) whose matching minutes are
+// open, e.g. "* 0-6,22-23 * * mon-fri" for weeknights. It comes from the
+// module's policies.d file or else the first matching entry of schedules.
+// Out of window, outside_schedule defer holds the envelope before the
+// pipeline until the next window opens (re-checked at least every minute
+// so reloads apply), at most schedule_hold_max envelopes at once: past that
+// they are refused (scheduled_full, one intake.denied event a second with the
+// count); deny ends it with deny_schedule, and both the run error and a
+// run.denied event say when the next window opens. The next opening is found
+// field by field (a cron month, day or hour that cannot match is skipped
+// whole), not by scanning minutes.
+
+type RunWindow struct {
+	Cron  string   `yaml:"cron"`  // alternative to days/start/end
+	Days  []string `yaml:"days"`  // mon..sun; empty = every day
+	Start string   `yaml:"start"` // HH:MM
+	End   string   `yaml:"end"`   // End < Start wraps past midnight
+}
+
+type ModuleSchedule struct {
+	Modules         []string    `yaml:"modules"` // names or prefix*
+	Tenants         []string    `yaml:"tenants"` // empty = every tenant
+	Schedule        []RunWindow `yaml:"schedule"`
+	OutsideSchedule string      `yaml:"outside_schedule"` // defer (default) | deny
+}
+
+func (w RunWindow) check() error {
+	if w.Cron != "" {
+		if w.Start != "" || w.End != "" || len(w.Days) > 0 { return errors.New("cron or days/start/end, not both") }
+		_, err := parseCron(w.Cron)
+		return err
+	}
+	_, e1 := time.Parse("15:04", w.Start)
+	_, e2 := time.Parse("15:04", w.End)
+	if e1 != nil || e2 != nil { return errors.New("start/end HH:MM or cron required") }
+	return nil
+}
+
+// cron is the window's parsed cron expression, cached by text.
+func (w RunWindow) cron() (cronExpr, bool) {
+	c, ok := cronCache.Load(w.Cron)
+	if !ok {
+		e, err := parseCron(w.Cron)
+		if err != nil { return cronExpr{}, false }
+		c, _ = cronCache.LoadOrStore(w.Cron, e)
+	}
+	return c.(cronExpr), true
+}
+
+func (w RunWindow) active(now time.Time) bool {
+	if w.Cron != "" {
+		c, ok := w.cron()
+		return ok && c.match(now)
+	}
+	return MaintWindow{Days: w.Days, Start: w.Start, End: w.End}.active(now)
+}
+
+// next is the first minute from t (a whole minute) the window is open;
+// zero if none before limit. days/start/end windows open at start, so only
+// t itself and the start minute of the next eight days can be it.
+func (w RunWindow) next(t, limit time.Time) time.Time {
+	if w.Cron != "" {
+		c, ok := w.cron()
+		if !ok { return time.Time{} }
+		return c.next(t, limit)
+	}
+	if w.active(t) { return t }
+	s := hhmm(w.Start)
+	for d := 0; d <= 7; d++ {
+		c := time.Date(t.Year(), t.Month(), t.Day()+d, s/60, s%60, 0, 0, t.Location())
+		if !c.Before(limit) { break }
+		if c.After(t) && w.active(c) { return c }
+	}
+	return time.Time{}
+}
+
+// runSchedule is the schedule in force for one module.
+type runSchedule struct {
+	windows []RunWindow
+	outside string
+	source  string // policies.d file or schedules[i]
+}
+
+func (c Config) scheduleFor(module string) *runSchedule {
+	if p := c.modulePolicy(module); p != nil && len(p.Schedule) > 0 { return &runSchedule{p.Schedule, p.OutsideSchedule, p.File} }
+	for i, s := range c.Schedules {
+		if !allowed(module, s.Modules) || (len(s.Tenants) > 0 && !allowed(c.Tenant, s.Tenants)) { continue }
+		return &runSchedule{s.Schedule, s.OutsideSchedule, fmt.Sprintf("schedules[%d]", i)}
+	}
+	return nil
+}
+
+func scheduleLoc(cfg Config) *time.Location {
+	loc, _ := time.LoadLocation(cfg.MaintTZ)
+	if loc == nil { loc = time.UTC }
+	return loc
+}
+
+func (s *runSchedule) open(cfg Config, now time.Time) bool {
+	if s == nil || len(s.windows) == 0 { return true }
+	return s.openAt(now.In(scheduleLoc(cfg)))
+}
+
+// openAt checks t already in maintenance_tz.
+func (s *runSchedule) openAt(t time.Time) bool {
+	for _, w := range s.windows {
+		if w.active(t) { return true }
+	}
+	return false
+}
+
+func (s *runSchedule) deny() bool { return s != nil && s.outside == "deny" }
+
+// next is the first minute after now the schedule is open; zero if none
+// within a year.
+func (s *runSchedule) next(cfg Config, now time.Time) time.Time {
+	if s == nil || len(s.windows) == 0 { return now }
+	t := now.In(scheduleLoc(cfg)).Truncate(time.Minute).Add(time.Minute)
+	var first time.Time
+	for _, w := range s.windows {
+		if n := w.next(t, t.AddDate(1, 0, 0)); !n.IsZero() && (first.IsZero() || n.Before(first)) { first = n }
+	}
+	return first
+}
+
+// scheduleWait is how long env must still be held; 0 = let it through.
+func scheduleWait(cfg Config, env *Envelope) time.Duration {
+	c, ok := cfg.forTenant(tenantOf(env))
+	module := env.Module
+	if module == "" { module = "unknown" }
+	s := c.scheduleFor(module)
+	now := time.Now()
+	if !ok || s.deny() || s.open(c, now) { return 0 }
+	if n := s.next(c, now); !n.IsZero() && n.Sub(now) < time.Minute { return n.Sub(now) }
+	return time.Minute
+}
+
+var (
+	scheduleHeld   atomic.Int64 // envelopes waiting for their window
+	scheduleFull   atomic.Int64 // refused since the last intake.denied event
+	scheduleFullAt atomic.Int64 // unix second of that event
+)
+
+// holdSchedule takes one of the schedule_hold_max slots for env; false means
+// the node already holds that many and env is refused.
+func holdSchedule(cfg Config, env *Envelope) bool {
+	if n := scheduleHeld.Add(1); cfg.ScheduleHoldMax == 0 || n <= int64(cfg.ScheduleHoldMax) { return true }
+	scheduleHeld.Add(-1)
+	scheduleTotal.WithLabelValues("full").Inc()
+	refused := scheduleFull.Add(1)
+	if now := time.Now().Unix(); scheduleFullAt.Load() < now && scheduleFullAt.Swap(now) < now {
+		scheduleFull.Add(-refused)
+		fmt.Printf("[schedule] %d envelopes held, refused %d\n", cfg.ScheduleHoldMax, refused)
+		go postEvent(cfg, map[string]any{"type": "intake.denied", "status": "error", "meta": map[string]any{"module": env.Module, "tenant": tenantOf(env), "reason": "scheduled_full", "schedule_hold_max": cfg.ScheduleHoldMax, "dropped": refused, "node": nodeID(cfg)}})
+	}
+	return false
+}
+
+// denySchedule ends rec with deny_schedule and tells the relay why and when
+// to retry.
+func denySchedule(cfg Config, rec *RunRecord, s *runSchedule) {
+	meta := map[string]any{"run": rec.ID, "module": rec.Module, "tenant": rec.Tenant, "result": "deny_schedule", "reason": "outside_window", "schedule": s.source}
+	rec.Error = "outside execution window (" + s.source + ")"
+	if n := s.next(cfg, time.Now()); !n.IsZero() {
+		meta["next_open"] = n.UTC().Format(time.RFC3339)
+		rec.Error += ", next opens " + n.UTC().Format(time.RFC3339)
+	}
+	rec.decide("schedule=closed"); rec.Result = "deny_schedule"
+	postRunEvent(cfg, rec, map[string]any{"type": "run.denied", "meta": meta})
+}
+
+// --- cron expressions ---
+
+type cronExpr struct {
+	min, hour, dom, month, dow uint64 // bit sets
+	anyDom, anyDow             bool
+}
+
+var (
+	cronMonths = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	cronDays   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+	cronCache  sync.Map // expression -> cronExpr
+)
+
+func parseCron(s string) (cronExpr, error) {
+	f := strings.Fields(s)
+	if len(f) != 5 { return cronExpr{}, fmt.Errorf("cron %q: want 5 fields (minute hour dom month dow)", s) }
+	var c cronExpr
+	var err error
+	field := func(i, lo, hi int, names []string, nameBase int) uint64 {
+		if err != nil { return 0 }
+		var bits uint64
+		if bits, err = cronField(f[i], lo, hi, names, nameBase); err != nil { err = fmt.Errorf("cron %q: field %d: %w", s, i+1, err) }
+		return bits
+	}
+	c.min, c.hour, c.dom = field(0, 0, 59, nil, 0), field(1, 0, 23, nil, 0), field(2, 1, 31, nil, 0)
+	c.month, c.dow = field(3, 1, 12, cronMonths, 1), field(4, 0, 7, cronDays, 0)
+	if c.dow&(1<<7) != 0 { c.dow |= 1 } // 7 = sunday
+	c.anyDom, c.anyDow = f[2] == "*", f[4] == "*"
+	return c, err
+}
+
+func cronField(f string, lo, hi int, names []string, nameBase int) (uint64, error) {
+	num := func(s string) (int, error) {
+		for i, n := range names {
+			if strings.EqualFold(s, n) { return i + nameBase, nil }
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < lo || n > hi { return 0, fmt.Errorf("%q not in %d-%d", s, lo, hi) }
+		return n, nil
+	}
+	var bits uint64
+	for _, part := range strings.Split(f, ",") {
+		rng, step := part, 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 { return 0, fmt.Errorf("bad step in %q", part) }
+			rng = part[:i]
+		}
+		a, b := lo, hi
+		if rng != "*" {
+			var err error
+			first, last, isRange := strings.Cut(rng, "-")
+			if a, err = num(first); err != nil { return 0, err }
+			b = a
+			if isRange {
+				if b, err = num(last); err != nil { return 0, err }
+			} else if step > 1 {
+				b = hi
+			}
+			if b < a { return 0, fmt.Errorf("range %q runs backwards", rng) }
+		}
+		for n := a; n <= b; n += step { bits |= 1 << n }
+	}
+	return bits, nil
+}
+
+func (c cronExpr) match(t time.Time) bool {
+	if c.min&(1<<t.Minute()) == 0 || c.hour&(1<<t.Hour()) == 0 || c.month&(1<<int(t.Month())) == 0 { return false }
+	return c.day(t)
+}
+
+// next is the first minute from t (a whole minute) that matches, or zero
+// before limit: a month, day or hour that does not match is skipped whole.
+// Hours are stepped in elapsed minutes, which lands on the next wall-clock
+// hour across DST changes too (time.Date has no answer for a skipped hour).
+func (c cronExpr) next(t, limit time.Time) time.Time {
+	for t.Before(limit) {
+		nextHour := t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		var n time.Time
+		switch {
+		case c.month&(1<<int(t.Month())) == 0:
+			n = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.day(t):
+			n = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<t.Hour()) == 0:
+			n = nextHour
+		case c.min&(1<<t.Minute()) == 0:
+			n = t.Add(time.Minute)
+		default:
+			return t
+		}
+		if !n.After(t) { n = nextHour } // midnight skipped by DST
+		t = n
+	}
+	return time.Time{}
+}
+
+// day applies the day-of-month / day-of-week rule.
+func (c cronExpr) day(t time.Time) bool {
+	dom, dow := c.dom&(1<<t.Day()) != 0, c.dow&(1<<int(t.Weekday())) != 0
+	switch {
+	case c.anyDom && c.anyDow: return true
+	case c.anyDom: return dow
+	case c.anyDow: return dom
+	}
+	return dom || dow // both restricted: either matches, as in cron
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronField(t *testing.T) {
+	bits := func(ns ...int) (b uint64) {
+		for _, n := range ns { b |= 1 << n }
+		return b
+	}
+	for _, c := range []struct {
+		f            string
+		lo, hi, base int
+		names        []string
+		want         uint64
+	}{
+		{"*", 0, 5, 0, nil, bits(0, 1, 2, 3, 4, 5)},
+		{"3", 0, 59, 0, nil, bits(3)},
+		{"1-3,7", 0, 23, 0, nil, bits(1, 2, 3, 7)},
+		{"*/15", 0, 59, 0, nil, bits(0, 15, 30, 45)},
+		{"10/20", 0, 59, 0, nil, bits(10, 30, 50)},
+		{"0-10/5", 0, 59, 0, nil, bits(0, 5, 10)},
+		{"mon-fri", 0, 7, 0, cronDays, bits(1, 2, 3, 4, 5)},
+		{"JAN,dec", 1, 12, 1, cronMonths, bits(1, 12)},
+	} {
+		got, err := cronField(c.f, c.lo, c.hi, c.names, c.base)
+		if err != nil || got != c.want { t.Errorf("cronField(%q) = %b, %v; want %b", c.f, got, err, c.want) }
+	}
+	for _, f := range []string{"", "60", "-1", "5-1", "*/0", "*/x", "1-", "mon", "a-b", "1,,2"} {
+		if _, err := cronField(f, 0, 59, nil, 0); err == nil { t.Errorf("cronField(%q): no error", f) }
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	for _, s := range []string{"* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "* * * foo *"} {
+		if _, err := parseCron(s); err == nil { t.Errorf("parseCron(%q): no error", s) }
+	}
+	c, err := parseCron("30 9 * * 7")
+	if err != nil { t.Fatal(err) }
+	if c.dow != 1|1<<7 { t.Errorf("dow 7 is not sunday: %b", c.dow) }
+}
+
+func TestCronMatch(t *testing.T) {
+	at := func(s string) time.Time { v, _ := time.Parse("2006-01-02 15:04", s); return v } // 2026-03-02 is a monday
+	for _, c := range []struct {
+		expr, at string
+		want     bool
+	}{
+		{"* 0-6,22-23 * * mon-fri", "2026-03-02 23:10", true},
+		{"* 0-6,22-23 * * mon-fri", "2026-03-02 12:00", false},
+		{"* 0-6,22-23 * * mon-fri", "2026-03-07 23:10", false}, // saturday
+		{"0 12 1 * *", "2026-03-01 12:00", true},
+		{"0 12 1 * *", "2026-03-01 12:01", false},
+		{"0 12 13 * fri", "2026-03-13 12:00", true},  // both restricted: either matches
+		{"0 12 13 * fri", "2026-03-06 12:00", true},  // a friday, not the 13th
+		{"0 12 13 * fri", "2026-03-05 12:00", false}, // neither
+		{"0 12 * jun *", "2026-03-05 12:00", false},
+		{"0 12 * * 0", "2026-03-08 12:00", true},
+		{"0 12 * * 7", "2026-03-08 12:00", true},
+	} {
+		e, err := parseCron(c.expr)
+		if err != nil { t.Fatal(err) }
+		if got := e.match(at(c.at)); got != c.want { t.Errorf("%q match %s = %v, want %v", c.expr, c.at, got, c.want) }
+	}
+}
+
+// TestScheduleNext checks the field-by-field search against a minute scan.
+func TestScheduleNext(t *testing.T) {
+	windows := []RunWindow{
+		{Cron: "* 0-6,22-23 * * mon-fri"},
+		{Cron: "*/20 9 * * *"},
+		{Cron: "0 0 1 jan *"},
+		{Cron: "15 3 13 * fri"},
+		{Cron: "30 2 * * *"},
+		{Cron: "0 0 30 2 *"}, // never
+		{Days: []string{"sat", "sun"}, Start: "00:00", End: "23:59"},
+		{Start: "22:00", End: "06:00"},
+		{Days: []string{"wed"}, Start: "09:30", End: "09:45"},
+	}
+	zones := []string{"UTC", "America/New_York", "America/Santiago", "Asia/Kolkata"} // Santiago skips midnight
+	starts := []string{"2026-03-07 23:59", "2026-03-08 01:59", "2026-10-31 23:30", "2026-11-01 01:10", "2026-12-31 23:59", "2026-06-17 09:44", "2026-09-05 23:30"}
+	for _, zone := range zones {
+		loc, err := time.LoadLocation(zone)
+		if err != nil { t.Skip("no tzdata:", err) }
+		for _, s := range starts {
+			from, _ := time.ParseInLocation("2006-01-02 15:04", s, loc)
+			limit := from.AddDate(0, 0, 40)
+			for _, w := range windows {
+				want := time.Time{}
+				for m := from; m.Before(limit); m = m.Add(time.Minute) {
+					if w.active(m) { want = m; break }
+				}
+				if got := w.next(from, limit); !got.Equal(want) { t.Errorf("%s %s %+v: next = %v, want %v", zone, s, w, got, want) }
+			}
+		}
+	}
+	s := &runSchedule{windows: windows[1:3]}
+	from := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	if got, want := s.next(Config{MaintTZ: "UTC"}, from), time.Date(2026, 3, 3, 9, 0, 0, 0, time.UTC); !got.Equal(want) { t.Errorf("runSchedule.next = %v, want %v", got, want) }
+	if got := (&runSchedule{windows: windows[5:6]}).next(Config{MaintTZ: "UTC"}, from); !got.IsZero() { t.Errorf("never-open schedule: next = %v", got) }
+}
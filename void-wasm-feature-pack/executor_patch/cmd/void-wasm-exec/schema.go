@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Envelope JSON Schema (schema/envelope.v1.json) and validation ---
+// The schema is served at /schema/{name} for relay and SDK tooling. The
+// checks below implement it by hand so the executor needs no schema engine;
+// keep both in sync when the envelope changes (and bump the version).
+
+//go:embed schema/*.json schema/*.proto
+var schemaFS embed.FS
+
+const envelopeSchemaVersion = "v1"
+
+var envelopesInvalid = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_envelopes_invalid_total", Help: "signal.wasm envelopes rejected by validation"}, []string{"reason"})
+
+var sha256Hex = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+type envelopeError struct{ reason, msg string }
+
+func (e envelopeError) Error() string { return e.reason + ": " + e.msg }
+
+// --- Envelope size and shape limits ---
+// Checked before the envelope is decoded into maps: envelope_max_kb on the
+// raw payload (JSON, or CBOR as received), then one shallow pass that keeps
+// inputs and meta as raw bytes for inputs_max_kb, caps_max and
+// meta_max_depth (nesting of objects and arrays inside meta). A violation
+// is rejected with its own reason (size, inputs_size, caps, meta_depth) in
+// void_wasm_envelopes_invalid_total. 0 turns a field limit off.
+
+// envelopeLimits returns the live limits, or the defaults before the config
+// is loaded (local tools).
+func envelopeLimits() Config {
+	if c := liveCfg.Load(); c != nil { return *c }
+	return defaultConfig()
+}
+
+func checkEnvelopeSize(n int) error {
+	if max := envelopeLimits().EnvelopeMaxKB; n > max<<10 { return envelopeError{"size", fmt.Sprintf("%d bytes, over envelope_max_kb %d", n, max)} }
+	return nil
+}
+
+func checkEnvelopeShape(payload []byte) error {
+	var head struct {
+		Inputs json.RawMessage   `json:"inputs"`
+		Meta   json.RawMessage   `json:"meta"`
+		Caps   []json.RawMessage `json:"caps"`
+	}
+	if err := json.Unmarshal(payload, &head); err != nil { return envelopeError{"decode", err.Error()} }
+	lim := envelopeLimits()
+	if lim.InputsMaxKB > 0 && len(head.Inputs) > lim.InputsMaxKB<<10 { return envelopeError{"inputs_size", fmt.Sprintf("%d bytes, over inputs_max_kb %d", len(head.Inputs), lim.InputsMaxKB)} }
+	if lim.CapsMax > 0 && len(head.Caps) > lim.CapsMax { return envelopeError{"caps", fmt.Sprintf("%d caps, over caps_max %d", len(head.Caps), lim.CapsMax)} }
+	if d := jsonDepth(head.Meta); lim.MetaMaxDepth > 0 && d > lim.MetaMaxDepth { return envelopeError{"meta_depth", fmt.Sprintf("nested %d deep, over meta_max_depth %d", d, lim.MetaMaxDepth)} }
+	return nil
+}
+
+// jsonDepth is the deepest nesting of objects and arrays in raw JSON.
+func jsonDepth(raw []byte) int {
+	depth, deepest, inStr, esc := 0, 0, false, false
+	for _, c := range raw {
+		switch {
+		case esc:
+			esc = false
+		case inStr:
+			if c == '\\' { esc = true } else if c == '"' { inStr = false }
+		case c == '"':
+			inStr = true
+		case c == '{' || c == '[':
+			depth++
+			deepest = max(deepest, depth)
+		case c == '}' || c == ']':
+			depth--
+		}
+	}
+	return deepest
+}
+
+// parseEnvelope decodes and validates a signal.wasm payload; strict rejects
+// fields the schema doesn't define.
+func parseEnvelope(payload []byte, strict bool) (Envelope, error) {
+	var env Envelope
+	if err := checkEnvelopeSize(len(payload)); err != nil { return env, err }
+	if err := checkEnvelopeShape(payload); err != nil { return env, err }
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	if strict { dec.DisallowUnknownFields() }
+	if err := dec.Decode(&env); err != nil { return env, envelopeError{"decode", err.Error()} }
+	if env.Type != "signal.wasm" { return env, envelopeError{"type", fmt.Sprintf("want signal.wasm, got %q", env.Type)} }
+	if env.URL == "" && env.CID == "" && env.SHA256 == "" && env.Module == "" && len(env.Variants) == 0 { return env, envelopeError{"source", "one of url, cid, sha256, module, variants required"} }
+	if env.SHA256 != "" && !sha256Hex.MatchString(env.SHA256) { return env, envelopeError{"sha256", "must be 64 hex chars"} }
+	if env.URL != "" {
+		if u, err := url.Parse(env.URL); err != nil || u.Scheme == "" { return env, envelopeError{"url", "must be an absolute uri"} }
+		if strings.HasPrefix(env.URL, "github://") {
+			if _, _, _, err := parseGitHubSource(env.URL); err != nil { return env, envelopeError{"url", err.Error()} }
+		}
+	}
+	if len(env.Module) > 256 { return env, envelopeError{"module", "longer than 256"} }
+	if name, c, ok := strings.Cut(env.Module, "@"); ok {
+		if env.Version != "" { return env, envelopeError{"version", "set in both module and version"} }
+		if c == "" { return env, envelopeError{"version", "empty after @"} }
+		env.Module, env.Version = name, c
+	}
+	if env.Version != "" {
+		if env.Module == "" { return env, envelopeError{"version", "needs module"} }
+		if env.URL != "" || env.CID != "" || len(env.Variants) > 0 { return env, envelopeError{"version", "excludes url, cid and variants"} }
+		if _, err := parseConstraint(env.Version); err != nil { return env, envelopeError{"version", err.Error()} }
+	}
+	if env.Tenant != "" && !tenantName.MatchString(env.Tenant) { return env, envelopeError{"tenant", "invalid name"} }
+	if err := validMountReqs(env.Mounts); err != nil { return env, envelopeError{"mounts", err.Error()} }
+	if err := validGuestEnv(env.Env); err != nil { return env, envelopeError{"env", err.Error()} }
+	if err := validPlacement(env.Placement); err != nil { return env, envelopeError{"placement", err.Error()} }
+	if err := validBudget(env.Budget); err != nil { return env, envelopeError{"budget", err.Error()} }
+	if err := validVariants(env.Variants); err != nil { return env, envelopeError{"variants", err.Error()} }
+	if _, err := inputRefs(env.Inputs); err != nil { return env, envelopeError{"inputs", err.Error()} }
+	return env, nil
+}
+
+func reasonOf(err error) string {
+	var ee envelopeError
+	if errors.As(err, &ee) { return ee.reason }
+	return "other"
+}
+
+// handleSchema serves GET /schema/{name}, e.g. /schema/envelope.v1.json.
+func handleSchema(w http.ResponseWriter, r *http.Request) {
+	b, err := schemaFS.ReadFile("schema/" + r.PathValue("name"))
+	if err != nil { writeJSON(w, 404, map[string]any{"error": "unknown schema", "version": envelopeSchemaVersion}); return }
+	w.Header().Set("content-type", "application/schema+json")
+	w.Write(b)
+}
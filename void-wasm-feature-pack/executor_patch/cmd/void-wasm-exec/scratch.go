@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// --- Persistent scratch (/scratch) ---
+// Modules matching scratch_modules get an rw /scratch that outlives the run,
+// for incremental work such as index building. It lives under
+// <scratch_dir>/<tenant>/<module sha256>, so tenants running the same module
+// never see each other's files, every build of a module starts from its own
+// state and a new build never reads what an older one wrote. It is an rw
+// mount with cleanup keep (mounts.go): scratch_quota_mb is polled during the
+// run and a run that outgrows it ends with mount_quota. Nothing is deleted by
+// the executor; GET /admin/scratch lists the volumes and
+// DELETE /admin/scratch/{sha256}[?tenant=] wipes a module's (one tenant's),
+// 409 while a run is using one of them.
+
+const scratchGuest = "/scratch"
+
+var (
+	scratchMu   sync.Mutex
+	scratchBusy = map[string]int{} // volume dir -> runs using it
+)
+
+// scratchMount is the /scratch mount for a run, if policy grants one; done
+// releases the volume after the run.
+func scratchMount(cfg Config, rec *RunRecord, modPath string) (m runMount, done func(), ok bool) {
+	if len(cfg.ScratchModules) == 0 || !allowed(rec.Module, cfg.ScratchModules) { return runMount{}, nil, false }
+	digest := moduleDigest(rec, modPath)
+	if digest == "" { return runMount{}, nil, false }
+	tenant := rec.Tenant
+	if tenant == "" { tenant = defaultTenant }
+	host := filepath.Join(cfg.ScratchDir, tenant, digest)
+	scratchMu.Lock()
+	scratchBusy[host]++
+	scratchMu.Unlock()
+	os.MkdirAll(host, 0o755)
+	now := time.Now()
+	os.Chtimes(host, now, now) // last used, for GET /admin/scratch
+	rec.decide("scratch=" + digest[:12])
+	done = func() {
+		scratchMu.Lock(); defer scratchMu.Unlock()
+		if scratchBusy[host]--; scratchBusy[host] <= 0 { delete(scratchBusy, host) }
+	}
+	return runMount{name: "scratch", host: host, guest: scratchGuest, rw: true, quota: int64(cfg.ScratchQuotaMB) << 20, cleanup: "keep"}, done, true
+}
+
+type scratchVolume struct {
+	Tenant   string    `json:"tenant"`
+	SHA256   string    `json:"sha256"`
+	Bytes    int64     `json:"bytes"`
+	LastUsed time.Time `json:"last_used"`
+	Active   int       `json:"active,omitempty"` // runs using it now
+}
+
+// listScratch lists the volumes under dir, of module sha and tenant when
+// they are set.
+func listScratch(dir, sha, tenant string) []scratchVolume {
+	tenants, _ := os.ReadDir(dir)
+	out := []scratchVolume{}
+	for _, t := range tenants {
+		if !t.IsDir() || !tenantName.MatchString(t.Name()) || (tenant != "" && t.Name() != tenant) { continue }
+		ents, _ := os.ReadDir(filepath.Join(dir, t.Name()))
+		for _, e := range ents {
+			if !e.IsDir() || !sha256Hex.MatchString(e.Name()) || (sha != "" && e.Name() != sha) { continue }
+			host := filepath.Join(dir, t.Name(), e.Name())
+			v := scratchVolume{Tenant: t.Name(), SHA256: e.Name(), Bytes: dirSize(host), Active: scratchBusy[host]}
+			if info, err := e.Info(); err == nil { v.LastUsed = info.ModTime() }
+			out = append(out, v)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].LastUsed.After(out[j].LastUsed) })
+	return out
+}
+
+func handleScratch(mux *http.ServeMux, cfg Config) {
+	mux.HandleFunc("GET /admin/scratch", func(w http.ResponseWriter, r *http.Request) {
+		scratchMu.Lock(); defer scratchMu.Unlock()
+		writeJSON(w, 200, listScratch(currentConfig().ScratchDir, "", r.URL.Query().Get("tenant")))
+	})
+	mux.HandleFunc("DELETE /admin/scratch/{sha}", func(w http.ResponseWriter, r *http.Request) {
+		sha, tenant := r.PathValue("sha"), r.URL.Query().Get("tenant")
+		if !sha256Hex.MatchString(sha) { writeJSON(w, 400, map[string]any{"error": "want a module sha256"}); return }
+		// held across the wipe, so no run mounts a volume that is going away
+		scratchMu.Lock(); defer scratchMu.Unlock()
+		dir := currentConfig().ScratchDir
+		vols := listScratch(dir, sha, tenant)
+		if len(vols) == 0 { writeJSON(w, 404, map[string]any{"error": "no scratch volume"}); return }
+		for _, v := range vols {
+			if v.Active > 0 { writeJSON(w, 409, map[string]any{"error": "scratch volume in use", "tenant": v.Tenant, "active": v.Active}); return }
+		}
+		var n int64
+		for _, v := range vols {
+			if err := os.RemoveAll(filepath.Join(dir, v.Tenant, v.SHA256)); err != nil { writeJSON(w, 500, map[string]any{"error": err.Error()}); return }
+			n += v.Bytes
+		}
+		fmt.Println("[admin] wiped scratch", sha, tenant, len(vols), "volume(s)", n, "bytes")
+		audit(cfg, "admin.scratch.wipe", map[string]any{"sha256": sha, "tenant": tenant, "volumes": len(vols), "bytes": n})
+		writeJSON(w, 200, map[string]any{"wiped": sha, "volumes": len(vols), "bytes": n})
+	})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// --- Service mode ---
+// `void-wasm-exec service install` registers the executor with the
+// platform's service manager, started as `service run -config <path>`:
+//   windows  a Windows service (automatic start, restarted on failure),
+//            output in <VOID_HOME>\exec.log
+//   darwin   a launchd job, a LaunchDaemon when run as root, else a
+//            LaunchAgent (RunAtLoad, KeepAlive), output in <VOID_HOME>/exec.log
+//   other    prints a systemd unit to install by hand
+// `service uninstall` removes it. A stop from the service manager drains
+// like SIGTERM (shutdown.go).
+
+const serviceUsage = "usage: void-wasm-exec service install|uninstall|run [-config executor.yaml] [-name void-wasm-exec]"
+
+func serviceLocal(args []string) int {
+	if len(args) == 0 { fmt.Fprintln(os.Stderr, serviceUsage); return 2 }
+	fs := flag.NewFlagSet("service", flag.ExitOnError)
+	cfgPath := fs.String("config", getenv("CONFIG_FILE", ""), "YAML config file the service runs with")
+	name := fs.String("name", "void-wasm-exec", "service name (launchd label suffix)")
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, serviceUsage); fs.PrintDefaults() }
+	cmd := args[0]
+	fs.Parse(args[1:])
+	var err error
+	switch cmd {
+	case "install":
+		var exe string
+		if exe, err = os.Executable(); err != nil { break }
+		if *cfgPath != "" {
+			if *cfgPath, err = filepath.Abs(*cfgPath); err != nil { break }
+		}
+		err = serviceInstall(*name, exe, *cfgPath)
+	case "uninstall":
+		err = serviceUninstall(*name)
+	case "run":
+		return serviceRun(*name, serviceArgs(*cfgPath))
+	default:
+		fs.Usage(); return 2
+	}
+	if err != nil { fmt.Fprintln(os.Stderr, "service "+cmd+":", err); return 1 }
+	return 0
+}
+
+// serviceArgs are the flags serve gets under the service manager.
+func serviceArgs(cfgPath string) []string {
+	if cfgPath == "" { return nil }
+	return []string{"-config", cfgPath}
+}
+
+// serviceLog sends the executor's output to <VOID_HOME>/exec.log.
+func serviceLog() {
+	os.MkdirAll(voidHome(), 0o755)
+	f, err := os.OpenFile(voidPath("exec.log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil { return }
+	os.Stdout, os.Stderr = f, f
+}
@@ -0,0 +1,69 @@
+//go:build darwin
+
+package main
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// launchdPlist is where the job for label lives: a LaunchDaemon for root,
+// the user's LaunchAgent otherwise.
+func launchdPlist(label string) (string, error) {
+	if os.Geteuid() == 0 { return filepath.Join("/Library/LaunchDaemons", label+".plist"), nil }
+	home, err := os.UserHomeDir()
+	if err != nil { return "", err }
+	return filepath.Join(home, "Library", "LaunchAgents", label+".plist"), nil
+}
+
+func serviceInstall(name, exe, cfgPath string) error {
+	label := "dev.void." + name
+	path, err := launchdPlist(label)
+	if err != nil { return err }
+	var argv strings.Builder
+	for _, a := range append([]string{exe, "service", "run", "-name", name}, serviceArgs(cfgPath)...) {
+		argv.WriteString("\t\t<string>" + html.EscapeString(a) + "</string>\n")
+	}
+	log := html.EscapeString(voidPath("exec.log"))
+	plist := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>` + label + `</string>
+	<key>ProgramArguments</key>
+	<array>
+` + argv.String() + `	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+	<key>StandardOutPath</key>
+	<string>` + log + `</string>
+	<key>StandardErrorPath</key>
+	<string>` + log + `</string>
+</dict>
+</plist>
+`
+	os.MkdirAll(filepath.Dir(path), 0o755)
+	os.MkdirAll(voidHome(), 0o755)
+	if err := writeFileAtomic(path, []byte(plist), 0o644); err != nil { return err }
+	if out, err := exec.Command("launchctl", "load", "-w", path).CombinedOutput(); err != nil { return fmt.Errorf("launchctl load: %v: %s", err, strings.TrimSpace(string(out))) }
+	fmt.Println("installed", path)
+	return nil
+}
+
+func serviceUninstall(name string) error {
+	path, err := launchdPlist("dev.void." + name)
+	if err != nil { return err }
+	if out, err := exec.Command("launchctl", "unload", "-w", path).CombinedOutput(); err != nil { fmt.Println("launchctl unload:", err, strings.TrimSpace(string(out))) }
+	return os.Remove(path)
+}
+
+// serviceRun: launchd keeps the process in the foreground and stops it with
+// SIGTERM, which serve already drains on.
+func serviceRun(_ string, args []string) int { serve(args); return 0 }
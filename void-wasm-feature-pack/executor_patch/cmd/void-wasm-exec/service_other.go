@@ -0,0 +1,39 @@
+//go:build !windows && !darwin
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// serviceInstall prints a systemd unit; installing it needs root and a
+// choice of user, which is the operator's call.
+func serviceInstall(name, exe, cfgPath string) error {
+	fmt.Printf(`# /etc/systemd/system/%s.service
+[Unit]
+Description=Void WASM executor
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+# root is refused: set User= here, or run_as in the config
+User=void
+ExecStart=%s
+Restart=on-failure
+KillSignal=SIGTERM
+TimeoutStopSec=60
+
+[Install]
+WantedBy=multi-user.target
+`, name, strings.Join(append([]string{exe, "service", "run", "-name", name}, serviceArgs(cfgPath)...), " "))
+	fmt.Println("# then: systemctl daemon-reload && systemctl enable --now", name)
+	return nil
+}
+
+func serviceUninstall(name string) error {
+	return errors.New("not managed here: systemctl disable --now " + name + " && rm /etc/systemd/system/" + name + ".service")
+}
+
+func serviceRun(_ string, args []string) int { serve(args); return 0 }
@@ -0,0 +1,69 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+func serviceInstall(name, exe, cfgPath string) error {
+	m, err := mgr.Connect()
+	if err != nil { return err }
+	defer m.Disconnect()
+	if s, err := m.OpenService(name); err == nil { s.Close(); return fmt.Errorf("service %s already exists", name) }
+	s, err := m.CreateService(name, exe, mgr.Config{DisplayName: "Void WASM executor", Description: "Runs signal.wasm envelopes from the void relay", StartType: mgr.StartAutomatic}, append([]string{"service", "run", "-name", name}, serviceArgs(cfgPath)...)...)
+	if err != nil { return err }
+	defer s.Close()
+	if err := s.SetRecoveryActions([]mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: 5 * time.Second}}, 86400); err != nil { return err }
+	fmt.Println("installed service", name, "- start it with: sc start", name)
+	return nil
+}
+
+func serviceUninstall(name string) error {
+	m, err := mgr.Connect()
+	if err != nil { return err }
+	defer m.Disconnect()
+	s, err := m.OpenService(name)
+	if err != nil { return fmt.Errorf("service %s: %w", name, err) }
+	defer s.Close()
+	return s.Delete()
+}
+
+// serviceRun serves under the service control manager; run by hand it is
+// plain serve.
+func serviceRun(name string, args []string) int {
+	if ok, err := svc.IsWindowsService(); err != nil || !ok { serve(args); return 0 }
+	serviceLog()
+	if err := svc.Run(name, winService{args}); err != nil { fmt.Println("[service]", err); return 1 }
+	return 0
+}
+
+type winService struct{ args []string }
+
+func (s winService) Execute(_ []string, req <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	done := make(chan struct{})
+	go func() { serve(s.args); close(done) }()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case <-done:
+			return false, 0
+		case c := <-req:
+			switch c.Cmd {
+			case svc.Interrogate:
+				status <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending, WaitHint: uint32((currentConfig().ShutdownTimeout + 5*time.Second).Milliseconds())}
+				shutdownSig <- os.Interrupt
+				<-done
+				return false, 0
+			}
+		}
+	}
+}
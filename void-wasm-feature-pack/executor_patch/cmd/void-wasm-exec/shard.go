@@ -0,0 +1,75 @@
+package main
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Consistent-hash sharding across executors ---
+// Every node sees the whole stream (SSE fan-out) and keeps only the envelopes
+// whose key lands on its own ring position, so one module (or envelope) stays
+// on the same node and its cache stays warm. Membership comes from
+// shard_nodes and can change with a reload; only ~1/N of keys move.
+
+const shardVNodes = 64
+
+var shardSkipped = prometheus.NewCounter(prometheus.CounterOpts{Name: "void_wasm_shard_skipped_total", Help: "Envelopes left to another shard owner"})
+
+type ring struct {
+	hashes []uint32
+	owners map[uint32]string
+}
+
+func hash32(s string) uint32 { h := fnv.New32a(); h.Write([]byte(s)); return h.Sum32() }
+
+func newRing(nodes []string) *ring {
+	r := &ring{owners: map[uint32]string{}}
+	for _, n := range nodes {
+		for i := 0; i < shardVNodes; i++ {
+			h := hash32(n + "#" + strconv.Itoa(i))
+			r.hashes = append(r.hashes, h)
+			r.owners[h] = n
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+	return r
+}
+
+func (r *ring) owner(key string) string {
+	if len(r.hashes) == 0 { return "" }
+	h := hash32(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) { i = 0 }
+	return r.owners[r.hashes[i]]
+}
+
+var (
+	ringMu  sync.Mutex
+	ringKey string
+	ringCur *ring
+)
+
+// currentRing rebuilds the ring only when the member list changed.
+func currentRing(nodes []string) *ring {
+	key := strings.Join(nodes, ",")
+	ringMu.Lock(); defer ringMu.Unlock()
+	if ringCur == nil || key != ringKey { ringCur, ringKey = newRing(nodes), key }
+	return ringCur
+}
+
+func shardKey(cfg Config, env *Envelope) string {
+	if cfg.ShardKey == "envelope" { return envelopeID(env) }
+	if env.Module != "" { return env.Module }
+	return env.SHA256
+}
+
+// ownsEnvelope is true when sharding is off or this node owns the key.
+func ownsEnvelope(cfg Config, env *Envelope) bool {
+	if len(cfg.ShardNodes) == 0 { return true }
+	return currentRing(cfg.ShardNodes).owner(shardKey(cfg, env)) == nodeID(cfg)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Shared module cache (S3 / MinIO) ---
+// With shared_cache s3://bucket/prefix the module cache has a second tier:
+// on a local miss, an envelope that declares sha256 is first looked up as
+// <prefix>/<sha256>.wasm with the same S3 settings as s3:// sources, and
+// only fetched from its url/cid if that misses too; what is fetched is then
+// written through to the bucket in the background, so the next executor in
+// the fleet gets it from there instead of the IPFS gateway. Every tier
+// verifies sha256, an object that does not match is ignored (and
+// overwritten), and envelopes without sha256 never use the shared tier since
+// nothing would vouch for the object. Uploads use s3_kms_key_id like
+// artifacts.
+
+var sharedCacheTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_shared_cache_total", Help: "Shared (S3) module cache lookups and write-throughs by result"}, []string{"result"})
+
+func sharedCacheKey(cfg Config, sha string) (bucket, key string) {
+	bucket, prefix, _ := parseS3(cfg.SharedCache)
+	key = strings.ToLower(sha) + ".wasm"
+	if prefix = strings.Trim(prefix, "/"); prefix != "" { key = prefix + "/" + key }
+	return bucket, key
+}
+
+// sharedCacheGet returns the module for sha from the bucket, or nil.
+func sharedCacheGet(cfg Config, sha string) []byte {
+	bucket, key := sharedCacheKey(cfg, sha)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.FetchTimeout)
+	defer cancel()
+	req, err := s3Request(ctx, cfg, "GET", bucket, key, nil, nil)
+	if err != nil { sharedCacheTotal.WithLabelValues("error").Inc(); return nil }
+	resp, err := fetchClient.Do(req)
+	if err != nil { sharedCacheTotal.WithLabelValues("error").Inc(); fmt.Println("[cache] shared get:", err); return nil }
+	defer resp.Body.Close()
+	// S3 answers 403 for a missing key without s3:ListBucket
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusForbidden { sharedCacheTotal.WithLabelValues("miss").Inc(); return nil }
+	if resp.StatusCode != 200 { sharedCacheTotal.WithLabelValues("error").Inc(); fmt.Println("[cache] shared get", key, "status", resp.StatusCode); return nil }
+	data, err := io.ReadAll(resp.Body)
+	if err != nil { sharedCacheTotal.WithLabelValues("error").Inc(); return nil }
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != strings.ToLower(sha) { sharedCacheTotal.WithLabelValues("bad_sha").Inc(); fmt.Println("[cache] shared object", key, "does not match its sha256, ignoring"); return nil }
+	sharedCacheTotal.WithLabelValues("hit").Inc()
+	return data
+}
+
+// sharedCachePut writes a verified download through to the bucket.
+func sharedCachePut(cfg Config, sha string, data []byte) {
+	bucket, key := sharedCacheKey(cfg, sha)
+	hdr := http.Header{"Content-Type": {"application/wasm"}}
+	if cfg.S3KMSKeyID != "" { hdr.Set("x-amz-server-side-encryption", "aws:kms"); hdr.Set("x-amz-server-side-encryption-aws-kms-key-id", cfg.S3KMSKeyID) }
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.FetchTimeout)
+	defer cancel()
+	if err := s3Put(ctx, cfg, bucket, key, data, hdr); err != nil { sharedCacheTotal.WithLabelValues("put_error").Inc(); fmt.Println("[cache] shared put", key+":", err); return }
+	sharedCacheTotal.WithLabelValues("put").Inc()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// shutdownSig gets SIGTERM/SIGINT, or a stop request from the service
+// manager (service_windows.go).
+var shutdownSig = make(chan os.Signal, 1)
+
+// shuttingDown makes queued (not yet started) runs bail instead of starting mid-drain.
+var shuttingDown atomic.Bool
+
+var drainRemaining = prometheus.NewGauge(prometheus.GaugeOpts{Name: "void_wasm_drain_remaining", Help: "Active runs left while draining for shutdown (-1 when not draining)"})
+
+// waitForShutdown blocks until SIGTERM/SIGINT, then stops intake, drains
+// active runs and pending event posts within ShutdownTimeout and closes
+// stores before returning.
+func waitForShutdown(cfg Config, stopIntake context.CancelFunc) {
+	timeout := cfg.ShutdownTimeout
+	drainRemaining.Set(-1)
+	signal.Notify(shutdownSig, syscall.SIGTERM, os.Interrupt)
+	s := <-shutdownSig
+	fmt.Println("[wasm] shutdown on", s, "- draining up to", timeout)
+
+	shuttingDown.Store(true)
+	setPaused(true)
+	stopIntake()
+	leaveRelay(cfg)
+
+	deadline := time.Now().Add(timeout)
+	for {
+		n := activeCount()
+		drainRemaining.Set(float64(n))
+		if n == 0 { break }
+		if time.Now().After(deadline) { fmt.Println("[wasm] drain timeout,", n, "runs still active"); break }
+		time.Sleep(200 * time.Millisecond)
+	}
+	ledger.close(cfg)
+	flushEvents(cfg, time.Until(deadline))
+	history.Close()
+	kvBackend.close()
+	if queues != nil { queues.close() }
+	fmt.Println("[wasm] shutdown complete")
+}
+
+// writeFileAtomic writes to a temp file in the same dir and renames it into
+// place, so a kill mid-write never leaves a truncated cache/KV file behind.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil { return err }
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil { tmp.Close(); return err }
+	if err := tmp.Chmod(perm); err != nil { tmp.Close(); return err }
+	if err := tmp.Close(); err != nil { return err }
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// --- Policy simulation (POST /admin/policy/simulate) ---
+// Runs an envelope through the same checks as intake and the policy stage,
+// against the live config, without queueing, fetching or executing it, and
+// without touching metrics or quotas. Unlike the pipeline it does not stop at
+// the first deny: every stage is reported, result is what the run would
+// record (the first deny, else "ok" or "dryrun"). Cosign and OPA are
+// evaluated as the security executor does: with cosign_verify a file://
+// module is checked by `cosign verify-blob` against the .sig/.crt next to it
+// (a remote one is verified once downloaded, so it shows as info), and with
+// opa_base the decision at opa_decision is queried with the same input
+// {module, caps, limits, sha256, signer}; either shows as skip only while
+// its knob is off. caps and limits are what the module would get if it ran,
+// after tenant and policies.d overrides.
+
+type simStep struct {
+	Stage  string `json:"stage"`
+	Result string `json:"result"` // allow | deny | skip | info
+	Detail string `json:"detail,omitempty"`
+}
+
+type simReport struct {
+	Result string         `json:"result"`
+	Module string         `json:"module"`
+	Tenant string         `json:"tenant"`
+	Path   string         `json:"path"`
+	Chain  []simStep      `json:"chain"`
+	Caps   map[string]any `json:"caps"`
+	Limits map[string]any `json:"limits"`
+}
+
+func simulatePolicy(base Config, env *Envelope) simReport {
+	module := env.Module
+	if module == "" { module = "unknown" }
+	cfg, tenantOK := base.forTenant(tenantOf(env))
+	cfg, mp := cfg.forModule(module)
+	rep := simReport{Module: module, Tenant: cfg.Tenant, Path: selectPath(cfg, env)}
+	step := func(stage, result, detail, deny string) {
+		rep.Chain = append(rep.Chain, simStep{stage, result, detail})
+		if result == "deny" && rep.Result == "" { rep.Result = deny }
+	}
+
+	switch {
+	case intakePaused.Load():
+		step("intake", "deny", "intake paused (POST /admin/resume)", "paused")
+	case maintBlocks(env):
+		step("intake", "deny", "maintenance window", "paused")
+	default:
+		step("intake", "allow", "", "")
+	}
+	if miss := unplaced(base, env); len(miss) > 0 { step("placement", "deny", "node labels "+labelList(base.NodeLabels)+" lack "+strings.Join(miss, ","), "not_placed") } else if len(env.Placement) > 0 { step("placement", "allow", labelList(base.NodeLabels), "") }
+	if cfg.IntakeRPS > 0 { step("intake_rate", "info", fmt.Sprintf("%d/s burst %d, %s; %.1f tokens left", cfg.IntakeRPS, cfg.IntakeBurst, cfg.IntakeAction, intakeTokens(cfg, env, module)), "") }
+	if ownsEnvelope(base, env) { step("shard", "allow", "", "") } else { step("shard", "deny", "owned by "+currentRing(base.ShardNodes).owner(shardKey(base, env)), "not_owner") }
+	if tenantOK { step("tenant", "allow", cfg.Tenant, "") } else { step("tenant", "deny", "unknown tenant "+cfg.Tenant, "deny_tenant") }
+	if allowed(module, cfg.AllowModules) { step("allowlist", "allow", "", "") } else { step("allowlist", "deny", "not in allow_modules "+strings.Join(cfg.AllowModules, ","), "deny_allowlist") }
+	if mp != nil { step("module_policy", "info", mp.File, "") }
+	switch sch := cfg.scheduleFor(module); {
+	case sch == nil:
+	case sch.open(cfg, time.Now()):
+		step("schedule", "allow", sch.source, "")
+	case sch.deny():
+		step("schedule", "deny", "outside "+sch.source+", next opens "+sch.next(cfg, time.Now()).UTC().Format(time.RFC3339), "deny_schedule")
+	default:
+		step("schedule", "info", "outside "+sch.source+": held until "+sch.next(cfg, time.Now()).UTC().Format(time.RFC3339), "")
+	}
+	switch over := quotaCheck(cfg, module); {
+	case over == "":
+		step("quota", "allow", "", "")
+	case cfg.QuotaAction == "deny":
+		step("quota", "deny", over, "deny_quota")
+	default:
+		step("quota", "info", over+": deferred by "+cfg.QuotaDefer.String(), "")
+	}
+	if len(env.Mounts) > 0 {
+		mounts, denied, err := mountsFor(cfg, env, cfg.Tenant, module)
+		if err != nil { step("mounts", "deny", denied+": "+err.Error(), "deny_mount") }
+		for _, m := range mounts {
+			if m.quota > 0 && m.cleanup != "run" && dirSize(m.host) >= m.quota { step("mounts", "deny", m.name+": quota exhausted", "deny_mount"); continue }
+			mode := "ro"
+			if m.rw { mode = "rw" }
+			step("mounts", "allow", m.name+":"+mode+" "+m.host+" -> "+m.guest, "")
+		}
+	}
+	if len(env.Env) > 0 {
+		vars, denied, err := guestEnvFor(cfg, env, cfg.Tenant, module)
+		if err != nil {
+			step("env", "deny", denied+": "+err.Error(), "deny_env")
+		} else {
+			names := make([]string, len(vars))
+			for i, kv := range vars { names[i] = kv[0] }
+			step("env", "allow", strings.Join(names, ","), "")
+		}
+	}
+	signer := ""
+	switch path, local := strings.CutPrefix(env.URL, "file://"); {
+	case !cfg.CosignVerify:
+		step("cosign", "skip", "cosign_verify off", "")
+	case !local:
+		step("cosign", "info", "remote module: verified after download", "")
+	default:
+		var err error
+		if signer, err = simCosign(path); err != nil { step("cosign", "deny", err.Error(), "download_or_verify_failed") } else { step("cosign", "allow", signer, "") }
+	}
+	switch ok, err := simOPA(cfg, env, signer); {
+	case cfg.OPABase == "":
+		step("opa", "skip", "opa_base not set", "")
+	case err != nil:
+		step("opa", "deny", err.Error(), "opa_error")
+	case !ok:
+		step("opa", "deny", strings.TrimRight(cfg.OPABase, "/")+cfg.OPADecision+" = false", "deny_policy")
+	default:
+		step("opa", "allow", "", "")
+	}
+	if g, n := groupFor(cfg, env, module); g != "" { step("group", "info", fmt.Sprintf("%s max %d", g, n), "") }
+	if len(cfg.ScratchModules) > 0 && allowed(module, cfg.ScratchModules) { step("scratch", "info", "/scratch under "+cfg.ScratchDir, "") }
+	if rep.Result == "" { rep.Result = "ok" }
+	if rep.Result == "ok" && cfg.DryRun { rep.Result = "dryrun" }
+
+	var notGranted []string
+	for _, c := range env.Caps {
+		if !allowed(c, cfg.AllowCaps) { notGranted = append(notGranted, c) }
+	}
+	rep.Caps = map[string]any{"requested": env.Caps, "allowed": cfg.AllowCaps, "not_granted": notGranted, "http_hosts": cfg.AllowHTTPHosts}
+
+	lim := outputLimitsFor(cfg, env)
+	output := "kill"
+	if !lim.kill { output = "throttle" }
+	mem := uint32(0) // wazero default
+	if rep.Path == pathCanary { mem = cfg.CanaryMemMB }
+	rep.Limits = map[string]any{
+		"timeout_ms": cfg.DefaultTO.Milliseconds(), "soft_timeout_ms": cfg.DefaultTO.Milliseconds() * int64(cfg.SoftTimeoutPct) / 100,
+		"mem_limit_mb": mem, "max_stdout_kb": lim.stdout >> 10, "max_events": lim.events, "max_event_kb": lim.eventBytes >> 10, "output_action": output,
+		"http_max_kb": cfg.MaxHTTPKB, "http_rps": cfg.HTTPRPS, "http_burst": cfg.HTTPBurst, "event_schema_action": cfg.EventSchemaAction, "clock": cfg.Clock,
+	}
+	return rep
+}
+
+// simCosign verifies path like the security executor and returns the signer.
+func simCosign(path string) (string, error) {
+	args := []string{"verify-blob", "--output=json"}
+	if _, err := os.Stat(path + ".crt"); err == nil { args = append(args, "--certificate", path+".crt") }
+	if _, err := os.Stat(path + ".sig"); err == nil { args = append(args, "--signature", path+".sig") }
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "cosign", append(args, path)...).CombinedOutput()
+	if err != nil { return "", fmt.Errorf("cosign: %v (%s)", err, bytes.TrimSpace(out)) }
+	var cj struct{ Cert struct{ Email, Subject string } }
+	json.Unmarshal(out, &cj)
+	if cj.Cert.Email != "" { return cj.Cert.Email, nil }
+	return cj.Cert.Subject, nil
+}
+
+// simOPA asks OPA for the decision the security executor would get.
+func simOPA(cfg Config, env *Envelope, signer string) (bool, error) {
+	if cfg.OPABase == "" { return true, nil }
+	input := map[string]any{"module": env.Module, "caps": env.Caps, "limits": env.Limits, "sha256": env.SHA256}
+	if signer != "" { input["signer"] = signer }
+	body, _ := json.Marshal(map[string]any{"input": input})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimRight(cfg.OPABase, "/")+cfg.OPADecision, bytes.NewReader(body))
+	if err != nil { return false, err }
+	req.Header.Set("content-type", "application/json")
+	resp, err := relayClient.Do(req)
+	if err != nil { return false, err }
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 { return false, fmt.Errorf("opa status %d", resp.StatusCode) }
+	var out struct{ Result *bool `json:"result"` }
+	if json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&out) != nil || out.Result == nil { return false, errors.New("opa: no boolean result (undefined decision?)") }
+	return *out.Result, nil
+}
+
+func handleSimulate(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil { writeJSON(w, 400, map[string]any{"error": err.Error()}); return }
+	cfg := currentConfig()
+	env, err := decodeEnvelope(r.Header.Get("content-type"), body, cfg.StrictEnvelopes)
+	if err != nil { writeJSON(w, 400, map[string]any{"error": err.Error()}); return }
+	writeJSON(w, 200, simulatePolicy(cfg, &env))
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// --- gRPC event sink (event_sink: grpc) ---
+// Queued events go to EventSink.Publish at event_grpc as EventBatch messages
+// (schema/events.v1.proto) instead of relay POSTs, and every finished run
+// sends a RunReceipt with its syscall timeline. Messages are encoded with
+// protowire and passed through a bytes codec, so no generated code is
+// needed; batching, retries and spill work as for the relay. An event's id
+// and time are fixed when it is queued, so a retried or replayed batch
+// carries the same ids and the sink can dedupe on them.
+
+const publishMethod = "/void.events.v1.EventSink/Publish"
+
+var (
+	sinkOnce    sync.Once
+	sinkConn    *grpc.ClientConn
+	sinkConnErr error
+)
+
+// rawCodec sends pre-encoded protobuf bytes as they are.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v any) ([]byte, error)      { return *v.(*[]byte), nil }
+func (rawCodec) Unmarshal(data []byte, v any) error { *v.(*[]byte) = append((*v.(*[]byte))[:0], data...); return nil }
+func (rawCodec) Name() string                       { return "proto" }
+
+func grpcConn(cfg Config) (*grpc.ClientConn, error) {
+	sinkOnce.Do(func() {
+		creds := insecure.NewCredentials()
+		if cfg.EventGRPCTLS { creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12}) }
+		sinkConn, sinkConnErr = grpc.NewClient(cfg.EventGRPC, grpc.WithTransportCredentials(creds))
+	})
+	return sinkConn, sinkConnErr
+}
+
+// publishWithRetry is postWithRetry for the gRPC sink.
+func publishWithRetry(cfg Config, batch []byte) error {
+	conn, err := grpcConn(cfg)
+	if err != nil { return err }
+	backoff := 200 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.RelayTimeout)
+		var ack []byte
+		err = conn.Invoke(ctx, publishMethod, &batch, &ack, grpc.ForceCodec(rawCodec{}))
+		cancel()
+		if err == nil { relayOK.Add(1); eventBytes.WithLabelValues("protobuf").Add(float64(len(batch))); return nil }
+		switch status.Code(err) {
+		case codes.InvalidArgument, codes.Unimplemented, codes.PermissionDenied, codes.Unauthenticated:
+			return err
+		}
+		relayFail.Add(1)
+		if attempt >= cfg.EventRetries { return err }
+		eventRetries.Inc()
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func publishBatch(cfg Config, batch []queuedEvent) error {
+	var b []byte
+	for _, qe := range batch { b = pbBytes(b, 1, pbEvent(cfg, qe)) }
+	return publishWithRetry(cfg, b)
+}
+
+// publishReceipt sends rec as a RunReceipt off the caller's goroutine.
+func publishReceipt(cfg Config, rec *RunRecord) {
+	b := pbBytes(nil, 2, pbReceipt(cfg, rec))
+	go func() {
+		if err := publishWithRetry(cfg, b); err != nil {
+			eventsDropped.WithLabelValues("receipt_lost").Inc()
+			fmt.Println("[grpc] receipt", rec.ID, "lost:", err, rec.corr())
+		}
+	}()
+}
+
+func pbEvent(cfg Config, qe queuedEvent) []byte {
+	data, _ := json.Marshal(qe.body())
+	var head struct{ Type string `json:"type"` }
+	json.Unmarshal(data, &head)
+	var m []byte
+	m = pbString(m, 1, qe.id)
+	m = pbString(m, 2, head.Type)
+	m = pbString(m, 3, nodeID(cfg))
+	if rec := qe.run; rec != nil {
+		m = pbString(m, 4, rec.ID)
+		m = pbString(m, 5, rec.Module)
+		m = pbString(m, 6, rec.Tenant)
+		if rec.Envelope != nil {
+			if tp, _ := rec.Envelope.Meta["traceparent"].(string); traceparentRe.MatchString(tp) { m = pbString(m, 9, tp) }
+		}
+		m = pbString(m, 10, rec.Correlation)
+	}
+	m = pbInt(m, 7, qe.at.UnixNano())
+	m = pbBytes(m, 8, data)
+	if sig := eventSignature(cfg, data); sig != nil { m = pbBytes(m, 11, pbSignature(sig)) }
+	return m
+}
+
+func pbSignature(s *ResultSignature) []byte {
+	var t []byte
+	t = pbString(t, 1, s.Node)
+	t = pbString(t, 2, s.KeyID)
+	t = pbString(t, 3, s.Alg)
+	t = pbString(t, 4, s.Digest)
+	return pbString(t, 5, s.Sig)
+}
+
+func pbReceipt(cfg Config, rec *RunRecord) []byte {
+	var m []byte
+	m = pbString(m, 1, rec.ID)
+	m = pbString(m, 2, rec.Module)
+	m = pbString(m, 3, rec.Tenant)
+	m = pbString(m, 4, rec.SHA256)
+	m = pbString(m, 5, rec.Path)
+	m = pbString(m, 6, rec.Result)
+	m = pbString(m, 7, rec.Error)
+	m = pbInt(m, 8, rec.Started.UnixNano())
+	m = pbInt(m, 9, rec.FetchMs)
+	m = pbInt(m, 10, rec.RunMs)
+	m = pbInt(m, 11, rec.TotalMs)
+	m = pbInt(m, 12, rec.NetBytes)
+	for _, s := range rec.Syscalls {
+		var t []byte
+		t = pbInt(t, 1, int64(s.Seq))
+		t = pbString(t, 2, s.Kind)
+		t = pbString(t, 3, s.ID)
+		t = pbString(t, 4, s.Result)
+		t = pbInt(t, 5, s.AtMs)
+		if s.Ms != 0 { t = protowire.AppendFixed64(protowire.AppendTag(t, 6, protowire.Fixed64Type), math.Float64bits(s.Ms)) }
+		m = pbBytes(m, 13, t)
+	}
+	m = pbInt(m, 14, int64(rec.SyscallsDropped))
+	for _, d := range rec.Decisions { m = pbBytes(m, 15, []byte(d)) }
+	m = pbString(m, 16, nodeID(cfg))
+	m = pbString(m, 17, rec.Attestation)
+	for _, a := range rec.Artifacts {
+		var t []byte
+		t = pbString(t, 1, a.Name)
+		t = pbString(t, 2, a.SHA256)
+		t = pbInt(t, 3, a.Size)
+		t = pbString(t, 4, a.URL)
+		t = pbString(t, 5, a.CID)
+		m = pbBytes(m, 18, t)
+	}
+	m = pbString(m, 19, rec.Correlation)
+	if b := rec.Budget; b != nil {
+		dims := func(d RunBudget) []byte {
+			var t []byte
+			t = pbInt(t, 1, d.WallMs)
+			t = pbInt(t, 2, d.Fuel)
+			t = pbInt(t, 3, d.NetKB)
+			return pbInt(t, 4, d.Syscalls)
+		}
+		var t []byte
+		t = pbBytes(t, 1, dims(b.Limit))
+		t = pbBytes(t, 2, dims(b.Used))
+		t = pbString(t, 3, b.Exhausted)
+		m = pbBytes(m, 20, t)
+	}
+	if rec.Signature != nil { m = pbBytes(m, 21, pbSignature(rec.Signature)) }
+	return m
+}
+
+// proto3 scalars are omitted at their zero value; pbBytes always writes
+// (embedded messages and repeated elements).
+func pbString(b []byte, n protowire.Number, s string) []byte {
+	if s == "" { return b }
+	return protowire.AppendString(protowire.AppendTag(b, n, protowire.BytesType), s)
+}
+
+func pbBytes(b []byte, n protowire.Number, v []byte) []byte {
+	return protowire.AppendBytes(protowire.AppendTag(b, n, protowire.BytesType), v)
+}
+
+func pbInt(b []byte, n protowire.Number, v int64) []byte {
+	if v == 0 { return b }
+	return protowire.AppendVarint(protowire.AppendTag(b, n, protowire.VarintType), uint64(v))
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var protoField = regexp.MustCompile(`^\s*(repeated\s+)?(\w+)\s+(\w+)\s*=\s*(\d+);`)
+
+// eventsSchema builds the message types of schema/events.v1.proto (flat
+// messages, scalar and message fields) so the hand encoding is decoded with
+// the same descriptors generated code would use.
+func eventsSchema(t *testing.T) protoreflect.FileDescriptor {
+	t.Helper()
+	src, err := schemaFS.ReadFile("schema/events.v1.proto")
+	if err != nil { t.Fatal(err) }
+	scalars := map[string]descriptorpb.FieldDescriptorProto_Type{
+		"string": descriptorpb.FieldDescriptorProto_TYPE_STRING, "bytes": descriptorpb.FieldDescriptorProto_TYPE_BYTES,
+		"int64": descriptorpb.FieldDescriptorProto_TYPE_INT64, "uint32": descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+		"double": descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+	}
+	fdp := &descriptorpb.FileDescriptorProto{Name: proto.String("events.v1.proto"), Package: proto.String("void.events.v1"), Syntax: proto.String("proto3")}
+	var msg *descriptorpb.DescriptorProto
+	sc := bufio.NewScanner(strings.NewReader(string(src)))
+	for sc.Scan() {
+		line := sc.Text()
+		if f := strings.Fields(line); len(f) >= 3 && f[0] == "message" {
+			msg = &descriptorpb.DescriptorProto{Name: proto.String(f[1])}
+			fdp.MessageType = append(fdp.MessageType, msg)
+			continue
+		}
+		if strings.HasPrefix(line, "}") { msg = nil; continue }
+		m := protoField.FindStringSubmatch(line)
+		if msg == nil || m == nil { continue }
+		n, _ := strconv.Atoi(m[4])
+		fd := &descriptorpb.FieldDescriptorProto{Name: proto.String(m[3]), Number: proto.Int32(int32(n)), JsonName: proto.String(m[3]), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()}
+		if m[1] != "" { fd.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum() }
+		if typ, ok := scalars[m[2]]; ok {
+			fd.Type = typ.Enum()
+		} else {
+			fd.Type, fd.TypeName = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(), proto.String(".void.events.v1."+m[2])
+		}
+		msg.Field = append(msg.Field, fd)
+	}
+	fd, err := protodesc.NewFile(fdp, nil)
+	if err != nil { t.Fatal(err) }
+	return fd
+}
+
+// decodeBatch parses b as an EventBatch, fails on any field the schema does
+// not know (a wrong number or wire type lands there), and returns it as
+// protojson with the .proto field names.
+func decodeBatch(t *testing.T, fd protoreflect.FileDescriptor, b []byte) map[string]any {
+	t.Helper()
+	msg := dynamicpb.NewMessage(fd.Messages().ByName("EventBatch"))
+	if err := proto.Unmarshal(b, msg); err != nil { t.Fatal(err) }
+	var unknown func(m protoreflect.Message, path string)
+	unknown = func(m protoreflect.Message, path string) {
+		if len(m.GetUnknown()) > 0 { t.Errorf("%s: fields not in events.v1.proto: %x", path, m.GetUnknown()) }
+		m.Range(func(f protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+			switch {
+			case f.Kind() != protoreflect.MessageKind:
+			case f.IsList():
+				for i := 0; i < v.List().Len(); i++ { unknown(v.List().Get(i).Message(), path+"."+string(f.Name())) }
+			default:
+				unknown(v.Message(), path+"."+string(f.Name()))
+			}
+			return true
+		})
+	}
+	unknown(msg, "EventBatch")
+	js, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg)
+	if err != nil { t.Fatal(err) }
+	var out map[string]any
+	json.Unmarshal(js, &out)
+	return out
+}
+
+func TestPbRoundTrip(t *testing.T) {
+	fd := eventsSchema(t)
+	testIdentity(t, "ed25519")
+	cfg := Config{NodeID: "node-a", SignEvents: true}
+	tp := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	rec := &RunRecord{
+		ID: "r1", Module: "demo/hello", Tenant: "acme", SHA256: "ab", Path: pathStable, Result: "ok", Error: "boom",
+		Envelope: &Envelope{Meta: map[string]any{"traceparent": tp}}, Correlation: "c-1",
+		Started: time.Unix(1700000000, 5), FetchMs: 1, RunMs: 2, TotalMs: 3, NetBytes: 4,
+		Syscalls:    []SyscallTrace{{Seq: 1, Kind: "http", ID: "h1", Result: "ok", AtMs: 7, Ms: 1.5}, {Seq: 2, Kind: "kv", Result: "denied"}},
+		SyscallsDropped: 3, Decisions: []string{"allow", "canary"}, Attestation: "dd",
+		Artifacts: []Artifact{{Name: "out.txt", SHA256: "ee", Size: 9, URL: "s3://b/out.txt", CID: "bafy"}},
+		Budget:    &BudgetReport{Limit: RunBudget{WallMs: 100, Fuel: 200, NetKB: 300, Syscalls: 400}, Used: RunBudget{WallMs: 10}, Exhausted: "fuel"},
+		Signature: &ResultSignature{Node: "node-a", KeyID: "k", Alg: "ed25519", Digest: "ff", Sig: "c2ln"},
+	}
+	qe := queuedEvent{raw: json.RawMessage(`{"type":"demo.done","n":1}`), run: rec, id: "e1", at: time.Unix(0, 123)}
+	node := queuedEvent{ev: map[string]any{"type": "heartbeat"}, id: "e2", at: time.Unix(0, 456)}
+	b := pbBytes(nil, 1, pbEvent(cfg, qe))
+	b = pbBytes(b, 1, pbEvent(cfg, node))
+	b = pbBytes(b, 2, pbReceipt(cfg, rec))
+	got := decodeBatch(t, fd, b)
+
+	events, _ := got["events"].([]any)
+	if len(events) != 2 { t.Fatalf("events = %v", got["events"]) }
+	ev := events[0].(map[string]any)
+	for k, want := range map[string]any{"id": "e1", "type": "demo.done", "node": "node-a", "run_id": "r1", "module": "demo/hello", "tenant": "acme", "time_unix_nano": "123", "traceparent": tp, "correlation_id": "c-1"} {
+		if ev[k] != want { t.Errorf("event %s = %v, want %v", k, ev[k], want) }
+	}
+	if data, _ := ev["data_json"].(string); data != "eyJ0eXBlIjoiZGVtby5kb25lIiwibiI6MX0=" { t.Errorf("data_json = %v", ev["data_json"]) }
+	if sig, _ := ev["signature"].(map[string]any); sig == nil || sig["alg"] != "ed25519" || sig["node"] != "node-a" || sig["key_id"] != identityKeyID {
+		t.Errorf("event signature = %v", ev["signature"])
+	}
+	ev = events[1].(map[string]any)
+	if ev["id"] != "e2" || ev["type"] != "heartbeat" || ev["run_id"] != nil || ev["time_unix_nano"] != "456" { t.Errorf("node event = %v", ev) }
+
+	receipts, _ := got["receipts"].([]any)
+	if len(receipts) != 1 { t.Fatalf("receipts = %v", got["receipts"]) }
+	r := receipts[0].(map[string]any)
+	for k, want := range map[string]any{"id": "r1", "module": "demo/hello", "tenant": "acme", "sha256": "ab", "path": pathStable, "result": "ok", "error": "boom",
+		"started_unix_nano": "1700000000000000005", "fetch_ms": "1", "run_ms": "2", "total_ms": "3", "net_bytes": "4", "syscalls_dropped": float64(3),
+		"node": "node-a", "attestation": "dd", "correlation_id": "c-1"} {
+		if r[k] != want { t.Errorf("receipt %s = %v, want %v", k, r[k], want) }
+	}
+	check := func(name string, v any, want string) {
+		t.Helper()
+		b, _ := json.Marshal(v)
+		if string(b) != want { t.Errorf("receipt %s = %s, want %s", name, b, want) }
+	}
+	check("syscalls", r["syscalls"], `[{"at_ms":"7","id":"h1","kind":"http","ms":1.5,"result":"ok","seq":1},{"kind":"kv","result":"denied","seq":2}]`)
+	check("decisions", r["decisions"], `["allow","canary"]`)
+	check("artifacts", r["artifacts"], `[{"cid":"bafy","name":"out.txt","sha256":"ee","size":"9","url":"s3://b/out.txt"}]`)
+	check("budget", r["budget"], `{"exhausted":"fuel","limit":{"fuel":"200","net_kb":"300","syscalls":"400","wall_ms":"100"},"used":{"wall_ms":"10"}}`)
+	check("signature", r["signature"], `{"alg":"ed25519","digest":"ff","key_id":"k","node":"node-a","sig":"c2ln"}`)
+}
+
+func TestSpillKeepsEventID(t *testing.T) {
+	cfg := Config{EventSpillDir: t.TempDir(), RelayBase: "http://relay"}
+	at := time.Unix(1700000000, 42)
+	evs := []queuedEvent{
+		{raw: json.RawMessage(`{"type":"demo.done"}`), id: "e1", at: at},
+		{ev: map[string]any{"type": "heartbeat"}, id: "e2", at: at},
+	}
+	if n := writeSpill(cfg, evs); n != 2 { t.Fatalf("spilled %d", n) }
+	f, err := os.Open(spillPath(cfg))
+	if err != nil { t.Fatal(err) }
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	var got []queuedEvent
+	for sc.Scan() {
+		qe, ok := unspill(cfg, sc.Bytes())
+		if !ok { t.Fatalf("unspill %s", sc.Bytes()) }
+		got = append(got, qe)
+	}
+	if len(got) != 2 { t.Fatalf("replayed %d", len(got)) }
+	for i, want := range []string{`{"type":"demo.done"}`, `{"type":"heartbeat"}`} {
+		if got[i].id != evs[i].id || !got[i].at.Equal(at) || string(got[i].raw) != want || got[i].base != cfg.RelayBase {
+			t.Errorf("line %d = %+v", i, got[i])
+		}
+	}
+	// a line spilled before ids were kept is the bare event
+	qe, ok := unspill(cfg, []byte(`{"type":"old"}`))
+	if !ok || qe.id == "" || string(qe.raw) != `{"type":"old"}` { t.Errorf("legacy line = %+v", qe) }
+	if _, ok := unspill(cfg, []byte(`{"type":`)); ok { t.Error("invalid line accepted") }
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- NATS publish sink ---
+// With nats_url set, events that belong to a run (module-emitted ones and
+// wasm.result) are also published to <nats_subject>.<type>, so other void
+// services can consume results straight off the bus; relay or gRPC delivery
+// is unchanged. The payload is the event as posted (CloudEvents-wrapped if
+// enabled), with run/module/tenant/node headers. nats_jetstream publishes
+// through JetStream and waits for the acks, creating nats_stream over
+// <nats_subject>.> if it does not exist. Spilled events replay to the relay
+// only; they were offered to NATS when first sent. Publishing runs on its
+// own worker behind a queue of event_queue events, so a slow or unreachable
+// server never holds up relay delivery; past that the events are dropped
+// for NATS (result queue_full).
+
+var natsPublished = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_nats_published_total", Help: "Events published to NATS by result"}, []string{"result"})
+
+var (
+	natsQ       chan queuedEvent
+	natsPending atomic.Int64 // offered but not yet published
+)
+
+func startNATS(cfg Config) {
+	natsQ = make(chan queuedEvent, cfg.EventQueue)
+	go func() {
+		batch := make([]queuedEvent, 0, cfg.EventBatch)
+		for qe := range natsQ {
+			batch = append(batch[:0], qe)
+			for len(batch) < cfg.EventBatch && len(natsQ) > 0 { batch = append(batch, <-natsQ) }
+			publishNATS(cfg, batch)
+			natsPending.Add(-int64(len(batch)))
+		}
+	}()
+}
+
+// offerNATS queues the run events in batch for the NATS worker.
+func offerNATS(batch []queuedEvent) {
+	for _, qe := range batch {
+		if qe.run == nil { continue }
+		natsPending.Add(1)
+		select {
+		case natsQ <- qe:
+		default:
+			natsPending.Add(-1)
+			natsPublished.WithLabelValues("queue_full").Inc()
+		}
+	}
+}
+
+var (
+	natsOnce    sync.Once
+	natsConn    *nats.Conn
+	natsJS      nats.JetStreamContext
+	natsConnErr error
+)
+
+func natsSink(cfg Config) (*nats.Conn, nats.JetStreamContext, error) {
+	natsOnce.Do(func() {
+		opts := []nats.Option{nats.Name("void-wasm-exec " + nodeID(cfg)), nats.MaxReconnects(-1), nats.RetryOnFailedConnect(true)}
+		if cfg.NATSCreds != "" { opts = append(opts, nats.UserCredentials(cfg.NATSCreds)) }
+		natsConn, natsConnErr = nats.Connect(cfg.NATSURL, opts...)
+		if natsConnErr != nil || !cfg.NATSJetStream { return }
+		natsJS, natsConnErr = natsConn.JetStream(nats.MaxWait(cfg.RelayTimeout))
+		if natsConnErr != nil || cfg.NATSStream == "" { return }
+		if _, err := natsJS.StreamInfo(cfg.NATSStream); errors.Is(err, nats.ErrStreamNotFound) {
+			_, err = natsJS.AddStream(&nats.StreamConfig{Name: cfg.NATSStream, Subjects: []string{cfg.NATSSubject + ".>"}})
+			if err != nil { fmt.Println("[nats] create stream", cfg.NATSStream, "failed:", err) } else { fmt.Println("[nats] created stream", cfg.NATSStream) }
+		}
+	})
+	return natsConn, natsJS, natsConnErr
+}
+
+// natsSubject maps an event type to a subject; characters NATS reserves
+// become '_' and empty tokens are dropped.
+func natsSubject(prefix, typ string) string {
+	var b strings.Builder
+	b.WriteString(prefix)
+	for _, tok := range strings.Split(typ, ".") {
+		if tok == "" { continue }
+		b.WriteByte('.')
+		for _, r := range tok {
+			if r <= ' ' || r == '*' || r == '>' || r == 0x7f { r = '_' }
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() == len(prefix) { b.WriteString(".untyped") }
+	return b.String()
+}
+
+// publishNATS offers the run events in batch to NATS; failures are counted,
+// never retried (the relay path has its own retries and spill).
+func publishNATS(cfg Config, batch []queuedEvent) {
+	nc, js, err := natsSink(cfg)
+	if err != nil { fmt.Println("[nats] connect:", err) }
+	var pending []nats.PubAckFuture
+	for _, qe := range batch {
+		rec := qe.run
+		if rec == nil { continue }
+		if err != nil { natsPublished.WithLabelValues("error").Inc(); continue }
+		data := []byte(qe.raw)
+		if data == nil { data, _ = json.Marshal(qe.ev) }
+		var head struct{ Type string `json:"type"` }
+		json.Unmarshal(data, &head)
+		msg := nats.NewMsg(natsSubject(cfg.NATSSubject, head.Type))
+		msg.Data = data
+		msg.Header.Set("Void-Run", rec.ID)
+		msg.Header.Set("Void-Module", rec.Module)
+		msg.Header.Set("Void-Tenant", rec.Tenant)
+		if rec.Correlation != "" { msg.Header.Set("Void-Correlation", rec.Correlation) }
+		msg.Header.Set("Void-Node", nodeID(cfg))
+		if sig := eventSignature(cfg, data); sig != nil { msg.Header.Set("Void-Signature", sig.header()) }
+		if js == nil {
+			if err := nc.PublishMsg(msg); err != nil { natsPublished.WithLabelValues("error").Inc(); continue }
+			natsPublished.WithLabelValues("ok").Inc()
+			continue
+		}
+		f, err := js.PublishMsgAsync(msg)
+		if err != nil { natsPublished.WithLabelValues("error").Inc(); continue }
+		pending = append(pending, f)
+	}
+	if js == nil { return }
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.RelayTimeout)
+	defer cancel()
+	for _, f := range pending {
+		select {
+		case <-f.Ok():
+			natsPublished.WithLabelValues("ok").Inc()
+		case err := <-f.Err():
+			natsPublished.WithLabelValues("error").Inc()
+			fmt.Println("[nats] jetstream:", err)
+		case <-ctx.Done():
+			natsPublished.WithLabelValues("timeout").Inc()
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- SLO self-evaluation: error budget burn + p95, emitting slo.breach ---
+// Finished runs go into a ring of the last sloMaxSamples, so a busy node's
+// windows cover at most that many runs.
+var (
+	sloBurn   = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "void_wasm_slo_burn_rate", Help: "Error budget burn rate by window"}, []string{"window"})
+	sloP95    = prometheus.NewGauge(prometheus.GaugeOpts{Name: "void_wasm_slo_p95_ms", Help: "p95 run latency over the short SLO window"})
+	sloBreach = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "void_wasm_slo_breach", Help: "1 while an SLO is breached"}, []string{"slo"})
+)
+
+type sloSample struct {
+	t   time.Time
+	bad bool
+	ms  float64
+}
+
+const sloMaxSamples = 1 << 16
+
+var (
+	sloMu      sync.Mutex
+	sloSamples []sloSample // grows to sloMaxSamples, then wraps at sloNext
+	sloNext    int
+)
+
+// sloBad: results that spend error budget (policy denies and dry runs don't).
+func sloBad(result string) bool {
+	switch result {
+	case "error", "download_error", "canceled": return true
+	}
+	return false
+}
+
+func recordSLO(result string, ms int64) {
+	if result == "" || result == "dryrun" || result == "shutdown" { return }
+	s := sloSample{t: time.Now(), bad: sloBad(result), ms: float64(ms)}
+	sloMu.Lock(); defer sloMu.Unlock()
+	if len(sloSamples) < sloMaxSamples { sloSamples = append(sloSamples, s); return }
+	sloSamples[sloNext] = s
+	sloNext = (sloNext + 1) % sloMaxSamples
+}
+
+// sloWindow returns error ratio, p95 and count for samples newer than d.
+func sloWindow(d time.Duration) (ratio, p95 float64, n int) {
+	sloMu.Lock(); defer sloMu.Unlock()
+	now := time.Now()
+	bad := 0
+	lat := []float64{}
+	for k := range len(sloSamples) { // newest first
+		s := sloSamples[(sloNext-1-k+len(sloSamples))%len(sloSamples)]
+		if now.Sub(s.t) > d { break }
+		n++
+		if s.bad { bad++ }
+		lat = append(lat, s.ms)
+	}
+	if n == 0 { return 0, 0, 0 }
+	sort.Float64s(lat)
+	return float64(bad) / float64(n), lat[(len(lat)*95+99)/100-1], n
+}
+
+func sloLoop(cfg Config) {
+	if cfg.SLOErrorTarget <= 0 { return }
+	active := map[string]time.Time{} // slo -> last breach event
+	emit := func(slo string, breached bool, meta map[string]any) {
+		last, was := active[slo]
+		if breached {
+			sloBreach.WithLabelValues(slo).Set(1)
+			if was && time.Since(last) < cfg.SLORenotify { return }
+			active[slo] = time.Now()
+			meta["slo"] = slo; meta["node"] = nodeID(cfg)
+			fmt.Println("[slo] breach", slo, meta)
+			postEvent(cfg, map[string]any{"type": "slo.breach", "status": "error", "meta": meta})
+			annotate(cfg, "slo", "slo.breach "+slo, "slo:"+slo)
+			return
+		}
+		sloBreach.WithLabelValues(slo).Set(0)
+		if was {
+			delete(active, slo)
+			fmt.Println("[slo] recovered", slo)
+			postEvent(cfg, map[string]any{"type": "slo.recovered", "meta": map[string]any{"slo": slo, "node": nodeID(cfg)}})
+			annotate(cfg, "slo", "slo.recovered "+slo, "slo:"+slo)
+		}
+	}
+	for {
+		time.Sleep(cfg.SLOEvalEvery)
+		shortR, p95, n := sloWindow(cfg.SLOShortWindow)
+		longR, _, nLong := sloWindow(cfg.SLOLongWindow)
+		shortBurn, longBurn := shortR/cfg.SLOErrorTarget, longR/cfg.SLOErrorTarget
+		sloBurn.WithLabelValues("short").Set(shortBurn)
+		sloBurn.WithLabelValues("long").Set(longBurn)
+		sloP95.Set(p95)
+		enough := n >= cfg.SLOMinRuns
+		// multi-window: both windows must burn fast, so one bad minute doesn't page
+		emit("error_budget", enough && nLong >= cfg.SLOMinRuns && shortBurn >= cfg.SLOBurnRate && longBurn >= cfg.SLOBurnRate,
+			map[string]any{"burn_short": shortBurn, "burn_long": longBurn, "error_ratio": shortR, "target": cfg.SLOErrorTarget, "runs": n})
+		emit("latency_p95", enough && p95 > float64(cfg.SLOP95.Milliseconds()),
+			map[string]any{"p95_ms": p95, "target_ms": cfg.SLOP95.Milliseconds(), "runs": n})
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSLORing(t *testing.T) {
+	sloMu.Lock()
+	saved, savedNext := sloSamples, sloNext
+	sloSamples, sloNext = nil, 0
+	sloMu.Unlock()
+	t.Cleanup(func() { sloMu.Lock(); sloSamples, sloNext = saved, savedNext; sloMu.Unlock() })
+
+	for i := range 100 {
+		result := "ok"
+		if i%10 == 0 { result = "error" }
+		recordSLO(result, int64(i+1))
+	}
+	recordSLO("dryrun", 1e6) // not counted
+	if ratio, p95, n := sloWindow(time.Minute); n != 100 || ratio != 0.1 || p95 != 95 { t.Errorf("window = %v %v %d", ratio, p95, n) }
+
+	// past the cap the oldest runs fall out; the window stays in time order
+	for range sloMaxSamples { recordSLO("ok", 5) }
+	sloMu.Lock()
+	if len(sloSamples) != sloMaxSamples { t.Errorf("ring holds %d", len(sloSamples)) }
+	sloSamples[(sloNext+sloMaxSamples-1)%sloMaxSamples].t = time.Now().Add(-time.Hour) // stale newest: nothing is in the window
+	sloMu.Unlock()
+	if _, _, n := sloWindow(time.Minute); n != 0 { t.Errorf("window past a stale sample = %d", n) }
+	recordSLO("error", 7)
+	if ratio, p95, n := sloWindow(time.Minute); n != 1 || ratio != 1 || p95 != 7 { t.Errorf("after wrap = %v %v %d", ratio, p95, n) }
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Downstream result stream (GET /stream, admin server) ---
+// Local subscribers (dashboards, other agents) can follow what the executor
+// does without the relay: GET /stream is Server-Sent Events with
+//   event: run.receipt  every booked run, its record without syscall traces
+//   event: run.denied   policy denies (tenant, allowlist, schedule, quota,
+//                       mounts, env) with the result and reason
+//   event: <type>       events runs post (wasm.result, wasm.progress, ...) as
+//                       the module emitted them, before any CloudEvents
+//                       wrapping
+// each with an increasing id. ?module=a,b and ?type=run.*,wasm.result filter
+// per subscriber (exact or trailing-* prefix, like allow_modules; both
+// must match), ?tenant=t too. Streaming is best effort: a subscriber that
+// falls streamQueue frames behind loses them and gets "event: dropped" with
+// the count once it catches up; nothing is replayed on reconnect.
+
+const streamQueue = 256
+
+var (
+	streamEvents = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_stream_events_total", Help: "Frames to GET /stream subscribers by result"}, []string{"result"})
+	streamSubs   = prometheus.NewGauge(prometheus.GaugeOpts{Name: "void_wasm_stream_subscribers", Help: "Connected GET /stream subscribers"})
+)
+
+type streamSub struct {
+	modules, types []string // empty = any
+	tenant         string
+	ch             chan []byte
+	dropped        atomic.Int64
+}
+
+var (
+	streamMu   sync.Mutex
+	streamSeq  uint64
+	streamSet  = map[*streamSub]bool{}
+	streamLive atomic.Int32 // len(streamSet), checked before encoding anything
+)
+
+func (s *streamSub) wants(typ, module, tenant string) bool {
+	if len(s.types) > 0 && !allowed(typ, s.types) { return false }
+	if len(s.modules) > 0 && !allowed(module, s.modules) { return false }
+	return s.tenant == "" || s.tenant == tenant
+}
+
+// streamPublish sends data (JSON) as one frame to every matching subscriber.
+func streamPublish(typ, module, tenant string, data []byte) {
+	streamMu.Lock(); defer streamMu.Unlock()
+	var frame []byte
+	for s := range streamSet {
+		if !s.wants(typ, module, tenant) { continue }
+		if frame == nil {
+			streamSeq++
+			frame = []byte(fmt.Sprintf("event: %s\nid: %d\ndata: %s\n\n", typ, streamSeq, data))
+		}
+		select {
+		case s.ch <- frame:
+			streamEvents.WithLabelValues("sent").Inc()
+		default:
+			s.dropped.Add(1)
+			streamEvents.WithLabelValues("dropped").Inc()
+		}
+	}
+}
+
+// streamRun publishes a booked run: its receipt, and run.denied for policy
+// denies (deny_schedule posts its own run.denied, which streamRunEvent
+// passes on).
+func streamRun(rec *RunRecord) {
+	if streamLive.Load() == 0 { return }
+	r := *rec
+	r.Syscalls, r.Envelope = nil, nil
+	b, _ := json.Marshal(r)
+	streamPublish("run.receipt", rec.Module, rec.Tenant, b)
+	if strings.HasPrefix(rec.Result, "deny_") && rec.Result != "deny_schedule" {
+		reason := rec.Error
+		if reason == "" && len(rec.Decisions) > 0 { reason = rec.Decisions[len(rec.Decisions)-1] }
+		b, _ = json.Marshal(map[string]any{"type": "run.denied", "meta": map[string]any{"run": rec.ID, "module": rec.Module, "tenant": rec.Tenant, "result": rec.Result, "reason": reason, "decisions": rec.Decisions}})
+		streamPublish("run.denied", rec.Module, rec.Tenant, b)
+	}
+}
+
+// streamRunEvent publishes an event a run posts; raw is the module's own
+// bytes when ev is nil.
+func streamRunEvent(rec *RunRecord, ev map[string]any, raw []byte) {
+	if rec == nil || streamLive.Load() == 0 { return }
+	var typ string
+	if ev != nil {
+		typ, _ = ev["type"].(string)
+		raw, _ = json.Marshal(ev)
+	} else {
+		var head struct{ Type string `json:"type"` }
+		json.Unmarshal(raw, &head)
+		typ = head.Type
+	}
+	if typ == "" { typ = "event" }
+	streamPublish(typ, rec.Module, rec.Tenant, raw)
+}
+
+func handleStream(w http.ResponseWriter, r *http.Request) {
+	fl, ok := w.(http.Flusher)
+	if !ok { writeJSON(w, 500, map[string]any{"error": "streaming unsupported"}); return }
+	q := r.URL.Query()
+	list := func(v string) []string {
+		if v == "" { return nil }
+		return strings.Split(v, ",")
+	}
+	s := &streamSub{modules: list(q.Get("module")), types: list(q.Get("type")), tenant: q.Get("tenant"), ch: make(chan []byte, streamQueue)}
+	streamMu.Lock()
+	streamSet[s] = true
+	streamLive.Store(int32(len(streamSet)))
+	streamMu.Unlock()
+	streamSubs.Inc()
+	defer func() {
+		streamMu.Lock()
+		delete(streamSet, s)
+		streamLive.Store(int32(len(streamSet)))
+		streamMu.Unlock()
+		streamSubs.Dec()
+	}()
+
+	w.Header().Set("content-type", "text/event-stream")
+	w.Header().Set("cache-control", "no-cache")
+	w.WriteHeader(200)
+	fmt.Fprintf(w, ": void-wasm-exec %s\n\n", nodeID(currentConfig()))
+	fl.Flush()
+	ping := time.NewTicker(15 * time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case frame := <-s.ch:
+			if n := s.dropped.Swap(0); n > 0 { fmt.Fprintf(w, "event: dropped\ndata: {\"dropped\":%d}\n\n", n) }
+			if _, err := w.Write(frame); err != nil { return }
+			fl.Flush()
+		case <-ping.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil { return }
+			fl.Flush()
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Multi-target module variants ---
+// Instead of one url/cid/sha256, an envelope may list "variants", each a
+// build of the same module for a target (wasm32-wasip1, wasm32-wasip2, ...)
+// and optimization level ("O3", "Os", ...) with its own url or cid and
+// sha256. The policy stage picks the first target in `targets` (what this
+// engine runs, in preference order) that has a variant, then among those the
+// first opt in `target_opts`; only that variant is fetched, and it is
+// verified against its own hash and cached under it, so variants never share
+// a cache entry. No runnable variant ends the run as no_target. The run
+// record carries the chosen target and opt and its sha256.
+
+var variantSelected = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_variant_selected_total", Help: "Module variants chosen by target and opt"}, []string{"target", "opt"})
+
+// engineTargets are the targets wazero with WASI preview 1 can run; preview 2
+// needs the component model.
+var engineTargets = []string{"wasm32-wasip1", "wasm32-wasi", "wasm32-unknown-unknown"}
+
+// ModuleVariant is one build listed in an envelope.
+type ModuleVariant struct {
+	Target string `json:"target"`
+	Opt    string `json:"opt,omitempty"`
+	URL    string `json:"url,omitempty"`
+	CID    string `json:"cid,omitempty"`
+	SHA256 string `json:"sha256"`
+}
+
+func validVariants(vs []ModuleVariant) error {
+	seen := map[string]bool{}
+	for i, v := range vs {
+		if v.Target == "" { return fmt.Errorf("[%d]: target required", i) }
+		if v.URL == "" && v.CID == "" { return fmt.Errorf("[%d]: url or cid required", i) }
+		if !sha256Hex.MatchString(v.SHA256) { return fmt.Errorf("[%d]: sha256 must be 64 hex chars", i) }
+		k := v.Target + "/" + v.Opt
+		if seen[k] { return fmt.Errorf("[%d]: duplicate %s", i, k) }
+		seen[k] = true
+	}
+	return nil
+}
+
+func validTargets(targets []string) error {
+	for _, t := range targets {
+		if !slices.Contains(engineTargets, t) { return fmt.Errorf("%q: this engine runs %s", t, strings.Join(engineTargets, ", ")) }
+	}
+	return nil
+}
+
+// selectVariant picks the variant this node should run; nil without variants.
+func selectVariant(cfg Config, vs []ModuleVariant) (*ModuleVariant, error) {
+	if len(vs) == 0 { return nil, nil }
+	targets := cfg.Targets
+	if len(targets) == 0 { targets = engineTargets }
+	for _, t := range targets {
+		var best *ModuleVariant
+		rank := func(opt string) int {
+			if i := slices.Index(cfg.TargetOpts, opt); i >= 0 { return i }
+			return len(cfg.TargetOpts) // unlisted opts rank last, in envelope order
+		}
+		for i := range vs {
+			if vs[i].Target == t && (best == nil || rank(vs[i].Opt) < rank(best.Opt)) { best = &vs[i] }
+		}
+		if best != nil {
+			variantSelected.WithLabelValues(best.Target, best.Opt).Inc()
+			return best, nil
+		}
+	}
+	have := make([]string, 0, len(vs))
+	for _, v := range vs { have = append(have, v.Target) }
+	return nil, errors.New("no variant for this engine: have " + strings.Join(have, ", ") + ", run " + strings.Join(targets, ", "))
+}
+
+// variantSource is env with v's source and hash, for fetchModule.
+func variantSource(env *Envelope, v *ModuleVariant) *Envelope {
+	if v == nil { return env }
+	src := *env
+	src.URL, src.CID, src.SHA256 = v.URL, v.CID, v.SHA256
+	return &src
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Multi-tenant namespaces ---
+// envelope.tenant selects a TenantPolicy that overrides allowlists and limits;
+// each tenant also gets its own KV file and cache dir. With no tenants
+// configured everything runs as "default" with the global settings.
+
+const defaultTenant = "default"
+
+type TenantPolicy struct {
+	AllowModules   []string `yaml:"allow_modules"`
+	AllowCaps      []string `yaml:"allow_caps"`
+	AllowHTTPHosts []string `yaml:"allow_http_hosts"`
+	HTTPRPS        int      `yaml:"http_rps"`
+	HTTPBurst      int      `yaml:"http_burst"`
+	CacheMaxMB     int      `yaml:"cache_max_mb"`
+
+	QuotaRuns    int64         `yaml:"quota_runs"` // tenant totals per quota_window
+	QuotaRunTime time.Duration `yaml:"quota_run_time"`
+	QuotaNetMB   int64         `yaml:"quota_net_mb"`
+}
+
+var tenantRuns = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_tenant_runs_total", Help: "Runs by tenant and result"}, []string{"tenant", "result"})
+
+var tenantName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+func tenantOf(env *Envelope) string {
+	if env.Tenant == "" { return defaultTenant }
+	return env.Tenant
+}
+
+// forTenant returns the effective config for one run; ok is false for an
+// unknown or malformed tenant. With no tenants configured the field is ignored.
+func (c Config) forTenant(name string) (Config, bool) {
+	if len(c.Tenants) == 0 { c.Tenant = defaultTenant; return c, true }
+	c.Tenant = name
+	if !tenantName.MatchString(name) { return c, false }
+	p, ok := c.Tenants[name]
+	if !ok { return c, false }
+	if p.AllowModules != nil { c.AllowModules = p.AllowModules }
+	if p.AllowCaps != nil { c.AllowCaps = p.AllowCaps }
+	if p.AllowHTTPHosts != nil { c.AllowHTTPHosts = p.AllowHTTPHosts }
+	if p.HTTPRPS > 0 { c.HTTPRPS, c.HTTPBurst = p.HTTPRPS, p.HTTPBurst }
+	if p.CacheMaxMB > 0 { c.CacheMaxMB = p.CacheMaxMB }
+	if name != defaultTenant { c.CacheDir = filepath.Join(c.CacheDir, "tenants", name) }
+	return c, true
+}
+
+// kvFile keeps the default tenant on the legacy path.
+func kvFile(tenant string) string {
+	if tenant == "" || tenant == defaultTenant { return kvPath }
+	return filepath.Join(filepath.Dir(kvPath), "kv", tenant+".json")
+}
+
+// --- Per-tenant http.fetch token buckets ---
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+var (
+	bucketsMu sync.Mutex
+	buckets   = map[string]*bucket{}
+)
+
+// httpAllow takes one token from the tenant's bucket; http_rps 0 = unlimited.
+func httpAllow(cfg Config) bool {
+	if cfg.HTTPRPS <= 0 { return true }
+	burst := float64(cfg.HTTPBurst)
+	if burst < 1 { burst = 1 }
+	bucketsMu.Lock(); defer bucketsMu.Unlock()
+	now := time.Now()
+	b := buckets[cfg.Tenant]
+	if b == nil { b = &bucket{tokens: burst, last: now}; buckets[cfg.Tenant] = b }
+	b.tokens += now.Sub(b.last).Seconds() * float64(cfg.HTTPRPS)
+	if b.tokens > burst { b.tokens = burst }
+	b.last = now
+	if b.tokens < 1 { return false }
+	b.tokens--
+	return true
+}
+
+// enforceCacheQuota evicts least recently written modules (never keep) until
+// dir fits maxMB.
+func enforceCacheQuota(dir string, maxMB int, keep string) {
+	n := pruneDir(dir, maxMB, 0, keep)
+	cacheEvictions.Add(float64(n))
+	cacheEvicted.Add(int64(n))
+}
+
+// pruneDir removes the files in dir (never keep) last written more than
+// maxAge ago, then the oldest ones until dir fits maxMB; 0 turns either
+// check off. Returns how many files went.
+func pruneDir(dir string, maxMB int, maxAge time.Duration, keep string) int {
+	if maxMB <= 0 && maxAge <= 0 { return 0 }
+	type entry struct {
+		path string
+		size int64
+		mod  time.Time
+	}
+	var files []entry
+	var total int64
+	removed := 0
+	cutoff := time.Now().Add(-maxAge)
+	ents, _ := os.ReadDir(dir)
+	for _, e := range ents {
+		if e.IsDir() || filepath.Join(dir, e.Name()) == keep { continue }
+		info, err := e.Info()
+		if err != nil { continue }
+		if maxAge > 0 && info.ModTime().Before(cutoff) {
+			if os.Remove(filepath.Join(dir, e.Name())) == nil { removed++; continue }
+		}
+		files = append(files, entry{filepath.Join(dir, e.Name()), info.Size(), info.ModTime()})
+		total += info.Size()
+	}
+	if maxMB <= 0 { return removed }
+	if st, err := os.Stat(keep); err == nil { total += st.Size() }
+	limit := int64(maxMB) << 20
+	sort.Slice(files, func(i, j int) bool { return files[i].mod.Before(files[j].mod) })
+	for _, f := range files {
+		if total <= limit { break }
+		if os.Remove(f.path) == nil { total -= f.size; removed++ }
+	}
+	return removed
+}
@@ -0,0 +1,23 @@
+package main
+
+import "time"
+
+// SyscallTrace is one syscall as seen by the host during a run.
+type SyscallTrace struct {
+	Seq    int     `json:"seq"`
+	Kind   string  `json:"kind"`
+	ID     string  `json:"id,omitempty"`
+	Result string  `json:"result"`
+	AtMs   int64   `json:"at_ms"` // offset from run start
+	Ms     float64 `json:"ms"`
+}
+
+// traceSyscall appends to the run's timeline, keeping at most max entries
+// and counting the rest so the receipt says how much was cut.
+func (rec *RunRecord) traceSyscall(max int, start time.Time, kind, id, result string, t0 time.Time) {
+	if len(rec.Syscalls) >= max { rec.SyscallsDropped++; return }
+	rec.Syscalls = append(rec.Syscalls, SyscallTrace{
+		Seq: len(rec.Syscalls) + rec.SyscallsDropped, Kind: kind, ID: id, Result: result,
+		AtMs: t0.Sub(start).Milliseconds(), Ms: float64(time.Since(t0).Microseconds()) / 1000,
+	})
+}
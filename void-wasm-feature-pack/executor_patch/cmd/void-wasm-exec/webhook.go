@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- Webhook result sinks ---
+// Each webhooks entry POSTs to its url when a finished run matches: on lists
+// results (deny_* and the like allowed; empty = anything but ok/dryrun),
+// paths stable|canary, modules the usual patterns. The body is template
+// rendered over the run record plus .Node (text/template, with json to quote
+// a value), or the record as JSON without one, so Slack/Discord/Teams
+// incoming webhooks work directly. Delivery is off the run's goroutine with
+// event_retries retries; a hook that keeps failing only costs its counter.
+
+type Webhook struct {
+	Name        string   `yaml:"name"` // metrics label; "" = hook<index>
+	URL         string   `yaml:"url"`
+	Auth        string   `yaml:"auth"`    // Authorization header value, e.g. "Bearer ..."
+	On          []string `yaml:"on"`      // results; empty = failures and denials
+	Paths       []string `yaml:"paths"`   // stable | canary; empty = both
+	Modules     []string `yaml:"modules"` // empty = all
+	Template    string   `yaml:"template"`
+	ContentType string   `yaml:"content_type"` // default application/json
+}
+
+var webhooksTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_webhooks_total", Help: "Webhook deliveries by hook and result"}, []string{"hook", "result"})
+
+var hookTmpls sync.Map // template text -> *template.Template
+
+var hookFuncs = template.FuncMap{
+	"json": func(v any) (string, error) { b, err := json.Marshal(v); return string(b), err },
+}
+
+func webhookTemplate(text string) (*template.Template, error) {
+	if t, ok := hookTmpls.Load(text); ok { return t.(*template.Template), nil }
+	t, err := template.New("webhook").Funcs(hookFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil { return nil, err }
+	hookTmpls.Store(text, t)
+	return t, nil
+}
+
+func (h Webhook) label(i int) string { if h.Name != "" { return h.Name }; return fmt.Sprintf("hook%d", i) }
+
+func (h Webhook) matches(rec *RunRecord) bool {
+	if len(h.On) == 0 {
+		if rec.Result == "ok" || rec.Result == "dryrun" { return false }
+	} else if !allowed(rec.Result, h.On) {
+		return false
+	}
+	if len(h.Paths) > 0 && !allowed(rec.Path, h.Paths) { return false }
+	return len(h.Modules) == 0 || allowed(rec.Module, h.Modules)
+}
+
+// hookData is what templates see: {{.Module}}, {{.Result}}, {{.Node}}...
+type hookData struct {
+	*RunRecord
+	Node string
+}
+
+// fireWebhooks renders matching hooks now (rec is still being finished by
+// the caller) and delivers them in the background.
+func fireWebhooks(cfg Config, rec *RunRecord) {
+	for i, h := range cfg.Webhooks {
+		if !h.matches(rec) { continue }
+		name := h.label(i)
+		var body []byte
+		if h.Template == "" {
+			body, _ = json.Marshal(rec)
+		} else {
+			t, err := webhookTemplate(h.Template)
+			var buf bytes.Buffer
+			if err == nil { err = t.Execute(&buf, hookData{rec, nodeID(cfg)}) }
+			if err != nil { webhooksTotal.WithLabelValues(name, "template_error").Inc(); fmt.Println("[webhook]", name, "template:", err, rec.corr()); continue }
+			body = buf.Bytes()
+		}
+		go func(h Webhook) {
+			result := "ok"
+			if err := deliverWebhook(cfg, h, body); err != nil { result = "error"; fmt.Println("[webhook]", name, rec.ID, "failed:", err, rec.corr()) }
+			webhooksTotal.WithLabelValues(name, result).Inc()
+		}(h)
+	}
+}
+
+func deliverWebhook(cfg Config, h Webhook, body []byte) error {
+	ctype := h.ContentType
+	if ctype == "" { ctype = "application/json" }
+	backoff := 500 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest("POST", h.URL, bytes.NewReader(body))
+		if err != nil { return err }
+		req.Header.Set("content-type", ctype)
+		if h.Auth != "" { req.Header.Set("authorization", h.Auth) }
+		resp, err := relayClient.Do(req)
+		if err == nil {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+			resp.Body.Close()
+			if resp.StatusCode < 300 { return nil }
+			err = fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+			if resp.StatusCode < 500 && resp.StatusCode != 429 { return err }
+		}
+		if attempt >= cfg.EventRetries { return err }
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
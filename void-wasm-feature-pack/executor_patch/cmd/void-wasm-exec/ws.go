@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// --- WebSocket sessions (cap ws, envelope "ws") ---
+// A streaming integration (LiveKit agents, first-node feeds) is a reactor
+// module: no _start, just callbacks. An envelope with "ws":"<name>" and caps
+// ws runs one: the host dials the endpoint declared under ws_endpoints (url,
+// handshake headers such as Authorization, which modules never see,
+// modules/tenants), calls the module's _initialize if it has one, and relays
+// frames until either side closes or the run's timeout ends the session:
+//   guest exports void_ws_alloc(len) -> ptr        buffer for the next frame
+//                 void_ws_on(kind, ptr, len)       0 open, 1 text, 2 binary,
+//                                                  8 close (code and reason)
+//   void host     ws_send(kind, ptr, len) -> i32   0, -1 not open, -2 bad kind
+//                                                  or over max, -3 rate limited
+//                 ws_close(code)                   ends the session
+// Frames in and out are capped at max_frame_kb (ws_max_frame_kb) and
+// max_rate (ws_max_rate) messages a second each way: a larger inbound
+// message closes the session with 1009, inbound frames over the rate are
+// dropped, outbound ones answer -3. Bytes both ways count as net_kb; a
+// ws_send past the budget answers -1 and closes the session with 1008. The
+// session is one syscall.ws entry in the timeline with the endpoint as id
+// and how it ended as result (closed, module_closed, too_large, io_err,
+// budget, error for a trap in a callback, canceled); the run record's ws
+// has the message and byte counts. Stdout syscalls are handled after the
+// session, as for any run.
+
+type WSEndpoint struct {
+	URL        string            `yaml:"url"`     // ws:// or wss://
+	Headers    map[string]string `yaml:"headers"` // handshake headers, e.g. Authorization
+	Modules    []string          `yaml:"modules"` // empty = any allowlisted module
+	Tenants    []string          `yaml:"tenants"` // empty = any tenant
+	MaxFrameKB int               `yaml:"max_frame_kb"` // 0 = ws_max_frame_kb
+	MaxRate    int               `yaml:"max_rate"`     // messages/s each way; 0 = ws_max_rate
+}
+
+// WSReport is a session's share of the run record.
+type WSReport struct {
+	Endpoint string `json:"endpoint"`
+	In       int64  `json:"in"`  // messages delivered to the module
+	Out      int64  `json:"out"` // messages the module sent
+	BytesIn  int64  `json:"bytes_in"`
+	BytesOut int64  `json:"bytes_out"`
+	Dropped  int64  `json:"dropped,omitempty"` // inbound over max_rate
+	Result   string `json:"result"`
+}
+
+// ws_send results
+const (
+	wsSendClosed  = -1
+	wsSendBad     = -2
+	wsSendLimited = -3
+)
+
+var (
+	wsFrames   = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_ws_frames_total", Help: "WebSocket messages relayed by endpoint, direction (in, out) and result"}, []string{"endpoint", "dir", "result"})
+	wsSessions = prometheus.NewGauge(prometheus.GaugeOpts{Name: "void_wasm_ws_sessions", Help: "Open WebSocket sessions"})
+)
+
+type wsSession struct {
+	conn    *wsConn
+	rep     *WSReport
+	max     int
+	in, out wsRate
+	closed  bool // by ws_close, or ws_send over the net budget
+	rec     *RunRecord
+}
+
+// wsRate is a one-second window message counter.
+type wsRate struct {
+	limit int
+	n     int
+	since time.Time
+}
+
+func (r *wsRate) allow() bool {
+	if now := time.Now(); now.Sub(r.since) >= time.Second { r.n, r.since = 0, now }
+	r.n++
+	return r.n <= r.limit
+}
+
+type wsKey struct{}
+
+type wsFrame struct {
+	op  byte
+	msg []byte
+	err error
+}
+
+// wsReact runs a reactor module against endpoint name until the session
+// ends; it takes the place of runStart.
+func wsReact(ctx context.Context, cfg Config, rec *RunRecord, name string, mod api.Module, start time.Time) (err error) {
+	ep, ok := cfg.WS[name]
+	switch {
+	case !allowed("ws", cfg.AllowCaps): return errors.New("ws: denied (caps ws)")
+	case !ok: return fmt.Errorf("ws: unknown endpoint %q", name)
+	case len(ep.Modules) > 0 && !allowed(rec.Module, ep.Modules), len(ep.Tenants) > 0 && !allowed(rec.Tenant, ep.Tenants): return fmt.Errorf("ws: endpoint %s denied for %s", name, rec.Module)
+	case mod.ExportedFunction("_start") != nil: return errors.New("ws: module exports _start; a ws session needs a reactor")
+	}
+	alloc, on := mod.ExportedFunction("void_ws_alloc"), mod.ExportedFunction("void_ws_on")
+	if alloc == nil || on == nil { return errors.New("ws: module must export void_ws_alloc and void_ws_on") }
+	if init := mod.ExportedFunction("_initialize"); init != nil {
+		if _, err := init.Call(ctx); err != nil { return err }
+	}
+	maxKB, rate := ep.MaxFrameKB, ep.MaxRate
+	if maxKB == 0 { maxKB = cfg.WSMaxFrameKB }
+	if rate == 0 { rate = cfg.WSMaxRate }
+	rec.WS = &WSReport{Endpoint: name}
+	t0 := time.Now()
+	defer func() { rec.traceSyscall(cfg.TimelineMax, start, "syscall.ws", name, rec.WS.Result, t0) }()
+	conn, err := wsDial(ctx, ep.URL, ep.Headers)
+	if err != nil { rec.WS.Result = "io_err"; return fmt.Errorf("ws: %s: %w", name, err) }
+	wsSessions.Inc()
+	defer wsSessions.Dec()
+	s := &wsSession{conn: conn, rep: rec.WS, max: maxKB << 10, in: wsRate{limit: rate}, out: wsRate{limit: rate}, rec: rec}
+	// end records how the session ended, unless ws_send already ended it
+	// over the net budget
+	end := func(result string, err error) error {
+		if rec.WS.Result == "budget" { return rec.budget.err() }
+		rec.WS.Result = result
+		return err
+	}
+	ctx = context.WithValue(ctx, wsKey{}, s)
+
+	frames, done := make(chan wsFrame), make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			op, msg, err := conn.read(s.max)
+			select {
+			case frames <- wsFrame{op, msg, err}:
+			case <-done: return
+			}
+			if err != nil || op == wsClose { return }
+		}
+	}()
+	deliver := func(kind byte, msg []byte) error {
+		ptr := uint64(0)
+		if len(msg) > 0 {
+			res, err := alloc.Call(ctx, uint64(len(msg)))
+			if err != nil { return err }
+			if ptr = res[0]; !mod.Memory().Write(uint32(ptr), msg) { return fmt.Errorf("ws: void_ws_alloc(%d) returned %d, out of memory", len(msg), uint32(ptr)) }
+		}
+		_, err := on.Call(ctx, uint64(kind), ptr, uint64(len(msg)))
+		return err
+	}
+	if err := deliver(0, nil); err != nil { conn.close(1011); return end("error", err) }
+	for !s.closed {
+		var f wsFrame
+		select {
+		case <-ctx.Done():
+			conn.close(1001)
+			return end("canceled", ctx.Err())
+		case f = <-frames:
+		}
+		switch {
+		case errors.Is(f.err, errWSTooLarge):
+			conn.close(1009)
+			rec.WS.Result = "too_large"
+			wsFrames.WithLabelValues(name, "in", "too_large").Inc()
+			return deliver(wsClose, []byte{0x03, 0xf1})
+		case f.err != nil:
+			conn.rwc.Close()
+			rec.WS.Result = "io_err"
+			return deliver(wsClose, []byte{0x03, 0xee}) // 1006, closed abnormally
+		case f.op == wsClose:
+			conn.rwc.Close()
+			rec.WS.Result = "closed"
+			return deliver(wsClose, f.msg)
+		}
+		rec.WS.BytesIn += int64(len(f.msg))
+		rec.NetBytes += int64(len(f.msg))
+		if !rec.budget.spend(budgetNet, int64(len(f.msg))) { conn.close(1008); rec.WS.Result = "budget"; return rec.budget.err() }
+		if !s.in.allow() {
+			rec.WS.Dropped++
+			wsFrames.WithLabelValues(name, "in", "rate_limited").Inc()
+			continue
+		}
+		rec.WS.In++
+		wsFrames.WithLabelValues(name, "in", "ok").Inc()
+		if err := deliver(f.op, f.msg); err != nil { conn.close(1011); return end("error", err) }
+	}
+	return end("module_closed", nil)
+}
+
+// exportWS adds ws_send and ws_close to the void host module.
+func exportWS(b wazero.HostModuleBuilder) {
+	i32 := api.ValueTypeI32
+	b.NewFunctionBuilder().
+		WithGoModuleFunction(api.GoModuleFunc(func(ctx context.Context, mod api.Module, stack []uint64) {
+			s, _ := ctx.Value(wsKey{}).(*wsSession)
+			kind, ptr, n := api.DecodeU32(stack[0]), api.DecodeU32(stack[1]), api.DecodeU32(stack[2])
+			if s == nil || s.closed { stack[0] = api.EncodeI32(wsSendClosed); return }
+			res, result := int32(0), "ok"
+			msg, ok := mod.Memory().Read(ptr, n)
+			switch {
+			case !ok || (kind != wsText && kind != wsBinary) || int(n) > s.max: res, result = wsSendBad, "bad"
+			case !s.out.allow(): res, result = wsSendLimited, "rate_limited"
+			case !s.rec.budget.spend(budgetNet, int64(n)):
+				res, result = wsSendClosed, "budget"
+				s.closed, s.rep.Result = true, "budget"
+				s.conn.close(1008)
+			case s.conn.write(byte(kind), msg) != nil: res, result = wsSendClosed, "io_err"
+			default:
+				s.rep.Out++
+				s.rep.BytesOut += int64(n)
+				s.rec.NetBytes += int64(n)
+			}
+			wsFrames.WithLabelValues(s.rep.Endpoint, "out", result).Inc()
+			stack[0] = api.EncodeI32(res)
+		}), []api.ValueType{i32, i32, i32}, []api.ValueType{i32}).
+		Export("ws_send")
+	b.NewFunctionBuilder().
+		WithGoFunction(api.GoFunc(func(ctx context.Context, stack []uint64) {
+			s, _ := ctx.Value(wsKey{}).(*wsSession)
+			if s == nil || s.closed { return }
+			code := int(api.DecodeU32(stack[0]))
+			if code < 1000 || code > 4999 { code = 1000 }
+			s.closed = true
+			s.conn.close(code)
+		}), []api.ValueType{i32}, nil).
+		Export("ws_close")
+}
+
+func validWS(c Config) error {
+	if c.WSMaxFrameKB < 1 || c.WSMaxRate < 1 { return fmt.Errorf("ws_max_frame_kb, ws_max_rate: must be >= 1") }
+	for name, ep := range c.WS {
+		u, err := url.Parse(ep.URL)
+		if !tenantName.MatchString(name) || err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" { return fmt.Errorf("ws_endpoints[%s]: name [a-z0-9_-] and a ws(s) url required", name) }
+		if ep.MaxFrameKB < 0 || ep.MaxRate < 0 { return fmt.Errorf("ws_endpoints[%s]: limits must be >= 0", name) }
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// --- WebSocket client (RFC 6455, for ws.go) ---
+// Just what a host-side session needs: the HTTP/1.1 upgrade over the shared
+// client pool, masked frames out, fragmented messages in up to a size cap,
+// pings answered and the close handshake. No extensions, no subprotocol
+// negotiation beyond a header the endpoint may set.
+
+const (
+	wsText   = 0x1
+	wsBinary = 0x2
+	wsClose  = 0x8
+	wsPing   = 0x9
+	wsPong   = 0xA
+)
+
+var (
+	errWSTooLarge = errors.New("ws: message over max_frame_kb")
+	errWSProtocol = errors.New("ws: protocol error")
+)
+
+type wsConn struct {
+	rwc io.ReadWriteCloser
+	br  *bufio.Reader
+	wmu sync.Mutex
+}
+
+func wsDial(ctx context.Context, raw string, headers map[string]string) (*wsConn, error) {
+	u, err := url.Parse(raw)
+	if err != nil { return nil, err }
+	u.Scheme = map[string]string{"ws": "http", "wss": "https"}[u.Scheme]
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req, _ := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	for k, v := range headers { req.Header.Set(k, v) }
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	resp, err := wsClient.Do(req)
+	if err != nil { return nil, err }
+	sum := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	rwc, ok := resp.Body.(io.ReadWriteCloser)
+	switch {
+	case resp.StatusCode != http.StatusSwitchingProtocols: err = fmt.Errorf("handshake: %s", resp.Status)
+	case resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]): err = errors.New("handshake: bad Sec-WebSocket-Accept")
+	case !ok: err = errors.New("handshake: connection not upgraded")
+	}
+	if err != nil { resp.Body.Close(); return nil, err }
+	return &wsConn{rwc: rwc, br: bufio.NewReader(rwc)}, nil
+}
+
+// read returns the next text or binary message of at most max bytes,
+// answering pings on the way; a close frame returns wsClose with its
+// payload (code and reason) after echoing the code.
+func (c *wsConn) read(max int) (op byte, msg []byte, err error) {
+	for {
+		var h [2]byte
+		if _, err := io.ReadFull(c.br, h[:]); err != nil { return 0, nil, err }
+		fin, code, n := h[0]&0x80 != 0, h[0]&0x0f, uint64(h[1]&0x7f)
+		if h[1]&0x80 != 0 || h[0]&0x70 != 0 { return 0, nil, errWSProtocol } // servers never mask; no extensions
+		if n >= 126 {
+			ext := make([]byte, 2)
+			if n == 127 { ext = make([]byte, 8) }
+			if _, err := io.ReadFull(c.br, ext); err != nil { return 0, nil, err }
+			if len(ext) == 8 { n = binary.BigEndian.Uint64(ext) } else { n = uint64(binary.BigEndian.Uint16(ext)) }
+		}
+		control := code >= wsClose
+		if control && (n > 125 || !fin) { return 0, nil, errWSProtocol }
+		if !control && uint64(len(msg))+n > uint64(max) { return 0, nil, errWSTooLarge }
+		p := make([]byte, n)
+		if _, err := io.ReadFull(c.br, p); err != nil { return 0, nil, err }
+		switch {
+		case code == wsPing: c.write(wsPong, p); continue
+		case code == wsPong: continue
+		case code == wsClose:
+			c.write(wsClose, p[:min(len(p), 2)])
+			return wsClose, p, nil
+		case code == 0 && op == 0, code != 0 && op != 0, code > wsBinary: return 0, nil, errWSProtocol
+		case code != 0: op = code
+		}
+		msg = append(msg, p...)
+		if fin { return op, msg, nil }
+	}
+}
+
+// write sends one masked frame.
+func (c *wsConn) write(op byte, p []byte) error {
+	hdr := []byte{0x80 | op, 0}
+	switch n := len(p); {
+	case n < 126: hdr[1] = byte(n)
+	case n <= 0xffff: hdr[1] = 126; hdr = binary.BigEndian.AppendUint16(hdr, uint16(n))
+	default: hdr[1] = 127; hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+	hdr[1] |= 0x80
+	var mask [4]byte
+	rand.Read(mask[:])
+	frame := append(append(hdr, mask[:]...), p...)
+	body := frame[len(hdr)+4:]
+	for i := range body { body[i] ^= mask[i%4] }
+	c.wmu.Lock(); defer c.wmu.Unlock()
+	_, err := c.rwc.Write(frame)
+	return err
+}
+
+// close sends a close frame with code and drops the connection.
+func (c *wsConn) close(code int) {
+	c.write(wsClose, binary.BigEndian.AppendUint16(nil, uint16(code)))
+	c.rwc.Close()
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// wsFrameBytes is a server frame: unmasked, fin as given.
+func wsFrameBytes(fin bool, op byte, p []byte) []byte {
+	b0 := op
+	if fin { b0 |= 0x80 }
+	h := []byte{b0, 0}
+	switch n := len(p); {
+	case n < 126: h[1] = byte(n)
+	case n <= 0xffff: h[1] = 126; h = binary.BigEndian.AppendUint16(h, uint16(n))
+	default: h[1] = 127; h = binary.BigEndian.AppendUint64(h, uint64(n))
+	}
+	return append(h, p...)
+}
+
+// readClientFrame reads one frame the client sent and unmasks it.
+func readClientFrame(r *bufio.Reader) (byte, []byte, error) {
+	var h [2]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil { return 0, nil, err }
+	if h[1]&0x80 == 0 { return 0, nil, errors.New("client frame not masked") }
+	n := uint64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		var e [2]byte
+		if _, err := io.ReadFull(r, e[:]); err != nil { return 0, nil, err }
+		n = uint64(binary.BigEndian.Uint16(e[:]))
+	case 127:
+		var e [8]byte
+		if _, err := io.ReadFull(r, e[:]); err != nil { return 0, nil, err }
+		n = binary.BigEndian.Uint64(e[:])
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(r, mask[:]); err != nil { return 0, nil, err }
+	p := make([]byte, n)
+	if _, err := io.ReadFull(r, p); err != nil { return 0, nil, err }
+	for i := range p { p[i] ^= mask[i%4] }
+	return h[0] & 0x0f, p, nil
+}
+
+// wsPipe connects a wsConn to a fake server that writes frames (a nil one
+// hangs up) and hands back every frame the client sends.
+func wsPipe(t *testing.T, frames ...[]byte) (*wsConn, <-chan [2][]byte) {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close(); server.Close() })
+	got := make(chan [2][]byte, 16)
+	go func() {
+		br := bufio.NewReader(server)
+		for {
+			op, p, err := readClientFrame(br)
+			if err != nil { return }
+			got <- [2][]byte{{op}, p}
+		}
+	}()
+	go func() {
+		for _, f := range frames {
+			if f == nil { server.Close(); return }
+			if _, err := server.Write(f); err != nil { return }
+		}
+	}()
+	return &wsConn{rwc: client, br: bufio.NewReader(client)}, got
+}
+
+func TestWSConnRead(t *testing.T) {
+	big := bytes.Repeat([]byte("b"), 70000)
+	mid := bytes.Repeat([]byte("m"), 300)
+	c, sent := wsPipe(t,
+		wsFrameBytes(false, wsText, []byte("hel")),
+		wsFrameBytes(true, wsPing, []byte("p1")), // control frames may come between fragments
+		wsFrameBytes(false, 0, []byte("lo ")),
+		wsFrameBytes(true, wsPong, nil),
+		wsFrameBytes(true, 0, []byte("world")),
+		wsFrameBytes(true, wsBinary, mid), // 16-bit length
+		wsFrameBytes(true, wsBinary, big), // 64-bit length
+		wsFrameBytes(true, wsClose, []byte{0x03, 0xe8, 'b', 'y', 'e'}),
+	)
+	op, msg, err := c.read(1 << 20)
+	if err != nil || op != wsText || string(msg) != "hello world" { t.Fatalf("fragmented read = %d %q %v", op, msg, err) }
+	if f := <-sent; f[0][0] != wsPong || string(f[1]) != "p1" { t.Errorf("ping answered with %d %q", f[0][0], f[1]) }
+	if op, msg, err = c.read(1 << 20); err != nil || op != wsBinary || !bytes.Equal(msg, mid) { t.Fatalf("126 length: %d %d %v", op, len(msg), err) }
+	if op, msg, err = c.read(1 << 20); err != nil || op != wsBinary || !bytes.Equal(msg, big) { t.Fatalf("127 length: %d %d %v", op, len(msg), err) }
+	if op, msg, err = c.read(1 << 20); err != nil || op != wsClose || string(msg) != "\x03\xe8bye" { t.Fatalf("close: %d %q %v", op, msg, err) }
+	if f := <-sent; f[0][0] != wsClose || string(f[1]) != "\x03\xe8" { t.Errorf("close echoed as %d %q", f[0][0], f[1]) }
+}
+
+func TestWSConnReadErrors(t *testing.T) {
+	masked := wsFrameBytes(true, wsText, []byte("x"))
+	masked[1] |= 0x80
+	rsv := wsFrameBytes(true, wsText, []byte("x"))
+	rsv[0] |= 0x40
+	for name, tc := range map[string]struct {
+		frames [][]byte
+		want   error
+	}{
+		"oversize frame":         {[][]byte{wsFrameBytes(true, wsText, make([]byte, 101))}, errWSTooLarge},
+		"oversize 64-bit":        {[][]byte{{0x82, 127, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}}, errWSTooLarge},
+		"oversize fragments":     {[][]byte{wsFrameBytes(false, wsText, make([]byte, 60)), wsFrameBytes(true, 0, make([]byte, 60))}, errWSTooLarge},
+		"long control frame":     {[][]byte{wsFrameBytes(true, wsPing, make([]byte, 126))}, errWSProtocol},
+		"fragmented control":     {[][]byte{wsFrameBytes(false, wsPing, nil)}, errWSProtocol},
+		"masked server frame":    {[][]byte{masked}, errWSProtocol},
+		"reserved bits":          {[][]byte{rsv}, errWSProtocol},
+		"orphan continuation":    {[][]byte{wsFrameBytes(true, 0, []byte("x"))}, errWSProtocol},
+		"data frame mid-message": {[][]byte{wsFrameBytes(false, wsText, []byte("a")), wsFrameBytes(true, wsBinary, []byte("b"))}, errWSProtocol},
+		"unknown opcode":         {[][]byte{wsFrameBytes(true, 0x3, nil)}, errWSProtocol},
+		"cut short":              {[][]byte{{0x81, 5, 'a', 'b'}, nil}, io.ErrUnexpectedEOF},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c, _ := wsPipe(t, tc.frames...)
+			if _, _, err := c.read(100); !errors.Is(err, tc.want) { t.Errorf("err = %v, want %v", err, tc.want) }
+		})
+	}
+}
+
+func TestWSConnWrite(t *testing.T) {
+	c, sent := wsPipe(t)
+	for _, n := range []int{0, 125, 126, 0xffff, 0x10000} {
+		p := bytes.Repeat([]byte{byte(n)}, n)
+		if err := c.write(wsBinary, p); err != nil { t.Fatalf("write %d: %v", n, err) }
+		if f := <-sent; f[0][0] != wsBinary || !bytes.Equal(f[1], p) { t.Errorf("%d bytes arrived as %d bytes, op %d", n, len(f[1]), f[0][0]) }
+	}
+}
+
+// wsUpgradeServer answers the upgrade with status and accept(key) as
+// Sec-WebSocket-Accept, then sends a text frame "hi".
+func wsUpgradeServer(t *testing.T, status int, accept func(key string) string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" || r.Header.Get("X-Token") != "t" { w.WriteHeader(400); return }
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil { return }
+		defer conn.Close()
+		fmt.Fprintf(rw, "HTTP/1.1 %d %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n", status, http.StatusText(status), accept(r.Header.Get("Sec-WebSocket-Key")))
+		if status != http.StatusSwitchingProtocols { rw.WriteString("Content-Length: 0\r\n\r\n"); rw.Flush(); return }
+		rw.WriteString("\r\n")
+		rw.Write(wsFrameBytes(true, wsText, []byte("hi")))
+		rw.Flush()
+		io.Copy(io.Discard, conn)
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func TestWSDial(t *testing.T) {
+	good := func(key string) string { sum := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11")); return base64.StdEncoding.EncodeToString(sum[:]) }
+	for name, tc := range map[string]struct {
+		status int
+		accept func(string) string
+		ok     bool
+	}{
+		"ok":         {101, good, true},
+		"bad accept": {101, func(key string) string { return good(key + "x") }, false},
+		"no accept":  {101, func(string) string { return "" }, false},
+		"not 101":    {200, good, false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			u := wsUpgradeServer(t, tc.status, tc.accept)
+			c, err := wsDial(context.Background(), u, map[string]string{"X-Token": "t"})
+			if tc.ok != (err == nil) { t.Fatalf("err = %v", err) }
+			if err != nil { return }
+			defer c.rwc.Close()
+			if op, msg, err := c.read(16); err != nil || op != wsText || string(msg) != "hi" { t.Errorf("first message = %d %q %v", op, msg, err) }
+		})
+	}
+}
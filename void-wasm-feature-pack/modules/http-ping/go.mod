@@ -0,0 +1,7 @@
+module http-ping
+
+go 1.21
+
+require github.com/s0fractal/void/void-wasm-feature-pack/sdk/voidsdk v0.0.0
+
+replace github.com/s0fractal/void/void-wasm-feature-pack/sdk/voidsdk => ../../sdk/voidsdk
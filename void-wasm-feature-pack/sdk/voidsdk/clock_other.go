@@ -0,0 +1,11 @@
+//go:build !wasip1
+
+package voidsdk
+
+import "time"
+
+var processStart = time.Now()
+
+func hostTimeNow() int64 { return time.Now().UnixNano() }
+
+func hostTimeMono() int64 { return int64(time.Since(processStart)) }
@@ -0,0 +1,9 @@
+//go:build wasip1
+
+package voidsdk
+
+//go:wasmimport void time_now
+func hostTimeNow() int64
+
+//go:wasmimport void time_mono
+func hostTimeMono() int64
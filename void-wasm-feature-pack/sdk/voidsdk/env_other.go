@@ -0,0 +1,5 @@
+//go:build !wasip1
+
+package voidsdk
+
+func hostEnvGet(string) ([]byte, int32) { return nil, -1 }
@@ -0,0 +1,18 @@
+//go:build wasip1
+
+package voidsdk
+
+import "unsafe"
+
+//go:wasmimport void env_get
+func voidEnvGet(namePtr, nameLen, ptr, n uint32) int32
+
+var envGetBuf = make([]byte, 4096)
+
+func hostEnvGet(name string) ([]byte, int32) {
+	if name == "" { return nil, -2 }
+	b := []byte(name)
+	n := voidEnvGet(uint32(uintptr(unsafe.Pointer(&b[0]))), uint32(len(b)), uint32(uintptr(unsafe.Pointer(&envGetBuf[0]))), uint32(len(envGetBuf)))
+	if n <= 0 { return nil, n }
+	return envGetBuf[:n], n
+}
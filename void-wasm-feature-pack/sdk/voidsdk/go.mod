@@ -0,0 +1,3 @@
+module github.com/s0fractal/void/void-wasm-feature-pack/sdk/voidsdk
+
+go 1.21
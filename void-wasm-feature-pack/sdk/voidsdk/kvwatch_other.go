@@ -0,0 +1,7 @@
+//go:build !wasip1
+
+package voidsdk
+
+func hostKVWatch(string) int32 { return -1 }
+
+func hostKVNext(int32) ([]byte, int32) { return nil, -1 }
@@ -0,0 +1,25 @@
+//go:build wasip1
+
+package voidsdk
+
+import "unsafe"
+
+//go:wasmimport void kv_watch
+func voidKVWatch(ptr, n uint32) int32
+
+//go:wasmimport void kv_next
+func voidKVNext(ptr, n uint32, timeoutMs int32) int32
+
+var kvNextBuf = make([]byte, 64<<10)
+
+func hostKVWatch(prefix string) int32 {
+	if prefix == "" { return voidKVWatch(0, 0) }
+	b := []byte(prefix)
+	return voidKVWatch(uint32(uintptr(unsafe.Pointer(&b[0]))), uint32(len(b)))
+}
+
+func hostKVNext(timeoutMs int32) ([]byte, int32) {
+	n := voidKVNext(uint32(uintptr(unsafe.Pointer(&kvNextBuf[0]))), uint32(len(kvNextBuf)), timeoutMs)
+	if n <= 0 { return nil, n }
+	return kvNextBuf[:n], n
+}
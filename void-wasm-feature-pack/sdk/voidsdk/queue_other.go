@@ -0,0 +1,5 @@
+//go:build !wasip1
+
+package voidsdk
+
+func hostQueuePop(string, int32) ([]byte, int32) { return nil, -1 }
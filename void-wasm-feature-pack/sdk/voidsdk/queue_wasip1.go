@@ -0,0 +1,17 @@
+//go:build wasip1
+
+package voidsdk
+
+import "unsafe"
+
+//go:wasmimport void queue_pop
+func voidQueuePop(namePtr, nameLen, ptr, n uint32, waitMs int32) int32
+
+var queuePopBuf = make([]byte, 128<<10)
+
+func hostQueuePop(queue string, waitMs int32) ([]byte, int32) {
+	name := []byte(queue)
+	n := voidQueuePop(uint32(uintptr(unsafe.Pointer(&name[0]))), uint32(len(name)), uint32(uintptr(unsafe.Pointer(&queuePopBuf[0]))), uint32(len(queuePopBuf)), waitMs)
+	if n <= 0 { return nil, n }
+	return queuePopBuf[:n], n
+}
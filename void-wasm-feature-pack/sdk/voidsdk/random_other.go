@@ -0,0 +1,7 @@
+//go:build !wasip1
+
+package voidsdk
+
+import "crypto/rand"
+
+func hostRandom(b []byte) int32 { rand.Read(b); return int32(len(b)) }
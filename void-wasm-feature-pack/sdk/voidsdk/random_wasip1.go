@@ -0,0 +1,10 @@
+//go:build wasip1
+
+package voidsdk
+
+import "unsafe"
+
+//go:wasmimport void random
+func voidRandom(ptr, n uint32) int32
+
+func hostRandom(b []byte) int32 { return voidRandom(uint32(uintptr(unsafe.Pointer(&b[0]))), uint32(len(b))) }
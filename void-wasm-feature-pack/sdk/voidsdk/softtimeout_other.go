@@ -0,0 +1,5 @@
+//go:build !wasip1
+
+package voidsdk
+
+func hostSoftTimeout() bool { return false }
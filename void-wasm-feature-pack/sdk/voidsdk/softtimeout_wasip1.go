@@ -0,0 +1,8 @@
+//go:build wasip1
+
+package voidsdk
+
+//go:wasmimport void soft_timeout
+func voidSoftTimeout() int32
+
+func hostSoftTimeout() bool { return voidSoftTimeout() != 0 }
@@ -0,0 +1,477 @@
+// Package voidsdk wraps the void-wasm-exec stdin/stdout protocol for Go and
+// TinyGo guests: inputs arrive as one JSON object on stdin, and every event or
+// syscall is one JSON line on stdout (see docs/SYSCALLS.md).
+//
+// The executor handles syscalls after the module exits and posts the replies
+// (sysret.*) to the relay, so KV.Get and HTTP.Fetch are requests, not calls
+// that return data to the guest.
+package voidsdk
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	ErrEmptyKey  = errors.New("voidsdk: empty kv key")
+	ErrEmptyURL  = errors.New("voidsdk: empty url")
+	ErrEmptyType = errors.New("voidsdk: empty event type")
+	ErrBadName   = errors.New("voidsdk: artifact name must be a relative path")
+)
+
+// Out is where frames go; tests may swap it for a buffer.
+var Out io.Writer = os.Stdout
+
+// In is where inputs are read from.
+var In io.Reader = os.Stdin
+
+// Now is the module's clock; voidtest swaps it for a deterministic one.
+var Now = time.Now
+
+// OutDir is the run's artifact directory; voidtest swaps it for a temp dir.
+var OutDir = "/out"
+
+// Event is a relay event as the executor forwards it.
+type Event struct {
+	Type   string         `json:"type"`
+	Status string         `json:"status,omitempty"`
+	Meta   map[string]any `json:"meta,omitempty"`
+}
+
+// write emits one frame as a single line so frames never interleave.
+func write(frame any) error {
+	b, err := json.Marshal(frame)
+	if err != nil { return err }
+	_, err = Out.Write(append(b, '\n'))
+	return err
+}
+
+// Inputs decodes the envelope inputs into v. Empty stdin leaves v untouched.
+func Inputs(v any) error {
+	b, err := io.ReadAll(In)
+	if err != nil { return err }
+	if len(b) == 0 { return nil }
+	return json.Unmarshal(b, v)
+}
+
+// Emit forwards an event to the relay via syscall.emit.
+func Emit(ev Event) error {
+	if ev.Type == "" { return ErrEmptyType }
+	return write(map[string]any{"type": "syscall.emit", "event": ev})
+}
+
+// Note emits an annotation.note with a message, the most common event.
+func Note(msg string) error {
+	return Emit(Event{Type: "annotation.note", Meta: map[string]any{"msg": msg}})
+}
+
+// ReportError emits module.error so failures show up on the relay. Return
+// normally from main afterwards: a non-zero exit discards the module's output.
+func ReportError(err error) error {
+	if err == nil { return nil }
+	return Emit(Event{Type: "module.error", Status: "error", Meta: map[string]any{"msg": err.Error()}})
+}
+
+// --- KV (needs caps: kv) ---
+type kvAPI struct{}
+
+var KV kvAPI
+
+// Set stores any JSON value under key.
+func (kvAPI) Set(key string, value any) error {
+	if key == "" { return ErrEmptyKey }
+	return write(map[string]any{"type": "syscall.kv.set", "key": key, "value": value})
+}
+
+// SetIf stores value only if key is still at revision rev (0 = only if it
+// does not exist yet), e.g. the Rev of a KVChange; sysret.kv.set reports
+// "conflict" otherwise. Needs kv_backend nats, elsewhere cas_unsupported.
+func (kvAPI) SetIf(key string, value any, rev uint64) error {
+	if key == "" { return ErrEmptyKey }
+	return write(map[string]any{"type": "syscall.kv.set", "key": key, "value": value, "rev": rev})
+}
+
+// Get asks the executor to publish sysret.kv.get for key.
+func (kvAPI) Get(key string) error {
+	if key == "" { return ErrEmptyKey }
+	return write(map[string]any{"type": "syscall.kv.get", "key": key})
+}
+
+// --- HTTP (needs caps: http and an allowed host) ---
+type Request struct {
+	Method  string            `json:"method,omitempty"` // default GET
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+}
+
+type httpAPI struct{}
+
+var HTTP httpAPI
+
+// Fetch requests an HTTP call; the executor publishes sysret.http with the
+// same id. maxKB 0 keeps the executor default.
+func (httpAPI) Fetch(id string, req Request, maxKB int) error {
+	if req.URL == "" { return ErrEmptyURL }
+	frame := map[string]any{"type": "syscall.http.fetch", "id": id, "req": req}
+	if maxKB > 0 { frame["limits"] = map[string]any{"max_kb": maxKB} }
+	return write(frame)
+}
+
+// --- GraphQL (needs caps: graphql and an endpoint in graphql_endpoints) ---
+type GraphQLRequest struct {
+	Endpoint  string         `json:"endpoint"` // name in the executor's graphql_endpoints, not a URL
+	Query     string         `json:"query"`
+	Variables map[string]any `json:"variables,omitempty"`
+	Operation string         `json:"operation,omitempty"`
+}
+
+var ErrEmptyQuery = errors.New("voidsdk: graphql request needs endpoint and query")
+
+type graphqlAPI struct{}
+
+var GraphQL graphqlAPI
+
+// Query sends a GraphQL operation; the executor publishes sysret.graphql
+// with the same id, carrying data and errors.
+func (graphqlAPI) Query(id string, req GraphQLRequest) error {
+	if req.Endpoint == "" || req.Query == "" { return ErrEmptyQuery }
+	frame := map[string]any{"type": "syscall.graphql", "id": id, "endpoint": req.Endpoint, "query": req.Query}
+	if req.Variables != nil { frame["variables"] = req.Variables }
+	if req.Operation != "" { frame["operation"] = req.Operation }
+	return write(frame)
+}
+
+// --- Inference (needs caps: ai and an endpoint in ai_endpoints) ---
+type AIMessage struct {
+	Role    string `json:"role"` // user | assistant
+	Content string `json:"content"`
+}
+
+type AIRequest struct {
+	Endpoint    string      `json:"endpoint"` // name in the executor's ai_endpoints; the host holds url and token
+	Model       string      `json:"model,omitempty"` // one of the endpoint's models; empty = its default
+	System      string      `json:"system,omitempty"`
+	Prompt      string      `json:"prompt,omitempty"` // sent as a last user message
+	Messages    []AIMessage `json:"messages,omitempty"`
+	MaxTokens   int         `json:"max_tokens,omitempty"` // capped by the endpoint
+	Temperature *float64    `json:"temperature,omitempty"`
+}
+
+var ErrEmptyPrompt = errors.New("voidsdk: ai request needs endpoint and prompt or messages")
+
+type aiAPI struct{}
+
+var AI aiAPI
+
+// Infer asks the endpoint's model; the executor publishes sysret.ai.infer
+// with the same id, carrying text, usage and cost.
+func (aiAPI) Infer(id string, req AIRequest) error {
+	if req.Endpoint == "" || (req.Prompt == "" && len(req.Messages) == 0) { return ErrEmptyPrompt }
+	return write(struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+		AIRequest
+	}{"syscall.ai.infer", id, req})
+}
+
+// --- Artifacts ---
+
+// WriteArtifact stores data as OutDir/name (subdirectories allowed). The
+// executor uploads it after the module exits and lists it in the run record.
+func WriteArtifact(name string, data []byte) error {
+	clean := path.Clean(name)
+	if name == "" || path.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") { return ErrBadName }
+	p := filepath.Join(OutDir, filepath.FromSlash(clean))
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil { return err }
+	return os.WriteFile(p, data, 0o644)
+}
+
+// --- Soft deadline ---
+
+// SoftTimeoutCheck, when set, answers SoftTimeout instead of the executor;
+// voidtest installs one.
+var SoftTimeoutCheck func() bool
+
+// SoftTimeout reports whether the run is past its soft deadline (the
+// executor's soft_timeout_pct): flush state, emit what you have and return
+// before the hard kill. Poll it between units of work. Outside the executor
+// it is false unless SoftTimeoutCheck is set.
+func SoftTimeout() bool {
+	if SoftTimeoutCheck != nil { return SoftTimeoutCheck() }
+	return hostSoftTimeout()
+}
+
+// --- KV watch (needs caps: kv) ---
+
+// KVChange is one change to a watched key, made by another run.
+type KVChange struct {
+	Watch     int32  `json:"watch"` // id returned by KV.Watch
+	Key       string `json:"key"`
+	Value     any    `json:"value,omitempty"`
+	Run       string `json:"run,omitempty"`       // run that wrote it
+	Rev       uint64 `json:"rev,omitempty"`       // key revision (kv_backend nats), for KV.SetIf
+	Dropped   int    `json:"dropped,omitempty"`   // changes lost to a full queue before this one
+	Truncated bool   `json:"truncated,omitempty"` // value too large, left out
+}
+
+var (
+	ErrWatchDenied = errors.New("voidsdk: kv watch denied (needs caps: kv, inside void-wasm-exec)")
+	ErrWatchLimit  = errors.New("voidsdk: too many kv watches")
+	ErrWatchPrefix = errors.New("voidsdk: kv watch prefix too long")
+	ErrNoWatch     = errors.New("voidsdk: KV.Next without KV.Watch")
+)
+
+// KVWatcher, when set, serves KV.Watch and KV.Next instead of the executor;
+// voidtest installs one.
+var KVWatcher interface {
+	Watch(prefix string) (int32, error)
+	Next(timeout time.Duration) (KVChange, bool, error)
+}
+
+// Watch subscribes the running module to changes of keys under prefix ("" =
+// every key) in its tenant's KV and returns the watch id. Watches end with
+// the run.
+func (kvAPI) Watch(prefix string) (int32, error) {
+	if KVWatcher != nil { return KVWatcher.Watch(prefix) }
+	switch id := hostKVWatch(prefix); id {
+	case -2: return 0, ErrWatchLimit
+	case -3: return 0, ErrWatchPrefix
+	default:
+		if id < 0 { return 0, ErrWatchDenied }
+		return id, nil
+	}
+}
+
+// Next blocks up to timeout for the next change on any of the module's
+// watches; ok is false when none arrived (or the run is ending).
+func (kvAPI) Next(timeout time.Duration) (c KVChange, ok bool, err error) {
+	if KVWatcher != nil { return KVWatcher.Next(timeout) }
+	b, n := hostKVNext(int32(timeout / time.Millisecond))
+	if n < 0 { return c, false, ErrNoWatch }
+	if n == 0 { return c, false, nil }
+	return c, true, json.Unmarshal(b, &c)
+}
+
+// --- Queues (needs caps: queue and the executor's queue_backend) ---
+
+// QueueMsg is a message taken from a queue; Ack it with its Receipt.
+type QueueMsg struct {
+	Queue    string          `json:"queue"`
+	ID       string          `json:"msg_id"`
+	Receipt  string          `json:"receipt"`
+	Body     json.RawMessage `json:"body"`
+	Run      string          `json:"run,omitempty"`    // run that pushed it
+	Module   string          `json:"module,omitempty"` // and its module
+	Pushed   time.Time       `json:"pushed"`
+	Receives int             `json:"receives"` // deliveries so far, this one included
+}
+
+var (
+	ErrEmptyQueue  = errors.New("voidsdk: empty queue name")
+	ErrQueueDenied = errors.New("voidsdk: queue denied (needs caps: queue and a queue backend, inside void-wasm-exec)")
+	ErrQueueName   = errors.New("voidsdk: bad queue name")
+	ErrQueueBuffer = errors.New("voidsdk: queue message larger than the pop buffer")
+	ErrQueueIO     = errors.New("voidsdk: queue store error")
+)
+
+// QueuePopper, when set, serves Queue.Pop instead of the executor; voidtest
+// installs one.
+var QueuePopper interface {
+	Pop(queue string, wait time.Duration) (QueueMsg, bool, error)
+}
+
+type queueAPI struct{}
+
+var Queue queueAPI
+
+// Push asks the executor to append body to queue once the module exits;
+// sysret.queue.push with the same id carries the msg_id (or "full",
+// "too_large").
+func (queueAPI) Push(id, queue string, body any) error {
+	if queue == "" { return ErrEmptyQueue }
+	frame := map[string]any{"type": "syscall.queue.push", "queue": queue, "body": body}
+	if id != "" { frame["id"] = id }
+	return write(frame)
+}
+
+// Pop takes the next message of queue, waiting up to wait for one; ok is
+// false when the queue stayed empty. The message is hidden from other runs
+// for the queue's visibility timeout: Ack it once handled, or it comes back.
+func (queueAPI) Pop(queue string, wait time.Duration) (m QueueMsg, ok bool, err error) {
+	if queue == "" { return m, false, ErrEmptyQueue }
+	if QueuePopper != nil { return QueuePopper.Pop(queue, wait) }
+	b, n := hostQueuePop(queue, int32(wait/time.Millisecond))
+	switch n {
+	case 0: return m, false, nil
+	case -2: return m, false, ErrQueueName
+	case -3: return m, false, ErrQueueBuffer
+	case -4: return m, false, ErrQueueIO
+	}
+	if n < 0 { return m, false, ErrQueueDenied }
+	return m, true, json.Unmarshal(b, &m)
+}
+
+// Ack removes m from its queue once the module exits; sysret.queue.ack
+// reports "stale" when its visibility ran out and it went to another run.
+func (queueAPI) Ack(m QueueMsg) error {
+	if m.Queue == "" { return ErrEmptyQueue }
+	return write(map[string]any{"type": "syscall.queue.ack", "queue": m.Queue, "msg_id": m.ID, "receipt": m.Receipt})
+}
+
+// --- Host variables (needs caps: env) ---
+
+var (
+	ErrEnvDenied     = errors.New("voidsdk: env denied (needs caps: env, inside void-wasm-exec)")
+	ErrEnvUndeclared = errors.New("voidsdk: host variable not declared for this module")
+	ErrEnvTooLong    = errors.New("voidsdk: host variable longer than the buffer")
+)
+
+// EnvReader, when set, serves Env.Get instead of the executor; voidtest
+// installs one.
+var EnvReader interface {
+	Get(name string) (string, error)
+}
+
+type envAPI struct{}
+
+var Env envAPI
+
+// Get reads a host variable the executor declares in host_vars (region,
+// node, feature flags). It is a call, answered while the module runs.
+func (envAPI) Get(name string) (string, error) {
+	if EnvReader != nil { return EnvReader.Get(name) }
+	b, n := hostEnvGet(name)
+	switch {
+	case n == -2: return "", ErrEnvUndeclared
+	case n == -3: return "", ErrEnvTooLong
+	case n < 0: return "", ErrEnvDenied
+	}
+	return string(b), nil
+}
+
+// --- Clocks ---
+
+// ClockSource, when set, answers TimeNow and stopwatches instead of the
+// executor; voidtest installs one.
+var ClockSource interface {
+	Now() time.Time
+	Mono() time.Duration // since the run started
+}
+
+// TimeNow is the executor's void.time_now: the host clock with clock real,
+// a fake one that moves 1ms per read with clock deterministic (the default),
+// so replays see the same times.
+func TimeNow() time.Time {
+	if ClockSource != nil { return ClockSource.Now() }
+	return time.Unix(0, hostTimeNow()).UTC()
+}
+
+func mono() time.Duration {
+	if ClockSource != nil { return ClockSource.Mono() }
+	return time.Duration(hostTimeMono())
+}
+
+// Stopwatch measures durations on the executor's monotonic clock
+// (void.time_mono), under the same clock policy as TimeNow.
+type Stopwatch struct{ start time.Duration }
+
+func StartStopwatch() Stopwatch { return Stopwatch{mono()} }
+
+// Elapsed is the time since StartStopwatch.
+func (s Stopwatch) Elapsed() time.Duration { return mono() - s.start }
+
+// --- Random bytes ---
+
+var ErrRandomQuota = errors.New("voidsdk: random quota exhausted (random_max_kb)")
+
+// RandomSource, when set, serves Random instead of the executor; voidtest
+// installs one.
+var RandomSource io.Reader
+
+// Random fills b from the executor's void.random: host entropy with clock
+// real, a stream seeded by the module's sha256, entry and inputs with clock
+// deterministic. Deterministic bytes are reproducible by anyone who knows the
+// module and the envelope, so they are not fit for keys or nonces. Draws count
+// against the run's random_max_kb.
+func Random(b []byte) error {
+	if RandomSource != nil { _, err := io.ReadFull(RandomSource, b); return err }
+	for len(b) > 0 {
+		n := min(len(b), 64<<10)
+		if r := hostRandom(b[:n]); r < 0 { return ErrRandomQuota }
+		b = b[n:]
+	}
+	return nil
+}
+
+// --- WebSocket sessions (reactor modules; needs caps: ws and "ws" in the envelope) ---
+// The executor holds the connection and calls the module's exports; in TinyGo:
+//
+//	//export void_ws_alloc
+//	func wsAlloc(n uint32) uint32 { return voidsdk.WSAlloc(n) }
+//
+//	//export void_ws_on
+//	func wsOn(kind, ptr, n uint32) { handle(kind, voidsdk.WSFrame(n)) }
+
+// void_ws_on kinds
+const (
+	WSOpen   = 0
+	WSText   = 1
+	WSBinary = 2
+	WSClosed = 8 // frame is the close code (2 bytes, big endian) and reason
+)
+
+var (
+	ErrWSClosed      = errors.New("voidsdk: ws session not open")
+	ErrWSBad         = errors.New("voidsdk: ws message over max_frame_kb")
+	ErrWSRateLimited = errors.New("voidsdk: ws send over max_rate")
+)
+
+// WSSender, when set, serves WS instead of the executor; voidtest installs
+// one.
+var WSSender interface {
+	Send(kind int, b []byte) error
+	Close(code int)
+}
+
+type wsAPI struct{}
+
+var WS wsAPI
+
+var wsBuf []byte
+
+// WSAlloc backs void_ws_alloc: one buffer, reused for every frame.
+func WSAlloc(n uint32) uint32 {
+	if uint32(cap(wsBuf)) < n { wsBuf = make([]byte, n) }
+	wsBuf = wsBuf[:n]
+	return bufAddr(wsBuf)
+}
+
+// WSFrame is the frame void_ws_on was called with; valid until it returns.
+func WSFrame(n uint32) []byte { return wsBuf[:min(int(n), len(wsBuf))] }
+
+func (wsAPI) Send(text string) error { return wsSend(WSText, []byte(text)) }
+
+func (wsAPI) SendBinary(b []byte) error { return wsSend(WSBinary, b) }
+
+// Close ends the session with code (1000 = normal).
+func (wsAPI) Close(code int) {
+	if WSSender != nil { WSSender.Close(code); return }
+	hostWSClose(int32(code))
+}
+
+func wsSend(kind int, b []byte) error {
+	if WSSender != nil { return WSSender.Send(kind, b) }
+	switch hostWSSend(int32(kind), b) {
+	case 0: return nil
+	case -2: return ErrWSBad
+	case -3: return ErrWSRateLimited
+	}
+	return ErrWSClosed
+}
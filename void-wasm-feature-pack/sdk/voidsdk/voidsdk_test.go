@@ -0,0 +1,173 @@
+package voidsdk
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// frames records every Write, so a test can tell one frame from one line.
+type frames [][]byte
+
+func (f *frames) Write(b []byte) (int, error) { *f = append(*f, append([]byte(nil), b...)); return len(b), nil }
+
+// capture points Out at a fresh recorder for the rest of the test.
+func capture(t *testing.T) *frames {
+	t.Helper()
+	f := &frames{}
+	old := Out
+	Out = f
+	t.Cleanup(func() { Out = old })
+	return f
+}
+
+func TestWireFormat(t *testing.T) {
+	zero := 0.0
+	for _, tc := range []struct {
+		name string
+		call func() error
+		want string
+	}{
+		{"emit", func() error { return Emit(Event{Type: "x", Status: "ok", Meta: map[string]any{"a": 1}}) }, `{"event":{"type":"x","status":"ok","meta":{"a":1}},"type":"syscall.emit"}`},
+		{"note", func() error { return Note("hi") }, `{"event":{"type":"annotation.note","meta":{"msg":"hi"}},"type":"syscall.emit"}`},
+		{"report error", func() error { return ReportError(errors.New("boom")) }, `{"event":{"type":"module.error","status":"error","meta":{"msg":"boom"}},"type":"syscall.emit"}`},
+		{"kv set", func() error { return KV.Set("k", []int{1}) }, `{"key":"k","type":"syscall.kv.set","value":[1]}`},
+		{"kv set null", func() error { return KV.Set("k", nil) }, `{"key":"k","type":"syscall.kv.set","value":null}`},
+		{"kv set if absent", func() error { return KV.SetIf("k", "v", 0) }, `{"key":"k","rev":0,"type":"syscall.kv.set","value":"v"}`},
+		{"kv get", func() error { return KV.Get("k") }, `{"key":"k","type":"syscall.kv.get"}`},
+		{"http fetch", func() error { return HTTP.Fetch("p1", Request{URL: "http://h/x"}, 0) }, `{"id":"p1","req":{"url":"http://h/x"},"type":"syscall.http.fetch"}`},
+		{"http fetch full", func() error {
+			return HTTP.Fetch("p2", Request{Method: "POST", URL: "http://h/x", Headers: map[string]string{"a": "b"}, Body: "{}"}, 8)
+		}, `{"id":"p2","limits":{"max_kb":8},"req":{"method":"POST","url":"http://h/x","headers":{"a":"b"},"body":"{}"},"type":"syscall.http.fetch"}`},
+		{"graphql", func() error { return GraphQL.Query("q1", GraphQLRequest{Endpoint: "cat", Query: "{a}"}) }, `{"endpoint":"cat","id":"q1","query":"{a}","type":"syscall.graphql"}`},
+		{"graphql full", func() error {
+			return GraphQL.Query("q2", GraphQLRequest{Endpoint: "cat", Query: "{a}", Variables: map[string]any{"n": 1}, Operation: "Op"})
+		}, `{"endpoint":"cat","id":"q2","operation":"Op","query":"{a}","type":"syscall.graphql","variables":{"n":1}}`},
+		{"ai", func() error { return AI.Infer("a1", AIRequest{Endpoint: "as", Prompt: "hi"}) }, `{"type":"syscall.ai.infer","id":"a1","endpoint":"as","prompt":"hi"}`},
+		{"ai full", func() error {
+			return AI.Infer("a2", AIRequest{Endpoint: "as", Model: "m", System: "s", Messages: []AIMessage{{"user", "x"}}, MaxTokens: 5, Temperature: &zero})
+		}, `{"type":"syscall.ai.infer","id":"a2","endpoint":"as","model":"m","system":"s","messages":[{"role":"user","content":"x"}],"max_tokens":5,"temperature":0}`},
+		{"queue push", func() error { return Queue.Push("p1", "jobs", map[string]any{"n": 1}) }, `{"body":{"n":1},"id":"p1","queue":"jobs","type":"syscall.queue.push"}`},
+		{"queue push without id", func() error { return Queue.Push("", "jobs", 1) }, `{"body":1,"queue":"jobs","type":"syscall.queue.push"}`},
+		{"queue ack", func() error { return Queue.Ack(QueueMsg{Queue: "jobs", ID: "7", Receipt: "7.1", Body: []byte(`{}`)}) }, `{"msg_id":"7","queue":"jobs","receipt":"7.1","type":"syscall.queue.ack"}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out := capture(t)
+			if err := tc.call(); err != nil { t.Fatal(err) }
+			if len(*out) != 1 { t.Fatalf("%d writes, want one per frame", len(*out)) }
+			if got := string((*out)[0]); got != tc.want+"\n" { t.Errorf("frame\n got %s\nwant %s", got, tc.want) }
+		})
+	}
+}
+
+func TestWireFormatErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		call func() error
+		want error
+	}{
+		{"emit without type", func() error { return Emit(Event{Meta: map[string]any{"a": 1}}) }, ErrEmptyType},
+		{"report nil", func() error { return ReportError(nil) }, nil},
+		{"kv set", func() error { return KV.Set("", 1) }, ErrEmptyKey},
+		{"kv set if", func() error { return KV.SetIf("", 1, 3) }, ErrEmptyKey},
+		{"kv get", func() error { return KV.Get("") }, ErrEmptyKey},
+		{"http without url", func() error { return HTTP.Fetch("p", Request{Method: "GET"}, 0) }, ErrEmptyURL},
+		{"graphql without query", func() error { return GraphQL.Query("q", GraphQLRequest{Endpoint: "cat"}) }, ErrEmptyQuery},
+		{"graphql without endpoint", func() error { return GraphQL.Query("q", GraphQLRequest{Query: "{a}"}) }, ErrEmptyQuery},
+		{"ai without prompt", func() error { return AI.Infer("a", AIRequest{Endpoint: "as", System: "s"}) }, ErrEmptyPrompt},
+		{"ai without endpoint", func() error { return AI.Infer("a", AIRequest{Prompt: "hi"}) }, ErrEmptyPrompt},
+		{"queue push", func() error { return Queue.Push("p", "", 1) }, ErrEmptyQueue},
+		{"queue ack", func() error { return Queue.Ack(QueueMsg{ID: "7"}) }, ErrEmptyQueue},
+		{"queue pop", func() error { _, _, err := Queue.Pop("", time.Second); return err }, ErrEmptyQueue},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out := capture(t)
+			if err := tc.call(); !errors.Is(err, tc.want) { t.Errorf("err = %v, want %v", err, tc.want) }
+			if len(*out) != 0 { t.Errorf("wrote %q", *out) }
+		})
+	}
+	out := capture(t)
+	if err := KV.Set("k", func() {}); err == nil || len(*out) != 0 { t.Errorf("unencodable value: %v, wrote %q", err, *out) }
+}
+
+func TestInputs(t *testing.T) {
+	old := In
+	defer func() { In = old }()
+	var v struct {
+		Name string `json:"name"`
+		N    int    `json:"n"`
+	}
+	In = strings.NewReader(`{"name":"x","n":2,"extra":true}`)
+	if err := Inputs(&v); err != nil || v.Name != "x" || v.N != 2 { t.Fatalf("inputs = %+v, %v", v, err) }
+	In = strings.NewReader("")
+	if err := Inputs(&v); err != nil || v.Name != "x" { t.Errorf("empty stdin: %+v, %v", v, err) }
+	In = strings.NewReader(`{"name":`)
+	if err := Inputs(&v); err == nil { t.Error("truncated inputs decoded") }
+	In = strings.NewReader(`{"n":"two"}`)
+	if err := Inputs(&v); err == nil { t.Error("mistyped inputs decoded") }
+}
+
+func TestWriteArtifact(t *testing.T) {
+	old := OutDir
+	defer func() { OutDir = old }()
+	OutDir = t.TempDir()
+	for _, name := range []string{"a.txt", "report/b.csv", "./c/./d.txt", "e/../f.txt"} {
+		if err := WriteArtifact(name, []byte(name)); err != nil { t.Errorf("%q: %v", name, err) }
+	}
+	for name, want := range map[string]string{"a.txt": "a.txt", "report/b.csv": "report/b.csv", "c/d.txt": "./c/./d.txt", "f.txt": "e/../f.txt"} {
+		if b, err := os.ReadFile(filepath.Join(OutDir, filepath.FromSlash(name))); err != nil || string(b) != want { t.Errorf("%s = %q, %v", name, b, err) }
+	}
+	for _, name := range []string{"", ".", "..", "../x", "a/../../x", "/etc/passwd"} {
+		if err := WriteArtifact(name, nil); !errors.Is(err, ErrBadName) { t.Errorf("%q: err = %v", name, err) }
+	}
+	if ents, _ := os.ReadDir(filepath.Dir(OutDir)); len(ents) != 1 { t.Errorf("wrote outside OutDir: %d entries beside it", len(ents)-1) }
+}
+
+// Outside void-wasm-exec the host functions answer as if the caps were
+// withdrawn, unless a harness hook is installed.
+func TestOutsideExecutor(t *testing.T) {
+	if _, err := Env.Get("region"); !errors.Is(err, ErrEnvDenied) { t.Errorf("Env.Get: %v", err) }
+	if _, err := KV.Watch("cfg/"); !errors.Is(err, ErrWatchDenied) { t.Errorf("KV.Watch: %v", err) }
+	if _, _, err := KV.Next(time.Millisecond); !errors.Is(err, ErrNoWatch) { t.Errorf("KV.Next: %v", err) }
+	if _, _, err := Queue.Pop("jobs", 0); !errors.Is(err, ErrQueueDenied) { t.Errorf("Queue.Pop: %v", err) }
+	if err := WS.Send("x"); !errors.Is(err, ErrWSClosed) { t.Errorf("WS.Send: %v", err) }
+	if SoftTimeout() { t.Error("soft timeout outside the executor") }
+
+	b := make([]byte, 100<<10)
+	if err := Random(b); err != nil || bytes.Equal(b[90<<10:], make([]byte, 10<<10)) { t.Errorf("Random: %v, tail left zero", err) }
+
+	if n := WSAlloc(8); n != 0 || len(WSFrame(8)) != 8 || len(WSFrame(100)) != 8 { t.Errorf("WSAlloc/WSFrame: %d %d", n, len(WSFrame(100))) }
+	WSAlloc(4)
+	if len(WSFrame(8)) != 4 { t.Error("WSFrame past the frame") }
+}
+
+type fakeClock struct {
+	now  time.Time
+	mono time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Mono() time.Duration { return c.mono }
+
+func TestHooks(t *testing.T) {
+	defer func() { SoftTimeoutCheck, ClockSource, RandomSource = nil, nil, nil }()
+	SoftTimeoutCheck = func() bool { return true }
+	if !SoftTimeout() { t.Error("SoftTimeoutCheck ignored") }
+
+	c := &fakeClock{now: time.Unix(1700000000, 0), mono: time.Second}
+	ClockSource = c
+	sw := StartStopwatch()
+	c.mono += 250 * time.Millisecond
+	if !TimeNow().Equal(c.now) || sw.Elapsed() != 250*time.Millisecond { t.Errorf("clock: %v %v", TimeNow(), sw.Elapsed()) }
+
+	RandomSource = strings.NewReader("abc")
+	b := make([]byte, 3)
+	if err := Random(b); err != nil || string(b) != "abc" { t.Errorf("Random = %q, %v", b, err) }
+	if err := Random(b); !errors.Is(err, io.EOF) { t.Errorf("drained source: %v", err) }
+}
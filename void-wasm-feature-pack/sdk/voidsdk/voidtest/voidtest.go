@@ -0,0 +1,452 @@
+// Package voidtest is a fake void-wasm-exec for module unit tests: it runs
+// module code in-process with voidsdk wired to buffers, then handles the
+// emitted frames the way the executor does (in-memory KV and queues,
+// scripted http.fetch responses, captured events and sysret replies).
+//
+//	h := voidtest.New()
+//	h.HTTP["GET http://relay:8787/healthz"] = voidtest.Response{Status: 200}
+//	if err := h.Run(map[string]any{"name": "x"}, run); err != nil { t.Fatal(err) }
+//	if len(h.EventsOf("annotation.note")) != 1 { t.Fatal("no note") }
+package voidtest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/s0fractal/void/void-wasm-feature-pack/sdk/voidsdk"
+)
+
+// Response is a scripted reply for one "METHOD URL".
+type Response struct {
+	Status  int
+	Body    string
+	Headers map[string]string
+}
+
+// GraphQLResponse is a scripted reply for one endpoint.
+type GraphQLResponse struct {
+	Status int // 0 = 200
+	Data   any
+	Errors []any
+}
+
+// AIResponse is a scripted reply for one endpoint.
+type AIResponse struct {
+	Status  int // 0 = 200
+	Text    string
+	Finish  string // "" = stop
+	In, Out int    // usage; In 0 = prompt bytes / 4
+	Error   string // provider message, answers upstream_error
+}
+
+// WSMessage is one message a reactor sent with voidsdk.WS.
+type WSMessage struct {
+	Kind int // voidsdk.WSText or voidsdk.WSBinary
+	Data []byte
+}
+
+// Syscall is one handled syscall with the executor's result label.
+type Syscall struct {
+	Kind   string
+	ID     string
+	Result string
+}
+
+// Clock is a manual clock installed as voidsdk.Now during Run.
+type Clock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *Clock) Now() time.Time { c.mu.Lock(); defer c.mu.Unlock(); return c.t }
+
+func (c *Clock) Advance(d time.Duration) { c.mu.Lock(); c.t = c.t.Add(d); c.mu.Unlock() }
+
+// runClock answers voidsdk.TimeNow and stopwatches from the Clock, with the
+// monotonic time counted from the start of Run.
+type runClock struct {
+	c     *Clock
+	start time.Time
+}
+
+func (r runClock) Now() time.Time { return r.c.Now() }
+
+func (r runClock) Mono() time.Duration { return r.c.Now().Sub(r.start) }
+
+type Harness struct {
+	Caps     []string            // granted caps; default emit, kv, http
+	KV       map[string]any      // shared across runs, pre-seed freely
+	HTTP     map[string]Response // "GET https://host/path" -> response
+	GraphQL  map[string]GraphQLResponse // endpoint name -> response; needs cap graphql
+	AI       map[string]AIResponse      // endpoint name -> response; needs cap ai
+	WSSent   []WSMessage                // sent with voidsdk.WS; needs cap ws
+	WSClosed int                        // code of voidsdk.WS.Close, 0 = still open
+	Clock    *Clock
+	Events   []map[string]any // events the executor would post (emit + plain lines)
+	Replies  []map[string]any // sysret.* frames
+	Syscalls []Syscall
+	Raw      []string // every non-empty stdout line
+
+	Artifacts map[string][]byte // files written with voidsdk.WriteArtifact, by name
+
+	SoftTimeout func() bool // what voidsdk.SoftTimeout reports during Run; nil = never
+
+	Changes []Change // other runs' KV writes, handed to voidsdk.KV.Next in order during Run
+	watches []string
+
+	Revs map[string]uint64 // non-nil mirrors kv_backend nats: per-key revisions and KV.SetIf
+	rev  uint64
+
+	Queues   map[string][]voidsdk.QueueMsg // pending messages by queue, shared across runs; needs cap queue
+	QueueMax int                           // push answers full at this depth; 0 = 10000
+	inFlight map[string]voidsdk.QueueMsg   // popped, by receipt
+	msgSeq   int
+
+	Vars map[string]string // host variables voidsdk.Env.Get may read (host_vars granted to the module); needs cap env
+
+	Random io.Reader // what voidsdk.Random draws from; nil = a fixed-seed stream, like clock deterministic
+}
+
+// Change is a KV write by another run, as a watching module sees it.
+type Change struct {
+	Key   string
+	Value any
+	Run   string
+	Rev   uint64
+}
+
+func New() *Harness {
+	return &Harness{
+		Caps:  []string{"emit", "kv", "http"},
+		KV:    map[string]any{},
+		Queues: map[string][]voidsdk.QueueMsg{},
+		HTTP:  map[string]Response{},
+		GraphQL: map[string]GraphQLResponse{},
+		AI:      map[string]AIResponse{},
+		Clock: &Clock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+}
+
+// Run executes fn as the module body with inputs on stdin, then processes
+// its output. It is not safe to run harnesses in parallel (voidsdk globals).
+func (h *Harness) Run(inputs any, fn func()) error {
+	in, err := json.Marshal(inputs)
+	if err != nil { return err }
+	var out bytes.Buffer
+	dir, err := os.MkdirTemp("", "voidtest-out-")
+	if err != nil { return err }
+	defer os.RemoveAll(dir)
+	oldIn, oldOut, oldNow, oldDir := voidsdk.In, voidsdk.Out, voidsdk.Now, voidsdk.OutDir
+	voidsdk.In, voidsdk.Out, voidsdk.Now, voidsdk.OutDir = bytes.NewReader(in), &out, h.Clock.Now, dir
+	defer func() { voidsdk.In, voidsdk.Out, voidsdk.Now, voidsdk.OutDir = oldIn, oldOut, oldNow, oldDir }()
+	oldSoft, oldWatch, oldPop, oldEnv, oldClock, oldRand, oldWS := voidsdk.SoftTimeoutCheck, voidsdk.KVWatcher, voidsdk.QueuePopper, voidsdk.EnvReader, voidsdk.ClockSource, voidsdk.RandomSource, voidsdk.WSSender
+	voidsdk.SoftTimeoutCheck, voidsdk.KVWatcher, voidsdk.QueuePopper, voidsdk.EnvReader, voidsdk.ClockSource, voidsdk.WSSender = h.softTimeout, kvWatcher{h}, queuePopper{h}, envReader{h}, runClock{h.Clock, h.Clock.Now()}, wsSender{h}
+	if h.Random == nil { h.Random = rand.New(rand.NewSource(1)) }
+	voidsdk.RandomSource = randomSource{h}
+	defer func() { voidsdk.SoftTimeoutCheck, voidsdk.KVWatcher, voidsdk.QueuePopper, voidsdk.EnvReader, voidsdk.ClockSource, voidsdk.RandomSource, voidsdk.WSSender = oldSoft, oldWatch, oldPop, oldEnv, oldClock, oldRand, oldWS }()
+	h.watches = nil
+	fn()
+	h.process(&out)
+	h.requeue()
+	return h.collect(dir)
+}
+
+func (h *Harness) softTimeout() bool { return h.SoftTimeout != nil && h.SoftTimeout() }
+
+// kvWatcher mirrors void.kv_watch / void.kv_next: watches need caps kv
+// (at most 8) and Next takes the first queued Change under a watched prefix,
+// skipping the rest; with none left it advances the clock by timeout.
+type kvWatcher struct{ h *Harness }
+
+func (w kvWatcher) Watch(prefix string) (int32, error) {
+	h := w.h
+	result, err := "ok", error(nil)
+	switch {
+	case !h.can("kv"): result, err = "denied", voidsdk.ErrWatchDenied
+	case len(h.watches) >= 8: result, err = "limit", voidsdk.ErrWatchLimit
+	case len(prefix) > 256: result, err = "bad_prefix", voidsdk.ErrWatchPrefix
+	}
+	h.Syscalls = append(h.Syscalls, Syscall{Kind: "syscall.kv.watch", ID: prefix, Result: result})
+	if err != nil { return 0, err }
+	h.watches = append(h.watches, prefix)
+	return int32(len(h.watches)), nil
+}
+
+func (w kvWatcher) Next(timeout time.Duration) (voidsdk.KVChange, bool, error) {
+	h := w.h
+	if len(h.watches) == 0 { return voidsdk.KVChange{}, false, voidsdk.ErrNoWatch }
+	for len(h.Changes) > 0 {
+		c := h.Changes[0]
+		h.Changes = h.Changes[1:]
+		for i, p := range h.watches {
+			if strings.HasPrefix(c.Key, p) { return voidsdk.KVChange{Watch: int32(i + 1), Key: c.Key, Value: c.Value, Run: c.Run, Rev: c.Rev}, true, nil }
+		}
+	}
+	h.Clock.Advance(timeout)
+	return voidsdk.KVChange{}, false, nil
+}
+
+// randomSource reads h.Random and records each draw as syscall.random.
+// wsSender mirrors void.ws_send / void.ws_close: cap ws and an open
+// session; no size or rate limits.
+type wsSender struct{ h *Harness }
+
+func (w wsSender) Send(kind int, b []byte) error {
+	switch {
+	case !w.h.can("ws") || w.h.WSClosed != 0: return voidsdk.ErrWSClosed
+	case kind != voidsdk.WSText && kind != voidsdk.WSBinary: return voidsdk.ErrWSBad
+	}
+	w.h.WSSent = append(w.h.WSSent, WSMessage{kind, append([]byte(nil), b...)})
+	return nil
+}
+
+func (w wsSender) Close(code int) {
+	if code < 1000 || code > 4999 { code = 1000 }
+	if w.h.WSClosed == 0 { w.h.WSClosed = code }
+}
+
+type randomSource struct{ h *Harness }
+
+func (r randomSource) Read(b []byte) (int, error) {
+	n, err := r.h.Random.Read(b)
+	result := "ok"
+	if err != nil { result = "error" }
+	r.h.Syscalls = append(r.h.Syscalls, Syscall{Kind: "syscall.random", ID: strconv.Itoa(len(b)), Result: result})
+	return n, err
+}
+
+// envReader mirrors void.env_get over h.Vars.
+type envReader struct{ h *Harness }
+
+func (e envReader) Get(name string) (string, error) {
+	h := e.h
+	v, ok := h.Vars[name]
+	result, err := "ok", error(nil)
+	switch {
+	case !h.can("env"): result, err = "denied", voidsdk.ErrEnvDenied
+	case !ok: result, err = "undeclared", voidsdk.ErrEnvUndeclared
+	case len(v) > 4096: result, err = "too_small", voidsdk.ErrEnvTooLong
+	}
+	h.Syscalls = append(h.Syscalls, Syscall{Kind: "syscall.env.get", ID: name, Result: result})
+	if err != nil { return "", err }
+	return v, nil
+}
+
+var queueName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// queuePopper mirrors void.queue_pop: it takes the head of the queue, or
+// advances the clock by wait when it is empty. Messages popped and not acked
+// go back to the front of their queue when Run ends, as if their visibility
+// had run out.
+type queuePopper struct{ h *Harness }
+
+func (q queuePopper) Pop(queue string, wait time.Duration) (voidsdk.QueueMsg, bool, error) {
+	h := q.h
+	result, err := "ok", error(nil)
+	switch {
+	case !h.can("queue"): result, err = "denied", voidsdk.ErrQueueDenied
+	case !queueName.MatchString(queue): result, err = "bad_queue", voidsdk.ErrQueueName
+	case len(h.Queues[queue]) == 0: result = "empty"
+	}
+	h.Syscalls = append(h.Syscalls, Syscall{Kind: "syscall.queue.pop", ID: queue, Result: result})
+	if err != nil { return voidsdk.QueueMsg{}, false, err }
+	if result == "empty" { h.Clock.Advance(wait); return voidsdk.QueueMsg{}, false, nil }
+	m := h.Queues[queue][0]
+	h.Queues[queue] = h.Queues[queue][1:]
+	m.Receives++
+	m.Receipt = m.ID + "." + strconv.Itoa(m.Receives)
+	if h.inFlight == nil { h.inFlight = map[string]voidsdk.QueueMsg{} }
+	h.inFlight[m.Receipt] = m
+	return m, true, nil
+}
+
+func (h *Harness) requeue() {
+	for r, m := range h.inFlight {
+		m.Receipt = ""
+		h.Queues[m.Queue] = append([]voidsdk.QueueMsg{m}, h.Queues[m.Queue]...)
+		delete(h.inFlight, r)
+	}
+}
+
+// collect reads what the module left in its /out.
+func (h *Harness) collect(dir string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() { return err }
+		b, err := os.ReadFile(p)
+		if err != nil { return err }
+		name, _ := filepath.Rel(dir, p)
+		if h.Artifacts == nil { h.Artifacts = map[string][]byte{} }
+		h.Artifacts[filepath.ToSlash(name)] = b
+		return nil
+	})
+}
+
+func (h *Harness) process(out *bytes.Buffer) {
+	sc := bufio.NewScanner(out)
+	sc.Buffer(make([]byte, 64*1024), 4<<20)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" { continue }
+		h.Raw = append(h.Raw, line)
+		var ev map[string]any
+		if json.Unmarshal([]byte(line), &ev) != nil { continue }
+		t, _ := ev["type"].(string)
+		if !strings.HasPrefix(t, "syscall.") { h.Events = append(h.Events, ev); continue }
+		id, _ := ev["id"].(string)
+		h.Syscalls = append(h.Syscalls, Syscall{Kind: t, ID: id, Result: h.syscall(t, ev)})
+	}
+}
+
+func (h *Harness) can(c string) bool {
+	for _, x := range h.Caps { if x == c { return true } }
+	return false
+}
+
+// syscall mirrors handleSyscall in void-wasm-exec, result labels included.
+func (h *Harness) syscall(kind string, p map[string]any) string {
+	switch kind {
+	case "syscall.emit":
+		ev, ok := p["event"].(map[string]any)
+		if !ok { return "bad_event" }
+		h.Events = append(h.Events, ev)
+	case "syscall.kv.set":
+		if !h.can("kv") { return "denied" }
+		key, _ := p["key"].(string)
+		if h.Revs == nil {
+			if _, cas := p["rev"]; cas {
+				h.Replies = append(h.Replies, map[string]any{"type": "sysret.kv.set", "ok": false, "key": p["key"], "error": "cas_unsupported"})
+				return "cas_unsupported"
+			}
+		}
+		if key == "" { return "bad_key" }
+		if h.Revs != nil {
+			if want, cas := p["rev"].(float64); cas && uint64(want) != h.Revs[key] {
+				h.Replies = append(h.Replies, map[string]any{"type": "sysret.kv.set", "ok": false, "key": key, "error": "conflict", "rev": h.Revs[key]})
+				return "conflict"
+			}
+			h.rev++
+			h.Revs[key] = h.rev
+		}
+		h.KV[key] = p["value"]
+		reply := map[string]any{"type": "sysret.kv.set", "ok": true, "key": key}
+		if h.Revs != nil { reply["rev"] = h.Revs[key] }
+		h.Replies = append(h.Replies, reply)
+	case "syscall.kv.get":
+		if !h.can("kv") { return "denied" }
+		key, _ := p["key"].(string)
+		val := h.KV[key]
+		reply := map[string]any{"type": "sysret.kv.get", "ok": val != nil, "key": key, "value": val}
+		if h.Revs != nil { reply["rev"] = h.Revs[key] }
+		h.Replies = append(h.Replies, reply)
+	case "syscall.http.fetch":
+		if !h.can("http") { return "denied" }
+		req, _ := p["req"].(map[string]any)
+		id, _ := p["id"].(string)
+		method, _ := req["method"].(string)
+		if method == "" { method = "GET" }
+		url, _ := req["url"].(string)
+		if url == "" { return "bad_url" }
+		resp, ok := h.HTTP[method+" "+url]
+		if !ok { return "io_err" }
+		h.Replies = append(h.Replies, map[string]any{
+			"type": "sysret.http", "id": id, "status": resp.Status,
+			"kb": len(resp.Body) / 1024, "headers": map[string]any{"content-type": resp.Headers["content-type"]},
+		})
+	case "syscall.graphql":
+		if !h.can("graphql") { return "denied" }
+		id, _ := p["id"].(string)
+		name, _ := p["endpoint"].(string)
+		resp, ok := h.GraphQL[name]
+		if !ok { return "bad_endpoint" }
+		if q, _ := p["query"].(string); q == "" { return "bad_query" }
+		if resp.Status == 0 { resp.Status = 200 }
+		reply := map[string]any{"type": "sysret.graphql", "id": id, "endpoint": name, "ok": resp.Status < 300 && len(resp.Errors) == 0, "status": resp.Status, "data": resp.Data}
+		if len(resp.Errors) > 0 { reply["errors"] = resp.Errors }
+		h.Replies = append(h.Replies, reply)
+	case "syscall.ai.infer":
+		if !h.can("ai") { return "denied" }
+		id, _ := p["id"].(string)
+		name, _ := p["endpoint"].(string)
+		resp, ok := h.AI[name]
+		if !ok { return "bad_endpoint" }
+		prompt, _ := p["prompt"].(string)
+		msgs, _ := p["messages"].([]any)
+		if prompt == "" && len(msgs) == 0 { return "bad_prompt" }
+		if resp.Status == 0 { resp.Status = 200 }
+		if resp.Finish == "" { resp.Finish = "stop" }
+		if resp.In == 0 { b, _ := json.Marshal([]any{p["system"], prompt, msgs}); resp.In = len(b) / 4 }
+		model, _ := p["model"].(string)
+		reply := map[string]any{"type": "sysret.ai.infer", "id": id, "endpoint": name, "model": model, "ok": resp.Status < 300, "status": resp.Status, "usage": map[string]any{"in": resp.In, "out": resp.Out}}
+		if resp.Status >= 300 {
+			reply["error"], reply["message"] = "upstream_error", resp.Error
+			h.Replies = append(h.Replies, reply)
+			return "upstream_error"
+		}
+		reply["text"], reply["finish"] = resp.Text, resp.Finish
+		h.Replies = append(h.Replies, reply)
+	case "syscall.queue.push":
+		if !h.can("queue") { return "denied" }
+		queue, _ := p["queue"].(string)
+		if !queueName.MatchString(queue) { return "bad_queue" }
+		reply := map[string]any{"type": "sysret.queue.push", "queue": queue}
+		if id, ok := p["id"].(string); ok { reply["id"] = id }
+		max := h.QueueMax
+		if max == 0 { max = 10000 }
+		depth := len(h.Queues[queue])
+		for _, m := range h.inFlight {
+			if m.Queue == queue { depth++ }
+		}
+		if depth >= max {
+			reply["ok"], reply["error"] = false, "full"
+			h.Replies = append(h.Replies, reply)
+			return "full"
+		}
+		body, _ := json.Marshal(p["body"])
+		h.msgSeq++
+		m := voidsdk.QueueMsg{Queue: queue, ID: strconv.Itoa(h.msgSeq), Body: body, Pushed: h.Clock.Now()}
+		if h.Queues == nil { h.Queues = map[string][]voidsdk.QueueMsg{} }
+		h.Queues[queue] = append(h.Queues[queue], m)
+		reply["ok"], reply["msg_id"] = true, m.ID
+		h.Replies = append(h.Replies, reply)
+	case "syscall.queue.ack":
+		if !h.can("queue") { return "denied" }
+		queue, _ := p["queue"].(string)
+		if !queueName.MatchString(queue) { return "bad_queue" }
+		id, _ := p["msg_id"].(string)
+		r, _ := p["receipt"].(string)
+		reply := map[string]any{"type": "sysret.queue.ack", "queue": queue, "msg_id": id, "ok": true}
+		if m, ok := h.inFlight[r]; !ok || m.ID != id || m.Queue != queue {
+			reply["ok"], reply["error"] = false, "stale"
+			h.Replies = append(h.Replies, reply)
+			return "stale"
+		}
+		delete(h.inFlight, r)
+		h.Replies = append(h.Replies, reply)
+	default:
+		return "unknown"
+	}
+	return "ok"
+}
+
+// EventsOf returns captured events of one type.
+func (h *Harness) EventsOf(typ string) []map[string]any {
+	var out []map[string]any
+	for _, ev := range h.Events {
+		if t, _ := ev["type"].(string); t == typ { out = append(out, ev) }
+	}
+	return out
+}
+
+// Reset clears captured output but keeps KV, scripts and the clock.
+func (h *Harness) Reset() { h.Events, h.Replies, h.Syscalls, h.Raw, h.Artifacts = nil, nil, nil, nil, nil }
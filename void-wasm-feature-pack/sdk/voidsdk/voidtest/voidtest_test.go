@@ -0,0 +1,251 @@
+package voidtest_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/s0fractal/void/void-wasm-feature-pack/sdk/voidsdk"
+	"github.com/s0fractal/void/void-wasm-feature-pack/sdk/voidsdk/voidtest"
+)
+
+// results lists the result labels of h's syscalls in order.
+func results(h *voidtest.Harness) []string {
+	var out []string
+	for _, s := range h.Syscalls { out = append(out, s.Kind+"="+s.Result) }
+	return out
+}
+
+func TestRun(t *testing.T) {
+	h := voidtest.New()
+	body := strings.Repeat("x", 2048)
+	h.HTTP["GET http://relay:8787/healthz"] = voidtest.Response{Status: 200, Body: body, Headers: map[string]string{"content-type": "text/plain"}}
+	err := h.Run(map[string]any{"name": "void"}, func() {
+		var in struct{ Name string `json:"name"` }
+		if err := voidsdk.Inputs(&in); err != nil { t.Error(err) }
+		voidsdk.Note("hello " + in.Name)
+		voidsdk.KV.Set("greeting", in.Name)
+		voidsdk.KV.Get("greeting")
+		voidsdk.KV.Get("missing")
+		voidsdk.HTTP.Fetch("ping", voidsdk.Request{URL: "http://relay:8787/healthz"}, 0)
+		voidsdk.HTTP.Fetch("nowhere", voidsdk.Request{Method: "POST", URL: "http://relay:8787/healthz"}, 0)
+		fmt.Fprintln(voidsdk.Out, `{"type":"plain.event","meta":{"n":1}}`)
+		fmt.Fprintln(voidsdk.Out, "debug: not a frame")
+		fmt.Fprintln(voidsdk.Out, `{"type":"syscall.emit","event":"not an object"}`)
+		fmt.Fprintln(voidsdk.Out, `{"type":"syscall.nope"}`)
+	})
+	if err != nil { t.Fatal(err) }
+	if voidsdk.Out != os.Stdout || voidsdk.In != os.Stdin { t.Error("Run left voidsdk wired to its buffers") }
+
+	if len(h.Raw) != 10 { t.Errorf("%d raw lines", len(h.Raw)) }
+	if n := h.EventsOf("annotation.note"); len(n) != 1 || n[0]["meta"].(map[string]any)["msg"] != "hello void" { t.Errorf("notes %v", n) }
+	if p := h.EventsOf("plain.event"); len(p) != 1 { t.Errorf("plain events %v", p) }
+	if want := []string{"syscall.emit=ok", "syscall.kv.set=ok", "syscall.kv.get=ok", "syscall.kv.get=ok", "syscall.http.fetch=ok", "syscall.http.fetch=io_err", "syscall.emit=bad_event", "syscall.nope=unknown"}; !reflect.DeepEqual(results(h), want) { t.Errorf("syscalls\n got %v\nwant %v", results(h), want) }
+	if h.Syscalls[4].ID != "ping" { t.Errorf("fetch id %q", h.Syscalls[4].ID) }
+	want := []map[string]any{
+		{"type": "sysret.kv.set", "ok": true, "key": "greeting"},
+		{"type": "sysret.kv.get", "ok": true, "key": "greeting", "value": "void"},
+		{"type": "sysret.kv.get", "ok": false, "key": "missing", "value": nil},
+		{"type": "sysret.http", "id": "ping", "status": 200, "kb": 2, "headers": map[string]any{"content-type": "text/plain"}},
+	}
+	if !reflect.DeepEqual(h.Replies, want) { t.Errorf("replies\n got %v\nwant %v", h.Replies, want) }
+
+	// KV outlives Reset and the run; captured output does not
+	h.Reset()
+	if err := h.Run(nil, func() { voidsdk.KV.Get("greeting") }); err != nil { t.Fatal(err) }
+	if len(h.Events) != 0 || len(h.Replies) != 1 || h.Replies[0]["value"] != "void" { t.Errorf("second run: events %v, replies %v", h.Events, h.Replies) }
+	if err := h.Run(func() {}, func() {}); err == nil { t.Error("unencodable inputs accepted") }
+}
+
+func TestCapsDenied(t *testing.T) {
+	h := voidtest.New()
+	h.Caps = []string{"emit"}
+	h.Vars = map[string]string{"region": "eu"}
+	h.Queues["jobs"] = []voidsdk.QueueMsg{{Queue: "jobs", ID: "1"}}
+	var errs []error
+	h.Run(nil, func() {
+		voidsdk.KV.Set("k", 1)
+		voidsdk.KV.Get("k")
+		voidsdk.HTTP.Fetch("p", voidsdk.Request{URL: "http://x/"}, 0)
+		voidsdk.GraphQL.Query("q", voidsdk.GraphQLRequest{Endpoint: "e", Query: "{a}"})
+		voidsdk.AI.Infer("a", voidsdk.AIRequest{Endpoint: "e", Prompt: "hi"})
+		voidsdk.Queue.Push("", "jobs", 1)
+		_, err := voidsdk.KV.Watch("")
+		errs = append(errs, err)
+		_, err = voidsdk.Env.Get("region")
+		errs = append(errs, err)
+		_, _, err = voidsdk.Queue.Pop("jobs", 0)
+		errs = append(errs, err)
+		errs = append(errs, voidsdk.WS.Send("x"))
+		voidsdk.Note("still here")
+	})
+	if want := []error{voidsdk.ErrWatchDenied, voidsdk.ErrEnvDenied, voidsdk.ErrQueueDenied, voidsdk.ErrWSClosed}; !reflect.DeepEqual(errs, want) { t.Errorf("host calls: %v", errs) }
+	for _, s := range h.Syscalls {
+		if (s.Kind == "syscall.emit") != (s.Result == "ok") || s.Kind != "syscall.emit" && s.Result != "denied" { t.Errorf("%s = %s", s.Kind, s.Result) }
+	}
+	if len(h.Syscalls) != 10 || len(h.Replies) != 0 || len(h.Queues["jobs"]) != 1 || len(h.KV) != 0 { t.Errorf("denied calls had effects: %v, %v, %v", results(h), h.Replies, h.Queues) }
+}
+
+func TestKVRevisions(t *testing.T) {
+	h := voidtest.New()
+	h.Run(nil, func() { voidsdk.KV.SetIf("lock", "me", 0) })
+	if got := results(h); !reflect.DeepEqual(got, []string{"syscall.kv.set=cas_unsupported"}) || h.Replies[0]["error"] != "cas_unsupported" || len(h.KV) != 0 { t.Errorf("without revisions: %v %v", got, h.Replies) }
+
+	h = voidtest.New()
+	h.Revs = map[string]uint64{}
+	h.Run(nil, func() {
+		voidsdk.KV.SetIf("lock", "a", 0) // rev 1
+		voidsdk.KV.SetIf("lock", "b", 0) // taken
+		voidsdk.KV.SetIf("lock", "c", 1) // rev 2
+		voidsdk.KV.Set("other", 1)       // rev 3
+		voidsdk.KV.Get("lock")
+	})
+	if got, want := results(h), []string{"syscall.kv.set=ok", "syscall.kv.set=conflict", "syscall.kv.set=ok", "syscall.kv.set=ok", "syscall.kv.get=ok"}; !reflect.DeepEqual(got, want) { t.Errorf("results %v", got) }
+	if h.Replies[1]["rev"] != uint64(1) || h.Replies[2]["rev"] != uint64(2) || h.Replies[3]["rev"] != uint64(3) || h.Replies[4]["value"] != "c" || h.Replies[4]["rev"] != uint64(2) { t.Errorf("replies %v", h.Replies) }
+}
+
+func TestQueues(t *testing.T) {
+	h := voidtest.New()
+	h.Caps = append(h.Caps, "queue")
+	h.QueueMax = 3
+	h.Run(nil, func() {
+		for i := 0; i < 4; i++ { voidsdk.Queue.Push(fmt.Sprint("p", i), "jobs", i) }
+		voidsdk.Queue.Push("", "Bad Name", 1)
+	})
+	if got, want := results(h), []string{"syscall.queue.push=ok", "syscall.queue.push=ok", "syscall.queue.push=ok", "syscall.queue.push=full", "syscall.queue.push=bad_queue"}; !reflect.DeepEqual(got, want) { t.Fatalf("push %v", got) }
+	if h.Replies[0]["msg_id"] != "1" || h.Replies[0]["id"] != "p0" || h.Replies[3]["error"] != "full" { t.Errorf("push replies %v", h.Replies) }
+
+	h.Reset()
+	var first, second voidsdk.QueueMsg
+	h.Run(nil, func() {
+		first, _, _ = voidsdk.Queue.Pop("jobs", time.Second)
+		second, _, _ = voidsdk.Queue.Pop("jobs", time.Second)
+		voidsdk.Queue.Ack(first)
+		voidsdk.Queue.Ack(voidsdk.QueueMsg{Queue: "jobs", ID: second.ID, Receipt: "forged"})
+		// second is neither acked nor lost: it goes back to the front after the run
+	})
+	if string(first.Body) != "0" || first.Receives != 1 || first.Receipt == "" || !first.Pushed.Equal(h.Clock.Now()) { t.Errorf("first pop %+v", first) }
+	if got, want := results(h), []string{"syscall.queue.pop=ok", "syscall.queue.pop=ok", "syscall.queue.ack=ok", "syscall.queue.ack=stale"}; !reflect.DeepEqual(got, want) { t.Errorf("pop %v", got) }
+	if q := h.Queues["jobs"]; len(q) != 2 || q[0].ID != second.ID || q[0].Receipt != "" || q[1].ID != "3" { t.Errorf("queue after the run %+v", q) }
+
+	h.Reset()
+	var again voidsdk.QueueMsg
+	h.Run(nil, func() {
+		again, _, _ = voidsdk.Queue.Pop("jobs", 0)
+		voidsdk.Queue.Ack(again)
+		voidsdk.Queue.Pop("jobs", 0)
+		voidsdk.Queue.Pop("jobs", 0)
+		voidsdk.Queue.Pop("jobs", 5*time.Second)
+	})
+	if again.ID != second.ID || again.Receives != 2 { t.Errorf("redelivery %+v", again) }
+	// host calls are answered while the module runs, stdout frames after it exits
+	if got, want := results(h), []string{"syscall.queue.pop=ok", "syscall.queue.pop=ok", "syscall.queue.pop=empty", "syscall.queue.pop=empty", "syscall.queue.ack=ok"}; !reflect.DeepEqual(got, want) { t.Errorf("drained queue: %v", got) }
+	if want := time.Date(2024, 1, 1, 0, 0, 5, 0, time.UTC); !h.Clock.Now().Equal(want) { t.Errorf("empty pop moved the clock to %v", h.Clock.Now()) }
+}
+
+func TestWatchAndClock(t *testing.T) {
+	h := voidtest.New()
+	h.Changes = []voidtest.Change{{Key: "other/x", Value: 1}, {Key: "cfg/a", Value: "on", Run: "r2", Rev: 4}}
+	start := h.Clock.Now()
+	var got []voidsdk.KVChange
+	var elapsed time.Duration
+	var now time.Time
+	h.Run(nil, func() {
+		if _, _, err := voidsdk.KV.Next(time.Second); err != voidsdk.ErrNoWatch { t.Errorf("Next before Watch: %v", err) }
+		id, err := voidsdk.KV.Watch("cfg/")
+		if err != nil || id != 1 { t.Errorf("watch %d, %v", id, err) }
+		sw := voidsdk.StartStopwatch()
+		for {
+			c, ok, _ := voidsdk.KV.Next(2 * time.Second)
+			if !ok { break }
+			got = append(got, c)
+		}
+		elapsed, now = sw.Elapsed(), voidsdk.TimeNow()
+	})
+	if len(got) != 1 || got[0] != (voidsdk.KVChange{Watch: 1, Key: "cfg/a", Value: "on", Run: "r2", Rev: 4}) { t.Errorf("changes %+v", got) }
+	if elapsed != 2*time.Second || !now.Equal(start.Add(2*time.Second)) { t.Errorf("clock: elapsed %v, now %v", elapsed, now) }
+
+	h.Reset()
+	h.Run(nil, func() {
+		for i := 0; i < 9; i++ { voidsdk.KV.Watch(fmt.Sprint("p", i)) }
+		voidsdk.KV.Watch(strings.Repeat("k", 257))
+	})
+	if got := results(h); got[7] != "syscall.kv.watch=ok" || got[8] != "syscall.kv.watch=limit" || len(got) != 10 { t.Errorf("watch limits %v", got) }
+}
+
+func TestEnvRandomWS(t *testing.T) {
+	draw := func() ([]byte, *voidtest.Harness) {
+		h := voidtest.New()
+		h.Caps = append(h.Caps, "env", "ws")
+		h.Vars = map[string]string{"region": "eu", "big": strings.Repeat("v", 4097)}
+		b := make([]byte, 32)
+		h.Run(nil, func() {
+			if v, err := voidsdk.Env.Get("region"); err != nil || v != "eu" { t.Errorf("region = %q, %v", v, err) }
+			if _, err := voidsdk.Env.Get("secret"); err != voidsdk.ErrEnvUndeclared { t.Errorf("undeclared: %v", err) }
+			if _, err := voidsdk.Env.Get("big"); err != voidsdk.ErrEnvTooLong { t.Errorf("too long: %v", err) }
+			if err := voidsdk.Random(b); err != nil { t.Error(err) }
+			voidsdk.WS.Send("hi")
+			voidsdk.WS.SendBinary([]byte{1, 2})
+			voidsdk.WS.Close(4001)
+			if err := voidsdk.WS.Send("late"); err != voidsdk.ErrWSClosed { t.Errorf("send after close: %v", err) }
+		})
+		return b, h
+	}
+	a, h := draw()
+	b, _ := draw()
+	if !bytes.Equal(a, b) || bytes.Equal(a, make([]byte, 32)) { t.Errorf("random draws %x, %x: want equal and non-zero", a, b) }
+	if got, want := results(h), []string{"syscall.env.get=ok", "syscall.env.get=undeclared", "syscall.env.get=too_small", "syscall.random=ok"}; !reflect.DeepEqual(got, want) { t.Errorf("syscalls %v", got) }
+	if want := []voidtest.WSMessage{{Kind: voidsdk.WSText, Data: []byte("hi")}, {Kind: voidsdk.WSBinary, Data: []byte{1, 2}}}; !reflect.DeepEqual(h.WSSent, want) || h.WSClosed != 4001 { t.Errorf("ws %v closed %d", h.WSSent, h.WSClosed) }
+
+	h = voidtest.New()
+	h.Random = strings.NewReader("0123")
+	var r []byte
+	h.Run(nil, func() { r = make([]byte, 4); voidsdk.Random(r) })
+	if string(r) != "0123" { t.Errorf("scripted random %q", r) }
+}
+
+func TestGraphQLAndAI(t *testing.T) {
+	h := voidtest.New()
+	h.Caps = append(h.Caps, "graphql", "ai")
+	h.GraphQL["catalog"] = voidtest.GraphQLResponse{Data: map[string]any{"n": 1}}
+	h.GraphQL["broken"] = voidtest.GraphQLResponse{Status: 200, Errors: []any{"boom"}}
+	h.AI["assistant"] = voidtest.AIResponse{Text: "ok", Out: 3}
+	h.AI["down"] = voidtest.AIResponse{Status: 503, Error: "overloaded"}
+	h.Run(nil, func() {
+		voidsdk.GraphQL.Query("q1", voidsdk.GraphQLRequest{Endpoint: "catalog", Query: "{n}"})
+		voidsdk.GraphQL.Query("q2", voidsdk.GraphQLRequest{Endpoint: "broken", Query: "{n}"})
+		voidsdk.GraphQL.Query("q3", voidsdk.GraphQLRequest{Endpoint: "unknown", Query: "{n}"})
+		voidsdk.AI.Infer("a1", voidsdk.AIRequest{Endpoint: "assistant", Model: "m", Prompt: "12345678"})
+		voidsdk.AI.Infer("a2", voidsdk.AIRequest{Endpoint: "down", Prompt: "x"})
+		voidsdk.AI.Infer("a3", voidsdk.AIRequest{Endpoint: "unknown", Prompt: "x"})
+	})
+	if got, want := results(h), []string{"syscall.graphql=ok", "syscall.graphql=ok", "syscall.graphql=bad_endpoint", "syscall.ai.infer=ok", "syscall.ai.infer=upstream_error", "syscall.ai.infer=bad_endpoint"}; !reflect.DeepEqual(got, want) { t.Errorf("results %v", got) }
+	if len(h.Replies) != 4 { t.Fatalf("replies %v", h.Replies) }
+	if r := h.Replies[0]; r["ok"] != true || r["status"] != 200 || !reflect.DeepEqual(r["data"], map[string]any{"n": 1}) { t.Errorf("graphql reply %v", r) }
+	if r := h.Replies[1]; r["ok"] != false || !reflect.DeepEqual(r["errors"], []any{"boom"}) { t.Errorf("graphql errors %v", r) }
+	if r := h.Replies[2]; r["text"] != "ok" || r["finish"] != "stop" || r["model"] != "m" || r["id"] != "a1" || r["usage"].(map[string]any)["out"] != 3 { t.Errorf("ai reply %v", r) }
+	if r := h.Replies[3]; r["ok"] != false || r["error"] != "upstream_error" || r["message"] != "overloaded" { t.Errorf("ai error %v", r) }
+}
+
+func TestArtifactsAndSoftTimeout(t *testing.T) {
+	h := voidtest.New()
+	polls := 0
+	h.SoftTimeout = func() bool { polls++; return polls > 2 }
+	done := 0
+	h.Run(nil, func() {
+		for !voidsdk.SoftTimeout() { done++ }
+		voidsdk.WriteArtifact("report/summary.csv", []byte("a,b\n"))
+		voidsdk.WriteArtifact("done.txt", []byte(fmt.Sprint(done)))
+	})
+	if done != 2 { t.Errorf("worked %d units before the soft deadline", done) }
+	if !reflect.DeepEqual(h.Artifacts, map[string][]byte{"report/summary.csv": []byte("a,b\n"), "done.txt": []byte("2")}) { t.Errorf("artifacts %q", h.Artifacts) }
+	if voidsdk.OutDir != "/out" || voidsdk.SoftTimeout() { t.Error("Run left its /out or soft deadline installed") }
+
+	h.Reset()
+	h.Run(nil, func() {})
+	if len(h.Artifacts) != 0 { t.Errorf("artifacts survived Reset: %q", h.Artifacts) }
+}
@@ -0,0 +1,9 @@
+//go:build !wasip1
+
+package voidsdk
+
+func bufAddr([]byte) uint32 { return 0 }
+
+func hostWSSend(int32, []byte) int32 { return -1 }
+
+func hostWSClose(int32) {}
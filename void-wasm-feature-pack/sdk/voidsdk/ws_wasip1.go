@@ -0,0 +1,20 @@
+//go:build wasip1
+
+package voidsdk
+
+import "unsafe"
+
+//go:wasmimport void ws_send
+func voidWSSend(kind, ptr, n uint32) int32
+
+//go:wasmimport void ws_close
+func voidWSClose(code uint32)
+
+func bufAddr(b []byte) uint32 {
+	if len(b) == 0 { return 0 }
+	return uint32(uintptr(unsafe.Pointer(&b[0])))
+}
+
+func hostWSSend(kind int32, b []byte) int32 { return voidWSSend(uint32(kind), bufAddr(b), uint32(len(b))) }
+
+func hostWSClose(code int32) { voidWSClose(uint32(code)) }
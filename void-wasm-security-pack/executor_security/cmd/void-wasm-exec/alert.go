@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Policy violation alerts (ALERT_SINK): the rollout SLO allows zero
+// violations, so cosign failures, OPA denies for allowlisted modules and
+// quarantine events on the relay bus page directly instead of waiting for a
+// Prometheus rule to evaluate.
+//   pagerduty  Events API v2, ALERT_KEY = routing key
+//   opsgenie   Alert API, ALERT_KEY = GenieKey (ALERT_URL for the EU instance)
+//   webhook    POST ALERT_URL {type: policy.violation, kind, module, detail,
+//              node, ts}, ALERT_KEY as a bearer token when set
+// The dedup key is void:<kind>:<module>; the same one is sent at most once
+// per ALERT_DEDUP_S (300). Delivery gets 3 tries, off the run path.
+
+var alertsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_policy_alerts_total", Help: "Policy violation alerts by kind and result"}, []string{"kind", "result"})
+
+var (
+	alertMu   sync.Mutex
+	alertLast = map[string]time.Time{} // dedup key -> last sent
+)
+
+var alertEndpoints = map[string]string{
+	"pagerduty": "https://events.pagerduty.com/v2/enqueue",
+	"opsgenie":  "https://api.opsgenie.com/v2/alerts",
+}
+
+// alertViolation fires kind (cosign_failed, opa_deny, quarantine) for module.
+func alertViolation(cfg Config, kind, module, detail string) {
+	if cfg.AlertSink == "" { return }
+	if module == "" { module = "unknown" }
+	key := "void:" + kind + ":" + module
+	alertMu.Lock()
+	if t, ok := alertLast[key]; ok && time.Since(t) < cfg.AlertDedup { alertMu.Unlock(); alertsTotal.WithLabelValues(kind, "deduped").Inc(); return }
+	alertLast[key] = time.Now()
+	alertMu.Unlock()
+	node, _ := os.Hostname()
+	summary := fmt.Sprintf("void policy violation: %s %s", kind, module)
+	var body map[string]any
+	hdr := http.Header{"Content-Type": {"application/json"}}
+	switch cfg.AlertSink {
+	case "pagerduty":
+		body = map[string]any{"routing_key": cfg.AlertKey, "event_action": "trigger", "dedup_key": key, "payload": map[string]any{
+			"summary": summary, "source": node, "severity": "critical", "component": module, "group": "void-wasm-exec", "class": kind,
+			"custom_details": map[string]any{"detail": detail},
+		}}
+	case "opsgenie":
+		hdr.Set("Authorization", "GenieKey "+cfg.AlertKey)
+		body = map[string]any{"message": summary, "alias": key, "description": detail, "priority": "P1", "source": node, "tags": []string{"void", kind}, "details": map[string]string{"module": module, "kind": kind}}
+	default:
+		if cfg.AlertKey != "" { hdr.Set("Authorization", "Bearer "+cfg.AlertKey) }
+		body = map[string]any{"type": "policy.violation", "kind": kind, "module": module, "detail": detail, "node": node, "ts": time.Now().UTC().Format(time.RFC3339)}
+	}
+	url := cfg.AlertURL
+	if url == "" { url = alertEndpoints[cfg.AlertSink] }
+	b, _ := json.Marshal(body)
+	fmt.Println("[alert]", summary, detail)
+	go func() {
+		var err error
+		for try := 0; try < 3; try++ {
+			if try > 0 { time.Sleep(time.Duration(try) * time.Second) }
+			if err = postAlert(url, hdr, b); err == nil { alertsTotal.WithLabelValues(kind, "sent").Inc(); return }
+		}
+		alertsTotal.WithLabelValues(kind, "error").Inc()
+		fmt.Println("[alert] delivery failed:", err)
+	}()
+}
+
+func postAlert(url string, hdr http.Header, body []byte) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil { return err }
+	req.Header = hdr.Clone()
+	resp, err := depClient.Do(req)
+	if err != nil { return err }
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode >= 300 { return fmt.Errorf("status %d: %s", resp.StatusCode, bytes.TrimSpace(msg)) }
+	return nil
+}
+
+// quarantineEvent reports whether a relay bus message is a quarantine
+// notice (type module.quarantine or *.quarantined) and for which module.
+func quarantineEvent(payload []byte) (module, detail string, ok bool) {
+	var ev struct {
+		Type   string         `json:"type"`
+		Module string         `json:"module"`
+		Reason string         `json:"reason"`
+		Meta   map[string]any `json:"meta"`
+	}
+	if json.Unmarshal(payload, &ev) != nil { return "", "", false }
+	if ev.Type != "module.quarantine" && !strings.HasSuffix(ev.Type, ".quarantined") { return "", "", false }
+	if ev.Module == "" { ev.Module, _ = ev.Meta["module"].(string) }
+	if ev.Reason == "" { ev.Reason, _ = ev.Meta["reason"].(string) }
+	return ev.Module, ev.Type + ": " + ev.Reason, true
+}
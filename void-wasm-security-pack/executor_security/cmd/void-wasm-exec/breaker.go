@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Circuit breaker: closed -> (N consecutive failures) -> open -> (cooldown) -> half-open
+// -> one probe -> closed on success / open again on failure.
+const (
+	breakerClosed   = 0
+	breakerHalfOpen = 1
+	breakerOpen     = 2
+)
+
+var errBreakerOpen = errors.New("circuit open")
+
+var (
+	breakerState    = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "void_wasm_breaker_state", Help: "Breaker state (0 closed, 1 half-open, 2 open)"}, []string{"target"})
+	breakerRejected = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_breaker_rejected_total", Help: "Calls short-circuited by an open breaker"}, []string{"target"})
+)
+
+type Breaker struct {
+	name     string
+	maxFails int
+	cooldown time.Duration
+
+	mu       sync.Mutex
+	state    int
+	fails    int
+	openedAt time.Time
+	probing  bool
+}
+
+func newBreaker(name string, maxFails int, cooldown time.Duration) *Breaker {
+	breakerState.WithLabelValues(name).Set(breakerClosed)
+	return &Breaker{name: name, maxFails: maxFails, cooldown: cooldown}
+}
+
+func (b *Breaker) setState(s int) { b.state = s; breakerState.WithLabelValues(b.name).Set(float64(s)) }
+
+// Do runs fn unless the breaker is open; in half-open only one probe passes.
+func (b *Breaker) Do(fn func() error) error {
+	b.mu.Lock()
+	if b.state == breakerOpen && time.Since(b.openedAt) >= b.cooldown { b.setState(breakerHalfOpen) }
+	if b.state == breakerOpen || (b.state == breakerHalfOpen && b.probing) {
+		b.mu.Unlock()
+		breakerRejected.WithLabelValues(b.name).Inc()
+		return errBreakerOpen
+	}
+	if b.state == breakerHalfOpen { b.probing = true }
+	b.mu.Unlock()
+
+	err := fn()
+
+	b.mu.Lock(); defer b.mu.Unlock()
+	b.probing = false
+	if err == nil {
+		b.fails = 0
+		if b.state != breakerClosed { b.setState(breakerClosed) }
+		return nil
+	}
+	b.fails++
+	if b.state == breakerHalfOpen || b.fails >= b.maxFails {
+		b.openedAt = time.Now()
+		b.setState(breakerOpen)
+	}
+	return err
+}
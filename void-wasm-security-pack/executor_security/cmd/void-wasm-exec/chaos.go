@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Fault injection (test only, CHAOS=1): OPA timeouts and cosign failures at
+// configured probabilities, to exercise breakers, alerts and rollout gates.
+
+var chaosInjected = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "void_wasm_chaos_injected_total", Help: "Faults injected by chaos mode"}, []string{"fault"})
+
+func chaosHit(cfg Config, fault string, p float64) bool {
+	if !cfg.Chaos || p <= 0 || rand.Float64() >= p { return false }
+	chaosInjected.WithLabelValues(fault).Inc()
+	fmt.Println("[chaos] inject", fault)
+	return true
+}
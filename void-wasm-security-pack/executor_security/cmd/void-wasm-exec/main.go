@@ -8,11 +8,9 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
-	"flag"
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -59,7 +57,25 @@ type Config struct {
 	OPABase      string
 	OPADecision  string
 
+	OPABundle         string // signed bundle OPA must have active; "" = any policy (bundle.go)
+	OPABundleRevision string // pinned revision; "" = whatever OPA activated
+	OPABundlePoll     time.Duration
+
 	DryRun bool
+
+	ReadyMinFreeMB uint64
+
+	BreakerFails    int
+	BreakerCooldown time.Duration
+
+	Chaos           bool // test only
+	ChaosOPATimeout float64
+	ChaosCosign     float64
+
+	AlertSink  string // pagerduty | opsgenie | webhook; "" = off (alert.go)
+	AlertURL   string
+	AlertKey   string
+	AlertDedup time.Duration
 }
 
 var (
@@ -75,11 +91,13 @@ var (
 )
 
 func mustRegister() {
-	reg.MustRegister(runsTotal, runMs, policyDenied, cosignTotal, opaTotal, stdoutEvents, sseReconnects, activeGauge)
+	reg.MustRegister(runsTotal, runMs, policyDenied, cosignTotal, opaTotal, stdoutEvents, sseReconnects, activeGauge, breakerState, breakerRejected, chaosInjected, alertsTotal, bundleInfo, bundleActive, bundleSwaps)
 }
 
 func getenv(key, def string) string { v := os.Getenv(key); if v == "" { return def }; return v }
 
+func prob(s string) float64 { var p float64; fmt.Sscanf(s, "%g", &p); return p }
+
 func loadConfig() Config {
 	atoi := func(s string, d int) int { var n int; if _,err:=fmt.Sscanf(s,"%d",&n); err!=nil { return d }; return n }
 	parse := func(s string) []string {
@@ -102,22 +120,47 @@ func loadConfig() Config {
 		CosignVerify: getenv("COSIGN_VERIFY", "0") == "1",
 		OPABase:      getenv("OPA_BASE", "http://opa-pdp:8181"),
 		OPADecision:  getenv("OPA_DECISION", "/v1/data/void/policy/allow"),
+		OPABundle:         getenv("OPA_BUNDLE", ""),
+		OPABundleRevision: getenv("OPA_BUNDLE_REVISION", ""),
+		OPABundlePoll:     time.Duration(atoi(getenv("OPA_BUNDLE_POLL_S", "10"), 10)) * time.Second,
 		DryRun:       getenv("WASM_DRYRUN", "0") == "1",
+		ReadyMinFreeMB: uint64(atoi(getenv("READY_MIN_FREE_MB", "100"), 100)),
+		BreakerFails:    atoi(getenv("BREAKER_FAILS", "5"), 5),
+		BreakerCooldown: time.Duration(atoi(getenv("BREAKER_COOLDOWN_MS", "10000"), 10000)) * time.Millisecond,
+		Chaos:           getenv("CHAOS", "0") == "1",
+		ChaosOPATimeout: prob(getenv("CHAOS_OPA_TIMEOUT", "0")),
+		ChaosCosign:     prob(getenv("CHAOS_COSIGN", "0")),
+		AlertSink:       getenv("ALERT_SINK", ""),
+		AlertURL:        getenv("ALERT_URL", ""),
+		AlertKey:        getenv("ALERT_KEY", ""),
+		AlertDedup:      time.Duration(atoi(getenv("ALERT_DEDUP_S", "300"), 300)) * time.Second,
 	}
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "sign" { os.Exit(signLocal(os.Args[2:])) }
 	mustRegister()
 	cfg := loadConfig()
+	relayBreaker = newBreaker("relay", cfg.BreakerFails, cfg.BreakerCooldown)
+	opaBreaker = newBreaker("opa", cfg.BreakerFails, cfg.BreakerCooldown)
+	if cfg.Chaos { fmt.Println("[chaos] fault injection ENABLED - test only") }
+	switch {
+	case cfg.AlertSink != "" && cfg.AlertSink != "pagerduty" && cfg.AlertSink != "opsgenie" && cfg.AlertSink != "webhook":
+		fmt.Println("[alert] ALERT_SINK must be pagerduty, opsgenie or webhook"); os.Exit(2)
+	case cfg.AlertSink == "webhook" && cfg.AlertURL == "", cfg.AlertSink != "" && cfg.AlertSink != "webhook" && cfg.AlertKey == "":
+		fmt.Println("[alert] ALERT_SINK", cfg.AlertSink, "needs ALERT_URL (webhook) or ALERT_KEY"); os.Exit(2)
+	}
 
 	go func() {
 		mux := http.NewServeMux()
 		mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
 		mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(200); w.Write([]byte("{\"ok\":true}")) })
+		mux.HandleFunc("/readyz", handleReady(cfg))
 		http.ListenAndServe(cfg.PromAddr, mux)
 	}()
 
 	os.MkdirAll(cfg.CacheDir, 0o755)
+	if cfg.OPABundle != "" && cfg.OPABase != "" { go watchBundle(cfg) }
 
 	sseURL := cfg.RelayBase + cfg.SSEPath
 	fmt.Println("[wasm] SSE connect", sseURL)
@@ -137,11 +180,12 @@ func sseLoop(cfg Config, sseURL string) error {
 	if resp.StatusCode != 200 { return fmt.Errorf("sse status %d", resp.StatusCode) }
 	rd := bufio.NewReader(resp.Body)
 	for {
-		line, err := rd.ReadString('\\n')
+		line, err := rd.ReadString('\n')
 		if err != nil { return err }
 		if !strings.HasPrefix(line, "data:") { continue }
 		payload := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
 		if payload == "" || payload == ":" { continue }
+		if module, detail, ok := quarantineEvent([]byte(payload)); ok { alertViolation(cfg, "quarantine", module, detail); continue }
 		var env Envelope
 		if json.Unmarshal([]byte(payload), &env) != nil { continue }
 		if env.Type != "signal.wasm" { continue }
@@ -182,7 +226,11 @@ func handleEnvelope(cfg Config, env *Envelope) {
 	// OPA
 	allowed, err := opaAllow(cfg, env, signer)
 	if err != nil {
-		opaTotal.WithLabelValues("error").Inc()
+		switch {
+		case errors.Is(err, errBreakerOpen): opaTotal.WithLabelValues("breaker_open").Inc()
+		case errors.Is(err, errBundleInactive): opaTotal.WithLabelValues("bundle_inactive").Inc()
+		default: opaTotal.WithLabelValues("error").Inc()
+		}
 		runsTotal.WithLabelValues("opa_error", moduleName).Inc()
 		return
 	}
@@ -190,6 +238,7 @@ func handleEnvelope(cfg Config, env *Envelope) {
 		policyDenied.Inc()
 		opaTotal.WithLabelValues("deny").Inc()
 		runsTotal.WithLabelValues("deny_policy", moduleName).Inc()
+		alertViolation(cfg, "opa_deny", moduleName, "OPA denied an allowlisted module (sha256 "+env.SHA256+", signer "+signer+")")
 		return
 	} else {
 		opaTotal.WithLabelValues("allow").Inc()
@@ -231,8 +280,10 @@ func fetchAndVerify(cfg Config, env *Envelope) (string, string, error) {
 	// cosign
 	if !cfg.CosignVerify { return path, "", nil }
 	signer, err := cosignVerify(env, path)
+	if err == nil && chaosHit(cfg, "cosign", cfg.ChaosCosign) { err = errors.New("chaos: injected cosign failure") }
 	if err != nil {
 		cosignTotal.WithLabelValues("verify_failed").Inc()
+		alertViolation(cfg, "cosign_failed", env.Module, err.Error())
 		return "", "", err
 	}
 	cosignTotal.WithLabelValues("verified").Inc()
@@ -291,8 +342,8 @@ func cosignVerify(env *Envelope, wasmPath string) (string, error) {
 		if _, err := os.Stat(base+".crt"); err == nil { crtPath = base + ".crt" }
 	}
 	args := []string{"verify-blob", "--output=json"}
-	if crtPath != "" { args += []string{"--certificate", crtPath} }
-	if sigPath != "" { args += []string{"--signature", sigPath} }
+	if crtPath != "" { args = append(args, "--certificate", crtPath) }
+	if sigPath != "" { args = append(args, "--signature", sigPath) }
 	args = append(args, wasmPath)
 	out, err := exec.Command("cosign", args...).CombinedOutput()
 	if err != nil { return "", fmt.Errorf("cosign failed: %v (%s)", err, string(out)) }
@@ -311,14 +362,27 @@ func opaAllow(cfg Config, env *Envelope, signer string) (bool, error) {
 	if signer != "" { input["signer"] = signer }
 	body, _ := json.Marshal(map[string]any{"input": input})
 	u := strings.TrimRight(cfg.OPABase, "/") + cfg.OPADecision
+	if cfg.OPABundle != "" { u += "?provenance=true" } // the revision that answered (bundle.go)
 	req, _ := http.NewRequest("POST", u, bytes.NewReader(body))
 	req.Header.Set("content-type", "application/json")
-	resp, err := http.DefaultClient.Do(req)
+	var out struct {
+		Result     bool          `json:"result"`
+		Provenance opaProvenance `json:"provenance"`
+	}
+	err := opaBreaker.Do(func() error {
+		if chaosHit(cfg, "opa_timeout", cfg.ChaosOPATimeout) {
+			time.Sleep(depClient.Timeout)
+			return errors.New("chaos: injected OPA timeout")
+		}
+		resp, err := depClient.Do(req)
+		if err != nil { return err }
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 { return fmt.Errorf("opa status %d", resp.StatusCode) }
+		if json.NewDecoder(resp.Body).Decode(&out) != nil { return errors.New("bad OPA response") }
+		return nil
+	})
 	if err != nil { return false, err }
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 { return false, fmt.Errorf("opa status %d", resp.StatusCode) }
-	var out struct { Result bool `json:"result"` }
-	if json.NewDecoder(resp.Body).Decode(&out) != nil { return false, errors.New("bad OPA response") }
+	if err := decisionBundle(cfg, out.Provenance); err != nil { return false, err }
 	return out.Result, nil
 }
 
@@ -348,10 +412,24 @@ func runWasm(ctx context.Context, cfg Config, path string, env *Envelope) error
 
 func mustRead(path string) []byte { b, err := os.ReadFile(path); if err != nil { panic(err) }; return b }
 
+// relay/OPA calls share short timeouts and sit behind breakers, so a flapping
+// dependency fails fast instead of stalling every run on dial timeouts
+var (
+	depClient    = &http.Client{Timeout: 2 * time.Second}
+	relayBreaker *Breaker
+	opaBreaker   *Breaker
+)
+
 func postEvent(cfg Config, ev map[string]any) {
 	url := cfg.RelayBase + cfg.EventPost
 	body, _ := json.Marshal(ev)
 	req, _ := http.NewRequest("POST", url, bytes.NewReader(body))
 	req.Header.Set("content-type", "application/json")
-	http.DefaultClient.Do(req)
+	relayBreaker.Do(func() error {
+		resp, err := depClient.Do(req)
+		if err != nil { return err }
+		resp.Body.Close()
+		if resp.StatusCode >= 500 { return fmt.Errorf("relay status %d", resp.StatusCode) }
+		return nil
+	})
 }
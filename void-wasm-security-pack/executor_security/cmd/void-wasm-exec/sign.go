@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// void-wasm-exec sign module.wasm: keyless cosign sign-blob, sha256, CID,
+// manifest and a ready-to-send envelope in one step. Writes ${wasm}.sig,
+// ${wasm}.crt and ${wasm}.manifest.json next to the module; the envelope goes
+// to stdout. The .sig/.crt pair is what cosignVerify looks for.
+
+type Manifest struct {
+	Module    string   `json:"module"`
+	File      string   `json:"file"`
+	Size      int      `json:"size"`
+	SHA256    string   `json:"sha256"`
+	CID       string   `json:"cid"`
+	Caps      []string `json:"caps,omitempty"`
+	Signature string   `json:"signature,omitempty"`
+	Cert      string   `json:"certificate,omitempty"`
+	SignedAt  string   `json:"signed_at,omitempty"`
+}
+
+// cidV1Raw is the CID ipfs gives the bytes as a single raw block (sha2-256, base32).
+func cidV1Raw(digest []byte) string {
+	b := append([]byte{0x01, 0x55, 0x12, 0x20}, digest...)
+	return "b" + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+}
+
+func signLocal(args []string) int {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	module := fs.String("module", "", "module name for the envelope (default: file name without .wasm)")
+	baseURL := fs.String("base-url", "", "where the files will be published, e.g. https://cdn/wasm/ (default: file:// next to the module)")
+	caps := fs.String("caps", "emit", "comma-separated caps for the envelope")
+	noSign := fs.Bool("no-sign", false, "skip cosign (hashes, manifest and envelope only)")
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, "usage: void-wasm-exec sign [flags] module.wasm"); fs.PrintDefaults() }
+	fs.Parse(args)
+	if fs.NArg() != 1 { fs.Usage(); return 2 }
+	path, err := filepath.Abs(fs.Arg(0))
+	if err != nil { fmt.Fprintln(os.Stderr, err); return 2 }
+	b, err := os.ReadFile(path)
+	if err != nil { fmt.Fprintln(os.Stderr, err); return 1 }
+
+	sum := sha256.Sum256(b)
+	m := Manifest{Module: *module, File: filepath.Base(path), Size: len(b), SHA256: hex.EncodeToString(sum[:]), CID: cidV1Raw(sum[:])}
+	if m.Module == "" { m.Module = strings.TrimSuffix(m.File, ".wasm") }
+	for _, c := range strings.Split(*caps, ",") { if c = strings.TrimSpace(c); c != "" { m.Caps = append(m.Caps, c) } }
+
+	if !*noSign {
+		// keyless: cosign runs the OIDC flow and gets a Fulcio certificate
+		out, err := exec.Command("cosign", "sign-blob", "--yes", "--output-signature", path+".sig", "--output-certificate", path+".crt", path).CombinedOutput()
+		if err != nil { fmt.Fprintf(os.Stderr, "cosign failed: %v (%s)\n", err, strings.TrimSpace(string(out))); return 1 }
+		m.Signature, m.Cert, m.SignedAt = m.File+".sig", m.File+".crt", time.Now().UTC().Format(time.RFC3339)
+	}
+	mb, _ := json.MarshalIndent(m, "", "  ")
+	if err := os.WriteFile(path+".manifest.json", append(mb, '\n'), 0o644); err != nil { fmt.Fprintln(os.Stderr, err); return 1 }
+
+	base := *baseURL
+	if base == "" { base = "file://" + filepath.Dir(path) }
+	at := func(name string) string { return strings.TrimSuffix(base, "/") + "/" + name }
+	env := Envelope{Type: "signal.wasm", Module: m.Module, URL: at(m.File), SHA256: m.SHA256, CID: m.CID, Caps: m.Caps, Inputs: map[string]any{}}
+	if !*noSign { env.SigURL, env.CertURL = at(m.Signature), at(m.Cert) }
+	eb, _ := json.MarshalIndent(env, "", "  ")
+	fmt.Fprintf(os.Stderr, "[sign] sha256 %s cid %s manifest %s.manifest.json\n", m.SHA256, m.CID, path)
+	fmt.Println(string(eb))
+	return 0
+}
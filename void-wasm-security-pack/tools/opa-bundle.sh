@@ -0,0 +1,45 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+# Build and sign the OPA policy bundle served to compose.opa-bundles.yml.
+# Usage:
+#   tools/opa-bundle.sh keygen                # bundle-key.pem + bundle-pub.pem (ES256)
+#   tools/opa-bundle.sh build <revision>      # opa/bundles/void.tar.gz signed with OPA_BUNDLE_KEY
+#   tools/opa-bundle.sh verify                # check the signature with OPA_BUNDLE_PUBKEY_FILE
+# The revision is what void_wasm_opa_bundle_info reports and what
+# OPA_BUNDLE_REVISION pins on the executor.
+
+MODE=${1:-}
+DIR=$(cd "$(dirname "$0")/.." && pwd)
+KEY=${OPA_BUNDLE_KEY:-bundle-key.pem}
+PUB=${OPA_BUNDLE_PUBKEY_FILE:-bundle-pub.pem}
+OUT="$DIR/opa/bundles/void.tar.gz"
+
+case "$MODE" in
+  keygen)
+    openssl ecparam -name prime256v1 -genkey -noout -out "$KEY"
+    openssl ec -in "$KEY" -pubout -out "$PUB"
+    echo "wrote $KEY (keep secret) and $PUB (OPA_BUNDLE_PUBKEY_FILE)"
+    ;;
+  build)
+    REV=${2:?usage: opa-bundle.sh build <revision>}
+    mkdir -p "$(dirname "$OUT")"
+    TMP="$OUT.tmp"
+    CLAIMS=$(mktemp); trap 'rm -f "$CLAIMS"' EXIT
+    echo '{"keyid": "void-bundles", "scope": "write"}' > "$CLAIMS" # what opa/config.yaml expects
+    opa build --bundle "$DIR/opa/policies" --revision "$REV" \
+      --signing-alg ES256 --signing-key "$KEY" --claims-file "$CLAIMS" -o "$TMP"
+    mv "$TMP" "$OUT" # the server never hands out a half-written bundle
+    echo "built $OUT revision $REV"
+    ;;
+  verify)
+    T=$(mktemp -d); trap 'rm -rf "$T"' EXIT
+    tar -xzf "$OUT" -C "$T"
+    opa build --bundle "$T" --signing-alg ES256 \
+      --verification-key "$PUB" --verification-key-id void-bundles --scope write -o /dev/null
+    echo "signature ok"
+    ;;
+  *)
+    sed -n '4,10p' "$0"; exit 2
+    ;;
+esac